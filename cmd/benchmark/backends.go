@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/1F47E/geo-index-rtree/pkg/backend"
+	"github.com/1F47E/geo-index-rtree/pkg/config"
+	"github.com/1F47E/geo-index-rtree/pkg/report"
+)
+
+// runBackends runs the same workload against each named backend in turn and
+// returns one result per backend that could be loaded. Every backend answers
+// queries drawn from opts.seed, so they see identical queries when run with a
+// fixed query count. In-memory backends load indexFile; PostGIS, SQLite and
+// Redis connect with the config file's postgis, sqlite and redis sections.
+func runBackends(names []string, configFile, indexFile string, opts runOptions,
+	run func(backend.SpatialIndex, runOptions) BenchmarkResult,
+	benchConfig func(points int64) BenchmarkConfig) []BenchmarkResult {
+
+	for i, name := range names {
+		names[i] = strings.ToLower(strings.TrimSpace(name))
+		if backend.IsDatabase(names[i]) {
+			continue
+		}
+		if _, err := backend.StorageOption(names[i]); err != nil {
+			log.Fatalf("Invalid -backends: %v", err)
+		}
+	}
+
+	c, err := config.Load(configFile)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	var results []BenchmarkResult
+	for _, name := range names {
+		log.Printf("Loading %s backend...\n", name)
+		index, loadTime, err := loadBackend(c, name, indexFile)
+		if err != nil {
+			log.Printf("Skipping %s backend: %v\n", name, err)
+			continue
+		}
+		log.Printf("%s backend ready with %d points in %v\n", name, index.Count(), loadTime)
+
+		log.Printf("Running %s queries against %s (seed %d)...\n", opts.queryDescription(), name, opts.seed)
+		opts.backend = name
+		result := run(index, opts)
+		result.Config = benchConfig(index.Count())
+		result.Config.Backend = name
+		result.Config.LoadDuration = loadTime
+		results = append(results, result)
+
+		if err := backend.Close(index); err != nil {
+			log.Printf("Failed to close %s backend: %v\n", name, err)
+		}
+		// Free the index before loading the next one so they don't compete for memory
+		runtime.GC()
+	}
+
+	if len(results) == 0 {
+		log.Fatalf("No backend could be benchmarked")
+	}
+	return results
+}
+
+// loadBackend opens the named backend and fills it from indexFile. A database
+// that already holds points is used as it is, since reloading millions of
+// rows would dominate the run; an empty one is filled from the file.
+func loadBackend(c *config.Config, name, indexFile string) (backend.SpatialIndex, time.Duration, error) {
+	bc := *c
+	bc.Index.Backend = name
+	index, err := backend.Open(&bc)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if backend.IsDatabase(name) {
+		count := index.Count()
+		if err := backend.Err(index); err != nil {
+			backend.Close(index)
+			return nil, 0, err
+		}
+		if count > 0 {
+			log.Printf("Using the %d points already in %s\n", count, name)
+			return index, 0, nil
+		}
+	}
+
+	start := time.Now()
+	if err := index.LoadFromFile(indexFile); err != nil {
+		backend.Close(index)
+		return nil, 0, fmt.Errorf("failed to load %s: %w", indexFile, err)
+	}
+	return index, time.Since(start), nil
+}
+
+// printBackends prints the results side by side, one column per backend, with
+// throughput relative to the first backend
+func printBackends(results []BenchmarkResult) {
+	first := results[0]
+	for _, r := range results[1:] {
+		if r.Config.IndexPoints != first.Config.IndexPoints {
+			fmt.Printf("warning: %s holds %d points, %s holds %d\n",
+				r.Config.Backend, r.Config.IndexPoints, first.Config.Backend, first.Config.IndexPoints)
+		}
+	}
+
+	rows := []struct {
+		name  string
+		value func(r BenchmarkResult) string
+	}{
+		{"Points", func(r BenchmarkResult) string { return fmt.Sprint(r.Config.IndexPoints) }},
+		{"Load time", func(r BenchmarkResult) string {
+			if r.Config.LoadDuration == 0 {
+				return "-"
+			}
+			return r.Config.LoadDuration.Round(time.Millisecond).String()
+		}},
+		{"Queries", func(r BenchmarkResult) string { return fmt.Sprint(r.TotalQueries) }},
+		{"Queries/sec", func(r BenchmarkResult) string { return fmt.Sprintf("%.0f", r.QueriesPerSec) }},
+		{"vs " + first.Config.Backend, func(r BenchmarkResult) string {
+			if first.QueriesPerSec == 0 {
+				return "-"
+			}
+			return fmt.Sprintf("%.2fx", r.QueriesPerSec/first.QueriesPerSec)
+		}},
+		{"Avg", func(r BenchmarkResult) string { return r.AvgDuration.String() }},
+		{"P50", func(r BenchmarkResult) string { return r.P50Duration.String() }},
+		{"P90", func(r BenchmarkResult) string { return r.P90Duration.String() }},
+		{"P99", func(r BenchmarkResult) string { return r.P99Duration.String() }},
+		{"P99.9", func(r BenchmarkResult) string { return r.P999Duration.String() }},
+		{"Max", func(r BenchmarkResult) string { return r.MaxDuration.String() }},
+		{"Avg results", func(r BenchmarkResult) string { return fmt.Sprintf("%.2f", r.AvgResults) }},
+		{"Heap peak", func(r BenchmarkResult) string { return report.Format(float64(r.Memory.HeapInusePeak), report.Bytes) }},
+		{"GC cycles", func(r BenchmarkResult) string { return fmt.Sprint(r.Memory.GCCount) }},
+		{"GC pause", func(r BenchmarkResult) string { return r.Memory.GCPauseTotal.String() }},
+		{"Allocs/query", func(r BenchmarkResult) string { return fmt.Sprintf("%.1f", r.Memory.AllocsPerQuery) }},
+		{"Bytes/query", func(r BenchmarkResult) string { return fmt.Sprintf("%.0f", r.Memory.BytesPerQuery) }},
+	}
+
+	fmt.Printf("\n=== Backend Comparison (%s, %d workers, seed %d) ===\n",
+		first.QueryType, first.Config.Workers, first.Config.Seed)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprint(w, "Metric\t")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t", r.Config.Backend)
+	}
+	fmt.Fprintln(w)
+	for _, row := range rows {
+		fmt.Fprintf(w, "%s\t", row.name)
+		for _, r := range results {
+			fmt.Fprintf(w, "%s\t", row.value(r))
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+}
+
+// writeResults saves backend results as a JSON array, or as CSV with one row
+// per backend
+func writeResults(results []BenchmarkResult, format, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	defer file.Close()
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(file)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			return fmt.Errorf("failed to encode results: %w", err)
+		}
+	case "csv":
+		w := csv.NewWriter(file)
+		for i, result := range results {
+			header, row := resultRecord(result)
+			if i == 0 {
+				w.Write(header)
+			}
+			w.Write(row)
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return fmt.Errorf("failed to write csv: %w", err)
+		}
+	default:
+		return fmt.Errorf("unknown output format %q", format)
+	}
+
+	return file.Close()
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// metric is one compared value of a benchmark result
+type metric struct {
+	name         string
+	old, new     float64
+	higherBetter bool
+	format       func(float64) string
+}
+
+// runCompare implements "benchmark compare old.json new.json" and returns the
+// process exit code: 1 when any metric regressed beyond the threshold
+func runCompare(args []string) int {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	threshold := fs.Float64("threshold", 10, "Regression threshold in percent")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: benchmark compare [-threshold pct] old.json new.json")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	before, err := readResult(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	after, err := readResult(fs.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	for _, warning := range configDifferences(before, after) {
+		fmt.Printf("warning: %s\n", warning)
+	}
+
+	dur := func(v float64) string { return time.Duration(v).String() }
+	qps := func(v float64) string { return fmt.Sprintf("%.0f", v) }
+	perQuery := func(v float64) string { return fmt.Sprintf("%.1f", v) }
+	metrics := []metric{
+		{"Queries/sec", before.QueriesPerSec, after.QueriesPerSec, true, qps},
+		{"Avg", float64(before.AvgDuration), float64(after.AvgDuration), false, dur},
+		{"P50", float64(before.P50Duration), float64(after.P50Duration), false, dur},
+		{"P90", float64(before.P90Duration), float64(after.P90Duration), false, dur},
+		{"P95", float64(before.P95Duration), float64(after.P95Duration), false, dur},
+		{"P99", float64(before.P99Duration), float64(after.P99Duration), false, dur},
+		{"P99.9", float64(before.P999Duration), float64(after.P999Duration), false, dur},
+		{"Max", float64(before.MaxDuration), float64(after.MaxDuration), false, dur},
+		{"Allocs/query", before.Memory.AllocsPerQuery, after.Memory.AllocsPerQuery, false, perQuery},
+		{"Bytes/query", before.Memory.BytesPerQuery, after.Memory.BytesPerQuery, false, perQuery},
+	}
+
+	fmt.Printf("\n=== Benchmark Comparison (%s, threshold %.1f%%) ===\n", after.QueryType, *threshold)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Metric\tOld\tNew\tChange\t")
+
+	regressions := 0
+	for _, m := range metrics {
+		change := 0.0
+		if m.old != 0 {
+			change = (m.new - m.old) / m.old * 100
+		}
+
+		worse := change
+		if m.higherBetter {
+			worse = -change
+		}
+		status := ""
+		switch {
+		case worse > *threshold:
+			status = "REGRESSION"
+			regressions++
+		case worse < -*threshold:
+			status = "improved"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%+.1f%%\t%s\n", m.name, m.format(m.old), m.format(m.new), change, status)
+	}
+	w.Flush()
+
+	if regressions > 0 {
+		fmt.Printf("\n%d metric(s) regressed by more than %.1f%%\n", regressions, *threshold)
+		return 1
+	}
+	fmt.Println("\nNo regressions")
+	return 0
+}
+
+func readResult(path string) (BenchmarkResult, error) {
+	var result BenchmarkResult
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return result, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return result, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return result, nil
+}
+
+// configDifferences lists settings that make two results not directly comparable
+func configDifferences(a, b BenchmarkResult) []string {
+	var diffs []string
+	if a.QueryType != b.QueryType {
+		diffs = append(diffs, fmt.Sprintf("query type differs (%s vs %s)", a.QueryType, b.QueryType))
+	}
+	if a.Config.Backend != b.Config.Backend {
+		diffs = append(diffs, fmt.Sprintf("backends differ (%s vs %s)", a.Config.Backend, b.Config.Backend))
+	}
+	if a.Config.Workers != b.Config.Workers {
+		diffs = append(diffs, fmt.Sprintf("workers differ (%d vs %d)", a.Config.Workers, b.Config.Workers))
+	}
+	if a.Config.IndexPoints != b.Config.IndexPoints {
+		diffs = append(diffs, fmt.Sprintf("index sizes differ (%d vs %d points)", a.Config.IndexPoints, b.Config.IndexPoints))
+	}
+	if a.Config.CPUCores != b.Config.CPUCores {
+		diffs = append(diffs, fmt.Sprintf("CPU core counts differ (%d vs %d)", a.Config.CPUCores, b.Config.CPUCores))
+	}
+	return diffs
+}
@@ -1,37 +1,87 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"math/rand"
+	"os"
 	"runtime"
-	"sync"
-	"sync/atomic"
+	"strings"
 	"time"
 
+	"github.com/1F47E/geo-index-rtree/pkg/backend"
+	"github.com/1F47E/geo-index-rtree/pkg/latency"
 	"github.com/1F47E/geo-index-rtree/pkg/models"
 	"github.com/1F47E/geo-index-rtree/pkg/rtree"
 )
 
 type BenchmarkResult struct {
-	QueryType      string
-	TotalQueries   int
-	TotalDuration  time.Duration
-	AvgDuration    time.Duration
-	QueriesPerSec  float64
-	MinDuration    time.Duration
-	MaxDuration    time.Duration
-	TotalResults   int64
-	AvgResults     float64
+	QueryType      string          `json:"query_type"`
+	TotalQueries   int             `json:"total_queries"`
+	TotalDuration  time.Duration   `json:"total_duration_ns"`
+	AvgDuration    time.Duration   `json:"avg_duration_ns"`
+	QueriesPerSec  float64         `json:"queries_per_sec"`
+	MinDuration    time.Duration   `json:"min_duration_ns"`
+	MaxDuration    time.Duration   `json:"max_duration_ns"`
+	TotalResults   int64           `json:"total_results"`
+	AvgResults     float64         `json:"avg_results"`
+	P50Duration    time.Duration   `json:"p50_duration_ns"`
+	P90Duration    time.Duration   `json:"p90_duration_ns"`
+	P95Duration    time.Duration   `json:"p95_duration_ns"`
+	P99Duration    time.Duration   `json:"p99_duration_ns"`
+	P999Duration   time.Duration   `json:"p999_duration_ns"`
+	Memory         MemoryStats     `json:"memory"`
+	Config         BenchmarkConfig `json:"config"`
+
+	// hist holds the raw latency distribution so results can be merged
+	hist *latency.Histogram
+}
+
+// BenchmarkConfig records the parameters and environment of a run so saved
+// results can be compared like for like
+type BenchmarkConfig struct {
+	Timestamp   time.Time `json:"timestamp"`
+	IndexFile   string    `json:"index_file"`
+	IndexPoints int64     `json:"index_points"`
+	Workers     int       `json:"workers"`
+	Duration    string    `json:"duration,omitempty"`
+	Warmup      string    `json:"warmup,omitempty"`
+	Cooldown    string    `json:"cooldown,omitempty"`
+	CPUCores    int       `json:"cpu_cores"`
+	GoVersion   string    `json:"go_version"`
+	MinLat      float64   `json:"min_lat"`
+	MaxLat      float64   `json:"max_lat"`
+	MinLon      float64   `json:"min_lon"`
+	MaxLon      float64   `json:"max_lon"`
+	BoxSize     float64   `json:"box_size"`
+	RadiusKm    float64   `json:"radius_km"`
+	K           int       `json:"k"`
+	Backend     string    `json:"backend,omitempty"`
+	Seed        int64     `json:"seed,omitempty"`
+	// LoadDuration is how long the backend took to load the index file, or
+	// to build the index in a dataset size sweep
+	LoadDuration time.Duration `json:"load_duration_ns,omitempty"`
+	// IndexHeapBytes is the heap a dataset size sweep's index added
+	IndexHeapBytes int64 `json:"index_heap_bytes,omitempty"`
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "compare" {
+		os.Exit(runCompare(os.Args[2:]))
+	}
+
 	var (
 		indexFile = flag.String("i", "data/index.gob", "Index file path")
 		queryType = flag.String("t", "box", "Query type: box, radius, nearest, mixed")
 		numQueries = flag.Int("n", 1000, "Number of queries to run")
 		workers = flag.Int("w", runtime.NumCPU(), "Number of concurrent workers")
+		// Time-based runs
+		duration = flag.Duration("duration", 0, "Run for this long instead of a fixed query count (e.g. 60s)")
+		warmup = flag.Duration("warmup", 0, "Run unmeasured queries for this long first (e.g. 10s)")
+		cooldown = flag.Duration("cooldown", 0, "Pause after a forced GC between warmup and measurement")
+		timelineFile = flag.String("timeline", "", "Write a per-second timeline (QPS, latency, GC pauses) to this CSV file")
 		// Geographic bounds for random queries (default: roughly USA)
 		minLat = flag.Float64("min-lat", 25.0, "Minimum latitude for random queries")
 		maxLat = flag.Float64("max-lat", 49.0, "Maximum latitude for random queries")
@@ -41,9 +91,157 @@ func main() {
 		boxSize = flag.Float64("box-size", 1.0, "Box size in degrees (for box queries)")
 		radius = flag.Float64("radius", 50.0, "Radius in km (for radius queries)")
 		k = flag.Int("k", 100, "Number of nearest neighbors")
+		// Machine-readable output
+		output = flag.String("output", "", "Also write the result to a file: json or csv")
+		outputFile = flag.String("output-file", "", "Result file path (default: benchmark_<type>.<format>, benchmark_backends_<type>.<format> with -backends or benchmark_sweep_<type>.<format> with -sweep-workers or benchmark_sizes_<type>.<format> with -sweep-points)")
+		reportFile = flag.String("report", "", "Also write a shareable report with tables and charts to this file: .html or .md")
+		// Backend comparison
+		backends = flag.String("backends", "", "Run the same workload against each of these comma-separated backends (e.g. rtree,geohash,postgis,sqlite,redis) and compare them")
+		configFile = flag.String("config", "", "Config file with the PostGIS, SQLite and Redis settings for -backends (default $GEOINDEX_CONFIG or ./config.yaml if present)")
+		sweepWorkers = flag.String("sweep-workers", "", "Repeat the workload at each of these comma-separated worker counts (e.g. 1,2,4,8,16) and report how throughput and latency scale")
+		sweepPoints = flag.String("sweep-points", "", "Instead of loading -i, build indexes of each of these comma-separated sizes (e.g. 1e5,1e6,1e7) from points generated in the query area and report build time, memory and query latency per size")
+		seed = flag.Int64("seed", 0, "Seed for the random queries (0 picks one, recorded in the results and manifest; -backends reuses it for every backend)")
 	)
 	flag.Parse()
 
+	if *output != "" && *output != "json" && *output != "csv" {
+		log.Fatalf("Unknown output format: %s", *output)
+	}
+	modes := 0
+	for _, mode := range []string{*backends, *sweepWorkers, *sweepPoints} {
+		if mode != "" {
+			modes++
+		}
+	}
+	if modes > 1 {
+		log.Fatalf("Only one of -backends, -sweep-workers and -sweep-points can be used")
+	}
+	var sweepLevels, sweepSizes []int
+	if *sweepWorkers != "" {
+		var err error
+		if sweepLevels, err = parseWorkers(*sweepWorkers); err != nil {
+			log.Fatalf("Invalid -sweep-workers: %v", err)
+		}
+	}
+	if *sweepPoints != "" {
+		var err error
+		if sweepSizes, err = parseSizes(*sweepPoints); err != nil {
+			log.Fatalf("Invalid -sweep-points: %v", err)
+		}
+	}
+
+	// Run benchmark
+	opts := runOptions{
+		queries:  *numQueries,
+		duration: *duration,
+		warmup:   *warmup,
+		cooldown: *cooldown,
+		workers:  *workers,
+		seed:     *seed,
+	}
+	if opts.seed == 0 {
+		opts.seed = time.Now().UnixNano()
+	}
+	if *timelineFile != "" {
+		opts.timeline = newTimeline(time.Second)
+	}
+
+	run := func(index backend.SpatialIndex, opts runOptions) BenchmarkResult {
+		switch *queryType {
+		case "box":
+			return benchmarkBoxQueries(index, opts,
+				*minLat, *maxLat, *minLon, *maxLon, *boxSize)
+		case "radius":
+			return benchmarkRadiusQueries(index, opts,
+				*minLat, *maxLat, *minLon, *maxLon, *radius)
+		case "nearest":
+			return benchmarkNearestQueries(index, opts,
+				*minLat, *maxLat, *minLon, *maxLon, *k)
+		default:
+			return benchmarkMixedQueries(index, opts,
+				*minLat, *maxLat, *minLon, *maxLon, *boxSize, *radius, *k)
+		}
+	}
+	switch *queryType {
+	case "box", "radius", "nearest", "mixed":
+	default:
+		log.Fatalf("Unknown query type: %s", *queryType)
+	}
+
+	benchConfig := func(points int64) BenchmarkConfig {
+		return BenchmarkConfig{
+			Timestamp:   time.Now().UTC(),
+			IndexFile:   *indexFile,
+			IndexPoints: points,
+			Workers:     *workers,
+			Duration:    durationString(*duration),
+			Warmup:      durationString(*warmup),
+			Cooldown:    durationString(*cooldown),
+			CPUCores:    runtime.NumCPU(),
+			GoVersion:   runtime.Version(),
+			MinLat:      *minLat,
+			MaxLat:      *maxLat,
+			MinLon:      *minLon,
+			MaxLon:      *maxLon,
+			BoxSize:     *boxSize,
+			RadiusKm:    *radius,
+			K:           *k,
+			Seed:        opts.seed,
+		}
+	}
+
+	if *backends != "" {
+		results := runBackends(strings.Split(*backends, ","), *configFile, *indexFile, opts, run, benchConfig)
+		printBackends(results)
+		if opts.timeline != nil {
+			if err := opts.timeline.writeCSV(*timelineFile); err != nil {
+				log.Fatalf("Failed to write timeline: %v", err)
+			}
+			fmt.Printf("Timeline written to %s\n", *timelineFile)
+		}
+		if *output != "" {
+			path := *outputFile
+			if path == "" {
+				path = fmt.Sprintf("benchmark_backends_%s.%s", *queryType, *output)
+			}
+			if err := writeResults(results, *output, path); err != nil {
+				log.Fatalf("Failed to write results: %v", err)
+			}
+			fmt.Printf("Results written to %s\n", path)
+			writeManifest(path, opts.seed)
+		}
+		if *reportFile != "" {
+			writeReport(newReport(results), *reportFile)
+		}
+		return
+	}
+
+	if sweepSizes != nil {
+		results := runSizeSweep(sweepSizes, opts, run, benchConfig)
+		printSizeSweep(results)
+		if opts.timeline != nil {
+			if err := opts.timeline.writeCSV(*timelineFile); err != nil {
+				log.Fatalf("Failed to write timeline: %v", err)
+			}
+			fmt.Printf("Timeline written to %s\n", *timelineFile)
+		}
+		if *output != "" {
+			path := *outputFile
+			if path == "" {
+				path = fmt.Sprintf("benchmark_sizes_%s.%s", *queryType, *output)
+			}
+			if err := writeResults(results, *output, path); err != nil {
+				log.Fatalf("Failed to write results: %v", err)
+			}
+			fmt.Printf("Results written to %s\n", path)
+			writeManifest(path, opts.seed)
+		}
+		if *reportFile != "" {
+			writeReport(newSizeSweepReport(results), *reportFile)
+		}
+		return
+	}
+
 	// Load index
 	log.Printf("Loading index from %s...\n", *indexFile)
 	index := rtree.NewGeoIndex()
@@ -52,27 +250,40 @@ func main() {
 	}
 	log.Printf("Index loaded with %d points\n", index.Count())
 
-	// Run benchmark
-	log.Printf("Running %d %s queries with %d workers...\n", *numQueries, *queryType, *workers)
-	
-	var result BenchmarkResult
-	switch *queryType {
-	case "box":
-		result = benchmarkBoxQueries(index, *numQueries, *workers, 
-			*minLat, *maxLat, *minLon, *maxLon, *boxSize)
-	case "radius":
-		result = benchmarkRadiusQueries(index, *numQueries, *workers,
-			*minLat, *maxLat, *minLon, *maxLon, *radius)
-	case "nearest":
-		result = benchmarkNearestQueries(index, *numQueries, *workers,
-			*minLat, *maxLat, *minLon, *maxLon, *k)
-	case "mixed":
-		result = benchmarkMixedQueries(index, *numQueries, *workers,
-			*minLat, *maxLat, *minLon, *maxLon, *boxSize, *radius, *k)
-	default:
-		log.Fatalf("Unknown query type: %s", *queryType)
+	if sweepLevels != nil {
+		results := runSweep(index, sweepLevels, opts, run, benchConfig)
+		printSweep(results)
+		if opts.timeline != nil {
+			if err := opts.timeline.writeCSV(*timelineFile); err != nil {
+				log.Fatalf("Failed to write timeline: %v", err)
+			}
+			fmt.Printf("Timeline written to %s\n", *timelineFile)
+		}
+		if *output != "" {
+			path := *outputFile
+			if path == "" {
+				path = fmt.Sprintf("benchmark_sweep_%s.%s", *queryType, *output)
+			}
+			if err := writeResults(results, *output, path); err != nil {
+				log.Fatalf("Failed to write results: %v", err)
+			}
+			fmt.Printf("Results written to %s\n", path)
+			writeManifest(path, opts.seed)
+		}
+		if *reportFile != "" {
+			writeReport(newSweepReport(results), *reportFile)
+		}
+		return
 	}
 
+	if opts.duration > 0 {
+		log.Printf("Running %s queries for %v with %d workers (seed %d)...\n", *queryType, opts.duration, *workers, opts.seed)
+	} else {
+		log.Printf("Running %d %s queries with %d workers (seed %d)...\n", *numQueries, *queryType, *workers, opts.seed)
+	}
+	
+	result := run(index, opts)
+
 	// Print results
 	fmt.Println("\n=== Benchmark Results ===")
 	fmt.Printf("Query Type: %s\n", result.QueryType)
@@ -82,260 +293,114 @@ func main() {
 	fmt.Printf("Queries/Second: %.2f\n", result.QueriesPerSec)
 	fmt.Printf("Min Duration: %v\n", result.MinDuration)
 	fmt.Printf("Max Duration: %v\n", result.MaxDuration)
+	fmt.Printf("P50 Duration: %v\n", result.P50Duration)
+	fmt.Printf("P90 Duration: %v\n", result.P90Duration)
+	fmt.Printf("P95 Duration: %v\n", result.P95Duration)
+	fmt.Printf("P99 Duration: %v\n", result.P99Duration)
+	fmt.Printf("P99.9 Duration: %v\n", result.P999Duration)
 	fmt.Printf("Total Results: %d\n", result.TotalResults)
 	fmt.Printf("Avg Results/Query: %.2f\n", result.AvgResults)
+	printMemory(result.Memory)
 	fmt.Printf("Workers Used: %d\n", *workers)
 	fmt.Printf("CPU Cores: %d\n", runtime.NumCPU())
-}
 
-func benchmarkBoxQueries(index *rtree.GeoIndex, numQueries, workers int,
-	minLat, maxLat, minLon, maxLon, boxSize float64) BenchmarkResult {
-	
-	var (
-		totalResults int64
-		minDuration = time.Hour
-		maxDuration time.Duration
-		durations []time.Duration
-		mu sync.Mutex
-	)
-
-	startTime := time.Now()
-	
-	// Worker pool
-	queryCh := make(chan int, numQueries)
-	var wg sync.WaitGroup
-	
-	wg.Add(workers)
-	for w := 0; w < workers; w++ {
-		go func() {
-			defer wg.Done()
-			r := rand.New(rand.NewSource(rand.Int63()))
-			
-			for range queryCh {
-				// Generate random box
-				lat := minLat + r.Float64()*(maxLat-minLat-boxSize)
-				lon := minLon + r.Float64()*(maxLon-minLon-boxSize)
-				
-				box := models.BoundingBox{
-					BottomLeft: models.Location{Lat: lat, Lon: lon},
-					TopRight:   models.Location{Lat: lat + boxSize, Lon: lon + boxSize},
-				}
-				
-				queryStart := time.Now()
-				results, err := index.QueryBox(box)
-				queryDuration := time.Since(queryStart)
-				
-				if err == nil {
-					atomic.AddInt64(&totalResults, int64(len(results)))
-					
-					mu.Lock()
-					durations = append(durations, queryDuration)
-					if queryDuration < minDuration {
-						minDuration = queryDuration
-					}
-					if queryDuration > maxDuration {
-						maxDuration = queryDuration
-					}
-					mu.Unlock()
-				}
-			}
-		}()
-	}
-	
-	// Send queries
-	for i := 0; i < numQueries; i++ {
-		queryCh <- i
+	if opts.timeline != nil {
+		if err := opts.timeline.writeCSV(*timelineFile); err != nil {
+			log.Fatalf("Failed to write timeline: %v", err)
+		}
+		fmt.Printf("Timeline written to %s\n", *timelineFile)
 	}
-	close(queryCh)
-	
-	wg.Wait()
-	totalDuration := time.Since(startTime)
-	
-	// Calculate average duration
-	var totalDur time.Duration
-	for _, d := range durations {
-		totalDur += d
+
+	result.Config = benchConfig(index.Count())
+	if *reportFile != "" {
+		writeReport(newReport([]BenchmarkResult{result}), *reportFile)
 	}
-	avgDuration := totalDur / time.Duration(len(durations))
-	
-	return BenchmarkResult{
-		QueryType:     "box",
-		TotalQueries:  numQueries,
-		TotalDuration: totalDuration,
-		AvgDuration:   avgDuration,
-		QueriesPerSec: float64(numQueries) / totalDuration.Seconds(),
-		MinDuration:   minDuration,
-		MaxDuration:   maxDuration,
-		TotalResults:  totalResults,
-		AvgResults:    float64(totalResults) / float64(numQueries),
+
+	if *output != "" {
+		path := *outputFile
+		if path == "" {
+			path = fmt.Sprintf("benchmark_%s.%s", result.QueryType, *output)
+		}
+		if err := writeResult(result, *output, path); err != nil {
+			log.Fatalf("Failed to write result: %v", err)
+		}
+		fmt.Printf("Result written to %s\n", path)
+		writeManifest(path, opts.seed)
 	}
 }
 
-func benchmarkRadiusQueries(index *rtree.GeoIndex, numQueries, workers int,
-	minLat, maxLat, minLon, maxLon, radius float64) BenchmarkResult {
+func benchmarkBoxQueries(index backend.SpatialIndex, opts runOptions,
+	minLat, maxLat, minLon, maxLon, boxSize float64) BenchmarkResult {
 	
-	var (
-		totalResults int64
-		minDuration = time.Hour
-		maxDuration time.Duration
-		durations []time.Duration
-		mu sync.Mutex
-	)
+	return runBenchmark("box", opts, func(r *rand.Rand) (int, error) {
+		// Generate random box
+		lat := minLat + r.Float64()*(maxLat-minLat-boxSize)
+		lon := minLon + r.Float64()*(maxLon-minLon-boxSize)
+		
+		box := models.BoundingBox{
+			BottomLeft: models.Location{Lat: lat, Lon: lon},
+			TopRight:   models.Location{Lat: lat + boxSize, Lon: lon + boxSize},
+		}
+		
+		results, err := index.QueryBox(box)
+		return len(results), err
+	})
+}
 
-	startTime := time.Now()
-	
-	queryCh := make(chan int, numQueries)
-	var wg sync.WaitGroup
-	
-	wg.Add(workers)
-	for w := 0; w < workers; w++ {
-		go func() {
-			defer wg.Done()
-			r := rand.New(rand.NewSource(rand.Int63()))
-			
-			for range queryCh {
-				// Generate random center
-				center := models.Location{
-					Lat: minLat + r.Float64()*(maxLat-minLat),
-					Lon: minLon + r.Float64()*(maxLon-minLon),
-				}
-				
-				queryStart := time.Now()
-				results, err := index.QueryRadius(center, radius)
-				queryDuration := time.Since(queryStart)
-				
-				if err == nil {
-					atomic.AddInt64(&totalResults, int64(len(results)))
-					
-					mu.Lock()
-					durations = append(durations, queryDuration)
-					if queryDuration < minDuration {
-						minDuration = queryDuration
-					}
-					if queryDuration > maxDuration {
-						maxDuration = queryDuration
-					}
-					mu.Unlock()
-				}
-			}
-		}()
-	}
-	
-	for i := 0; i < numQueries; i++ {
-		queryCh <- i
-	}
-	close(queryCh)
-	
-	wg.Wait()
-	totalDuration := time.Since(startTime)
-	
-	var totalDur time.Duration
-	for _, d := range durations {
-		totalDur += d
-	}
-	avgDuration := totalDur / time.Duration(len(durations))
+func benchmarkRadiusQueries(index backend.SpatialIndex, opts runOptions,
+	minLat, maxLat, minLon, maxLon, radius float64) BenchmarkResult {
 	
-	return BenchmarkResult{
-		QueryType:     "radius",
-		TotalQueries:  numQueries,
-		TotalDuration: totalDuration,
-		AvgDuration:   avgDuration,
-		QueriesPerSec: float64(numQueries) / totalDuration.Seconds(),
-		MinDuration:   minDuration,
-		MaxDuration:   maxDuration,
-		TotalResults:  totalResults,
-		AvgResults:    float64(totalResults) / float64(numQueries),
-	}
+	return runBenchmark("radius", opts, func(r *rand.Rand) (int, error) {
+		// Generate random center
+		center := models.Location{
+			Lat: minLat + r.Float64()*(maxLat-minLat),
+			Lon: minLon + r.Float64()*(maxLon-minLon),
+		}
+		
+		results, err := index.QueryRadius(center, radius)
+		return len(results), err
+	})
 }
 
-func benchmarkNearestQueries(index *rtree.GeoIndex, numQueries, workers int,
+func benchmarkNearestQueries(index backend.SpatialIndex, opts runOptions,
 	minLat, maxLat, minLon, maxLon float64, k int) BenchmarkResult {
 	
-	var (
-		totalResults int64
-		minDuration = time.Hour
-		maxDuration time.Duration
-		durations []time.Duration
-		mu sync.Mutex
-	)
-
-	startTime := time.Now()
-	
-	queryCh := make(chan int, numQueries)
-	var wg sync.WaitGroup
-	
-	wg.Add(workers)
-	for w := 0; w < workers; w++ {
-		go func() {
-			defer wg.Done()
-			r := rand.New(rand.NewSource(rand.Int63()))
-			
-			for range queryCh {
-				// Generate random center
-				center := models.Location{
-					Lat: minLat + r.Float64()*(maxLat-minLat),
-					Lon: minLon + r.Float64()*(maxLon-minLon),
-				}
-				
-				queryStart := time.Now()
-				results := index.NearestNeighbors(center, k)
-				queryDuration := time.Since(queryStart)
-				
-				atomic.AddInt64(&totalResults, int64(len(results)))
-				
-				mu.Lock()
-				durations = append(durations, queryDuration)
-				if queryDuration < minDuration {
-					minDuration = queryDuration
-				}
-				if queryDuration > maxDuration {
-					maxDuration = queryDuration
-				}
-				mu.Unlock()
-			}
-		}()
+	nearest := func(center models.Location) ([]*models.Point, error) {
+		return index.NearestNeighbors(center, k), nil
 	}
-	
-	for i := 0; i < numQueries; i++ {
-		queryCh <- i
-	}
-	close(queryCh)
-	
-	wg.Wait()
-	totalDuration := time.Since(startTime)
-	
-	var totalDur time.Duration
-	for _, d := range durations {
-		totalDur += d
-	}
-	avgDuration := totalDur / time.Duration(len(durations))
-	
-	return BenchmarkResult{
-		QueryType:     "nearest",
-		TotalQueries:  numQueries,
-		TotalDuration: totalDuration,
-		AvgDuration:   avgDuration,
-		QueriesPerSec: float64(numQueries) / totalDuration.Seconds(),
-		MinDuration:   minDuration,
-		MaxDuration:   maxDuration,
-		TotalResults:  totalResults,
-		AvgResults:    float64(totalResults) / float64(numQueries),
+	// SpatialIndex can't report database errors per query, so ask the database
+	if db, ok := index.(backend.ContextIndex); ok {
+		nearest = func(center models.Location) ([]*models.Point, error) {
+			return db.NearestNeighborsContext(context.Background(), center, k)
+		}
 	}
+
+	return runBenchmark("nearest", opts, func(r *rand.Rand) (int, error) {
+		// Generate random center
+		center := models.Location{
+			Lat: minLat + r.Float64()*(maxLat-minLat),
+			Lon: minLon + r.Float64()*(maxLon-minLon),
+		}
+		
+		results, err := nearest(center)
+		return len(results), err
+	})
 }
 
-func benchmarkMixedQueries(index *rtree.GeoIndex, numQueries, workers int,
+func benchmarkMixedQueries(index backend.SpatialIndex, opts runOptions,
 	minLat, maxLat, minLon, maxLon, boxSize, radius float64, k int) BenchmarkResult {
 	
-	// Run 1/3 of each query type
-	queriesPerType := numQueries / 3
+	// Run 1/3 of each query type, splitting the query count or the duration
+	opts.queries /= 3
+	opts.duration /= 3
 	
 	log.Println("Running mixed benchmark (33% each type)...")
 	
-	boxResult := benchmarkBoxQueries(index, queriesPerType, workers, 
+	boxResult := benchmarkBoxQueries(index, opts,
 		minLat, maxLat, minLon, maxLon, boxSize)
-	radiusResult := benchmarkRadiusQueries(index, queriesPerType, workers,
+	radiusResult := benchmarkRadiusQueries(index, opts,
 		minLat, maxLat, minLon, maxLon, radius)
-	nearestResult := benchmarkNearestQueries(index, queriesPerType, workers,
+	nearestResult := benchmarkNearestQueries(index, opts,
 		minLat, maxLat, minLon, maxLon, k)
 	
 	// Combine results
@@ -343,35 +408,41 @@ func benchmarkMixedQueries(index *rtree.GeoIndex, numQueries, workers int,
 	totalDuration := boxResult.TotalDuration + radiusResult.TotalDuration + nearestResult.TotalDuration
 	totalResults := boxResult.TotalResults + radiusResult.TotalResults + nearestResult.TotalResults
 	
+	hist := latency.NewHistogram()
+	hist.Merge(boxResult.hist)
+	hist.Merge(radiusResult.hist)
+	hist.Merge(nearestResult.hist)
+	
+	result := newResult("mixed", totalQueries, totalDuration, totalResults, hist)
+	result.Memory = mergeMemory(totalQueries, boxResult.Memory, radiusResult.Memory, nearestResult.Memory)
+	return result
+}
+
+// newResult summarizes a benchmark run from its latency histogram
+func newResult(queryType string, numQueries int, totalDuration time.Duration, totalResults int64, hist *latency.Histogram) BenchmarkResult {
 	return BenchmarkResult{
-		QueryType:     "mixed",
-		TotalQueries:  totalQueries,
+		QueryType:     queryType,
+		TotalQueries:  numQueries,
 		TotalDuration: totalDuration,
-		AvgDuration:   totalDuration / time.Duration(totalQueries),
-		QueriesPerSec: float64(totalQueries) / totalDuration.Seconds(),
-		MinDuration:   min(boxResult.MinDuration, radiusResult.MinDuration, nearestResult.MinDuration),
-		MaxDuration:   max(boxResult.MaxDuration, radiusResult.MaxDuration, nearestResult.MaxDuration),
+		AvgDuration:   hist.Mean(),
+		QueriesPerSec: float64(numQueries) / totalDuration.Seconds(),
+		MinDuration:   hist.Min(),
+		MaxDuration:   hist.Max(),
 		TotalResults:  totalResults,
-		AvgResults:    float64(totalResults) / float64(totalQueries),
+		AvgResults:    float64(totalResults) / float64(numQueries),
+		P50Duration:   hist.Percentile(50),
+		P90Duration:   hist.Percentile(90),
+		P95Duration:   hist.Percentile(95),
+		P99Duration:   hist.Percentile(99),
+		P999Duration:  hist.Percentile(99.9),
+		hist:          hist,
 	}
 }
 
-func min(durations ...time.Duration) time.Duration {
-	minD := durations[0]
-	for _, d := range durations[1:] {
-		if d < minD {
-			minD = d
-		}
+// durationString formats an optional duration flag, leaving unset ones empty
+func durationString(d time.Duration) string {
+	if d == 0 {
+		return ""
 	}
-	return minD
+	return d.String()
 }
-
-func max(durations ...time.Duration) time.Duration {
-	maxD := durations[0]
-	for _, d := range durations[1:] {
-		if d > maxD {
-			maxD = d
-		}
-	}
-	return maxD
-}
\ No newline at end of file
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/1F47E/geo-index-rtree/pkg/report"
+)
+
+// memorySampleInterval is how often the heap is sampled while queries run.
+// ReadMemStats stops the world for a few microseconds, so sampling is kept
+// far apart from the queries it would otherwise delay.
+const memorySampleInterval = 100 * time.Millisecond
+
+// MemoryStats is the memory and GC activity of a measured benchmark phase.
+// Throughput alone hides a design that allocates on every query; the
+// allocations per query and the GC work they cause show it.
+type MemoryStats struct {
+	HeapInuseAvg  uint64        `json:"heap_inuse_avg_bytes"`
+	HeapInusePeak uint64        `json:"heap_inuse_peak_bytes"`
+	GCCount       uint32        `json:"gc_count"`
+	GCPauseTotal  time.Duration `json:"gc_pause_total_ns"`
+	AllocBytes    uint64        `json:"alloc_bytes"`
+	Allocs        uint64        `json:"allocs"`
+	// AllocsPerQuery and BytesPerQuery include the benchmark's own small
+	// per-query overhead, so compare them between runs rather than read them
+	// as absolutes
+	AllocsPerQuery float64 `json:"allocs_per_query"`
+	BytesPerQuery  float64 `json:"bytes_per_query"`
+
+	samples int
+}
+
+// memorySampler samples runtime.MemStats while a phase is measured
+type memorySampler struct {
+	start   runtime.MemStats
+	stats   MemoryStats
+	heapSum uint64
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// startMemorySampler records the counters at the start of a phase and
+// samples the heap in use until finish
+func startMemorySampler() *memorySampler {
+	m := &memorySampler{stop: make(chan struct{}), done: make(chan struct{})}
+	runtime.ReadMemStats(&m.start)
+	m.observe(m.start.HeapInuse)
+
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(memorySampleInterval)
+		defer ticker.Stop()
+		var mem runtime.MemStats
+		for {
+			select {
+			case <-m.stop:
+				return
+			case <-ticker.C:
+				runtime.ReadMemStats(&mem)
+				m.observe(mem.HeapInuse)
+			}
+		}
+	}()
+	return m
+}
+
+func (m *memorySampler) observe(heapInuse uint64) {
+	m.stats.samples++
+	m.heapSum += heapInuse
+	m.stats.HeapInusePeak = max(m.stats.HeapInusePeak, heapInuse)
+}
+
+// finish stops sampling and returns the activity since the start, with
+// per-query figures for the given number of queries
+func (m *memorySampler) finish(queries int) MemoryStats {
+	close(m.stop)
+	<-m.done
+
+	var end runtime.MemStats
+	runtime.ReadMemStats(&end)
+	m.observe(end.HeapInuse)
+
+	stats := m.stats
+	stats.HeapInuseAvg = m.heapSum / uint64(stats.samples)
+	stats.GCCount = end.NumGC - m.start.NumGC
+	stats.GCPauseTotal = time.Duration(end.PauseTotalNs - m.start.PauseTotalNs)
+	stats.AllocBytes = end.TotalAlloc - m.start.TotalAlloc
+	stats.Allocs = end.Mallocs - m.start.Mallocs
+	stats.perQuery(queries)
+	return stats
+}
+
+// perQuery sets the per-query figures from the totals
+func (s *MemoryStats) perQuery(queries int) {
+	if queries > 0 {
+		s.AllocsPerQuery = float64(s.Allocs) / float64(queries)
+		s.BytesPerQuery = float64(s.AllocBytes) / float64(queries)
+	}
+}
+
+// mergeMemory combines the activity of phases run one after another, such
+// as the three query types of a mixed run
+func mergeMemory(queries int, phases ...MemoryStats) MemoryStats {
+	var merged MemoryStats
+	var heapSum uint64
+	for _, p := range phases {
+		merged.HeapInusePeak = max(merged.HeapInusePeak, p.HeapInusePeak)
+		merged.GCCount += p.GCCount
+		merged.GCPauseTotal += p.GCPauseTotal
+		merged.AllocBytes += p.AllocBytes
+		merged.Allocs += p.Allocs
+		merged.samples += p.samples
+		heapSum += p.HeapInuseAvg * uint64(p.samples)
+	}
+	if merged.samples > 0 {
+		merged.HeapInuseAvg = heapSum / uint64(merged.samples)
+	}
+	merged.perQuery(queries)
+	return merged
+}
+
+// printMemory prints the memory and GC activity of a result
+func printMemory(s MemoryStats) {
+	fmt.Printf("Heap In Use: %s avg, %s peak\n",
+		report.Format(float64(s.HeapInuseAvg), report.Bytes), report.Format(float64(s.HeapInusePeak), report.Bytes))
+	fmt.Printf("GC Cycles: %d (%v paused)\n", s.GCCount, s.GCPauseTotal)
+	fmt.Printf("Allocations/Query: %.1f (%s)\n", s.AllocsPerQuery, report.Format(s.BytesPerQuery, report.Bytes))
+}
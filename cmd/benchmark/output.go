@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/1F47E/geo-index-rtree/pkg/manifest"
+	"github.com/1F47E/geo-index-rtree/pkg/report"
+)
+
+// writeManifest writes the seed, flags, source version and machine of this
+// run next to the results at path, so the run can be reproduced
+func writeManifest(path string, seed int64) {
+	manifestPath := manifest.PathFor(path)
+	if err := manifest.New("benchmark", seed).Write(manifestPath); err != nil {
+		log.Fatalf("Failed to write manifest: %v", err)
+	}
+	fmt.Printf("Manifest written to %s\n", manifestPath)
+}
+
+// writeReport writes r as HTML or Markdown, choosing the format by the
+// extension of path
+func writeReport(r *report.Report, path string) {
+	if err := r.Write(path); err != nil {
+		log.Fatalf("Failed to write report: %v", err)
+	}
+	fmt.Printf("Report written to %s\n", path)
+}
+
+// newReport summarizes results, which share a query type and workload, as a
+// report comparing their backends
+func newReport(results []BenchmarkResult) *report.Report {
+	first := results[0]
+	c := first.Config
+	engines := make([]string, len(results))
+	for i, r := range results {
+		engines[i] = r.Config.Backend
+		if engines[i] == "" {
+			engines[i] = "rtree"
+		}
+	}
+	row := func(metric string, unit report.Unit, value func(r BenchmarkResult) float64) report.Row {
+		values := make([]float64, len(results))
+		for i, r := range results {
+			values[i] = value(r)
+		}
+		return report.Row{Metric: metric, Unit: unit, Values: values}
+	}
+	duration := func(metric string, d func(r BenchmarkResult) time.Duration) report.Row {
+		return row(metric, report.Duration, func(r BenchmarkResult) float64 { return float64(d(r)) })
+	}
+
+	run := fmt.Sprintf("%d queries", first.TotalQueries)
+	if c.Duration != "" {
+		run = c.Duration
+	}
+	if c.Warmup != "" {
+		run += " after " + c.Warmup + " warmup"
+	}
+	r := &report.Report{
+		Title:     fmt.Sprintf("Benchmark: %s queries", first.QueryType),
+		Generated: c.Timestamp,
+		Context: []report.Field{
+			{Name: "Index file", Value: c.IndexFile},
+			{Name: "Points", Value: report.Format(float64(c.IndexPoints), report.Count)},
+			{Name: "Run", Value: run},
+			{Name: "Workers", Value: strconv.Itoa(c.Workers)},
+			{Name: "Query area", Value: fmt.Sprintf("lat %g..%g, lon %g..%g", c.MinLat, c.MaxLat, c.MinLon, c.MaxLon)},
+			{Name: "Seed", Value: strconv.FormatInt(c.Seed, 10)},
+			{Name: "Machine", Value: fmt.Sprintf("%d CPU cores, %s", c.CPUCores, c.GoVersion)},
+		},
+		Sections: []report.Section{{
+			Title:   "Throughput and latency",
+			Engines: engines,
+			Chart:   "Queries/sec",
+			Rows: []report.Row{
+				row("Queries/sec", report.PerSecond, func(r BenchmarkResult) float64 { return r.QueriesPerSec }),
+				duration("Avg", func(r BenchmarkResult) time.Duration { return r.AvgDuration }),
+				duration("P50", func(r BenchmarkResult) time.Duration { return r.P50Duration }),
+				duration("P90", func(r BenchmarkResult) time.Duration { return r.P90Duration }),
+				duration("P99", func(r BenchmarkResult) time.Duration { return r.P99Duration }),
+				duration("P99.9", func(r BenchmarkResult) time.Duration { return r.P999Duration }),
+				duration("Max", func(r BenchmarkResult) time.Duration { return r.MaxDuration }),
+				row("Queries", report.Count, func(r BenchmarkResult) float64 { return float64(r.TotalQueries) }),
+				row("Avg results", report.Count, func(r BenchmarkResult) float64 { return r.AvgResults }),
+				row("Heap in use (avg)", report.Bytes, func(r BenchmarkResult) float64 { return float64(r.Memory.HeapInuseAvg) }),
+				row("Heap in use (peak)", report.Bytes, func(r BenchmarkResult) float64 { return float64(r.Memory.HeapInusePeak) }),
+				row("GC cycles", report.Count, func(r BenchmarkResult) float64 { return float64(r.Memory.GCCount) }),
+				duration("GC pause", func(r BenchmarkResult) time.Duration { return r.Memory.GCPauseTotal }),
+				row("Allocs/query", report.Count, func(r BenchmarkResult) float64 { return math.Round(r.Memory.AllocsPerQuery*10) / 10 }),
+				row("Bytes/query", report.Bytes, func(r BenchmarkResult) float64 { return r.Memory.BytesPerQuery }),
+				row("Points", report.Count, func(r BenchmarkResult) float64 { return float64(r.Config.IndexPoints) }),
+				// Databases that already held the points were not loaded
+				row("Load time", report.Duration, func(r BenchmarkResult) float64 {
+					if r.Config.LoadDuration == 0 {
+						return report.Missing
+					}
+					return float64(r.Config.LoadDuration)
+				}),
+			},
+		}},
+	}
+	for _, res := range results[1:] {
+		if res.Config.IndexPoints != c.IndexPoints {
+			r.Notes = append(r.Notes, fmt.Sprintf("%s holds %d points, %s holds %d.",
+				res.Config.Backend, res.Config.IndexPoints, engines[0], c.IndexPoints))
+		}
+	}
+	return r
+}
+
+// writeResult saves a benchmark result as JSON or as a single-row CSV with a header
+func writeResult(result BenchmarkResult, format, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	defer file.Close()
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(file)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(result); err != nil {
+			return fmt.Errorf("failed to encode result: %w", err)
+		}
+	case "csv":
+		w := csv.NewWriter(file)
+		header, row := resultRecord(result)
+		if err := w.WriteAll([][]string{header, row}); err != nil {
+			return fmt.Errorf("failed to write csv: %w", err)
+		}
+	default:
+		return fmt.Errorf("unknown output format %q", format)
+	}
+
+	return file.Close()
+}
+
+// resultRecord flattens a result and its config into CSV columns
+func resultRecord(r BenchmarkResult) ([]string, []string) {
+	ns := func(d time.Duration) string { return strconv.FormatInt(int64(d), 10) }
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	c := r.Config
+
+	columns := []struct {
+		name  string
+		value string
+	}{
+		{"timestamp", c.Timestamp.Format(time.RFC3339)},
+		{"query_type", r.QueryType},
+		{"total_queries", strconv.Itoa(r.TotalQueries)},
+		{"total_duration_ns", ns(r.TotalDuration)},
+		{"queries_per_sec", f(r.QueriesPerSec)},
+		{"avg_duration_ns", ns(r.AvgDuration)},
+		{"min_duration_ns", ns(r.MinDuration)},
+		{"max_duration_ns", ns(r.MaxDuration)},
+		{"p50_duration_ns", ns(r.P50Duration)},
+		{"p90_duration_ns", ns(r.P90Duration)},
+		{"p95_duration_ns", ns(r.P95Duration)},
+		{"p99_duration_ns", ns(r.P99Duration)},
+		{"p999_duration_ns", ns(r.P999Duration)},
+		{"total_results", strconv.FormatInt(r.TotalResults, 10)},
+		{"avg_results", f(r.AvgResults)},
+		{"index_file", c.IndexFile},
+		{"index_points", strconv.FormatInt(c.IndexPoints, 10)},
+		{"workers", strconv.Itoa(c.Workers)},
+		{"duration", c.Duration},
+		{"warmup", c.Warmup},
+		{"cooldown", c.Cooldown},
+		{"cpu_cores", strconv.Itoa(c.CPUCores)},
+		{"go_version", c.GoVersion},
+		{"min_lat", f(c.MinLat)},
+		{"max_lat", f(c.MaxLat)},
+		{"min_lon", f(c.MinLon)},
+		{"max_lon", f(c.MaxLon)},
+		{"box_size", f(c.BoxSize)},
+		{"radius_km", f(c.RadiusKm)},
+		{"k", strconv.Itoa(c.K)},
+		{"backend", c.Backend},
+		{"seed", strconv.FormatInt(c.Seed, 10)},
+		{"heap_inuse_avg_bytes", strconv.FormatUint(r.Memory.HeapInuseAvg, 10)},
+		{"heap_inuse_peak_bytes", strconv.FormatUint(r.Memory.HeapInusePeak, 10)},
+		{"gc_count", strconv.FormatUint(uint64(r.Memory.GCCount), 10)},
+		{"gc_pause_total_ns", ns(r.Memory.GCPauseTotal)},
+		{"alloc_bytes", strconv.FormatUint(r.Memory.AllocBytes, 10)},
+		{"allocs", strconv.FormatUint(r.Memory.Allocs, 10)},
+		{"allocs_per_query", f(r.Memory.AllocsPerQuery)},
+		{"bytes_per_query", f(r.Memory.BytesPerQuery)},
+		{"load_duration_ns", ns(c.LoadDuration)},
+		{"index_heap_bytes", strconv.FormatInt(c.IndexHeapBytes, 10)},
+	}
+
+	header := make([]string, len(columns))
+	row := make([]string, len(columns))
+	for i, col := range columns {
+		header[i] = col.name
+		row[i] = col.value
+	}
+	return header, row
+}
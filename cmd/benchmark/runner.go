@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/1F47E/geo-index-rtree/pkg/latency"
+)
+
+// queryFunc runs one random query and returns the number of results
+type queryFunc func(r *rand.Rand) (int, error)
+
+// runOptions controls how long a benchmark runs. A non-zero duration takes
+// precedence over the query count. Worker i draws its queries from seed+i,
+// and in count mode runs a fixed share of them, so runs with the same seed,
+// workers and query count issue exactly the same queries.
+type runOptions struct {
+	queries  int
+	duration time.Duration
+	warmup   time.Duration
+	cooldown time.Duration
+	workers  int
+	seed     int64
+	backend  string // labels timeline phases when comparing backends
+	timeline *timeline
+}
+
+// queryDescription describes the run length for log messages
+func (o runOptions) queryDescription() string {
+	if o.duration > 0 {
+		return fmt.Sprintf("%v of", o.duration)
+	}
+	return fmt.Sprint(o.queries)
+}
+
+// runBenchmark runs an optional warmup phase whose queries are discarded,
+// pauses for the cooldown after a forced GC, then measures fn
+func runBenchmark(queryType string, opts runOptions, fn queryFunc) BenchmarkResult {
+	if opts.warmup > 0 {
+		log.Printf("Warming up %s queries for %v...\n", queryType, opts.warmup)
+		// Warmup workers are seeded after the measured ones, so a query cache
+		// is not primed with the measured queries
+		runQueries(fn, opts.workers, 0, opts.warmup, opts.seed+int64(opts.workers), nil, nil)
+	}
+	if opts.cooldown > 0 {
+		runtime.GC()
+		time.Sleep(opts.cooldown)
+	}
+
+	hist := latency.NewHistogram()
+	if opts.timeline != nil {
+		phase := queryType
+		if opts.backend != "" {
+			phase = opts.backend + "/" + queryType
+		}
+		opts.timeline.start(phase)
+	}
+	memory := startMemorySampler()
+	startTime := time.Now()
+	completed, totalResults := runQueries(fn, opts.workers, opts.queries, opts.duration, opts.seed, hist, opts.timeline)
+	totalDuration := time.Since(startTime)
+	if opts.timeline != nil {
+		opts.timeline.finish()
+	}
+
+	result := newResult(queryType, completed, totalDuration, totalResults, hist)
+	result.Memory = memory.finish(completed)
+	return result
+}
+
+// runQueries executes fn on a pool of workers, either numQueries times or until
+// duration elapses, recording successful query latencies into hist and tl when
+// non-nil. Worker w is seeded with seed+w, or randomly when seed is zero.
+func runQueries(fn queryFunc, workers, numQueries int, duration time.Duration, seed int64, hist *latency.Histogram, tl *timeline) (int, int64) {
+	var (
+		completed    atomic.Int64
+		totalResults atomic.Int64
+		mu           sync.Mutex
+		wg           sync.WaitGroup
+	)
+
+	// In count mode each worker runs its share of the queries, so a seed
+	// fixes which queries run; in duration mode they run until the deadline
+	deadline := time.Now().Add(duration)
+	share := func(w int) int {
+		n := numQueries / workers
+		if w < numQueries%workers {
+			n++
+		}
+		return n
+	}
+
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		workerSeed := rand.Int63()
+		if seed != 0 {
+			workerSeed = seed + int64(w)
+		}
+		remaining := share(w)
+		go func() {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(workerSeed))
+			next := func() bool {
+				if duration > 0 {
+					return time.Now().Before(deadline)
+				}
+				remaining--
+				return remaining >= 0
+			}
+
+			for next() {
+				queryStart := time.Now()
+				n, err := fn(r)
+				queryDuration := time.Since(queryStart)
+				completed.Add(1)
+
+				if err == nil {
+					totalResults.Add(int64(n))
+					if hist != nil {
+						mu.Lock()
+						hist.Record(queryDuration)
+						mu.Unlock()
+					}
+					if tl != nil {
+						tl.record(queryDuration)
+					}
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	return int(completed.Load()), totalResults.Load()
+}
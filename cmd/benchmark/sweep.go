@@ -0,0 +1,310 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/1F47E/geo-index-rtree/pkg/backend"
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/1F47E/geo-index-rtree/pkg/report"
+	"github.com/1F47E/geo-index-rtree/pkg/rtree"
+)
+
+// parseWorkers parses a comma-separated list of worker counts such as
+// "1,2,4,8"
+func parseWorkers(list string) ([]int, error) {
+	var levels []int
+	for _, field := range strings.Split(list, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid worker count %q", field)
+		}
+		levels = append(levels, n)
+	}
+	return levels, nil
+}
+
+// runSweep runs the workload against index once per worker count, every run
+// drawing its queries from opts.seed, and returns one result per count
+func runSweep(index backend.SpatialIndex, levels []int, opts runOptions,
+	run func(backend.SpatialIndex, runOptions) BenchmarkResult,
+	benchConfig func(points int64) BenchmarkConfig) []BenchmarkResult {
+
+	if top := slices.Max(levels); top > runtime.GOMAXPROCS(0) {
+		log.Printf("Sweeping up to %d workers on %d CPUs; levels above that measure oversubscription\n", top, runtime.GOMAXPROCS(0))
+	}
+
+	results := make([]BenchmarkResult, 0, len(levels))
+	for _, workers := range levels {
+		log.Printf("Running %s queries with %d workers (seed %d)...\n", opts.queryDescription(), workers, opts.seed)
+		opts.workers = workers
+		opts.backend = fmt.Sprintf("w%d", workers)
+		result := run(index, opts)
+		result.Config = benchConfig(index.Count())
+		result.Config.Workers = workers
+		results = append(results, result)
+		// Start every level with a clean heap
+		runtime.GC()
+	}
+	return results
+}
+
+// sweepScaling returns the throughput of r relative to the first level, and
+// that speedup divided by the increase in workers: 1 is linear scaling
+func sweepScaling(first, r BenchmarkResult) (speedup, efficiency float64) {
+	if first.QueriesPerSec == 0 {
+		return 0, 0
+	}
+	speedup = r.QueriesPerSec / first.QueriesPerSec
+	return speedup, speedup * float64(first.Config.Workers) / float64(r.Config.Workers)
+}
+
+// printSweep prints one row per worker count with its throughput, scaling
+// and latency
+func printSweep(results []BenchmarkResult) {
+	first := results[0]
+	fmt.Printf("\n=== Concurrency Sweep (%s, %d CPU cores, seed %d) ===\n",
+		first.QueryType, runtime.NumCPU(), first.Config.Seed)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "Workers\tQueries/sec\tSpeedup\tEfficiency\tAvg\tP50\tP90\tP99\tP99.9\t")
+	for _, r := range results {
+		speedup, efficiency := sweepScaling(first, r)
+		fmt.Fprintf(w, "%d\t%.0f\t%.2fx\t%.0f%%\t%v\t%v\t%v\t%v\t%v\t\n",
+			r.Config.Workers, r.QueriesPerSec, speedup, efficiency*100,
+			r.AvgDuration, r.P50Duration, r.P90Duration, r.P99Duration, r.P999Duration)
+	}
+	w.Flush()
+}
+
+// newSweepReport summarizes a sweep as a report with one column per worker
+// count, adding its scaling to the rows of a backend comparison
+func newSweepReport(results []BenchmarkResult) *report.Report {
+	r := newReport(results)
+	first := results[0]
+	r.Title = fmt.Sprintf("Concurrency sweep: %s queries", first.QueryType)
+
+	levels := make([]string, len(results))
+	speedups := make([]float64, len(results))
+	efficiencies := make([]float64, len(results))
+	for i, res := range results {
+		levels[i] = strconv.Itoa(res.Config.Workers)
+		speedups[i], efficiencies[i] = sweepScaling(first, res)
+	}
+	for i := range r.Context {
+		if r.Context[i].Name == "Workers" {
+			r.Context[i].Value = strings.Join(levels, ", ")
+		}
+	}
+	r.Notes = append(r.Notes, "Speedup is throughput relative to the first level; efficiency divides it by the increase in workers, so 100% is linear scaling.")
+
+	s := &r.Sections[0]
+	s.Title = "Scaling by worker count"
+	for i, res := range results {
+		s.Engines[i] = levels[i] + " workers"
+		if res.Config.Workers == 1 {
+			s.Engines[i] = "1 worker"
+		}
+	}
+	rows := []report.Row{s.Rows[0],
+		{Metric: "Speedup", Unit: report.Ratio, Values: speedups},
+		{Metric: "Efficiency", Unit: report.Percent, Values: efficiencies},
+	}
+	// The points and load time are the same at every level
+	for _, row := range s.Rows[1:] {
+		if row.Metric != "Points" && row.Metric != "Load time" {
+			rows = append(rows, row)
+		}
+	}
+	s.Rows = rows
+	return r
+}
+
+// parseSizes parses a comma-separated list of point counts, accepting
+// exponents such as "1e5,1e6,1e7"
+func parseSizes(list string) ([]int, error) {
+	var sizes []int
+	for _, field := range strings.Split(list, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || v < 1 || v > 1e10 || v != math.Trunc(v) {
+			return nil, fmt.Errorf("invalid point count %q", field)
+		}
+		sizes = append(sizes, int(v))
+	}
+	return sizes, nil
+}
+
+// sweepChunkSize is the number of points generated from one random source.
+// Chunk c draws from seed+1+c, as in the load command, so a smaller size
+// holds the first points of a larger one.
+const sweepChunkSize = 10000
+
+// generatePoints returns n points spread uniformly over the query area of b
+func generatePoints(n int, b BenchmarkConfig, seed int64) []*models.Point {
+	points := make([]*models.Point, n)
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, runtime.NumCPU())
+	for start := 0; start < n; start += sweepChunkSize {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(start, end int) {
+			defer func() { <-sem; wg.Done() }()
+			r := rand.New(rand.NewSource(seed + 1 + int64(start/sweepChunkSize)))
+			for i := start; i < end; i++ {
+				points[i] = &models.Point{
+					ID: fmt.Sprintf("point_%d", i),
+					Location: &models.Location{
+						Lat: b.MinLat + r.Float64()*(b.MaxLat-b.MinLat),
+						Lon: b.MinLon + r.Float64()*(b.MaxLon-b.MinLon),
+					},
+				}
+			}
+		}(start, min(start+sweepChunkSize, n))
+	}
+	wg.Wait()
+	return points
+}
+
+// heapInUse returns the live heap after a forced collection
+func heapInUse() uint64 {
+	runtime.GC()
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return mem.HeapAlloc
+}
+
+// runSizeSweep builds an R-tree of each size from generated points, timing
+// the build and measuring its heap, then runs the workload against it. The
+// heap includes the points themselves, as it would for a loaded index.
+func runSizeSweep(sizes []int, opts runOptions,
+	run func(backend.SpatialIndex, runOptions) BenchmarkResult,
+	benchConfig func(points int64) BenchmarkConfig) []BenchmarkResult {
+
+	results := make([]BenchmarkResult, 0, len(sizes))
+	for _, n := range sizes {
+		config := benchConfig(int64(n))
+		log.Printf("Generating %d points (seed %d)...\n", n, opts.seed)
+		before := heapInUse()
+		points := generatePoints(n, config, opts.seed)
+
+		log.Printf("Building an index of %d points...\n", n)
+		index := rtree.NewGeoIndex()
+		start := time.Now()
+		if err := index.IndexPoints(points); err != nil {
+			log.Fatalf("Failed to build index: %v", err)
+		}
+		buildTime := time.Since(start)
+		// points is dead here, so only the index keeps them alive
+		heap := heapInUse()
+		var heapBytes int64
+		if heap > before {
+			heapBytes = int64(heap - before)
+		}
+		log.Printf("Built in %v using %s of heap\n", buildTime, report.Format(float64(heapBytes), report.Bytes))
+
+		log.Printf("Running %s queries against %d points...\n", opts.queryDescription(), n)
+		opts.backend = fmt.Sprintf("n%d", n)
+		result := run(index, opts)
+		result.Config = config
+		result.Config.IndexFile = ""
+		result.Config.LoadDuration = buildTime
+		result.Config.IndexHeapBytes = heapBytes
+		results = append(results, result)
+	}
+	return results
+}
+
+// printSizeSweep prints one row per dataset size with its build cost and
+// query performance
+func printSizeSweep(results []BenchmarkResult) {
+	first := results[0]
+	fmt.Printf("\n=== Dataset Size Sweep (%s, %d workers, seed %d) ===\n",
+		first.QueryType, first.Config.Workers, first.Config.Seed)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "Points\tBuild\tPoints/sec\tHeap\tBytes/point\tQueries/sec\tAvg\tP50\tP99\tAvg results\t")
+	for _, r := range results {
+		c := r.Config
+		fmt.Fprintf(w, "%d\t%v\t%.0f\t%s\t%.0f\t%.0f\t%v\t%v\t%v\t%.2f\t\n",
+			c.IndexPoints, c.LoadDuration.Round(time.Millisecond), buildRate(r),
+			report.Format(float64(c.IndexHeapBytes), report.Bytes), bytesPerPoint(r),
+			r.QueriesPerSec, r.AvgDuration, r.P50Duration, r.P99Duration, r.AvgResults)
+	}
+	w.Flush()
+}
+
+// buildRate returns the points indexed per second of a size sweep result
+func buildRate(r BenchmarkResult) float64 {
+	if r.Config.LoadDuration == 0 {
+		return 0
+	}
+	return float64(r.Config.IndexPoints) / r.Config.LoadDuration.Seconds()
+}
+
+// bytesPerPoint returns the heap per point of a size sweep result
+func bytesPerPoint(r BenchmarkResult) float64 {
+	if r.Config.IndexPoints == 0 {
+		return 0
+	}
+	return float64(r.Config.IndexHeapBytes) / float64(r.Config.IndexPoints)
+}
+
+// newSizeSweepReport summarizes a size sweep as a report with one column per
+// dataset size
+func newSizeSweepReport(results []BenchmarkResult) *report.Report {
+	r := newReport(results)
+	first := results[0]
+	r.Title = fmt.Sprintf("Dataset size sweep: %s queries", first.QueryType)
+
+	sizes := make([]string, len(results))
+	for i, res := range results {
+		sizes[i] = report.Format(float64(res.Config.IndexPoints), report.Count)
+	}
+	context := r.Context[:0]
+	for _, f := range r.Context {
+		switch f.Name {
+		case "Index file":
+			continue
+		case "Points":
+			f.Value = strings.Join(sizes, ", ") + ", uniform over the query area"
+		}
+		context = append(context, f)
+	}
+	r.Context = context
+	// The sizes differ on purpose, so drop the warning that they differ
+	r.Notes = []string{"Heap is the live heap an index adds, including its points, measured after a forced GC."}
+
+	s := &r.Sections[0]
+	s.Title = "Scaling by dataset size"
+	for i := range s.Engines {
+		s.Engines[i] = sizes[i] + " points"
+	}
+	values := func(value func(r BenchmarkResult) float64) []float64 {
+		out := make([]float64, len(results))
+		for i, res := range results {
+			out[i] = value(res)
+		}
+		return out
+	}
+	rows := []report.Row{s.Rows[0],
+		{Metric: "Build time", Unit: report.Duration, Values: values(func(r BenchmarkResult) float64 { return float64(r.Config.LoadDuration) })},
+		{Metric: "Build rate", Unit: report.PerSecond, Values: values(buildRate)},
+		{Metric: "Heap", Unit: report.Bytes, Values: values(func(r BenchmarkResult) float64 { return float64(r.Config.IndexHeapBytes) })},
+		{Metric: "Bytes/point", Unit: report.Count, Values: values(func(r BenchmarkResult) float64 { return math.Round(bytesPerPoint(r)) })},
+	}
+	for _, row := range s.Rows[1:] {
+		if row.Metric != "Points" && row.Metric != "Load time" {
+			rows = append(rows, row)
+		}
+	}
+	s.Rows = rows
+	return r
+}
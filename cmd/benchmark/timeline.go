@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"runtime/metrics"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	metricGCCycles = "/gc/cycles/total:gc-cycles"
+	metricGCPauses = "/sched/pauses/total/gc:seconds"
+	metricHeap     = "/memory/classes/heap/objects:bytes"
+)
+
+// timelineRow is one sampling interval of a benchmark run
+type timelineRow struct {
+	second     int
+	phase      string
+	span       time.Duration
+	queries    int64
+	avgLatency time.Duration
+	gcCycles   uint64
+	gcPause    time.Duration
+	heapBytes  uint64
+}
+
+// timeline samples throughput, latency and GC activity once per interval
+// while a benchmark phase is being measured
+type timeline struct {
+	interval time.Duration
+
+	queries   atomic.Int64
+	latencyNs atomic.Int64
+
+	mu      sync.Mutex
+	rows    []timelineRow
+	elapsed int
+	stop    chan struct{}
+	done    chan struct{}
+	samples []metrics.Sample
+}
+
+func newTimeline(interval time.Duration) *timeline {
+	return &timeline{
+		interval: interval,
+		samples: []metrics.Sample{
+			{Name: metricGCCycles},
+			{Name: metricGCPauses},
+			{Name: metricHeap},
+		},
+	}
+}
+
+// record adds one completed query; safe to call from any goroutine
+func (t *timeline) record(d time.Duration) {
+	t.queries.Add(1)
+	t.latencyNs.Add(int64(d))
+}
+
+// start begins sampling a phase, continuing the second counter across phases
+func (t *timeline) start(phase string) {
+	t.stop = make(chan struct{})
+	t.done = make(chan struct{})
+	t.queries.Store(0)
+	t.latencyNs.Store(0)
+
+	go func() {
+		defer close(t.done)
+		ticker := time.NewTicker(t.interval)
+		defer ticker.Stop()
+
+		last := time.Now()
+		cycles, pause, _ := t.readRuntime()
+		for {
+			select {
+			case <-t.stop:
+				// Flush a partial trailing interval so short runs still produce rows
+				if t.queries.Load() > 0 {
+					t.sample(phase, &last, &cycles, &pause)
+				}
+				return
+			case <-ticker.C:
+				t.sample(phase, &last, &cycles, &pause)
+			}
+		}
+	}()
+}
+
+// finish stops sampling the current phase
+func (t *timeline) finish() {
+	close(t.stop)
+	<-t.done
+}
+
+func (t *timeline) sample(phase string, last *time.Time, prevCycles *uint64, prevPause *float64) {
+	now := time.Now()
+	queries := t.queries.Swap(0)
+	latencyNs := t.latencyNs.Swap(0)
+	cycles, pause, heap := t.readRuntime()
+
+	row := timelineRow{
+		phase:     phase,
+		span:      now.Sub(*last),
+		queries:   queries,
+		gcCycles:  cycles - *prevCycles,
+		gcPause:   time.Duration((pause - *prevPause) * float64(time.Second)),
+		heapBytes: heap,
+	}
+	if queries > 0 {
+		row.avgLatency = time.Duration(latencyNs / queries)
+	}
+	*last, *prevCycles, *prevPause = now, cycles, pause
+
+	t.mu.Lock()
+	t.elapsed++
+	row.second = t.elapsed
+	t.rows = append(t.rows, row)
+	t.mu.Unlock()
+}
+
+// readRuntime returns the GC cycle count, an estimate of the total GC pause
+// time in seconds and the live heap size
+func (t *timeline) readRuntime() (uint64, float64, uint64) {
+	metrics.Read(t.samples)
+
+	var cycles, heap uint64
+	var pause float64
+	for _, s := range t.samples {
+		switch {
+		case s.Value.Kind() == metrics.KindBad:
+			continue
+		case s.Name == metricGCCycles:
+			cycles = s.Value.Uint64()
+		case s.Name == metricHeap:
+			heap = s.Value.Uint64()
+		case s.Name == metricGCPauses:
+			pause = histogramSum(s.Value.Float64Histogram())
+		}
+	}
+	return cycles, pause, heap
+}
+
+// histogramSum estimates the total of a runtime histogram using bucket midpoints
+func histogramSum(h *metrics.Float64Histogram) float64 {
+	var sum float64
+	for i, count := range h.Counts {
+		if count == 0 {
+			continue
+		}
+		lo, hi := h.Buckets[i], h.Buckets[i+1]
+		switch {
+		case math.IsInf(lo, -1):
+			sum += float64(count) * hi
+		case math.IsInf(hi, 1):
+			sum += float64(count) * lo
+		default:
+			sum += float64(count) * (lo + hi) / 2
+		}
+	}
+	return sum
+}
+
+// writeCSV saves the recorded rows
+func (t *timeline) writeCSV(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	defer file.Close()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	w := csv.NewWriter(file)
+	w.Write([]string{"second", "phase", "queries", "qps", "avg_latency_us", "gc_cycles", "gc_pause_us", "heap_mb"})
+	for _, row := range t.rows {
+		w.Write([]string{
+			strconv.Itoa(row.second),
+			row.phase,
+			strconv.FormatInt(row.queries, 10),
+			strconv.FormatFloat(float64(row.queries)/row.span.Seconds(), 'f', 1, 64),
+			strconv.FormatFloat(float64(row.avgLatency)/float64(time.Microsecond), 'f', 2, 64),
+			strconv.FormatUint(row.gcCycles, 10),
+			strconv.FormatFloat(float64(row.gcPause)/float64(time.Microsecond), 'f', 1, 64),
+			strconv.FormatFloat(float64(row.heapBytes)/(1<<20), 'f', 1, 64),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("failed to write csv: %w", err)
+	}
+	return file.Close()
+}
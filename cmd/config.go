@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/1F47E/geo-index-rtree/pkg/config"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Print the effective configuration",
+	Long: `Print the settings every command starts from, after merging the built-in
+defaults, the config file and GEOINDEX_* environment variables.
+
+The config file is --config, else $GEOINDEX_CONFIG, else ./config.yaml if it
+exists. Each key can be overridden with GEOINDEX_<SECTION>_<KEY>, e.g.
+GEOINDEX_INDEX_FILE or GEOINDEX_POSTGIS_PASSWORD; --env lists them all.
+Command-line flags take precedence over both.`,
+	Run: runConfig,
+}
+
+var (
+	configFile    string
+	configShowEnv bool
+
+	// appConfig is loaded before any command runs
+	appConfig *config.Config
+)
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "Config file (default $GEOINDEX_CONFIG or ./config.yaml if present)")
+	rootCmd.PersistentPreRun = applyConfig
+
+	configCmd.Flags().BoolVar(&configShowEnv, "env", false, "List the environment variables that override config keys")
+
+	rootCmd.AddCommand(configCmd)
+}
+
+// configFlags maps flag names to their configured defaults for each command.
+// "file" and "backend" apply to every command.
+func configFlags(c *config.Config) map[*cobra.Command]map[string]any {
+	return map[*cobra.Command]map[string]any{
+		serveCmd: {
+			"host":              c.Server.Host,
+			"port":              c.Server.Port,
+			"auth-token":        c.Server.AuthToken,
+			"metrics":           c.Server.Metrics,
+			"ui":                c.Server.UI,
+			"snapshot-interval": c.Server.SnapshotInterval,
+		},
+		watchCmd: {
+			"server":     c.Server.URL,
+			"auth-token": c.Server.AuthToken,
+		},
+		loadCmd: {
+			"points":  c.Benchmark.Points,
+			"workers": c.Benchmark.Workers,
+		},
+		queryCmd: {
+			"queries": c.Benchmark.Queries,
+			"workers": c.Benchmark.Workers,
+		},
+		radiusCmd: {
+			"queries": c.Benchmark.Queries,
+			"radius":  c.Benchmark.RadiusKm,
+			"workers": c.Benchmark.Workers,
+		},
+		nearestCmd: {
+			"queries":   c.Benchmark.Queries,
+			"neighbors": c.Benchmark.Neighbors,
+			"workers":   c.Benchmark.Workers,
+		},
+		profileCmd: {
+			"radius":    c.Benchmark.RadiusKm,
+			"neighbors": c.Benchmark.Neighbors,
+			"workers":   c.Benchmark.Workers,
+		},
+	}
+}
+
+// applyConfig loads the config and uses it for every flag of cmd that was not
+// set on the command line. Zero values leave the flag's own default in place.
+func applyConfig(cmd *cobra.Command, args []string) {
+	c, err := config.Load(configFile)
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	appConfig = c
+
+	values := map[string]any{"file": c.Index.File, "backend": c.Index.Backend}
+	for name, value := range configFlags(c)[cmd] {
+		values[name] = value
+	}
+
+	for name, value := range values {
+		flag := cmd.Flags().Lookup(name)
+		if flag == nil || flag.Changed {
+			continue
+		}
+		s := fmt.Sprint(value)
+		if s == "" || s == "0" || s == "0s" || s == "false" {
+			continue
+		}
+		if err := flag.Value.Set(s); err != nil {
+			log.Fatalf("Invalid config value for --%s: %v", name, err)
+		}
+	}
+}
+
+func runConfig(cmd *cobra.Command, args []string) {
+	if configShowEnv {
+		fmt.Println(config.EnvFile)
+		for _, name := range config.EnvVars() {
+			fmt.Println(name)
+		}
+		return
+	}
+
+	if appConfig.Path != "" {
+		fmt.Printf("# Loaded from %s\n", appConfig.Path)
+	} else {
+		fmt.Println("# No config file found; built-in defaults")
+	}
+
+	// Don't echo secrets
+	shown := *appConfig
+	if shown.PostGIS.Password != "" {
+		shown.PostGIS.Password = "********"
+	}
+	if shown.Server.AuthToken != "" {
+		shown.Server.AuthToken = "********"
+	}
+	if shown.Redis.Password != "" {
+		shown.Redis.Password = "********"
+	}
+
+	enc := yaml.NewEncoder(os.Stdout)
+	enc.SetIndent(2)
+	if err := enc.Encode(shown); err != nil {
+		log.Fatalf("Failed to encode config: %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/1F47E/geo-index-rtree/pkg/formats"
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/1F47E/geo-index-rtree/pkg/rtree"
+	"github.com/spf13/cobra"
+)
+
+var convertCmd = &cobra.Command{
+	Use:   "convert <input> <output>",
+	Short: "Convert points between index and interchange formats",
+	Long: `Read every point from one file and write it to another format.
+
+Supported formats: gob (index snapshots), flatgeobuf, parquet, geojson, csv and
+ndjson. gpx and osmpbf can be read but not written. Formats are detected from the
+file extensions unless --from / --to are given.`,
+	Example: `  go-geo-index convert geo_index.gob --to flatgeobuf points.fgb
+  go-geo-index convert geo_index.gob points.parquet
+  go-geo-index convert points.geojson geo_index.gob`,
+	Args: cobra.ExactArgs(2),
+	Run:  runConvert,
+}
+
+var (
+	convertFrom string
+	convertTo   string
+)
+
+func init() {
+	convertCmd.Flags().StringVar(&convertFrom, "from", "", "Input format (detected from extension by default)")
+	convertCmd.Flags().StringVar(&convertTo, "to", "", "Output format (detected from extension by default)")
+
+	rootCmd.AddCommand(convertCmd)
+}
+
+func runConvert(cmd *cobra.Command, args []string) {
+	input, output := args[0], args[1]
+	start := time.Now()
+	points, err := readPointsFile(input, convertFrom)
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", input, err)
+	}
+	if err := writePointsFile(output, convertTo, points); err != nil {
+		log.Fatalf("Failed to write %s: %v", output, err)
+	}
+
+	fmt.Printf("Converted %d points from %s to %s in %v\n", len(points), input, output, time.Since(start))
+}
+
+// isGobFormat reports whether path/format refer to an index snapshot
+func isGobFormat(path, format string) bool {
+	if format != "" {
+		return strings.EqualFold(format, "gob")
+	}
+	return strings.EqualFold(filepath.Ext(path), ".gob")
+}
+
+// readPointsFile reads all points from an index snapshot or interchange file
+func readPointsFile(path, format string) ([]*models.Point, error) {
+	if isGobFormat(path, format) {
+		return rtree.ReadPoints(path)
+	}
+
+	f, err := resolveFormat(path, format)
+	if err != nil {
+		return nil, err
+	}
+	var in io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		in = file
+	}
+
+	r, err := formats.NewReader(f, in)
+	if err != nil {
+		return nil, err
+	}
+	var points []*models.Point
+	err = formats.ReadBatches(r, 10000, func(batch []*models.Point) error {
+		points = append(points, batch...)
+		return nil
+	})
+	return points, err
+}
+
+// writePointsFile saves points as an index snapshot or in an interchange format
+func writePointsFile(path, format string, points []*models.Point) error {
+	if isGobFormat(path, format) {
+		index := rtree.NewGeoIndex()
+		if err := index.IndexPoints(points); err != nil {
+			return fmt.Errorf("failed to index points: %w", err)
+		}
+		return index.SaveToFile(path)
+	}
+
+	f, err := resolveFormat(path, format)
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create output: %w", err)
+	}
+	defer file.Close()
+
+	w, err := formats.NewWriter(f, file)
+	if err != nil {
+		return err
+	}
+	for _, p := range points {
+		if err := w.Write(p); err != nil {
+			return fmt.Errorf("failed to write points: %w", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to write points: %w", err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/1F47E/geo-index-rtree/pkg/rtree"
+	"github.com/spf13/cobra"
+)
+
+var dedupeCmd = &cobra.Command{
+	Use:   "dedupe",
+	Short: "Collapse duplicate points in an index",
+	Long: `Find points that share an ID, or lie within --distance of each other, and
+collapse each group into a single point.
+
+--keep selects the survivor:
+  first   the earliest point of the group (default)
+  last    the latest point of the group
+  merged  one point at the group's centroid, with the earliest point's ID
+
+Grouping by distance is transitive: a chain of points each within --distance
+of the next collapses into one group.`,
+	Run: runDedupe,
+}
+
+var (
+	dedupeOutput   string
+	dedupeDistance float64
+	dedupeKeep     string
+	dedupeDryRun   bool
+)
+
+func init() {
+	dedupeCmd.Flags().StringVarP(&dedupeOutput, "output", "o", "", "Output index file (required unless --dry-run)")
+	dedupeCmd.Flags().Float64Var(&dedupeDistance, "distance", 0, "Also collapse points closer than this many km (0 matches identical IDs only)")
+	dedupeCmd.Flags().StringVar(&dedupeKeep, "keep", "first", "Survivor of each group: first, last, merged")
+	dedupeCmd.Flags().BoolVar(&dedupeDryRun, "dry-run", false, "Report duplicates without writing an index")
+
+	rootCmd.AddCommand(dedupeCmd)
+}
+
+func runDedupe(cmd *cobra.Command, args []string) {
+	if dedupeOutput == "" && !dedupeDryRun {
+		log.Fatalf("--output is required unless --dry-run is set")
+	}
+
+	start := time.Now()
+	points, err := rtree.ReadPoints(indexFile)
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", indexFile, err)
+	}
+
+	result, err := rtree.Dedupe(points, rtree.DedupeOptions{
+		DistanceKm: dedupeDistance,
+		Keep:       rtree.KeepPolicy(dedupeKeep),
+	})
+	if err != nil {
+		log.Fatalf("Failed to dedupe: %v", err)
+	}
+
+	fmt.Printf("%d points, %d duplicate groups, %d points removed (%d remaining) in %v\n",
+		len(points), result.Groups, result.Removed, len(result.Points), time.Since(start))
+	if dedupeDryRun {
+		return
+	}
+
+	index := rtree.NewGeoIndex()
+	if err := index.IndexPoints(result.Points); err != nil {
+		log.Fatalf("Failed to index points: %v", err)
+	}
+	if err := index.SaveToFile(dedupeOutput); err != nil {
+		log.Fatalf("Failed to save index: %v", err)
+	}
+	fmt.Printf("Saved %d points to %s\n", index.Count(), dedupeOutput)
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"math/rand"
@@ -11,35 +14,15 @@ import (
 	"sync/atomic"
 	"time"
 
+	appconfig "github.com/1F47E/geo-index-rtree/pkg/config"
+	"github.com/1F47E/geo-index-rtree/pkg/backend"
 	"github.com/1F47E/geo-index-rtree/pkg/models"
 	"github.com/1F47E/geo-index-rtree/pkg/postgis"
+	"github.com/1F47E/geo-index-rtree/pkg/report"
 	"github.com/1F47E/geo-index-rtree/pkg/rtree"
 	"github.com/mattn/go-isatty"
-	"gopkg.in/yaml.v3"
 )
 
-const indexFile = "geo_index.gob"
-
-// Config structure for YAML configuration
-type Config struct {
-	Demo struct {
-		Points             int `yaml:"points"`
-		BenchmarkDuration  int `yaml:"benchmark_duration"`
-	} `yaml:"demo"`
-	PostGIS struct {
-		Host               string `yaml:"host"`
-		Port               int    `yaml:"port"`
-		User               string `yaml:"user"`
-		Password           string `yaml:"password"`
-		Database           string `yaml:"database"`
-		MaxConnections     int    `yaml:"max_connections"`
-		ConnectionTimeout  int    `yaml:"connection_timeout"`
-	} `yaml:"postgis"`
-	Network struct {
-		SimulatedLatencyMs int `yaml:"simulated_latency_ms"`
-	} `yaml:"network"`
-}
-
 var (
 	// ANSI color codes
 	colorReset  = "\033[0m"
@@ -52,24 +35,47 @@ var (
 	colorBold   = "\033[1m"
 	
 	// Configuration
-	config Config
+	config *appconfig.Config
 	
 	// Network latency simulation
 	simulateNetworkLatency = false
 	networkLatency time.Duration
+	
+	// CI mode: plain output, short runs and a JSON summary
+	ciMode bool
+	
+	// Database compared with the R-Tree (demo.database)
+	databaseName = "PostGIS"
+)
+
+// CI mode defaults, used unless --points / --duration are given
+const (
+	ciPoints   = 100000
+	ciDuration = 2
 )
 
 func init() {
 	// Disable colors if not in a terminal
 	if !isatty.IsTerminal(os.Stdout.Fd()) && !isatty.IsCygwinTerminal(os.Stdout.Fd()) {
-		colorReset = ""
-		colorRed = ""
-		colorGreen = ""
-		colorYellow = ""
-		colorBlue = ""
-		colorPurple = ""
-		colorCyan = ""
-		colorBold = ""
+		disableColors()
+	}
+}
+
+func disableColors() {
+	colorReset = ""
+	colorRed = ""
+	colorGreen = ""
+	colorYellow = ""
+	colorBlue = ""
+	colorPurple = ""
+	colorCyan = ""
+	colorBold = ""
+}
+
+// pause gives interactive viewers a moment between phases
+func pause() {
+	if !ciMode {
+		time.Sleep(500 * time.Millisecond)
 	}
 }
 
@@ -95,6 +101,9 @@ func printStat(label string, value interface{}) {
 }
 
 func printProgress(current, total int, label string) {
+	if ciMode {
+		return
+	}
 	percent := float64(current) / float64(total) * 100
 	barLength := 40
 	filled := int(percent / 100 * float64(barLength))
@@ -115,27 +124,72 @@ func printProgress(current, total int, label string) {
 	}
 }
 
-func loadConfig() error {
-	data, err := os.ReadFile("config.yaml")
-	if err != nil {
-		return fmt.Errorf("failed to read config.yaml: %w", err)
-	}
-	
-	if err := yaml.Unmarshal(data, &config); err != nil {
-		return fmt.Errorf("failed to parse config.yaml: %w", err)
+func main() {
+	var (
+		configPath  = flag.String("config", "", "YAML config file (default $GEOINDEX_CONFIG or ./config.yaml if present, else built-in defaults)")
+		points      = flag.Int("points", 0, "Number of points to generate (overrides demo.points)")
+		duration    = flag.Int("duration", 0, "Benchmark duration in seconds (overrides demo.benchmark_duration)")
+		postgisHost = flag.String("postgis-host", "", "PostGIS host (overrides postgis.host)")
+		postgisPort = flag.Int("postgis-port", 0, "PostGIS port (overrides postgis.port)")
+		database    = flag.String("database", "", "Database compared with the R-Tree: postgis, sqlite or redis (overrides demo.database)")
+		skipPostGIS = flag.Bool("skip-postgis", false, "Only benchmark the R-Tree")
+		withLatency = flag.Bool("network-latency", false, "Simulate network latency for PostGIS and Redis queries (network.simulated_latency_ms)")
+		ci          = flag.Bool("ci", false, fmt.Sprintf("Non-interactive smoke test: no progress bars, %d points and %ds benchmarks by default, JSON summary and exit status 1 on failed thresholds", ciPoints, ciDuration))
+		summaryPath = flag.String("summary", "", "Write a JSON summary to this file (default demo-summary.json with --ci)")
+		reportPath  = flag.String("report", "", "Write a shareable report with tables and charts to this file: .html or .md")
+		minQPS      = flag.Float64("min-qps", 1000, "CI threshold: minimum R-Tree queries per second (0 disables)")
+		maxLatency  = flag.Duration("max-latency", 10*time.Millisecond, "CI threshold: maximum average R-Tree query time (0 disables)")
+		minSpeedup  = flag.Float64("min-speedup", 0, "CI threshold: minimum R-Tree/PostGIS throughput ratio when PostGIS ran (0 disables)")
+	)
+	flag.Parse()
+
+	ciMode = *ci
+	if ciMode {
+		disableColors()
+		if *summaryPath == "" {
+			*summaryPath = "demo-summary.json"
+		}
 	}
-	
-	return nil
-}
 
-func main() {
 	// Load configuration
-	if err := loadConfig(); err != nil {
+	var err error
+	config, err = appconfig.Load(*configPath)
+	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
+	if ciMode {
+		config.Demo.Points = ciPoints
+		config.Demo.BenchmarkDuration = ciDuration
+	}
+	if *points > 0 {
+		config.Demo.Points = *points
+	}
+	if *duration > 0 {
+		config.Demo.BenchmarkDuration = *duration
+	}
+	if *postgisHost != "" {
+		config.PostGIS.Host = *postgisHost
+	}
+	if *postgisPort > 0 {
+		config.PostGIS.Port = *postgisPort
+	}
+	if *database != "" {
+		config.Demo.Database = *database
+	}
+	usePostGIS := false
+	switch config.Demo.Database {
+	case backend.SQLite:
+		databaseName = "SQLite"
+	case backend.Redis:
+		databaseName = "Redis"
+	case backend.PostGIS, "":
+		usePostGIS = true
+	default:
+		log.Fatalf("Unknown demo.database %q (use postgis, sqlite or redis)", config.Demo.Database)
+	}
 	
-	// Check for network latency simulation flag
-	if len(os.Args) > 1 && os.Args[1] == "--network-latency" {
+	// SQLite runs in process, so there is no network to simulate
+	if *withLatency && config.Demo.Database != backend.SQLite {
 		simulateNetworkLatency = true
 		networkLatency = time.Duration(config.Network.SimulatedLatencyMs) * time.Millisecond
 	}
@@ -145,28 +199,54 @@ func main() {
 	// Phase 1: Load Points
 	loadAndIndex()
 	
-	// Phase 2: R-Tree Bounding Box Queries
-	time.Sleep(500 * time.Millisecond)
+	// Phase 2: R-Tree box, radius and nearest neighbor queries
+	pause()
 	rtreeStats := runBenchmarks()
 	
-	// Phase 3: PostGIS Bounding Box Queries
-	time.Sleep(500 * time.Millisecond)
-	postgisStats := runPostGISBenchmark()
-	
-	// Phase 4: Radius Searches
-	// time.Sleep(500 * time.Millisecond)
-	// runRadiusSearches()
-	
-	// Phase 5: Nearest Neighbor
-	// time.Sleep(500 * time.Millisecond)
-	// runNearestNeighbors()
+	// Phase 3: The same three query types against the database
+	var postgisStats benchmarkStats
+	switch {
+	case *skipPostGIS:
+		printInfo(fmt.Sprintf("Skipping %s benchmark (--skip-postgis)", databaseName))
+	case usePostGIS:
+		pause()
+		postgisStats = runPostGISBenchmark()
+	default:
+		pause()
+		postgisStats = runDatabaseBenchmark(config.Demo.Database)
+	}
 	
 	// Summary
 	printComparison(rtreeStats, postgisStats)
 	printSummary()
 	
+	passed := true
+	if *summaryPath != "" {
+		summary := buildSummary(rtreeStats, postgisStats, thresholds{
+			MinQPS:       *minQPS,
+			MaxLatencyUs: float64(maxLatency.Microseconds()),
+			MinSpeedup:   *minSpeedup,
+		})
+		passed = summary.Passed
+		if err := writeSummary(*summaryPath, summary); err != nil {
+			log.Fatalf("Failed to write summary: %v", err)
+		}
+		printSuccess(fmt.Sprintf("Summary written to %s", *summaryPath))
+		for _, check := range summary.Checks {
+			if !check.Passed {
+				printError(fmt.Sprintf("Threshold failed: %s = %.1f (limit %.1f)", check.Name, check.Value, check.Threshold))
+			}
+		}
+	}
+	if *reportPath != "" {
+		if err := buildReport(rtreeStats, postgisStats).Write(*reportPath); err != nil {
+			log.Fatalf("Failed to write report: %v", err)
+		}
+		printSuccess(fmt.Sprintf("Report written to %s", *reportPath))
+	}
+	
 	// Stop PostGIS if it was used
-	if postgisStats.totalQueries > 0 {
+	if postgisStats.totalQueries > 0 && usePostGIS {
 		fmt.Println()
 		printInfo("Stopping PostGIS container...")
 		cmd := exec.Command("docker", "compose", "down")
@@ -176,16 +256,20 @@ func main() {
 			printSuccess("PostGIS container stopped")
 		}
 	}
+	
+	if ciMode && !passed {
+		os.Exit(1)
+	}
 }
 
 func loadAndIndex() {
 	// Check if index already exists
-	if fileInfo, err := os.Stat(indexFile); err == nil {
+	if fileInfo, err := os.Stat(config.Index.File); err == nil {
 		printSubtitle("Using Existing Index")
 		
 		// Load and verify the index
 		index := rtree.NewGeoIndex()
-		if err := index.LoadFromFile(indexFile); err != nil {
+		if err := index.LoadFromFile(config.Index.File); err != nil {
 			fmt.Printf("%sError loading existing index: %v%s\n", colorRed, err, colorReset)
 			fmt.Println("Regenerating index...")
 		} else {
@@ -205,7 +289,7 @@ func loadAndIndex() {
 				sizeStr = fmt.Sprintf("%d bytes", fileSize)
 			}
 			
-			printSuccess(fmt.Sprintf("Found existing index: %s", indexFile))
+			printSuccess(fmt.Sprintf("Found existing index: %s", config.Index.File))
 			fmt.Println()
 			printStat("Index file size", sizeStr)
 			printStat("Points indexed", fmt.Sprintf("%s%d%s", colorGreen, count, colorReset))
@@ -277,20 +361,90 @@ func loadAndIndex() {
 	loadTime := time.Since(start)
 	
 	// Save index
-	if err := index.SaveToFile(indexFile); err != nil {
+	if err := index.SaveToFile(config.Index.File); err != nil {
 		log.Printf("Error saving index: %v", err)
 	}
 	
 	fmt.Println()
 	printSuccess(fmt.Sprintf("Indexed %d points across %d partitions in %v", numPoints, numCPU, loadTime))
 	printSuccess(fmt.Sprintf("Indexing rate: %.0f points/second", float64(numPoints)/loadTime.Seconds()))
-	printSuccess(fmt.Sprintf("Index saved to %s", indexFile))
+	printSuccess(fmt.Sprintf("Index saved to %s", config.Index.File))
 }
 
 type benchmarkStats struct {
 	queriesPerSecond float64
 	avgQueryTime     time.Duration
 	totalQueries     int64
+	plan             *planSummary
+	batchQPS         float64
+	// Radius and nearest neighbor phases; the fields above describe box queries
+	radius *benchmarkStats
+	knn    *benchmarkStats
+}
+
+// planSummary aggregates EXPLAIN (ANALYZE, BUFFERS) results over sampled PostGIS queries
+type planSummary struct {
+	samples          int
+	nodeType         string
+	indexName        string
+	avgPlanningTime  time.Duration
+	avgExecutionTime time.Duration
+	avgRows          float64
+	sharedHitBlocks  int64
+	sharedReadBlocks int64
+}
+
+// randomQueryBox returns a random 0.1-2 degree box anywhere on the globe
+func randomQueryBox() models.BoundingBox {
+	centerLat := rand.Float64()*180 - 90
+	centerLon := rand.Float64()*360 - 180
+	boxSize := rand.Float64()*1.9 + 0.1
+	
+	return models.BoundingBox{
+		BottomLeft: models.Location{Lat: centerLat - boxSize/2, Lon: centerLon - boxSize/2},
+		TopRight:   models.Location{Lat: centerLat + boxSize/2, Lon: centerLon + boxSize/2},
+	}
+}
+
+// explainPostGISQueries runs EXPLAIN ANALYZE on n random box queries and summarizes the plans
+func explainPostGISQueries(ctx context.Context, db *postgis.PostGISIndex, n int) *planSummary {
+	summary := &planSummary{}
+	nodeTypes := make(map[string]int)
+	var planning, execution time.Duration
+	var rows int64
+	
+	for i := 0; i < n; i++ {
+		plan, err := db.ExplainBox(ctx, randomQueryBox())
+		if err != nil {
+			printError(fmt.Sprintf("EXPLAIN failed: %v", err))
+			continue
+		}
+		summary.samples++
+		nodeTypes[plan.NodeType]++
+		if plan.IndexName != "" {
+			summary.indexName = plan.IndexName
+		}
+		planning += plan.PlanningTime
+		execution += plan.ExecutionTime
+		rows += plan.ActualRows
+		summary.sharedHitBlocks += plan.SharedHitBlocks
+		summary.sharedReadBlocks += plan.SharedReadBlocks
+	}
+	
+	if summary.samples == 0 {
+		return nil
+	}
+	
+	for nodeType, count := range nodeTypes {
+		if count > nodeTypes[summary.nodeType] {
+			summary.nodeType = nodeType
+		}
+	}
+	summary.avgPlanningTime = planning / time.Duration(summary.samples)
+	summary.avgExecutionTime = execution / time.Duration(summary.samples)
+	summary.avgRows = float64(rows) / float64(summary.samples)
+	
+	return summary
 }
 
 func runBenchmarks() benchmarkStats {
@@ -298,7 +452,7 @@ func runBenchmarks() benchmarkStats {
 	
 	// Load index
 	index := rtree.NewGeoIndex()
-	if err := index.LoadFromFile(indexFile); err != nil {
+	if err := index.LoadFromFile(config.Index.File); err != nil {
 		log.Fatalf("Failed to load index: %v", err)
 	}
 	
@@ -309,67 +463,30 @@ func runBenchmarks() benchmarkStats {
 	fmt.Printf("R-Tree advantage: Each query %sinternally uses %d CPU cores%s\n",
 		colorGreen, runtime.NumCPU(), colorReset)
 	
-	// Run benchmark
-	var queryCount atomic.Int64
-	
-	start := time.Now()
-	deadline := start.Add(benchDuration)
-	
-	// Progress reporter
-	done := make(chan bool)
-	go func() {
-		ticker := time.NewTicker(100 * time.Millisecond)
-		defer ticker.Stop()
-		
-		for {
-			select {
-			case <-done:
-				fmt.Println()
-				return
-			case <-ticker.C:
-				elapsed := time.Since(start)
-				percent := elapsed.Seconds() / benchDuration.Seconds() * 100
-				if percent > 100 {
-					percent = 100
-				}
-				printProgress(int(percent), 100, "Benchmarking")
-			}
-		}
-	}()
-	
 	// Single-threaded benchmark
-	for time.Now().Before(deadline) {
-		// Random query
-		centerLat := rand.Float64()*180 - 90
-		centerLon := rand.Float64()*360 - 180
-		boxSize := rand.Float64()*1.9 + 0.1
-		
-		box := models.BoundingBox{
-			BottomLeft: models.Location{Lat: centerLat - boxSize/2, Lon: centerLon - boxSize/2},
-			TopRight: models.Location{Lat: centerLat + boxSize/2, Lon: centerLon + boxSize/2},
-		}
-		
-		_, err := index.QueryBox(box)
-		if err == nil {
-			queryCount.Add(1)
-		}
-	}
-	done <- true
-	elapsed := time.Since(start)
+	stats := timedQueries(func() error {
+		_, err := index.QueryBox(randomQueryBox())
+		return err
+	}, 0)
 	
-	completedQueries := queryCount.Load()
 	fmt.Println()
 	printSuccess("R-Tree Bounding Box Queries Complete!")
-	printStat("Total queries", fmt.Sprintf("%d", completedQueries))
-	printStat("Queries per second", fmt.Sprintf("%s%.0f%s", colorGreen, float64(completedQueries)/elapsed.Seconds(), colorReset))
-	printStat("Average query time", fmt.Sprintf("%s%v%s", colorGreen, elapsed/time.Duration(completedQueries), colorReset))
+	printQueryStats(stats, colorGreen)
 	printInfo(fmt.Sprintf("Each query internally searched %d partitions in parallel", runtime.NumCPU()))
 	
-	return benchmarkStats{
-		queriesPerSecond: float64(completedQueries)/elapsed.Seconds(),
-		avgQueryTime:     elapsed/time.Duration(completedQueries),
-		totalQueries:     completedQueries,
-	}
+	// Radius and nearest neighbor phases on the same index
+	radius := runRadiusSearches("R-Tree", colorGreen, func(center models.Location) error {
+		_, err := index.QueryRadius(center, config.Demo.RadiusKm)
+		return err
+	}, 0)
+	knn := runNearestNeighbors("R-Tree", colorGreen, func(center models.Location) error {
+		index.NearestNeighbors(center, config.Demo.Neighbors)
+		return nil
+	}, 0)
+	
+	stats.radius = &radius
+	stats.knn = &knn
+	return stats
 }
 
 func runPostGISBenchmark() benchmarkStats {
@@ -395,14 +512,16 @@ func runPostGISBenchmark() benchmarkStats {
 	}
 	defer db.Close()
 	printSuccess("Connected to PostGIS")
+
+	ctx := context.Background()
 	
 	// Check if data is already loaded
-	count, err := db.Count()
+	count, err := db.Count(ctx)
 	if err == nil && count >= int64(config.Demo.Points) {
 		printSuccess(fmt.Sprintf("Found existing PostGIS data with %d points", count))
 		
 		// Get and display database statistics
-		stats, err := db.GetDatabaseStats()
+		stats, err := db.GetDatabaseStats(ctx)
 		if err == nil {
 			fmt.Println()
 			printStat("Database size", stats["database_size"])
@@ -416,7 +535,7 @@ func runPostGISBenchmark() benchmarkStats {
 		printInfo("Loading points into PostGIS...")
 		
 		// Initialize schema
-		if err := db.InitSchema(); err != nil {
+		if err := db.InitSchema(ctx); err != nil {
 			log.Printf("Failed to initialize schema: %v", err)
 			return benchmarkStats{}
 		}
@@ -437,7 +556,7 @@ func runPostGISBenchmark() benchmarkStats {
 		}
 		
 		fmt.Println() // New line for progress bar
-		err = db.BulkInsertPoints(points, progressCallback)
+		err = db.BulkInsertPoints(ctx, points, progressCallback)
 		fmt.Println() // Clear line after progress
 		
 		if err != nil {
@@ -451,7 +570,7 @@ func runPostGISBenchmark() benchmarkStats {
 		// Create spatial index
 		printInfo("Creating spatial index...")
 		indexStart := time.Now()
-		if err := db.CreateSpatialIndex(); err != nil {
+		if err := db.CreateSpatialIndex(ctx); err != nil {
 			log.Printf("Failed to create spatial index: %v", err)
 			return benchmarkStats{}
 		}
@@ -472,7 +591,157 @@ func runPostGISBenchmark() benchmarkStats {
 		fmt.Printf("%sSimulating network latency: +%v per query%s\n", colorCyan, networkLatency, colorReset)
 	}
 	
-	// Run benchmark
+	// Single-threaded benchmark, sleeping after each query to simulate network latency
+	var latency time.Duration
+	if simulateNetworkLatency {
+		latency = networkLatency
+	}
+	stats := timedQueries(func() error {
+		_, err := db.QueryBox(ctx, randomQueryBox())
+		return err
+	}, latency)
+	
+	fmt.Println()
+	printSuccess("PostGIS Bounding Box Queries Complete!")
+	printQueryStats(stats, colorYellow)
+	if simulateNetworkLatency {
+		printInfo(fmt.Sprintf("Each query included %v simulated network latency", networkLatency))
+	} else {
+		printInfo("Each query executed sequentially without parallelism")
+	}
+	
+	// Sample query plans outside the timed loop so EXPLAIN overhead doesn't skew throughput
+	var plan *planSummary
+	if config.PostGIS.ExplainSamples > 0 {
+		fmt.Println()
+		printInfo(fmt.Sprintf("Running EXPLAIN (ANALYZE, BUFFERS) on %d sample queries...", config.PostGIS.ExplainSamples))
+		plan = explainPostGISQueries(ctx, db, config.PostGIS.ExplainSamples)
+	}
+	
+	// A batch-capable client pays the network round trip once per batch
+	if simulateNetworkLatency && config.Network.BatchSize > 1 {
+		stats.batchQPS = runPostGISBatchBenchmark(ctx, db, benchDuration, config.Network.BatchSize)
+	}
+	stats.plan = plan
+	
+	// Radius and nearest neighbor phases against the same table
+	radius := runRadiusSearches("PostGIS", colorYellow, func(center models.Location) error {
+		_, err := db.QueryRadius(ctx, center, config.Demo.RadiusKm)
+		return err
+	}, latency)
+	knn := runNearestNeighbors("PostGIS", colorYellow, func(center models.Location) error {
+		_, err := db.NearestNeighbors(ctx, center, config.Demo.Neighbors)
+		return err
+	}, latency)
+	
+	stats.radius = &radius
+	stats.knn = &knn
+	return stats
+}
+
+// runDatabaseBenchmark runs the box, radius and nearest neighbor phases
+// against the SQLite R*Tree or Redis GEO backend, the comparisons that need
+// no PostGIS. Points already in the database are reused like PostGIS data.
+func runDatabaseBenchmark(name string) benchmarkStats {
+	printSubtitle(fmt.Sprintf("Running %s Bounding Box Queries", databaseName))
+	
+	hint := "Run 'make redis-up' to start Redis"
+	if name == backend.SQLite {
+		printInfo(fmt.Sprintf("Opening SQLite database %s...", config.SQLite.Path))
+		hint = "Build the demo with -tags sqlite to link the SQLite driver"
+	} else {
+		printInfo(fmt.Sprintf("Connecting to Redis at %s...", config.Redis.Addr))
+	}
+	c := *config
+	c.Index.Backend = name
+	index, err := backend.Open(&c)
+	if err != nil {
+		printError(fmt.Sprintf("%s unavailable: %v", databaseName, err))
+		printInfo(fmt.Sprintf("Skipping %s benchmark. %s", databaseName, hint))
+		fmt.Println()
+		return benchmarkStats{}
+	}
+	defer backend.Close(index)
+	db := index.(backend.ContextIndex)
+	ctx := context.Background()
+	
+	count := db.Count()
+	if err := backend.Err(db); err != nil {
+		log.Printf("Failed to count points: %v", err)
+		return benchmarkStats{}
+	}
+	if count >= int64(config.Demo.Points) {
+		printSuccess(fmt.Sprintf("Found existing %s data with %d points", databaseName, count))
+	} else {
+		printInfo(fmt.Sprintf("Loading points into %s...", databaseName))
+		points := generateRandomPoints(config.Demo.Points)
+		
+		// One call per batch so the progress bar moves
+		const batchSize = 100000
+		start := time.Now()
+		fmt.Println()
+		for i := 0; i < len(points); i += batchSize {
+			end := min(i+batchSize, len(points))
+			if err := db.IndexPoints(points[i:end]); err != nil {
+				log.Printf("Failed to insert points: %v", err)
+				return benchmarkStats{}
+			}
+			printProgress(end, len(points), fmt.Sprintf("Loading %d points", len(points)))
+		}
+		printSuccess(fmt.Sprintf("Loaded %d points in %v", db.Count(), time.Since(start)))
+		if name == backend.Redis {
+			printInfo("Redis cannot store latitudes beyond ±85.05°, so points there were skipped")
+		}
+	}
+	
+	// SQLite runs in process; Redis pays the simulated round trip like PostGIS
+	var latency time.Duration
+	if simulateNetworkLatency && name != backend.SQLite {
+		latency = networkLatency
+	}
+	
+	benchDuration := time.Duration(config.Demo.BenchmarkDuration) * time.Second
+	fmt.Printf("Running %ssingle-threaded%s benchmark for %s%v%s\n", 
+		colorBold, colorReset, colorBold, benchDuration, colorReset)
+	if name == backend.SQLite {
+		fmt.Printf("SQLite: Each query runs %ssequentially%s in process (no network)\n", colorYellow, colorReset)
+	} else {
+		fmt.Printf("Redis: Each query is %sone GEOSEARCH round trip%s, run sequentially\n", colorYellow, colorReset)
+	}
+	if latency > 0 {
+		fmt.Printf("%sSimulating network latency: +%v per query%s\n", colorCyan, latency, colorReset)
+	}
+	
+	stats := timedQueries(func() error {
+		_, err := db.QueryBoxContext(ctx, randomQueryBox())
+		return err
+	}, latency)
+	
+	fmt.Println()
+	printSuccess(fmt.Sprintf("%s Bounding Box Queries Complete!", databaseName))
+	printQueryStats(stats, colorYellow)
+	
+	radius := runRadiusSearches(databaseName, colorYellow, func(center models.Location) error {
+		_, err := db.QueryRadiusContext(ctx, center, config.Demo.RadiusKm)
+		return err
+	}, latency)
+	knn := runNearestNeighbors(databaseName, colorYellow, func(center models.Location) error {
+		_, err := db.NearestNeighborsContext(ctx, center, config.Demo.Neighbors)
+		return err
+	}, latency)
+	
+	stats.radius = &radius
+	stats.knn = &knn
+	return stats
+}
+
+// runPostGISBatchBenchmark sends batchSize box queries per round trip via
+// QueryBoxes and returns the effective queries per second
+func runPostGISBatchBenchmark(ctx context.Context, db *postgis.PostGISIndex, benchDuration time.Duration, batchSize int) float64 {
+	fmt.Println()
+	fmt.Printf("Running %sbatched%s benchmark (%d queries per round trip) for %s%v%s\n",
+		colorBold, colorReset, batchSize, colorBold, benchDuration, colorReset)
+	
 	var queryCount atomic.Int64
 	
 	start := time.Now()
@@ -500,47 +769,29 @@ func runPostGISBenchmark() benchmarkStats {
 		}
 	}()
 	
-	// Single-threaded benchmark
+	boxes := make([]models.BoundingBox, batchSize)
 	for time.Now().Before(deadline) {
-		// Random query
-		centerLat := rand.Float64()*180 - 90
-		centerLon := rand.Float64()*360 - 180
-		boxSize := rand.Float64()*1.9 + 0.1
-		
-		box := models.BoundingBox{
-			BottomLeft: models.Location{Lat: centerLat - boxSize/2, Lon: centerLon - boxSize/2},
-			TopRight: models.Location{Lat: centerLat + boxSize/2, Lon: centerLon + boxSize/2},
+		for i := range boxes {
+			boxes[i] = randomQueryBox()
 		}
 		
-		_, err := db.QueryBox(box)
+		_, err := db.QueryBoxes(ctx, boxes)
 		if err == nil {
-			queryCount.Add(1)
-			// Simulate network latency
-			if simulateNetworkLatency {
-				time.Sleep(networkLatency)
-			}
+			queryCount.Add(int64(batchSize))
+			time.Sleep(networkLatency)
 		}
 	}
 	done <- true
 	elapsed := time.Since(start)
 	
-	completedQueries := queryCount.Load()
+	qps := float64(queryCount.Load()) / elapsed.Seconds()
 	fmt.Println()
-	printSuccess("PostGIS Bounding Box Queries Complete!")
-	printStat("Total queries", fmt.Sprintf("%d", completedQueries))
-	printStat("Queries per second", fmt.Sprintf("%s%.0f%s", colorYellow, float64(completedQueries)/elapsed.Seconds(), colorReset))
-	printStat("Average query time", fmt.Sprintf("%s%v%s", colorYellow, elapsed/time.Duration(completedQueries), colorReset))
-	if simulateNetworkLatency {
-		printInfo(fmt.Sprintf("Each query included %v simulated network latency", networkLatency))
-	} else {
-		printInfo("Each query executed sequentially without parallelism")
-	}
+	printSuccess("PostGIS Batched Queries Complete!")
+	printStat("Total queries", fmt.Sprintf("%d", queryCount.Load()))
+	printStat("Queries per second", fmt.Sprintf("%s%.0f%s", colorYellow, qps, colorReset))
+	printInfo(fmt.Sprintf("Each batch of %d queries paid %v simulated network latency once", batchSize, networkLatency))
 	
-	return benchmarkStats{
-		queriesPerSecond: float64(completedQueries)/elapsed.Seconds(),
-		avgQueryTime:     elapsed/time.Duration(completedQueries),
-		totalQueries:     completedQueries,
-	}
+	return qps
 }
 
 func printError(message string) {
@@ -553,13 +804,13 @@ func printComparison(rtreeStats, postgisStats benchmarkStats) {
 	fmt.Printf("\n%sSingle-Threaded Benchmark Results:%s\n", colorBold, colorReset)
 	fmt.Printf("• R-Tree: Each query %sinternally parallelized%s across %d CPU partitions\n", colorGreen, colorReset, runtime.NumCPU())
 	if simulateNetworkLatency {
-		fmt.Printf("• PostGIS: Each query runs %ssequentially%s with %s%v network latency%s\n\n", 
-			colorYellow, colorReset, colorCyan, networkLatency, colorReset)
+		fmt.Printf("• %s: Each query runs %ssequentially%s with %s%v network latency%s\n\n", 
+			databaseName, colorYellow, colorReset, colorCyan, networkLatency, colorReset)
 	} else {
-		fmt.Printf("• PostGIS: Each query runs %ssequentially%s without parallelism\n\n", colorYellow, colorReset)
+		fmt.Printf("• %s: Each query runs %ssequentially%s without parallelism\n\n", databaseName, colorYellow, colorReset)
 	}
 	
-	fmt.Printf("%s%-20s %-30s %-30s%s\n", colorBold, "Metric", "R-Tree (Internal Parallel)", "PostGIS (Sequential)", colorReset)
+	fmt.Printf("%s%-20s %-30s %-30s%s\n", colorBold, "Metric", "R-Tree (Internal Parallel)", databaseName+" (Sequential)", colorReset)
 	fmt.Println(strings.Repeat("-", 80))
 	
 	// Queries per second
@@ -582,6 +833,12 @@ func printComparison(rtreeStats, postgisStats benchmarkStats) {
 		colorGreen, rtreeAvg, colorReset,
 		colorYellow, postgisAvg, colorReset)
 	
+	if postgisStats.batchQPS > 0 {
+		fmt.Printf("%-20s %s%-30s%s %s%-30s%s\n", "Batched queries/s",
+			colorGreen, "N/A", colorReset,
+			colorYellow, fmt.Sprintf("%.0f", postgisStats.batchQPS), colorReset)
+	}
+	
 	// Total queries
 	fmt.Printf("%-20s %-30d", "Total queries", rtreeStats.totalQueries)
 	if postgisStats.totalQueries > 0 {
@@ -590,10 +847,40 @@ func printComparison(rtreeStats, postgisStats benchmarkStats) {
 		fmt.Printf(" %-30s\n", "N/A")
 	}
 	
+	// Radius and nearest neighbor phases
+	for _, phase := range []struct {
+		name           string
+		rtree, postgis *benchmarkStats
+	}{
+		{fmt.Sprintf("Radius %.0fkm", config.Demo.RadiusKm), rtreeStats.radius, postgisStats.radius},
+		{fmt.Sprintf("KNN k=%d", config.Demo.Neighbors), rtreeStats.knn, postgisStats.knn},
+	} {
+		if phase.rtree == nil {
+			continue
+		}
+		postgisQPS, postgisAvg := "N/A", "N/A"
+		if phase.postgis != nil && phase.postgis.queriesPerSecond > 0 {
+			postgisQPS = fmt.Sprintf("%.0f", phase.postgis.queriesPerSecond)
+			postgisAvg = phase.postgis.avgQueryTime.String()
+		}
+		fmt.Printf("%-20s %s%-30s%s %s%-30s%s\n", phase.name+" q/s",
+			colorGreen, fmt.Sprintf("%.0f", phase.rtree.queriesPerSecond), colorReset,
+			colorYellow, postgisQPS, colorReset)
+		fmt.Printf("%-20s %s%-30s%s %s%-30s%s\n", phase.name+" avg",
+			colorGreen, phase.rtree.avgQueryTime.String(), colorReset,
+			colorYellow, postgisAvg, colorReset)
+	}
+	
 	// Performance ratio
 	if postgisStats.queriesPerSecond > 0 {
 		ratio := rtreeStats.queriesPerSecond / postgisStats.queriesPerSecond
-		fmt.Printf("\n%sR-Tree is %.1fx faster than PostGIS%s\n", colorBold, ratio, colorReset)
+		fmt.Printf("\n%sR-Tree is %.1fx faster than %s%s for bounding box queries\n", colorBold, ratio, databaseName, colorReset)
+		if r := speedup(rtreeStats.radius, postgisStats.radius); r > 0 {
+			fmt.Printf("%sR-Tree is %.1fx faster than %s%s for radius queries\n", colorBold, r, databaseName, colorReset)
+		}
+		if r := speedup(rtreeStats.knn, postgisStats.knn); r > 0 {
+			fmt.Printf("%sR-Tree is %.1fx faster than %s%s for nearest neighbor queries\n", colorBold, r, databaseName, colorReset)
+		}
 		if simulateNetworkLatency {
 			fmt.Printf("This represents %sreal-world cloud/remote database performance%s\n", 
 				colorCyan, colorReset)
@@ -607,108 +894,71 @@ func printComparison(rtreeStats, postgisStats benchmarkStats) {
 		}
 	}
 	
+	if plan := postgisStats.plan; plan != nil {
+		fmt.Printf("\n%sPostGIS Query Plan (%d sampled queries):%s\n", colorBold, plan.samples, colorReset)
+		printStat("Plan node", plan.nodeType)
+		if plan.indexName != "" {
+			printStat("Index used", plan.indexName)
+		}
+		printStat("Avg planning time", plan.avgPlanningTime.String())
+		printStat("Avg execution time", plan.avgExecutionTime.String())
+		printStat("Avg rows returned", fmt.Sprintf("%.1f", plan.avgRows))
+		printStat("Shared buffers hit/read", fmt.Sprintf("%d/%d", plan.sharedHitBlocks, plan.sharedReadBlocks))
+		if overhead := postgisStats.avgQueryTime - plan.avgExecutionTime - plan.avgPlanningTime; overhead > 0 && !simulateNetworkLatency {
+			printInfo(fmt.Sprintf("~%v of each PostGIS query is spent outside the executor (protocol, parsing, result transfer)", overhead))
+		}
+	}
+	
 	fmt.Println()
 }
 
-func runRadiusSearches() {
-	printSubtitle("Running Radius Searches")
-	
-	// Load index
-	index := rtree.NewGeoIndex()
-	if err := index.LoadFromFile(indexFile); err != nil {
-		log.Fatalf("Failed to load index: %v", err)
-	}
-	
-	benchDuration := 10 * time.Second
-	numWorkers := runtime.NumCPU()
-	searchRadius := 50.0 // km
-	
-	fmt.Printf("Running radius searches (%s%.0f km%s) for %s%v%s using %s%d%s workers...\n",
-		colorBold, searchRadius, colorReset,
-		colorBold, benchDuration, colorReset, 
-		colorBold, numWorkers, colorReset)
-	
-	// Run benchmark
-	var queryCount atomic.Int64
-	
-	start := time.Now()
-	deadline := start.Add(benchDuration)
-	
-	// Progress reporter
-	done := make(chan bool)
-	go func() {
-		ticker := time.NewTicker(100 * time.Millisecond)
-		defer ticker.Stop()
-		
-		for {
-			select {
-			case <-done:
-				fmt.Println()
-				return
-			case <-ticker.C:
-				elapsed := time.Since(start)
-				percent := elapsed.Seconds() / benchDuration.Seconds() * 100
-				if percent > 100 {
-					percent = 100
-				}
-				printProgress(int(percent), 100, "Benchmarking")
-			}
-		}
-	}()
+// runRadiusSearches benchmarks radius queries around random centers
+func runRadiusSearches(engine, color string, query func(models.Location) error, latency time.Duration) benchmarkStats {
+	printSubtitle(fmt.Sprintf("Running %s Radius Searches", engine))
+	fmt.Printf("Running %ssingle-threaded%s %s%.0f km%s radius searches for %s%ds%s\n",
+		colorBold, colorReset, colorBold, config.Demo.RadiusKm, colorReset,
+		colorBold, config.Demo.BenchmarkDuration, colorReset)
 	
-	var wg sync.WaitGroup
+	stats := timedQueries(func() error {
+		return query(randomLocation())
+	}, latency)
 	
-	for w := 0; w < numWorkers; w++ {
-		wg.Add(1)
-		
-		go func() {
-			defer wg.Done()
-			
-			for time.Now().Before(deadline) {
-				// Random center point
-				center := models.Location{
-					Lat: rand.Float64()*180 - 90,
-					Lon: rand.Float64()*360 - 180,
-				}
-				
-				_, err := index.QueryRadius(center, searchRadius)
-				if err == nil {
-					queryCount.Add(1)
-				}
-			}
-		}()
-	}
+	fmt.Println()
+	printSuccess(fmt.Sprintf("%s Radius Searches Complete!", engine))
+	printQueryStats(stats, color)
+	return stats
+}
+
+// runNearestNeighbors benchmarks k-nearest-neighbor queries around random centers
+func runNearestNeighbors(engine, color string, query func(models.Location) error, latency time.Duration) benchmarkStats {
+	printSubtitle(fmt.Sprintf("Running %s Nearest Neighbor Searches", engine))
+	fmt.Printf("Finding %s%d%s nearest neighbors (%ssingle-threaded%s) for %s%ds%s\n",
+		colorBold, config.Demo.Neighbors, colorReset, colorBold, colorReset,
+		colorBold, config.Demo.BenchmarkDuration, colorReset)
 	
-	wg.Wait()
-	done <- true
-	elapsed := time.Since(start)
+	stats := timedQueries(func() error {
+		return query(randomLocation())
+	}, latency)
 	
-	completedQueries := queryCount.Load()
 	fmt.Println()
-	printSuccess("Radius Searches Complete!")
-	printStat("Queries per second", fmt.Sprintf("%s%.0f%s", colorGreen, float64(completedQueries)/elapsed.Seconds(), colorReset))
-	printStat("Average query time", fmt.Sprintf("%s%v%s", colorGreen, elapsed/time.Duration(completedQueries), colorReset))
+	printSuccess(fmt.Sprintf("%s Nearest Neighbor Searches Complete!", engine))
+	printQueryStats(stats, color)
+	return stats
 }
 
-func runNearestNeighbors() {
-	printSubtitle("Running Nearest Neighbor Searches")
-	
-	// Load index
-	index := rtree.NewGeoIndex()
-	if err := index.LoadFromFile(indexFile); err != nil {
-		log.Fatalf("Failed to load index: %v", err)
+// randomLocation returns a uniformly random coordinate
+func randomLocation() models.Location {
+	return models.Location{
+		Lat: rand.Float64()*180 - 90,
+		Lon: rand.Float64()*360 - 180,
 	}
-	
-	benchDuration := 10 * time.Second
-	numWorkers := runtime.NumCPU()
-	numNeighbors := 10
-	
-	fmt.Printf("Finding %s%d%s nearest neighbors for %s%v%s using %s%d%s workers...\n",
-		colorBold, numNeighbors, colorReset,
-		colorBold, benchDuration, colorReset,
-		colorBold, numWorkers, colorReset)
-	
-	// Run benchmark
+}
+
+// timedQueries runs query back to back on one goroutine for the configured
+// benchmark duration with a progress bar. latency is slept after each
+// successful query to simulate a network round trip.
+func timedQueries(query func() error, latency time.Duration) benchmarkStats {
+	benchDuration := time.Duration(config.Demo.BenchmarkDuration) * time.Second
 	var queryCount atomic.Int64
 	
 	start := time.Now()
@@ -736,36 +986,39 @@ func runNearestNeighbors() {
 		}
 	}()
 	
-	var wg sync.WaitGroup
-	
-	for w := 0; w < numWorkers; w++ {
-		wg.Add(1)
-		
-		go func() {
-			defer wg.Done()
-			
-			for time.Now().Before(deadline) {
-				// Random query point
-				center := models.Location{
-					Lat: rand.Float64()*180 - 90,
-					Lon: rand.Float64()*360 - 180,
-				}
-				
-				_ = index.NearestNeighbors(center, numNeighbors)
-				queryCount.Add(1)
+	for time.Now().Before(deadline) {
+		if err := query(); err == nil {
+			queryCount.Add(1)
+			if latency > 0 {
+				time.Sleep(latency)
 			}
-		}()
+		}
 	}
-	
-	wg.Wait()
 	done <- true
 	elapsed := time.Since(start)
 	
-	completedQueries := queryCount.Load()
-	fmt.Println()
-	printSuccess("Nearest Neighbor Searches Complete!")
-	printStat("Queries per second", fmt.Sprintf("%s%.0f%s", colorGreen, float64(completedQueries)/elapsed.Seconds(), colorReset))
-	printStat("Average query time", fmt.Sprintf("%s%v%s", colorGreen, elapsed/time.Duration(completedQueries), colorReset))
+	stats := benchmarkStats{
+		queriesPerSecond: float64(queryCount.Load()) / elapsed.Seconds(),
+		totalQueries:     queryCount.Load(),
+	}
+	if stats.totalQueries > 0 {
+		stats.avgQueryTime = elapsed / time.Duration(stats.totalQueries)
+	}
+	return stats
+}
+
+func printQueryStats(stats benchmarkStats, color string) {
+	printStat("Total queries", fmt.Sprintf("%d", stats.totalQueries))
+	printStat("Queries per second", fmt.Sprintf("%s%.0f%s", color, stats.queriesPerSecond, colorReset))
+	printStat("Average query time", fmt.Sprintf("%s%v%s", color, stats.avgQueryTime, colorReset))
+}
+
+// speedup returns the R-Tree/PostGIS throughput ratio, or 0 if either side is missing
+func speedup(rtreeStats, postgisStats *benchmarkStats) float64 {
+	if rtreeStats == nil || postgisStats == nil || postgisStats.queriesPerSecond == 0 {
+		return 0
+	}
+	return rtreeStats.queriesPerSecond / postgisStats.queriesPerSecond
 }
 
 func printSummary() {
@@ -783,6 +1036,185 @@ func printSummary() {
 	fmt.Println()
 }
 
+// thresholds are the pass/fail limits checked in the summary; zero disables a check
+type thresholds struct {
+	MinQPS       float64 `json:"min_qps,omitempty"`
+	MaxLatencyUs float64 `json:"max_avg_latency_us,omitempty"`
+	MinSpeedup   float64 `json:"min_speedup,omitempty"`
+}
+
+type engineSummary struct {
+	QPS          float64 `json:"qps"`
+	AvgLatencyUs float64 `json:"avg_latency_us"`
+	TotalQueries int64   `json:"total_queries"`
+	BatchQPS     float64 `json:"batch_qps,omitempty"`
+	// Radius and KNN are set on the top-level (box query) summary of each engine
+	Radius *engineSummary `json:"radius,omitempty"`
+	KNN    *engineSummary `json:"knn,omitempty"`
+}
+
+type thresholdCheck struct {
+	Name      string  `json:"name"`
+	Value     float64 `json:"value"`
+	Threshold float64 `json:"threshold"`
+	Passed    bool    `json:"passed"`
+}
+
+// demoSummary is the machine-readable result written with --summary / --ci
+type demoSummary struct {
+	Points          int              `json:"points"`
+	DurationSeconds int              `json:"duration_seconds"`
+	CPUs            int              `json:"cpus"`
+	NetworkLatency  string           `json:"network_latency,omitempty"`
+	RTree           engineSummary    `json:"rtree"`
+	// PostGIS holds the results of the compared database, named by Database
+	PostGIS         *engineSummary   `json:"postgis,omitempty"`
+	Database        string           `json:"database,omitempty"`
+	Speedup         float64          `json:"speedup,omitempty"`
+	RadiusSpeedup   float64          `json:"radius_speedup,omitempty"`
+	KNNSpeedup      float64          `json:"knn_speedup,omitempty"`
+	Thresholds      thresholds       `json:"thresholds"`
+	Checks          []thresholdCheck `json:"checks"`
+	Passed          bool             `json:"passed"`
+}
+
+func newEngineSummary(stats benchmarkStats) engineSummary {
+	summary := engineSummary{
+		QPS:          stats.queriesPerSecond,
+		AvgLatencyUs: float64(stats.avgQueryTime.Nanoseconds()) / 1000,
+		TotalQueries: stats.totalQueries,
+		BatchQPS:     stats.batchQPS,
+	}
+	if stats.radius != nil {
+		radius := newEngineSummary(*stats.radius)
+		summary.Radius = &radius
+	}
+	if stats.knn != nil {
+		knn := newEngineSummary(*stats.knn)
+		summary.KNN = &knn
+	}
+	return summary
+}
+
+func buildSummary(rtreeStats, postgisStats benchmarkStats, limits thresholds) demoSummary {
+	summary := demoSummary{
+		Points:          config.Demo.Points,
+		DurationSeconds: config.Demo.BenchmarkDuration,
+		CPUs:            runtime.NumCPU(),
+		RTree:           newEngineSummary(rtreeStats),
+		Thresholds:      limits,
+		Checks:          []thresholdCheck{},
+		Passed:          true,
+	}
+	if simulateNetworkLatency {
+		summary.NetworkLatency = networkLatency.String()
+	}
+	if postgisStats.totalQueries > 0 {
+		pg := newEngineSummary(postgisStats)
+		summary.PostGIS = &pg
+		summary.Database = databaseName
+		summary.Speedup = rtreeStats.queriesPerSecond / postgisStats.queriesPerSecond
+		summary.RadiusSpeedup = speedup(rtreeStats.radius, postgisStats.radius)
+		summary.KNNSpeedup = speedup(rtreeStats.knn, postgisStats.knn)
+	}
+	
+	check := func(name string, value, threshold float64, ok bool) {
+		summary.Checks = append(summary.Checks, thresholdCheck{Name: name, Value: value, Threshold: threshold, Passed: ok})
+		summary.Passed = summary.Passed && ok
+	}
+	if limits.MinQPS > 0 {
+		check("rtree_qps", summary.RTree.QPS, limits.MinQPS, summary.RTree.QPS >= limits.MinQPS)
+	}
+	if limits.MaxLatencyUs > 0 {
+		check("rtree_avg_latency_us", summary.RTree.AvgLatencyUs, limits.MaxLatencyUs, summary.RTree.AvgLatencyUs <= limits.MaxLatencyUs)
+	}
+	if limits.MinSpeedup > 0 && summary.PostGIS != nil {
+		check("speedup", summary.Speedup, limits.MinSpeedup, summary.Speedup >= limits.MinSpeedup)
+	}
+	return summary
+}
+
+func writeSummary(path string, summary demoSummary) error {
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// buildReport compares the R-Tree with the database query type by query type
+func buildReport(rtreeStats, postgisStats benchmarkStats) *report.Report {
+	engines := []string{"R-Tree"}
+	ranDatabase := postgisStats.totalQueries > 0
+	if ranDatabase {
+		engines = append(engines, databaseName)
+	}
+	
+	r := &report.Report{
+		Title:     "Go Geo-Index Demo: R-Tree vs " + databaseName,
+		Generated: time.Now(),
+		Context: []report.Field{
+			{Name: "Points", Value: fmt.Sprintf("%d", config.Demo.Points)},
+			{Name: "Benchmark duration", Value: fmt.Sprintf("%d seconds per test", config.Demo.BenchmarkDuration)},
+			{Name: "CPU cores", Value: fmt.Sprintf("%d", runtime.NumCPU())},
+			{Name: "Go version", Value: runtime.Version()},
+		},
+	}
+	note := fmt.Sprintf("Each R-Tree query is internally parallelized across %d CPU partitions; each %s query runs sequentially", runtime.NumCPU(), databaseName)
+	if simulateNetworkLatency {
+		r.Context = append(r.Context, report.Field{Name: "Simulated network latency", Value: networkLatency.String()})
+		note += fmt.Sprintf(" with %v of simulated network latency", networkLatency)
+	}
+	r.Notes = append(r.Notes, note+". Both use single-threaded query generation.")
+	if !ranDatabase {
+		r.Notes = append(r.Notes, databaseName+" was not benchmarked.")
+	}
+	if plan := postgisStats.plan; plan != nil {
+		r.Notes = append(r.Notes, fmt.Sprintf("PostGIS plan over %d sampled queries: %s %s, %v planning and %v execution on average.",
+			plan.samples, plan.nodeType, plan.indexName, plan.avgPlanningTime, plan.avgExecutionTime))
+	}
+	
+	for _, phase := range []struct {
+		title          string
+		rtree, postgis *benchmarkStats
+	}{
+		{"Bounding box queries", &rtreeStats, &postgisStats},
+		{fmt.Sprintf("Radius queries (%.0f km)", config.Demo.RadiusKm), rtreeStats.radius, postgisStats.radius},
+		{fmt.Sprintf("Nearest neighbor queries (k=%d)", config.Demo.Neighbors), rtreeStats.knn, postgisStats.knn},
+	} {
+		if phase.rtree == nil {
+			continue
+		}
+		values := func(value func(stats *benchmarkStats) float64) []float64 {
+			out := []float64{value(phase.rtree)}
+			if ranDatabase {
+				db := report.Missing
+				if phase.postgis != nil && phase.postgis.totalQueries > 0 {
+					db = value(phase.postgis)
+				}
+				out = append(out, db)
+			}
+			return out
+		}
+		section := report.Section{
+			Title:   phase.title,
+			Engines: engines,
+			Chart:   "Queries/sec",
+			Rows: []report.Row{
+				{Metric: "Queries/sec", Unit: report.PerSecond, Values: values(func(stats *benchmarkStats) float64 { return stats.queriesPerSecond })},
+				{Metric: "Avg query time", Unit: report.Duration, Values: values(func(stats *benchmarkStats) float64 { return float64(stats.avgQueryTime) })},
+				{Metric: "Total queries", Unit: report.Count, Values: values(func(stats *benchmarkStats) float64 { return float64(stats.totalQueries) })},
+			},
+		}
+		if phase.postgis != nil && phase.postgis.batchQPS > 0 {
+			section.Rows = append(section.Rows, report.Row{Metric: "Batched queries/sec", Unit: report.PerSecond,
+				Values: []float64{report.Missing, phase.postgis.batchQPS}})
+		}
+		r.Sections = append(r.Sections, section)
+	}
+	return r
+}
+
 func generateRandomPoints(n int) []*models.Point {
 	points := make([]*models.Point, n)
 	
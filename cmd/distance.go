@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/1F47E/geo-index-rtree/pkg/rtree"
+	"github.com/spf13/cobra"
+)
+
+var distanceCmd = &cobra.Command{
+	Use:   "distance <lat1> <lon1> <lat2> <lon2>",
+	Short: "Calculate the distance between two coordinates",
+	Long: `Print the great-circle (haversine) and ellipsoidal (Vincenty, WGS-84)
+distance between two coordinates.
+
+Haversine is what the index uses for radius and nearest-neighbor queries;
+Vincenty is accurate to millimeters but fails to converge for nearly antipodal
+points. Negative coordinates can be passed as-is.`,
+	Example: `  go-geo-index distance 37.7749 -122.4194 34.0522 -118.2437
+  go-geo-index distance 51.5074 -0.1278 40.7128 -74.0060 --unit nm`,
+	// See parseNumericArgs
+	DisableFlagParsing: true,
+	Run:                runDistance,
+}
+
+var distanceUnit string
+
+// distanceUnits maps unit names to kilometers per unit
+var distanceUnits = map[string]float64{
+	"km": 1,
+	"mi": 1.609344,
+	"nm": 1.852,
+}
+
+func init() {
+	distanceCmd.Flags().StringVarP(&distanceUnit, "unit", "u", "km", "Unit: km, mi, nm")
+
+	rootCmd.AddCommand(distanceCmd)
+}
+
+func runDistance(cmd *cobra.Command, args []string) {
+	coordArgs, ok := parseNumericArgs(cmd, args)
+	if !ok {
+		return
+	}
+
+	perUnit, ok := distanceUnits[strings.ToLower(distanceUnit)]
+	if !ok {
+		log.Fatalf("Unknown unit %q (use km, mi or nm)", distanceUnit)
+	}
+
+	coords, err := parseFloats(coordArgs, 4)
+	if err != nil {
+		log.Fatalf("Usage: distance <lat1> <lon1> <lat2> <lon2>: %v", err)
+	}
+	lat1, lon1, lat2, lon2 := coords[0], coords[1], coords[2], coords[3]
+	for _, lat := range []float64{lat1, lat2} {
+		if lat < -90 || lat > 90 {
+			log.Fatalf("Latitude %g out of range [-90, 90]", lat)
+		}
+	}
+	for _, lon := range []float64{lon1, lon2} {
+		if lon < -180 || lon > 180 {
+			log.Fatalf("Longitude %g out of range [-180, 180]", lon)
+		}
+	}
+
+	unit := strings.ToLower(distanceUnit)
+	fmt.Printf("Haversine: %.3f %s\n", rtree.Distance(lat1, lon1, lat2, lon2)/perUnit, unit)
+
+	vincenty, err := rtree.VincentyDistance(lat1, lon1, lat2, lon2)
+	switch {
+	case errors.Is(err, rtree.ErrNoConvergence):
+		fmt.Printf("Vincenty:  n/a (points are nearly antipodal)\n")
+	case err != nil:
+		log.Fatalf("Failed to calculate distance: %v", err)
+	default:
+		fmt.Printf("Vincenty:  %.3f %s\n", vincenty/perUnit, unit)
+	}
+}
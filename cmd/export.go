@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/1F47E/geo-index-rtree/pkg/formats"
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/spf13/cobra"
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export index points as GeoJSON, CSV, NDJSON, FlatGeobuf or Parquet",
+	Long: `Dump the points stored in an index file, optionally restricted to a bounding box.
+
+The format is taken from --format, or detected from the output file extension.`,
+	Run: runExport,
+}
+
+var (
+	exportOutput string
+	exportFormat string
+	exportBBox   string
+)
+
+func init() {
+	exportCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "Output file (required)")
+	exportCmd.Flags().StringVar(&exportFormat, "format", "", "Output format: geojson, csv, ndjson, flatgeobuf, parquet (detected from extension by default)")
+	exportCmd.Flags().StringVar(&exportBBox, "bbox", "", "Only export points inside min_lat,min_lon,max_lat,max_lon")
+	_ = exportCmd.MarkFlagRequired("output")
+
+	rootCmd.AddCommand(exportCmd)
+}
+
+func runExport(cmd *cobra.Command, args []string) {
+	format, err := resolveFormat(exportOutput, exportFormat)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	box := worldBounds()
+	if exportBBox != "" {
+		if box, err = parseBBox(exportBBox); err != nil {
+			log.Fatalf("Invalid --bbox: %v", err)
+		}
+	}
+
+	index, err := loadIndex(indexFile)
+	if err != nil {
+		log.Fatalf("Failed to load index: %v", err)
+	}
+
+	start := time.Now()
+	points, err := index.QueryBox(box)
+	if err != nil {
+		log.Fatalf("Failed to query index: %v", err)
+	}
+
+	file, err := os.Create(exportOutput)
+	if err != nil {
+		log.Fatalf("Failed to create output: %v", err)
+	}
+	defer file.Close()
+
+	w, err := formats.NewWriter(format, file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, point := range points {
+		if err := w.Write(point); err != nil {
+			log.Fatalf("Failed to write %s: %v", exportOutput, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		log.Fatalf("Failed to write %s: %v", exportOutput, err)
+	}
+
+	fmt.Printf("Exported %d points to %s (%s) in %v\n", len(points), exportOutput, format, time.Since(start))
+}
+
+// worldBounds returns a bounding box covering every valid coordinate
+func worldBounds() models.BoundingBox {
+	return models.BoundingBox{
+		BottomLeft: models.Location{Lat: -90, Lon: -180},
+		TopRight:   models.Location{Lat: 90, Lon: 180},
+	}
+}
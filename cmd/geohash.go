@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/1F47E/geo-index-rtree/pkg/geohash"
+	"github.com/1F47E/geo-index-rtree/pkg/rtree"
+	"github.com/spf13/cobra"
+)
+
+var geohashCmd = &cobra.Command{
+	Use:   "geohash",
+	Short: "Encode, decode and find neighbors of geohashes",
+	Long: `Convert between coordinates and geohash cells.
+
+Each character narrows the cell by 5 bits: 5 characters is ~5km, 7 is ~150m
+and 9 is ~5m. Query an index by cell with the query tool's -geohash flag.`,
+}
+
+var geohashEncodeCmd = &cobra.Command{
+	Use:     "encode <lat> <lon>",
+	Short:   "Print the geohash of a coordinate",
+	Example: `  go-geo-index geohash encode 37.7749 -122.4194 --precision 7`,
+	// See parseNumericArgs
+	DisableFlagParsing: true,
+	Run:                runGeohashEncode,
+}
+
+var geohashDecodeCmd = &cobra.Command{
+	Use:   "decode <geohash>...",
+	Short: "Print the center and bounds of geohash cells",
+	Args:  cobra.MinimumNArgs(1),
+	Run:   runGeohashDecode,
+}
+
+var geohashNeighborsCmd = &cobra.Command{
+	Use:   "neighbors <geohash>",
+	Short: "Print the eight cells surrounding a geohash",
+	Args:  cobra.ExactArgs(1),
+	Run:   runGeohashNeighbors,
+}
+
+var geohashPrecision int
+
+func init() {
+	geohashEncodeCmd.Flags().IntVarP(&geohashPrecision, "precision", "p", geohash.DefaultPrecision,
+		fmt.Sprintf("Number of characters (1-%d)", geohash.MaxPrecision))
+
+	geohashCmd.AddCommand(geohashEncodeCmd, geohashDecodeCmd, geohashNeighborsCmd)
+	rootCmd.AddCommand(geohashCmd)
+}
+
+func runGeohashEncode(cmd *cobra.Command, args []string) {
+	coordArgs, ok := parseNumericArgs(cmd, args)
+	if !ok {
+		return
+	}
+	coords, err := parseFloats(coordArgs, 2)
+	if err != nil {
+		log.Fatalf("Usage: geohash encode <lat> <lon>: %v", err)
+	}
+	if coords[0] < -90 || coords[0] > 90 || coords[1] < -180 || coords[1] > 180 {
+		log.Fatalf("Coordinate (%g, %g) out of range", coords[0], coords[1])
+	}
+	if geohashPrecision < 1 || geohashPrecision > geohash.MaxPrecision {
+		log.Fatalf("--precision must be between 1 and %d", geohash.MaxPrecision)
+	}
+
+	fmt.Println(geohash.Encode(coords[0], coords[1], geohashPrecision))
+}
+
+func runGeohashDecode(cmd *cobra.Command, args []string) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for i, hash := range args {
+		box, err := geohash.DecodeBounds(hash)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		lat, lon, _ := geohash.Decode(hash)
+
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		midLat := (box.BottomLeft.Lat + box.TopRight.Lat) / 2
+		fmt.Fprintf(w, "Geohash:\t%s\n", hash)
+		fmt.Fprintf(w, "Center:\t%.6f, %.6f\n", lat, lon)
+		fmt.Fprintf(w, "Bounds:\t%s\n", formatBounds(&box))
+		fmt.Fprintf(w, "Cell size:\t%.3f x %.3f km\n",
+			rtree.Distance(midLat, box.BottomLeft.Lon, midLat, box.TopRight.Lon),
+			rtree.Distance(box.BottomLeft.Lat, lon, box.TopRight.Lat, lon))
+	}
+	w.Flush()
+}
+
+func runGeohashNeighbors(cmd *cobra.Command, args []string) {
+	neighbors, err := geohash.Neighbors(args[0])
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for dir, n := range neighbors {
+		if n == "" {
+			n = "- (beyond the pole)"
+		}
+		fmt.Fprintf(w, "%s\t%s\n", geohash.Direction(dir), n)
+	}
+	w.Flush()
+}
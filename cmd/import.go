@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/1F47E/geo-index-rtree/pkg/formats"
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/1F47E/geo-index-rtree/pkg/rtree"
+	"github.com/spf13/cobra"
+)
+
+var importCmd = &cobra.Command{
+	Use:   "import <input>",
+	Short: "Import points from GeoJSON, CSV, NDJSON, GPX, OSM PBF, FlatGeobuf or Parquet",
+	Long: `Stream points from an input file into a new R-Tree index and save it.
+
+The format is detected from the file extension (.geojson/.json, .csv,
+.ndjson/.jsonl, .gpx, .osm.pbf/.pbf, .fgb, .parquet) unless --format is given. Use
+"-" to read from stdin together with --format.
+
+CSV files may have a header naming id/lat/lon columns (latitude, lng, longitude
+and similar aliases are recognized); without one, columns are read as id,lat,lon.
+From OSM PBF extracts only tagged nodes are imported, with IDs like "node/123".
+Parquet files need lat/lon columns or a WKB geometry column as in GeoParquet.`,
+	Args: cobra.ExactArgs(1),
+	Run:  runImport,
+}
+
+var (
+	importOutput    string
+	importFormat    string
+	importBatchSize int
+	importAppend    bool
+	importWrap      bool
+	importIDPrefix  string
+)
+
+func init() {
+	importCmd.Flags().StringVarP(&importOutput, "output", "o", "", "Output index file (defaults to --file)")
+	importCmd.Flags().StringVar(&importFormat, "format", "", "Input format: geojson, csv, ndjson, gpx, osmpbf, flatgeobuf, parquet (detected from extension by default)")
+	importCmd.Flags().IntVarP(&importBatchSize, "batch-size", "b", 100000, "Number of points indexed per batch")
+	importCmd.Flags().BoolVar(&importAppend, "append", false, "Add the points to the existing output index instead of overwriting it")
+	importCmd.Flags().BoolVar(&importWrap, "wrap-longitudes", false, "Wrap longitudes into [-180, 180), for sources using 0-360")
+	importCmd.Flags().StringVar(&importIDPrefix, "id-prefix", "", `Store IDs of the form <prefix><number> as numbers in the index file, e.g. "point_" (use "" for plain numbers)`)
+
+	rootCmd.AddCommand(importCmd)
+}
+
+func runImport(cmd *cobra.Command, args []string) {
+	input := args[0]
+	output := importOutput
+	if output == "" {
+		output = indexFile
+	}
+	if importBatchSize < 1 {
+		log.Fatalf("--batch-size must be at least 1, got %d", importBatchSize)
+	}
+
+	format, err := resolveFormat(input, importFormat)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var in io.Reader = os.Stdin
+	if input != "-" {
+		f, err := os.Open(input)
+		if err != nil {
+			log.Fatalf("Failed to open input: %v", err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	reader, err := formats.NewReader(format, in)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Importing %s (%s) into %s...\n", input, format, output)
+	start := time.Now()
+
+	var opts []rtree.Option
+	if importWrap {
+		opts = append(opts, rtree.WithLongitudeWrap())
+	}
+	if cmd.Flags().Changed("id-prefix") {
+		opts = append(opts, rtree.WithIDCodec(rtree.IDCodec{Prefix: importIDPrefix}))
+	}
+	index := rtree.NewGeoIndex(opts...)
+	if importAppend {
+		if _, err := os.Stat(output); err == nil {
+			if err := index.LoadFromFile(output); err != nil {
+				log.Fatalf("Failed to load existing index: %v", err)
+			}
+			fmt.Printf("Appending to %d existing points\n", index.Count())
+		}
+	}
+	existing := index.Count()
+
+	err = formats.ReadBatches(reader, importBatchSize, func(batch []*models.Point) error {
+		if err := index.IndexPoints(batch); err != nil {
+			return err
+		}
+		if verbose {
+			fmt.Printf("  %d points indexed\n", index.Count())
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("Failed to import %s: %v", input, err)
+	}
+
+	elapsed := time.Since(start)
+	imported := index.Count() - existing
+	fmt.Printf("Imported %d points in %v (%.0f points/sec)\n",
+		imported, elapsed, float64(imported)/elapsed.Seconds())
+
+	if err := index.SaveToFile(output); err != nil {
+		log.Fatalf("Failed to save index: %v", err)
+	}
+	fmt.Printf("Index saved to %s\n", output)
+}
+
+// resolveFormat resolves the format of path, preferring an explicit name
+func resolveFormat(path, name string) (formats.Format, error) {
+	if name != "" {
+		return formats.Parse(name)
+	}
+	if path == "-" {
+		return "", fmt.Errorf("--format is required when using stdin")
+	}
+	return formats.Detect(path)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/1F47E/geo-index-rtree/pkg/backend"
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/1F47E/geo-index-rtree/pkg/rtree"
+	"github.com/spf13/cobra"
+)
+
+// loadIndex loads an in-memory index of the --backend kind from path,
+// reporting progress on stdout
+func loadIndex(path string, opts ...rtree.Option) (*rtree.GeoIndex, error) {
+	index, err := backend.New(indexBackend, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("Loading index from %s...\n", path)
+	start := time.Now()
+	if err := index.LoadFromFile(path); err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("Loaded %d points in %v\n", index.Count(), time.Since(start))
+	return index, nil
+}
+
+// isDatabase reports whether --backend selects PostGIS or SQLite
+func isDatabase() bool {
+	return backend.IsDatabase(indexBackend)
+}
+
+// openBackend returns an empty index of the --backend kind, or the database
+// configured in the postgis or sqlite section
+func openBackend() (backend.SpatialIndex, error) {
+	c := *appConfig
+	c.Index.Backend = indexBackend
+	return backend.Open(&c)
+}
+
+// openIndex returns the index the benchmark commands query: the file at path
+// loaded into an in-memory backend, or the database as it is
+func openIndex(path string) (backend.SpatialIndex, error) {
+	if !isDatabase() {
+		index, err := loadIndex(path)
+		if err != nil {
+			return nil, err
+		}
+		return index, nil
+	}
+
+	index, err := openBackend()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Connected to %s database with %d points\n", indexBackend, index.Count())
+	return index, nil
+}
+
+// printPoints prints up to limit points, with the distance to center when given
+func printPoints(points []*models.Point, center *models.Location, limit int) {
+	for i, point := range points {
+		if limit > 0 && i >= limit {
+			fmt.Printf("... %d more (showing first %d)\n", len(points)-limit, limit)
+			break
+		}
+		if center != nil {
+			dist := rtree.Distance(center.Lat, center.Lon, point.Location.Lat, point.Location.Lon)
+			fmt.Printf("%d. %s: (%.6f, %.6f) - %.2f km\n",
+				i+1, point.ID, point.Location.Lat, point.Location.Lon, dist)
+		} else {
+			fmt.Printf("%d. %s: (%.6f, %.6f)\n",
+				i+1, point.ID, point.Location.Lat, point.Location.Lon)
+		}
+	}
+}
+
+// parseBBox parses a "min_lat,min_lon,max_lat,max_lon" bounding box
+func parseBBox(s string) (models.BoundingBox, error) {
+	v, err := parseFloats(strings.Split(s, ","), 4)
+	if err != nil {
+		return models.BoundingBox{}, err
+	}
+	box := models.BoundingBox{
+		BottomLeft: models.Location{Lat: v[0], Lon: v[1]},
+		TopRight:   models.Location{Lat: v[2], Lon: v[3]},
+	}
+	if err := box.Validate(); err != nil {
+		return models.BoundingBox{}, err
+	}
+	return box, nil
+}
+
+// parseNumericArgs parses flags for commands that set DisableFlagParsing so
+// negative coordinates aren't mistaken for flags. Numeric arguments are returned
+// as positionals and everything else is parsed as flags; ok is false if help
+// was printed.
+func parseNumericArgs(cmd *cobra.Command, args []string) (numeric []string, ok bool) {
+	var flagArgs []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if _, err := strconv.ParseFloat(arg, 64); err == nil {
+			numeric = append(numeric, arg)
+			continue
+		}
+		flagArgs = append(flagArgs, arg)
+
+		// A flag given as "--name value" takes the next argument, even a number
+		if strings.Contains(arg, "=") || !strings.HasPrefix(arg, "-") || i+1 == len(args) {
+			continue
+		}
+		name := strings.TrimLeft(arg, "-")
+		flag := cmd.Flags().Lookup(name)
+		if flag == nil && !strings.HasPrefix(arg, "--") {
+			flag = cmd.Flags().ShorthandLookup(name)
+		}
+		if flag != nil && flag.NoOptDefVal == "" {
+			i++
+			flagArgs = append(flagArgs, args[i])
+		}
+	}
+	if err := cmd.Flags().Parse(flagArgs); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if help, _ := cmd.Flags().GetBool("help"); help {
+		cmd.Help()
+		return nil, false
+	}
+	if cmd.Flags().NArg() > 0 {
+		log.Fatalf("Unexpected arguments: %v", cmd.Flags().Args())
+	}
+	return numeric, true
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+)
+
+// population.csv is a coarse 1x1 degree raster of relative population weights
+//
+//go:embed population.csv
+var populationCSV string
+
+const (
+	kmPerDegree = 111.32
+	// maxAttempts bounds rejection sampling; after that the point is clamped into the bounds
+	maxAttempts = 100
+)
+
+// bounds is the region points are generated in
+type bounds struct {
+	minLat, maxLat, minLon, maxLon float64
+}
+
+func (b bounds) contains(lat, lon float64) bool {
+	return lat >= b.minLat && lat <= b.maxLat && lon >= b.minLon && lon <= b.maxLon
+}
+
+func (b bounds) clamp(lat, lon float64) models.Location {
+	return models.Location{Lat: clamp(lat, b.minLat, b.maxLat), Lon: clamp(lon, b.minLon, b.maxLon)}
+}
+
+func (b bounds) random(r *rand.Rand) models.Location {
+	return models.Location{
+		Lat: b.minLat + r.Float64()*(b.maxLat-b.minLat),
+		Lon: b.minLon + r.Float64()*(b.maxLon-b.minLon),
+	}
+}
+
+// generator produces one location per call. Implementations are read-only
+// after construction, so one generator is shared by all workers.
+type generator interface {
+	next(r *rand.Rand) models.Location
+}
+
+type uniformGenerator struct {
+	b bounds
+}
+
+func (g uniformGenerator) next(r *rand.Rand) models.Location {
+	return g.b.random(r)
+}
+
+// populationGenerator picks raster cells weighted by population, then a
+// uniform position inside the chosen cell (clipped to the bounds)
+type populationGenerator struct {
+	b          bounds
+	cells      []models.Location // south-west corners
+	cumulative []float64
+}
+
+func newPopulationGenerator(b bounds) (*populationGenerator, error) {
+	reader := csv.NewReader(strings.NewReader(populationCSV))
+	reader.Comment = '#'
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse population raster: %w", err)
+	}
+
+	g := &populationGenerator{b: b}
+	total := 0.0
+	for _, rec := range records[1:] {
+		lat, _ := strconv.ParseFloat(rec[0], 64)
+		lon, _ := strconv.ParseFloat(rec[1], 64)
+		weight, _ := strconv.ParseFloat(rec[2], 64)
+
+		// Keep cells overlapping the bounds, weighted by the overlapping fraction
+		overlap := overlapFraction(b, lat, lon)
+		if overlap == 0 || weight <= 0 {
+			continue
+		}
+		total += weight * overlap
+		g.cells = append(g.cells, models.Location{Lat: lat, Lon: lon})
+		g.cumulative = append(g.cumulative, total)
+	}
+	if len(g.cells) == 0 {
+		return nil, fmt.Errorf("population raster has no populated cells inside the given bounds")
+	}
+	return g, nil
+}
+
+func (g *populationGenerator) next(r *rand.Rand) models.Location {
+	target := r.Float64() * g.cumulative[len(g.cumulative)-1]
+	i := sort.SearchFloat64s(g.cumulative, target)
+	if i >= len(g.cells) {
+		i = len(g.cells) - 1
+	}
+	cell := g.cells[i]
+
+	cellBounds := bounds{
+		minLat: math.Max(cell.Lat, g.b.minLat),
+		maxLat: math.Min(cell.Lat+1, g.b.maxLat),
+		minLon: math.Max(cell.Lon, g.b.minLon),
+		maxLon: math.Min(cell.Lon+1, g.b.maxLon),
+	}
+	return cellBounds.random(r)
+}
+
+// overlapFraction returns how much of the 1 degree cell at (lat, lon) lies in b
+func overlapFraction(b bounds, lat, lon float64) float64 {
+	dLat := math.Min(lat+1, b.maxLat) - math.Max(lat, b.minLat)
+	dLon := math.Min(lon+1, b.maxLon) - math.Max(lon, b.minLon)
+	if dLat <= 0 || dLon <= 0 {
+		return 0
+	}
+	return dLat * dLon
+}
+
+// clusterGenerator draws points from isotropic Gaussians around fixed centers
+type clusterGenerator struct {
+	b       bounds
+	centers []models.Location
+	sigmaKm float64
+}
+
+func (g clusterGenerator) next(r *rand.Rand) models.Location {
+	c := g.centers[r.Intn(len(g.centers))]
+	var lat, lon float64
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		lat = c.Lat + r.NormFloat64()*g.sigmaKm/kmPerDegree
+		lon = c.Lon + r.NormFloat64()*g.sigmaKm/(kmPerDegree*math.Max(math.Cos(c.Lat*math.Pi/180), 0.01))
+		if g.b.contains(lat, lon) {
+			break
+		}
+	}
+	return g.b.clamp(lat, lon)
+}
+
+// corridorGenerator scatters points along random polylines, like traffic
+// along roads, with Gaussian offsets perpendicular to each segment
+type corridorGenerator struct {
+	b        bounds
+	segments [][2]models.Location
+	lengths  []float64 // cumulative, so long roads get proportionally more points
+	widthKm  float64
+}
+
+func newCorridorGenerator(b bounds, corridors int, widthKm float64, r *rand.Rand) *corridorGenerator {
+	g := &corridorGenerator{b: b, widthKm: widthKm}
+	total := 0.0
+	for i := 0; i < corridors; i++ {
+		// Each corridor is a short random walk between two anchor points
+		from, to := b.random(r), b.random(r)
+		const legs = 4
+		prev := from
+		for leg := 1; leg <= legs; leg++ {
+			t := float64(leg) / legs
+			next := models.Location{
+				Lat: from.Lat + (to.Lat-from.Lat)*t,
+				Lon: from.Lon + (to.Lon-from.Lon)*t,
+			}
+			if leg < legs {
+				next.Lat = clamp(next.Lat+r.NormFloat64()*(b.maxLat-b.minLat)*0.03, b.minLat, b.maxLat)
+				next.Lon = clamp(next.Lon+r.NormFloat64()*(b.maxLon-b.minLon)*0.03, b.minLon, b.maxLon)
+			}
+			total += math.Hypot(next.Lat-prev.Lat, next.Lon-prev.Lon)
+			g.segments = append(g.segments, [2]models.Location{prev, next})
+			g.lengths = append(g.lengths, total)
+			prev = next
+		}
+	}
+	return g
+}
+
+func (g *corridorGenerator) next(r *rand.Rand) models.Location {
+	i := sort.SearchFloat64s(g.lengths, r.Float64()*g.lengths[len(g.lengths)-1])
+	if i >= len(g.segments) {
+		i = len(g.segments) - 1
+	}
+	a, b := g.segments[i][0], g.segments[i][1]
+
+	var lat, lon float64
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		t := r.Float64()
+		lat = a.Lat + (b.Lat-a.Lat)*t
+		lon = a.Lon + (b.Lon-a.Lon)*t
+
+		// Offset perpendicular to the segment direction
+		dLat, dLon := b.Lat-a.Lat, b.Lon-a.Lon
+		norm := math.Hypot(dLat, dLon)
+		if norm > 0 {
+			offset := r.NormFloat64() * g.widthKm / kmPerDegree
+			lat += -dLon / norm * offset
+			lon += dLat / norm * offset
+		}
+		if g.b.contains(lat, lon) {
+			break
+		}
+	}
+	return g.b.clamp(lat, lon)
+}
+
+// newGenerator builds the generator for a -dist flag value
+func newGenerator(dist string, b bounds, centers string, clusters int, sigmaKm float64,
+	corridors int, widthKm float64, r *rand.Rand) (generator, error) {
+
+	switch dist {
+	case "uniform":
+		return uniformGenerator{b}, nil
+	case "population":
+		return newPopulationGenerator(b)
+	case "clusters":
+		var locs []models.Location
+		if centers != "" {
+			var err error
+			if locs, err = parseCenters(centers); err != nil {
+				return nil, err
+			}
+		} else {
+			for i := 0; i < clusters; i++ {
+				locs = append(locs, b.random(r))
+			}
+		}
+		if len(locs) == 0 {
+			return nil, fmt.Errorf("clusters distribution needs at least one center")
+		}
+		return clusterGenerator{b: b, centers: locs, sigmaKm: sigmaKm}, nil
+	case "corridors":
+		if corridors < 1 {
+			return nil, fmt.Errorf("corridors distribution needs at least one corridor")
+		}
+		return newCorridorGenerator(b, corridors, widthKm, r), nil
+	}
+	return nil, fmt.Errorf("unknown distribution %q (use uniform, population, clusters or corridors)", dist)
+}
+
+// parseCenters parses "lat,lon;lat,lon;..."
+func parseCenters(s string) ([]models.Location, error) {
+	var locs []models.Location
+	for _, pair := range strings.Split(s, ";") {
+		parts := strings.Split(strings.TrimSpace(pair), ",")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid center %q, expected lat,lon", pair)
+		}
+		lat, err1 := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+		lon, err2 := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("invalid center %q, expected lat,lon", pair)
+		}
+		locs = append(locs, models.Location{Lat: lat, Lon: lon})
+	}
+	return locs, nil
+}
+
+func clamp(v, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, v))
+}
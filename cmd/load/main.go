@@ -7,8 +7,10 @@ import (
 	"math/rand"
 	"os"
 	"runtime"
+	"sync/atomic"
 	"time"
 
+	"github.com/1F47E/geo-index-rtree/pkg/manifest"
 	"github.com/1F47E/geo-index-rtree/pkg/models"
 	"github.com/1F47E/geo-index-rtree/pkg/rtree"
 )
@@ -18,12 +20,20 @@ func main() {
 		numPoints  = flag.Int("n", 1000000, "Number of points to generate")
 		outputFile = flag.String("o", "data/index.gob", "Output file path")
 		workers    = flag.Int("w", runtime.NumCPU(), "Number of worker goroutines")
-		seed       = flag.Int64("seed", time.Now().UnixNano(), "Random seed")
+		seed       = flag.Int64("seed", time.Now().UnixNano(), "Random seed; with the same flags it reproduces the same points for any -w (recorded in the manifest)")
+		appendMode = flag.Bool("append", false, "Add the generated points to the existing output index instead of overwriting it")
 		// Geographic bounds for random point generation (default: roughly USA)
 		minLat = flag.Float64("min-lat", 25.0, "Minimum latitude")
 		maxLat = flag.Float64("max-lat", 49.0, "Maximum latitude")
 		minLon = flag.Float64("min-lon", -125.0, "Minimum longitude")
 		maxLon = flag.Float64("max-lon", -66.0, "Maximum longitude")
+		// Spatial distribution of generated points
+		dist      = flag.String("dist", "uniform", "Distribution: uniform, population, clusters, corridors")
+		centers   = flag.String("centers", "", "Cluster centers as \"lat,lon;lat,lon\" (clusters; random centers if empty)")
+		clusters  = flag.Int("clusters", 10, "Number of random cluster centers (clusters)")
+		sigma     = flag.Float64("sigma", 50, "Cluster standard deviation in km (clusters)")
+		corridors = flag.Int("corridors", 20, "Number of road-like corridors (corridors)")
+		width     = flag.Float64("width", 2, "Corridor standard deviation across the road in km (corridors)")
 	)
 	flag.Parse()
 
@@ -32,21 +42,56 @@ func main() {
 		log.Fatalf("Failed to create data directory: %v", err)
 	}
 
-	log.Printf("Generating %d random points with %d workers...\n", *numPoints, *workers)
+	log.Printf("Generating %d %s points with %d workers...\n", *numPoints, *dist, *workers)
 	log.Printf("Geographic bounds: lat[%.2f, %.2f], lon[%.2f, %.2f]\n", 
 		*minLat, *maxLat, *minLon, *maxLon)
 
-	// Initialize random generator
-	rand.Seed(*seed)
+	// IndexPoints reports each stage of a batch; a stage's rate and ETA
+	// count from the end of the one before
+	var stage *progress
+	var lastElapsed time.Duration
+	onProgress := func(p rtree.Progress) {
+		if stage == nil || stage.stage != stageNames[p.Stage] {
+			if stage == nil {
+				lastElapsed = 0
+			}
+			stage = newProgress(stageNames[p.Stage], "points", p.Total)
+			stage.start = time.Now().Add(lastElapsed - p.Elapsed)
+		}
+		stage.update(p.Done)
+		lastElapsed = p.Elapsed
+	}
+	index := rtree.NewGeoIndexWithWorkers(*workers, rtree.WithProgress(onProgress))
+	if *appendMode {
+		if _, err := os.Stat(*outputFile); err == nil {
+			log.Printf("Appending to existing index %s...\n", *outputFile)
+			if err := index.LoadFromFile(*outputFile); err != nil {
+				log.Fatalf("Failed to load existing index: %v", err)
+			}
+			log.Printf("Existing index has %d points\n", index.Count())
+		} else if !os.IsNotExist(err) {
+			log.Fatalf("Failed to stat existing index: %v", err)
+		}
+	}
+
+	log.Printf("Seed: %d\n", *seed)
+	b := bounds{minLat: *minLat, maxLat: *maxLat, minLon: *minLon, maxLon: *maxLon}
+	gen, err := newGenerator(*dist, b, *centers, *clusters, *sigma, *corridors, *width,
+		rand.New(rand.NewSource(*seed)))
+	if err != nil {
+		log.Fatalf("Invalid distribution: %v", err)
+	}
 
 	// Generate points in parallel
-	points := generateRandomPoints(*numPoints, *minLat, *maxLat, *minLon, *maxLon, *workers)
+	// IDs continue after the existing points so appended batches don't collide
+	points := generateRandomPoints(*numPoints, int(index.Count()), gen, *workers, *seed,
+		newProgress("Generating", "points", *numPoints))
 
-	// Create index
+	// Insert into index
 	log.Println("Building R-Tree index...")
 	startTime := time.Now()
 	
-	index := rtree.NewGeoIndexWithWorkers(*workers)
+	stage = nil
 	if err := index.IndexPoints(points); err != nil {
 		log.Fatalf("Failed to index points: %v", err)
 	}
@@ -72,55 +117,62 @@ func main() {
 		log.Printf("Index file size: %.2f MB\n", float64(fileInfo.Size())/(1024*1024))
 	}
 	log.Printf("Total points indexed: %d\n", index.Count())
+
+	manifestPath := manifest.PathFor(*outputFile)
+	if err := manifest.New("load", *seed).Write(manifestPath); err != nil {
+		log.Fatalf("Failed to write manifest: %v", err)
+	}
+	log.Printf("Manifest written to %s\n", manifestPath)
+}
+
+// chunkSize is the number of points generated from one random source. Chunk
+// c draws from seed+1+c, so the points depend on the seed alone, not on how
+// chunks are spread over workers.
+const chunkSize = 10000
+
+// stageNames are the progress labels of the IndexPoints stages
+var stageNames = map[string]string{
+	rtree.StagePartitioning: "Partitioning",
+	rtree.StageBuilding:     "Building",
 }
 
-func generateRandomPoints(n int, minLat, maxLat, minLon, maxLon float64, workers int) []*models.Point {
+// generateRandomPoints generates n points with gen, reporting each
+// generated chunk to prog
+func generateRandomPoints(n, idOffset int, gen generator, workers int, seed int64, prog *progress) []*models.Point {
 	points := make([]*models.Point, n)
-	
-	// Calculate points per worker
-	pointsPerWorker := n / workers
-	remainder := n % workers
+	var generated atomic.Int64
 	
 	// Channel to coordinate work
 	type workRange struct {
 		start, end int
 	}
-	work := make(chan workRange, workers)
+	chunks := (n + chunkSize - 1) / chunkSize
+	work := make(chan workRange, chunks)
 	done := make(chan bool, workers)
 	
 	// Start workers
 	for w := 0; w < workers; w++ {
 		go func(workerID int) {
-			// Each worker gets its own random generator to avoid contention
-			r := rand.New(rand.NewSource(rand.Int63()))
-			
 			for wr := range work {
+				// Each chunk gets its own random generator, seeded by position
+				r := rand.New(rand.NewSource(seed + 1 + int64(wr.start/chunkSize)))
 				for i := wr.start; i < wr.end; i++ {
-					lat := minLat + r.Float64()*(maxLat-minLat)
-					lon := minLon + r.Float64()*(maxLon-minLon)
+					loc := gen.next(r)
 					
 					points[i] = &models.Point{
-						ID: fmt.Sprintf("point_%d", i),
-						Location: &models.Location{
-							Lat: lat,
-							Lon: lon,
-						},
+						ID:       fmt.Sprintf("point_%d", idOffset+i),
+						Location: &loc,
 					}
 				}
+				prog.update(int(generated.Add(int64(wr.end - wr.start))))
 			}
 			done <- true
 		}(w)
 	}
 	
 	// Distribute work
-	start := 0
-	for w := 0; w < workers; w++ {
-		size := pointsPerWorker
-		if w < remainder {
-			size++
-		}
-		work <- workRange{start: start, end: start + size}
-		start += size
+	for start := 0; start < n; start += chunkSize {
+		work <- workRange{start: start, end: min(start+chunkSize, n)}
 	}
 	close(work)
 	
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// progressInterval is the least time between two progress lines of a stage
+const progressInterval = time.Second
+
+// progress logs the advance of a long stage with its rate and an estimate of
+// the time left, at most once per progressInterval and once at the end
+type progress struct {
+	stage string
+	unit  string
+	total int
+	start time.Time
+
+	mu     sync.Mutex
+	logged time.Time
+	done   bool
+}
+
+func newProgress(stage, unit string, total int) *progress {
+	now := time.Now()
+	return &progress{stage: stage, unit: unit, total: total, start: now, logged: now}
+}
+
+// update logs done out of total if a line is due; safe for concurrent use
+func (p *progress) update(done int) {
+	p.updateSince(done, time.Since(p.start))
+}
+
+// updateSince is update with the elapsed time measured by the caller
+func (p *progress) updateSince(done int, elapsed time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	finished := done >= p.total
+	if p.done || (!finished && time.Since(p.logged) < progressInterval) {
+		return
+	}
+	p.logged = time.Now()
+	p.done = finished
+	log.Println(p.line(done, elapsed))
+}
+
+// line formats the progress of done out of total after elapsed
+func (p *progress) line(done int, elapsed time.Duration) string {
+	pct := 100.0
+	if p.total > 0 {
+		pct = float64(done) / float64(p.total) * 100
+	}
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(done) / elapsed.Seconds()
+	}
+	line := fmt.Sprintf("%s: %d/%d %s (%.1f%%), %.0f %s/sec", p.stage, done, p.total, p.unit, pct, rate, p.unit)
+	switch {
+	case done >= p.total:
+		line += fmt.Sprintf(", done in %v", elapsed.Round(time.Millisecond))
+	case rate > 0:
+		eta := time.Duration(float64(p.total-done) / rate * float64(time.Second))
+		line += fmt.Sprintf(", ETA %v", eta.Round(time.Second))
+	}
+	return line
+}
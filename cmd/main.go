@@ -6,17 +6,20 @@ import (
 	"math/rand"
 	"os"
 	"runtime"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/1F47E/geo-index-rtree/pkg/geo"
+	"github.com/1F47E/geo-index-rtree/pkg/backend"
+	"github.com/1F47E/geo-index-rtree/pkg/models"
 	"github.com/spf13/cobra"
 )
 
 var (
-	indexFile string
-	verbose   bool
+	indexFile    string
+	indexBackend string
+	verbose      bool
 )
 
 var rootCmd = &cobra.Command{
@@ -63,6 +66,7 @@ var (
 
 func init() {
 	rootCmd.PersistentFlags().StringVarP(&indexFile, "file", "f", "geo_index.gob", "Index file path")
+	rootCmd.PersistentFlags().StringVar(&indexBackend, "backend", backend.RTree, "Index backend: "+strings.Join(backend.Names, ", "))
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Verbose output")
 
 	loadCmd.Flags().IntVarP(&numPoints, "points", "p", 1000000, "Number of points to generate")
@@ -90,13 +94,17 @@ func main() {
 }
 
 func runLoad(cmd *cobra.Command, args []string) {
-	fmt.Printf("Loading %d random points into R-Tree index using %d workers...\n", numPoints, numWorkers)
+	fmt.Printf("Loading %d random points into %s index using %d workers...\n", numPoints, indexBackend, numWorkers)
 	
 	// Generate random points
 	points := generateRandomPoints(numPoints)
 	
 	// Create index
-	index := geo.NewGeoIndex()
+	index, err := openBackend()
+	if err != nil {
+		log.Fatalf("Failed to open %s backend: %v", indexBackend, err)
+	}
+	defer backend.Close(index)
 	
 	// Measure loading time
 	start := time.Now()
@@ -116,19 +124,24 @@ func runLoad(cmd *cobra.Command, args []string) {
 			endIdx = numPoints
 		}
 		
-		go func(batch []*geo.Point) {
+		go func(batch []*models.Point) {
 			defer wg.Done()
-			index.IndexPoints(batch)
+			if err := index.IndexPoints(batch); err != nil {
+				log.Printf("Failed to index batch: %v", err)
+			}
 		}(points[startIdx:endIdx])
 	}
 	
 	wg.Wait()
 	loadTime := time.Since(start)
 	
-	fmt.Printf("Loaded %d points in %v\n", index.Size(), loadTime)
+	fmt.Printf("Loaded %d points in %v\n", index.Count(), loadTime)
 	fmt.Printf("Points per second: %.0f\n", float64(numPoints)/loadTime.Seconds())
 	
-	// Save to file
+	// The database keeps its points; in-memory backends are saved to file
+	if isDatabase() {
+		return
+	}
 	if err := index.SaveToFile(indexFile); err != nil {
 		log.Fatalf("Failed to save index: %v", err)
 	}
@@ -138,17 +151,16 @@ func runLoad(cmd *cobra.Command, args []string) {
 
 func runQuery(cmd *cobra.Command, args []string) {
 	// Load index
-	index := geo.NewGeoIndex()
-	fmt.Printf("Loading index from %s...\n", indexFile)
-	if err := index.LoadFromFile(indexFile); err != nil {
+	index, err := openIndex(indexFile)
+	if err != nil {
 		log.Fatalf("Failed to load index: %v", err)
 	}
+	defer backend.Close(index)
 	
-	fmt.Printf("Loaded %d points\n", index.Size())
 	fmt.Printf("Running %d bounding box queries using %d workers...\n", numQueries, numWorkers)
 	
 	// Prepare random bounding boxes
-	queries := make([]struct{ latBL, lonBL, latTR, lonTR float64 }, numQueries)
+	queries := make([]models.BoundingBox, numQueries)
 	for i := 0; i < numQueries; i++ {
 		// Random center point
 		centerLat := rand.Float64()*180 - 90
@@ -157,11 +169,9 @@ func runQuery(cmd *cobra.Command, args []string) {
 		// Random box size (0.1 to 2 degrees)
 		boxSize := rand.Float64()*1.9 + 0.1
 		
-		queries[i] = struct{ latBL, lonBL, latTR, lonTR float64 }{
-			latBL: centerLat - boxSize/2,
-			lonBL: centerLon - boxSize/2,
-			latTR: centerLat + boxSize/2,
-			lonTR: centerLon + boxSize/2,
+		queries[i] = models.BoundingBox{
+			BottomLeft: models.Location{Lat: centerLat - boxSize/2, Lon: centerLon - boxSize/2},
+			TopRight:   models.Location{Lat: centerLat + boxSize/2, Lon: centerLon + boxSize/2},
 		}
 	}
 	
@@ -187,8 +197,7 @@ func runQuery(cmd *cobra.Command, args []string) {
 			
 			localResults := 0
 			for i := start; i < end; i++ {
-				q := queries[i]
-				results, err := index.SearchBox(q.latBL, q.lonBL, q.latTR, q.lonTR)
+				results, err := index.QueryBox(queries[i])
 				if err != nil {
 					log.Printf("Worker %d: Query error: %v", workerID, err)
 					continue
@@ -219,21 +228,20 @@ func runQuery(cmd *cobra.Command, args []string) {
 
 func runRadius(cmd *cobra.Command, args []string) {
 	// Load index
-	index := geo.NewGeoIndex()
-	fmt.Printf("Loading index from %s...\n", indexFile)
-	if err := index.LoadFromFile(indexFile); err != nil {
+	index, err := openIndex(indexFile)
+	if err != nil {
 		log.Fatalf("Failed to load index: %v", err)
 	}
+	defer backend.Close(index)
 	
-	fmt.Printf("Loaded %d points\n", index.Size())
 	fmt.Printf("Running %d radius searches (%.1f km) using %d workers...\n", numQueries, searchRadius, numWorkers)
 	
 	// Prepare random center points
-	centers := make([]struct{ lat, lon float64 }, numQueries)
+	centers := make([]models.Location, numQueries)
 	for i := 0; i < numQueries; i++ {
-		centers[i] = struct{ lat, lon float64 }{
-			lat: rand.Float64()*180 - 90,
-			lon: rand.Float64()*360 - 180,
+		centers[i] = models.Location{
+			Lat: rand.Float64()*180 - 90,
+			Lon: rand.Float64()*360 - 180,
 		}
 	}
 	
@@ -259,8 +267,7 @@ func runRadius(cmd *cobra.Command, args []string) {
 			
 			localResults := 0
 			for i := start; i < end; i++ {
-				c := centers[i]
-				results, err := index.SearchRadius(c.lat, c.lon, searchRadius)
+				results, err := index.QueryRadius(centers[i], searchRadius)
 				if err != nil {
 					log.Printf("Worker %d: Query error: %v", workerID, err)
 					continue
@@ -292,21 +299,20 @@ func runRadius(cmd *cobra.Command, args []string) {
 
 func runNearest(cmd *cobra.Command, args []string) {
 	// Load index
-	index := geo.NewGeoIndex()
-	fmt.Printf("Loading index from %s...\n", indexFile)
-	if err := index.LoadFromFile(indexFile); err != nil {
+	index, err := openIndex(indexFile)
+	if err != nil {
 		log.Fatalf("Failed to load index: %v", err)
 	}
+	defer backend.Close(index)
 	
-	fmt.Printf("Loaded %d points\n", index.Size())
 	fmt.Printf("Running %d nearest neighbor searches (k=%d) using %d workers...\n", numQueries, numNeighbors, numWorkers)
 	
 	// Prepare random query points
-	queryPoints := make([]struct{ lat, lon float64 }, numQueries)
+	queryPoints := make([]models.Location, numQueries)
 	for i := 0; i < numQueries; i++ {
-		queryPoints[i] = struct{ lat, lon float64 }{
-			lat: rand.Float64()*180 - 90,
-			lon: rand.Float64()*360 - 180,
+		queryPoints[i] = models.Location{
+			Lat: rand.Float64()*180 - 90,
+			Lon: rand.Float64()*360 - 180,
 		}
 	}
 	
@@ -332,8 +338,7 @@ func runNearest(cmd *cobra.Command, args []string) {
 			
 			localResults := 0
 			for i := start; i < end; i++ {
-				q := queryPoints[i]
-				results := index.NearestNeighbors(q.lat, q.lon, numNeighbors)
+				results := index.NearestNeighbors(queryPoints[i], numNeighbors)
 				localResults += len(results)
 				queryCount.Add(1)
 				
@@ -358,8 +363,8 @@ func runNearest(cmd *cobra.Command, args []string) {
 	fmt.Printf("Total results found: %d\n", totalResults.Load())
 }
 
-func generateRandomPoints(n int) []*geo.Point {
-	points := make([]*geo.Point, n)
+func generateRandomPoints(n int) []*models.Point {
+	points := make([]*models.Point, n)
 	
 	// Use multiple goroutines to generate points in parallel
 	numWorkers := runtime.NumCPU()
@@ -401,10 +406,9 @@ func generateRandomPoints(n int) []*geo.Point {
 					lon = r.Float64()*360 - 180  // -180 to 180
 				}
 				
-				points[i] = &geo.Point{
-					ID:  fmt.Sprintf("point_%d", i),
-					Lat: lat,
-					Lon: lon,
+				points[i] = &models.Point{
+					ID:       fmt.Sprintf("point_%d", i),
+					Location: &models.Location{Lat: lat, Lon: lon},
 				}
 			}
 		}(startIdx, endIdx)
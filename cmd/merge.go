@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/1F47E/geo-index-rtree/pkg/rtree"
+	"github.com/spf13/cobra"
+)
+
+var mergeCmd = &cobra.Command{
+	Use:   "merge <index>...",
+	Short: "Merge several index files into one",
+	Long: `Load multiple index files and write a single index containing all of their points.
+
+Points sharing an ID across (or within) inputs are handled by --duplicates:
+  first  keep the point from the earliest input (default)
+  last   keep the point from the latest input
+  all    keep every copy
+  error  abort the merge`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  runMerge,
+}
+
+var (
+	mergeOutput     string
+	mergeDuplicates string
+)
+
+func init() {
+	mergeCmd.Flags().StringVarP(&mergeOutput, "output", "o", "", "Output index file (required)")
+	mergeCmd.Flags().StringVar(&mergeDuplicates, "duplicates", "first", "Duplicate ID policy: first, last, all, error")
+	_ = mergeCmd.MarkFlagRequired("output")
+
+	rootCmd.AddCommand(mergeCmd)
+}
+
+func runMerge(cmd *cobra.Command, args []string) {
+	switch mergeDuplicates {
+	case "first", "last", "all", "error":
+	default:
+		log.Fatalf("Unknown duplicate policy %q (use first, last, all or error)", mergeDuplicates)
+	}
+
+	start := time.Now()
+	var merged []*models.Point
+	position := make(map[string]int)
+	duplicates := 0
+
+	for _, path := range args {
+		points, err := rtree.ReadPoints(path)
+		if err != nil {
+			log.Fatalf("Failed to read %s: %v", path, err)
+		}
+		fmt.Printf("Read %d points from %s\n", len(points), path)
+
+		for _, p := range points {
+			i, seen := position[p.ID]
+			if seen {
+				duplicates++
+				switch mergeDuplicates {
+				case "error":
+					log.Fatalf("Duplicate ID %q in %s", p.ID, path)
+				case "first":
+					continue
+				case "last":
+					merged[i] = p
+					continue
+				}
+			}
+			position[p.ID] = len(merged)
+			merged = append(merged, p)
+		}
+	}
+
+	index := rtree.NewGeoIndex()
+	if err := index.IndexPoints(merged); err != nil {
+		log.Fatalf("Failed to index points: %v", err)
+	}
+	if err := index.SaveToFile(mergeOutput); err != nil {
+		log.Fatalf("Failed to save index: %v", err)
+	}
+
+	fmt.Printf("Merged %d files into %s: %d points, %d duplicate IDs (%s) in %v\n",
+		len(args), mergeOutput, index.Count(), duplicates, mergeDuplicates, time.Since(start))
+}
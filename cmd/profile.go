@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/1F47E/geo-index-rtree/pkg/latency"
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/1F47E/geo-index-rtree/pkg/rtree"
+	"github.com/spf13/cobra"
+)
+
+var profileCmd = &cobra.Command{
+	Use:   "profile",
+	Short: "Run a query workload while capturing pprof profiles",
+	Long: `Load an index and run random queries within its bounds for --duration while
+recording a CPU profile. Afterwards a heap profile is written, plus block and
+mutex profiles with --block / --mutex, and the run's throughput and latency
+percentiles go to results.json in the same directory.
+
+Attach the whole output directory to performance reports; inspect it with
+  go tool pprof -http=: <dir>/cpu.pprof`,
+	Run: runProfile,
+}
+
+var (
+	profileType      string
+	profileDuration  time.Duration
+	profileWorkers   int
+	profileOutput    string
+	profileBoxSize   float64
+	profileRadius    float64
+	profileNeighbors int
+	profileBlock     bool
+	profileMutex     bool
+)
+
+func init() {
+	profileCmd.Flags().StringVarP(&profileType, "type", "t", "mixed", "Query type: box, radius, nearest, mixed")
+	profileCmd.Flags().DurationVarP(&profileDuration, "duration", "d", 10*time.Second, "How long to run the workload")
+	profileCmd.Flags().IntVarP(&profileWorkers, "workers", "w", runtime.NumCPU(), "Number of concurrent workers")
+	profileCmd.Flags().StringVarP(&profileOutput, "output", "o", "", "Output directory (default profile-<timestamp>)")
+	profileCmd.Flags().Float64Var(&profileBoxSize, "box-size", 1, "Box size in degrees (box queries)")
+	profileCmd.Flags().Float64VarP(&profileRadius, "radius", "r", 50, "Radius in km (radius queries)")
+	profileCmd.Flags().IntVarP(&profileNeighbors, "neighbors", "n", 10, "Number of nearest neighbors (nearest queries)")
+	profileCmd.Flags().BoolVar(&profileBlock, "block", false, "Also capture a blocking profile (adds overhead)")
+	profileCmd.Flags().BoolVar(&profileMutex, "mutex", false, "Also capture a mutex contention profile (adds overhead)")
+
+	rootCmd.AddCommand(profileCmd)
+}
+
+// profileResult is written to results.json next to the profiles
+type profileResult struct {
+	Timestamp   time.Time           `json:"timestamp"`
+	IndexFile   string              `json:"index_file"`
+	IndexPoints int64               `json:"index_points"`
+	Bounds      *models.BoundingBox `json:"bounds,omitempty"`
+	QueryType   string              `json:"query_type"`
+	Workers     int                 `json:"workers"`
+	BoxSize     float64             `json:"box_size,omitempty"`
+	RadiusKm    float64             `json:"radius_km,omitempty"`
+	K           int                 `json:"k,omitempty"`
+	Queries     int64               `json:"queries"`
+	Errors      int64               `json:"errors"`
+	Duration    string              `json:"duration"`
+	QPS         float64             `json:"queries_per_sec"`
+	AvgResults  float64             `json:"avg_results"`
+	Mean        time.Duration       `json:"mean_ns"`
+	P50         time.Duration       `json:"p50_ns"`
+	P99         time.Duration       `json:"p99_ns"`
+	Max         time.Duration       `json:"max_ns"`
+	HeapInuse   uint64              `json:"heap_inuse_bytes"`
+	NumGC       uint32              `json:"num_gc"`
+	GoVersion   string              `json:"go_version"`
+	CPUCores    int                 `json:"cpu_cores"`
+	GOMAXPROCS  int                 `json:"gomaxprocs"`
+	Profiles    []string            `json:"profiles"`
+}
+
+func runProfile(cmd *cobra.Command, args []string) {
+	switch profileType {
+	case "box", "radius", "nearest", "mixed":
+	default:
+		log.Fatalf("Unknown query type: %s", profileType)
+	}
+	if profileWorkers < 1 {
+		profileWorkers = 1
+	}
+
+	index, err := loadIndex(indexFile)
+	if err != nil {
+		log.Fatalf("Failed to load index: %v", err)
+	}
+	bounds, err := index.Bounds()
+	if err != nil {
+		log.Fatalf("Cannot profile %s: %v", indexFile, err)
+	}
+
+	dir := profileOutput
+	if dir == "" {
+		dir = "profile-" + time.Now().Format("20060102-150405")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Fatalf("Failed to create output directory: %v", err)
+	}
+
+	if profileBlock {
+		runtime.SetBlockProfileRate(1)
+		defer runtime.SetBlockProfileRate(0)
+	}
+	if profileMutex {
+		runtime.SetMutexProfileFraction(1)
+		defer runtime.SetMutexProfileFraction(0)
+	}
+
+	cpuFile, err := os.Create(filepath.Join(dir, "cpu.pprof"))
+	if err != nil {
+		log.Fatalf("Failed to create CPU profile: %v", err)
+	}
+	if err := pprof.StartCPUProfile(cpuFile); err != nil {
+		log.Fatalf("Failed to start CPU profile: %v", err)
+	}
+
+	fmt.Printf("Profiling %s queries for %v with %d workers...\n", profileType, profileDuration, profileWorkers)
+	result := runProfileWorkload(index, bounds)
+
+	pprof.StopCPUProfile()
+	if err := cpuFile.Close(); err != nil {
+		log.Fatalf("Failed to write CPU profile: %v", err)
+	}
+	profiles := []string{"cpu.pprof"}
+
+	// Collect garbage first so the heap profile shows live memory
+	runtime.GC()
+	extra := []struct {
+		name    string
+		enabled bool
+	}{{"heap", true}, {"block", profileBlock}, {"mutex", profileMutex}}
+	for _, p := range extra {
+		if !p.enabled {
+			continue
+		}
+		name := p.name + ".pprof"
+		if err := writeProfile(p.name, filepath.Join(dir, name)); err != nil {
+			log.Fatalf("Failed to write %s profile: %v", p.name, err)
+		}
+		profiles = append(profiles, name)
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	result.Timestamp = time.Now().UTC()
+	result.IndexFile = indexFile
+	result.IndexPoints = index.Count()
+	result.Bounds = &bounds
+	result.HeapInuse = mem.HeapInuse
+	result.NumGC = mem.NumGC
+	result.GoVersion = runtime.Version()
+	result.CPUCores = runtime.NumCPU()
+	result.GOMAXPROCS = runtime.GOMAXPROCS(0)
+	result.Profiles = profiles
+
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		log.Fatalf("Failed to encode results: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "results.json"), append(data, '\n'), 0644); err != nil {
+		log.Fatalf("Failed to write results: %v", err)
+	}
+
+	fmt.Printf("\n%d queries in %s: %.0f queries/sec, mean %v, p50 %v, p99 %v, max %v\n",
+		result.Queries, result.Duration, result.QPS, result.Mean, result.P50, result.P99, result.Max)
+	if result.Errors > 0 {
+		fmt.Printf("%d queries failed\n", result.Errors)
+	}
+	fmt.Printf("Heap in use: %s, %d GC cycles\n", formatBytes(int64(result.HeapInuse)), result.NumGC)
+	fmt.Printf("\nWrote results.json and %v to %s\n", profiles, dir)
+	fmt.Printf("Inspect with: go tool pprof -http=: %s\n", filepath.Join(dir, "cpu.pprof"))
+}
+
+// runProfileWorkload runs random queries inside bounds on profileWorkers
+// goroutines until profileDuration elapses
+func runProfileWorkload(index *rtree.GeoIndex, bounds models.BoundingBox) profileResult {
+	query := func(r *rand.Rand, queryType string) (int, error) {
+		lat := bounds.BottomLeft.Lat + r.Float64()*(bounds.TopRight.Lat-bounds.BottomLeft.Lat)
+		lon := bounds.BottomLeft.Lon + r.Float64()*(bounds.TopRight.Lon-bounds.BottomLeft.Lon)
+		center := models.Location{Lat: lat, Lon: lon}
+
+		switch queryType {
+		case "box":
+			results, err := index.QueryBox(models.BoundingBox{
+				BottomLeft: models.Location{Lat: lat - profileBoxSize/2, Lon: lon - profileBoxSize/2},
+				TopRight:   models.Location{Lat: lat + profileBoxSize/2, Lon: lon + profileBoxSize/2},
+			})
+			return len(results), err
+		case "radius":
+			results, err := index.QueryRadius(center, profileRadius)
+			return len(results), err
+		default:
+			return len(index.NearestNeighbors(center, profileNeighbors)), nil
+		}
+	}
+	mixed := []string{"box", "radius", "nearest"}
+
+	var (
+		queries, failed, found atomic.Int64
+		mu                     sync.Mutex
+		wg                     sync.WaitGroup
+	)
+	hist := latency.NewHistogram()
+	deadline := time.Now().Add(profileDuration)
+	start := time.Now()
+
+	wg.Add(profileWorkers)
+	for w := 0; w < profileWorkers; w++ {
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			local := latency.NewHistogram()
+
+			for i := 0; time.Now().Before(deadline); i++ {
+				queryType := profileType
+				if queryType == "mixed" {
+					queryType = mixed[i%len(mixed)]
+				}
+
+				queryStart := time.Now()
+				n, err := query(r, queryType)
+				local.Record(time.Since(queryStart))
+				queries.Add(1)
+				if err != nil {
+					failed.Add(1)
+					continue
+				}
+				found.Add(int64(n))
+			}
+
+			mu.Lock()
+			hist.Merge(local)
+			mu.Unlock()
+		}(time.Now().UnixNano() + int64(w))
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	result := profileResult{
+		QueryType: profileType,
+		Workers:   profileWorkers,
+		Queries:   queries.Load(),
+		Errors:    failed.Load(),
+		Duration:  elapsed.Round(time.Millisecond).String(),
+		QPS:       float64(queries.Load()) / elapsed.Seconds(),
+		Mean:      hist.Mean(),
+		P50:       hist.Percentile(50),
+		P99:       hist.Percentile(99),
+		Max:       hist.Max(),
+	}
+	if ok := result.Queries - result.Errors; ok > 0 {
+		result.AvgResults = float64(found.Load()) / float64(ok)
+	}
+	if profileType == "box" || profileType == "mixed" {
+		result.BoxSize = profileBoxSize
+	}
+	if profileType == "radius" || profileType == "mixed" {
+		result.RadiusKm = profileRadius
+	}
+	if profileType == "nearest" || profileType == "mixed" {
+		result.K = profileNeighbors
+	}
+	return result
+}
+
+// writeProfile saves a named runtime profile (heap, block, mutex) to path
+func writeProfile(name, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := pprof.Lookup(name).WriteTo(f, 0); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/1F47E/geo-index-rtree/pkg/rtree"
+)
+
+// batchQuery is one line of batch input. JSON lines use these field names;
+// CSV lines are "box,min_lat,min_lon,max_lat,max_lon", "radius,lat,lon[,radius_km]"
+// or "nearest,lat,lon[,k]".
+type batchQuery struct {
+	ID       string  `json:"id,omitempty"`
+	Type     string  `json:"type"`
+	MinLat   float64 `json:"min_lat,omitempty"`
+	MinLon   float64 `json:"min_lon,omitempty"`
+	MaxLat   float64 `json:"max_lat,omitempty"`
+	MaxLon   float64 `json:"max_lon,omitempty"`
+	Lat      float64 `json:"lat,omitempty"`
+	Lon      float64 `json:"lon,omitempty"`
+	RadiusKm float64 `json:"radius_km,omitempty"`
+	K        int     `json:"k,omitempty"`
+}
+
+// batchResult is written as one NDJSON line per input query, in input order
+type batchResult struct {
+	Line   int             `json:"line"`
+	ID     string          `json:"id,omitempty"`
+	Type   string          `json:"type"`
+	Count  int             `json:"count"`
+	TookUs int64           `json:"took_us"`
+	Points []*models.Point `json:"points,omitempty"`
+	Error  string          `json:"error,omitempty"`
+
+	seq int
+}
+
+// maxBatchK caps k of nearest queries, so one line cannot size a search past
+// memory and take the whole batch down
+const maxBatchK = 10000
+
+// batchDefaults fill in radius and k for queries that omit them
+type batchDefaults struct {
+	radiusKm float64
+	k        int
+	limit    int
+}
+
+type batchJob struct {
+	seq  int
+	line int
+	text string
+}
+
+// runBatch executes every query read from in on a pool of workers and writes
+// results to out as NDJSON. It returns the number of queries and failures.
+func runBatch(index *rtree.GeoIndex, in io.Reader, out io.Writer, workers int, defaults batchDefaults) (int, int, error) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan batchJob, workers*4)
+	results := make(chan batchResult, workers*4)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				results <- executeBatchQuery(index, job, defaults)
+			}
+		}()
+	}
+
+	// Read input in the background so workers and the writer run concurrently
+	var readErr error
+	go func() {
+		defer close(jobs)
+		scanner := bufio.NewScanner(in)
+		line, seq := 0, 0
+		for scanner.Scan() {
+			line++
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" || strings.HasPrefix(text, "#") {
+				continue
+			}
+			jobs <- batchJob{seq: seq, line: line, text: text}
+			seq++
+		}
+		readErr = scanner.Err()
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Results arrive out of order; buffer them until the next one in sequence is ready
+	w := bufio.NewWriter(out)
+	enc := json.NewEncoder(w)
+	pending := make(map[int]batchResult)
+	next, failed := 0, 0
+	var writeErr error
+
+	for result := range results {
+		if result.Error != "" {
+			failed++
+		}
+		pending[result.seq] = result
+
+		for {
+			ready, ok := pending[next]
+			if !ok {
+				break
+			}
+			// Keep draining after a write error so workers can finish
+			if writeErr == nil {
+				writeErr = enc.Encode(ready)
+			}
+			delete(pending, next)
+			next++
+		}
+	}
+
+	if writeErr == nil {
+		writeErr = w.Flush()
+	}
+	if writeErr != nil {
+		return next, failed, writeErr
+	}
+	return next, failed, readErr
+}
+
+func executeBatchQuery(index *rtree.GeoIndex, job batchJob, defaults batchDefaults) batchResult {
+	result := batchResult{Line: job.line, seq: job.seq}
+
+	q, err := parseBatchQuery(job.text)
+	result.ID, result.Type = q.ID, q.Type
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	var points []*models.Point
+	start := time.Now()
+	switch q.Type {
+	case "box":
+		points, err = index.QueryBox(models.BoundingBox{
+			BottomLeft: models.Location{Lat: q.MinLat, Lon: q.MinLon},
+			TopRight:   models.Location{Lat: q.MaxLat, Lon: q.MaxLon},
+		})
+	case "radius":
+		if q.RadiusKm == 0 {
+			q.RadiusKm = defaults.radiusKm
+		}
+		points, err = index.QueryRadius(models.Location{Lat: q.Lat, Lon: q.Lon}, q.RadiusKm)
+	case "nearest":
+		if q.K == 0 {
+			q.K = defaults.k
+		}
+		if q.K <= 0 || q.K > maxBatchK {
+			err = fmt.Errorf("invalid k %d: must be between 1 and %d", q.K, maxBatchK)
+			break
+		}
+		points = index.NearestNeighbors(models.Location{Lat: q.Lat, Lon: q.Lon}, q.K)
+	default:
+		err = fmt.Errorf("unknown query type %q", q.Type)
+	}
+	result.TookUs = time.Since(start).Microseconds()
+
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Count = len(points)
+	if defaults.limit > 0 && len(points) > defaults.limit {
+		points = points[:defaults.limit]
+	}
+	result.Points = points
+	return result
+}
+
+// parseBatchQuery accepts either a JSON object or a CSV record
+func parseBatchQuery(text string) (batchQuery, error) {
+	var q batchQuery
+	if strings.HasPrefix(text, "{") {
+		if err := json.Unmarshal([]byte(text), &q); err != nil {
+			return q, fmt.Errorf("invalid json query: %w", err)
+		}
+		return q, nil
+	}
+
+	record, err := csv.NewReader(bytes.NewBufferString(text)).Read()
+	if err != nil {
+		return q, fmt.Errorf("invalid csv query: %w", err)
+	}
+	q.Type = strings.ToLower(strings.TrimSpace(record[0]))
+
+	// radius_km and k are optional and fall back to the command line defaults
+	minFields, maxFields := 3, 4
+	switch q.Type {
+	case "box":
+		minFields, maxFields = 5, 5
+	case "radius", "nearest":
+	default:
+		return q, fmt.Errorf("unknown query type %q", q.Type)
+	}
+	if len(record) < minFields || len(record) > maxFields {
+		want := strconv.Itoa(maxFields)
+		if minFields != maxFields {
+			want = fmt.Sprintf("%d or %d", minFields, maxFields)
+		}
+		return q, fmt.Errorf("%s query needs %s fields, got %d", q.Type, want, len(record))
+	}
+
+	values := make([]float64, maxFields-1)
+	for i, field := range record[1:] {
+		if values[i], err = strconv.ParseFloat(strings.TrimSpace(field), 64); err != nil {
+			return q, fmt.Errorf("invalid number %q", field)
+		}
+	}
+
+	switch q.Type {
+	case "box":
+		q.MinLat, q.MinLon, q.MaxLat, q.MaxLon = values[0], values[1], values[2], values[3]
+	case "radius":
+		q.Lat, q.Lon, q.RadiusKm = values[0], values[1], values[2]
+	case "nearest":
+		q.Lat, q.Lon = values[0], values[1]
+		// Without a k field K stays 0 and executeBatchQuery applies the default
+		if len(record) == 4 {
+			// Out of range floats have no defined int conversion
+			if k := values[2]; k != math.Trunc(k) || k < 1 || k > maxBatchK {
+				return q, fmt.Errorf("invalid k %g: must be a whole number between 1 and %d", k, maxBatchK)
+			}
+			q.K = int(values[2])
+		}
+	}
+	return q, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBatchQueryCSV(t *testing.T) {
+	cases := []struct {
+		line string
+		want batchQuery
+	}{
+		{"box,1,2,3,4", batchQuery{Type: "box", MinLat: 1, MinLon: 2, MaxLat: 3, MaxLon: 4}},
+		{"radius,10,20", batchQuery{Type: "radius", Lat: 10, Lon: 20}},
+		{"radius,10,20,5", batchQuery{Type: "radius", Lat: 10, Lon: 20, RadiusKm: 5}},
+		{"nearest,10,20", batchQuery{Type: "nearest", Lat: 10, Lon: 20}},
+		{"nearest,10,20,7", batchQuery{Type: "nearest", Lat: 10, Lon: 20, K: 7}},
+	}
+	for _, tc := range cases {
+		got, err := parseBatchQuery(tc.line)
+		require.NoError(t, err, tc.line)
+		assert.Equal(t, tc.want, got, tc.line)
+	}
+}
+
+func TestParseBatchQueryCSVErrors(t *testing.T) {
+	for _, line := range []string{
+		"box,1,2,3",
+		"radius,10",
+		"nearest,10,20,0",
+		"nearest,10,20,1.5",
+		"nearest,10,20,1e30",
+		"nearest,10,20,7,8",
+		"circle,10,20",
+	} {
+		_, err := parseBatchQuery(line)
+		assert.Error(t, err, line)
+	}
+}
@@ -6,7 +6,11 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"runtime"
+	"time"
 
+	"github.com/1F47E/geo-index-rtree/pkg/formats"
+	"github.com/1F47E/geo-index-rtree/pkg/geohash"
 	"github.com/1F47E/geo-index-rtree/pkg/models"
 	"github.com/1F47E/geo-index-rtree/pkg/rtree"
 )
@@ -24,14 +28,29 @@ func main() {
 		centerLat = flag.Float64("lat", 0, "Center latitude (radius/nearest query)")
 		centerLon = flag.Float64("lon", 0, "Center longitude (radius/nearest query)")
 		radius    = flag.Float64("radius", 10, "Radius in km (radius query)")
+		// Geohash cell: the box for box queries, the center for radius/nearest
+		geohashCell = flag.String("geohash", "", "Geohash cell to query, e.g. u4pruyd (box query of the cell, or center of radius/nearest)")
 		// Nearest query parameters
 		k = flag.Int("k", 10, "Number of nearest neighbors (nearest query)")
 		// Output format
-		outputJSON = flag.Bool("json", false, "Output results as JSON")
+		outputJSON = flag.Bool("json", false, "Output results as JSON (same as -format json)")
+		format     = flag.String("format", "text", "Output format: text, json, geojson, csv, ndjson")
 		limit      = flag.Int("limit", 100, "Maximum number of results to display")
+		// Batch mode
+		batchFile = flag.String("batch", "", "Read queries from a file (\"-\" for stdin), one JSON or CSV query per line, and write NDJSON results")
+		workers   = flag.Int("w", runtime.NumCPU(), "Number of concurrent workers (batch mode)")
 	)
 	flag.Parse()
 
+	if *outputJSON {
+		*format = "json"
+	}
+	switch *format {
+	case "text", "json", "geojson", "csv", "ndjson":
+	default:
+		log.Fatalf("Unknown output format: %s", *format)
+	}
+
 	// Load index
 	log.Printf("Loading index from %s...\n", *indexFile)
 	index := rtree.NewGeoIndex()
@@ -40,13 +59,47 @@ func main() {
 	}
 	log.Printf("Index loaded with %d points\n", index.Count())
 
+	if *batchFile != "" {
+		in := os.Stdin
+		if *batchFile != "-" {
+			f, err := os.Open(*batchFile)
+			if err != nil {
+				log.Fatalf("Failed to open batch file: %v", err)
+			}
+			defer f.Close()
+			in = f
+		}
+
+		start := time.Now()
+		total, failed, err := runBatch(index, in, os.Stdout, *workers, batchDefaults{
+			radiusKm: *radius,
+			k:        *k,
+			limit:    *limit,
+		})
+		if err != nil {
+			log.Fatalf("Batch failed: %v", err)
+		}
+		log.Printf("Ran %d queries (%d failed) in %v\n", total, failed, time.Since(start))
+		return
+	}
+
+	if *geohashCell != "" {
+		box, err := geohash.DecodeBounds(*geohashCell)
+		if err != nil {
+			log.Fatalf("Invalid geohash: %v", err)
+		}
+		*minLat, *minLon = box.BottomLeft.Lat, box.BottomLeft.Lon
+		*maxLat, *maxLon = box.TopRight.Lat, box.TopRight.Lon
+		*centerLat, *centerLon, _ = geohash.Decode(*geohashCell)
+	}
+
 	var results []*models.Point
 	var err error
 
 	switch *queryType {
 	case "box":
 		if *minLat == 0 && *maxLat == 0 && *minLon == 0 && *maxLon == 0 {
-			log.Fatal("Box query requires --min-lat, --max-lat, --min-lon, --max-lon")
+			log.Fatal("Box query requires --min-lat, --max-lat, --min-lon, --max-lon or --geohash")
 		}
 		box := models.BoundingBox{
 			BottomLeft: models.Location{Lat: *minLat, Lon: *minLon},
@@ -60,7 +113,7 @@ func main() {
 
 	case "radius":
 		if *centerLat == 0 && *centerLon == 0 {
-			log.Fatal("Radius query requires --lat and --lon for center point")
+			log.Fatal("Radius query requires --lat and --lon (or --geohash) for center point")
 		}
 		center := models.Location{Lat: *centerLat, Lon: *centerLon}
 		results, err = index.QueryRadius(center, *radius)
@@ -71,7 +124,7 @@ func main() {
 
 	case "nearest":
 		if *centerLat == 0 && *centerLon == 0 {
-			log.Fatal("Nearest query requires --lat and --lon for center point")
+			log.Fatal("Nearest query requires --lat and --lon (or --geohash) for center point")
 		}
 		center := models.Location{Lat: *centerLat, Lon: *centerLon}
 		results = index.NearestNeighbors(center, *k)
@@ -88,13 +141,27 @@ func main() {
 	}
 
 	// Output results
-	if *outputJSON {
+	switch *format {
+	case "json":
 		encoder := json.NewEncoder(os.Stdout)
 		encoder.SetIndent("", "  ")
 		if err := encoder.Encode(results); err != nil {
 			log.Fatalf("Failed to encode results: %v", err)
 		}
-	} else {
+	case "geojson", "csv", "ndjson":
+		w, err := formats.NewWriter(formats.Format(*format), os.Stdout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, point := range results {
+			if err := w.Write(point); err != nil {
+				log.Fatalf("Failed to write results: %v", err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			log.Fatalf("Failed to write results: %v", err)
+		}
+	default:
 		for i, point := range results {
 			if *queryType == "radius" || *queryType == "nearest" {
 				dist := rtree.Distance(*centerLat, *centerLon, 
@@ -107,4 +174,4 @@ func main() {
 			}
 		}
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/1F47E/geo-index-rtree/pkg/rtree"
+	"github.com/chzyer/readline"
+	"github.com/spf13/cobra"
+)
+
+var replCmd = &cobra.Command{
+	Use:   "repl",
+	Short: "Interactive query shell over a loaded index",
+	Long: `Load an R-Tree index once and run queries against it interactively.
+
+Commands:
+  box <min-lat> <min-lon> <max-lat> <max-lon>   Bounding box query
+  radius <lat> <lon> <km>                       Radius search
+  nearest <lat> <lon> [k]                       k nearest neighbors (default k=10)
+  stats                                         Index statistics
+  map [width]                                   World map of point density (default width 72)
+  help                                          Show commands
+  exit | quit                                   Leave the shell`,
+	Run: runRepl,
+}
+
+var replLimit int
+
+func init() {
+	replCmd.Flags().IntVarP(&replLimit, "limit", "l", 20, "Maximum number of results to print per query")
+
+	rootCmd.AddCommand(replCmd)
+}
+
+func runRepl(cmd *cobra.Command, args []string) {
+	index, err := loadIndex(indexFile)
+	if err != nil {
+		log.Fatalf("Failed to load index: %v", err)
+	}
+
+	historyFile := ""
+	if home, err := os.UserHomeDir(); err == nil {
+		historyFile = filepath.Join(home, ".go_geo_index_history")
+	}
+
+	rl, err := readline.NewEx(&readline.Config{
+		Prompt:          "geo> ",
+		HistoryFile:     historyFile,
+		InterruptPrompt: "^C",
+		EOFPrompt:       "exit",
+		AutoComplete: readline.NewPrefixCompleter(
+			readline.PcItem("box"),
+			readline.PcItem("radius"),
+			readline.PcItem("nearest"),
+			readline.PcItem("stats"),
+			readline.PcItem("map"),
+			readline.PcItem("help"),
+			readline.PcItem("exit"),
+			readline.PcItem("quit"),
+		),
+	})
+	if err != nil {
+		log.Fatalf("Failed to start shell: %v", err)
+	}
+	defer rl.Close()
+
+	fmt.Println("Type 'help' for available commands")
+
+	for {
+		line, err := rl.Readline()
+		if errors.Is(err, readline.ErrInterrupt) {
+			if len(line) == 0 {
+				return
+			}
+			continue
+		}
+		if errors.Is(err, io.EOF) {
+			return
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "exit", "quit":
+			return
+		case "help":
+			fmt.Println(cmd.Long)
+		case "stats":
+			printReplStats(index)
+		case "map":
+			if err := runReplMap(index, fields[1:]); err != nil {
+				fmt.Printf("Error: %v\n", err)
+			}
+		case "box", "radius", "nearest":
+			if err := runReplQuery(index, fields[0], fields[1:]); err != nil {
+				fmt.Printf("Error: %v\n", err)
+			}
+		default:
+			fmt.Printf("Unknown command %q, type 'help' for available commands\n", fields[0])
+		}
+	}
+}
+
+// runReplQuery executes a single box/radius/nearest command and prints the results
+func runReplQuery(index *rtree.GeoIndex, command string, args []string) error {
+	var (
+		results []*models.Point
+		center  *models.Location
+		err     error
+	)
+
+	start := time.Now()
+	switch command {
+	case "box":
+		v, err := parseFloats(args, 4)
+		if err != nil {
+			return fmt.Errorf("usage: box <min-lat> <min-lon> <max-lat> <max-lon>: %w", err)
+		}
+		box := models.BoundingBox{
+			BottomLeft: models.Location{Lat: v[0], Lon: v[1]},
+			TopRight:   models.Location{Lat: v[2], Lon: v[3]},
+		}
+		if results, err = index.QueryBox(box); err != nil {
+			return err
+		}
+
+	case "radius":
+		v, err := parseFloats(args, 3)
+		if err != nil {
+			return fmt.Errorf("usage: radius <lat> <lon> <km>: %w", err)
+		}
+		center = &models.Location{Lat: v[0], Lon: v[1]}
+		if results, err = index.QueryRadius(*center, v[2]); err != nil {
+			return err
+		}
+
+	case "nearest":
+		k := 10
+		if len(args) == 3 {
+			if k, err = strconv.Atoi(args[2]); err != nil || k <= 0 {
+				return fmt.Errorf("invalid k %q", args[2])
+			}
+			args = args[:2]
+		}
+		v, err := parseFloats(args, 2)
+		if err != nil {
+			return fmt.Errorf("usage: nearest <lat> <lon> [k]: %w", err)
+		}
+		center = &models.Location{Lat: v[0], Lon: v[1]}
+		results = index.NearestNeighbors(*center, k)
+	}
+	elapsed := time.Since(start)
+
+	printPoints(results, center, replLimit)
+	fmt.Printf("%d results in %v\n", len(results), elapsed)
+	return nil
+}
+
+func printReplStats(index *rtree.GeoIndex) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	fmt.Printf("Index file: %s\n", indexFile)
+	fmt.Printf("Points: %d\n", index.Count())
+	fmt.Printf("Heap in use: %.2f MB\n", float64(mem.HeapInuse)/(1<<20))
+}
+
+// runReplMap draws the density map, optionally at the width given in args
+func runReplMap(index *rtree.GeoIndex, args []string) error {
+	width := 72
+	switch len(args) {
+	case 0:
+	case 1:
+		w, err := strconv.Atoi(args[0])
+		if err != nil || w <= 0 {
+			return fmt.Errorf("invalid width %q", args[0])
+		}
+		width = w
+	default:
+		return fmt.Errorf("expected at most 1 argument, got %d", len(args))
+	}
+	return printDensityMap(index, width)
+}
+
+// parseFloats parses exactly n float arguments
+func parseFloats(args []string, n int) ([]float64, error) {
+	if len(args) != n {
+		return nil, fmt.Errorf("expected %d arguments, got %d", n, len(args))
+	}
+
+	values := make([]float64, n)
+	for i, arg := range args {
+		v, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", arg)
+		}
+		values[i] = v
+	}
+	return values, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/1F47E/geo-index-rtree/pkg/rtree"
+	"github.com/spf13/cobra"
+)
+
+var sampleCmd = &cobra.Command{
+	Use:   "sample",
+	Short: "Extract a random sample of points into a smaller index or file",
+	Long: `Pick N points from an index file and write them to a new index (.gob) or to
+GeoJSON, CSV, NDJSON, FlatGeobuf or Parquet (chosen by the output extension or --format).
+
+Methods:
+  random      uniform sample over all points (default)
+  stratified  split the data extent into a grid and sample each cell in
+              proportion to its point count, so sparse regions stay represented`,
+	Run: runSample,
+}
+
+var (
+	sampleCount  int
+	sampleMethod string
+	sampleGrid   int
+	sampleSeed   int64
+	sampleOutput string
+	sampleFormat string
+)
+
+func init() {
+	sampleCmd.Flags().IntVarP(&sampleCount, "points", "n", 1000, "Number of points to sample")
+	sampleCmd.Flags().StringVar(&sampleMethod, "method", "random", "Sampling method: random, stratified")
+	sampleCmd.Flags().IntVar(&sampleGrid, "grid", 16, "Grid cells per axis for stratified sampling")
+	sampleCmd.Flags().Int64Var(&sampleSeed, "seed", 0, "Random seed (0 uses the current time)")
+	sampleCmd.Flags().StringVarP(&sampleOutput, "output", "o", "", "Output file: .gob index, .geojson, .csv or .ndjson (required)")
+	sampleCmd.Flags().StringVar(&sampleFormat, "format", "", "Output format: gob, geojson, csv, ndjson, flatgeobuf, parquet (detected from extension by default)")
+	_ = sampleCmd.MarkFlagRequired("output")
+
+	rootCmd.AddCommand(sampleCmd)
+}
+
+func runSample(cmd *cobra.Command, args []string) {
+	if sampleCount < 1 {
+		log.Fatalf("--points must be at least 1, got %d", sampleCount)
+	}
+	points, err := rtree.ReadPoints(indexFile)
+	if err != nil {
+		log.Fatalf("Failed to read index: %v", err)
+	}
+
+	seed := sampleSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	r := rand.New(rand.NewSource(seed))
+
+	var sample []*models.Point
+	switch sampleMethod {
+	case "random":
+		sample = randomSample(points, sampleCount, r)
+	case "stratified":
+		sample = stratifiedSample(points, sampleCount, sampleGrid, r)
+	default:
+		log.Fatalf("Unknown sampling method %q (use random or stratified)", sampleMethod)
+	}
+
+	if err := writePointsFile(sampleOutput, sampleFormat, sample); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Sampled %d of %d points (%s, seed %d) into %s\n",
+		len(sample), len(points), sampleMethod, seed, sampleOutput)
+}
+
+// randomSample picks n distinct points uniformly with a partial Fisher-Yates shuffle
+func randomSample(points []*models.Point, n int, r *rand.Rand) []*models.Point {
+	if n >= len(points) {
+		return points
+	}
+	shuffled := make([]*models.Point, len(points))
+	copy(shuffled, points)
+	for i := 0; i < n; i++ {
+		j := i + r.Intn(len(shuffled)-i)
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	}
+	return shuffled[:n]
+}
+
+// stratifiedSample buckets points into a grid over their extent and allocates
+// the sample to cells in proportion to their size (largest remainder method)
+func stratifiedSample(points []*models.Point, n, grid int, r *rand.Rand) []*models.Point {
+	if n >= len(points) || grid < 1 {
+		return randomSample(points, n, r)
+	}
+
+	var bounds *models.BoundingBox
+	for _, p := range points {
+		if p.Location == nil {
+			continue
+		}
+		if bounds == nil {
+			bounds = &models.BoundingBox{BottomLeft: *p.Location, TopRight: *p.Location}
+			continue
+		}
+		*bounds = bounds.Union(models.BoundingBox{BottomLeft: *p.Location, TopRight: *p.Location})
+	}
+	if bounds == nil {
+		return nil
+	}
+
+	cellOf := func(v, lo, hi float64) int {
+		if hi <= lo {
+			return 0
+		}
+		c := int((v - lo) / (hi - lo) * float64(grid))
+		if c >= grid {
+			c = grid - 1
+		}
+		return c
+	}
+
+	cells := make(map[int][]*models.Point)
+	for _, p := range points {
+		if p.Location == nil {
+			continue
+		}
+		row := cellOf(p.Location.Lat, bounds.BottomLeft.Lat, bounds.TopRight.Lat)
+		col := cellOf(p.Location.Lon, bounds.BottomLeft.Lon, bounds.TopRight.Lon)
+		cells[row*grid+col] = append(cells[row*grid+col], p)
+	}
+
+	type allocation struct {
+		cell      int
+		quota     int
+		remainder float64
+	}
+	allocs := make([]allocation, 0, len(cells))
+	assigned := 0
+	for cell, members := range cells {
+		exact := float64(n) * float64(len(members)) / float64(len(points))
+		quota := int(exact)
+		allocs = append(allocs, allocation{cell, quota, exact - float64(quota)})
+		assigned += quota
+	}
+
+	// Hand out the rounding leftovers to the cells that lost the most
+	sort.Slice(allocs, func(i, j int) bool {
+		if allocs[i].remainder != allocs[j].remainder {
+			return allocs[i].remainder > allocs[j].remainder
+		}
+		return allocs[i].cell < allocs[j].cell
+	})
+	for i := 0; assigned < n && i < len(allocs); i++ {
+		allocs[i].quota++
+		assigned++
+	}
+
+	sample := make([]*models.Point, 0, n)
+	for _, a := range allocs {
+		sample = append(sample, randomSample(cells[a.cell], a.quota, r)...)
+	}
+	return sample
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/1F47E/geo-index-rtree/pkg/rtree"
+	"github.com/1F47E/geo-index-rtree/pkg/server"
+	"github.com/spf13/cobra"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve the index over HTTP",
+	Long: `Load an R-Tree index and serve box, radius and nearest neighbor queries over HTTP.
+
+Endpoints:
+  GET  /query/box?min_lat=&min_lon=&max_lat=&max_lon=
+  GET  /query/radius?lat=&lon=&radius_km=
+  GET  /query/nearest?lat=&lon=&k=
+  POST /points          (JSON array of points)
+  GET  /stats
+  GET  /metrics         (with --metrics)
+  GET  /ui              (with --ui, a map for drawing box and radius queries)
+  GET  /health`,
+	Run: runServe,
+}
+
+var (
+	serveHost             string
+	servePort             int
+	serveAuthToken        string
+	serveMetrics          bool
+	serveUI               bool
+	serveSnapshotInterval time.Duration
+)
+
+func init() {
+	serveCmd.Flags().StringVar(&serveHost, "host", "", "Host to listen on")
+	serveCmd.Flags().IntVarP(&servePort, "port", "p", 8080, "Port to listen on")
+	serveCmd.Flags().StringVar(&serveAuthToken, "auth-token", "", "Require this bearer token on all endpoints except /health")
+	serveCmd.Flags().BoolVar(&serveMetrics, "metrics", false, "Expose Prometheus metrics at /metrics")
+	serveCmd.Flags().BoolVar(&serveUI, "ui", false, "Serve a map at /ui for drawing queries in the browser")
+	serveCmd.Flags().DurationVar(&serveSnapshotInterval, "snapshot-interval", 0, "Save the index back to --file at this interval when modified (0 disables)")
+
+	rootCmd.AddCommand(serveCmd)
+}
+
+func runServe(cmd *cobra.Command, args []string) {
+	var indexMetrics *rtree.PrometheusMetrics
+	var opts []rtree.Option
+	if serveMetrics {
+		indexMetrics = rtree.NewPrometheusMetrics("geoindex_index")
+		opts = append(opts, rtree.WithMetrics(indexMetrics))
+	}
+	index, err := loadIndex(indexFile, opts...)
+	if err != nil {
+		log.Fatalf("Failed to load index: %v", err)
+	}
+
+	srv := server.New(index, server.Config{
+		Addr:             fmt.Sprintf("%s:%d", serveHost, servePort),
+		AuthToken:        serveAuthToken,
+		EnableMetrics:    serveMetrics,
+		EnableUI:         serveUI,
+		IndexMetrics:     indexMetrics,
+		SnapshotFile:     indexFile,
+		SnapshotInterval: serveSnapshotInterval,
+		Logger:           serveLogger(),
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("Serving %d points on %s:%d\n", index.Count(), serveHost, servePort)
+	if serveAuthToken != "" {
+		fmt.Println("Bearer token authentication enabled")
+	}
+	if serveUI {
+		host := serveHost
+		if host == "" {
+			host = "localhost"
+		}
+		fmt.Printf("Map UI at http://%s:%d/ui\n", host, servePort)
+	}
+	if serveSnapshotInterval > 0 {
+		fmt.Printf("Auto-snapshot to %s every %v\n", indexFile, serveSnapshotInterval)
+	}
+
+	if err := srv.ListenAndServe(ctx); err != nil {
+		log.Fatalf("Server error: %v", err)
+	}
+	fmt.Println("Server stopped")
+}
+
+// serveLogger logs server events to stderr, including failed requests with
+// --verbose
+func serveLogger() *slog.Logger {
+	level := slog.LevelInfo
+	if verbose {
+		level = slog.LevelDebug
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+}
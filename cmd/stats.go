@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/1F47E/geo-index-rtree/pkg/heatmap"
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/1F47E/geo-index-rtree/pkg/rtree"
+	"github.com/spf13/cobra"
+)
+
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show index metadata and point distribution",
+	Long:  `Print the point count, geographic bounds, per-partition distribution and estimated memory and disk sizes of an index file.`,
+	Run:   runStats,
+}
+
+var (
+	statsJSON      bool
+	statsMap       bool
+	statsMapWidth  int
+	statsStructure string
+)
+
+func init() {
+	statsCmd.Flags().BoolVar(&statsJSON, "json", false, "Print stats as JSON")
+	statsCmd.Flags().BoolVar(&statsMap, "map", false, "Draw a world map of point density")
+	statsCmd.Flags().IntVar(&statsMapWidth, "map-width", 72, "Width of the density map in characters")
+	statsCmd.Flags().StringVar(&statsStructure, "structure", "", "Write the partitions and tree bounding boxes to this GeoJSON file")
+
+	rootCmd.AddCommand(statsCmd)
+}
+
+// fileStats combines index statistics with metadata about the file it was loaded from
+type fileStats struct {
+	File      string    `json:"file"`
+	DiskBytes int64     `json:"disk_bytes"`
+	Modified  time.Time `json:"modified"`
+	LoadTime  string    `json:"load_time"`
+	rtree.IndexStats
+}
+
+func runStats(cmd *cobra.Command, args []string) {
+	info, err := os.Stat(indexFile)
+	if err != nil {
+		log.Fatalf("Failed to stat index: %v", err)
+	}
+
+	start := time.Now()
+	index := rtree.NewGeoIndex()
+	if err := index.LoadFromFile(indexFile); err != nil {
+		log.Fatalf("Failed to load index: %v", err)
+	}
+
+	stats := fileStats{
+		File:       indexFile,
+		DiskBytes:  info.Size(),
+		Modified:   info.ModTime(),
+		LoadTime:   time.Since(start).String(),
+		IndexStats: index.Stats(),
+	}
+
+	if statsStructure != "" {
+		if err := writeStructure(index, statsStructure); err != nil {
+			log.Fatalf("Failed to write structure: %v", err)
+		}
+	}
+
+	if statsJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(stats); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	printStats(stats)
+	if statsMap {
+		fmt.Println()
+		if err := printDensityMap(index, statsMapWidth); err != nil {
+			log.Fatalf("Failed to draw density map: %v", err)
+		}
+	}
+}
+
+func printStats(stats fileStats) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "File:\t%s\n", stats.File)
+	fmt.Fprintf(w, "Modified:\t%s\n", stats.Modified.Format(time.RFC3339))
+	fmt.Fprintf(w, "Load time:\t%s\n", stats.LoadTime)
+	fmt.Fprintf(w, "Points:\t%d\n", stats.Count)
+	fmt.Fprintf(w, "Partitions:\t%d\n", len(stats.Partitions))
+	fmt.Fprintf(w, "Bounds:\t%s\n", formatBounds(stats.Bounds))
+	fmt.Fprintf(w, "Disk size:\t%s\n", formatBytes(stats.DiskBytes))
+	fmt.Fprintf(w, "Memory (est.):\t%s\n", formatBytes(stats.EstimatedBytes))
+	w.Flush()
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "Partition\tLon range\tPoints\tShare\tDepth\t")
+	for _, p := range stats.Partitions {
+		share := 0.0
+		if stats.Count > 0 {
+			share = float64(p.Count) / float64(stats.Count) * 100
+		}
+		fmt.Fprintf(w, "%d\t%.1f..%.1f\t%d\t%.1f%%\t%d\t\n",
+			p.Index, p.Region.BottomLeft.Lon, p.Region.TopRight.Lon, p.Count, share, p.Depth)
+	}
+	w.Flush()
+}
+
+// printDensityMap draws the point density of index as a world map width
+// characters wide, framed so empty edges stay visible
+func printDensityMap(index *rtree.GeoIndex, width int) error {
+	world := models.BoundingBox{
+		BottomLeft: models.Location{Lat: -90, Lon: -180},
+		TopRight:   models.Location{Lat: 90, Lon: 180},
+	}
+	// Terminal cells are about twice as tall as wide
+	grid, err := index.DensityGrid(world, max(width/4, 1), width, rtree.Equirectangular)
+	if err != nil {
+		return err
+	}
+
+	border := "+" + strings.Repeat("-", width) + "+"
+	fmt.Println(border)
+	for _, line := range strings.Split(strings.TrimSuffix(heatmap.Text(grid, heatmap.Options{}), "\n"), "\n") {
+		fmt.Printf("|%s|\n", line)
+	}
+	fmt.Println(border)
+	fmt.Printf("Shading %q from 1 to %d points per cell (log scale)\n", heatmap.Shades, grid.Max)
+	return nil
+}
+
+// writeStructure writes the shape of index to a GeoJSON file for QGIS
+func writeStructure(index *rtree.GeoIndex, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := index.DumpStructure(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func formatBounds(box *models.BoundingBox) string {
+	if box == nil {
+		return "(empty)"
+	}
+	return fmt.Sprintf("(%.6f, %.6f) - (%.6f, %.6f)",
+		box.BottomLeft.Lat, box.BottomLeft.Lon, box.TopRight.Lat, box.TopRight.Lon)
+}
+
+// formatBytes renders a byte count with a binary unit suffix
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
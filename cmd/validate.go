@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/1F47E/geo-index-rtree/pkg/backend"
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/1F47E/geo-index-rtree/pkg/rtree"
+	"github.com/spf13/cobra"
+)
+
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Cross-check index queries against a brute-force scan",
+	Long: `Load an index, run random box, radius and nearest neighbor queries and compare
+every result against a linear scan of all stored points. The points are indexed
+with the layout chosen by --backend. Queries are spread over the whole globe, so
+partition boundaries, the poles and the antimeridian are exercised; boxes
+reaching past longitude 180 run against a second index built with longitude
+wrapping, which splits them.
+
+Exits with status 1 if any query returns a different result set.`,
+	Run: runValidate,
+}
+
+var (
+	validateQueries  int
+	validateSeed     int64
+	validateK        int
+	validateShow     int
+	validateMaxBox   float64
+	validateMaxRange float64
+)
+
+func init() {
+	validateCmd.Flags().IntVarP(&validateQueries, "queries", "q", 1000, "Number of queries per query type")
+	validateCmd.Flags().Int64Var(&validateSeed, "seed", 0, "Random seed (0 uses the current time)")
+	validateCmd.Flags().IntVarP(&validateK, "neighbors", "n", 10, "Number of nearest neighbors per KNN query")
+	validateCmd.Flags().IntVar(&validateShow, "show", 5, "Number of mismatches to print per query type")
+	validateCmd.Flags().Float64Var(&validateMaxBox, "max-box", 10, "Maximum box size in degrees")
+	validateCmd.Flags().Float64Var(&validateMaxRange, "max-radius", 500, "Maximum radius in km")
+
+	rootCmd.AddCommand(validateCmd)
+}
+
+// mismatch describes one query whose index result differs from the scan
+type mismatch struct {
+	query   string
+	missing []string
+	extra   []string
+}
+
+func runValidate(cmd *cobra.Command, args []string) {
+	points, err := rtree.ReadPoints(indexFile)
+	if err != nil {
+		log.Fatalf("Failed to read index: %v", err)
+	}
+	index, err := backend.New(indexBackend)
+	if err != nil {
+		log.Fatalf("Failed to create index: %v", err)
+	}
+	if err := index.IndexPoints(points); err != nil {
+		log.Fatalf("Failed to build index: %v", err)
+	}
+	// Boxes crossing the antimeridian are only split by a wrapping index
+	wrapped, err := backend.New(indexBackend, rtree.WithLongitudeWrap())
+	if err != nil {
+		log.Fatalf("Failed to create index: %v", err)
+	}
+	if err := wrapped.IndexPoints(points); err != nil {
+		log.Fatalf("Failed to build index: %v", err)
+	}
+	fmt.Printf("Validating %d points from %s (%s backend)\n", len(points), indexFile, indexBackend)
+
+	seed := validateSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	fmt.Printf("Seed: %d\n\n", seed)
+	r := rand.New(rand.NewSource(seed))
+
+	checks := []struct {
+		name string
+		run  func() *mismatch
+	}{
+		{"box", func() *mismatch { return validateBox(index, wrapped, points, r) }},
+		{"radius", func() *mismatch { return validateRadius(index, points, r) }},
+		{"nearest", func() *mismatch { return validateNearest(index, points, r) }},
+	}
+
+	failed := false
+	for _, check := range checks {
+		var mismatches []*mismatch
+		start := time.Now()
+		for i := 0; i < validateQueries; i++ {
+			if m := check.run(); m != nil {
+				mismatches = append(mismatches, m)
+			}
+		}
+
+		status := "OK"
+		if len(mismatches) > 0 {
+			status = "FAIL"
+			failed = true
+		}
+		fmt.Printf("%-8s %4s  %d/%d queries mismatched (%v)\n",
+			check.name, status, len(mismatches), validateQueries, time.Since(start).Round(time.Millisecond))
+
+		for i, m := range mismatches {
+			if i >= validateShow {
+				fmt.Printf("  ... %d more\n", len(mismatches)-validateShow)
+				break
+			}
+			if len(m.missing) == 0 && len(m.extra) == 0 {
+				fmt.Printf("  %s\n", m.query)
+				continue
+			}
+			fmt.Printf("  %s: %d missing %s, %d extra %s\n",
+				m.query, len(m.missing), sampleIDs(m.missing), len(m.extra), sampleIDs(m.extra))
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// validateBox queries index, or wrapped for boxes reaching past longitude 180
+func validateBox(index, wrapped *rtree.GeoIndex, points []*models.Point, r *rand.Rand) *mismatch {
+	latSize := r.Float64() * validateMaxBox
+	lonSize := r.Float64() * validateMaxBox
+	lat := -90 + r.Float64()*(180-latSize)
+	lon := -180 + r.Float64()*360
+	box := models.BoundingBox{
+		BottomLeft: models.Location{Lat: lat, Lon: lon},
+		TopRight:   models.Location{Lat: lat + latSize, Lon: lon + lonSize},
+	}
+	crosses := box.TopRight.Lon > 180
+	if crosses {
+		index = wrapped
+	}
+
+	got, err := index.QueryBox(box)
+	if err != nil {
+		return &mismatch{query: fmt.Sprintf("box %s: %v", formatBounds(&box), err)}
+	}
+
+	var want []*models.Point
+	for _, p := range points {
+		if p.Location == nil {
+			continue
+		}
+		loc := *p.Location
+		if crosses {
+			loc.Lon = models.NormalizeLongitude(loc.Lon)
+			if loc.Lon < box.BottomLeft.Lon {
+				loc.Lon += 360
+			}
+		}
+		if box.Contains(loc) {
+			want = append(want, p)
+		}
+	}
+	return diffResults(fmt.Sprintf("box %s", formatBounds(&box)), want, got)
+}
+
+func validateRadius(index *rtree.GeoIndex, points []*models.Point, r *rand.Rand) *mismatch {
+	center := randomLocation(r)
+	radius := 1 + r.Float64()*(validateMaxRange-1)
+
+	got, err := index.QueryRadius(center, radius)
+	if err != nil {
+		return &mismatch{query: fmt.Sprintf("radius (%.4f, %.4f) %.1fkm: %v", center.Lat, center.Lon, radius, err)}
+	}
+
+	var want []*models.Point
+	for _, p := range points {
+		if p.Location != nil && rtree.Distance(center.Lat, center.Lon, p.Location.Lat, p.Location.Lon) <= radius {
+			want = append(want, p)
+		}
+	}
+	return diffResults(fmt.Sprintf("radius (%.4f, %.4f) %.1fkm", center.Lat, center.Lon, radius), want, got)
+}
+
+// validateNearest compares neighbor distances rather than IDs, since points at
+// the same distance may legitimately be returned in either order
+func validateNearest(index *rtree.GeoIndex, points []*models.Point, r *rand.Rand) *mismatch {
+	center := randomLocation(r)
+	query := fmt.Sprintf("nearest (%.4f, %.4f) k=%d", center.Lat, center.Lon, validateK)
+	got := index.NearestNeighbors(center, validateK)
+
+	dist := func(p *models.Point) float64 {
+		return rtree.Distance(center.Lat, center.Lon, p.Location.Lat, p.Location.Lon)
+	}
+
+	all := make([]float64, 0, len(points))
+	for _, p := range points {
+		if p.Location != nil {
+			all = append(all, dist(p))
+		}
+	}
+	sort.Float64s(all)
+	if len(all) > validateK {
+		all = all[:validateK]
+	}
+
+	if len(got) != len(all) {
+		return &mismatch{query: fmt.Sprintf("%s: got %d neighbors, want %d", query, len(got), len(all))}
+	}
+	gotDist := make([]float64, len(got))
+	for i, p := range got {
+		gotDist[i] = dist(p)
+	}
+	sort.Float64s(gotDist)
+	for i := range gotDist {
+		if math.Abs(gotDist[i]-all[i]) > 1e-9 {
+			return &mismatch{query: fmt.Sprintf("%s: neighbor %d at %.3fkm, want %.3fkm", query, i+1, gotDist[i], all[i])}
+		}
+	}
+	return nil
+}
+
+func randomLocation(r *rand.Rand) models.Location {
+	return models.Location{
+		Lat: -90 + r.Float64()*180,
+		Lon: -180 + r.Float64()*360,
+	}
+}
+
+// diffResults compares two result sets by ID, counting duplicates
+func diffResults(query string, want, got []*models.Point) *mismatch {
+	counts := make(map[string]int, len(want))
+	for _, p := range want {
+		counts[p.ID]++
+	}
+	for _, p := range got {
+		counts[p.ID]--
+	}
+
+	m := &mismatch{query: query}
+	for id, c := range counts {
+		for ; c > 0; c-- {
+			m.missing = append(m.missing, id)
+		}
+		for ; c < 0; c++ {
+			m.extra = append(m.extra, id)
+		}
+	}
+	if len(m.missing) == 0 && len(m.extra) == 0 {
+		return nil
+	}
+	sort.Strings(m.missing)
+	sort.Strings(m.extra)
+	return m
+}
+
+// sampleIDs formats the first few IDs of a list
+func sampleIDs(ids []string) string {
+	if len(ids) == 0 {
+		return ""
+	}
+	if len(ids) > 3 {
+		return fmt.Sprintf("%v...", ids[:3])
+	}
+	return fmt.Sprint(ids)
+}
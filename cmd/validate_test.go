@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/1F47E/geo-index-rtree/pkg/backend"
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/1F47E/geo-index-rtree/pkg/rtree"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateChecksPass(t *testing.T) {
+	r := rand.New(rand.NewSource(11))
+	points := make([]*models.Point, 5000)
+	for i := range points {
+		points[i] = &models.Point{
+			ID:       fmt.Sprintf("p%d", i),
+			Location: &models.Location{Lat: -90 + r.Float64()*180, Lon: -180 + r.Float64()*360},
+		}
+	}
+	validateK, validateMaxBox = 10, 10
+
+	for _, name := range []string{backend.RTree, backend.Compact, backend.KDTree} {
+		t.Run(name, func(t *testing.T) {
+			index, err := backend.New(name)
+			require.NoError(t, err)
+			require.NoError(t, index.IndexPoints(points))
+			wrapped, err := backend.New(name, rtree.WithLongitudeWrap())
+			require.NoError(t, err)
+			require.NoError(t, wrapped.IndexPoints(points))
+
+			for i := 0; i < 300; i++ {
+				assert.Nil(t, validateBox(index, wrapped, points, r))
+				assert.Nil(t, validateRadius(index, points, r))
+				assert.Nil(t, validateNearest(index, points, r))
+			}
+		})
+	}
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/1F47E/geo-index-rtree/pkg/backend"
+	"github.com/1F47E/geo-index-rtree/pkg/client"
+	"github.com/1F47E/geo-index-rtree/pkg/formats"
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/1F47E/geo-index-rtree/pkg/rtree"
+	"github.com/spf13/cobra"
+)
+
+var watchCmd = &cobra.Command{
+	Use:   "watch <file|directory|->",
+	Short: "Continuously ingest NDJSON points into an index",
+	Long: `Tail an NDJSON stream and keep an index up to date as points arrive.
+
+The source can be a file (followed like "tail -f", surviving truncation and
+rotation), a directory (every *.ndjson / *.jsonl file in it, including files
+created later) or "-" for stdin. Each line is a point in the same layouts the
+import command accepts.
+
+By default points go into the local index at --file, which is saved every
+--snapshot-interval and on exit. With --server they are sent to a running
+"serve" instance instead.`,
+	Args: cobra.ExactArgs(1),
+	Run:  runWatch,
+}
+
+var (
+	watchServer           string
+	watchAuthToken        string
+	watchBatchSize        int
+	watchFlushInterval    time.Duration
+	watchPollInterval     time.Duration
+	watchSnapshotInterval time.Duration
+	watchFromEnd          bool
+)
+
+func init() {
+	watchCmd.Flags().StringVar(&watchServer, "server", "", "Send points to this server (e.g. http://localhost:8080) instead of a local index")
+	watchCmd.Flags().StringVar(&watchAuthToken, "auth-token", "", "Bearer token for --server")
+	watchCmd.Flags().IntVarP(&watchBatchSize, "batch-size", "b", 1000, "Maximum points per insert")
+	watchCmd.Flags().DurationVar(&watchFlushInterval, "flush-interval", time.Second, "Insert a partial batch after this long")
+	watchCmd.Flags().DurationVar(&watchPollInterval, "poll", 500*time.Millisecond, "How often to check files for new data")
+	watchCmd.Flags().DurationVar(&watchSnapshotInterval, "snapshot-interval", 30*time.Second, "Save the local index this often when modified (0 saves only on exit)")
+	watchCmd.Flags().BoolVar(&watchFromEnd, "from-end", false, "Skip data already in a watched file and only ingest new lines")
+
+	rootCmd.AddCommand(watchCmd)
+}
+
+// pointSink receives batches of ingested points
+type pointSink interface {
+	insert(ctx context.Context, points []*models.Point) error
+	// tick is called periodically, e.g. to snapshot
+	tick() error
+	close() error
+}
+
+// localSink indexes into an in-process index persisted to a file
+type localSink struct {
+	index    *rtree.GeoIndex
+	path     string
+	interval time.Duration
+	dirty    bool
+	lastSave time.Time
+}
+
+func (s *localSink) insert(ctx context.Context, points []*models.Point) error {
+	s.dirty = true
+	return s.index.IndexPoints(points)
+}
+
+func (s *localSink) tick() error {
+	if !s.dirty || s.interval <= 0 || time.Since(s.lastSave) < s.interval {
+		return nil
+	}
+	return s.save()
+}
+
+func (s *localSink) close() error {
+	if !s.dirty {
+		return nil
+	}
+	return s.save()
+}
+
+// save writes a snapshot atomically (write + rename)
+func (s *localSink) save() error {
+	tmp := s.path + ".tmp"
+	if err := s.index.SaveToFile(tmp); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to replace snapshot: %w", err)
+	}
+	s.dirty = false
+	s.lastSave = time.Now()
+	fmt.Printf("Saved %d points to %s\n", s.index.Count(), s.path)
+	return nil
+}
+
+// remoteSink posts batches to a running server
+type remoteSink struct {
+	client *client.Client
+}
+
+func (s *remoteSink) insert(ctx context.Context, points []*models.Point) error {
+	_, err := s.client.InsertPoints(ctx, points)
+	return err
+}
+
+func (s *remoteSink) tick() error  { return nil }
+func (s *remoteSink) close() error { return nil }
+
+func runWatch(cmd *cobra.Command, args []string) {
+	source := args[0]
+	if watchBatchSize < 1 {
+		log.Fatalf("--batch-size must be at least 1, got %d", watchBatchSize)
+	}
+	if watchFlushInterval <= 0 {
+		log.Fatalf("--flush-interval must be positive, got %v", watchFlushInterval)
+	}
+	if watchPollInterval <= 0 {
+		log.Fatalf("--poll must be positive, got %v", watchPollInterval)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var sink pointSink
+	if watchServer != "" {
+		sink = &remoteSink{client: client.New(watchServer, client.WithAuthToken(watchAuthToken))}
+		fmt.Printf("Sending points to %s\n", watchServer)
+	} else {
+		index, err := backend.New(indexBackend)
+		if err != nil {
+			log.Fatalf("Failed to create index: %v", err)
+		}
+		if _, err := os.Stat(indexFile); err == nil {
+			if index, err = loadIndex(indexFile); err != nil {
+				log.Fatalf("Failed to load index: %v", err)
+			}
+		}
+		sink = &localSink{index: index, path: indexFile, interval: watchSnapshotInterval, lastSave: time.Now()}
+		fmt.Printf("Indexing points into %s\n", indexFile)
+	}
+
+	lines := make(chan string, watchBatchSize)
+	go func() {
+		defer close(lines)
+		if err := watchSource(ctx, source, lines); err != nil {
+			log.Printf("Watch error: %v", err)
+		}
+	}()
+
+	total, err := ingest(ctx, lines, sink)
+	if closeErr := sink.close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Fatalf("Ingestion failed after %d points: %v", total, err)
+	}
+	fmt.Printf("Ingested %d points\n", total)
+}
+
+// ingest batches parsed lines into the sink until lines is closed or ctx is
+// done
+func ingest(ctx context.Context, lines <-chan string, sink pointSink) (int, error) {
+	ticker := time.NewTicker(watchFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*models.Point, 0, watchBatchSize)
+	total, skipped := 0, 0
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		// Use a fresh context so the final flush still goes through after Ctrl-C
+		if err := sink.insert(context.Background(), batch); err != nil {
+			return err
+		}
+		total += len(batch)
+		if verbose {
+			fmt.Printf("Indexed %d points (%d total, %d skipped)\n", len(batch), total, skipped)
+		}
+		batch = make([]*models.Point, 0, watchBatchSize)
+		return nil
+	}
+
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return total, flush()
+			}
+			point, err := formats.ParseNDJSONLine([]byte(line))
+			if err != nil || point.ID == "" {
+				skipped++
+				if verbose {
+					fmt.Printf("Skipping line: %q\n", line)
+				}
+				continue
+			}
+			batch = append(batch, point)
+			if len(batch) >= watchBatchSize {
+				if err := flush(); err != nil {
+					return total, err
+				}
+			}
+		case <-ticker.C:
+			if err := flush(); err != nil {
+				return total, err
+			}
+			if err := sink.tick(); err != nil {
+				return total, err
+			}
+		case <-ctx.Done():
+			// Take what the source already produced without waiting for it to
+			// stop, as a read from stdin may block until the next line
+			for {
+				select {
+				case line, ok := <-lines:
+					if !ok {
+						return total, flush()
+					}
+					if point, err := formats.ParseNDJSONLine([]byte(line)); err == nil && point.ID != "" {
+						batch = append(batch, point)
+					}
+				default:
+					return total, flush()
+				}
+			}
+		}
+	}
+}
+
+// watchSource streams lines from stdin, a followed file or a directory
+func watchSource(ctx context.Context, source string, lines chan<- string) error {
+	if source == "-" {
+		return readLines(ctx, os.Stdin, lines)
+	}
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return watchDir(ctx, source, lines)
+	}
+	return tailFile(ctx, source, watchFromEnd, lines)
+}
+
+func readLines(ctx context.Context, r io.Reader, lines chan<- string) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		select {
+		case lines <- line:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+// watchDir follows every NDJSON file in dir, picking up new files as they appear
+func watchDir(ctx context.Context, dir string, lines chan<- string) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	seen := make(map[string]bool)
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+
+	// Files present at startup honour --from-end; files created later are read in full
+	initial := true
+	for {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			ext := strings.ToLower(filepath.Ext(name))
+			if entry.IsDir() || seen[name] || (ext != ".ndjson" && ext != ".jsonl") {
+				continue
+			}
+			seen[name] = true
+
+			wg.Add(1)
+			go func(path string, fromEnd bool) {
+				defer wg.Done()
+				if err := tailFile(ctx, path, fromEnd, lines); err != nil {
+					log.Printf("Failed to follow %s: %v", path, err)
+				}
+			}(filepath.Join(dir, name), watchFromEnd && initial)
+		}
+		initial = false
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// tailFile reads path line by line and keeps following it as it grows. A file
+// that shrinks (truncated) or is replaced (rotated) is re-read from the start.
+func tailFile(ctx context.Context, path string, fromEnd bool, lines chan<- string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { file.Close() }()
+
+	var offset int64
+	if fromEnd {
+		if offset, err = file.Seek(0, io.SeekEnd); err != nil {
+			return err
+		}
+	}
+	reader := bufio.NewReader(file)
+	var partial strings.Builder
+
+	for {
+		chunk, err := reader.ReadString('\n')
+		offset += int64(len(chunk))
+		partial.WriteString(chunk)
+
+		if err == nil {
+			line := strings.TrimSpace(partial.String())
+			partial.Reset()
+			if line == "" {
+				continue
+			}
+			select {
+			case lines <- line:
+			case <-ctx.Done():
+				return nil
+			}
+			continue
+		}
+		if !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		// At EOF: wait for more data, then check for truncation or rotation
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(watchPollInterval):
+		}
+
+		pathInfo, statErr := os.Stat(path)
+		fileInfo, fileErr := file.Stat()
+		if statErr != nil || fileErr != nil {
+			continue
+		}
+		if !os.SameFile(pathInfo, fileInfo) || pathInfo.Size() < offset {
+			file.Close()
+			if file, err = os.Open(path); err != nil {
+				return err
+			}
+			offset = 0
+			partial.Reset()
+			reader = bufio.NewReader(file)
+			continue
+		}
+		reader.Reset(file)
+	}
+}
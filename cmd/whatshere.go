@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/1F47E/geo-index-rtree/pkg/rtree"
+	"github.com/spf13/cobra"
+)
+
+var whatsHereCmd = &cobra.Command{
+	Use:     "whatshere <lat> <lon>",
+	Aliases: []string{"reverse"},
+	Short:   "Show the indexed point nearest to a coordinate",
+	Long: `Reverse lookup: print the point nearest to a coordinate with its distance,
+direction and properties. Against an index of named places this works as
+reverse geocoding, e.g. the city or address closest to a GPS fix.
+
+Negative coordinates can be passed as-is.`,
+	Example: `  go-geo-index whatshere 37.7936 -122.3958 -f cities.gob
+  go-geo-index reverse 51.5007 -0.1246 --unit mi`,
+	// See parseNumericArgs
+	DisableFlagParsing: true,
+	Run:                runWhatsHere,
+}
+
+var whatsHereUnit string
+
+func init() {
+	whatsHereCmd.Flags().StringVarP(&whatsHereUnit, "unit", "u", "km", "Distance unit: m, km, mi, nmi")
+
+	rootCmd.AddCommand(whatsHereCmd)
+}
+
+func runWhatsHere(cmd *cobra.Command, args []string) {
+	coordArgs, ok := parseNumericArgs(cmd, args)
+	if !ok {
+		return
+	}
+
+	unit, err := models.ParseUnit(whatsHereUnit)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	v, err := parseFloats(coordArgs, 2)
+	if err != nil {
+		log.Fatalf("Usage: whatshere <lat> <lon>: %v", err)
+	}
+	loc := models.Location{Lat: v[0], Lon: v[1]}
+	if loc.Lat < -90 || loc.Lat > 90 {
+		log.Fatalf("Latitude %g out of range [-90, 90]", loc.Lat)
+	}
+	if loc.Lon < -180 || loc.Lon > 180 {
+		log.Fatalf("Longitude %g out of range [-180, 180]", loc.Lon)
+	}
+
+	index, err := loadIndex(indexFile)
+	if err != nil {
+		log.Fatalf("Failed to load index: %v", err)
+	}
+	result, err := index.Nearest(loc)
+	switch {
+	case errors.Is(err, rtree.ErrEmptyIndex):
+		log.Fatalf("Index %s has no points", indexFile)
+	case err != nil:
+		log.Fatalf("Lookup failed: %v", err)
+	}
+	fmt.Println()
+	printWhatsHere(loc, result, unit)
+}
+
+// printWhatsHere prints the point nearest to loc, its distance in unit, the
+// direction to it and its properties
+func printWhatsHere(loc models.Location, result models.PointDistance, unit models.Unit) {
+	p := result.Point
+	fmt.Printf("Nearest:   %s\n", p.ID)
+	fmt.Printf("Location:  %.6f, %.6f\n", p.Location.Lat, p.Location.Lon)
+
+	distance := unit.FromKm(result.DistanceKm)
+	if result.DistanceKm == 0 {
+		fmt.Println("Distance:  here")
+	} else {
+		bearing := loc.BearingTo(*p.Location)
+		fmt.Printf("Distance:  %s %s %s (bearing %.0f°)\n",
+			formatDistance(distance), unit, compassPoint(bearing), bearing)
+	}
+
+	if len(p.Properties) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(p.Properties))
+	width := 0
+	for k := range p.Properties {
+		keys = append(keys, k)
+		width = max(width, len(k))
+	}
+	sort.Strings(keys)
+	fmt.Println("Properties:")
+	for _, k := range keys {
+		fmt.Printf("  %-*s  %s\n", width, k, formatProperty(p.Properties[k]))
+	}
+}
+
+// formatDistance prints short distances with more decimals
+func formatDistance(d float64) string {
+	if d < 10 {
+		return fmt.Sprintf("%.2f", d)
+	}
+	return fmt.Sprintf("%.1f", d)
+}
+
+// compassPoints are the eight directions starting at north, clockwise
+var compassPoints = []string{"N", "NE", "E", "SE", "S", "SW", "W", "NW"}
+
+// compassPoint names the eight-point compass direction of a bearing in degrees
+func compassPoint(bearing float64) string {
+	i := int(math.Round(bearing/45)) % len(compassPoints)
+	return compassPoints[i]
+}
+
+// formatProperty prints strings and numbers as they are and anything else,
+// such as lists or nested objects, as JSON
+func formatProperty(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
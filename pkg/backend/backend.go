@@ -0,0 +1,157 @@
+// Package backend defines SpatialIndex, the point index API shared by the
+// in-memory R-tree, its alternative storage layouts, PostGIS, SQLite and
+// Redis, and opens a backend chosen by name so applications and the CLI can
+// switch between them through configuration (index.backend,
+// GEOINDEX_INDEX_BACKEND or --backend).
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/1F47E/geo-index-rtree/pkg/config"
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/1F47E/geo-index-rtree/pkg/rtree"
+)
+
+// SpatialIndex is a point index. *rtree.GeoIndex implements it for every
+// in-memory backend, *rtree.TieredIndex for datasets spilling to disk,
+// *rtree.BoundedIndex for capped caches, *rtree.MovingIndex for tracked
+// fleets, and PostGIS, SQLite and Redis for the databases.
+type SpatialIndex interface {
+	// IndexPoints adds points to the index
+	IndexPoints(points []*models.Point) error
+	// QueryBox returns the points inside box
+	QueryBox(box models.BoundingBox) ([]*models.Point, error)
+	// QueryRadius returns the points within radius of center, in the
+	// index's distance unit (kilometers unless configured otherwise)
+	QueryRadius(center models.Location, radius float64) ([]*models.Point, error)
+	// NearestNeighbors returns up to n points nearest to center, nearest first
+	NearestNeighbors(center models.Location, n int) []*models.Point
+	// Count returns the number of indexed points
+	Count() int64
+	// SaveToFile writes the points to an index file
+	SaveToFile(path string) error
+	// LoadFromFile adds the points of an index file
+	LoadFromFile(path string) error
+}
+
+// ContextIndex is a database backend whose queries take a context and return
+// every error, including those NearestNeighbors can only record for Err.
+// PostGISIndex, SQLiteIndex and RedisIndex implement it.
+type ContextIndex interface {
+	SpatialIndex
+	QueryBoxContext(ctx context.Context, box models.BoundingBox) ([]*models.Point, error)
+	QueryRadiusContext(ctx context.Context, center models.Location, radius float64) ([]*models.Point, error)
+	NearestNeighborsContext(ctx context.Context, center models.Location, n int) ([]*models.Point, error)
+}
+
+// Backend names
+const (
+	RTree     = "rtree"
+	Compact   = "compact"
+	Geohash   = "geohash"
+	S2        = "s2"
+	Quadtree  = "quadtree"
+	KDTree    = "kdtree"
+	Grid      = "grid"
+	SpaceTime = "spacetime"
+	PostGIS   = "postgis"
+	SQLite    = "sqlite"
+	Redis     = "redis"
+)
+
+// Names lists every backend, the default first
+var Names = []string{RTree, Compact, Geohash, S2, Quadtree, KDTree, Grid, SpaceTime, PostGIS, SQLite, Redis}
+
+var (
+	_ SpatialIndex = (*rtree.GeoIndex)(nil)
+	_ SpatialIndex = (*rtree.TieredIndex)(nil)
+	_ SpatialIndex = (*rtree.BoundedIndex)(nil)
+	_ SpatialIndex = (*rtree.MovingIndex)(nil)
+)
+
+// StorageOption returns the rtree option selecting an in-memory backend. The
+// rtree backend needs no option, so its option changes nothing.
+func StorageOption(name string) (rtree.Option, error) {
+	switch strings.ToLower(name) {
+	case RTree, "":
+		return func(*rtree.GeoIndex) {}, nil
+	case Compact:
+		return rtree.WithCompactStorage(), nil
+	case Geohash:
+		return rtree.WithGeohashStorage(0), nil
+	case S2:
+		return rtree.WithS2Storage(), nil
+	case Quadtree:
+		return rtree.WithQuadtreeStorage(), nil
+	case KDTree:
+		return rtree.WithKDTreeStorage(), nil
+	case Grid:
+		return rtree.WithGridStorage(0), nil
+	case SpaceTime:
+		return rtree.WithSpaceTimeStorage(""), nil
+	case PostGIS, SQLite, Redis:
+		return nil, fmt.Errorf("backend %q is a database, not an in-memory index", name)
+	}
+	return nil, unknown(name)
+}
+
+// IsDatabase reports whether the named backend keeps its points in a
+// database rather than in memory
+func IsDatabase(name string) bool {
+	return strings.EqualFold(name, PostGIS) || strings.EqualFold(name, SQLite) || strings.EqualFold(name, Redis)
+}
+
+func unknown(name string) error {
+	return fmt.Errorf("unknown backend %q (use %s)", name, strings.Join(Names, ", "))
+}
+
+// New returns an empty in-memory index of the named backend, built with opts
+// as well
+func New(name string, opts ...rtree.Option) (*rtree.GeoIndex, error) {
+	storage, err := StorageOption(name)
+	if err != nil {
+		return nil, err
+	}
+	return rtree.NewGeoIndex(append(opts, storage)...), nil
+}
+
+// Open returns the backend named by c.Index.Backend, the R-tree when empty.
+// PostGIS connects with c.PostGIS and SQLite opens c.SQLite, both creating
+// their tables if missing, and Redis connects with c.Redis; other backends
+// start empty. Release the index with Close.
+func Open(c *config.Config, opts ...rtree.Option) (SpatialIndex, error) {
+	switch {
+	case strings.EqualFold(c.Index.Backend, PostGIS):
+		return openPostGIS(c.PostGIS)
+	case strings.EqualFold(c.Index.Backend, SQLite):
+		return openSQLite(c.SQLite)
+	case strings.EqualFold(c.Index.Backend, Redis):
+		return openRedis(c.Redis)
+	}
+	index, err := New(c.Index.Backend, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// Close releases what idx holds, such as a database connection
+func Close(idx SpatialIndex) error {
+	if closer, ok := idx.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Err returns the last error a database backend could not return from
+// NearestNeighbors or Count, nil for in-memory backends
+func Err(idx SpatialIndex) error {
+	if db, ok := idx.(interface{ Err() error }); ok {
+		return db.Err()
+	}
+	return nil
+}
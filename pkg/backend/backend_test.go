@@ -0,0 +1,120 @@
+package backend
+
+import (
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"slices"
+	"sort"
+	"testing"
+
+	"github.com/1F47E/geo-index-rtree/pkg/config"
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPoints(n int) []*models.Point {
+	rng := rand.New(rand.NewSource(7))
+	points := make([]*models.Point, n)
+	for i := range points {
+		points[i] = &models.Point{
+			ID:       fmt.Sprintf("point_%d", i),
+			Location: &models.Location{Lat: rng.Float64()*120 - 60, Lon: rng.Float64()*360 - 180},
+		}
+	}
+	return points
+}
+
+func ids(points []*models.Point) []string {
+	out := make([]string, len(points))
+	for i, p := range points {
+		out[i] = p.ID
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestBackends(t *testing.T) {
+	points := testPoints(3000)
+	box := models.BoundingBox{
+		BottomLeft: models.Location{Lat: 10, Lon: -30},
+		TopRight:   models.Location{Lat: 40, Lon: 20},
+	}
+	center := models.Location{Lat: 48.85, Lon: 2.35}
+
+	reference, err := New(RTree)
+	require.NoError(t, err)
+	require.NoError(t, reference.IndexPoints(points))
+	wantBox, err := reference.QueryBox(box)
+	require.NoError(t, err)
+	wantRadius, err := reference.QueryRadius(center, 1500)
+	require.NoError(t, err)
+	wantNearest := reference.NearestNeighbors(center, 10)
+
+	for _, name := range Names {
+		// The servers are not available here; pkg/redisgeo tests Redis
+		// against a fake one
+		if name == PostGIS || name == Redis {
+			continue
+		}
+		t.Run(name, func(t *testing.T) {
+			// An empty SQLite path opens an in-memory database
+			c := &config.Config{Index: config.IndexConfig{Backend: name}, SQLite: config.SQLiteConfig{Driver: "sqlite"}}
+			if name == SQLite && !slices.Contains(sql.Drivers(), c.SQLite.Driver) {
+				t.Skip("no SQLite driver linked in, run with -tags sqlite")
+			}
+			index, err := Open(c)
+			require.NoError(t, err)
+			defer func() { assert.NoError(t, Close(index)) }()
+
+			require.NoError(t, index.IndexPoints(points))
+			assert.Equal(t, int64(len(points)), index.Count())
+
+			check := func(index SpatialIndex) {
+				got, err := index.QueryBox(box)
+				require.NoError(t, err)
+				assert.Equal(t, ids(wantBox), ids(got))
+				got, err = index.QueryRadius(center, 1500)
+				require.NoError(t, err)
+				assert.Equal(t, ids(wantRadius), ids(got))
+				assert.Equal(t, ids(wantNearest), ids(index.NearestNeighbors(center, 10)))
+			}
+			check(index)
+
+			path := filepath.Join(t.TempDir(), "index.gob")
+			require.NoError(t, index.SaveToFile(path))
+			loaded, err := Open(c)
+			require.NoError(t, err)
+			defer func() { assert.NoError(t, Close(loaded)) }()
+			require.NoError(t, loaded.LoadFromFile(path))
+			assert.Equal(t, int64(len(points)), loaded.Count())
+			check(loaded)
+		})
+	}
+}
+
+func TestBackendNames(t *testing.T) {
+	for _, name := range []string{"", "RTree", "KDTree"} {
+		_, err := New(name)
+		assert.NoError(t, err, name)
+	}
+
+	_, err := New("btree")
+	assert.ErrorContains(t, err, `unknown backend "btree"`)
+	_, err = Open(&config.Config{Index: config.IndexConfig{Backend: "btree"}})
+	assert.ErrorContains(t, err, "quadtree")
+
+	_, err = StorageOption(PostGIS)
+	assert.ErrorContains(t, err, "database")
+	assert.True(t, IsDatabase("SQLite"))
+	assert.True(t, IsDatabase(Redis))
+	assert.False(t, IsDatabase(KDTree))
+
+	_, err = Open(&config.Config{
+		Index:  config.IndexConfig{Backend: SQLite},
+		SQLite: config.SQLiteConfig{Driver: "no-such-driver"},
+	})
+	assert.ErrorContains(t, err, "-tags sqlite")
+}
@@ -0,0 +1,147 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/1F47E/geo-index-rtree/pkg/config"
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/1F47E/geo-index-rtree/pkg/postgis"
+	"github.com/1F47E/geo-index-rtree/pkg/rtree"
+)
+
+// postgisLoadBatch is the number of rows read at a time when saving the
+// table to a file
+const postgisLoadBatch = 10000
+
+// PostGISIndex adapts a PostGIS connection to SpatialIndex. Points are
+// upserted by ID, so indexing a point again moves it rather than adding a
+// copy. Index files are converted through an in-memory R-tree: SaveToFile
+// writes the whole table, LoadFromFile upserts every point of the file.
+//
+// NearestNeighbors and Count cannot return errors through SpatialIndex; when
+// they fail they return nothing and Err reports why.
+type PostGISIndex struct {
+	DB *postgis.PostGISIndex
+
+	mu  sync.Mutex
+	err error
+}
+
+var _ ContextIndex = (*PostGISIndex)(nil)
+
+// openPostGIS connects with c and creates the points table if it is missing
+func openPostGIS(c config.PostGISConfig) (SpatialIndex, error) {
+	db, err := postgis.NewPostGISIndex(c.Host, c.User, c.Password, c.Database, c.Port,
+		postgis.WithInitMode(postgis.InitIfNotExists))
+	if err != nil {
+		return nil, err
+	}
+	if err := db.InitSchema(context.Background()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &PostGISIndex{DB: db}, nil
+}
+
+// setErr records err for Err if it is not nil
+func (p *PostGISIndex) setErr(err error) {
+	if err != nil {
+		p.mu.Lock()
+		p.err = err
+		p.mu.Unlock()
+	}
+}
+
+// Err returns the last error of a NearestNeighbors or Count call
+func (p *PostGISIndex) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// IndexPoints upserts the points that have a location
+func (p *PostGISIndex) IndexPoints(points []*models.Point) error {
+	located := make([]*models.Point, 0, len(points))
+	for _, point := range points {
+		if point.Location != nil {
+			located = append(located, point)
+		}
+	}
+	if len(located) == 0 {
+		return nil
+	}
+	return p.DB.UpsertPoints(context.Background(), located)
+}
+
+// QueryBox returns the points inside box
+func (p *PostGISIndex) QueryBox(box models.BoundingBox) ([]*models.Point, error) {
+	return p.DB.QueryBox(context.Background(), box)
+}
+
+// QueryBoxContext is QueryBox with a context
+func (p *PostGISIndex) QueryBoxContext(ctx context.Context, box models.BoundingBox) ([]*models.Point, error) {
+	return p.DB.QueryBox(ctx, box)
+}
+
+// QueryRadius returns the points within radius kilometers of center
+func (p *PostGISIndex) QueryRadius(center models.Location, radius float64) ([]*models.Point, error) {
+	return p.DB.QueryRadius(context.Background(), center, radius)
+}
+
+// QueryRadiusContext is QueryRadius with a context
+func (p *PostGISIndex) QueryRadiusContext(ctx context.Context, center models.Location, radius float64) ([]*models.Point, error) {
+	return p.DB.QueryRadius(ctx, center, radius)
+}
+
+// NearestNeighbors returns up to n points nearest to center, or nil and sets
+// Err on failure
+func (p *PostGISIndex) NearestNeighbors(center models.Location, n int) []*models.Point {
+	points, err := p.DB.NearestNeighbors(context.Background(), center, n)
+	p.setErr(err)
+	return points
+}
+
+// NearestNeighborsContext returns up to n points nearest to center, nearest
+// first
+func (p *PostGISIndex) NearestNeighborsContext(ctx context.Context, center models.Location, n int) ([]*models.Point, error) {
+	return p.DB.NearestNeighbors(ctx, center, n)
+}
+
+// Count returns the number of rows, or zero and sets Err on failure
+func (p *PostGISIndex) Count() int64 {
+	count, err := p.DB.Count(context.Background())
+	p.setErr(err)
+	return count
+}
+
+// SaveToFile writes every point of the table to an index file
+func (p *PostGISIndex) SaveToFile(path string) error {
+	index := rtree.NewGeoIndex()
+	if _, err := p.DB.LoadIntoIndex(context.Background(), index, postgis.SourceTable{}, postgisLoadBatch); err != nil {
+		return fmt.Errorf("failed to read table: %w", err)
+	}
+	return index.SaveToFile(path)
+}
+
+// LoadFromFile upserts every point of an index file
+func (p *PostGISIndex) LoadFromFile(path string) error {
+	index := rtree.NewGeoIndex()
+	if err := index.LoadFromFile(path); err != nil {
+		return err
+	}
+	points, err := index.QueryBox(models.BoundingBox{
+		BottomLeft: models.Location{Lat: -90, Lon: -180},
+		TopRight:   models.Location{Lat: 90, Lon: 180},
+	})
+	if err != nil {
+		return err
+	}
+	return p.IndexPoints(points)
+}
+
+// Close closes the database connection
+func (p *PostGISIndex) Close() error {
+	return p.DB.Close()
+}
@@ -0,0 +1,135 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/1F47E/geo-index-rtree/pkg/config"
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/1F47E/geo-index-rtree/pkg/redisgeo"
+	"github.com/1F47E/geo-index-rtree/pkg/rtree"
+)
+
+// RedisIndex adapts a Redis GEO key to SpatialIndex. Points are added by ID,
+// so indexing a point again moves it. Redis cannot store points beyond
+// ±redisgeo.MaxLatitude; IndexPoints skips them, so Count can be lower than
+// the number of points indexed. Index files are converted through an
+// in-memory R-tree like PostGISIndex.
+//
+// NearestNeighbors and Count cannot return errors through SpatialIndex; when
+// they fail they return nothing and Err reports why.
+type RedisIndex struct {
+	DB *redisgeo.Index
+
+	mu  sync.Mutex
+	err error
+}
+
+var _ ContextIndex = (*RedisIndex)(nil)
+
+// openRedis connects to the server of c
+func openRedis(c config.RedisConfig) (SpatialIndex, error) {
+	db, err := redisgeo.New(c.Addr,
+		redisgeo.WithKey(c.Key),
+		redisgeo.WithPassword(c.Password),
+		redisgeo.WithDB(c.DB),
+		redisgeo.WithPoolSize(c.PoolSize))
+	if err != nil {
+		return nil, err
+	}
+	return &RedisIndex{DB: db}, nil
+}
+
+// setErr records err for Err if it is not nil
+func (r *RedisIndex) setErr(err error) {
+	if err != nil {
+		r.mu.Lock()
+		r.err = err
+		r.mu.Unlock()
+	}
+}
+
+// Err returns the last error of a NearestNeighbors or Count call
+func (r *RedisIndex) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// IndexPoints adds the points Redis can store
+func (r *RedisIndex) IndexPoints(points []*models.Point) error {
+	_, err := r.DB.Add(context.Background(), points)
+	return err
+}
+
+// QueryBox returns the points inside box
+func (r *RedisIndex) QueryBox(box models.BoundingBox) ([]*models.Point, error) {
+	return r.DB.QueryBox(context.Background(), box)
+}
+
+// QueryBoxContext is QueryBox with a context
+func (r *RedisIndex) QueryBoxContext(ctx context.Context, box models.BoundingBox) ([]*models.Point, error) {
+	return r.DB.QueryBox(ctx, box)
+}
+
+// QueryRadius returns the points within radius kilometers of center
+func (r *RedisIndex) QueryRadius(center models.Location, radius float64) ([]*models.Point, error) {
+	return r.DB.QueryRadius(context.Background(), center, radius)
+}
+
+// QueryRadiusContext is QueryRadius with a context
+func (r *RedisIndex) QueryRadiusContext(ctx context.Context, center models.Location, radius float64) ([]*models.Point, error) {
+	return r.DB.QueryRadius(ctx, center, radius)
+}
+
+// NearestNeighbors returns up to n points nearest to center, or nil and sets
+// Err on failure
+func (r *RedisIndex) NearestNeighbors(center models.Location, n int) []*models.Point {
+	points, err := r.DB.NearestNeighbors(context.Background(), center, n)
+	r.setErr(err)
+	return points
+}
+
+// NearestNeighborsContext returns up to n points nearest to center, nearest
+// first
+func (r *RedisIndex) NearestNeighborsContext(ctx context.Context, center models.Location, n int) ([]*models.Point, error) {
+	return r.DB.NearestNeighbors(ctx, center, n)
+}
+
+// Count returns the number of stored points, or zero and sets Err on failure
+func (r *RedisIndex) Count() int64 {
+	count, err := r.DB.Count(context.Background())
+	r.setErr(err)
+	return count
+}
+
+// SaveToFile writes every stored point to an index file
+func (r *RedisIndex) SaveToFile(path string) error {
+	points, err := r.QueryBox(models.BoundingBox{
+		BottomLeft: models.Location{Lat: -90, Lon: -180},
+		TopRight:   models.Location{Lat: 90, Lon: 180},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read points: %w", err)
+	}
+	index := rtree.NewGeoIndex()
+	if err := index.IndexPoints(points); err != nil {
+		return err
+	}
+	return index.SaveToFile(path)
+}
+
+// LoadFromFile adds every point of an index file
+func (r *RedisIndex) LoadFromFile(path string) error {
+	points, err := rtree.ReadPoints(path)
+	if err != nil {
+		return err
+	}
+	return r.IndexPoints(points)
+}
+
+// Close closes the connections
+func (r *RedisIndex) Close() error {
+	return r.DB.Close()
+}
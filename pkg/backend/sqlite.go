@@ -0,0 +1,278 @@
+package backend
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/1F47E/geo-index-rtree/pkg/config"
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/1F47E/geo-index-rtree/pkg/rtree"
+)
+
+// sqliteSchema creates the points table and the R*Tree indexing it. R*Tree
+// keeps coordinates as 32-bit floats rounded outwards, so its matches are
+// candidates that queries check against the exact lat and lon columns.
+const sqliteSchema = `
+	CREATE TABLE IF NOT EXISTS points (
+		pk  INTEGER PRIMARY KEY,
+		id  TEXT NOT NULL UNIQUE,
+		lat REAL NOT NULL,
+		lon REAL NOT NULL
+	);
+	CREATE VIRTUAL TABLE IF NOT EXISTS points_rtree USING rtree(pk, min_lat, max_lat, min_lon, max_lon);
+`
+
+const sqliteBoxQuery = `
+	SELECT p.id, p.lat, p.lon
+	FROM points_rtree r JOIN points p ON p.pk = r.pk
+	WHERE r.max_lat >= ? AND r.min_lat <= ? AND r.max_lon >= ? AND r.min_lon <= ?
+		AND p.lat BETWEEN ? AND ? AND p.lon BETWEEN ? AND ?
+`
+
+// sqliteNearestStartKm is the radius NearestNeighbors searches first,
+// quadrupling it until enough points are found
+const sqliteNearestStartKm = 10
+
+// halfCircumferenceKm is the distance to the antipode, beyond which a radius
+// search covers the globe
+const halfCircumferenceKm = math.Pi * 6371
+
+// SQLiteIndex keeps points in an SQLite database indexed by its R*Tree
+// module: a database to compare the in-memory indexes with where Docker and
+// PostGIS are not available. Like PostGISIndex it stores IDs and locations,
+// upserts points by ID and converts index files through an in-memory R-tree.
+//
+// database/sql needs an SQLite driver built with R*Tree in the binary. Build
+// with -tags sqlite to link modernc.org/sqlite, which is pure Go, or link
+// another and name it in sqlite.driver.
+//
+// NearestNeighbors and Count cannot return errors through SpatialIndex; when
+// they fail they return nothing and Err reports why. NearestNeighborsContext
+// returns the error instead.
+type SQLiteIndex struct {
+	DB *sql.DB
+
+	mu  sync.Mutex
+	err error
+}
+
+var _ ContextIndex = (*SQLiteIndex)(nil)
+
+// openSQLite opens the database of c, creating it and its tables if missing
+func openSQLite(c config.SQLiteConfig) (SpatialIndex, error) {
+	if !slices.Contains(sql.Drivers(), c.Driver) {
+		return nil, fmt.Errorf("no %q database/sql driver is linked in; build with -tags sqlite", c.Driver)
+	}
+	path := c.Path
+	if path == "" {
+		path = ":memory:"
+	}
+	db, err := sql.Open(c.Driver, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	if path == ":memory:" {
+		// Every connection opens its own in-memory database
+		db.SetMaxOpenConns(1)
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create schema in %s: %w", path, err)
+	}
+	return &SQLiteIndex{DB: db}, nil
+}
+
+// setErr records err for Err if it is not nil
+func (s *SQLiteIndex) setErr(err error) {
+	if err != nil {
+		s.mu.Lock()
+		s.err = err
+		s.mu.Unlock()
+	}
+}
+
+// Err returns the last error of a NearestNeighbors or Count call
+func (s *SQLiteIndex) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// IndexPoints upserts the points that have a location in one transaction
+func (s *SQLiteIndex) IndexPoints(points []*models.Point) error {
+	ctx := context.Background()
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	upsert, err := tx.PrepareContext(ctx, `
+		INSERT INTO points (id, lat, lon) VALUES (?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET lat = excluded.lat, lon = excluded.lon
+		RETURNING pk
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer upsert.Close()
+	index, err := tx.PrepareContext(ctx, `
+		INSERT OR REPLACE INTO points_rtree (pk, min_lat, max_lat, min_lon, max_lon) VALUES (?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer index.Close()
+
+	for _, point := range points {
+		if point.Location == nil {
+			continue
+		}
+		lat, lon := point.Location.Lat, point.Location.Lon
+		var pk int64
+		if err := upsert.QueryRowContext(ctx, point.ID, lat, lon).Scan(&pk); err != nil {
+			return fmt.Errorf("failed to upsert point %s: %w", point.ID, err)
+		}
+		if _, err := index.ExecContext(ctx, pk, lat, lat, lon, lon); err != nil {
+			return fmt.Errorf("failed to index point %s: %w", point.ID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit upsert: %w", err)
+	}
+	return nil
+}
+
+// QueryBox returns the points inside box
+func (s *SQLiteIndex) QueryBox(box models.BoundingBox) ([]*models.Point, error) {
+	return s.QueryBoxContext(context.Background(), box)
+}
+
+// QueryBoxContext is QueryBox with a context
+func (s *SQLiteIndex) QueryBoxContext(ctx context.Context, box models.BoundingBox) ([]*models.Point, error) {
+	bl, tr := box.BottomLeft, box.TopRight
+	rows, err := s.DB.QueryContext(ctx, sqliteBoxQuery,
+		bl.Lat, tr.Lat, bl.Lon, tr.Lon,
+		bl.Lat, tr.Lat, bl.Lon, tr.Lon)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+
+	var results []*models.Point
+	for rows.Next() {
+		var id string
+		var lat, lon float64
+		if err := rows.Scan(&id, &lat, &lon); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		results = append(results, &models.Point{ID: id, Location: &models.Location{Lat: lat, Lon: lon}})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+	return results, nil
+}
+
+// QueryRadius returns the points within radius kilometers of center
+func (s *SQLiteIndex) QueryRadius(center models.Location, radius float64) ([]*models.Point, error) {
+	return s.QueryRadiusContext(context.Background(), center, radius)
+}
+
+// QueryRadiusContext is QueryRadius with a context. It reads the points of
+// the boxes around the circle and keeps those within radius.
+func (s *SQLiteIndex) QueryRadiusContext(ctx context.Context, center models.Location, radius float64) ([]*models.Point, error) {
+	var results []*models.Point
+	for _, box := range models.NewBoundingBoxesFromCenter(center, radius) {
+		candidates, err := s.QueryBoxContext(ctx, box)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range candidates {
+			if center.DistanceTo(*p.Location) <= radius {
+				results = append(results, p)
+			}
+		}
+	}
+	return results, nil
+}
+
+// NearestNeighbors returns up to n points nearest to center, or nil and sets
+// Err on failure
+func (s *SQLiteIndex) NearestNeighbors(center models.Location, n int) []*models.Point {
+	points, err := s.NearestNeighborsContext(context.Background(), center, n)
+	s.setErr(err)
+	return points
+}
+
+// NearestNeighborsContext returns up to n points nearest to center, nearest
+// first, by widening a radius search until it holds n points or covers the
+// globe
+func (s *SQLiteIndex) NearestNeighborsContext(ctx context.Context, center models.Location, n int) ([]*models.Point, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	var results []*models.Point
+	for radiusKm := float64(sqliteNearestStartKm); ; radiusKm *= 4 {
+		var err error
+		if results, err = s.QueryRadiusContext(ctx, center, radiusKm); err != nil {
+			return nil, err
+		}
+		if len(results) >= n || radiusKm >= halfCircumferenceKm {
+			break
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return center.DistanceTo(*results[i].Location) < center.DistanceTo(*results[j].Location)
+	})
+	if len(results) > n {
+		results = results[:n]
+	}
+	return results, nil
+}
+
+// Count returns the number of rows, or zero and sets Err on failure
+func (s *SQLiteIndex) Count() int64 {
+	var count int64
+	if err := s.DB.QueryRow(`SELECT COUNT(*) FROM points`).Scan(&count); err != nil {
+		s.setErr(fmt.Errorf("failed to count points: %w", err))
+		return 0
+	}
+	return count
+}
+
+// SaveToFile writes every point of the database to an index file
+func (s *SQLiteIndex) SaveToFile(path string) error {
+	points, err := s.QueryBox(models.BoundingBox{
+		BottomLeft: models.Location{Lat: -90, Lon: -180},
+		TopRight:   models.Location{Lat: 90, Lon: 180},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read points: %w", err)
+	}
+	index := rtree.NewGeoIndex()
+	if err := index.IndexPoints(points); err != nil {
+		return err
+	}
+	return index.SaveToFile(path)
+}
+
+// LoadFromFile upserts every point of an index file
+func (s *SQLiteIndex) LoadFromFile(path string) error {
+	points, err := rtree.ReadPoints(path)
+	if err != nil {
+		return err
+	}
+	return s.IndexPoints(points)
+}
+
+// Close closes the database
+func (s *SQLiteIndex) Close() error {
+	return s.DB.Close()
+}
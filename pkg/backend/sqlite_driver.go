@@ -0,0 +1,6 @@
+//go:build sqlite
+
+package backend
+
+// The pure Go SQLite driver, registered as "sqlite" with R*Tree enabled
+import _ "modernc.org/sqlite"
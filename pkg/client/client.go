@@ -0,0 +1,208 @@
+// Package client is a Go client for the HTTP API served by pkg/server
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+)
+
+// Client talks to a geo index server
+type Client struct {
+	baseURL    string
+	authToken  string
+	httpClient *http.Client
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithAuthToken sends the token as a bearer token on every request
+func WithAuthToken(token string) Option {
+	return func(c *Client) {
+		c.authToken = token
+	}
+}
+
+// WithHTTPClient replaces the default HTTP client (10s timeout)
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// New creates a client for the server at baseURL, e.g. "http://localhost:8080"
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// QueryResult is the response of the query endpoints
+type QueryResult struct {
+	Count  int             `json:"count"`
+	TookUs int64           `json:"took_us"`
+	Points []*models.Point `json:"points"`
+}
+
+// InsertResult is the response of the insert endpoint. Indexed leaves out
+// points without a location, which the server skips.
+type InsertResult struct {
+	Indexed int   `json:"indexed"`
+	Total   int64 `json:"total"`
+}
+
+// Stats is the response of the stats endpoint
+type Stats struct {
+	Points    int64 `json:"points"`
+	Snapshots int64 `json:"snapshots"`
+}
+
+// QueryBox returns all points within box
+func (c *Client) QueryBox(ctx context.Context, box models.BoundingBox) (*QueryResult, error) {
+	params := url.Values{}
+	params.Set("min_lat", formatFloat(box.BottomLeft.Lat))
+	params.Set("min_lon", formatFloat(box.BottomLeft.Lon))
+	params.Set("max_lat", formatFloat(box.TopRight.Lat))
+	params.Set("max_lon", formatFloat(box.TopRight.Lon))
+
+	var result QueryResult
+	if err := c.do(ctx, http.MethodGet, "/query/box?"+params.Encode(), nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// QueryRadius returns all points within radiusKm of center
+func (c *Client) QueryRadius(ctx context.Context, center models.Location, radiusKm float64) (*QueryResult, error) {
+	params := url.Values{}
+	params.Set("lat", formatFloat(center.Lat))
+	params.Set("lon", formatFloat(center.Lon))
+	params.Set("radius_km", formatFloat(radiusKm))
+
+	var result QueryResult
+	if err := c.do(ctx, http.MethodGet, "/query/radius?"+params.Encode(), nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// QueryRadiusIn returns all points within radius of center, with radius in unit
+func (c *Client) QueryRadiusIn(ctx context.Context, center models.Location, radius float64, unit models.Unit) (*QueryResult, error) {
+	params := url.Values{}
+	params.Set("lat", formatFloat(center.Lat))
+	params.Set("lon", formatFloat(center.Lon))
+	params.Set("radius", formatFloat(radius))
+	params.Set("unit", string(unit))
+
+	var result QueryResult
+	if err := c.do(ctx, http.MethodGet, "/query/radius?"+params.Encode(), nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// NearestNeighbors returns the k points closest to center
+func (c *Client) NearestNeighbors(ctx context.Context, center models.Location, k int) (*QueryResult, error) {
+	params := url.Values{}
+	params.Set("lat", formatFloat(center.Lat))
+	params.Set("lon", formatFloat(center.Lon))
+	params.Set("k", strconv.Itoa(k))
+
+	var result QueryResult
+	if err := c.do(ctx, http.MethodGet, "/query/nearest?"+params.Encode(), nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// InsertPoints adds points to the served index
+func (c *Client) InsertPoints(ctx context.Context, points []*models.Point) (*InsertResult, error) {
+	body, err := json.Marshal(points)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode points: %w", err)
+	}
+
+	var result InsertResult
+	if err := c.do(ctx, http.MethodPost, "/points", body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Stats returns server-side index statistics
+func (c *Client) Stats(ctx context.Context) (*Stats, error) {
+	var result Stats
+	if err := c.do(ctx, http.MethodGet, "/stats", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// APIError is returned for non-2xx responses
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("server returned %d: %s", e.StatusCode, e.Message)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body []byte, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.authToken)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		msg := strings.TrimSpace(string(data))
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error != "" {
+			msg = apiErr.Error
+		}
+		return &APIError{StatusCode: resp.StatusCode, Message: msg}
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
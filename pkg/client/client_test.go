@@ -0,0 +1,62 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/1F47E/geo-index-rtree/pkg/rtree"
+	"github.com/1F47E/geo-index-rtree/pkg/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	index := rtree.NewGeoIndex()
+	srv := server.New(index, server.Config{AuthToken: "secret"})
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	ctx := context.Background()
+	c := New(ts.URL, WithAuthToken("secret"))
+
+	inserted, err := c.InsertPoints(ctx, []*models.Point{
+		{ID: "SF", Location: &models.Location{Lat: 37.7749, Lon: -122.4194}},
+		{ID: "LA", Location: &models.Location{Lat: 34.0522, Lon: -118.2437}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, inserted.Indexed)
+	assert.Equal(t, int64(2), inserted.Total)
+
+	box, err := c.QueryBox(ctx, models.BoundingBox{
+		BottomLeft: models.Location{Lat: 37, Lon: -123},
+		TopRight:   models.Location{Lat: 38, Lon: -122},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, box.Count)
+	assert.Equal(t, "SF", box.Points[0].ID)
+
+	radius, err := c.QueryRadius(ctx, models.Location{Lat: 34, Lon: -118.2}, 20)
+	require.NoError(t, err)
+	assert.Equal(t, 1, radius.Count)
+	radius, err = c.QueryRadiusIn(ctx, models.Location{Lat: 34, Lon: -118.2}, 12, models.Miles)
+	require.NoError(t, err)
+	assert.Equal(t, 1, radius.Count)
+
+	nearest, err := c.NearestNeighbors(ctx, models.Location{Lat: 37, Lon: -122}, 1)
+	require.NoError(t, err)
+	require.Len(t, nearest.Points, 1)
+	assert.Equal(t, "SF", nearest.Points[0].ID)
+
+	stats, err := c.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), stats.Points)
+
+	_, err = New(ts.URL).Stats(ctx)
+	var apiErr *APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, 401, apiErr.StatusCode)
+	assert.Equal(t, "unauthorized", apiErr.Message)
+}
@@ -0,0 +1,299 @@
+// Package config loads the settings shared by the CLI commands and the demo:
+// index path, server settings, PostGIS, SQLite and Redis connections and
+// benchmark defaults.
+//
+// Values are resolved in order of increasing priority: built-in defaults, the
+// YAML config file, then GEOINDEX_<SECTION>_<KEY> environment variables (e.g.
+// GEOINDEX_POSTGIS_PASSWORD). Command-line flags override all of them.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// EnvPrefix starts every environment variable read by Load
+	EnvPrefix = "GEOINDEX_"
+	// EnvFile names the config file to use when none is given explicitly
+	EnvFile = EnvPrefix + "CONFIG"
+	// DefaultFile is read from the working directory if present
+	DefaultFile = "config.yaml"
+)
+
+// envAliases are older variable names still honored, mapped to their current names
+var envAliases = map[string]string{
+	"GEOINDEX_AUTH_TOKEN": "GEOINDEX_SERVER_AUTH_TOKEN",
+}
+
+// Config holds every configurable setting
+type Config struct {
+	Index     IndexConfig     `yaml:"index"`
+	Server    ServerConfig    `yaml:"server"`
+	PostGIS   PostGISConfig   `yaml:"postgis"`
+	SQLite    SQLiteConfig    `yaml:"sqlite"`
+	Redis     RedisConfig     `yaml:"redis"`
+	Benchmark BenchmarkConfig `yaml:"benchmark"`
+	Demo      DemoConfig      `yaml:"demo"`
+	Network   NetworkConfig   `yaml:"network"`
+
+	// Path is the file the config was read from, empty if none
+	Path string `yaml:"-"`
+}
+
+// IndexConfig locates the index file and selects the backend holding points
+type IndexConfig struct {
+	File    string `yaml:"file"`
+	Backend string `yaml:"backend"` // rtree, compact, geohash, s2, quadtree, kdtree, grid, spacetime, postgis, sqlite or redis
+}
+
+// ServerConfig configures the HTTP server and clients of it
+type ServerConfig struct {
+	Host             string        `yaml:"host"`
+	Port             int           `yaml:"port"`
+	URL              string        `yaml:"url"` // used by commands that talk to a running server
+	AuthToken        string        `yaml:"auth_token"`
+	Metrics          bool          `yaml:"metrics"`
+	UI               bool          `yaml:"ui"`
+	SnapshotInterval time.Duration `yaml:"snapshot_interval"`
+}
+
+// PostGISConfig holds the PostGIS connection settings
+type PostGISConfig struct {
+	Host              string `yaml:"host"`
+	Port              int    `yaml:"port"`
+	User              string `yaml:"user"`
+	Password          string `yaml:"password"`
+	Database          string `yaml:"database"`
+	MaxConnections    int    `yaml:"max_connections"`
+	ConnectionTimeout int    `yaml:"connection_timeout"` // seconds
+	ExplainSamples    int    `yaml:"explain_samples"`
+}
+
+// SQLiteConfig holds the settings of the SQLite R*Tree backend
+type SQLiteConfig struct {
+	Path   string `yaml:"path"`   // database file, empty for an in-memory database
+	Driver string `yaml:"driver"` // database/sql driver name
+}
+
+// RedisConfig holds the settings of the Redis GEO backend
+type RedisConfig struct {
+	Addr     string `yaml:"addr"` // host:port
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+	Key      string `yaml:"key"` // sorted set holding the points
+	PoolSize int    `yaml:"pool_size"`
+}
+
+// BenchmarkConfig holds defaults for the load, query, radius and nearest commands
+type BenchmarkConfig struct {
+	Points    int     `yaml:"points"`
+	Queries   int     `yaml:"queries"`
+	Workers   int     `yaml:"workers"` // 0 uses every CPU
+	RadiusKm  float64 `yaml:"radius_km"`
+	Neighbors int     `yaml:"neighbors"`
+}
+
+// DemoConfig holds the demo dataset size and benchmark parameters
+type DemoConfig struct {
+	Database          string  `yaml:"database"` // postgis, sqlite or redis, the database compared with the R-Tree
+	Points            int     `yaml:"points"`
+	BenchmarkDuration int     `yaml:"benchmark_duration"` // seconds
+	RadiusKm          float64 `yaml:"radius_km"`
+	Neighbors         int     `yaml:"neighbors"`
+}
+
+// NetworkConfig controls PostGIS network latency simulation in the demo
+type NetworkConfig struct {
+	SimulatedLatencyMs int `yaml:"simulated_latency_ms"`
+	BatchSize          int `yaml:"batch_size"`
+}
+
+// Default returns the built-in settings used when nothing is configured
+func Default() *Config {
+	return &Config{
+		Index: IndexConfig{File: "geo_index.gob", Backend: "rtree"},
+		Server: ServerConfig{
+			Port: 8080,
+		},
+		PostGIS: PostGISConfig{
+			Host:              "localhost",
+			Port:              5499,
+			User:              "geouser",
+			Password:          "geopass",
+			Database:          "geodb",
+			MaxConnections:    25,
+			ConnectionTimeout: 5,
+			ExplainSamples:    20,
+		},
+		SQLite: SQLiteConfig{
+			Path:   "geo_index.sqlite",
+			Driver: "sqlite",
+		},
+		Redis: RedisConfig{
+			Addr:     "localhost:6399",
+			Key:      "geoindex:points",
+			PoolSize: 16,
+		},
+		Benchmark: BenchmarkConfig{
+			Points:    1000000,
+			Queries:   1000,
+			RadiusKm:  50,
+			Neighbors: 10,
+		},
+		Demo: DemoConfig{
+			Database:          "postgis",
+			Points:            1000000,
+			BenchmarkDuration: 10,
+			RadiusKm:          50,
+			Neighbors:         10,
+		},
+		Network: NetworkConfig{
+			SimulatedLatencyMs: 3,
+			BatchSize:          100,
+		},
+	}
+}
+
+// Load reads the config file at path over the defaults and applies environment
+// overrides. With an empty path, $GEOINDEX_CONFIG is used if set, otherwise
+// config.yaml in the working directory if it exists; an explicitly named file
+// must exist.
+func Load(path string) (*Config, error) {
+	return load(path, os.LookupEnv)
+}
+
+func load(path string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	c := Default()
+
+	required := true
+	if path == "" {
+		if env, ok := lookupEnv(EnvFile); ok && env != "" {
+			path = env
+		} else {
+			path, required = DefaultFile, false
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist) && !required:
+	case err != nil:
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	default:
+		if err := yaml.Unmarshal(data, c); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		c.Path = path
+	}
+
+	if err := c.applyEnv(lookupEnv); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// applyEnv overrides fields from GEOINDEX_<SECTION>_<KEY> variables, where
+// section and key are the upper-cased YAML names
+func (c *Config) applyEnv(lookupEnv func(string) (string, bool)) error {
+	return c.walk(func(name string, field reflect.Value) error {
+		value, ok := lookupEnv(name)
+		for alias, target := range envAliases {
+			if !ok && target == name {
+				value, ok = lookupEnv(alias)
+			}
+		}
+		if !ok {
+			return nil
+		}
+		if err := setField(field, value); err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+		return nil
+	})
+}
+
+// EnvVars lists every environment variable Load reads, besides GEOINDEX_CONFIG
+func EnvVars() []string {
+	var names []string
+	Default().walk(func(name string, _ reflect.Value) error {
+		names = append(names, name)
+		return nil
+	})
+	return names
+}
+
+// walk calls fn with the environment variable name of every settable field
+func (c *Config) walk(fn func(name string, field reflect.Value) error) error {
+	v := reflect.ValueOf(c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		section := yamlName(v.Type().Field(i))
+		if section == "" || v.Field(i).Kind() != reflect.Struct {
+			continue
+		}
+		sv := v.Field(i)
+		for j := 0; j < sv.NumField(); j++ {
+			key := yamlName(sv.Type().Field(j))
+			if key == "" {
+				continue
+			}
+			name := EnvPrefix + strings.ToUpper(section+"_"+key)
+			if err := fn(name, sv.Field(j)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func yamlName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+func setField(field reflect.Value, value string) error {
+	if field.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func envMap(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "geoindex.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+index:
+  file: data/usa.gob
+server:
+  port: 9090
+  snapshot_interval: 30s
+postgis:
+  host: db.internal
+demo:
+  points: 5000
+`), 0644))
+
+	c, err := load(path, envMap(nil))
+	require.NoError(t, err)
+	assert.Equal(t, path, c.Path)
+	assert.Equal(t, "data/usa.gob", c.Index.File)
+	assert.Equal(t, 9090, c.Server.Port)
+	assert.Equal(t, 30*time.Second, c.Server.SnapshotInterval)
+	assert.Equal(t, "db.internal", c.PostGIS.Host)
+	assert.Equal(t, 5000, c.Demo.Points)
+	// Unset keys keep their defaults
+	assert.Equal(t, 5499, c.PostGIS.Port)
+	assert.Equal(t, 10, c.Demo.BenchmarkDuration)
+	assert.Equal(t, "rtree", c.Index.Backend)
+
+	// Environment overrides the file
+	c, err = load(path, envMap(map[string]string{
+		"GEOINDEX_SERVER_PORT":         "7070",
+		"GEOINDEX_POSTGIS_PASSWORD":    "secret",
+		"GEOINDEX_BENCHMARK_RADIUS_KM": "12.5",
+		"GEOINDEX_SERVER_METRICS":      "true",
+		"GEOINDEX_AUTH_TOKEN":          "legacy",
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, 7070, c.Server.Port)
+	assert.Equal(t, "secret", c.PostGIS.Password)
+	assert.Equal(t, 12.5, c.Benchmark.RadiusKm)
+	assert.True(t, c.Server.Metrics)
+	assert.Equal(t, "legacy", c.Server.AuthToken)
+
+	// The current name wins over its alias
+	c, err = load(path, envMap(map[string]string{
+		"GEOINDEX_AUTH_TOKEN":        "legacy",
+		"GEOINDEX_SERVER_AUTH_TOKEN": "current",
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, "current", c.Server.AuthToken)
+
+	_, err = load(path, envMap(map[string]string{"GEOINDEX_SERVER_PORT": "http"}))
+	assert.ErrorContains(t, err, "GEOINDEX_SERVER_PORT")
+}
+
+func TestLoadFileResolution(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "env.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("index:\n  file: from-env.gob\n"), 0644))
+
+	// GEOINDEX_CONFIG names the file when no path is given
+	c, err := load("", envMap(map[string]string{EnvFile: path}))
+	require.NoError(t, err)
+	assert.Equal(t, "from-env.gob", c.Index.File)
+
+	// An explicit path must exist, the default one need not
+	_, err = load(filepath.Join(dir, "missing.yaml"), envMap(nil))
+	assert.Error(t, err)
+
+	wd, _ := os.Getwd()
+	require.NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+	c, err = load("", envMap(nil))
+	require.NoError(t, err)
+	assert.Empty(t, c.Path)
+	assert.Equal(t, Default(), c)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bad.yaml"), []byte("server: [1, 2"), 0644))
+	_, err = load("bad.yaml", envMap(nil))
+	assert.Error(t, err)
+}
+
+func TestEnvVars(t *testing.T) {
+	names := EnvVars()
+	assert.Contains(t, names, "GEOINDEX_INDEX_FILE")
+	assert.Contains(t, names, "GEOINDEX_INDEX_BACKEND")
+	assert.Contains(t, names, "GEOINDEX_POSTGIS_PASSWORD")
+	assert.Contains(t, names, "GEOINDEX_NETWORK_SIMULATED_LATENCY_MS")
+	assert.NotContains(t, names, "GEOINDEX_PATH")
+}
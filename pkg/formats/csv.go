@@ -0,0 +1,150 @@
+package formats
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+)
+
+var (
+	latColumns = []string{"lat", "latitude", "y"}
+	lonColumns = []string{"lon", "lng", "long", "longitude", "x"}
+	idColumns  = []string{"id", "point_id", "name"}
+	altColumns = []string{"alt", "altitude", "elevation", "ele"}
+)
+
+// csvReader reads points from CSV with an optional header row. Columns are
+// located by header name (id/lat/lon and common aliases); without a header the
+// layout is assumed to be id,lat,lon (or lat,lon with generated IDs). With a
+// header, any other non-empty columns become string properties.
+type csvReader struct {
+	r        *csv.Reader
+	started  bool
+	idCol    int
+	latCol   int
+	lonCol   int
+	altCol   int
+	line     int
+	propCols map[int]string
+}
+
+func newCSVReader(r io.Reader) *csvReader {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	cr.ReuseRecord = true
+	return &csvReader{r: cr, idCol: -1, altCol: -1}
+}
+
+func (c *csvReader) Read() (*models.Point, error) {
+	for {
+		record, err := c.r.Read()
+		if err != nil {
+			return nil, err
+		}
+		c.line++
+
+		if !c.started {
+			c.started = true
+			if c.detectHeader(record) {
+				continue
+			}
+		}
+
+		return c.parse(record)
+	}
+}
+
+// detectHeader configures column positions and reports whether record is a header row
+func (c *csvReader) detectHeader(record []string) bool {
+	c.latCol, c.lonCol = -1, -1
+	for i, name := range record {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch {
+		case c.latCol < 0 && contains(latColumns, name):
+			c.latCol = i
+		case c.lonCol < 0 && contains(lonColumns, name):
+			c.lonCol = i
+		case c.idCol < 0 && contains(idColumns, name):
+			c.idCol = i
+		case c.altCol < 0 && contains(altColumns, name):
+			c.altCol = i
+		}
+	}
+	if c.latCol >= 0 && c.lonCol >= 0 {
+		for i, name := range record {
+			name = strings.TrimSpace(name)
+			if i == c.latCol || i == c.lonCol || i == c.idCol || i == c.altCol || name == "" {
+				continue
+			}
+			if c.propCols == nil {
+				c.propCols = make(map[int]string)
+			}
+			c.propCols[i] = name
+		}
+		return true
+	}
+
+	// No recognizable header: positional layout
+	if len(record) >= 3 {
+		c.idCol, c.latCol, c.lonCol = 0, 1, 2
+	} else {
+		c.idCol, c.latCol, c.lonCol = -1, 0, 1
+	}
+	return false
+}
+
+func (c *csvReader) parse(record []string) (*models.Point, error) {
+	if c.latCol >= len(record) || c.lonCol >= len(record) {
+		return nil, fmt.Errorf("csv line %d: expected at least %d columns", c.line, max(c.latCol, c.lonCol)+1)
+	}
+
+	lat, err := strconv.ParseFloat(strings.TrimSpace(record[c.latCol]), 64)
+	if err != nil {
+		return nil, fmt.Errorf("csv line %d: invalid latitude %q", c.line, record[c.latCol])
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(record[c.lonCol]), 64)
+	if err != nil {
+		return nil, fmt.Errorf("csv line %d: invalid longitude %q", c.line, record[c.lonCol])
+	}
+
+	id := fmt.Sprintf("point_%d", c.line)
+	if c.idCol >= 0 && c.idCol < len(record) && record[c.idCol] != "" {
+		id = record[c.idCol]
+	}
+
+	point := &models.Point{
+		ID:       id,
+		Location: &models.Location{Lat: lat, Lon: lon},
+	}
+	if c.altCol >= 0 && c.altCol < len(record) && strings.TrimSpace(record[c.altCol]) != "" {
+		alt, err := strconv.ParseFloat(strings.TrimSpace(record[c.altCol]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("csv line %d: invalid altitude %q", c.line, record[c.altCol])
+		}
+		point.Location.Alt = alt
+	}
+	for i, name := range c.propCols {
+		if i >= len(record) || record[i] == "" {
+			continue
+		}
+		if point.Properties == nil {
+			point.Properties = make(map[string]any)
+		}
+		point.Properties[name] = record[i]
+	}
+	return point, nil
+}
+
+func contains(values []string, v string) bool {
+	for _, candidate := range values {
+		if candidate == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,795 @@
+package formats
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+)
+
+// FlatGeobuf files are a magic number, a size-prefixed FlatBuffers Header, an
+// optional packed Hilbert R-tree and a sequence of size-prefixed Features.
+// Like the OSM PBF decoder, the code below reads and writes just the tables it
+// needs by hand rather than depending on generated FlatBuffers code.
+
+const (
+	maxFGBHeaderSize  = 10 * 1024 * 1024
+	maxFGBFeatureSize = 32 * 1024 * 1024
+
+	fgbNodeItemSize = 40 // minX, minY, maxX, maxY, offset
+
+	fgbGeometryPoint   = 1
+	fgbDefaultNodeSize = 16
+)
+
+// Column types written by fgbWriter
+const (
+	fgbColumnBool   = 2
+	fgbColumnLong   = 7
+	fgbColumnDouble = 10
+	fgbColumnString = 11
+	fgbColumnJSON   = 12
+)
+
+// Header, Column, Feature and Geometry field ids from the FlatGeobuf schema
+const (
+	fgbHeaderEnvelope      = 1
+	fgbHeaderGeometryType  = 2
+	fgbHeaderColumns       = 7
+	fgbHeaderFeaturesCount = 8
+	fgbHeaderIndexNodeSize = 9
+	fgbHeaderCrs           = 10
+
+	fgbColumnName = 0
+	fgbColumnType = 1
+
+	fgbCrsCode = 1
+
+	fgbFeatureGeometry   = 0
+	fgbFeatureProperties = 1
+	fgbFeatureColumns    = 2
+
+	fgbGeometryXY   = 1
+	fgbGeometryType = 6
+)
+
+var (
+	fgbMagic          = []byte{'f', 'g', 'b', 3, 'f', 'g', 'b', 0}
+	errMalformedFGB   = errors.New("malformed flatgeobuf data")
+	fgbFixedTypeSizes = map[uint8]int{
+		0: 1, 1: 1, 2: 1, // byte, ubyte, bool
+		3: 2, 4: 2, // short, ushort
+		5: 4, 6: 4, 9: 4, // int, uint, float
+		7: 8, 8: 8, 10: 8, // long, ulong, double
+	}
+)
+
+type fgbColumn struct {
+	name string
+	typ  uint8
+}
+
+// fgbReader streams Point features from a FlatGeobuf file. Features with other
+// geometry types are skipped. IDs come from the first column named like the
+// CSV id columns; the other columns become properties.
+type fgbReader struct {
+	r            io.Reader
+	started      bool
+	geometryType uint8
+	columns      []fgbColumn
+	n            int
+}
+
+func newFGBReader(r io.Reader) *fgbReader {
+	return &fgbReader{r: r}
+}
+
+func (f *fgbReader) Read() (*models.Point, error) {
+	if !f.started {
+		if err := f.readHeader(); err != nil {
+			return nil, err
+		}
+		f.started = true
+	}
+
+	for {
+		var size uint32
+		if err := binary.Read(f.r, binary.LittleEndian, &size); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return nil, errMalformedFGB
+			}
+			return nil, err
+		}
+		if size > maxFGBFeatureSize {
+			return nil, fmt.Errorf("%w: feature of %d bytes", errMalformedFGB, size)
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(f.r, data); err != nil {
+			return nil, fmt.Errorf("failed to read feature %d: %w", f.n+1, err)
+		}
+		f.n++
+
+		point, err := f.decodeFeature(data)
+		if err != nil {
+			return nil, fmt.Errorf("feature %d: %w", f.n, err)
+		}
+		if point != nil {
+			return point, nil
+		}
+	}
+}
+
+// readHeader checks the magic number, decodes the header and skips the index
+func (f *fgbReader) readHeader() error {
+	magic := make([]byte, len(fgbMagic))
+	if _, err := io.ReadFull(f.r, magic); err != nil {
+		return fmt.Errorf("failed to read flatgeobuf magic: %w", err)
+	}
+	// The last byte is the patch version, which readers must accept
+	if !bytes.Equal(magic[:7], fgbMagic[:7]) {
+		return fmt.Errorf("not a flatgeobuf v3 file")
+	}
+
+	var size uint32
+	if err := binary.Read(f.r, binary.LittleEndian, &size); err != nil {
+		return fmt.Errorf("failed to read flatgeobuf header: %w", err)
+	}
+	if size > maxFGBHeaderSize {
+		return fmt.Errorf("%w: header of %d bytes", errMalformedFGB, size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(f.r, data); err != nil {
+		return fmt.Errorf("failed to read flatgeobuf header: %w", err)
+	}
+
+	var count uint64
+	nodeSize := uint16(fgbDefaultNodeSize)
+	err := decodeFB(func() {
+		header := fbRoot(data)
+		f.geometryType = header.uint8(fgbHeaderGeometryType, 0)
+		f.columns = header.columns(fgbHeaderColumns)
+		count = header.uint64(fgbHeaderFeaturesCount, 0)
+		nodeSize = header.uint16(fgbHeaderIndexNodeSize, fgbDefaultNodeSize)
+	})
+	if err != nil {
+		return err
+	}
+
+	if nodeSize > 0 && count > 0 {
+		if _, err := io.CopyN(io.Discard, f.r, int64(fgbIndexSize(count, nodeSize))); err != nil {
+			return fmt.Errorf("failed to skip flatgeobuf index: %w", err)
+		}
+	}
+	return nil
+}
+
+func (f *fgbReader) decodeFeature(data []byte) (point *models.Point, err error) {
+	err = decodeFB(func() {
+		feature := fbRoot(data)
+		geometry, ok := feature.table(fgbFeatureGeometry)
+		if !ok {
+			return
+		}
+		if geometry.uint8(fgbGeometryType, f.geometryType) != fgbGeometryPoint {
+			return
+		}
+		xy := geometry.float64s(fgbGeometryXY)
+		if len(xy) < 2 {
+			panic(fmt.Errorf("%w: point without coordinates", errMalformedFGB))
+		}
+
+		columns := f.columns
+		if override := feature.columns(fgbFeatureColumns); len(override) > 0 {
+			columns = override
+		}
+		id, props := fgbProperties(feature.bytes(fgbFeatureProperties), columns)
+		if id == "" {
+			id = fmt.Sprintf("point_%d", f.n)
+		}
+		point = &models.Point{ID: id, Location: &models.Location{Lat: xy[1], Lon: xy[0]}, Properties: props}
+	})
+	return point, err
+}
+
+// fgbProperties decodes a feature's properties, returning the id column
+// separately. Decoding stops at the first malformed value.
+func fgbProperties(data []byte, columns []fgbColumn) (string, map[string]any) {
+	want := -1
+	for _, name := range idColumns {
+		for i, col := range columns {
+			if strings.EqualFold(col.name, name) {
+				want = i
+				break
+			}
+		}
+		if want >= 0 {
+			break
+		}
+	}
+
+	var id string
+	var props map[string]any
+	for len(data) >= 2 {
+		i := int(binary.LittleEndian.Uint16(data))
+		data = data[2:]
+		if i >= len(columns) {
+			break
+		}
+
+		typ := columns[i].typ
+		size, fixed := fgbFixedTypeSizes[typ]
+		if !fixed {
+			if len(data) < 4 {
+				break
+			}
+			size = int(binary.LittleEndian.Uint32(data))
+			data = data[4:]
+		}
+		if size > len(data) {
+			break
+		}
+		value := fgbValue(typ, data[:size])
+		data = data[size:]
+
+		if i == want {
+			switch v := value.(type) {
+			case string:
+				id = v
+			case int64:
+				id = strconv.FormatInt(v, 10)
+			case uint64:
+				id = strconv.FormatUint(v, 10)
+			}
+			if id != "" {
+				continue
+			}
+		}
+		if value == nil {
+			continue
+		}
+		if props == nil {
+			props = make(map[string]any)
+		}
+		props[columns[i].name] = value
+	}
+	return id, props
+}
+
+// fgbValue decodes one property value of the given column type. Integers
+// decode as int64 (uint64 for ulong), floats as float64, and json columns as
+// the value they hold.
+func fgbValue(typ uint8, b []byte) any {
+	switch typ {
+	case 0:
+		return int64(int8(b[0]))
+	case 1:
+		return int64(b[0])
+	case fgbColumnBool:
+		return b[0] != 0
+	case 3:
+		return int64(int16(binary.LittleEndian.Uint16(b)))
+	case 4:
+		return int64(binary.LittleEndian.Uint16(b))
+	case 5:
+		return int64(int32(binary.LittleEndian.Uint32(b)))
+	case 6:
+		return int64(binary.LittleEndian.Uint32(b))
+	case fgbColumnLong:
+		return int64(binary.LittleEndian.Uint64(b))
+	case 8:
+		return binary.LittleEndian.Uint64(b)
+	case 9:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+	case fgbColumnDouble:
+		return math.Float64frombits(binary.LittleEndian.Uint64(b))
+	case fgbColumnString, 13: // string, ISO 8601 datetime
+		return string(b)
+	case fgbColumnJSON:
+		var v any
+		if json.Unmarshal(b, &v) != nil {
+			return string(b)
+		}
+		return v
+	case 14:
+		return bytes.Clone(b)
+	}
+	return nil
+}
+
+// fgbIndexSize returns the byte size of a packed Hilbert R-tree over count items
+func fgbIndexSize(count uint64, nodeSize uint16) uint64 {
+	if nodeSize < 2 {
+		nodeSize = 2
+	}
+	n, nodes := count, count
+	for n > 1 {
+		n = (n + uint64(nodeSize) - 1) / uint64(nodeSize)
+		nodes += n
+	}
+	return nodes * fgbNodeItemSize
+}
+
+// fgbWriter writes Point features with a string "id" column followed by one
+// column per property name, and no spatial index. Points are buffered so the
+// header can carry the feature count, extent and column types.
+type fgbWriter struct {
+	w        io.Writer
+	points   []models.Point
+	envelope [4]float64 // minX, minY, maxX, maxY
+}
+
+func newFGBWriter(w io.Writer) *fgbWriter {
+	return &fgbWriter{
+		w:        w,
+		envelope: [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)},
+	}
+}
+
+func (f *fgbWriter) Write(point *models.Point) error {
+	if point.Location == nil {
+		return nil
+	}
+	lon, lat := point.Location.Lon, point.Location.Lat
+	f.envelope = [4]float64{
+		math.Min(f.envelope[0], lon), math.Min(f.envelope[1], lat),
+		math.Max(f.envelope[2], lon), math.Max(f.envelope[3], lat),
+	}
+
+	location := *point.Location
+	f.points = append(f.points, models.Point{ID: point.ID, Location: &location, Properties: point.Properties})
+	return nil
+}
+
+func (f *fgbWriter) Close() error {
+	columns := fgbInferColumns(f.points)
+	count := uint64(len(f.points))
+	fields := []fbField{
+		{id: fgbHeaderGeometryType, size: 1, value: fgbGeometryPoint},
+		{id: fgbHeaderColumns, ref: func(b *fbBuilder) int {
+			return b.tables(len(columns), func(b *fbBuilder, i int) int {
+				return b.table([]fbField{
+					{id: fgbColumnName, ref: func(b *fbBuilder) int { return b.string(columns[i].name) }},
+					{id: fgbColumnType, size: 1, value: uint64(columns[i].typ)},
+				})
+			})
+		}},
+		{id: fgbHeaderFeaturesCount, size: 8, value: count},
+		// Written explicitly because the schema default (16) means "indexed"
+		{id: fgbHeaderIndexNodeSize, size: 2, value: 0},
+		{id: fgbHeaderCrs, ref: func(b *fbBuilder) int {
+			return b.table([]fbField{{id: fgbCrsCode, size: 4, value: 4326}})
+		}},
+	}
+	if count > 0 {
+		envelope := f.envelope
+		fields = append(fields, fbField{id: fgbHeaderEnvelope, ref: func(b *fbBuilder) int {
+			return b.float64s(envelope[:])
+		}})
+	}
+
+	b := newFBBuilder()
+	b.finish(b.table(fields))
+
+	if _, err := f.w.Write(fgbMagic); err != nil {
+		return err
+	}
+	if _, err := f.w.Write(b.sizePrefixed()); err != nil {
+		return err
+	}
+
+	for i := range f.points {
+		feature, err := fgbEncodeFeature(&f.points[i], columns)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %w", f.points[i].ID, err)
+		}
+		if _, err := f.w.Write(feature); err != nil {
+			return err
+		}
+	}
+	f.points = nil
+	return nil
+}
+
+// fgbInferColumns returns the id column followed by a column for every
+// property name, sorted. A column is bool, string, long or double when all its
+// values are of that kind (mixed integers and floats give double), and json
+// otherwise. An "id" property is dropped in favor of the point ID.
+func fgbInferColumns(points []models.Point) []fgbColumn {
+	types := make(map[string]uint8)
+	for i := range points {
+		for name, value := range points[i].Properties {
+			if value == nil || strings.EqualFold(name, "id") {
+				continue
+			}
+			typ := fgbPropertyType(value)
+			prev, seen := types[name]
+			switch {
+			case !seen || prev == typ:
+				types[name] = typ
+			case (prev == fgbColumnLong || prev == fgbColumnDouble) && (typ == fgbColumnLong || typ == fgbColumnDouble):
+				types[name] = fgbColumnDouble
+			default:
+				types[name] = fgbColumnJSON
+			}
+		}
+	}
+
+	names := make([]string, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	columns := []fgbColumn{{name: "id", typ: fgbColumnString}}
+	for _, name := range names {
+		columns = append(columns, fgbColumn{name: name, typ: types[name]})
+	}
+	return columns
+}
+
+// fgbPropertyType returns the column type that can hold a property value
+func fgbPropertyType(value any) uint8 {
+	switch value.(type) {
+	case bool:
+		return fgbColumnBool
+	case string:
+		return fgbColumnString
+	case int, int8, int16, int32, int64, uint8, uint16, uint32:
+		return fgbColumnLong
+	case float32, float64:
+		return fgbColumnDouble
+	}
+	return fgbColumnJSON
+}
+
+// fgbEncodeFeature builds a size-prefixed Feature for point
+func fgbEncodeFeature(point *models.Point, columns []fgbColumn) ([]byte, error) {
+	props := make([]byte, 6, 6+len(point.ID))
+	binary.LittleEndian.PutUint32(props[2:], uint32(len(point.ID)))
+	props = append(props, point.ID...)
+
+	for i, col := range columns[1:] {
+		value, ok := point.Properties[col.name]
+		if !ok || value == nil {
+			continue
+		}
+		props = binary.LittleEndian.AppendUint16(props, uint16(i+1))
+
+		switch col.typ {
+		case fgbColumnBool:
+			if value.(bool) {
+				props = append(props, 1)
+			} else {
+				props = append(props, 0)
+			}
+		case fgbColumnLong:
+			n := reflect.ValueOf(value)
+			if n.CanUint() {
+				props = binary.LittleEndian.AppendUint64(props, n.Uint())
+			} else {
+				props = binary.LittleEndian.AppendUint64(props, uint64(n.Int()))
+			}
+		case fgbColumnDouble:
+			n := reflect.ValueOf(value)
+			var v float64
+			if n.CanFloat() {
+				v = n.Float()
+			} else if n.CanUint() {
+				v = float64(n.Uint())
+			} else {
+				v = float64(n.Int())
+			}
+			props = binary.LittleEndian.AppendUint64(props, math.Float64bits(v))
+		case fgbColumnString:
+			s := value.(string)
+			props = binary.LittleEndian.AppendUint32(props, uint32(len(s)))
+			props = append(props, s...)
+		default:
+			data, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			props = binary.LittleEndian.AppendUint32(props, uint32(len(data)))
+			props = append(props, data...)
+		}
+	}
+
+	lon, lat := point.Location.Lon, point.Location.Lat
+	b := newFBBuilder()
+	b.finish(b.table([]fbField{
+		{id: fgbFeatureGeometry, ref: func(b *fbBuilder) int {
+			return b.table([]fbField{
+				{id: fgbGeometryXY, ref: func(b *fbBuilder) int { return b.float64s([]float64{lon, lat}) }},
+			})
+		}},
+		{id: fgbFeatureProperties, ref: func(b *fbBuilder) int { return b.bytes(props) }},
+	}))
+	return b.sizePrefixed(), nil
+}
+
+// fbField is one field of a FlatBuffers table under construction: either an
+// inline scalar of size bytes or, when ref is set, an offset to a child object
+type fbField struct {
+	id    int
+	size  int
+	value uint64
+	ref   func(b *fbBuilder) int
+}
+
+// fbBuilder lays out a FlatBuffer front to back: every table is preceded by
+// its vtable and followed by the objects it references, so all uoffsets point
+// forward as the format requires
+type fbBuilder struct {
+	buf []byte
+}
+
+func newFBBuilder() *fbBuilder {
+	// Room for the root table offset
+	return &fbBuilder{buf: make([]byte, 4, 256)}
+}
+
+func (b *fbBuilder) pad(align int) {
+	for len(b.buf)%align != 0 {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+func (b *fbBuilder) putOffset(at, target int) {
+	binary.LittleEndian.PutUint32(b.buf[at:], uint32(target-at))
+}
+
+func (b *fbBuilder) table(fields []fbField) int {
+	maxID := 0
+	for _, f := range fields {
+		if f.id > maxID {
+			maxID = f.id
+		}
+	}
+
+	// Inline layout: soffset to vtable, then fields at naturally aligned offsets
+	offsets := make([]int, len(fields))
+	size := 4
+	for _, want := range []int{8, 4, 2, 1} {
+		for i, f := range fields {
+			fieldSize := f.size
+			if f.ref != nil {
+				fieldSize = 4
+			}
+			if fieldSize == want {
+				offsets[i] = size
+				size += want
+			}
+		}
+	}
+
+	b.pad(2)
+	vtable := len(b.buf)
+	b.buf = binary.LittleEndian.AppendUint16(b.buf, uint16(4+2*(maxID+1)))
+	b.buf = binary.LittleEndian.AppendUint16(b.buf, uint16(size))
+	entries := make([]uint16, maxID+1)
+	for i, f := range fields {
+		entries[f.id] = uint16(offsets[i])
+	}
+	for _, e := range entries {
+		b.buf = binary.LittleEndian.AppendUint16(b.buf, e)
+	}
+
+	b.pad(8)
+	table := len(b.buf)
+	b.buf = append(b.buf, make([]byte, size)...)
+	binary.LittleEndian.PutUint32(b.buf[table:], uint32(int32(table-vtable)))
+
+	for i, f := range fields {
+		at := table + offsets[i]
+		switch {
+		case f.ref != nil:
+		case f.size == 1:
+			b.buf[at] = byte(f.value)
+		case f.size == 2:
+			binary.LittleEndian.PutUint16(b.buf[at:], uint16(f.value))
+		case f.size == 4:
+			binary.LittleEndian.PutUint32(b.buf[at:], uint32(f.value))
+		case f.size == 8:
+			binary.LittleEndian.PutUint64(b.buf[at:], f.value)
+		}
+	}
+	for i, f := range fields {
+		if f.ref != nil {
+			at := table + offsets[i]
+			b.putOffset(at, f.ref(b))
+		}
+	}
+	return table
+}
+
+func (b *fbBuilder) string(s string) int {
+	b.pad(4)
+	pos := len(b.buf)
+	b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(len(s)))
+	b.buf = append(b.buf, s...)
+	b.buf = append(b.buf, 0)
+	return pos
+}
+
+func (b *fbBuilder) bytes(data []byte) int {
+	b.pad(4)
+	pos := len(b.buf)
+	b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(len(data)))
+	b.buf = append(b.buf, data...)
+	return pos
+}
+
+func (b *fbBuilder) float64s(values []float64) int {
+	// The elements, not the length prefix, must be 8-byte aligned
+	b.pad(4)
+	if len(b.buf)%8 == 0 {
+		b.buf = append(b.buf, 0, 0, 0, 0)
+	}
+	pos := len(b.buf)
+	b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(len(values)))
+	for _, v := range values {
+		b.buf = binary.LittleEndian.AppendUint64(b.buf, math.Float64bits(v))
+	}
+	return pos
+}
+
+func (b *fbBuilder) tables(n int, build func(b *fbBuilder, i int) int) int {
+	b.pad(4)
+	pos := len(b.buf)
+	b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(n))
+	b.buf = append(b.buf, make([]byte, 4*n)...)
+	for i := 0; i < n; i++ {
+		b.putOffset(pos+4+4*i, build(b, i))
+	}
+	return pos
+}
+
+func (b *fbBuilder) finish(root int) {
+	b.putOffset(0, root)
+}
+
+func (b *fbBuilder) sizePrefixed() []byte {
+	return append(binary.LittleEndian.AppendUint32(nil, uint32(len(b.buf))), b.buf...)
+}
+
+// fbTable reads fields of a FlatBuffers table. Accessors panic with
+// errMalformedFGB on out of range offsets; decodeFB turns that into an error.
+type fbTable struct {
+	buf []byte
+	pos int
+}
+
+func decodeFB(fn func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(error)
+			if !ok || !errors.Is(e, errMalformedFGB) {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+	fn()
+	return nil
+}
+
+func fbCheck(buf []byte, pos, size int) {
+	if pos < 0 || size < 0 || pos > len(buf)-size {
+		panic(errMalformedFGB)
+	}
+}
+
+func fbRoot(buf []byte) fbTable {
+	fbCheck(buf, 0, 4)
+	return fbTable{buf: buf, pos: fbIndirect(buf, 0)}
+}
+
+func fbIndirect(buf []byte, at int) int {
+	fbCheck(buf, at, 4)
+	return at + int(binary.LittleEndian.Uint32(buf[at:]))
+}
+
+// field returns the absolute position of field id, or 0 when it is absent
+func (t fbTable) field(id int) int {
+	fbCheck(t.buf, t.pos, 4)
+	vtable := t.pos - int(int32(binary.LittleEndian.Uint32(t.buf[t.pos:])))
+	fbCheck(t.buf, vtable, 4)
+	vtableSize := int(binary.LittleEndian.Uint16(t.buf[vtable:]))
+	entry := 4 + 2*id
+	if entry+2 > vtableSize {
+		return 0
+	}
+	fbCheck(t.buf, vtable+entry, 2)
+	offset := int(binary.LittleEndian.Uint16(t.buf[vtable+entry:]))
+	if offset == 0 {
+		return 0
+	}
+	return t.pos + offset
+}
+
+func (t fbTable) uint8(id int, def uint8) uint8 {
+	at := t.field(id)
+	if at == 0 {
+		return def
+	}
+	fbCheck(t.buf, at, 1)
+	return t.buf[at]
+}
+
+func (t fbTable) uint16(id int, def uint16) uint16 {
+	at := t.field(id)
+	if at == 0 {
+		return def
+	}
+	fbCheck(t.buf, at, 2)
+	return binary.LittleEndian.Uint16(t.buf[at:])
+}
+
+func (t fbTable) uint64(id int, def uint64) uint64 {
+	at := t.field(id)
+	if at == 0 {
+		return def
+	}
+	fbCheck(t.buf, at, 8)
+	return binary.LittleEndian.Uint64(t.buf[at:])
+}
+
+func (t fbTable) table(id int) (fbTable, bool) {
+	at := t.field(id)
+	if at == 0 {
+		return fbTable{}, false
+	}
+	return fbTable{buf: t.buf, pos: fbIndirect(t.buf, at)}, true
+}
+
+// vector returns the position of the first element and the element count
+func (t fbTable) vector(id int, elemSize int) (int, int) {
+	at := t.field(id)
+	if at == 0 {
+		return 0, 0
+	}
+	pos := fbIndirect(t.buf, at)
+	fbCheck(t.buf, pos, 4)
+	n := int(binary.LittleEndian.Uint32(t.buf[pos:]))
+	if n > (len(t.buf)-pos-4)/elemSize {
+		panic(errMalformedFGB)
+	}
+	return pos + 4, n
+}
+
+func (t fbTable) bytes(id int) []byte {
+	start, n := t.vector(id, 1)
+	return t.buf[start : start+n]
+}
+
+func (t fbTable) string(id int) string {
+	return string(t.bytes(id))
+}
+
+func (t fbTable) float64s(id int) []float64 {
+	start, n := t.vector(id, 8)
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = math.Float64frombits(binary.LittleEndian.Uint64(t.buf[start+8*i:]))
+	}
+	return values
+}
+
+func (t fbTable) columns(id int) []fgbColumn {
+	start, n := t.vector(id, 4)
+	columns := make([]fgbColumn, n)
+	for i := range columns {
+		col := fbTable{buf: t.buf, pos: fbIndirect(t.buf, start+4*i)}
+		columns[i] = fgbColumn{name: col.string(fgbColumnName), typ: col.uint8(fgbColumnType, 0)}
+	}
+	return columns
+}
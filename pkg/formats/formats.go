@@ -0,0 +1,122 @@
+// Package formats reads geographic points from common interchange formats
+// (GeoJSON, CSV, NDJSON, GPX, OSM PBF, FlatGeobuf and Parquet) as a stream, so large
+// inputs can be indexed without materializing every record first
+package formats
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+)
+
+// Format identifies an input/output encoding
+type Format string
+
+const (
+	GeoJSON    Format = "geojson"
+	CSV        Format = "csv"
+	NDJSON     Format = "ndjson"
+	GPX        Format = "gpx"
+	OSMPBF     Format = "osmpbf"
+	FlatGeobuf Format = "flatgeobuf"
+	Parquet    Format = "parquet"
+)
+
+// Reader streams points from an input. Read returns io.EOF once the input is exhausted.
+type Reader interface {
+	Read() (*models.Point, error)
+}
+
+// Detect guesses the format of a file from its extension
+func Detect(path string) (Format, error) {
+	name := strings.ToLower(filepath.Base(path))
+	switch {
+	case strings.HasSuffix(name, ".osm.pbf"), strings.HasSuffix(name, ".pbf"):
+		return OSMPBF, nil
+	case strings.HasSuffix(name, ".geojson"), strings.HasSuffix(name, ".json"):
+		return GeoJSON, nil
+	case strings.HasSuffix(name, ".ndjson"), strings.HasSuffix(name, ".jsonl"), strings.HasSuffix(name, ".geojsonl"):
+		return NDJSON, nil
+	case strings.HasSuffix(name, ".csv"):
+		return CSV, nil
+	case strings.HasSuffix(name, ".gpx"):
+		return GPX, nil
+	case strings.HasSuffix(name, ".fgb"):
+		return FlatGeobuf, nil
+	case strings.HasSuffix(name, ".parquet"), strings.HasSuffix(name, ".geoparquet"):
+		return Parquet, nil
+	}
+	return "", fmt.Errorf("cannot detect format of %s, specify it explicitly", path)
+}
+
+// Parse validates a user supplied format name
+func Parse(name string) (Format, error) {
+	switch f := Format(strings.ToLower(name)); f {
+	case GeoJSON, CSV, NDJSON, GPX, OSMPBF, FlatGeobuf, Parquet:
+		return f, nil
+	case "json":
+		return GeoJSON, nil
+	case "pbf", "osm":
+		return OSMPBF, nil
+	case "fgb":
+		return FlatGeobuf, nil
+	case "geoparquet":
+		return Parquet, nil
+	}
+	return "", fmt.Errorf("unknown format %q", name)
+}
+
+// NewReader returns a streaming point reader for the given format
+func NewReader(format Format, r io.Reader) (Reader, error) {
+	switch format {
+	case GeoJSON:
+		return newGeoJSONReader(r), nil
+	case CSV:
+		return newCSVReader(r), nil
+	case NDJSON:
+		return newNDJSONReader(r), nil
+	case GPX:
+		return newGPXReader(r), nil
+	case OSMPBF:
+		return newPBFReader(r), nil
+	case FlatGeobuf:
+		return newFGBReader(r), nil
+	case Parquet:
+		return newParquetReader(r), nil
+	}
+	return nil, fmt.Errorf("unsupported input format %q", format)
+}
+
+// ReadBatches drains r, calling fn with batches of up to batchSize points.
+// batchSize must be at least 1.
+func ReadBatches(r Reader, batchSize int, fn func([]*models.Point) error) error {
+	if batchSize < 1 {
+		return fmt.Errorf("invalid batch size %d: must be at least 1", batchSize)
+	}
+	batch := make([]*models.Point, 0, batchSize)
+	for {
+		point, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		batch = append(batch, point)
+		if len(batch) == batchSize {
+			if err := fn(batch); err != nil {
+				return err
+			}
+			batch = make([]*models.Point, 0, batchSize)
+		}
+	}
+
+	if len(batch) > 0 {
+		return fn(batch)
+	}
+	return nil
+}
@@ -0,0 +1,607 @@
+package formats
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readAll(t *testing.T, format Format, input string) []*models.Point {
+	r, err := NewReader(format, strings.NewReader(input))
+	require.NoError(t, err)
+
+	var points []*models.Point
+	require.NoError(t, ReadBatches(r, 2, func(batch []*models.Point) error {
+		points = append(points, batch...)
+		return nil
+	}))
+	return points
+}
+
+func TestDetect(t *testing.T) {
+	cases := map[string]Format{
+		"cities.geojson":        GeoJSON,
+		"cities.csv":            CSV,
+		"track.GPX":             GPX,
+		"monaco-latest.osm.pbf": OSMPBF,
+		"points.ndjson":         NDJSON,
+		"points.fgb":            FlatGeobuf,
+		"points.parquet":        Parquet,
+	}
+	for path, want := range cases {
+		got, err := Detect(path)
+		require.NoError(t, err, path)
+		assert.Equal(t, want, got, path)
+	}
+
+	_, err := Detect("points.bin")
+	assert.Error(t, err)
+}
+
+func TestCSV(t *testing.T) {
+	points := readAll(t, CSV, "name,longitude,latitude\nSF,-122.4194,37.7749\nLA,-118.2437,34.0522\nNYC,-74.006,40.7128\n")
+	require.Len(t, points, 3)
+	assert.Equal(t, "SF", points[0].ID)
+	assert.Equal(t, 37.7749, points[0].Location.Lat)
+	assert.Equal(t, -122.4194, points[0].Location.Lon)
+
+	// Headerless input is read as id,lat,lon
+	points = readAll(t, CSV, "a,1.5,2.5\n")
+	require.Len(t, points, 1)
+	assert.Equal(t, "a", points[0].ID)
+	assert.Equal(t, 2.5, points[0].Location.Lon)
+
+	// Extra header columns become properties
+	points = readAll(t, CSV, "id,lat,lon,name,pop\nSF,37.7749,-122.4194,San Francisco,\n")
+	require.Len(t, points, 1)
+	assert.Equal(t, map[string]any{"name": "San Francisco"}, points[0].Properties)
+
+	// Altitude columns set the location's altitude
+	points = readAll(t, CSV, "id,lat,lon,altitude\nd1,37.7749,-122.4194,120.5\nd2,37.7,-122.4,\n")
+	require.Len(t, points, 2)
+	assert.Equal(t, 120.5, points[0].Location.Alt)
+	assert.Zero(t, points[1].Location.Alt)
+	assert.Nil(t, points[0].Properties)
+
+	r, _ := NewReader(CSV, strings.NewReader("id,lat,lon\nx,abc,1\n"))
+	_, err := r.Read()
+	assert.Error(t, err)
+	r, _ = NewReader(CSV, strings.NewReader("id,lat,lon,alt\nx,1,1,high\n"))
+	_, err = r.Read()
+	assert.Error(t, err)
+}
+
+func TestReadBatchesSize(t *testing.T) {
+	for _, size := range []int{0, -1} {
+		r, err := NewReader(CSV, strings.NewReader("a,1,2\n"))
+		require.NoError(t, err)
+		err = ReadBatches(r, size, func([]*models.Point) error { return nil })
+		assert.Error(t, err, size)
+	}
+}
+
+func TestGeoJSON(t *testing.T) {
+	input := `{"type":"FeatureCollection","name":"test","features":[
+		{"type":"Feature","id":7,"geometry":{"type":"Point","coordinates":[-122.4194,37.7749]},"properties":{}},
+		{"type":"Feature","geometry":{"type":"LineString","coordinates":[[0,0],[1,1]]},"properties":{}},
+		{"type":"Feature","geometry":{"type":"Point","coordinates":[-118.2437,34.0522]},"properties":{"id":"LA","pop":3.9e6,"tags":["city"]}},
+		{"type":"Feature","geometry":{"type":"Point","coordinates":[-74.006,40.7128]},"properties":null}
+	]}`
+	points := readAll(t, GeoJSON, input)
+	require.Len(t, points, 3)
+	assert.Equal(t, "7", points[0].ID)
+	assert.Equal(t, 37.7749, points[0].Location.Lat)
+	assert.Equal(t, map[string]any{IDTypeProperty: "number"}, points[0].Properties)
+	assert.Equal(t, "LA", points[1].ID)
+	assert.Equal(t, map[string]any{"id": "LA", "pop": 3.9e6, "tags": []any{"city"}}, points[1].Properties)
+	assert.Equal(t, "point_4", points[2].ID)
+}
+
+func TestGeoJSONFeatureIDs(t *testing.T) {
+	input := `{"type":"FeatureCollection","features":[
+		{"type":"Feature","id":9007199254740993,"geometry":{"type":"Point","coordinates":[1,2]},"properties":{"name":"big"}},
+		{"type":"Feature","id":"123","geometry":{"type":"Point","coordinates":[3,4]},"properties":{}},
+		{"type":"Feature","id":123,"geometry":{"type":"Point","coordinates":[3,4]}},
+		{"type":"Feature","geometry":{"type":"Point","coordinates":[5,6]},"properties":{"id":17}}
+	]}`
+	points := readAll(t, GeoJSON, input)
+	require.Len(t, points, 4)
+	assert.Equal(t, "9007199254740993", points[0].ID)
+	assert.Equal(t, map[string]any{"name": "big", IDTypeProperty: "number"}, points[0].Properties)
+	assert.Equal(t, "123", points[1].ID)
+	assert.Nil(t, points[1].Properties)
+	assert.Equal(t, "123", points[2].ID)
+	assert.Equal(t, map[string]any{IDTypeProperty: "number"}, points[2].Properties)
+	assert.Equal(t, "17", points[3].ID)
+
+	// Numeric IDs are written back as numbers and anything else as strings
+	points = append(points,
+		&models.Point{ID: "7", Location: &models.Location{Lat: 8, Lon: 7}},
+		&models.Point{ID: "-1.5", Location: &models.Location{Lat: 8, Lon: 7}},
+	)
+	var buf bytes.Buffer
+	w, err := NewWriter(GeoJSON, &buf)
+	require.NoError(t, err)
+	for _, p := range points {
+		require.NoError(t, w.Write(p))
+	}
+	require.NoError(t, w.Close())
+	assert.JSONEq(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","id":9007199254740993,"geometry":{"type":"Point","coordinates":[1,2]},"properties":{"name":"big"}},
+		{"type":"Feature","id":"123","geometry":{"type":"Point","coordinates":[3,4]},"properties":{}},
+		{"type":"Feature","id":123,"geometry":{"type":"Point","coordinates":[3,4]},"properties":{}},
+		{"type":"Feature","id":17,"geometry":{"type":"Point","coordinates":[5,6]},"properties":{"id":17}},
+		{"type":"Feature","id":"7","geometry":{"type":"Point","coordinates":[7,8]},"properties":{}},
+		{"type":"Feature","id":"-1.5","geometry":{"type":"Point","coordinates":[7,8]},"properties":{}}
+	]}`, buf.String())
+	assert.Contains(t, buf.String(), `"id":9007199254740993`)
+	assert.Contains(t, buf.String(), `"id":"123"`)
+	assert.Equal(t, points, readAll(t, GeoJSON, buf.String()))
+}
+
+func TestGeoJSONSingleFeature(t *testing.T) {
+	points := readAll(t, GeoJSON, `{"type":"Feature","id":3,"properties":{"name":"x"},"geometry":{"type":"Point","coordinates":[10,20]}}`)
+	require.Len(t, points, 1)
+	assert.Equal(t, &models.Point{
+		ID:         "3",
+		Location:   &models.Location{Lat: 20, Lon: 10},
+		Properties: map[string]any{"name": "x", IDTypeProperty: "number"},
+	}, points[0])
+
+	points = readAll(t, GeoJSON, `{"coordinates":[10,20],"type":"Point"}`)
+	require.Len(t, points, 1)
+	assert.Equal(t, "point_1", points[0].ID)
+	assert.Equal(t, 20.0, points[0].Location.Lat)
+
+	for _, input := range []string{
+		`{"type":"Feature","geometry":{"type":"LineString","coordinates":[[0,0],[1,1]]}}`,
+		`{"type":"Polygon","coordinates":[]}`,
+		`{"name":"no type"}`,
+	} {
+		r, err := NewReader(GeoJSON, strings.NewReader(input))
+		require.NoError(t, err)
+		_, err = r.Read()
+		assert.Error(t, err, input)
+	}
+}
+
+func TestNDJSON(t *testing.T) {
+	input := `{"id":"SF","location":{"lat":37.7749,"lon":-122.4194}}
+
+{"id":"LA","lat":34.0522,"lon":-118.2437,"alt":71}
+{"type":"Feature","id":"NYC","geometry":{"type":"Point","coordinates":[-74.006,40.7128,10]},"properties":{"borough":"Manhattan"}}
+`
+	points := readAll(t, NDJSON, input)
+	require.Len(t, points, 3)
+	assert.Equal(t, "SF", points[0].ID)
+	assert.Equal(t, 34.0522, points[1].Location.Lat)
+	assert.Equal(t, "NYC", points[2].ID)
+	assert.Equal(t, -74.006, points[2].Location.Lon)
+	assert.Equal(t, map[string]any{"borough": "Manhattan"}, points[2].Properties)
+	assert.Zero(t, points[0].Location.Alt)
+	assert.Equal(t, 71.0, points[1].Location.Alt)
+	assert.Equal(t, 10.0, points[2].Location.Alt)
+}
+
+func TestGPX(t *testing.T) {
+	input := `<?xml version="1.0"?>
+<gpx version="1.1" xmlns="http://www.topografix.com/GPX/1/1">
+  <wpt lat="37.7749" lon="-122.4194"><name>SF</name></wpt>
+  <trk><trkseg>
+    <trkpt lat="34.0522" lon="-118.2437"><ele>71</ele></trkpt>
+  </trkseg></trk>
+</gpx>`
+	points := readAll(t, GPX, input)
+	require.Len(t, points, 2)
+	assert.Equal(t, "SF", points[0].ID)
+	assert.Equal(t, "trkpt_2", points[1].ID)
+	assert.Equal(t, -118.2437, points[1].Location.Lon)
+	assert.Zero(t, points[0].Location.Alt)
+	assert.Equal(t, 71.0, points[1].Location.Alt)
+}
+
+// protobuf encoding helpers for building a minimal PBF fixture
+func pbVarint(buf *bytes.Buffer, num int, v uint64) {
+	buf.Write(binary.AppendUvarint(nil, uint64(num)<<3))
+	buf.Write(binary.AppendUvarint(nil, v))
+}
+
+func pbBytes(buf *bytes.Buffer, num int, data []byte) {
+	buf.Write(binary.AppendUvarint(nil, uint64(num)<<3|2))
+	buf.Write(binary.AppendUvarint(nil, uint64(len(data))))
+	buf.Write(data)
+}
+
+func pbPacked(deltas ...int64) []byte {
+	var out []byte
+	for _, d := range deltas {
+		out = binary.AppendUvarint(out, uint64(d<<1^(d>>63)))
+	}
+	return out
+}
+
+func TestOSMPBF(t *testing.T) {
+	// Two dense nodes, only the second tagged, at granularity 100
+	var dense bytes.Buffer
+	pbBytes(&dense, 1, pbPacked(10, 5))                 // ids 10, 15
+	pbBytes(&dense, 8, pbPacked(377749000, -10000000))  // lats 37.7749, 36.7749
+	pbBytes(&dense, 9, pbPacked(-1224194000, 10000000)) // lons -122.4194, -121.4194
+	pbBytes(&dense, 10, binary.AppendUvarint(binary.AppendUvarint(binary.AppendUvarint([]byte{0}, 1), 2), 0))
+
+	var group, block, strs bytes.Buffer
+	pbBytes(&group, 2, dense.Bytes())
+	pbBytes(&strs, 1, []byte{})
+	pbBytes(&strs, 1, []byte("amenity"))
+	pbBytes(&strs, 1, []byte("cafe"))
+	pbBytes(&block, 1, strs.Bytes())
+	pbBytes(&block, 2, group.Bytes())
+
+	var blob, header, file bytes.Buffer
+	pbBytes(&blob, 1, block.Bytes())
+	pbBytes(&header, 1, []byte("OSMData"))
+	pbVarint(&header, 3, uint64(blob.Len()))
+	require.NoError(t, binary.Write(&file, binary.BigEndian, uint32(header.Len())))
+	file.Write(header.Bytes())
+	file.Write(blob.Bytes())
+
+	points := readAll(t, OSMPBF, file.String())
+	require.Len(t, points, 1)
+	assert.Equal(t, "node/15", points[0].ID)
+	assert.Equal(t, map[string]any{"amenity": "cafe"}, points[0].Properties)
+	assert.InDelta(t, 36.7749, points[0].Location.Lat, 1e-9)
+	assert.InDelta(t, -121.4194, points[0].Location.Lon, 1e-9)
+}
+
+func TestOSMPBFHugeRawSize(t *testing.T) {
+	var blob, header, file bytes.Buffer
+	pbVarint(&blob, 2, 1<<62)
+	pbBytes(&blob, 3, []byte{0x78, 0x9c})
+	pbBytes(&header, 1, []byte("OSMData"))
+	pbVarint(&header, 3, uint64(blob.Len()))
+	require.NoError(t, binary.Write(&file, binary.BigEndian, uint32(header.Len())))
+	file.Write(header.Bytes())
+	file.Write(blob.Bytes())
+
+	r, err := NewReader(OSMPBF, strings.NewReader(file.String()))
+	require.NoError(t, err)
+	err = ReadBatches(r, 2, func([]*models.Point) error { return nil })
+	assert.ErrorIs(t, err, errMalformedPBF)
+}
+
+func TestOSMPBFBlobSize(t *testing.T) {
+	compressedBlob := func(data []byte, rawSize uint64) []byte {
+		var z, blob bytes.Buffer
+		zw := zlib.NewWriter(&z)
+		zw.Write(data)
+		zw.Close()
+		pbVarint(&blob, 2, rawSize)
+		pbBytes(&blob, 3, z.Bytes())
+		return blob.Bytes()
+	}
+	data := bytes.Repeat([]byte("node"), 100)
+
+	out, err := decodeBlob(compressedBlob(data, uint64(len(data))))
+	require.NoError(t, err)
+	assert.Equal(t, data, out)
+
+	// A header understating or overstating the size is malformed, not truncated
+	_, err = decodeBlob(compressedBlob(data, uint64(len(data)-1)))
+	assert.ErrorIs(t, err, errMalformedPBF)
+	_, err = decodeBlob(compressedBlob(data, uint64(len(data)+1)))
+	assert.ErrorIs(t, err, errMalformedPBF)
+
+	// Streams past the blob limit are rejected whatever the header says
+	_, err = decodeBlob(compressedBlob(make([]byte, maxBlobSize+1), 1))
+	assert.ErrorIs(t, err, errMalformedPBF)
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	points := []*models.Point{
+		{ID: "SF", Location: &models.Location{Lat: 37.7749, Lon: -122.4194}},
+		{ID: "LA", Location: &models.Location{Lat: 34.0522, Lon: -118.2437}},
+	}
+
+	for _, format := range []Format{GeoJSON, CSV, NDJSON, FlatGeobuf, Parquet} {
+		var buf bytes.Buffer
+		w, err := NewWriter(format, &buf)
+		require.NoError(t, err)
+		for _, p := range points {
+			require.NoError(t, w.Write(p))
+		}
+		require.NoError(t, w.Close())
+
+		got := readAll(t, format, buf.String())
+		assert.Equal(t, points, got, format)
+	}
+
+	// An empty export is still a valid document
+	var buf bytes.Buffer
+	w, _ := NewWriter(GeoJSON, &buf)
+	require.NoError(t, w.Close())
+	assert.Empty(t, readAll(t, GeoJSON, buf.String()))
+
+	_, err := NewWriter(GPX, &buf)
+	assert.Error(t, err)
+}
+
+func TestWriterAltitude(t *testing.T) {
+	points := []*models.Point{
+		{ID: "SF", Location: &models.Location{Lat: 37.7749, Lon: -122.4194}},
+		{ID: "drone", Location: &models.Location{Lat: 34.0522, Lon: -118.2437, Alt: 120.5}},
+		{ID: "LA", Location: &models.Location{Lat: 34.0522, Lon: -118.2437}},
+	}
+
+	for _, format := range []Format{GeoJSON, CSV, NDJSON, Parquet} {
+		var buf bytes.Buffer
+		w, err := NewWriter(format, &buf)
+		require.NoError(t, err)
+		for _, p := range points {
+			require.NoError(t, w.Write(p))
+		}
+		require.NoError(t, w.Close())
+
+		got := readAll(t, format, buf.String())
+		assert.Equal(t, points, got, format)
+	}
+
+	// CSV only gains the alt column when a point has an altitude
+	var buf bytes.Buffer
+	w, _ := NewWriter(CSV, &buf)
+	for _, p := range points {
+		require.NoError(t, w.Write(p))
+	}
+	require.NoError(t, w.Close())
+	assert.Equal(t, "id,lat,lon,alt\nSF,37.7749,-122.4194,\ndrone,34.0522,-118.2437,120.5\nLA,34.0522,-118.2437,\n", buf.String())
+
+	buf.Reset()
+	w, _ = NewWriter(CSV, &buf)
+	require.NoError(t, w.Write(points[0]))
+	require.NoError(t, w.Close())
+	assert.Equal(t, "id,lat,lon\nSF,37.7749,-122.4194\n", buf.String())
+}
+
+func TestWriterProperties(t *testing.T) {
+	points := []*models.Point{
+		{ID: "SF", Location: &models.Location{Lat: 37.7749, Lon: -122.4194}, Properties: map[string]any{
+			"name": "San Francisco", "pop": 815201.0, "capital": false, "tags": []any{"city", "coast"},
+		}},
+		{ID: "LA", Location: &models.Location{Lat: 34.0522, Lon: -118.2437}},
+	}
+
+	for _, format := range []Format{GeoJSON, NDJSON, FlatGeobuf, Parquet} {
+		var buf bytes.Buffer
+		w, err := NewWriter(format, &buf)
+		require.NoError(t, err)
+		for _, p := range points {
+			require.NoError(t, w.Write(p))
+		}
+		require.NoError(t, w.Close())
+
+		got := readAll(t, format, buf.String())
+		assert.Equal(t, points, got, format)
+	}
+}
+
+func TestFlatGeobuf(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(FlatGeobuf, &buf)
+	require.NoError(t, err)
+	require.NoError(t, w.Write(&models.Point{ID: "SF", Location: &models.Location{Lat: 37.7749, Lon: -122.4194}}))
+	require.NoError(t, w.Close())
+	assert.Equal(t, []byte("fgb\x03fgb\x00"), buf.Bytes()[:8])
+
+	// Header carries the count, the extent and an explicit "no index"
+	r := newFGBReader(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, r.readHeader())
+	assert.Equal(t, uint8(fgbGeometryPoint), r.geometryType)
+	assert.Equal(t, []fgbColumn{{name: "id", typ: fgbColumnString}}, r.columns)
+
+	// Patch version bumps are accepted, other magic is not
+	data := append([]byte(nil), buf.Bytes()...)
+	data[7] = 1
+	assert.Len(t, readAll(t, FlatGeobuf, string(data)), 1)
+	_, err = newFGBReader(strings.NewReader("fgb\x02fgb\x00")).Read()
+	assert.Error(t, err)
+
+	// Truncated features are reported rather than silently dropped
+	_, err = newFGBReader(bytes.NewReader(buf.Bytes()[:buf.Len()-3])).Read()
+	assert.Error(t, err)
+
+	// Column types are inferred from the property values
+	columns := fgbInferColumns([]models.Point{
+		{Properties: map[string]any{"id": "x", "n": 1, "f": 1.5, "ok": true, "mixed": "a"}},
+		{Properties: map[string]any{"n": int64(2), "f": 2, "mixed": 3.0, "nil": nil}},
+	})
+	assert.Equal(t, []fgbColumn{
+		{name: "id", typ: fgbColumnString},
+		{name: "f", typ: fgbColumnDouble},
+		{name: "mixed", typ: fgbColumnJSON},
+		{name: "n", typ: fgbColumnLong},
+		{name: "ok", typ: fgbColumnBool},
+	}, columns)
+
+	assert.Equal(t, uint64(0), fgbIndexSize(0, 16))
+	assert.Equal(t, uint64(40), fgbIndexSize(1, 16))
+	assert.Equal(t, uint64((100+7+1)*40), fgbIndexSize(100, 16))
+}
+
+// parquetPageBytes returns a page header for body, stored compressed as given
+func parquetPageBytes(typ int32, header thriftField, body, stored []byte) []byte {
+	page := appendThriftStruct(nil, thriftFields{
+		{pqPageType, typ},
+		{pqPageUncompressedSize, int32(len(body))},
+		{pqPageCompressedSize, int32(len(stored))},
+		header,
+	})
+	return append(page, stored...)
+}
+
+// snappyLiteral stores data as one Snappy literal
+func snappyLiteral(data []byte) []byte {
+	out := binary.AppendUvarint(nil, uint64(len(data)))
+	out = append(out, byte(len(data)-1)<<2)
+	return append(out, data...)
+}
+
+func TestParquetReader(t *testing.T) {
+	// city: optional UTF8, a dictionary page and a dictionary encoded v1 page,
+	// Snappy compressed. Definition levels 1,0,1 and indexes 1,0 are bit-packed.
+	dict := []byte{1, 0, 0, 0, 'a', 1, 0, 0, 0, 'b'}
+	dictPage := parquetPageBytes(parquetDictionaryPage,
+		thriftField{pqPageDictionaryHeader, thriftFields{{1, int32(2)}, {2, int32(parquetPlain)}}},
+		dict, snappyLiteral(dict))
+	names := []byte{2, 0, 0, 0, 3, 0b101, 1, 3, 0b01}
+	namePage := parquetPageBytes(parquetDataPage,
+		thriftField{pqPageDataHeader, thriftFields{{1, int32(3)}, {2, int32(parquetRLEDictionary)}, {3, int32(parquetRLE)}, {4, int32(parquetRLE)}}},
+		names, snappyLiteral(names))
+
+	// geometry: WKB in a gzip compressed v2 page; a little endian point, a big
+	// endian EWKB point with Z and an empty point
+	wkb := func(order binary.AppendByteOrder, typ uint32, coords ...float64) []byte {
+		b := []byte{1}
+		if order == binary.BigEndian {
+			b[0] = 0
+		}
+		b = order.AppendUint32(b, typ)
+		for _, c := range coords {
+			b = order.AppendUint64(b, math.Float64bits(c))
+		}
+		return append(binary.LittleEndian.AppendUint32(nil, uint32(len(b))), b...)
+	}
+	var geoms []byte
+	for _, g := range [][]byte{
+		wkb(binary.LittleEndian, 1, 1, 2),
+		wkb(binary.BigEndian, 0x80000001, 3, 4, 5),
+		wkb(binary.LittleEndian, 1, math.NaN(), math.NaN()),
+	} {
+		geoms = append(geoms, g...)
+	}
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(geoms)
+	zw.Close()
+	geomPage := parquetPageBytes(parquetDataPageV2,
+		thriftField{pqPageDataHeaderV2, thriftFields{{1, int32(3)}, {2, int32(0)}, {3, int32(3)}, {4, int32(parquetPlain)}, {5, int32(0)}, {6, int32(0)}}},
+		geoms, gz.Bytes())
+
+	// score: required INT32, plain and uncompressed
+	scores := []byte{10, 0, 0, 0, 20, 0, 0, 0, 30, 0, 0, 0}
+	scorePage := parquetPageBytes(parquetDataPage,
+		thriftField{pqPageDataHeader, thriftFields{{1, int32(3)}, {2, int32(parquetPlain)}, {3, int32(parquetRLE)}, {4, int32(parquetRLE)}}},
+		scores, scores)
+
+	file := append([]byte(nil), parquetMagic...)
+	var chunks []any
+	for _, c := range []struct {
+		typ     int32
+		codec   int32
+		dict    []byte
+		data    []byte
+		hasDict bool
+	}{
+		{parquetByteArray, parquetSnappy, dictPage, namePage, true},
+		{parquetByteArray, parquetGzip, nil, geomPage, false},
+		{parquetInt32, parquetUncompressed, nil, scorePage, false},
+	} {
+		meta := thriftFields{
+			{1, c.typ},
+			{pqMetaCodec, c.codec},
+			{pqMetaNumValues, int64(3)},
+			{pqMetaTotalCompressedSize, int64(len(c.dict) + len(c.data))},
+			{pqMetaDataPageOffset, int64(len(file) + len(c.dict))},
+		}
+		if c.hasDict {
+			meta = append(meta, thriftField{pqMetaDictionaryPageOffset, int64(len(file))})
+		}
+		chunks = append(chunks, thriftFields{{2, int64(len(file))}, {pqChunkMetaData, meta}})
+		file = append(file, c.dict...)
+		file = append(file, c.data...)
+	}
+	footer := appendThriftStruct(nil, thriftFields{
+		{1, int32(1)},
+		{pqFileSchema, thriftListOf{thriftStruct, []any{
+			thriftFields{{pqSchemaName, "schema"}, {pqSchemaNumChildren, int32(3)}},
+			thriftFields{{pqSchemaType, int32(parquetByteArray)}, {pqSchemaRepetition, int32(parquetOptional)}, {pqSchemaName, "city"}, {pqSchemaConverted, int32(parquetUTF8)}},
+			thriftFields{{pqSchemaType, int32(parquetByteArray)}, {pqSchemaRepetition, int32(parquetRequired)}, {pqSchemaName, "geometry"}},
+			thriftFields{{pqSchemaType, int32(parquetInt32)}, {pqSchemaRepetition, int32(parquetRequired)}, {pqSchemaName, "score"}},
+		}}},
+		{pqFileNumRows, int64(3)},
+		{pqFileRowGroups, thriftListOf{thriftStruct, []any{thriftFields{
+			{pqRowGroupColumns, thriftListOf{thriftStruct, chunks}},
+			{2, int64(len(file))},
+			{pqRowGroupNumRows, int64(3)},
+		}}}},
+	})
+	file = append(file, footer...)
+	file = binary.LittleEndian.AppendUint32(file, uint32(len(footer)))
+	file = append(file, parquetMagic...)
+
+	points := readAll(t, Parquet, string(file))
+	assert.Equal(t, []*models.Point{
+		{ID: "point_1", Location: &models.Location{Lat: 2, Lon: 1}, Properties: map[string]any{"city": "b", "score": int64(10)}},
+		{ID: "point_2", Location: &models.Location{Lat: 4, Lon: 3, Alt: 5}, Properties: map[string]any{"score": int64(20)}},
+	}, points)
+
+	// Truncated files and corrupt footers are reported
+	_, err := newParquetReader(bytes.NewReader(file[:len(file)-3])).Read()
+	assert.Error(t, err)
+	corrupt := append([]byte(nil), file...)
+	binary.LittleEndian.PutUint32(corrupt[len(corrupt)-8:], uint32(len(corrupt)))
+	_, err = newParquetReader(bytes.NewReader(corrupt)).Read()
+	assert.ErrorIs(t, err, errMalformedParquet)
+}
+
+func TestParquetWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(Parquet, &buf)
+	require.NoError(t, err)
+	points := []*models.Point{
+		{ID: "a", Location: &models.Location{Lat: 1, Lon: 2, Alt: 30}, Properties: map[string]any{"n": 1, "lat": "dropped"}},
+		{ID: "b", Location: &models.Location{Lat: 3, Lon: 4}},
+	}
+	for _, p := range points {
+		require.NoError(t, w.Write(p))
+	}
+	require.NoError(t, w.Close())
+	assert.Equal(t, parquetMagic, buf.Bytes()[:4])
+
+	// Readers that do not seek get the whole input buffered
+	r, err := NewReader(Parquet, io.MultiReader(bytes.NewReader(buf.Bytes())))
+	require.NoError(t, err)
+	var got []*models.Point
+	require.NoError(t, ReadBatches(r, 10, func(batch []*models.Point) error {
+		got = append(got, batch...)
+		return nil
+	}))
+	assert.Equal(t, []*models.Point{
+		{ID: "a", Location: &models.Location{Lat: 1, Lon: 2, Alt: 30}, Properties: map[string]any{"n": int64(1)}},
+		{ID: "b", Location: &models.Location{Lat: 3, Lon: 4}},
+	}, got)
+
+	// An empty table is still a valid file
+	buf.Reset()
+	w, _ = NewWriter(Parquet, &buf)
+	require.NoError(t, w.Close())
+	assert.Empty(t, readAll(t, Parquet, buf.String()))
+}
+
+func TestSnappyDecode(t *testing.T) {
+	// A literal "abc" then a copy of 6 bytes from 3 back
+	out, err := snappyDecode([]byte{9, 2 << 2, 'a', 'b', 'c', 1 | 2<<2, 3}, 9)
+	require.NoError(t, err)
+	assert.Equal(t, "abcabcabc", string(out))
+
+	_, err = snappyDecode([]byte{9, 2 << 2, 'a', 'b', 'c', 1 | 2<<2, 4}, 9)
+	assert.Error(t, err)
+	_, err = snappyDecode([]byte{9, 2 << 2, 'a', 'b', 'c'}, 9)
+	assert.Error(t, err)
+}
@@ -0,0 +1,271 @@
+package formats
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+)
+
+type geoJSONGeometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+// location converts a Point geometry ([lon, lat] or [lon, lat, alt]) to a
+// models.Location
+func (g *geoJSONGeometry) location() (*models.Location, error) {
+	if g.Type != "Point" {
+		return nil, fmt.Errorf("unsupported geometry type %q", g.Type)
+	}
+	var coords []float64
+	if err := json.Unmarshal(g.Coordinates, &coords); err != nil || len(coords) < 2 {
+		return nil, fmt.Errorf("point geometry needs [lon, lat] coordinates")
+	}
+	loc := &models.Location{Lat: coords[1], Lon: coords[0]}
+	if len(coords) > 2 {
+		loc.Alt = coords[2]
+	}
+	return loc, nil
+}
+
+type geoJSONFeature struct {
+	ID         json.RawMessage  `json:"id"`
+	Geometry   *geoJSONGeometry `json:"geometry"`
+	Properties map[string]any   `json:"properties"`
+}
+
+// IDTypeProperty is the reserved property recording that a point's ID was a
+// JSON number in its source. The GeoJSON reader sets it to "number" and the
+// GeoJSON writer consumes it, so numeric and string IDs survive a round trip;
+// points without it are written with string IDs.
+const IDTypeProperty = "_id_type"
+
+// idTypeNumber is the IDTypeProperty value of numeric IDs
+const idTypeNumber = "number"
+
+// geoJSONReader streams Point features out of a FeatureCollection without
+// decoding the whole document. A document holding a single Feature or a bare
+// Point geometry is read as one point. Features with other geometry types are
+// skipped. Feature IDs, numeric or string, and properties are kept on the
+// point, with numeric IDs in their JSON text and marked by IDTypeProperty.
+type geoJSONReader struct {
+	dec     *json.Decoder
+	started bool
+	n       int
+	// single holds the point of a document that is not a FeatureCollection
+	single *models.Point
+}
+
+func newGeoJSONReader(r io.Reader) *geoJSONReader {
+	return &geoJSONReader{dec: json.NewDecoder(r)}
+}
+
+func (g *geoJSONReader) Read() (*models.Point, error) {
+	if !g.started {
+		g.started = true
+		if err := g.seekFeatures(); err != nil {
+			return nil, err
+		}
+		if g.single != nil {
+			return g.single, nil
+		}
+	}
+	if g.single != nil {
+		return nil, io.EOF
+	}
+
+	for g.dec.More() {
+		var feature geoJSONFeature
+		if err := g.dec.Decode(&feature); err != nil {
+			return nil, fmt.Errorf("failed to decode feature %d: %w", g.n+1, err)
+		}
+		g.n++
+
+		point, err := feature.point(g.n)
+		if err != nil {
+			return nil, fmt.Errorf("feature %d: %w", g.n, err)
+		}
+		if point != nil {
+			return point, nil
+		}
+	}
+	return nil, io.EOF
+}
+
+// point converts the nth feature of a document to a point, or returns nil for
+// features without a Point geometry. Features without an ID fall back to an
+// "id" property, then to point_n.
+func (f *geoJSONFeature) point(n int) (*models.Point, error) {
+	if f.Geometry == nil || f.Geometry.Type != "Point" {
+		return nil, nil
+	}
+	loc, err := f.Geometry.location()
+	if err != nil {
+		return nil, err
+	}
+
+	id, numeric := rawID(f.ID)
+	if id == "" {
+		id, numeric = propertyID(f.Properties["id"])
+	}
+	if id == "" {
+		id = fmt.Sprintf("point_%d", n)
+	}
+	point := &models.Point{ID: id, Location: loc}
+	if len(f.Properties) > 0 {
+		point.Properties = f.Properties
+	}
+	if numeric {
+		if point.Properties == nil {
+			point.Properties = make(map[string]any, 1)
+		}
+		point.Properties[IDTypeProperty] = idTypeNumber
+	}
+	return point, nil
+}
+
+// seekFeatures advances the decoder to the first element of the "features"
+// array. A document without one is decoded whole and, if it is a Feature or a
+// Point geometry, its point is kept in g.single.
+func (g *geoJSONReader) seekFeatures() error {
+	tok, err := g.dec.Token()
+	if err != nil {
+		return fmt.Errorf("failed to read geojson: %w", err)
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return fmt.Errorf("geojson must be a FeatureCollection, Feature or Point object")
+	}
+
+	members := make(map[string]json.RawMessage)
+	for g.dec.More() {
+		tok, err := g.dec.Token()
+		if err != nil {
+			return fmt.Errorf("failed to read geojson: %w", err)
+		}
+		key, _ := tok.(string)
+		if key == "features" {
+			tok, err := g.dec.Token()
+			if err != nil {
+				return fmt.Errorf("failed to read geojson: %w", err)
+			}
+			if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+				return fmt.Errorf("geojson features must be an array")
+			}
+			return nil
+		}
+
+		// Keep the value of any other member in case this is not a collection
+		var value json.RawMessage
+		if err := g.dec.Decode(&value); err != nil {
+			return fmt.Errorf("failed to read geojson: %w", err)
+		}
+		members[key] = value
+	}
+	return g.decodeSingle(members)
+}
+
+// decodeSingle reads the point of a Feature or Point document from its members
+func (g *geoJSONReader) decodeSingle(members map[string]json.RawMessage) error {
+	var typ string
+	if err := json.Unmarshal(members["type"], &typ); err != nil || typ == "" {
+		return fmt.Errorf("geojson has no features array")
+	}
+	data, err := json.Marshal(members)
+	if err != nil {
+		return fmt.Errorf("failed to read geojson: %w", err)
+	}
+
+	var feature geoJSONFeature
+	switch typ {
+	case "Feature":
+		if err := json.Unmarshal(data, &feature); err != nil {
+			return fmt.Errorf("failed to decode feature: %w", err)
+		}
+	case "Point":
+		feature.Geometry = &geoJSONGeometry{}
+		if err := json.Unmarshal(data, feature.Geometry); err != nil {
+			return fmt.Errorf("failed to decode geometry: %w", err)
+		}
+	default:
+		return fmt.Errorf("unsupported geojson type %q", typ)
+	}
+
+	point, err := feature.point(1)
+	if err != nil {
+		return fmt.Errorf("feature 1: %w", err)
+	}
+	if point == nil {
+		return fmt.Errorf("geojson feature has no Point geometry")
+	}
+	g.single = point
+	return nil
+}
+
+// propertyID renders a decoded string or number property as an ID and
+// reports whether it was a number
+func propertyID(v any) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, false
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	}
+	return "", false
+}
+
+// rawID renders a JSON string or number ID as a string and reports whether it
+// was a number. Numbers keep their JSON text, so large integers are not
+// rounded.
+func rawID(raw json.RawMessage) (string, bool) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", false
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, false
+	}
+	return strings.TrimSpace(string(raw)), true
+}
+
+// featureID is a feature ID as written to GeoJSON: a JSON number if it was
+// read from one, otherwise a string
+type featureID struct {
+	id      string
+	numeric bool
+}
+
+// outputID returns the GeoJSON ID of point and its properties without
+// IDTypeProperty
+func outputID(point *models.Point, properties map[string]any) (featureID, map[string]any) {
+	kind, ok := properties[IDTypeProperty]
+	if !ok {
+		return featureID{id: point.ID}, properties
+	}
+	rest := make(map[string]any, len(properties)-1)
+	for k, v := range properties {
+		if k != IDTypeProperty {
+			rest[k] = v
+		}
+	}
+	return featureID{id: point.ID, numeric: kind == idTypeNumber && isNumber(point.ID)}, rest
+}
+
+func (id featureID) MarshalJSON() ([]byte, error) {
+	if id.numeric {
+		return []byte(id.id), nil
+	}
+	return json.Marshal(id.id)
+}
+
+// isNumber reports whether s is a single JSON number
+func isNumber(s string) bool {
+	if s == "" || s[0] != '-' && (s[0] < '0' || s[0] > '9') {
+		return false
+	}
+	var n json.Number
+	return json.Unmarshal([]byte(s), &n) == nil
+}
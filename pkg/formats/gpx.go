@@ -0,0 +1,61 @@
+package formats
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+)
+
+type gpxPoint struct {
+	Lat  float64 `xml:"lat,attr"`
+	Lon  float64 `xml:"lon,attr"`
+	Ele  float64 `xml:"ele"`
+	Name string  `xml:"name"`
+}
+
+// gpxReader streams waypoints, track points and route points from a GPX
+// document, with <ele> elevations in meters as altitudes
+type gpxReader struct {
+	dec *xml.Decoder
+	n   int
+}
+
+func newGPXReader(r io.Reader) *gpxReader {
+	return &gpxReader{dec: xml.NewDecoder(r)}
+}
+
+func (g *gpxReader) Read() (*models.Point, error) {
+	for {
+		tok, err := g.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "wpt", "trkpt", "rtept":
+		default:
+			continue
+		}
+
+		var p gpxPoint
+		if err := g.dec.DecodeElement(&p, &start); err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", start.Name.Local, err)
+		}
+		g.n++
+
+		id := p.Name
+		if id == "" {
+			id = fmt.Sprintf("%s_%d", start.Name.Local, g.n)
+		}
+		return &models.Point{
+			ID:       id,
+			Location: &models.Location{Lat: p.Lat, Lon: p.Lon, Alt: p.Ele},
+		}, nil
+	}
+}
@@ -0,0 +1,91 @@
+package formats
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+)
+
+// ndjsonRecord accepts both the models.Point layout
+// ({"id":..,"location":{"lat":..,"lon":..}}) and flat {"id":..,"lat":..,"lon":..}
+// lines, as well as GeoJSON Point features. Altitudes are read from "alt" in
+// meters, or a feature's third coordinate. Any "properties" object is kept.
+type ndjsonRecord struct {
+	ID         json.RawMessage  `json:"id"`
+	Location   *models.Location `json:"location"`
+	Lat        *float64         `json:"lat"`
+	Lon        *float64         `json:"lon"`
+	Alt        float64          `json:"alt"`
+	Type       string           `json:"type"`
+	Geometry   *geoJSONGeometry `json:"geometry"`
+	Properties map[string]any   `json:"properties"`
+}
+
+// ndjsonReader reads one point per line
+type ndjsonReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func newNDJSONReader(r io.Reader) *ndjsonReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return &ndjsonReader{scanner: scanner}
+}
+
+func (n *ndjsonReader) Read() (*models.Point, error) {
+	for n.scanner.Scan() {
+		n.line++
+		line := bytes.TrimSpace(n.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		point, err := ParseNDJSONLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("ndjson line %d: %w", n.line, err)
+		}
+		if point.ID == "" {
+			point.ID = fmt.Sprintf("point_%d", n.line)
+		}
+		return point, nil
+	}
+
+	if err := n.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// ParseNDJSONLine decodes a single NDJSON point record
+func ParseNDJSONLine(line []byte) (*models.Point, error) {
+	var rec ndjsonRecord
+	if err := json.Unmarshal(line, &rec); err != nil {
+		return nil, err
+	}
+
+	id, _ := rawID(rec.ID)
+	point := &models.Point{ID: id}
+	if len(rec.Properties) > 0 {
+		point.Properties = rec.Properties
+	}
+	switch {
+	case rec.Location != nil:
+		point.Location = rec.Location
+	case rec.Lat != nil && rec.Lon != nil:
+		point.Location = &models.Location{Lat: *rec.Lat, Lon: *rec.Lon, Alt: rec.Alt}
+	case rec.Type == "Feature" && rec.Geometry != nil:
+		loc, err := rec.Geometry.location()
+		if err != nil {
+			return nil, err
+		}
+		point.Location = loc
+	default:
+		return nil, fmt.Errorf("record has no location")
+	}
+	return point, nil
+}
@@ -0,0 +1,396 @@
+package formats
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+)
+
+// OSM PBF files are a sequence of (BlobHeader, Blob) pairs, where OSMData blobs
+// hold PrimitiveBlocks of nodes, ways and relations. Only nodes carry
+// coordinates, so ways and relations are skipped. Untagged nodes are almost
+// always way vertices rather than points of interest and are skipped as well.
+// Node tags become point properties.
+// The decoder below reads just the protobuf fields it needs, which keeps the
+// package free of generated code.
+
+const (
+	maxBlobHeaderSize = 64 * 1024
+	maxBlobSize       = 32 * 1024 * 1024
+)
+
+var errMalformedPBF = errors.New("malformed osm pbf data")
+
+// pbfReader streams tagged nodes from an OSM PBF file
+type pbfReader struct {
+	r       io.Reader
+	pending []*models.Point
+}
+
+func newPBFReader(r io.Reader) *pbfReader {
+	return &pbfReader{r: r}
+}
+
+func (p *pbfReader) Read() (*models.Point, error) {
+	for len(p.pending) == 0 {
+		if err := p.readBlock(); err != nil {
+			return nil, err
+		}
+	}
+	point := p.pending[0]
+	p.pending[0] = nil
+	p.pending = p.pending[1:]
+	return point, nil
+}
+
+// readBlock reads the next blob and queues the nodes it contains
+func (p *pbfReader) readBlock() error {
+	var size uint32
+	if err := binary.Read(p.r, binary.BigEndian, &size); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return errMalformedPBF
+		}
+		return err
+	}
+	if size > maxBlobHeaderSize {
+		return fmt.Errorf("%w: blob header of %d bytes", errMalformedPBF, size)
+	}
+
+	header := make([]byte, size)
+	if _, err := io.ReadFull(p.r, header); err != nil {
+		return fmt.Errorf("failed to read blob header: %w", err)
+	}
+
+	var blobType string
+	var dataSize uint64
+	err := walkFields(header, func(num int, v uint64, data []byte) error {
+		switch num {
+		case 1:
+			blobType = string(data)
+		case 3:
+			dataSize = v
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if dataSize > maxBlobSize {
+		return fmt.Errorf("%w: blob of %d bytes", errMalformedPBF, dataSize)
+	}
+
+	blob := make([]byte, dataSize)
+	if _, err := io.ReadFull(p.r, blob); err != nil {
+		return fmt.Errorf("failed to read blob: %w", err)
+	}
+	if blobType != "OSMData" {
+		return nil
+	}
+
+	block, err := decodeBlob(blob)
+	if err != nil {
+		return err
+	}
+	return p.decodePrimitiveBlock(block)
+}
+
+// decodeBlob returns the uncompressed payload of a Blob message
+func decodeBlob(blob []byte) ([]byte, error) {
+	var raw, compressed []byte
+	var rawSize uint64
+	err := walkFields(blob, func(num int, v uint64, data []byte) error {
+		switch num {
+		case 1:
+			raw = data
+		case 2:
+			rawSize = v
+		case 3:
+			compressed = data
+		case 4, 5, 6, 7:
+			return fmt.Errorf("unsupported osm pbf compression (field %d)", num)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if raw != nil {
+		return raw, nil
+	}
+	if compressed == nil {
+		return nil, fmt.Errorf("%w: empty blob", errMalformedPBF)
+	}
+	if rawSize > maxBlobSize {
+		return nil, fmt.Errorf("%w: blob of %d bytes uncompressed", errMalformedPBF, rawSize)
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress blob: %w", err)
+	}
+	defer zr.Close()
+
+	// Read one byte past the limit so oversized streams are caught rather
+	// than cut off, and hold the stream to the size its header declares
+	out := bytes.NewBuffer(make([]byte, 0, rawSize))
+	if _, err := io.Copy(out, io.LimitReader(zr, maxBlobSize+1)); err != nil {
+		return nil, fmt.Errorf("failed to decompress blob: %w", err)
+	}
+	if out.Len() > maxBlobSize || uint64(out.Len()) != rawSize {
+		return nil, fmt.Errorf("%w: blob decompressed to %d bytes, header says %d", errMalformedPBF, out.Len(), rawSize)
+	}
+	return out.Bytes(), nil
+}
+
+// primitiveBlock holds the coordinate transform shared by all groups in a block
+type primitiveBlock struct {
+	strings     [][]byte
+	granularity int64
+	latOffset   int64
+	lonOffset   int64
+}
+
+func (b *primitiveBlock) location(lat, lon int64) *models.Location {
+	return &models.Location{
+		Lat: 1e-9 * float64(b.latOffset+b.granularity*lat),
+		Lon: 1e-9 * float64(b.lonOffset+b.granularity*lon),
+	}
+}
+
+// tag returns the key and value at the given string table indexes
+func (b *primitiveBlock) tag(key, val uint64) (string, string, error) {
+	if key >= uint64(len(b.strings)) || val >= uint64(len(b.strings)) {
+		return "", "", fmt.Errorf("%w: string index out of range", errMalformedPBF)
+	}
+	return string(b.strings[key]), string(b.strings[val]), nil
+}
+
+func (p *pbfReader) decodePrimitiveBlock(data []byte) error {
+	block := &primitiveBlock{granularity: 100}
+	var groups [][]byte
+	err := walkFields(data, func(num int, v uint64, field []byte) error {
+		switch num {
+		case 1:
+			return walkFields(field, func(num int, _ uint64, s []byte) error {
+				if num == 1 {
+					block.strings = append(block.strings, s)
+				}
+				return nil
+			})
+		case 2:
+			groups = append(groups, field)
+		case 17:
+			block.granularity = int64(v)
+		case 19:
+			block.latOffset = int64(v)
+		case 20:
+			block.lonOffset = int64(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, group := range groups {
+		err := walkFields(group, func(num int, _ uint64, field []byte) error {
+			switch num {
+			case 1:
+				return p.decodeNode(block, field)
+			case 2:
+				return p.decodeDenseNodes(block, field)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *pbfReader) decodeNode(block *primitiveBlock, data []byte) error {
+	var id, lat, lon int64
+	var keys, vals []uint64
+	err := walkFields(data, func(num int, v uint64, field []byte) error {
+		var err error
+		switch num {
+		case 1:
+			id = zigzag(v)
+		case 2:
+			keys, err = packedVarints(field)
+		case 3:
+			vals, err = packedVarints(field)
+		case 8:
+			lat = zigzag(v)
+		case 9:
+			lon = zigzag(v)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	if len(vals) != len(keys) {
+		return fmt.Errorf("%w: node keys and vals differ in length", errMalformedPBF)
+	}
+
+	props := make(map[string]any, len(keys))
+	for i := range keys {
+		k, v, err := block.tag(keys[i], vals[i])
+		if err != nil {
+			return err
+		}
+		props[k] = v
+	}
+	p.pending = append(p.pending, &models.Point{
+		ID:         fmt.Sprintf("node/%d", id),
+		Location:   block.location(lat, lon),
+		Properties: props,
+	})
+	return nil
+}
+
+func (p *pbfReader) decodeDenseNodes(block *primitiveBlock, data []byte) error {
+	var ids, lats, lons []int64
+	var keysVals []uint64
+	err := walkFields(data, func(num int, _ uint64, field []byte) error {
+		var err error
+		switch num {
+		case 1:
+			ids, err = packedDeltas(field)
+		case 8:
+			lats, err = packedDeltas(field)
+		case 9:
+			lons, err = packedDeltas(field)
+		case 10:
+			keysVals, err = packedVarints(field)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if len(lats) != len(ids) || len(lons) != len(ids) {
+		return fmt.Errorf("%w: dense node arrays differ in length", errMalformedPBF)
+	}
+
+	// keys_vals is a flat list of key/value string indexes, with each node's
+	// tags terminated by a 0. It is empty when no node in the block has tags.
+	kv := 0
+	for i := range ids {
+		var props map[string]any
+		for kv+1 < len(keysVals) && keysVals[kv] != 0 {
+			k, v, err := block.tag(keysVals[kv], keysVals[kv+1])
+			if err != nil {
+				return err
+			}
+			if props == nil {
+				props = make(map[string]any)
+			}
+			props[k] = v
+			kv += 2
+		}
+		kv++
+
+		if props != nil {
+			p.pending = append(p.pending, &models.Point{
+				ID:         fmt.Sprintf("node/%d", ids[i]),
+				Location:   block.location(lats[i], lons[i]),
+				Properties: props,
+			})
+		}
+	}
+	return nil
+}
+
+// walkFields calls fn for every field in a protobuf message. Varint and fixed
+// fields are passed as v, length-delimited fields as data.
+func walkFields(msg []byte, fn func(num int, v uint64, data []byte) error) error {
+	for len(msg) > 0 {
+		key, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return errMalformedPBF
+		}
+		msg = msg[n:]
+
+		num := int(key >> 3)
+		var v uint64
+		var data []byte
+		switch key & 7 {
+		case 0:
+			v, n = binary.Uvarint(msg)
+			if n <= 0 {
+				return errMalformedPBF
+			}
+			msg = msg[n:]
+		case 1:
+			if len(msg) < 8 {
+				return errMalformedPBF
+			}
+			v = binary.LittleEndian.Uint64(msg)
+			msg = msg[8:]
+		case 2:
+			size, n := binary.Uvarint(msg)
+			if n <= 0 || size > uint64(len(msg)-n) {
+				return errMalformedPBF
+			}
+			data = msg[n : n+int(size)]
+			msg = msg[n+int(size):]
+		case 5:
+			if len(msg) < 4 {
+				return errMalformedPBF
+			}
+			v = uint64(binary.LittleEndian.Uint32(msg))
+			msg = msg[4:]
+		default:
+			return fmt.Errorf("%w: unsupported wire type %d", errMalformedPBF, key&7)
+		}
+
+		if err := fn(num, v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func packedVarints(data []byte) ([]uint64, error) {
+	var values []uint64
+	for len(data) > 0 {
+		v, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, errMalformedPBF
+		}
+		values = append(values, v)
+		data = data[n:]
+	}
+	return values, nil
+}
+
+// packedDeltas decodes a packed, delta-coded sint64 array
+func packedDeltas(data []byte) ([]int64, error) {
+	raw, err := packedVarints(data)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]int64, len(raw))
+	var acc int64
+	for i, v := range raw {
+		acc += zigzag(v)
+		values[i] = acc
+	}
+	return values, nil
+}
+
+func zigzag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
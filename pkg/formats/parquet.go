@@ -0,0 +1,1360 @@
+package formats
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+)
+
+// Parquet files are the magic "PAR1", row groups of column chunks made of
+// pages, and a FileMetaData footer followed by its length and the magic again.
+// Metadata and page headers use the Thrift compact protocol. Like the
+// FlatGeobuf and OSM PBF code, the encoder and decoder below handle just the
+// structures they need by hand.
+//
+// The reader takes flat schemas: plain or dictionary encoded pages (v1 or v2),
+// uncompressed, Snappy or gzip compressed. Locations come from lat/lon columns
+// named like the CSV ones or, failing that, a WKB "geometry" column as in
+// GeoParquet. Nested columns are skipped.
+
+const (
+	maxParquetFooterSize = 64 * 1024 * 1024
+	maxParquetPageSize   = 256 * 1024 * 1024
+	maxParquetChunkSize  = 1024 * 1024 * 1024
+	maxParquetGroupRows  = 64 * 1024 * 1024
+	maxThriftDepth       = 32
+
+	// parquetRowGroupSize is the number of rows per row group written
+	parquetRowGroupSize = 64 * 1024
+)
+
+// Physical types
+const (
+	parquetBoolean           = 0
+	parquetInt32             = 1
+	parquetInt64             = 2
+	parquetInt96             = 3
+	parquetFloat             = 4
+	parquetDouble            = 5
+	parquetByteArray         = 6
+	parquetFixedLenByteArray = 7
+)
+
+// Repetition types, converted types, encodings, codecs and page types
+const (
+	parquetRequired = 0
+	parquetOptional = 1
+
+	parquetUTF8 = 0
+	parquetEnum = 4
+	parquetJSON = 19
+
+	parquetPlain           = 0
+	parquetPlainDictionary = 2
+	parquetRLE             = 3
+	parquetRLEDictionary   = 8
+
+	parquetUncompressed = 0
+	parquetSnappy       = 1
+	parquetGzip         = 2
+
+	parquetDataPage       = 0
+	parquetDictionaryPage = 2
+	parquetDataPageV2     = 3
+)
+
+// Field ids of the Thrift structures from parquet.thrift
+const (
+	pqFileSchema    = 2
+	pqFileNumRows   = 3
+	pqFileRowGroups = 4
+	pqFileMetadata  = 5
+
+	pqSchemaType        = 1
+	pqSchemaTypeLength  = 2
+	pqSchemaRepetition  = 3
+	pqSchemaName        = 4
+	pqSchemaNumChildren = 5
+	pqSchemaConverted   = 6
+	pqSchemaLogical     = 10
+
+	pqRowGroupColumns = 1
+	pqRowGroupNumRows = 3
+
+	pqChunkMetaData = 3
+
+	pqMetaCodec                = 4
+	pqMetaNumValues            = 5
+	pqMetaTotalCompressedSize  = 7
+	pqMetaDataPageOffset       = 9
+	pqMetaDictionaryPageOffset = 11
+
+	pqPageType             = 1
+	pqPageUncompressedSize = 2
+	pqPageCompressedSize   = 3
+	pqPageDataHeader       = 5
+	pqPageDictionaryHeader = 7
+	pqPageDataHeaderV2     = 8
+)
+
+// Thrift compact protocol types
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftByte   = 3
+	thriftI16    = 4
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftDouble = 7
+	thriftBinary = 8
+	thriftList   = 9
+	thriftSet    = 10
+	thriftMap    = 11
+	thriftStruct = 12
+)
+
+var (
+	parquetMagic        = []byte("PAR1")
+	errMalformedParquet = errors.New("malformed parquet data")
+)
+
+// parquetColumn is a top level leaf column of a Parquet schema
+type parquetColumn struct {
+	name     string
+	typ      int64
+	length   int // of fixed length byte arrays
+	optional bool
+	text     bool // byte arrays holding UTF-8 strings
+	json     bool // byte arrays holding JSON
+	chunk    int  // index of the column's chunk in each row group
+}
+
+// parquetReader reads points from a Parquet file row group by row group. The
+// footer comes last, so input that is not an io.ReaderAt and io.Seeker is
+// read into memory first. IDs come from the first column named like the CSV
+// id columns, and every other column holding strings, numbers, booleans or
+// JSON becomes a property.
+type parquetReader struct {
+	r       io.Reader
+	started bool
+	src     io.ReaderAt
+	size    int64
+
+	columns   []parquetColumn
+	rowGroups []map[int16]any
+	next      int
+	pending   []*models.Point
+	rows      int
+
+	// Indexes into columns, -1 when absent
+	idCol, latCol, lonCol, altCol, geomCol int
+}
+
+func newParquetReader(r io.Reader) *parquetReader {
+	return &parquetReader{r: r}
+}
+
+func (p *parquetReader) Read() (*models.Point, error) {
+	if !p.started {
+		if err := p.open(); err != nil {
+			return nil, err
+		}
+		p.started = true
+	}
+	for len(p.pending) == 0 {
+		if p.next == len(p.rowGroups) {
+			return nil, io.EOF
+		}
+		p.next++
+		if err := p.readRowGroup(p.rowGroups[p.next-1]); err != nil {
+			return nil, fmt.Errorf("row group %d: %w", p.next, err)
+		}
+	}
+	point := p.pending[0]
+	p.pending[0] = nil
+	p.pending = p.pending[1:]
+	return point, nil
+}
+
+// open locates the input's size, then decodes the footer and the schema
+func (p *parquetReader) open() error {
+	if ra, ok := p.r.(io.ReaderAt); ok {
+		if seeker, ok := p.r.(io.Seeker); ok {
+			if size, err := seeker.Seek(0, io.SeekEnd); err == nil {
+				p.src, p.size = ra, size
+			}
+		}
+	}
+	if p.src == nil {
+		data, err := io.ReadAll(p.r)
+		if err != nil {
+			return fmt.Errorf("failed to read parquet: %w", err)
+		}
+		p.src, p.size = bytes.NewReader(data), int64(len(data))
+	}
+
+	if p.size < int64(2*len(parquetMagic)+4) {
+		return fmt.Errorf("%w: file of %d bytes", errMalformedParquet, p.size)
+	}
+	head := make([]byte, len(parquetMagic))
+	tail := make([]byte, 4+len(parquetMagic))
+	if _, err := p.src.ReadAt(head, 0); err != nil {
+		return fmt.Errorf("failed to read parquet magic: %w", err)
+	}
+	if _, err := p.src.ReadAt(tail, p.size-int64(len(tail))); err != nil {
+		return fmt.Errorf("failed to read parquet footer: %w", err)
+	}
+	if !bytes.Equal(head, parquetMagic) || !bytes.Equal(tail[4:], parquetMagic) {
+		return fmt.Errorf("not a parquet file")
+	}
+
+	footerSize := int64(binary.LittleEndian.Uint32(tail))
+	if footerSize > maxParquetFooterSize || footerSize > p.size-int64(len(head)+len(tail)) {
+		return fmt.Errorf("%w: footer of %d bytes", errMalformedParquet, footerSize)
+	}
+	footer := make([]byte, footerSize)
+	if _, err := p.src.ReadAt(footer, p.size-int64(len(tail))-footerSize); err != nil {
+		return fmt.Errorf("failed to read parquet footer: %w", err)
+	}
+
+	var meta map[int16]any
+	err := decodeParquet(func() {
+		meta = (&thriftReader{buf: footer}).structure()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to decode parquet footer: %w", err)
+	}
+	p.rowGroups = thriftStructs(meta, pqFileRowGroups)
+	return p.readSchema(thriftStructs(meta, pqFileSchema), thriftStructs(meta, pqFileMetadata))
+}
+
+// readSchema keeps the top level leaf columns and picks the location and ID
+// columns among them
+func (p *parquetReader) readSchema(elements, metadata []map[int16]any) error {
+	if len(elements) == 0 {
+		return fmt.Errorf("%w: empty schema", errMalformedParquet)
+	}
+
+	// Elements are the schema tree in depth first order after the root; every
+	// leaf, nested or not, has a chunk in each row group
+	chunk := 0
+	var skip func(i, depth int) int
+	skip = func(i, depth int) int {
+		if i >= len(elements) || depth > maxThriftDepth {
+			panic(errMalformedParquet)
+		}
+		children := thriftInt(elements[i], pqSchemaNumChildren)
+		if children <= 0 {
+			chunk++
+			return i + 1
+		}
+		next := i + 1
+		for c := int64(0); c < children; c++ {
+			next = skip(next, depth+1)
+		}
+		return next
+	}
+	err := decodeParquet(func() {
+		roots := thriftInt(elements[0], pqSchemaNumChildren)
+		i := 1
+		for c := int64(0); c < roots; c++ {
+			if i >= len(elements) {
+				panic(errMalformedParquet)
+			}
+			el := elements[i]
+			if thriftInt(el, pqSchemaNumChildren) > 0 || thriftInt(el, pqSchemaRepetition) > parquetOptional {
+				i = skip(i, 1)
+				continue
+			}
+			converted, hasConverted := el[pqSchemaConverted]
+			logical := thriftStructField(el, pqSchemaLogical)
+			_, logicalString := logical[1]
+			_, logicalEnum := logical[4]
+			_, logicalJSON := logical[12]
+			isJSON := hasConverted && converted == int64(parquetJSON) || logicalJSON
+			p.columns = append(p.columns, parquetColumn{
+				name:     string(thriftBytes(el, pqSchemaName)),
+				typ:      thriftInt(el, pqSchemaType),
+				length:   int(thriftInt(el, pqSchemaTypeLength)),
+				optional: thriftInt(el, pqSchemaRepetition) == parquetOptional,
+				text: isJSON || logicalString || logicalEnum ||
+					hasConverted && (converted == int64(parquetUTF8) || converted == int64(parquetEnum)),
+				json:  isJSON,
+				chunk: chunk,
+			})
+			chunk++
+			i++
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to decode parquet schema: %w", err)
+	}
+
+	p.idCol = p.findColumn(idColumns, nil)
+	numeric := func(c parquetColumn) bool {
+		return c.typ == parquetDouble || c.typ == parquetFloat || c.typ == parquetInt32 || c.typ == parquetInt64
+	}
+	p.latCol = p.findColumn(latColumns, numeric)
+	p.lonCol = p.findColumn(lonColumns, numeric)
+	p.altCol = p.findColumn(altColumns, numeric)
+	p.geomCol = -1
+	if p.latCol < 0 || p.lonCol < 0 {
+		p.latCol, p.lonCol = -1, -1
+		wkb := func(c parquetColumn) bool { return c.typ == parquetByteArray && !c.text }
+		p.geomCol = p.findColumn([]string{geoParquetColumn(metadata), "geometry", "geom", "wkb_geometry"}, wkb)
+		if p.geomCol < 0 {
+			return fmt.Errorf("parquet file has no lat/lon or WKB geometry columns")
+		}
+	}
+	return nil
+}
+
+// findColumn returns the index of the first column named like one of names,
+// in order of names, that passes ok, or -1
+func (p *parquetReader) findColumn(names []string, ok func(parquetColumn) bool) int {
+	for _, name := range names {
+		for i, col := range p.columns {
+			if name != "" && strings.EqualFold(col.name, name) && (ok == nil || ok(col)) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// geoParquetColumn returns the primary geometry column named in GeoParquet
+// "geo" metadata, or ""
+func geoParquetColumn(metadata []map[int16]any) string {
+	for _, kv := range metadata {
+		if string(thriftBytes(kv, 1)) != "geo" {
+			continue
+		}
+		var geo struct {
+			PrimaryColumn string `json:"primary_column"`
+		}
+		if json.Unmarshal(thriftBytes(kv, 2), &geo) == nil {
+			return geo.PrimaryColumn
+		}
+	}
+	return ""
+}
+
+// readRowGroup decodes the columns in use and queues the group's points. Rows
+// without a location are skipped.
+func (p *parquetReader) readRowGroup(rg map[int16]any) error {
+	chunks := thriftStructs(rg, pqRowGroupColumns)
+	numRows := thriftInt(rg, pqRowGroupNumRows)
+	if numRows < 0 || numRows > maxParquetGroupRows {
+		return fmt.Errorf("%w: row group of %d rows", errMalformedParquet, numRows)
+	}
+
+	values := make([][]any, len(p.columns))
+	for i, col := range p.columns {
+		if col.chunk >= len(chunks) {
+			return fmt.Errorf("%w: missing column chunk %d", errMalformedParquet, col.chunk)
+		}
+		v, err := p.readColumnChunk(col, thriftStructField(chunks[col.chunk], pqChunkMetaData), int(numRows))
+		if err != nil {
+			return fmt.Errorf("column %s: %w", col.name, err)
+		}
+		values[i] = v
+	}
+
+	for row := 0; row < int(numRows); row++ {
+		p.rows++
+		loc := p.location(values, row)
+		if loc == nil {
+			continue
+		}
+		point := &models.Point{ID: fmt.Sprintf("point_%d", p.rows), Location: loc}
+		if p.idCol >= 0 {
+			switch v := values[p.idCol][row].(type) {
+			case string:
+				if v != "" {
+					point.ID = v
+				}
+			case int64:
+				point.ID = strconv.FormatInt(v, 10)
+			}
+		}
+		for i, col := range p.columns {
+			if i == p.idCol || i == p.latCol || i == p.lonCol || i == p.altCol || i == p.geomCol {
+				continue
+			}
+			value := values[i][row]
+			if _, isBytes := value.([]byte); isBytes || value == nil {
+				continue
+			}
+			if s, ok := value.(string); ok && col.json {
+				var v any
+				if json.Unmarshal([]byte(s), &v) == nil {
+					value = v
+				}
+			}
+			if point.Properties == nil {
+				point.Properties = make(map[string]any)
+			}
+			point.Properties[col.name] = value
+		}
+		p.pending = append(p.pending, point)
+	}
+	return nil
+}
+
+// location returns the location of a row, or nil when it has none
+func (p *parquetReader) location(values [][]any, row int) *models.Location {
+	if p.geomCol >= 0 {
+		data, _ := values[p.geomCol][row].([]byte)
+		return wkbPoint(data)
+	}
+	lat, okLat := parquetFloat64(values[p.latCol][row])
+	lon, okLon := parquetFloat64(values[p.lonCol][row])
+	if !okLat || !okLon {
+		return nil
+	}
+	loc := &models.Location{Lat: lat, Lon: lon}
+	if p.altCol >= 0 {
+		loc.Alt, _ = parquetFloat64(values[p.altCol][row])
+	}
+	return loc
+}
+
+func parquetFloat64(v any) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// wkbPoint decodes a WKB or EWKB Point, or returns nil for other geometries
+// and empty points
+func wkbPoint(data []byte) *models.Location {
+	if len(data) < 5 {
+		return nil
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if data[0] == 0 {
+		order = binary.BigEndian
+	}
+	typ := order.Uint32(data[1:])
+	data = data[5:]
+
+	// EWKB flags the dimensions in high bits, ISO WKB adds 1000s to the type
+	hasZ := typ&0x80000000 != 0
+	if typ&0x20000000 != 0 {
+		if len(data) < 4 {
+			return nil
+		}
+		data = data[4:]
+	}
+	typ &= 0x0fffffff
+	if typ/1000 == 1 || typ/1000 == 3 {
+		hasZ = true
+	}
+	if typ%1000 != 1 {
+		return nil
+	}
+
+	need := 16
+	if hasZ {
+		need = 24
+	}
+	if len(data) < need {
+		return nil
+	}
+	x := math.Float64frombits(order.Uint64(data))
+	y := math.Float64frombits(order.Uint64(data[8:]))
+	if math.IsNaN(x) || math.IsNaN(y) {
+		return nil
+	}
+	loc := &models.Location{Lat: y, Lon: x}
+	if hasZ {
+		if z := math.Float64frombits(order.Uint64(data[16:])); !math.IsNaN(z) {
+			loc.Alt = z
+		}
+	}
+	return loc
+}
+
+// readColumnChunk decodes every value of a column chunk, with nil for nulls
+func (p *parquetReader) readColumnChunk(col parquetColumn, meta map[int16]any, numRows int) ([]any, error) {
+	if meta == nil {
+		return nil, fmt.Errorf("%w: column chunk without metadata", errMalformedParquet)
+	}
+	start := thriftInt(meta, pqMetaDataPageOffset)
+	if dict, ok := meta[pqMetaDictionaryPageOffset].(int64); ok && dict > 0 && dict < start {
+		start = dict
+	}
+	size := thriftInt(meta, pqMetaTotalCompressedSize)
+	if start < 0 || size < 0 || size > maxParquetChunkSize || start > p.size-size {
+		return nil, fmt.Errorf("%w: column chunk of %d bytes at %d", errMalformedParquet, size, start)
+	}
+	data := make([]byte, size)
+	if _, err := p.src.ReadAt(data, start); err != nil {
+		return nil, fmt.Errorf("failed to read column chunk: %w", err)
+	}
+
+	codec := thriftInt(meta, pqMetaCodec)
+	numValues := int(thriftInt(meta, pqMetaNumValues))
+	if numValues != numRows {
+		return nil, fmt.Errorf("%w: %d values for %d rows", errMalformedParquet, numValues, numRows)
+	}
+
+	values := make([]any, 0, numValues)
+	var dict []any
+	for len(values) < numValues {
+		var header map[int16]any
+		pos := 0
+		err := decodeParquet(func() {
+			t := &thriftReader{buf: data}
+			header = t.structure()
+			pos = t.pos
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode page header: %w", err)
+		}
+		compressed := thriftInt(header, pqPageCompressedSize)
+		uncompressed := thriftInt(header, pqPageUncompressedSize)
+		if compressed < 0 || compressed > int64(len(data)-pos) || uncompressed < 0 || uncompressed > maxParquetPageSize {
+			return nil, fmt.Errorf("%w: page of %d bytes", errMalformedParquet, compressed)
+		}
+		body := data[pos : pos+int(compressed)]
+		data = data[pos+int(compressed):]
+
+		switch thriftInt(header, pqPageType) {
+		case parquetDictionaryPage:
+			h := thriftStructField(header, pqPageDictionaryHeader)
+			raw, err := parquetDecompress(codec, body, int(uncompressed))
+			if err != nil {
+				return nil, err
+			}
+			if dict, err = parquetPlainValues(col, raw, int(thriftInt(h, 1))); err != nil {
+				return nil, err
+			}
+		case parquetDataPage:
+			h := thriftStructField(header, pqPageDataHeader)
+			raw, err := parquetDecompress(codec, body, int(uncompressed))
+			if err != nil {
+				return nil, err
+			}
+			n := int(thriftInt(h, 1))
+			if n < 0 || n > numValues-len(values) {
+				return nil, fmt.Errorf("%w: page of %d values", errMalformedParquet, n)
+			}
+			var defs []uint32
+			if col.optional {
+				if len(raw) < 4 || int(binary.LittleEndian.Uint32(raw)) > len(raw)-4 {
+					return nil, fmt.Errorf("%w: definition levels", errMalformedParquet)
+				}
+				size := 4 + int(binary.LittleEndian.Uint32(raw))
+				if defs, err = rleHybrid(raw[4:size], 1, n); err != nil {
+					return nil, err
+				}
+				raw = raw[size:]
+			}
+			if values, err = parquetPage(values, col, thriftInt(h, 2), raw, n, defs, dict); err != nil {
+				return nil, err
+			}
+		case parquetDataPageV2:
+			h := thriftStructField(header, pqPageDataHeaderV2)
+			n := int(thriftInt(h, 1))
+			if n < 0 || n > numValues-len(values) {
+				return nil, fmt.Errorf("%w: page of %d values", errMalformedParquet, n)
+			}
+			defSize, repSize := int(thriftInt(h, 5)), int(thriftInt(h, 6))
+			if defSize < 0 || repSize < 0 || defSize+repSize > len(body) || defSize+repSize > int(uncompressed) {
+				return nil, fmt.Errorf("%w: page levels", errMalformedParquet)
+			}
+			var defs []uint32
+			if col.optional {
+				var err error
+				if defs, err = rleHybrid(body[repSize:repSize+defSize], 1, n); err != nil {
+					return nil, err
+				}
+			}
+			raw := body[repSize+defSize:]
+			if isCompressed, ok := h[7].(bool); !ok || isCompressed {
+				var err error
+				if raw, err = parquetDecompress(codec, raw, int(uncompressed)-repSize-defSize); err != nil {
+					return nil, err
+				}
+			}
+			var err error
+			if values, err = parquetPage(values, col, thriftInt(h, 4), raw, n, defs, dict); err != nil {
+				return nil, err
+			}
+		}
+		if len(values) > numValues {
+			return nil, fmt.Errorf("%w: more values than rows", errMalformedParquet)
+		}
+		if len(values) < numValues && len(data) == 0 {
+			return nil, fmt.Errorf("%w: column chunk ends after %d of %d values", errMalformedParquet, len(values), numValues)
+		}
+	}
+	return values, nil
+}
+
+// parquetPage appends the n values of a data page to values. defs holds the
+// definition levels of an optional column, where 0 marks a null.
+func parquetPage(values []any, col parquetColumn, encoding int64, data []byte, n int, defs []uint32, dict []any) ([]any, error) {
+	present := n
+	if defs != nil {
+		present = 0
+		for _, d := range defs {
+			if d > 0 {
+				present++
+			}
+		}
+	}
+
+	var decoded []any
+	switch encoding {
+	case parquetPlain:
+		var err error
+		if decoded, err = parquetPlainValues(col, data, present); err != nil {
+			return nil, err
+		}
+	case parquetPlainDictionary, parquetRLEDictionary:
+		if len(data) < 1 {
+			return nil, fmt.Errorf("%w: dictionary indexes", errMalformedParquet)
+		}
+		indexes, err := rleHybrid(data[1:], int(data[0]), present)
+		if err != nil {
+			return nil, err
+		}
+		decoded = make([]any, present)
+		for i, index := range indexes {
+			if int(index) >= len(dict) {
+				return nil, fmt.Errorf("%w: dictionary index %d of %d", errMalformedParquet, index, len(dict))
+			}
+			decoded[i] = dict[index]
+		}
+	default:
+		return nil, fmt.Errorf("unsupported parquet encoding %d", encoding)
+	}
+
+	if defs == nil {
+		return append(values, decoded...), nil
+	}
+	next := 0
+	for _, d := range defs {
+		if d == 0 {
+			values = append(values, nil)
+			continue
+		}
+		values = append(values, decoded[next])
+		next++
+	}
+	return values, nil
+}
+
+// parquetPlainValues decodes n plain encoded values: integers as int64,
+// floats as float64, text byte arrays as strings and others as []byte. INT96
+// values decode as nil.
+func parquetPlainValues(col parquetColumn, data []byte, n int) ([]any, error) {
+	size := map[int64]int{parquetInt32: 4, parquetInt64: 8, parquetInt96: 12, parquetFloat: 4, parquetDouble: 8}[col.typ]
+	switch col.typ {
+	case parquetBoolean:
+		size = 0
+		if n < 0 || (n+7)/8 > len(data) {
+			return nil, fmt.Errorf("%w: %d booleans in %d bytes", errMalformedParquet, n, len(data))
+		}
+	case parquetFixedLenByteArray:
+		size = col.length
+		if size <= 0 {
+			return nil, fmt.Errorf("%w: fixed length of %d bytes", errMalformedParquet, size)
+		}
+	case parquetByteArray:
+		// Each value has at least its 4 byte length
+		size = 4
+	}
+	if size > 0 && (n < 0 || n > len(data)/size) {
+		return nil, fmt.Errorf("%w: %d values in %d bytes", errMalformedParquet, n, len(data))
+	}
+
+	values := make([]any, n)
+	for i := range values {
+		switch col.typ {
+		case parquetBoolean:
+			values[i] = data[i/8]>>(i%8)&1 == 1
+		case parquetInt32:
+			values[i] = int64(int32(binary.LittleEndian.Uint32(data[4*i:])))
+		case parquetInt64:
+			values[i] = int64(binary.LittleEndian.Uint64(data[8*i:]))
+		case parquetFloat:
+			values[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:])))
+		case parquetDouble:
+			values[i] = math.Float64frombits(binary.LittleEndian.Uint64(data[8*i:]))
+		case parquetFixedLenByteArray:
+			values[i] = parquetBytes(col, data[size*i:size*(i+1)])
+		case parquetByteArray:
+			if len(data) < 4 || int(binary.LittleEndian.Uint32(data)) > len(data)-4 {
+				return nil, fmt.Errorf("%w: byte array %d", errMalformedParquet, i)
+			}
+			end := 4 + int(binary.LittleEndian.Uint32(data))
+			values[i] = parquetBytes(col, data[4:end])
+			data = data[end:]
+		}
+	}
+	return values, nil
+}
+
+func parquetBytes(col parquetColumn, b []byte) any {
+	if col.text {
+		return string(b)
+	}
+	return bytes.Clone(b)
+}
+
+// rleHybrid decodes n values of the given bit width from the RLE/bit-packing
+// hybrid encoding used for levels and dictionary indexes
+func rleHybrid(data []byte, bitWidth, n int) ([]uint32, error) {
+	if bitWidth < 0 || bitWidth > 32 || n < 0 {
+		return nil, fmt.Errorf("%w: rle bit width %d", errMalformedParquet, bitWidth)
+	}
+	values := make([]uint32, 0, min(n, 64*1024))
+	for len(values) < n {
+		header, k := binary.Uvarint(data)
+		if k <= 0 {
+			return nil, fmt.Errorf("%w: rle run ends after %d of %d values", errMalformedParquet, len(values), n)
+		}
+		data = data[k:]
+
+		if header&1 == 0 {
+			// A run of one value stored in whole bytes
+			width := (bitWidth + 7) / 8
+			if len(data) < width {
+				return nil, fmt.Errorf("%w: rle run", errMalformedParquet)
+			}
+			var v uint32
+			for i := 0; i < width; i++ {
+				v |= uint32(data[i]) << (8 * i)
+			}
+			data = data[width:]
+			for run := header >> 1; run > 0 && len(values) < n; run-- {
+				values = append(values, v)
+			}
+			continue
+		}
+
+		// Groups of 8 values packed least significant bit first
+		groups := header >> 1
+		if groups > uint64(len(data)) {
+			return nil, fmt.Errorf("%w: bit-packed run", errMalformedParquet)
+		}
+		size := int(groups) * bitWidth
+		if size > len(data) {
+			return nil, fmt.Errorf("%w: bit-packed run", errMalformedParquet)
+		}
+		packed := data[:size]
+		data = data[size:]
+		for i := 0; i < int(groups)*8 && len(values) < n; i++ {
+			var v uint32
+			for b := 0; b < bitWidth; b++ {
+				bit := i*bitWidth + b
+				v |= uint32(packed[bit/8]>>(bit%8)&1) << b
+			}
+			values = append(values, v)
+		}
+	}
+	return values, nil
+}
+
+// parquetDecompress returns the size bytes a page body decompresses to
+func parquetDecompress(codec int64, data []byte, size int) ([]byte, error) {
+	switch codec {
+	case parquetUncompressed:
+		return data, nil
+	case parquetSnappy:
+		return snappyDecode(data, size)
+	case parquetGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress page: %w", err)
+		}
+		defer zr.Close()
+		out := bytes.NewBuffer(make([]byte, 0, size))
+		if _, err := io.Copy(out, io.LimitReader(zr, int64(size)+1)); err != nil {
+			return nil, fmt.Errorf("failed to decompress page: %w", err)
+		}
+		if out.Len() != size {
+			return nil, fmt.Errorf("%w: page of %d bytes decompressed to %d", errMalformedParquet, size, out.Len())
+		}
+		return out.Bytes(), nil
+	}
+	return nil, fmt.Errorf("unsupported parquet compression codec %d", codec)
+}
+
+// snappyDecode decodes a raw Snappy block that must decompress to size bytes
+func snappyDecode(src []byte, size int) ([]byte, error) {
+	n, k := binary.Uvarint(src)
+	if k <= 0 || n != uint64(size) {
+		return nil, fmt.Errorf("%w: snappy block of %d bytes, want %d", errMalformedParquet, n, size)
+	}
+	src = src[k:]
+	dst := make([]byte, 0, size)
+	for len(src) > 0 {
+		tag := src[0]
+		var length, offset int
+		switch tag & 3 {
+		case 0:
+			length = int(tag>>2) + 1
+			src = src[1:]
+			if length > 60 {
+				extra := length - 60
+				if len(src) < extra {
+					return nil, errMalformedParquet
+				}
+				length = 0
+				for i := 0; i < extra; i++ {
+					length |= int(src[i]) << (8 * i)
+				}
+				length++
+				src = src[extra:]
+			}
+			if length <= 0 || length > len(src) || length > size-len(dst) {
+				return nil, errMalformedParquet
+			}
+			dst = append(dst, src[:length]...)
+			src = src[length:]
+			continue
+		case 1:
+			if len(src) < 2 {
+				return nil, errMalformedParquet
+			}
+			length = 4 + int(tag>>2&7)
+			offset = int(tag&0xe0)<<3 | int(src[1])
+			src = src[2:]
+		case 2:
+			if len(src) < 3 {
+				return nil, errMalformedParquet
+			}
+			length = int(tag>>2) + 1
+			offset = int(binary.LittleEndian.Uint16(src[1:]))
+			src = src[3:]
+		case 3:
+			if len(src) < 5 {
+				return nil, errMalformedParquet
+			}
+			length = int(tag>>2) + 1
+			offset = int(binary.LittleEndian.Uint32(src[1:]))
+			src = src[5:]
+		}
+		if offset <= 0 || offset > len(dst) || length > size-len(dst) {
+			return nil, errMalformedParquet
+		}
+		// Copies may overlap their own output
+		for i := 0; i < length; i++ {
+			dst = append(dst, dst[len(dst)-offset])
+		}
+	}
+	if len(dst) != size {
+		return nil, fmt.Errorf("%w: snappy block decompressed to %d bytes, want %d", errMalformedParquet, len(dst), size)
+	}
+	return dst, nil
+}
+
+// thriftReader decodes the Thrift compact protocol. Structs decode as maps
+// from field id to value, with integers as int64, binary as []byte and lists
+// as []any; maps are skipped. Methods panic with errMalformedParquet on
+// truncated or overly nested input; decodeParquet turns that into an error.
+type thriftReader struct {
+	buf   []byte
+	pos   int
+	depth int
+}
+
+func decodeParquet(fn func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(error)
+			if !ok || !errors.Is(e, errMalformedParquet) {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+	fn()
+	return nil
+}
+
+func (t *thriftReader) byte() byte {
+	if t.pos >= len(t.buf) {
+		panic(errMalformedParquet)
+	}
+	t.pos++
+	return t.buf[t.pos-1]
+}
+
+func (t *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(t.buf[t.pos:])
+	if n <= 0 {
+		panic(errMalformedParquet)
+	}
+	t.pos += n
+	return v
+}
+
+func (t *thriftReader) varint() int64 {
+	v, n := binary.Varint(t.buf[t.pos:])
+	if n <= 0 {
+		panic(errMalformedParquet)
+	}
+	t.pos += n
+	return v
+}
+
+func (t *thriftReader) bytes(n uint64) []byte {
+	if n > uint64(len(t.buf)-t.pos) {
+		panic(errMalformedParquet)
+	}
+	t.pos += int(n)
+	return t.buf[t.pos-int(n) : t.pos]
+}
+
+func (t *thriftReader) structure() map[int16]any {
+	if t.depth++; t.depth > maxThriftDepth {
+		panic(errMalformedParquet)
+	}
+	fields := make(map[int16]any)
+	var last int16
+	for {
+		b := t.byte()
+		if b == 0 {
+			break
+		}
+		typ := b & 0x0f
+		id := last + int16(b>>4)
+		if b>>4 == 0 {
+			id = int16(t.varint())
+		}
+		last = id
+		if typ == thriftTrue || typ == thriftFalse {
+			fields[id] = typ == thriftTrue
+			continue
+		}
+		fields[id] = t.value(typ)
+	}
+	t.depth--
+	return fields
+}
+
+func (t *thriftReader) value(typ byte) any {
+	switch typ {
+	case thriftTrue, thriftFalse:
+		// Booleans inside lists take a byte each
+		return t.byte() == thriftTrue
+	case thriftByte:
+		return int64(int8(t.byte()))
+	case thriftI16, thriftI32, thriftI64:
+		return t.varint()
+	case thriftDouble:
+		return math.Float64frombits(binary.LittleEndian.Uint64(t.bytes(8)))
+	case thriftBinary:
+		return t.bytes(t.uvarint())
+	case thriftList, thriftSet:
+		header := t.byte()
+		n, elem := uint64(header>>4), header&0x0f
+		if n == 15 {
+			n = t.uvarint()
+		}
+		// Every element takes at least a byte
+		if n > uint64(len(t.buf)-t.pos) {
+			panic(errMalformedParquet)
+		}
+		values := make([]any, n)
+		for i := range values {
+			values[i] = t.value(elem)
+		}
+		return values
+	case thriftMap:
+		n := t.uvarint()
+		if n == 0 {
+			return nil
+		}
+		if n > uint64(len(t.buf)-t.pos) {
+			panic(errMalformedParquet)
+		}
+		types := t.byte()
+		for i := uint64(0); i < n; i++ {
+			t.value(types >> 4)
+			t.value(types & 0x0f)
+		}
+		return nil
+	case thriftStruct:
+		return t.structure()
+	}
+	panic(errMalformedParquet)
+}
+
+func thriftInt(s map[int16]any, id int16) int64 {
+	v, _ := s[id].(int64)
+	return v
+}
+
+func thriftBytes(s map[int16]any, id int16) []byte {
+	v, _ := s[id].([]byte)
+	return v
+}
+
+func thriftStructField(s map[int16]any, id int16) map[int16]any {
+	v, _ := s[id].(map[int16]any)
+	return v
+}
+
+// thriftStructs returns the structs of a list field, skipping other values
+func thriftStructs(s map[int16]any, id int16) []map[int16]any {
+	list, _ := s[id].([]any)
+	structs := make([]map[int16]any, 0, len(list))
+	for _, v := range list {
+		if st, ok := v.(map[int16]any); ok {
+			structs = append(structs, st)
+		}
+	}
+	return structs
+}
+
+// thriftField is one field of a Thrift struct to encode. Values are bool,
+// int32, int64, string, thriftFields or thriftListOf.
+type thriftField struct {
+	id    int16
+	value any
+}
+
+type thriftFields []thriftField
+
+// thriftListOf is a list of elem typed values
+type thriftListOf struct {
+	elem   byte
+	values []any
+}
+
+func appendThriftStruct(buf []byte, fields thriftFields) []byte {
+	var last int16
+	for _, f := range fields {
+		typ := thriftTypeOf(f.value)
+		if delta := f.id - last; delta > 0 && delta <= 15 {
+			buf = append(buf, byte(delta)<<4|typ)
+		} else {
+			buf = append(buf, typ)
+			buf = binary.AppendVarint(buf, int64(f.id))
+		}
+		last = f.id
+		buf = appendThriftValue(buf, f.value)
+	}
+	return append(buf, 0)
+}
+
+func thriftTypeOf(v any) byte {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return thriftTrue
+		}
+		return thriftFalse
+	case int32:
+		return thriftI32
+	case int64:
+		return thriftI64
+	case string:
+		return thriftBinary
+	case thriftListOf:
+		return thriftList
+	case thriftFields:
+		return thriftStruct
+	}
+	panic(fmt.Sprintf("unsupported thrift value %T", v))
+}
+
+// appendThriftValue encodes v; booleans are carried by the field type
+func appendThriftValue(buf []byte, v any) []byte {
+	switch v := v.(type) {
+	case int32:
+		return binary.AppendVarint(buf, int64(v))
+	case int64:
+		return binary.AppendVarint(buf, v)
+	case string:
+		buf = binary.AppendUvarint(buf, uint64(len(v)))
+		return append(buf, v...)
+	case thriftListOf:
+		if len(v.values) < 15 {
+			buf = append(buf, byte(len(v.values))<<4|v.elem)
+		} else {
+			buf = append(buf, 0xf0|v.elem)
+			buf = binary.AppendUvarint(buf, uint64(len(v.values)))
+		}
+		for _, value := range v.values {
+			buf = appendThriftValue(buf, value)
+		}
+		return buf
+	case thriftFields:
+		return appendThriftStruct(buf, v)
+	}
+	return buf
+}
+
+// parquetWriter writes points as a flat table of a required "id" string and
+// "lat"/"lon" doubles, an optional "alt" double when any point has an
+// altitude, and an optional column per property name typed like the
+// FlatGeobuf writer's. Properties named like those fixed columns are dropped.
+// Pages are plain encoded and uncompressed, and points are buffered so the
+// schema can cover every property.
+type parquetWriter struct {
+	w      io.Writer
+	points []models.Point
+}
+
+// parquetOutputColumn is a column written by parquetWriter with the function
+// returning its value for a point, or nil for a null
+type parquetOutputColumn struct {
+	parquetColumn
+	value func(p *models.Point) any
+}
+
+func newParquetWriter(w io.Writer) *parquetWriter {
+	return &parquetWriter{w: w}
+}
+
+func (p *parquetWriter) Write(point *models.Point) error {
+	if point.Location == nil {
+		return nil
+	}
+	location := *point.Location
+	p.points = append(p.points, models.Point{ID: point.ID, Location: &location, Properties: point.Properties})
+	return nil
+}
+
+func (p *parquetWriter) Close() error {
+	columns := p.columns()
+	offset := int64(len(parquetMagic))
+	if _, err := p.w.Write(parquetMagic); err != nil {
+		return err
+	}
+
+	var rowGroups []any
+	for start := 0; start < len(p.points); start += parquetRowGroupSize {
+		rows := p.points[start:min(start+parquetRowGroupSize, len(p.points))]
+		var chunks []any
+		var groupSize int64
+		for _, col := range columns {
+			page, err := parquetEncodePage(col, rows)
+			if err != nil {
+				return fmt.Errorf("failed to encode column %s: %w", col.name, err)
+			}
+			if _, err := p.w.Write(page); err != nil {
+				return err
+			}
+			chunks = append(chunks, thriftFields{
+				{2, offset},
+				{pqChunkMetaData, thriftFields{
+					{1, int32(col.typ)},
+					{2, thriftListOf{thriftI32, []any{int32(parquetPlain), int32(parquetRLE)}}},
+					{3, thriftListOf{thriftBinary, []any{col.name}}},
+					{pqMetaCodec, int32(parquetUncompressed)},
+					{pqMetaNumValues, int64(len(rows))},
+					{6, int64(len(page))},
+					{pqMetaTotalCompressedSize, int64(len(page))},
+					{pqMetaDataPageOffset, offset},
+				}},
+			})
+			offset += int64(len(page))
+			groupSize += int64(len(page))
+		}
+		rowGroups = append(rowGroups, thriftFields{
+			{pqRowGroupColumns, thriftListOf{thriftStruct, chunks}},
+			{2, groupSize},
+			{pqRowGroupNumRows, int64(len(rows))},
+		})
+	}
+
+	schema := []any{thriftFields{
+		{pqSchemaName, "schema"},
+		{pqSchemaNumChildren, int32(len(columns))},
+	}}
+	for _, col := range columns {
+		el := thriftFields{
+			{pqSchemaType, int32(col.typ)},
+			{pqSchemaRepetition, int32(parquetRequired)},
+			{pqSchemaName, col.name},
+		}
+		if col.optional {
+			el[1].value = int32(parquetOptional)
+		}
+		switch {
+		case col.json:
+			el = append(el, thriftField{pqSchemaConverted, int32(parquetJSON)})
+		case col.text:
+			el = append(el, thriftField{pqSchemaConverted, int32(parquetUTF8)})
+		}
+		schema = append(schema, el)
+	}
+
+	footer := appendThriftStruct(nil, thriftFields{
+		{1, int32(1)},
+		{pqFileSchema, thriftListOf{thriftStruct, schema}},
+		{pqFileNumRows, int64(len(p.points))},
+		{pqFileRowGroups, thriftListOf{thriftStruct, rowGroups}},
+		{6, "geo-index-rtree"},
+	})
+	footer = binary.LittleEndian.AppendUint32(footer, uint32(len(footer)))
+	footer = append(footer, parquetMagic...)
+	p.points = nil
+	_, err := p.w.Write(footer)
+	return err
+}
+
+// columns returns the id, lat, lon and, when used, alt columns followed by
+// the property columns
+func (p *parquetWriter) columns() []parquetOutputColumn {
+	columns := []parquetOutputColumn{
+		{parquetColumn{name: "id", typ: parquetByteArray, text: true}, func(p *models.Point) any { return p.ID }},
+		{parquetColumn{name: "lat", typ: parquetDouble}, func(p *models.Point) any { return p.Location.Lat }},
+		{parquetColumn{name: "lon", typ: parquetDouble}, func(p *models.Point) any { return p.Location.Lon }},
+	}
+	for i := range p.points {
+		if p.points[i].Location.Alt != 0 {
+			columns = append(columns, parquetOutputColumn{
+				parquetColumn{name: "alt", typ: parquetDouble, optional: true},
+				func(p *models.Point) any {
+					if p.Location.Alt == 0 {
+						return nil
+					}
+					return p.Location.Alt
+				},
+			})
+			break
+		}
+	}
+
+	for _, col := range fgbInferColumns(p.points)[1:] {
+		if strings.EqualFold(col.name, "lat") || strings.EqualFold(col.name, "lon") || strings.EqualFold(col.name, "alt") {
+			continue
+		}
+		out := parquetOutputColumn{parquetColumn: parquetColumn{name: col.name, optional: true}}
+		switch col.typ {
+		case fgbColumnBool:
+			out.typ = parquetBoolean
+		case fgbColumnLong:
+			out.typ = parquetInt64
+		case fgbColumnDouble:
+			out.typ = parquetDouble
+		case fgbColumnString:
+			out.typ, out.text = parquetByteArray, true
+		default:
+			out.typ, out.text, out.json = parquetByteArray, true, true
+		}
+		name := col.name
+		out.value = func(p *models.Point) any { return p.Properties[name] }
+		columns = append(columns, out)
+	}
+	return columns
+}
+
+// parquetEncodePage returns a page header and a plain encoded data page
+// holding the column's values for rows. Optional columns lead with their
+// definition levels.
+func parquetEncodePage(col parquetOutputColumn, rows []models.Point) ([]byte, error) {
+	var levels, data []byte
+	var bits []bool
+	for i := range rows {
+		value := col.value(&rows[i])
+		if col.optional {
+			if value == nil {
+				levels = append(levels, 0)
+				continue
+			}
+			levels = append(levels, 1)
+		}
+
+		switch col.typ {
+		case parquetBoolean:
+			bits = append(bits, value.(bool))
+		case parquetInt64:
+			n := reflect.ValueOf(value)
+			if n.CanUint() {
+				data = binary.LittleEndian.AppendUint64(data, n.Uint())
+			} else {
+				data = binary.LittleEndian.AppendUint64(data, uint64(n.Int()))
+			}
+		case parquetDouble:
+			n := reflect.ValueOf(value)
+			var v float64
+			if n.CanFloat() {
+				v = n.Float()
+			} else if n.CanUint() {
+				v = float64(n.Uint())
+			} else {
+				v = float64(n.Int())
+			}
+			data = binary.LittleEndian.AppendUint64(data, math.Float64bits(v))
+		default:
+			var b []byte
+			if s, ok := value.(string); ok && !col.json {
+				b = []byte(s)
+			} else {
+				var err error
+				if b, err = json.Marshal(value); err != nil {
+					return nil, err
+				}
+			}
+			data = binary.LittleEndian.AppendUint32(data, uint32(len(b)))
+			data = append(data, b...)
+		}
+	}
+	if col.typ == parquetBoolean {
+		data = make([]byte, (len(bits)+7)/8)
+		for i, b := range bits {
+			if b {
+				data[i/8] |= 1 << (i % 8)
+			}
+		}
+	}
+
+	var body []byte
+	if col.optional {
+		runs := appendRLERuns(nil, levels)
+		body = binary.LittleEndian.AppendUint32(body, uint32(len(runs)))
+		body = append(body, runs...)
+	}
+	body = append(body, data...)
+
+	page := appendThriftStruct(nil, thriftFields{
+		{pqPageType, int32(parquetDataPage)},
+		{pqPageUncompressedSize, int32(len(body))},
+		{pqPageCompressedSize, int32(len(body))},
+		{pqPageDataHeader, thriftFields{
+			{1, int32(len(rows))},
+			{2, int32(parquetPlain)},
+			{3, int32(parquetRLE)},
+			{4, int32(parquetRLE)},
+		}},
+	})
+	return append(page, body...), nil
+}
+
+// appendRLERuns encodes one bit levels as RLE runs of the hybrid encoding
+func appendRLERuns(buf []byte, levels []byte) []byte {
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		buf = binary.AppendUvarint(buf, uint64(j-i)<<1)
+		buf = append(buf, levels[i])
+		i = j
+	}
+	return buf
+}
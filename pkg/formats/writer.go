@@ -0,0 +1,180 @@
+package formats
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+)
+
+// Writer streams points to an output. Close flushes buffered output and
+// finishes the document but does not close the underlying io.Writer.
+type Writer interface {
+	Write(point *models.Point) error
+	Close() error
+}
+
+// NewWriter returns a streaming point writer for the given format
+func NewWriter(format Format, w io.Writer) (Writer, error) {
+	switch format {
+	case GeoJSON:
+		return &geoJSONWriter{w: bufio.NewWriter(w)}, nil
+	case CSV:
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	case NDJSON:
+		bw := bufio.NewWriter(w)
+		return &ndjsonWriter{w: bw, enc: json.NewEncoder(bw)}, nil
+	case FlatGeobuf:
+		return newFGBWriter(w), nil
+	case Parquet:
+		return newParquetWriter(w), nil
+	}
+	return nil, fmt.Errorf("unsupported output format %q", format)
+}
+
+// geoJSONWriter writes a FeatureCollection of Point features
+type geoJSONWriter struct {
+	w *bufio.Writer
+	n int
+}
+
+// geoJSONOutputFeature is a models.GeoJSONFeature with its ID written as
+// featureID
+type geoJSONOutputFeature struct {
+	Type       string                  `json:"type"`
+	ID         featureID               `json:"id"`
+	Geometry   *models.GeoJSONGeometry `json:"geometry"`
+	Properties map[string]any          `json:"properties"`
+}
+
+func (g *geoJSONWriter) Write(point *models.Point) error {
+	if point.Location == nil {
+		return nil
+	}
+
+	prefix := ",\n"
+	if g.n == 0 {
+		prefix = `{"type":"FeatureCollection","features":[` + "\n"
+	}
+	g.n++
+
+	feature := point.ToGeoJSON()
+	id, properties := outputID(point, feature.Properties)
+	data, err := json.Marshal(geoJSONOutputFeature{
+		Type:       feature.Type,
+		ID:         id,
+		Geometry:   feature.Geometry,
+		Properties: properties,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := g.w.WriteString(prefix); err != nil {
+		return err
+	}
+	_, err = g.w.Write(data)
+	return err
+}
+
+func (g *geoJSONWriter) Close() error {
+	end := "\n]}\n"
+	if g.n == 0 {
+		end = `{"type":"FeatureCollection","features":[]}` + "\n"
+	}
+	if _, err := g.w.WriteString(end); err != nil {
+		return err
+	}
+	return g.w.Flush()
+}
+
+// csvWriter writes an id,lat,lon table with a header row, adding an alt
+// column once a point carries an altitude. Rows are held back until the first
+// such point or Close decides the header; from then on they stream. Properties
+// are not written since the columns must be known before the first row.
+type csvWriter struct {
+	w       *csv.Writer
+	started bool
+	alt     bool
+	pending []models.Point
+}
+
+func (c *csvWriter) Write(point *models.Point) error {
+	if point.Location == nil {
+		return nil
+	}
+	if !c.started {
+		if point.Location.Alt == 0 {
+			location := *point.Location
+			c.pending = append(c.pending, models.Point{ID: point.ID, Location: &location})
+			return nil
+		}
+		c.alt = true
+		if err := c.start(); err != nil {
+			return err
+		}
+	}
+	return c.writeRow(point)
+}
+
+// start writes the header and the rows held back so far
+func (c *csvWriter) start() error {
+	c.started = true
+	header := []string{"id", "lat", "lon"}
+	if c.alt {
+		header = append(header, "alt")
+	}
+	if err := c.w.Write(header); err != nil {
+		return err
+	}
+	for i := range c.pending {
+		if err := c.writeRow(&c.pending[i]); err != nil {
+			return err
+		}
+	}
+	c.pending = nil
+	return nil
+}
+
+func (c *csvWriter) writeRow(point *models.Point) error {
+	record := []string{
+		point.ID,
+		strconv.FormatFloat(point.Location.Lat, 'f', -1, 64),
+		strconv.FormatFloat(point.Location.Lon, 'f', -1, 64),
+	}
+	if c.alt {
+		alt := ""
+		if point.Location.Alt != 0 {
+			alt = strconv.FormatFloat(point.Location.Alt, 'f', -1, 64)
+		}
+		record = append(record, alt)
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvWriter) Close() error {
+	if !c.started {
+		if err := c.start(); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// ndjsonWriter writes one models.Point JSON object per line
+type ndjsonWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (n *ndjsonWriter) Write(point *models.Point) error {
+	return n.enc.Encode(point)
+}
+
+func (n *ndjsonWriter) Close() error {
+	return n.w.Flush()
+}
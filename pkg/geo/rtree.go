@@ -1,286 +1,106 @@
-// Package geo provides an efficient R-Tree implementation for geo-spatial search.
-// This implementation is optimized for maximum performance using goroutines
-// to parallelize operations across CPU cores.
+// Package geo is the original single-tree index API, kept as a thin wrapper
+// over pkg/rtree so existing callers keep compiling.
+//
+// Deprecated: use pkg/rtree and pkg/models directly. Index files written by
+// this package are in the pkg/rtree format, and files it wrote before still load.
 package geo
 
 import (
-	"encoding/gob"
-	"fmt"
-	"math"
-	"os"
-	"runtime"
-	"sync"
-	"sync/atomic"
-
-	"github.com/dhconnelly/rtreego"
-)
-
-const (
-	tolerance    = 0.01
-	minChildren  = 25
-	maxChildren  = 50
-	dimensions   = 2
-	earthRadius  = 6371.0 // km
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/1F47E/geo-index-rtree/pkg/rtree"
 )
 
 // Point represents a geographical point
+//
+// Deprecated: use models.Point.
 type Point struct {
 	ID  string
 	Lat float64
 	Lon float64
 }
 
-// spatialItem wraps a Point for R-Tree indexing
-type spatialItem struct {
-	*Point
-	rect *rtreego.Rect
-}
-
-func (si *spatialItem) Bounds() *rtreego.Rect {
-	return si.rect
-}
-
-// GeoIndex is a thread-safe R-Tree based geographical index
+// GeoIndex is a thread-safe geographical index backed by rtree.GeoIndex
+//
+// Deprecated: use rtree.GeoIndex.
 type GeoIndex struct {
-	tree     *rtreego.Rtree
-	mu       sync.RWMutex
-	itemCount atomic.Int64
+	index *rtree.GeoIndex
 }
 
 // NewGeoIndex creates a new geographical index
 func NewGeoIndex() *GeoIndex {
-	return &GeoIndex{
-		tree: rtreego.NewTree(dimensions, minChildren, maxChildren),
-	}
+	return &GeoIndex{index: rtree.NewGeoIndex()}
 }
 
-// IndexPoints indexes a batch of points using parallel processing
-func (g *GeoIndex) IndexPoints(points []*Point) {
-	if len(points) == 0 {
-		return
-	}
-	
-	numCPU := runtime.NumCPU()
-	spatialItems := make([]rtreego.Spatial, len(points))
-	var wg sync.WaitGroup
-	
-	// Calculate batch size for each CPU
-	batchSize := len(points) / numCPU
-	if batchSize < 1 {
-		batchSize = 1
-		numCPU = len(points)
-	}
-
-	// Process points in parallel
-	for i := 0; i < numCPU && i*batchSize < len(points); i++ {
-		wg.Add(1)
-		start := i * batchSize
-		end := start + batchSize
-		if end > len(points) {
-			end = len(points)
-		}
-		
-		go func(start, end int) {
-			defer wg.Done()
-			for j := start; j < end; j++ {
-				point := points[j]
-				if point == nil {
-					continue
-				}
-				rtPoint := rtreego.Point{point.Lat, point.Lon}
-				rect := rtPoint.ToRect(tolerance)
-				spatialItems[j] = &spatialItem{point, rect}
-			}
-		}(start, end)
-	}
-
-	wg.Wait()
+// Index returns the underlying rtree index
+func (g *GeoIndex) Index() *rtree.GeoIndex {
+	return g.index
+}
 
-	// Insert items into the tree (this part must be synchronized)
-	g.mu.Lock()
-	defer g.mu.Unlock()
-	
-	count := int64(0)
-	for _, item := range spatialItems {
-		if item != nil {
-			g.tree.Insert(item)
-			count++
+// IndexPoints indexes a batch of points. Nil points are skipped.
+func (g *GeoIndex) IndexPoints(points []*Point) {
+	converted := make([]*models.Point, 0, len(points))
+	for _, p := range points {
+		if p != nil {
+			converted = append(converted, &models.Point{ID: p.ID, Location: &models.Location{Lat: p.Lat, Lon: p.Lon}})
 		}
 	}
-	g.itemCount.Add(count)
+	// rtree.GeoIndex.IndexPoints never fails for points with a location
+	_ = g.index.IndexPoints(converted)
 }
 
 // SearchBox returns all points within the given bounding box
 // Box is defined by bottom-left (latBL, lonBL) and top-right (latTR, lonTR) corners
 func (g *GeoIndex) SearchBox(latBL, lonBL, latTR, lonTR float64) ([]*Point, error) {
-	g.mu.RLock()
-	defer g.mu.RUnlock()
-
-	// Create bounding box
-	bottomLeftPoint := rtreego.Point{latBL, lonBL}
-	rectSize := []float64{latTR - latBL, lonTR - lonBL}
-	
-	bounds, err := rtreego.NewRect(bottomLeftPoint, rectSize)
+	results, err := g.index.QueryBox(models.BoundingBox{
+		BottomLeft: models.Location{Lat: latBL, Lon: lonBL},
+		TopRight:   models.Location{Lat: latTR, Lon: lonTR},
+	})
 	if err != nil {
-		return nil, fmt.Errorf("invalid bounding box: %w", err)
+		return nil, err
 	}
-
-	// Search for intersecting items
-	results := g.tree.SearchIntersect(bounds)
-	
-	// Filter results to ensure they're actually within the box
-	points := make([]*Point, 0, len(results))
-	for _, result := range results {
-		item, ok := result.(*spatialItem)
-		if !ok || item.Point == nil {
-			continue
-		}
-		
-		// Verify point is within the box boundaries
-		if item.Lat >= latBL && item.Lat <= latTR &&
-			item.Lon >= lonBL && item.Lon <= lonTR {
-			points = append(points, item.Point)
-		}
-	}
-
-	return points, nil
+	return fromModels(results), nil
 }
 
 // SearchRadius returns all points within the given radius (in km) from the center point
 func (g *GeoIndex) SearchRadius(centerLat, centerLon float64, radiusKm float64) ([]*Point, error) {
-	g.mu.RLock()
-	defer g.mu.RUnlock()
-
-	// Convert radius to degrees (approximation)
-	deg := (radiusKm / earthRadius) * (180 / math.Pi)
-	
-	// Create bounding box
-	bounds, err := rtreego.NewRect(
-		rtreego.Point{centerLat - deg, centerLon - deg},
-		[]float64{2 * deg, 2 * deg},
-	)
+	results, err := g.index.QueryRadius(models.Location{Lat: centerLat, Lon: centerLon}, radiusKm)
 	if err != nil {
-		return nil, fmt.Errorf("invalid radius search: %w", err)
+		return nil, err
 	}
-
-	// Search for intersecting items
-	results := g.tree.SearchIntersect(bounds)
-	
-	// Filter by actual distance
-	points := make([]*Point, 0, len(results))
-	for _, result := range results {
-		item, ok := result.(*spatialItem)
-		if !ok || item.Point == nil {
-			continue
-		}
-		
-		// Calculate actual distance
-		dist := haversineDistance(centerLat, centerLon, item.Lat, item.Lon)
-		if dist <= radiusKm {
-			points = append(points, item.Point)
-		}
-	}
-
-	return points, nil
+	return fromModels(results), nil
 }
 
 // NearestNeighbors returns the N nearest points to the given location
 func (g *GeoIndex) NearestNeighbors(lat, lon float64, n int) []*Point {
-	g.mu.RLock()
-	defer g.mu.RUnlock()
-
-	queryPoint := rtreego.Point{lat, lon}
-	results := g.tree.NearestNeighbors(n, queryPoint)
-	
-	points := make([]*Point, len(results))
-	for i, result := range results {
-		points[i] = result.(*spatialItem).Point
-	}
-
-	return points
+	return fromModels(g.index.NearestNeighbors(models.Location{Lat: lat, Lon: lon}, n))
 }
 
 // Size returns the number of points in the index
 func (g *GeoIndex) Size() int64 {
-	return g.itemCount.Load()
+	return g.index.Count()
 }
 
 // Clear removes all points from the index
 func (g *GeoIndex) Clear() {
-	g.mu.Lock()
-	defer g.mu.Unlock()
-	
-	g.tree = rtreego.NewTree(dimensions, minChildren, maxChildren)
-	g.itemCount.Store(0)
+	g.index.Clear()
 }
 
-// SaveToFile saves the index to a file using gob encoding
+// SaveToFile saves the index to a file in the pkg/rtree format
 func (g *GeoIndex) SaveToFile(filename string) error {
-	g.mu.RLock()
-	defer g.mu.RUnlock()
-
-	file, err := os.Create(filename)
-	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
-	}
-	defer file.Close()
-
-	// Collect all points by searching with a very large bounding box
-	var points []*Point
-	largeBounds, _ := rtreego.NewRect(rtreego.Point{-90, -180}, []float64{180, 360})
-	results := g.tree.SearchIntersect(largeBounds)
-	
-	for _, result := range results {
-		if item, ok := result.(*spatialItem); ok {
-			points = append(points, item.Point)
-		}
-	}
-
-	// Encode points
-	encoder := gob.NewEncoder(file)
-	if err := encoder.Encode(points); err != nil {
-		return fmt.Errorf("failed to encode index: %w", err)
-	}
-
-	return nil
+	return g.index.SaveToFile(filename)
 }
 
 // LoadFromFile loads the index from a file
 func (g *GeoIndex) LoadFromFile(filename string) error {
-	file, err := os.Open(filename)
-	if err != nil {
-		return fmt.Errorf("failed to open file: %w", err)
-	}
-	defer file.Close()
+	return g.index.LoadFromFile(filename)
+}
 
-	// Decode points
-	var points []*Point
-	decoder := gob.NewDecoder(file)
-	if err := decoder.Decode(&points); err != nil {
-		return fmt.Errorf("failed to decode index: %w", err)
+func fromModels(points []*models.Point) []*Point {
+	converted := make([]*Point, 0, len(points))
+	for _, p := range points {
+		if p.Location != nil {
+			converted = append(converted, &Point{ID: p.ID, Lat: p.Location.Lat, Lon: p.Location.Lon})
+		}
 	}
-
-	// Clear existing index and re-index points
-	g.Clear()
-	g.IndexPoints(points)
-
-	return nil
+	return converted
 }
-
-// haversineDistance calculates the distance between two lat/lon points in kilometers
-func haversineDistance(lat1, lon1, lat2, lon2 float64) float64 {
-	lat1Rad := lat1 * math.Pi / 180.0
-	lon1Rad := lon1 * math.Pi / 180.0
-	lat2Rad := lat2 * math.Pi / 180.0
-	lon2Rad := lon2 * math.Pi / 180.0
-
-	dLat := lat2Rad - lat1Rad
-	dLon := lon2Rad - lon1Rad
-
-	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
-		math.Cos(lat1Rad)*math.Cos(lat2Rad)*
-			math.Sin(dLon/2)*math.Sin(dLon/2)
-
-	c := 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
-	return earthRadius * c
-}
\ No newline at end of file
@@ -1,11 +1,16 @@
 package geo
 
 import (
+	"encoding/gob"
 	"fmt"
 	"math"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
+
+	"github.com/1F47E/geo-index-rtree/pkg/rtree"
 )
 
 func TestIndexAndSearchBox(t *testing.T) {
@@ -122,6 +127,42 @@ func TestParallelIndexing(t *testing.T) {
 	}
 }
 
+func TestPersistence(t *testing.T) {
+	dir := t.TempDir()
+	index := NewGeoIndex()
+	index.IndexPoints([]*Point{{ID: "NY", Lat: 40.7128, Lon: -74.0060}, nil})
+
+	// Saved files are readable by pkg/rtree
+	path := filepath.Join(dir, "index.gob")
+	if err := index.SaveToFile(path); err != nil {
+		t.Fatalf("SaveToFile failed: %v", err)
+	}
+	loaded := rtree.NewGeoIndex()
+	if err := loaded.LoadFromFile(path); err != nil || loaded.Count() != 1 {
+		t.Fatalf("rtree load: count %d, err %v", loaded.Count(), err)
+	}
+
+	// Files in the format this package used to write still load
+	legacy := filepath.Join(dir, "legacy.gob")
+	file, err := os.Create(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gob.NewEncoder(file).Encode([]*Point{{ID: "a", Lat: 1, Lon: 2}, {ID: "b", Lat: 3, Lon: 4}}); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	index = NewGeoIndex()
+	if err := index.LoadFromFile(legacy); err != nil {
+		t.Fatalf("LoadFromFile failed: %v", err)
+	}
+	results, _ := index.SearchBox(0, 0, 2, 3)
+	if index.Size() != 2 || len(results) != 1 || results[0].ID != "a" {
+		t.Errorf("legacy load: size %d, results %v", index.Size(), results)
+	}
+}
+
 func BenchmarkSearchBox(b *testing.B) {
 	index := NewGeoIndex()
 	
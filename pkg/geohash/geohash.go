@@ -0,0 +1,221 @@
+// Package geohash encodes coordinates as geohash strings, decodes them back to
+// cells and finds neighboring cells. Each character adds 5 bits, alternating
+// between longitude and latitude, so a 7 character hash is a ~150m cell.
+package geohash
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+)
+
+const (
+	base32 = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+	// MaxPrecision is the longest hash supported; 12 characters is ~4cm
+	MaxPrecision = 12
+	// DefaultPrecision is a ~5m cell
+	DefaultPrecision = 9
+)
+
+// decodeMap maps a base32 character to its 5-bit value, or -1
+var decodeMap [256]int8
+
+func init() {
+	for i := range decodeMap {
+		decodeMap[i] = -1
+	}
+	for i := 0; i < len(base32); i++ {
+		decodeMap[base32[i]] = int8(i)
+	}
+}
+
+// Direction identifies one of the eight neighbors of a cell
+type Direction int
+
+// Neighbor directions, clockwise from north
+const (
+	North Direction = iota
+	NorthEast
+	East
+	SouthEast
+	South
+	SouthWest
+	West
+	NorthWest
+)
+
+var directionNames = [...]string{"n", "ne", "e", "se", "s", "sw", "w", "nw"}
+
+// String returns the short compass name of the direction, e.g. "ne"
+func (d Direction) String() string {
+	if d < North || d > NorthWest {
+		return fmt.Sprintf("Direction(%d)", int(d))
+	}
+	return directionNames[d]
+}
+
+// offset returns the direction as a step in cells
+func (d Direction) offset() (dLat, dLon float64) {
+	switch d {
+	case North:
+		return 1, 0
+	case NorthEast:
+		return 1, 1
+	case East:
+		return 0, 1
+	case SouthEast:
+		return -1, 1
+	case South:
+		return -1, 0
+	case SouthWest:
+		return -1, -1
+	case West:
+		return 0, -1
+	default:
+		return 1, -1
+	}
+}
+
+// Encode returns the geohash of a coordinate with the given number of
+// characters. Precision is clamped to 1..MaxPrecision.
+func Encode(lat, lon float64, precision int) string {
+	if precision < 1 {
+		precision = 1
+	}
+	if precision > MaxPrecision {
+		precision = MaxPrecision
+	}
+
+	latMin, latMax := -90.0, 90.0
+	lonMin, lonMax := -180.0, 180.0
+	var sb strings.Builder
+	sb.Grow(precision)
+
+	even := true // bits alternate, starting with longitude
+	for sb.Len() < precision {
+		ch := 0
+		for bit := 0; bit < 5; bit++ {
+			ch <<= 1
+			if even {
+				mid := (lonMin + lonMax) / 2
+				if lon >= mid {
+					ch |= 1
+					lonMin = mid
+				} else {
+					lonMax = mid
+				}
+			} else {
+				mid := (latMin + latMax) / 2
+				if lat >= mid {
+					ch |= 1
+					latMin = mid
+				} else {
+					latMax = mid
+				}
+			}
+			even = !even
+		}
+		sb.WriteByte(base32[ch])
+	}
+	return sb.String()
+}
+
+// DecodeBounds returns the cell covered by a geohash. Hashes are case-insensitive.
+func DecodeBounds(hash string) (models.BoundingBox, error) {
+	if hash == "" {
+		return models.BoundingBox{}, fmt.Errorf("empty geohash")
+	}
+	if len(hash) > MaxPrecision {
+		return models.BoundingBox{}, fmt.Errorf("geohash %q longer than %d characters", hash, MaxPrecision)
+	}
+
+	latMin, latMax := -90.0, 90.0
+	lonMin, lonMax := -180.0, 180.0
+	even := true
+	for i := 0; i < len(hash); i++ {
+		c := hash[i]
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		v := decodeMap[c]
+		if v < 0 {
+			return models.BoundingBox{}, fmt.Errorf("invalid geohash character %q in %q", hash[i], hash)
+		}
+		for bit := 4; bit >= 0; bit-- {
+			set := v>>bit&1 == 1
+			if even {
+				mid := (lonMin + lonMax) / 2
+				if set {
+					lonMin = mid
+				} else {
+					lonMax = mid
+				}
+			} else {
+				mid := (latMin + latMax) / 2
+				if set {
+					latMin = mid
+				} else {
+					latMax = mid
+				}
+			}
+			even = !even
+		}
+	}
+
+	return models.BoundingBox{
+		BottomLeft: models.Location{Lat: latMin, Lon: lonMin},
+		TopRight:   models.Location{Lat: latMax, Lon: lonMax},
+	}, nil
+}
+
+// Decode returns the center of the cell covered by a geohash
+func Decode(hash string) (lat, lon float64, err error) {
+	box, err := DecodeBounds(hash)
+	if err != nil {
+		return 0, 0, err
+	}
+	return (box.BottomLeft.Lat + box.TopRight.Lat) / 2, (box.BottomLeft.Lon + box.TopRight.Lon) / 2, nil
+}
+
+// Neighbor returns the adjacent cell of the same precision in direction dir.
+// Cells wrap around the antimeridian; there is no neighbor beyond a pole, in
+// which case an empty string is returned.
+func Neighbor(hash string, dir Direction) (string, error) {
+	if dir < North || dir > NorthWest {
+		return "", fmt.Errorf("invalid direction %d", int(dir))
+	}
+	box, err := DecodeBounds(hash)
+	if err != nil {
+		return "", err
+	}
+
+	height := box.TopRight.Lat - box.BottomLeft.Lat
+	width := box.TopRight.Lon - box.BottomLeft.Lon
+	dLat, dLon := dir.offset()
+
+	lat := (box.BottomLeft.Lat+box.TopRight.Lat)/2 + dLat*height
+	if lat > 90 || lat < -90 {
+		return "", nil
+	}
+	lon := (box.BottomLeft.Lon+box.TopRight.Lon)/2 + dLon*width
+	lon = math.Mod(lon+540, 360) - 180
+
+	return Encode(lat, lon, len(hash)), nil
+}
+
+// Neighbors returns the eight adjacent cells, indexed by Direction. Entries
+// beyond a pole are empty strings.
+func Neighbors(hash string) ([8]string, error) {
+	var neighbors [8]string
+	for dir := North; dir <= NorthWest; dir++ {
+		n, err := Neighbor(hash, dir)
+		if err != nil {
+			return neighbors, err
+		}
+		neighbors[dir] = n
+	}
+	return neighbors, nil
+}
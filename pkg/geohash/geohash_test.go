@@ -0,0 +1,89 @@
+package geohash
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncode(t *testing.T) {
+	assert.Equal(t, "u4pruydqqvj", Encode(57.64911, 10.40744, 11))
+	assert.Equal(t, "ezs42", Encode(42.605, -5.603, 5))
+	assert.Equal(t, "9q8yyk8yt", Encode(37.7749, -122.4194, DefaultPrecision))
+
+	// Precision is clamped
+	assert.Len(t, Encode(0, 0, 0), 1)
+	assert.Len(t, Encode(0, 0, 50), MaxPrecision)
+}
+
+func TestDecode(t *testing.T) {
+	lat, lon, err := Decode("u4pruydqqvj")
+	require.NoError(t, err)
+	assert.InDelta(t, 57.64911, lat, 1e-5)
+	assert.InDelta(t, 10.40744, lon, 1e-5)
+
+	box, err := DecodeBounds("EZS42")
+	require.NoError(t, err)
+	assert.LessOrEqual(t, box.BottomLeft.Lat, 42.605)
+	assert.GreaterOrEqual(t, box.TopRight.Lat, 42.605)
+	assert.LessOrEqual(t, box.BottomLeft.Lon, -5.603)
+	assert.GreaterOrEqual(t, box.TopRight.Lon, -5.603)
+
+	for _, hash := range []string{"", "u4pa", "u4pruydqqvjxx"} {
+		_, err := DecodeBounds(hash)
+		assert.Error(t, err, hash)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, c := range [][2]float64{{0, 0}, {-33.8688, 151.2093}, {89.9, 179.9}, {-89.9, -179.9}} {
+		hash := Encode(c[0], c[1], 8)
+		box, err := DecodeBounds(hash)
+		require.NoError(t, err)
+		assert.True(t, box.BottomLeft.Lat <= c[0] && c[0] <= box.TopRight.Lat, hash)
+		assert.True(t, box.BottomLeft.Lon <= c[1] && c[1] <= box.TopRight.Lon, hash)
+	}
+}
+
+func TestNeighbors(t *testing.T) {
+	hash := "u4pruyd"
+	box, _ := DecodeBounds(hash)
+	neighbors, err := Neighbors(hash)
+	require.NoError(t, err)
+
+	for dir, n := range neighbors {
+		require.Len(t, n, len(hash), Direction(dir).String())
+		nbox, err := DecodeBounds(n)
+		require.NoError(t, err)
+
+		dLat, dLon := Direction(dir).offset()
+		switch dLat {
+		case 1:
+			assert.InDelta(t, box.TopRight.Lat, nbox.BottomLeft.Lat, 1e-9)
+		case -1:
+			assert.InDelta(t, box.BottomLeft.Lat, nbox.TopRight.Lat, 1e-9)
+		default:
+			assert.InDelta(t, box.BottomLeft.Lat, nbox.BottomLeft.Lat, 1e-9)
+		}
+		switch dLon {
+		case 1:
+			assert.InDelta(t, box.TopRight.Lon, nbox.BottomLeft.Lon, 1e-9)
+		case -1:
+			assert.InDelta(t, box.BottomLeft.Lon, nbox.TopRight.Lon, 1e-9)
+		default:
+			assert.InDelta(t, box.BottomLeft.Lon, nbox.BottomLeft.Lon, 1e-9)
+		}
+	}
+
+	// Wraps across the antimeridian
+	east, err := Neighbor(Encode(0.1, 179.99, 5), East)
+	require.NoError(t, err)
+	ebox, _ := DecodeBounds(east)
+	assert.Equal(t, -180.0, ebox.BottomLeft.Lon)
+
+	// Nothing beyond the pole
+	north, err := Neighbor(Encode(89.99, 0, 5), North)
+	require.NoError(t, err)
+	assert.Empty(t, north)
+}
@@ -0,0 +1,185 @@
+// Package heatmap renders density grids as PNG heatmaps and as character maps
+// for the terminal. Empty cells are transparent in images, so images of Web
+// Mercator grids can be laid over web map tiles or image overlays in Leaflet,
+// Mapbox and OpenLayers.
+package heatmap
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/1F47E/geo-index-rtree/pkg/rtree"
+)
+
+// TileSize is the width and height in pixels of a web map tile
+const TileSize = 256
+
+// Ramp maps density to color: the first color is used for the sparsest
+// non-empty cells, the last for the densest and the ones between are spaced
+// evenly and blended
+type Ramp []color.NRGBA
+
+// Ramps are the built-in color ramps by name
+var Ramps = map[string]Ramp{
+	"heat": {
+		{R: 0, G: 0, B: 255, A: 96},
+		{R: 0, G: 255, B: 255, A: 160},
+		{R: 0, G: 255, B: 0, A: 192},
+		{R: 255, G: 255, B: 0, A: 224},
+		{R: 255, G: 0, B: 0, A: 255},
+	},
+	"viridis": {
+		{R: 68, G: 1, B: 84, A: 255},
+		{R: 59, G: 82, B: 139, A: 255},
+		{R: 33, G: 145, B: 140, A: 255},
+		{R: 94, G: 201, B: 98, A: 255},
+		{R: 253, G: 231, B: 37, A: 255},
+	},
+	"gray": {
+		{R: 0, G: 0, B: 0, A: 64},
+		{R: 0, G: 0, B: 0, A: 255},
+	},
+}
+
+// DefaultRamp is the ramp used when Options.Ramp is empty
+const DefaultRamp = "heat"
+
+// ParseRamp returns the built-in ramp with the given name, or a ramp of
+// comma-separated hex colors such as "#0000ff80,#ff0000" (alpha optional)
+func ParseRamp(s string) (Ramp, error) {
+	if ramp, ok := Ramps[strings.ToLower(s)]; ok {
+		return ramp, nil
+	}
+	if !strings.Contains(s, "#") {
+		names := make([]string, 0, len(Ramps))
+		for name := range Ramps {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown color ramp %q (use %s or a list of hex colors)", s, strings.Join(names, ", "))
+	}
+
+	var ramp Ramp
+	for _, part := range strings.Split(s, ",") {
+		c, err := parseHexColor(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		ramp = append(ramp, c)
+	}
+	if len(ramp) < 2 {
+		return nil, fmt.Errorf("color ramp %q needs at least two colors", s)
+	}
+	return ramp, nil
+}
+
+// parseHexColor parses #rrggbb or #rrggbbaa
+func parseHexColor(s string) (color.NRGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if len(hex) != 8 || err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid color %q: want #rrggbb or #rrggbbaa", s)
+	}
+	return color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
+
+// At returns the color at t in [0, 1], blending the two nearest stops
+func (r Ramp) At(t float64) color.NRGBA {
+	if len(r) == 1 {
+		return r[0]
+	}
+	pos := math.Min(math.Max(t, 0), 1) * float64(len(r)-1)
+	i := min(int(pos), len(r)-2)
+	f := pos - float64(i)
+	blend := func(a, b uint8) uint8 {
+		return uint8(math.Round(float64(a) + (float64(b)-float64(a))*f))
+	}
+	a, b := r[i], r[i+1]
+	return color.NRGBA{R: blend(a.R, b.R), G: blend(a.G, b.G), B: blend(a.B, b.B), A: blend(a.A, b.A)}
+}
+
+// Options controls how counts are colored
+type Options struct {
+	// Ramp colors the cells; DefaultRamp when empty
+	Ramp Ramp
+	// Linear scales colors by count rather than by log(1+count). Point
+	// densities are usually heavy-tailed, so the log scale keeps sparse
+	// areas visible next to cities.
+	Linear bool
+	// Max is the count given the last ramp color, the grid's Max when zero.
+	// Set it to keep colors comparable across tiles.
+	Max int64
+}
+
+// scale returns a function mapping a cell count of grid to [0, 1]
+func (opts Options) scale(grid *rtree.DensityGrid) func(n int64) float64 {
+	maxCount := opts.Max
+	if maxCount <= 0 {
+		maxCount = grid.Max
+	}
+	return func(n int64) float64 {
+		if opts.Linear {
+			return float64(n) / float64(maxCount)
+		}
+		return math.Log1p(float64(n)) / math.Log1p(float64(maxCount))
+	}
+}
+
+// Render draws grid as an image with one pixel per cell. Empty cells are
+// transparent.
+func Render(grid *rtree.DensityGrid, opts Options) *image.NRGBA {
+	ramp := opts.Ramp
+	if len(ramp) == 0 {
+		ramp = Ramps[DefaultRamp]
+	}
+	scale := opts.scale(grid)
+
+	img := image.NewNRGBA(image.Rect(0, 0, grid.Cols, grid.Rows))
+	for row, counts := range grid.Counts {
+		for col, n := range counts {
+			if n > 0 {
+				img.SetNRGBA(col, row, ramp.At(scale(n)))
+			}
+		}
+	}
+	return img
+}
+
+// Encode renders grid and writes it to w as a PNG
+func Encode(w io.Writer, grid *rtree.DensityGrid, opts Options) error {
+	if err := png.Encode(w, Render(grid, opts)); err != nil {
+		return fmt.Errorf("failed to encode heatmap: %w", err)
+	}
+	return nil
+}
+
+// TileBox returns the bounds of web map tile x, y at zoom z in the XYZ
+// scheme used by OpenStreetMap and Google Maps, with y growing southward
+func TileBox(z, x, y int) (models.BoundingBox, error) {
+	if z < 0 || z > 30 {
+		return models.BoundingBox{}, fmt.Errorf("zoom %d out of range [0, 30]", z)
+	}
+	n := 1 << z
+	if x < 0 || x >= n || y < 0 || y >= n {
+		return models.BoundingBox{}, fmt.Errorf("tile %d/%d/%d does not exist", z, x, y)
+	}
+	lon := func(x int) float64 { return float64(x)/float64(n)*360 - 180 }
+	lat := func(y int) float64 {
+		return math.Atan(math.Sinh(math.Pi*(1-2*float64(y)/float64(n)))) * 180 / math.Pi
+	}
+	return models.BoundingBox{
+		BottomLeft: models.Location{Lat: lat(y + 1), Lon: lon(x)},
+		TopRight:   models.Location{Lat: lat(y), Lon: lon(x + 1)},
+	}, nil
+}
@@ -0,0 +1,91 @@
+package heatmap
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/1F47E/geo-index-rtree/pkg/rtree"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRamp(t *testing.T) {
+	ramp, err := ParseRamp("Viridis")
+	require.NoError(t, err)
+	assert.Equal(t, Ramps["viridis"], ramp)
+
+	ramp, err = ParseRamp("#0000ff80, #ff0000")
+	require.NoError(t, err)
+	assert.Equal(t, Ramp{{B: 255, A: 128}, {R: 255, A: 255}}, ramp)
+	assert.Equal(t, color.NRGBA{R: 128, B: 128, A: 192}, ramp.At(0.5))
+	assert.Equal(t, ramp[0], ramp.At(-1))
+	assert.Equal(t, ramp[1], ramp.At(2))
+
+	for _, bad := range []string{"rainbow", "#ff0000", "#ff00", "#gg0000,#ff0000"} {
+		_, err := ParseRamp(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestRender(t *testing.T) {
+	grid := &rtree.DensityGrid{
+		Rows: 2, Cols: 3,
+		Counts: [][]int64{{0, 1, 10}, {100, 0, 0}},
+		Max:    100,
+	}
+	ramp := Ramp{{A: 255}, {R: 255, A: 255}}
+
+	img := Render(grid, Options{Ramp: ramp, Linear: true})
+	assert.Equal(t, 3, img.Bounds().Dx())
+	assert.Equal(t, 2, img.Bounds().Dy())
+	assert.Equal(t, color.NRGBA{}, img.NRGBAAt(0, 0))
+	assert.Equal(t, color.NRGBA{R: 3, A: 255}, img.NRGBAAt(1, 0))
+	assert.Equal(t, color.NRGBA{R: 255, A: 255}, img.NRGBAAt(0, 1))
+
+	// The log scale lifts sparse cells; a fixed max dims the densest one
+	logImg := Render(grid, Options{Ramp: ramp})
+	assert.Greater(t, logImg.NRGBAAt(1, 0).R, img.NRGBAAt(1, 0).R)
+	dimmed := Render(grid, Options{Ramp: ramp, Linear: true, Max: 1000})
+	assert.Equal(t, uint8(26), dimmed.NRGBAAt(0, 1).R)
+
+	var buf bytes.Buffer
+	require.NoError(t, Encode(&buf, grid, Options{}))
+	decoded, err := png.Decode(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, img.Bounds(), decoded.Bounds())
+}
+
+func TestTileBox(t *testing.T) {
+	box, err := TileBox(0, 0, 0)
+	require.NoError(t, err)
+	assert.InDelta(t, -180, box.BottomLeft.Lon, 1e-9)
+	assert.InDelta(t, 180, box.TopRight.Lon, 1e-9)
+	assert.InDelta(t, rtree.MaxMercatorLat, box.TopRight.Lat, 1e-9)
+	assert.InDelta(t, -rtree.MaxMercatorLat, box.BottomLeft.Lat, 1e-9)
+
+	// Tile 10/163/395 covers San Francisco
+	box, err = TileBox(10, 163, 395)
+	require.NoError(t, err)
+	assert.True(t, box.Contains(models.Location{Lat: 37.7749, Lon: -122.4194}))
+
+	for _, bad := range [][3]int{{-1, 0, 0}, {31, 0, 0}, {1, 2, 0}, {1, 0, -1}} {
+		_, err := TileBox(bad[0], bad[1], bad[2])
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestText(t *testing.T) {
+	grid := &rtree.DensityGrid{
+		Rows: 2, Cols: 4,
+		Counts: [][]int64{{0, 1, 2, 100}, {100, 0, 0, 50}},
+		Max:    100,
+	}
+	assert.Equal(t, " ::@\n@  #\n", Text(grid, Options{}))
+	assert.Equal(t, " ..@\n@  +\n", Text(grid, Options{Linear: true}))
+
+	// Cells above a fixed max are clamped to the densest shade
+	assert.Equal(t, " .:@\n@  @\n", Text(grid, Options{Linear: true, Max: 10}))
+}
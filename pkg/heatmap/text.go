@@ -0,0 +1,34 @@
+package heatmap
+
+import (
+	"strings"
+
+	"github.com/1F47E/geo-index-rtree/pkg/rtree"
+)
+
+// Shades are the characters Text draws from the sparsest to the densest
+// cells. Empty cells are blank.
+const Shades = ".:-=+*#%@"
+
+// Text draws grid as lines of characters, one per cell, shaded by count on
+// the scale chosen by opts; Ramp is ignored. An Equirectangular grid with
+// about twice as many columns as rows gives a world map that looks right in
+// a terminal.
+func Text(grid *rtree.DensityGrid, opts Options) string {
+	scale := opts.scale(grid)
+
+	var b strings.Builder
+	b.Grow(grid.Rows * (grid.Cols + 1))
+	for _, counts := range grid.Counts {
+		for _, n := range counts {
+			if n <= 0 {
+				b.WriteByte(' ')
+				continue
+			}
+			i := int(scale(n) * float64(len(Shades)-1))
+			b.WriteByte(Shades[max(min(i, len(Shades)-1), 0)])
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
@@ -0,0 +1,147 @@
+// Package latency records query latencies in an HDR-style log-linear
+// histogram, giving percentiles with bounded relative error in fixed memory
+// regardless of how many samples are recorded
+package latency
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+const (
+	// subBucketBits sets the precision: each power-of-two range is split into
+	// 2^(subBucketBits-1) linear buckets, so values are kept to within 1/64 (~1.6%)
+	subBucketBits  = 7
+	subBucketCount = 1 << subBucketBits
+	subBucketHalf  = subBucketCount / 2
+	numBuckets     = subBucketCount + (64-subBucketBits)*subBucketHalf
+)
+
+// Histogram accumulates durations. It is not safe for concurrent use; record
+// into one histogram per goroutine and Merge them, or guard it with a mutex.
+type Histogram struct {
+	counts []int64
+	total  int64
+	sum    time.Duration
+	min    time.Duration
+	max    time.Duration
+}
+
+// NewHistogram creates an empty histogram
+func NewHistogram() *Histogram {
+	return &Histogram{
+		counts: make([]int64, numBuckets),
+		min:    time.Duration(math.MaxInt64),
+	}
+}
+
+// Record adds a single sample. Negative durations are recorded as zero.
+func (h *Histogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	h.counts[bucketIndex(uint64(d))]++
+	h.total++
+	h.sum += d
+	if d < h.min {
+		h.min = d
+	}
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// Merge adds all samples from other into h
+func (h *Histogram) Merge(other *Histogram) {
+	if other == nil || other.total == 0 {
+		return
+	}
+	for i, c := range other.counts {
+		h.counts[i] += c
+	}
+	h.total += other.total
+	h.sum += other.sum
+	if other.min < h.min {
+		h.min = other.min
+	}
+	if other.max > h.max {
+		h.max = other.max
+	}
+}
+
+// Count returns the number of recorded samples
+func (h *Histogram) Count() int64 {
+	return h.total
+}
+
+// Min returns the smallest recorded sample
+func (h *Histogram) Min() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return h.min
+}
+
+// Max returns the largest recorded sample
+func (h *Histogram) Max() time.Duration {
+	return h.max
+}
+
+// Mean returns the exact average of all samples
+func (h *Histogram) Mean() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.total)
+}
+
+// Percentile returns the sample value at or below which p percent of samples
+// fall, e.g. Percentile(99.9). The result is the upper edge of the matching
+// bucket, clamped to the recorded min and max.
+func (h *Histogram) Percentile(p float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	target := int64(math.Ceil(p / 100 * float64(h.total)))
+	if target < 1 {
+		target = 1
+	}
+
+	var seen int64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= target {
+			v := time.Duration(bucketUpper(i))
+			if v > h.max {
+				v = h.max
+			}
+			if v < h.min {
+				v = h.min
+			}
+			return v
+		}
+	}
+	return h.max
+}
+
+// bucketIndex maps a value to its bucket: values below subBucketCount get an
+// exact bucket, larger values share a bucket with others of the same top bits
+func bucketIndex(v uint64) int {
+	if v < subBucketCount {
+		return int(v)
+	}
+	shift := bits.Len64(v) - subBucketBits
+	return subBucketCount + (shift-1)*subBucketHalf + int(v>>shift) - subBucketHalf
+}
+
+// bucketUpper returns the largest value that maps to bucket i
+func bucketUpper(i int) uint64 {
+	if i < subBucketCount {
+		return uint64(i)
+	}
+	i -= subBucketCount
+	shift := i/subBucketHalf + 1
+	top := uint64(i%subBucketHalf + subBucketHalf)
+	return (top+1)<<shift - 1
+}
@@ -0,0 +1,63 @@
+package latency
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBucketRoundTrip(t *testing.T) {
+	for _, v := range []uint64{0, 1, 127, 128, 129, 255, 256, 1000, 123456789, 1 << 40, 1<<63 - 1} {
+		i := bucketIndex(v)
+		require.Less(t, i, numBuckets)
+		assert.GreaterOrEqual(t, bucketUpper(i), v, "value %d", v)
+		if i > 0 {
+			assert.Less(t, bucketUpper(i-1), v, "value %d", v)
+		}
+	}
+}
+
+func TestPercentiles(t *testing.T) {
+	h := NewHistogram()
+	r := rand.New(rand.NewSource(1))
+
+	samples := make([]time.Duration, 100000)
+	for i := range samples {
+		// Mostly fast queries with a slow tail
+		d := time.Duration(r.ExpFloat64() * float64(200*time.Microsecond))
+		samples[i] = d
+		h.Record(d)
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+
+	assert.Equal(t, int64(len(samples)), h.Count())
+	assert.Equal(t, samples[0], h.Min())
+	assert.Equal(t, samples[len(samples)-1], h.Max())
+
+	for _, p := range []float64{50, 90, 99, 99.9} {
+		exact := samples[int(p/100*float64(len(samples)))-1]
+		assert.InEpsilon(t, float64(exact), float64(h.Percentile(p)), 0.02, "p%v", p)
+	}
+	assert.Equal(t, h.Max(), h.Percentile(100))
+}
+
+func TestMerge(t *testing.T) {
+	a, b := NewHistogram(), NewHistogram()
+	a.Record(time.Millisecond)
+	b.Record(3 * time.Millisecond)
+	b.Record(5 * time.Millisecond)
+
+	a.Merge(b)
+	assert.Equal(t, int64(3), a.Count())
+	assert.Equal(t, time.Millisecond, a.Min())
+	assert.Equal(t, 5*time.Millisecond, a.Max())
+	assert.Equal(t, 3*time.Millisecond, a.Mean())
+
+	empty := NewHistogram()
+	assert.Equal(t, time.Duration(0), empty.Percentile(99))
+	assert.Equal(t, time.Duration(0), empty.Min())
+}
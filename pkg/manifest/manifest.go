@@ -0,0 +1,130 @@
+// Package manifest records how a benchmark or data generation run was made:
+// its seed, flags, source version and machine, written as JSON next to the
+// run's results so the run can be reproduced when filing performance issues.
+package manifest
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// Manifest describes one run
+type Manifest struct {
+	// Command is the program that made the run, e.g. "benchmark"
+	Command string `json:"command"`
+	// Args are the command line arguments as given
+	Args []string `json:"args"`
+	// Flags holds the value of every flag, defaults included
+	Flags map[string]string `json:"flags"`
+	// Seed determines every random choice of the run
+	Seed int64 `json:"seed"`
+	// GitVersion is the commit the binary was built from, suffixed with
+	// "-dirty" for uncommitted changes, or empty when unknown
+	GitVersion string `json:"git_version,omitempty"`
+	GoVersion  string `json:"go_version"`
+	OS         string `json:"os"`
+	Arch       string `json:"arch"`
+	// CPUModel is the processor model name, empty where it cannot be read
+	CPUModel  string    `json:"cpu_model,omitempty"`
+	CPUCores  int       `json:"cpu_cores"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// New returns the manifest of the running program with the given seed,
+// taking its flags from flag.CommandLine, which must be parsed
+func New(command string, seed int64) Manifest {
+	return FromFlags(command, seed, flag.CommandLine)
+}
+
+// FromFlags returns the manifest of a run configured by the parsed flag set
+// fs
+func FromFlags(command string, seed int64, fs *flag.FlagSet) Manifest {
+	flags := make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) {
+		flags[f.Name] = f.Value.String()
+	})
+	return Manifest{
+		Command:    command,
+		Args:       fs.Args(),
+		Flags:      flags,
+		Seed:       seed,
+		GitVersion: gitVersion(),
+		GoVersion:  runtime.Version(),
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		CPUModel:   cpuModel(),
+		CPUCores:   runtime.NumCPU(),
+		Timestamp:  time.Now().UTC(),
+	}
+}
+
+// PathFor returns the manifest path for a results file: its name with the
+// extension replaced by ".manifest.json"
+func PathFor(results string) string {
+	return strings.TrimSuffix(results, filepath.Ext(results)) + ".manifest.json"
+}
+
+// Write writes m as indented JSON to path
+func (m Manifest) Write(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// gitVersion returns the VCS revision stamped into the binary by go build,
+// or asks git for binaries built by go run, which are not stamped
+func gitVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		var revision, modified string
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				revision = s.Value
+			case "vcs.modified":
+				modified = s.Value
+			}
+		}
+		if revision != "" {
+			if modified == "true" {
+				revision += "-dirty"
+			}
+			return revision
+		}
+	}
+	out, err := exec.Command("git", "describe", "--always", "--dirty", "--abbrev=40").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// cpuModel returns the first model name in /proc/cpuinfo
+func cpuModel() string {
+	f, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if ok && strings.TrimSpace(key) == "model name" {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
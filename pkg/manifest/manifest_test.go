@@ -0,0 +1,38 @@
+package manifest
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifest(t *testing.T) {
+	fs := flag.NewFlagSet("benchmark", flag.ContinueOnError)
+	fs.String("t", "box", "")
+	fs.Int("n", 1000, "")
+	require.NoError(t, fs.Parse([]string{"-t", "radius", "extra"}))
+
+	m := FromFlags("benchmark", 42, fs)
+	assert.Equal(t, map[string]string{"t": "radius", "n": "1000"}, m.Flags)
+	assert.Equal(t, []string{"extra"}, m.Args)
+	assert.Equal(t, int64(42), m.Seed)
+	assert.Equal(t, runtime.NumCPU(), m.CPUCores)
+	assert.Equal(t, runtime.Version(), m.GoVersion)
+
+	path := PathFor(filepath.Join(t.TempDir(), "benchmark_box.json"))
+	assert.Equal(t, "benchmark_box.manifest.json", filepath.Base(path))
+	require.NoError(t, m.Write(path))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var read Manifest
+	require.NoError(t, json.Unmarshal(data, &read))
+	assert.Equal(t, m.Flags, read.Flags)
+	assert.Equal(t, m.Seed, read.Seed)
+	assert.True(t, m.Timestamp.Equal(read.Timestamp))
+}
@@ -0,0 +1,103 @@
+package models
+
+import "fmt"
+
+// GeoJSONGeometry is a GeoJSON geometry object. Coordinates are [lon, lat]
+// positions nested according to Type.
+type GeoJSONGeometry struct {
+	Type        string `json:"type"`
+	Coordinates any    `json:"coordinates"`
+}
+
+// GeoJSONFeature is a GeoJSON Feature
+type GeoJSONFeature struct {
+	Type       string           `json:"type"`
+	ID         string           `json:"id"`
+	Geometry   *GeoJSONGeometry `json:"geometry"`
+	Properties map[string]any   `json:"properties"`
+}
+
+// GeoJSONFeatureCollection is a GeoJSON FeatureCollection
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJSONFeature `json:"features"`
+}
+
+// ToGeoJSON returns the location as a Point geometry, with the altitude as a
+// third coordinate when it is set
+func (l Location) ToGeoJSON() GeoJSONGeometry {
+	if l.Alt != 0 {
+		return GeoJSONGeometry{Type: "Point", Coordinates: []float64{l.Lon, l.Lat, l.Alt}}
+	}
+	return GeoJSONGeometry{Type: "Point", Coordinates: []float64{l.Lon, l.Lat}}
+}
+
+// ToGeoJSON returns the box as a Polygon geometry with a counterclockwise ring
+func (b BoundingBox) ToGeoJSON() GeoJSONGeometry {
+	minLon, minLat := b.BottomLeft.Lon, b.BottomLeft.Lat
+	maxLon, maxLat := b.TopRight.Lon, b.TopRight.Lat
+	return GeoJSONGeometry{
+		Type: "Polygon",
+		Coordinates: [][][]float64{{
+			{minLon, minLat}, {maxLon, minLat}, {maxLon, maxLat}, {minLon, maxLat}, {minLon, minLat},
+		}},
+	}
+}
+
+// ToGeoJSON returns the point as a Feature carrying its properties. A point
+// without a location gets a null geometry.
+func (p *Point) ToGeoJSON() GeoJSONFeature {
+	feature := GeoJSONFeature{Type: "Feature", ID: p.ID, Properties: p.Properties}
+	if feature.Properties == nil {
+		feature.Properties = map[string]any{}
+	}
+	if p.Location != nil {
+		geometry := p.Location.ToGeoJSON()
+		feature.Geometry = &geometry
+	}
+	return feature
+}
+
+// NewGeoJSONFeatureCollection returns points as a FeatureCollection
+func NewGeoJSONFeatureCollection(points []*Point) GeoJSONFeatureCollection {
+	features := make([]GeoJSONFeature, len(points))
+	for i, p := range points {
+		features[i] = p.ToGeoJSON()
+	}
+	return GeoJSONFeatureCollection{Type: "FeatureCollection", Features: features}
+}
+
+// ToPoint converts a Point feature back into a Point. Both constructed
+// features and ones decoded from JSON are accepted.
+func (f GeoJSONFeature) ToPoint() (*Point, error) {
+	if f.Geometry == nil || f.Geometry.Type != "Point" {
+		return nil, fmt.Errorf("feature %q is not a Point", f.ID)
+	}
+
+	var lon, lat float64
+	switch c := f.Geometry.Coordinates.(type) {
+	case []float64:
+		if len(c) < 2 {
+			return nil, fmt.Errorf("feature %q has fewer than 2 coordinates", f.ID)
+		}
+		lon, lat = c[0], c[1]
+	case []any:
+		if len(c) < 2 {
+			return nil, fmt.Errorf("feature %q has fewer than 2 coordinates", f.ID)
+		}
+		var ok1, ok2 bool
+		lon, ok1 = c[0].(float64)
+		lat, ok2 = c[1].(float64)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("feature %q has non-numeric coordinates", f.ID)
+		}
+	default:
+		return nil, fmt.Errorf("feature %q has invalid coordinates", f.ID)
+	}
+
+	point := &Point{ID: f.ID, Location: &Location{Lat: lat, Lon: lon}}
+	if len(f.Properties) > 0 {
+		point.Properties = f.Properties
+	}
+	return point, nil
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeoJSON(t *testing.T) {
+	box := BoundingBox{BottomLeft: Location{Lat: 37, Lon: -123}, TopRight: Location{Lat: 38, Lon: -122}}
+	data, err := json.Marshal(box.ToGeoJSON())
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"type":"Polygon","coordinates":[[[-123,37],[-122,37],[-122,38],[-123,38],[-123,37]]]}`, string(data))
+
+	point := &Point{ID: "SF", Location: &Location{Lat: 37.7749, Lon: -122.4194}, Properties: map[string]any{"name": "San Francisco"}}
+	data, err = json.Marshal(NewGeoJSONFeatureCollection([]*Point{point, {ID: "nowhere"}}))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","id":"SF","geometry":{"type":"Point","coordinates":[-122.4194,37.7749]},"properties":{"name":"San Francisco"}},
+		{"type":"Feature","id":"nowhere","geometry":null,"properties":{}}
+	]}`, string(data))
+
+	// Features round-trip, whether constructed or decoded
+	got, err := point.ToGeoJSON().ToPoint()
+	require.NoError(t, err)
+	assert.Equal(t, point, got)
+
+	var feature GeoJSONFeature
+	data, _ = json.Marshal(point.ToGeoJSON())
+	require.NoError(t, json.Unmarshal(data, &feature))
+	got, err = feature.ToPoint()
+	require.NoError(t, err)
+	assert.Equal(t, point, got)
+
+	_, err = (&Point{ID: "x"}).ToGeoJSON().ToPoint()
+	assert.Error(t, err)
+	_, err = GeoJSONFeature{Geometry: &GeoJSONGeometry{Type: "Point", Coordinates: []any{"a", 1.0}}}.ToPoint()
+	assert.Error(t, err)
+}
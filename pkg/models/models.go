@@ -1,19 +1,328 @@
 package models
 
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// earthRadiusKm is the mean radius of the Earth
+const earthRadiusKm = 6371.0
+
+// kmPerDegree is the length of one degree of latitude
+const kmPerDegree = math.Pi * earthRadiusKm / 180
+
 // Location represents a geographic location with latitude and longitude
 type Location struct {
 	Lat float64 `json:"lat"`
 	Lon float64 `json:"lon"`
+	// Alt is the optional altitude in meters. It is ignored by every distance
+	// except Distance3DTo, and so by indexes not built for altitude.
+	Alt float64 `json:"alt,omitempty"`
+}
+
+// DistanceTo returns the haversine great-circle distance to other in kilometers
+func (l Location) DistanceTo(other Location) float64 {
+	lat1 := l.Lat * math.Pi / 180
+	lat2 := other.Lat * math.Pi / 180
+	dLat := lat2 - lat1
+	dLon := (other.Lon - l.Lon) * math.Pi / 180
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return earthRadiusKm * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// Distance3DTo returns the straight-line combination of the haversine
+// distance to other and the altitude difference, in kilometers. Over the
+// short ranges where altitude matters the curvature of the Earth is
+// negligible.
+func (l Location) Distance3DTo(other Location) float64 {
+	return math.Hypot(l.DistanceTo(other), (other.Alt-l.Alt)/1000)
+}
+
+// BearingTo returns the initial great-circle bearing to other in degrees
+// clockwise from north, in [0, 360)
+func (l Location) BearingTo(other Location) float64 {
+	lat1 := l.Lat * math.Pi / 180
+	lat2 := other.Lat * math.Pi / 180
+	dLon := (other.Lon - l.Lon) * math.Pi / 180
+
+	y := math.Sin(dLon) * math.Cos(lat2)
+	x := math.Cos(lat1)*math.Sin(lat2) - math.Sin(lat1)*math.Cos(lat2)*math.Cos(dLon)
+	bearing := math.Mod(math.Atan2(y, x)*180/math.Pi+360, 360)
+	if bearing >= 360 {
+		bearing = 0
+	}
+	return bearing
+}
+
+// Destination returns the point reached by travelling km along a great circle
+// starting at the given bearing in degrees. The longitude is wrapped into
+// [-180, 180).
+func (l Location) Destination(bearing, km float64) Location {
+	lat1 := l.Lat * math.Pi / 180
+	lon1 := l.Lon * math.Pi / 180
+	theta := bearing * math.Pi / 180
+	delta := km / earthRadiusKm
+
+	lat2 := math.Asin(math.Sin(lat1)*math.Cos(delta) + math.Cos(lat1)*math.Sin(delta)*math.Cos(theta))
+	lon2 := lon1 + math.Atan2(math.Sin(theta)*math.Sin(delta)*math.Cos(lat1),
+		math.Cos(delta)-math.Sin(lat1)*math.Sin(lat2))
+	return Location{
+		Lat: lat2 * 180 / math.Pi,
+		Lon: NormalizeLongitude(lon2 * 180 / math.Pi),
+	}
+}
+
+// Interpolate returns the point the given fraction of the way from l to other
+// along the great circle between them: l at 0, other at 1 and the midpoint
+// at 0.5. Fractions outside [0, 1] extend the path beyond either end. For
+// antipodal points, where every great circle through both is equally short,
+// the path starts along BearingTo. The longitude is wrapped into [-180, 180).
+func (l Location) Interpolate(other Location, fraction float64) Location {
+	lat1, lon1 := l.Lat*math.Pi/180, l.Lon*math.Pi/180
+	lat2, lon2 := other.Lat*math.Pi/180, other.Lon*math.Pi/180
+	x1, y1, z1 := math.Cos(lat1)*math.Cos(lon1), math.Cos(lat1)*math.Sin(lon1), math.Sin(lat1)
+	x2, y2, z2 := math.Cos(lat2)*math.Cos(lon2), math.Cos(lat2)*math.Sin(lon2), math.Sin(lat2)
+
+	// The angle from the cross and dot products stays accurate near 0 and
+	// pi, where the haversine loses precision
+	sinDelta := math.Sqrt(math.Pow(y1*z2-z1*y2, 2) + math.Pow(z1*x2-x1*z2, 2) + math.Pow(x1*y2-y1*x2, 2))
+	cosDelta := x1*x2 + y1*y2 + z1*z2
+	delta := math.Atan2(sinDelta, cosDelta)
+	if sinDelta < 1e-12 {
+		if cosDelta > 0 {
+			return l
+		}
+		return l.Destination(l.BearingTo(other), fraction*delta*earthRadiusKm)
+	}
+
+	// Spherical linear interpolation between the two unit vectors
+	a := math.Sin((1-fraction)*delta) / sinDelta
+	b := math.Sin(fraction*delta) / sinDelta
+	x, y, z := a*x1+b*x2, a*y1+b*y2, a*z1+b*z2
+	return Location{
+		Lat: math.Atan2(z, math.Hypot(x, y)) * 180 / math.Pi,
+		Lon: NormalizeLongitude(math.Atan2(y, x) * 180 / math.Pi),
+	}
+}
+
+// Midpoint returns the point halfway between l and other along the great
+// circle between them
+func (l Location) Midpoint(other Location) Location {
+	return l.Interpolate(other, 0.5)
 }
 
-// Point represents a geo point with an ID and location
+// PathTo returns n points evenly spaced along the great circle from l to
+// other, starting with l and ending with other, e.g. to densify a track
+// before buffering it. n below 2 is treated as 2.
+func (l Location) PathTo(other Location, n int) []Location {
+	n = max(n, 2)
+	path := make([]Location, n)
+	path[0], path[n-1] = l, other
+	for i := 1; i < n-1; i++ {
+		path[i] = l.Interpolate(other, float64(i)/float64(n-1))
+	}
+	return path
+}
+
+// Point represents a geo point with an ID, location and optional properties.
+// Property values are JSON-compatible: strings, numbers, bools, nil, and
+// []any / map[string]any of those.
 type Point struct {
-	ID       string    `json:"id"`
-	Location *Location `json:"location"`
+	ID         string         `json:"id"`
+	Location   *Location      `json:"location"`
+	Properties map[string]any `json:"properties,omitempty"`
+}
+
+// PointDistance pairs a query result with its distance from the query center.
+// Distance is in Unit; DistanceKm is always kilometers.
+type PointDistance struct {
+	Point      *Point  `json:"point"`
+	DistanceKm float64 `json:"distance_km"`
+	Distance   float64 `json:"distance"`
+	Unit       Unit    `json:"unit"`
 }
 
 // BoundingBox represents a rectangular area defined by two corners
 type BoundingBox struct {
-	BottomLeft Location
-	TopRight   Location
-}
\ No newline at end of file
+	BottomLeft Location `json:"bottom_left"`
+	TopRight   Location `json:"top_right"`
+}
+
+// UnmarshalJSON also accepts the untagged BottomLeft/TopRight keys written
+// before the JSON tags existed, e.g. in older index file headers
+func (b *BoundingBox) UnmarshalJSON(data []byte) error {
+	type plain BoundingBox
+	var v struct {
+		plain
+		LegacyBottomLeft *Location `json:"BottomLeft"`
+		LegacyTopRight   *Location `json:"TopRight"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*b = BoundingBox(v.plain)
+	if v.LegacyBottomLeft != nil {
+		b.BottomLeft = *v.LegacyBottomLeft
+	}
+	if v.LegacyTopRight != nil {
+		b.TopRight = *v.LegacyTopRight
+	}
+	return nil
+}
+
+// NewBoundingBoxFromCenter returns the smallest box containing every point
+// within radiusKm of center on a spherical Earth. Longitudes widen with
+// latitude; a circle covering a pole spans every longitude. Latitudes are
+// clamped to the poles, longitudes are left unwrapped and may pass ±180;
+// NewBoundingBoxesFromCenter returns the boxes to search instead.
+func NewBoundingBoxFromCenter(center Location, radiusKm float64) BoundingBox {
+	deg := radiusKm / kmPerDegree
+	box := BoundingBox{
+		BottomLeft: Location{Lat: center.Lat - deg, Lon: -180},
+		TopRight:   Location{Lat: center.Lat + deg, Lon: 180},
+	}
+	if box.BottomLeft.Lat <= -90 || box.TopRight.Lat >= 90 {
+		box.BottomLeft.Lat = math.Max(box.BottomLeft.Lat, -90)
+		box.TopRight.Lat = math.Min(box.TopRight.Lat, 90)
+		return box
+	}
+
+	// Half the longitude span of a circle of angular radius delta
+	delta := radiusKm / earthRadiusKm
+	dLon := math.Asin(math.Sin(delta)/math.Cos(center.Lat*math.Pi/180)) * 180 / math.Pi
+	box.BottomLeft.Lon = center.Lon - dLon
+	box.TopRight.Lon = center.Lon + dLon
+	return box
+}
+
+// NewBoundingBoxesFromCenter returns the boxes to search for the points within
+// radiusKm of center whose longitudes lie in [-180, 180]. It is the box of
+// NewBoundingBoxFromCenter followed, if that passes ±180, by the part beyond
+// moved 360 degrees back into range. The boxes do not overlap.
+func NewBoundingBoxesFromCenter(center Location, radiusKm float64) []BoundingBox {
+	box := NewBoundingBoxFromCenter(center, radiusKm)
+	boxes := []BoundingBox{box}
+	if box.TopRight.Lon > 180 {
+		over := box
+		over.BottomLeft.Lon = math.Max(box.BottomLeft.Lon, 180) - 360
+		over.TopRight.Lon = box.TopRight.Lon - 360
+		boxes = append(boxes, over)
+	}
+	if box.BottomLeft.Lon < -180 {
+		over := box
+		over.BottomLeft.Lon = box.BottomLeft.Lon + 360
+		over.TopRight.Lon = math.Min(box.TopRight.Lon, -180) + 360
+		boxes = append(boxes, over)
+	}
+	return boxes
+}
+
+// Validate checks that the corners are valid coordinates and that BottomLeft
+// is below and left of TopRight
+func (b BoundingBox) Validate() error {
+	for _, c := range []struct {
+		name string
+		loc  Location
+	}{{"bottom-left", b.BottomLeft}, {"top-right", b.TopRight}} {
+		if math.IsNaN(c.loc.Lat) || c.loc.Lat < -90 || c.loc.Lat > 90 {
+			return fmt.Errorf("%s latitude %v outside [-90, 90]", c.name, c.loc.Lat)
+		}
+		if math.IsNaN(c.loc.Lon) || c.loc.Lon < -180 || c.loc.Lon > 180 {
+			return fmt.Errorf("%s longitude %v outside [-180, 180]", c.name, c.loc.Lon)
+		}
+	}
+	if b.BottomLeft.Lat > b.TopRight.Lat || b.BottomLeft.Lon > b.TopRight.Lon {
+		return fmt.Errorf("bottom-left corner must be below and left of top-right corner")
+	}
+	return nil
+}
+
+// Normalize returns the box with its corners swapped per axis where needed so
+// that BottomLeft holds the minimum latitude and longitude
+func (b BoundingBox) Normalize() BoundingBox {
+	if b.BottomLeft.Lat > b.TopRight.Lat {
+		b.BottomLeft.Lat, b.TopRight.Lat = b.TopRight.Lat, b.BottomLeft.Lat
+	}
+	if b.BottomLeft.Lon > b.TopRight.Lon {
+		b.BottomLeft.Lon, b.TopRight.Lon = b.TopRight.Lon, b.BottomLeft.Lon
+	}
+	return b
+}
+
+// Contains reports whether loc lies inside the box or on its edge
+func (b BoundingBox) Contains(loc Location) bool {
+	return loc.Lat >= b.BottomLeft.Lat && loc.Lat <= b.TopRight.Lat &&
+		loc.Lon >= b.BottomLeft.Lon && loc.Lon <= b.TopRight.Lon
+}
+
+// Intersects reports whether the boxes overlap, including touching edges
+func (b BoundingBox) Intersects(other BoundingBox) bool {
+	return b.BottomLeft.Lat <= other.TopRight.Lat && b.TopRight.Lat >= other.BottomLeft.Lat &&
+		b.BottomLeft.Lon <= other.TopRight.Lon && b.TopRight.Lon >= other.BottomLeft.Lon
+}
+
+// Expand returns the box grown by km on every side. Longitudes are scaled for
+// the edge furthest from the equator, so the result covers everything within
+// km of the box. Latitudes are clamped to the poles and a box reaching a pole
+// spans every longitude; longitudes are otherwise left unwrapped and may pass
+// ±180.
+func (b BoundingBox) Expand(km float64) BoundingBox {
+	deg := km / kmPerDegree
+	b.BottomLeft.Lat = math.Max(b.BottomLeft.Lat-deg, -90)
+	b.TopRight.Lat = math.Min(b.TopRight.Lat+deg, 90)
+
+	cos := math.Cos(math.Max(math.Abs(b.BottomLeft.Lat), math.Abs(b.TopRight.Lat)) * math.Pi / 180)
+	if cos < 1e-9 {
+		b.BottomLeft.Lon, b.TopRight.Lon = -180, 180
+		return b
+	}
+	b.BottomLeft.Lon -= deg / cos
+	b.TopRight.Lon += deg / cos
+	return b
+}
+
+// Center returns the midpoint of the box in degrees
+func (b BoundingBox) Center() Location {
+	return Location{
+		Lat: (b.BottomLeft.Lat + b.TopRight.Lat) / 2,
+		Lon: (b.BottomLeft.Lon + b.TopRight.Lon) / 2,
+	}
+}
+
+// AreaKm2 returns the area of the box on a spherical Earth
+func (b BoundingBox) AreaKm2() float64 {
+	lat1 := b.BottomLeft.Lat * math.Pi / 180
+	lat2 := b.TopRight.Lat * math.Pi / 180
+	dLon := (b.TopRight.Lon - b.BottomLeft.Lon) * math.Pi / 180
+	return math.Abs(earthRadiusKm * earthRadiusKm * (math.Sin(lat2) - math.Sin(lat1)) * dLon)
+}
+
+// Union returns the smallest box containing both boxes
+func (b BoundingBox) Union(other BoundingBox) BoundingBox {
+	return BoundingBox{
+		BottomLeft: Location{
+			Lat: math.Min(b.BottomLeft.Lat, other.BottomLeft.Lat),
+			Lon: math.Min(b.BottomLeft.Lon, other.BottomLeft.Lon),
+		},
+		TopRight: Location{
+			Lat: math.Max(b.TopRight.Lat, other.TopRight.Lat),
+			Lon: math.Max(b.TopRight.Lon, other.TopRight.Lon),
+		},
+	}
+}
+
+// NormalizeLongitude wraps a longitude in degrees into [-180, 180), e.g. 190
+// becomes -170 and 180 becomes -180. NaN and infinities are returned unchanged.
+func NormalizeLongitude(lon float64) float64 {
+	if lon >= -180 && lon < 180 || math.IsNaN(lon) || math.IsInf(lon, 0) {
+		return lon
+	}
+	lon = math.Mod(lon+180, 360)
+	if lon < 0 {
+		lon += 360
+	}
+	return lon - 180
+}
@@ -0,0 +1,295 @@
+package models
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeLongitude(t *testing.T) {
+	cases := map[float64]float64{
+		0:      0,
+		-180:   -180,
+		179.5:  179.5,
+		180:    -180,
+		190:    -170,
+		359.5:  -0.5,
+		360:    0,
+		540:    -180,
+		-190:   170,
+		-540:   -180,
+		-725.5: -5.5,
+	}
+	for in, want := range cases {
+		assert.InDelta(t, want, NormalizeLongitude(in), 1e-9, "lon %v", in)
+	}
+
+	assert.True(t, math.IsNaN(NormalizeLongitude(math.NaN())))
+	assert.True(t, math.IsInf(NormalizeLongitude(math.Inf(1)), 1))
+}
+
+func TestBoundingBoxJSON(t *testing.T) {
+	box := BoundingBox{
+		BottomLeft: Location{Lat: 37, Lon: -123},
+		TopRight:   Location{Lat: 38, Lon: -122},
+	}
+	data, err := json.Marshal(box)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"bottom_left":{"lat":37,"lon":-123},"top_right":{"lat":38,"lon":-122}}`, string(data))
+
+	var got BoundingBox
+	require.NoError(t, json.Unmarshal(data, &got))
+	assert.Equal(t, box, got)
+
+	// Untagged keys from older files still decode
+	got = BoundingBox{}
+	require.NoError(t, json.Unmarshal([]byte(`{"BottomLeft":{"lat":37,"lon":-123},"TopRight":{"lat":38,"lon":-122}}`), &got))
+	assert.Equal(t, box, got)
+}
+
+func TestBoundingBoxValidate(t *testing.T) {
+	box := BoundingBox{
+		BottomLeft: Location{Lat: 38, Lon: -122},
+		TopRight:   Location{Lat: 37, Lon: -123},
+	}
+	assert.Error(t, box.Validate())
+
+	box = box.Normalize()
+	assert.Equal(t, Location{Lat: 37, Lon: -123}, box.BottomLeft)
+	assert.Equal(t, Location{Lat: 38, Lon: -122}, box.TopRight)
+	assert.NoError(t, box.Validate())
+
+	assert.NoError(t, BoundingBox{BottomLeft: Location{Lat: -90, Lon: -180}, TopRight: Location{Lat: 90, Lon: 180}}.Validate())
+	assert.Error(t, BoundingBox{TopRight: Location{Lat: 91}}.Validate())
+	assert.Error(t, BoundingBox{BottomLeft: Location{Lon: -181}}.Validate())
+	assert.Error(t, BoundingBox{BottomLeft: Location{Lat: math.NaN()}}.Validate())
+}
+
+func TestBoundingBoxGeometry(t *testing.T) {
+	box := BoundingBox{
+		BottomLeft: Location{Lat: 0, Lon: 0},
+		TopRight:   Location{Lat: 10, Lon: 20},
+	}
+
+	assert.True(t, box.Contains(Location{Lat: 5, Lon: 5}))
+	assert.True(t, box.Contains(Location{Lat: 10, Lon: 20}))
+	assert.False(t, box.Contains(Location{Lat: 10.1, Lon: 5}))
+
+	assert.True(t, box.Intersects(BoundingBox{BottomLeft: Location{Lat: 5, Lon: 15}, TopRight: Location{Lat: 15, Lon: 25}}))
+	assert.True(t, box.Intersects(BoundingBox{BottomLeft: Location{Lat: 10, Lon: 20}, TopRight: Location{Lat: 11, Lon: 21}}))
+	assert.False(t, box.Intersects(BoundingBox{BottomLeft: Location{Lat: 11, Lon: 0}, TopRight: Location{Lat: 12, Lon: 20}}))
+
+	assert.Equal(t, Location{Lat: 5, Lon: 10}, box.Center())
+
+	union := box.Union(BoundingBox{BottomLeft: Location{Lat: -5, Lon: 5}, TopRight: Location{Lat: 5, Lon: 30}})
+	assert.Equal(t, BoundingBox{BottomLeft: Location{Lat: -5, Lon: 0}, TopRight: Location{Lat: 10, Lon: 30}}, union)
+
+	// The whole sphere, and one degree square at the equator
+	world := BoundingBox{BottomLeft: Location{Lat: -90, Lon: -180}, TopRight: Location{Lat: 90, Lon: 180}}
+	assert.InDelta(t, 4*math.Pi*earthRadiusKm*earthRadiusKm, world.AreaKm2(), 1)
+	cell := BoundingBox{TopRight: Location{Lat: 1, Lon: 1}}
+	assert.InDelta(t, 111.19*111.19, cell.AreaKm2(), 10)
+}
+
+func TestBoundingBoxExpand(t *testing.T) {
+	// At the equator a degree is ~111km both ways
+	point := BoundingBox{}
+	box := point.Expand(kmPerDegree)
+	assert.InDelta(t, -1, box.BottomLeft.Lat, 1e-9)
+	assert.InDelta(t, 1, box.TopRight.Lat, 1e-9)
+	assert.InDelta(t, -1, box.BottomLeft.Lon, 1e-3)
+	assert.InDelta(t, 1, box.TopRight.Lon, 1e-3)
+
+	// At 60° longitude degrees are half as long
+	point = BoundingBox{BottomLeft: Location{Lat: 59, Lon: 10}, TopRight: Location{Lat: 59, Lon: 10}}
+	box = point.Expand(kmPerDegree)
+	assert.InDelta(t, 60, box.TopRight.Lat, 1e-9)
+	assert.InDelta(t, 8, box.BottomLeft.Lon, 1e-9)
+	assert.InDelta(t, 12, box.TopRight.Lon, 1e-9)
+
+	// Reaching a pole covers every longitude
+	box = BoundingBox{BottomLeft: Location{Lat: 89.5}, TopRight: Location{Lat: 89.5}}.Expand(100)
+	assert.Equal(t, 90.0, box.TopRight.Lat)
+	assert.Equal(t, -180.0, box.BottomLeft.Lon)
+	assert.Equal(t, 180.0, box.TopRight.Lon)
+}
+
+func TestLocationGeodesy(t *testing.T) {
+	sf := Location{Lat: 37.7749, Lon: -122.4194}
+	la := Location{Lat: 34.0522, Lon: -118.2437}
+
+	assert.InDelta(t, 559.1, sf.DistanceTo(la), 0.5)
+	assert.InDelta(t, sf.DistanceTo(la), la.DistanceTo(sf), 1e-9)
+	assert.Zero(t, sf.DistanceTo(sf))
+
+	origin := Location{}
+	assert.InDelta(t, 0, origin.BearingTo(Location{Lat: 1}), 1e-9)
+	assert.InDelta(t, 90, origin.BearingTo(Location{Lon: 1}), 1e-9)
+	assert.InDelta(t, 180, origin.BearingTo(Location{Lat: -1}), 1e-9)
+	assert.InDelta(t, 270, origin.BearingTo(Location{Lon: -1}), 1e-9)
+	assert.InDelta(t, 136.5, sf.BearingTo(la), 0.5)
+
+	// Destination inverts distance and bearing
+	dest := sf.Destination(sf.BearingTo(la), sf.DistanceTo(la))
+	assert.InDelta(t, la.Lat, dest.Lat, 1e-6)
+	assert.InDelta(t, la.Lon, dest.Lon, 1e-6)
+
+	// Crossing the antimeridian wraps the longitude
+	dest = Location{Lon: 179.5}.Destination(90, kmPerDegree)
+	assert.InDelta(t, 0, dest.Lat, 1e-9)
+	assert.InDelta(t, -179.5, dest.Lon, 1e-9)
+
+	// Altitude only counts in 3D distance
+	drone := Location{Lat: sf.Lat, Lon: sf.Lon, Alt: 400}
+	assert.Zero(t, sf.DistanceTo(drone))
+	assert.InDelta(t, 0.4, sf.Distance3DTo(drone), 1e-12)
+	assert.InDelta(t, math.Hypot(sf.DistanceTo(la), 0.4), drone.Distance3DTo(la), 1e-9)
+	assert.Equal(t, []float64{sf.Lon, sf.Lat, 400}, drone.ToGeoJSON().Coordinates)
+}
+
+func TestGreatCircleInterpolation(t *testing.T) {
+	sf := Location{Lat: 37.7749, Lon: -122.4194}
+	tokyo := Location{Lat: 35.6762, Lon: 139.6503}
+	total := sf.DistanceTo(tokyo)
+
+	mid := sf.Midpoint(tokyo)
+	assert.InDelta(t, total/2, sf.DistanceTo(mid), 1e-6)
+	assert.InDelta(t, total/2, mid.DistanceTo(tokyo), 1e-6)
+	// The great circle bulges north over the Pacific and crosses the antimeridian
+	assert.Greater(t, mid.Lat, 45.0)
+	assert.Less(t, mid.Lon, -160.0)
+
+	assert.InDelta(t, sf.Lat, sf.Interpolate(tokyo, 0).Lat, 1e-9)
+	assert.InDelta(t, tokyo.Lon, sf.Interpolate(tokyo, 1).Lon, 1e-9)
+	assert.InDelta(t, 0, Location{Lat: 0, Lon: 10}.Midpoint(Location{Lat: 0, Lon: 20}).Lat, 1e-9)
+	assert.InDelta(t, 15, Location{Lat: 0, Lon: 10}.Midpoint(Location{Lat: 0, Lon: 20}).Lon, 1e-9)
+
+	// Identical and antipodal points still give a point on a shortest path
+	assert.Equal(t, sf, sf.Interpolate(sf, 0.3))
+	antipode := Location{Lat: -sf.Lat, Lon: sf.Lon + 180}
+	assert.InDelta(t, sf.DistanceTo(antipode)/2, sf.DistanceTo(sf.Midpoint(antipode)), 1e-3)
+
+	path := sf.PathTo(tokyo, 5)
+	require.Len(t, path, 5)
+	assert.Equal(t, sf, path[0])
+	assert.Equal(t, tokyo, path[4])
+	for i := 1; i < len(path); i++ {
+		assert.InDelta(t, total/4, path[i-1].DistanceTo(path[i]), 1e-6)
+	}
+	assert.Equal(t, []Location{sf, tokyo}, sf.PathTo(tokyo, 0))
+}
+
+func TestNewBoundingBoxFromCenter(t *testing.T) {
+	box := NewBoundingBoxFromCenter(Location{}, kmPerDegree)
+	assert.InDelta(t, -1, box.BottomLeft.Lat, 1e-9)
+	assert.InDelta(t, 1, box.TopRight.Lat, 1e-9)
+	assert.InDelta(t, 1, box.TopRight.Lon, 1e-3)
+
+	// Longitude span widens with latitude and just contains the circle
+	center := Location{Lat: 60, Lon: 10}
+	box = NewBoundingBoxFromCenter(center, 100)
+	assert.InDelta(t, 10+100/(kmPerDegree/2), box.TopRight.Lon, 0.01)
+	for bearing := 0.0; bearing < 360; bearing += 15 {
+		assert.True(t, box.Contains(center.Destination(bearing, 99.9)), "bearing %v", bearing)
+	}
+
+	// A circle covering a pole spans every longitude
+	box = NewBoundingBoxFromCenter(Location{Lat: 89, Lon: 10}, 200)
+	assert.Equal(t, 90.0, box.TopRight.Lat)
+	assert.Equal(t, -180.0, box.BottomLeft.Lon)
+	assert.Equal(t, 180.0, box.TopRight.Lon)
+}
+
+func TestNewBoundingBoxesFromCenter(t *testing.T) {
+	boxes := NewBoundingBoxesFromCenter(Location{Lat: 10, Lon: 20}, 100)
+	assert.Equal(t, []BoundingBox{NewBoundingBoxFromCenter(Location{Lat: 10, Lon: 20}, 100)}, boxes)
+
+	// Across the antimeridian the overhang is searched on the other side
+	boxes = NewBoundingBoxesFromCenter(Location{Lon: 179.95}, 50)
+	require.Len(t, boxes, 2)
+	assert.InDelta(t, 179.5, boxes[0].BottomLeft.Lon, 0.01)
+	assert.Equal(t, -180.0, boxes[1].BottomLeft.Lon)
+	assert.InDelta(t, -179.6, boxes[1].TopRight.Lon, 0.01)
+	assert.True(t, boxes[1].Contains(Location{Lon: -179.9}))
+
+	boxes = NewBoundingBoxesFromCenter(Location{Lon: -179.95}, 50)
+	require.Len(t, boxes, 2)
+	assert.InDelta(t, 179.6, boxes[1].BottomLeft.Lon, 0.01)
+	assert.Equal(t, 180.0, boxes[1].TopRight.Lon)
+
+	// Polar boxes already span every longitude
+	assert.Len(t, NewBoundingBoxesFromCenter(Location{Lat: 89, Lon: 179}, 200), 1)
+}
+
+func TestWKT(t *testing.T) {
+	assert.Equal(t, "POINT(-122.4194 37.7749)", Location{Lat: 37.7749, Lon: -122.4194}.WKT())
+	assert.Equal(t, "POINT(0 0)", Location{}.WKT())
+
+	box := BoundingBox{BottomLeft: Location{Lat: 37, Lon: -123}, TopRight: Location{Lat: 38.5, Lon: -122}}
+	assert.Equal(t, "POLYGON((-123 37, -122 37, -122 38.5, -123 38.5, -123 37))", box.WKT())
+}
+
+func TestUnit(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		unit Unit
+		km   float64
+	}{
+		{"KM", Kilometers, 1},
+		{"metres", Meters, 0.001},
+		{"miles", Miles, 1.609344},
+		{"nmi", NauticalMiles, 1.852},
+	} {
+		unit, err := ParseUnit(tc.name)
+		require.NoError(t, err)
+		assert.Equal(t, tc.unit, unit)
+		assert.InDelta(t, tc.km, unit.ToKm(1), 1e-12)
+		assert.InDelta(t, 1, unit.FromKm(tc.km), 1e-12)
+	}
+
+	_, err := ParseUnit("furlong")
+	assert.Error(t, err)
+	assert.False(t, Unit("furlong").Valid())
+	assert.True(t, math.IsNaN(Unit("furlong").ToKm(1)))
+
+	sf := Location{Lat: 37.7749, Lon: -122.4194}
+	la := Location{Lat: 34.0522, Lon: -118.2437}
+	assert.InDelta(t, sf.DistanceTo(la)/1.852, sf.DistanceIn(la, NauticalMiles), 1e-9)
+}
+
+func TestPolygon(t *testing.T) {
+	// A 10x10 square with a 2x2 hole in the middle
+	polygon := &Polygon{
+		ID:    "zone",
+		Outer: []Location{{Lat: 0, Lon: 0}, {Lat: 0, Lon: 10}, {Lat: 10, Lon: 10}, {Lat: 10, Lon: 0}},
+		Holes: [][]Location{{{Lat: 4, Lon: 4}, {Lat: 4, Lon: 6}, {Lat: 6, Lon: 6}, {Lat: 6, Lon: 4}, {Lat: 4, Lon: 4}}},
+	}
+	require.NoError(t, polygon.Validate())
+	assert.Equal(t, BoundingBox{BottomLeft: Location{Lat: 0, Lon: 0}, TopRight: Location{Lat: 10, Lon: 10}}, polygon.Bounds())
+
+	for loc, want := range map[Location]bool{
+		{Lat: 1, Lon: 1}:   true,
+		{Lat: 5, Lon: 5}:   false, // in the hole
+		{Lat: 4, Lon: 5}:   true,  // on the hole's edge
+		{Lat: 0, Lon: 5}:   true,  // on the outer edge
+		{Lat: 10, Lon: 10}: true,  // a vertex
+		{Lat: 11, Lon: 5}:  false,
+		{Lat: 5, Lon: -1}:  false,
+	} {
+		assert.Equal(t, want, polygon.Contains(loc), "%+v", loc)
+	}
+
+	// A concave L shape
+	l := &Polygon{Outer: []Location{{Lat: 0, Lon: 0}, {Lat: 0, Lon: 4}, {Lat: 2, Lon: 4}, {Lat: 2, Lon: 2}, {Lat: 4, Lon: 2}, {Lat: 4, Lon: 0}}}
+	assert.True(t, l.Contains(Location{Lat: 3, Lon: 1}))
+	assert.False(t, l.Contains(Location{Lat: 3, Lon: 3}))
+
+	assert.Error(t, (&Polygon{Outer: []Location{{Lat: 0, Lon: 0}, {Lat: 1, Lon: 1}, {Lat: 0, Lon: 0}}}).Validate())
+	assert.Error(t, (&Polygon{Outer: []Location{{Lat: 0, Lon: 0}, {Lat: 1, Lon: 1}, {Lat: 91, Lon: 0}}}).Validate())
+	bad := *polygon
+	bad.Holes = [][]Location{{{Lat: 1, Lon: 1}}}
+	assert.ErrorContains(t, bad.Validate(), "hole 0")
+}
@@ -0,0 +1,108 @@
+package models
+
+import (
+	"fmt"
+	"math"
+)
+
+// Polygon is an area such as a geofence, zone or delivery area, bounded by an
+// outer ring with optional holes. Rings are lists of locations; repeating the
+// first location at the end is optional. Edges are straight lines in
+// latitude/longitude, so polygons crossing the antimeridian must be split.
+type Polygon struct {
+	ID         string         `json:"id"`
+	Outer      []Location     `json:"outer"`
+	Holes      [][]Location   `json:"holes,omitempty"`
+	Properties map[string]any `json:"properties,omitempty"`
+}
+
+// Validate checks that every ring has at least three distinct vertices with
+// valid coordinates
+func (p *Polygon) Validate() error {
+	if err := validateRing(p.Outer); err != nil {
+		return fmt.Errorf("outer ring: %w", err)
+	}
+	for i, hole := range p.Holes {
+		if err := validateRing(hole); err != nil {
+			return fmt.Errorf("hole %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func validateRing(ring []Location) error {
+	distinct := make(map[Location]struct{}, len(ring))
+	for _, loc := range ring {
+		if math.IsNaN(loc.Lat) || loc.Lat < -90 || loc.Lat > 90 {
+			return fmt.Errorf("latitude %v outside [-90, 90]", loc.Lat)
+		}
+		if math.IsNaN(loc.Lon) || loc.Lon < -180 || loc.Lon > 180 {
+			return fmt.Errorf("longitude %v outside [-180, 180]", loc.Lon)
+		}
+		distinct[loc] = struct{}{}
+	}
+	if len(distinct) < 3 {
+		return fmt.Errorf("ring needs at least 3 distinct vertices, has %d", len(distinct))
+	}
+	return nil
+}
+
+// Bounds returns the smallest box containing the outer ring
+func (p *Polygon) Bounds() BoundingBox {
+	if len(p.Outer) == 0 {
+		return BoundingBox{}
+	}
+	box := BoundingBox{BottomLeft: p.Outer[0], TopRight: p.Outer[0]}
+	for _, loc := range p.Outer[1:] {
+		box = box.Union(BoundingBox{BottomLeft: loc, TopRight: loc})
+	}
+	return box
+}
+
+// Contains reports whether loc lies inside the polygon, on its boundary
+// included, and outside every hole
+func (p *Polygon) Contains(loc Location) bool {
+	if onRing(p.Outer, loc) {
+		return true
+	}
+	if !insideRing(p.Outer, loc) {
+		return false
+	}
+	for _, hole := range p.Holes {
+		if onRing(hole, loc) {
+			return true
+		}
+		if insideRing(hole, loc) {
+			return false
+		}
+	}
+	return true
+}
+
+// insideRing is the even-odd ray casting test, casting the ray towards
+// increasing longitude
+func insideRing(ring []Location, loc Location) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a.Lat > loc.Lat) != (b.Lat > loc.Lat) &&
+			loc.Lon < (b.Lon-a.Lon)*(loc.Lat-a.Lat)/(b.Lat-a.Lat)+a.Lon {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// onRing reports whether loc lies exactly on an edge of ring
+func onRing(ring []Location, loc Location) bool {
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[j], ring[i]
+		cross := (b.Lon-a.Lon)*(loc.Lat-a.Lat) - (b.Lat-a.Lat)*(loc.Lon-a.Lon)
+		if cross == 0 &&
+			loc.Lon >= math.Min(a.Lon, b.Lon) && loc.Lon <= math.Max(a.Lon, b.Lon) &&
+			loc.Lat >= math.Min(a.Lat, b.Lat) && loc.Lat <= math.Max(a.Lat, b.Lat) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Unit is a unit of distance
+type Unit string
+
+const (
+	Kilometers    Unit = "km"
+	Meters        Unit = "m"
+	Miles         Unit = "mi"
+	NauticalMiles Unit = "nmi"
+)
+
+// kmPerUnit is the length of one of each unit in kilometers
+var kmPerUnit = map[Unit]float64{
+	Kilometers:    1,
+	Meters:        0.001,
+	Miles:         1.609344,
+	NauticalMiles: 1.852,
+}
+
+// ParseUnit parses a unit name or abbreviation such as "km", "miles" or "nmi",
+// ignoring case
+func ParseUnit(s string) (Unit, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "km", "kilometer", "kilometers", "kilometre", "kilometres":
+		return Kilometers, nil
+	case "m", "meter", "meters", "metre", "metres":
+		return Meters, nil
+	case "mi", "mile", "miles":
+		return Miles, nil
+	case "nmi", "nm", "nautical-mile", "nautical-miles":
+		return NauticalMiles, nil
+	}
+	return "", fmt.Errorf("unknown distance unit %q", s)
+}
+
+// Valid reports whether u is one of the defined units
+func (u Unit) Valid() bool {
+	_, ok := kmPerUnit[u]
+	return ok
+}
+
+// ToKm converts v in this unit to kilometers. Unknown units give NaN.
+func (u Unit) ToKm(v float64) float64 {
+	factor, ok := kmPerUnit[u]
+	if !ok {
+		return math.NaN()
+	}
+	return v * factor
+}
+
+// FromKm converts km kilometers to this unit. Unknown units give NaN.
+func (u Unit) FromKm(km float64) float64 {
+	factor, ok := kmPerUnit[u]
+	if !ok {
+		return math.NaN()
+	}
+	return km / factor
+}
+
+// DistanceIn returns the haversine great-circle distance to other in unit
+func (l Location) DistanceIn(other Location, unit Unit) float64 {
+	return unit.FromKm(l.DistanceTo(other))
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"strconv"
+	"strings"
+)
+
+// WKT returns the location as Well-Known Text, e.g. "POINT(-122.4194 37.7749)"
+func (l Location) WKT() string {
+	return "POINT(" + wktPosition(l.Lon, l.Lat) + ")"
+}
+
+// WKT returns the box as a Well-Known Text polygon with a counterclockwise
+// ring, as accepted by PostGIS ST_GeomFromText
+func (b BoundingBox) WKT() string {
+	minLon, minLat := b.BottomLeft.Lon, b.BottomLeft.Lat
+	maxLon, maxLat := b.TopRight.Lon, b.TopRight.Lat
+	ring := []string{
+		wktPosition(minLon, minLat),
+		wktPosition(maxLon, minLat),
+		wktPosition(maxLon, maxLat),
+		wktPosition(minLon, maxLat),
+		wktPosition(minLon, minLat),
+	}
+	return "POLYGON((" + strings.Join(ring, ", ") + "))"
+}
+
+func wktPosition(x, y float64) string {
+	return strconv.FormatFloat(x, 'f', -1, 64) + " " + strconv.FormatFloat(y, 'f', -1, 64)
+}
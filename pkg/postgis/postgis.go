@@ -3,19 +3,122 @@ package postgis
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"strings"
+	"sync"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/1F47E/geo-index-rtree/pkg/rtree"
+)
+
+const (
+	defaultSchema = "public"
+	defaultTable  = "geo_points"
+	// wgs84SRID is EPSG:4326, the lon/lat degrees the index API speaks
+	wgs84SRID     = 4326
+	defaultSRID   = wgs84SRID
+)
+
+// discardLogger is the logger of indexes built without WithLogger
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError + 1}))
+
+// InitMode controls how InitSchema treats an existing points table
+type InitMode int
+
+const (
+	// InitRecreate drops the points table and creates it from scratch (default)
+	InitRecreate InitMode = iota
+	// InitIfNotExists creates the schema and table only when they are missing,
+	// leaving any existing data untouched
+	InitIfNotExists
 )
 
 type PostGISIndex struct {
-	db *sql.DB
+	db        *sql.DB
+	schema    string
+	table     string
+	initMode  InitMode
+	geography bool
+	srid      int
+
+	// Query statements are prepared lazily and reused; database/sql re-prepares
+	// them transparently on each pooled connection the first time it is used
+	prepare bool
+	stmtMu  sync.Mutex
+	stmts   map[string]*sql.Stmt
+
+	log *slog.Logger
+}
+
+// Option configures a PostGISIndex
+type Option func(*PostGISIndex)
+
+// WithSchema sets the schema holding the points table (default "public")
+func WithSchema(schema string) Option {
+	return func(p *PostGISIndex) {
+		p.schema = schema
+	}
+}
+
+// WithTable sets the name of the points table (default "geo_points")
+func WithTable(table string) Option {
+	return func(p *PostGISIndex) {
+		p.table = table
+	}
+}
+
+// WithInitMode sets how InitSchema handles an existing table
+func WithInitMode(mode InitMode) Option {
+	return func(p *PostGISIndex) {
+		p.initMode = mode
+	}
+}
+
+// WithGeography stores locations as geography(Point) instead of geometry(Point),
+// so distance filters use spheroid meters like the haversine-based R-tree
+func WithGeography() Option {
+	return func(p *PostGISIndex) {
+		p.geography = true
+	}
+}
+
+// WithSRID sets the spatial reference system of the location column (default
+// 4326). Locations still go in and come out as WGS 84 longitude and latitude:
+// geometry columns in another SRID, such as a projected one, are converted
+// with ST_Transform on insert and query. Geography columns need a geographic
+// SRID, whose coordinates are degrees already.
+func WithSRID(srid int) Option {
+	return func(p *PostGISIndex) {
+		p.srid = srid
+	}
+}
+
+// WithoutPreparedStatements sends query SQL as plain text on every call
+// instead of reusing prepared statements
+func WithoutPreparedStatements() Option {
+	return func(p *PostGISIndex) {
+		p.prepare = false
+	}
+}
+
+// WithLogger sends structured logs to logger: the connection, schema and
+// index setup, bulk inserts and loads at info level with their counts and
+// durations, their failures at error level, and upserts and deletes at debug
+// level. Nothing is logged by default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(p *PostGISIndex) {
+		p.log = logger
+	}
 }
 
 // NewPostGISIndex creates a new PostGIS connection
-func NewPostGISIndex(host, user, password, dbname string, port int) (*PostGISIndex, error) {
+func NewPostGISIndex(host, user, password, dbname string, port int, opts ...Option) (*PostGISIndex, error) {
 	connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable connect_timeout=5",
 		host, port, user, password, dbname)
 	
@@ -38,79 +141,218 @@ func NewPostGISIndex(host, user, password, dbname string, port int) (*PostGISInd
 	db.SetMaxIdleConns(25)
 	db.SetConnMaxLifetime(5 * time.Minute)
 	
-	return &PostGISIndex{db: db}, nil
+	p := &PostGISIndex{
+		db:      db,
+		schema:  defaultSchema,
+		table:   defaultTable,
+		srid:    defaultSRID,
+		prepare: true,
+		stmts:   make(map[string]*sql.Stmt),
+		log:     discardLogger,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.log == nil {
+		p.log = discardLogger
+	}
+	p.log.Info("connected to PostGIS", "host", host, "port", port, "database", dbname, "table", p.tableName())
+	
+	return p, nil
+}
+
+// tableName returns the quoted, schema-qualified name of the points table
+func (p *PostGISIndex) tableName() string {
+	return pq.QuoteIdentifier(p.schema) + "." + pq.QuoteIdentifier(p.table)
+}
+
+// columnType returns the SQL type of the location column
+func (p *PostGISIndex) columnType() string {
+	if p.geography {
+		return fmt.Sprintf("GEOGRAPHY(POINT, %d)", p.srid)
+	}
+	return fmt.Sprintf("GEOMETRY(POINT, %d)", p.srid)
+}
+
+// projected reports whether the location column is geometry in an SRID other
+// than WGS 84, so coordinates are transformed on the way in and out
+func (p *PostGISIndex) projected() bool {
+	return !p.geography && p.srid != wgs84SRID
+}
+
+// fromWGS84 converts geom, an SQL geometry expression in WGS 84, to the type
+// and SRID of the location column
+func (p *PostGISIndex) fromWGS84(geom string) string {
+	switch {
+	case p.geography:
+		return fmt.Sprintf("ST_SetSRID(%s, %d)::geography", geom, p.srid)
+	case p.projected():
+		return fmt.Sprintf("ST_Transform(%s, %d)", geom, p.srid)
+	}
+	return geom
+}
+
+// locationWGS84 returns the location column as WGS 84 geometry
+func (p *PostGISIndex) locationWGS84() string {
+	switch {
+	case p.geography:
+		return "location::geometry"
+	case p.projected():
+		return fmt.Sprintf("ST_Transform(location, %d)", wgs84SRID)
+	}
+	return "location"
+}
+
+// makePoint returns an SQL expression building a location value from the
+// lon/lat placeholders
+func (p *PostGISIndex) makePoint(lon, lat string) string {
+	return p.fromWGS84(fmt.Sprintf("ST_SetSRID(ST_MakePoint(%s, %s), %d)", lon, lat, wgs84SRID))
+}
+
+// boxFilter returns an SQL condition matching locations in the lon/lat box
+// with the given corner expressions. In a projected SRID the box edges may
+// curve, so the index searches the extent of the densified, transformed box
+// and the exact test is done in WGS 84.
+func (p *PostGISIndex) boxFilter(minLon, minLat, maxLon, maxLat string) string {
+	envelope := fmt.Sprintf("ST_MakeEnvelope(%s, %s, %s, %s, %d)", minLon, minLat, maxLon, maxLat, wgs84SRID)
+	if !p.projected() {
+		return "location && " + p.fromWGS84(envelope)
+	}
+	return fmt.Sprintf("location && %s AND %s && %s",
+		p.fromWGS84(fmt.Sprintf("ST_Segmentize(%s, 1)", envelope)), p.locationWGS84(), envelope)
+}
+
+// selectColumns returns the id/lat/lon select list shared by all point queries
+func (p *PostGISIndex) selectColumns() string {
+	return fmt.Sprintf("id, ST_Y(%[1]s) as lat, ST_X(%[1]s) as lon", p.locationWGS84())
+}
+
+// query runs a read query, reusing the prepared statement registered under name
+func (p *PostGISIndex) query(ctx context.Context, name, query string, args ...interface{}) (*sql.Rows, error) {
+	if !p.prepare {
+		return p.db.QueryContext(ctx, query, args...)
+	}
+	
+	p.stmtMu.Lock()
+	stmt, ok := p.stmts[name]
+	if !ok {
+		var err error
+		stmt, err = p.db.PrepareContext(ctx, query)
+		if err != nil {
+			p.stmtMu.Unlock()
+			return nil, fmt.Errorf("failed to prepare %s statement: %w", name, err)
+		}
+		p.stmts[name] = stmt
+	}
+	p.stmtMu.Unlock()
+	
+	return stmt.QueryContext(ctx, args...)
+}
+
+// closeStatements drops all cached prepared statements
+func (p *PostGISIndex) closeStatements() {
+	p.stmtMu.Lock()
+	defer p.stmtMu.Unlock()
+	
+	for name, stmt := range p.stmts {
+		if err := stmt.Close(); err != nil {
+			p.log.Warn("failed to close prepared statement", "statement", name, "error", err)
+		}
+		delete(p.stmts, name)
+	}
 }
 
 // InitSchema creates the necessary tables and indexes
-func (p *PostGISIndex) InitSchema() error {
+func (p *PostGISIndex) InitSchema(ctx context.Context) error {
+	// Cached plans reference the old table and would fail after it is recreated
+	p.closeStatements()
+	
 	queries := []string{
 		// Enable PostGIS extension
 		`CREATE EXTENSION IF NOT EXISTS postgis;`,
 		
+		fmt.Sprintf(`CREATE SCHEMA IF NOT EXISTS %s;`, pq.QuoteIdentifier(p.schema)),
+	}
+	
+	if p.initMode == InitRecreate {
 		// Drop existing table if exists
-		`DROP TABLE IF EXISTS geo_points;`,
-		
-		// Create table with geometry column
-		`CREATE TABLE geo_points (
-			id TEXT PRIMARY KEY,
-			location GEOMETRY(POINT, 4326)
-		);`,
+		queries = append(queries, fmt.Sprintf(`DROP TABLE IF EXISTS %s;`, p.tableName()))
 	}
 	
+	// Create table with geometry column
+	queries = append(queries, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			id TEXT PRIMARY KEY,
+			location %s
+		);`, p.tableName(), p.columnType()))
+	
 	for _, query := range queries {
-		if _, err := p.db.Exec(query); err != nil {
+		if _, err := p.db.ExecContext(ctx, query); err != nil {
+			p.log.Error("schema initialization failed", "table", p.tableName(), "error", err)
 			return fmt.Errorf("failed to execute query '%s': %w", query, err)
 		}
 	}
 	
+	p.log.Info("schema initialized", "table", p.tableName(), "recreated", p.initMode == InitRecreate)
 	return nil
 }
 
 // CreateSpatialIndex creates a GIST index on the geometry column
-func (p *PostGISIndex) CreateSpatialIndex() error {
-	query := `CREATE INDEX idx_geo_points_location ON geo_points USING GIST(location);`
+func (p *PostGISIndex) CreateSpatialIndex(ctx context.Context) error {
+	query := fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON %s USING GIST(location);`,
+		pq.QuoteIdentifier("idx_"+p.table+"_location"), p.tableName())
 	
 	start := time.Now()
-	if _, err := p.db.Exec(query); err != nil {
+	if _, err := p.db.ExecContext(ctx, query); err != nil {
+		p.log.Error("spatial index creation failed", "table", p.tableName(), "error", err)
 		return fmt.Errorf("failed to create spatial index: %w", err)
 	}
 	
 	// Analyze table for better query planning
-	if _, err := p.db.Exec("ANALYZE geo_points;"); err != nil {
+	if _, err := p.db.ExecContext(ctx, fmt.Sprintf("ANALYZE %s;", p.tableName())); err != nil {
+		p.log.Error("table analysis failed", "table", p.tableName(), "error", err)
 		return fmt.Errorf("failed to analyze table: %w", err)
 	}
 	
-	_ = time.Since(start) // Track time but don't print here
+	p.log.Info("spatial index created", "table", p.tableName(), "duration", time.Since(start))
 	
 	return nil
 }
 
 // BulkInsertPoints inserts points in batches for better performance
-func (p *PostGISIndex) BulkInsertPoints(points []*models.Point, progressCallback func(loaded, total int)) error {
+func (p *PostGISIndex) BulkInsertPoints(ctx context.Context, points []*models.Point, progressCallback func(loaded, total int)) (err error) {
 	const batchSize = 10000
 	
+	start := time.Now()
+	defer func() {
+		if err != nil {
+			p.log.Error("bulk insert failed", "table", p.tableName(), "error", err)
+			return
+		}
+		p.log.Info("points inserted", "table", p.tableName(), "points", len(points), "duration", time.Since(start))
+	}()
+	
 	// Prepare statement
-	stmt, err := p.db.Prepare(`
-		INSERT INTO geo_points (id, location) 
-		VALUES ($1, ST_SetSRID(ST_MakePoint($2, $3), 4326))
-	`)
+	stmt, err := p.db.PrepareContext(ctx, fmt.Sprintf(`
+		INSERT INTO %s (id, location) 
+		VALUES ($1, %s)
+	`, p.tableName(), p.makePoint("$2", "$3")))
 	if err != nil {
 		return fmt.Errorf("failed to prepare statement: %w", err)
 	}
 	defer stmt.Close()
 	
 	// Begin transaction
-	tx, err := p.db.Begin()
+	tx, err := p.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	
-	txStmt := tx.Stmt(stmt)
+	txStmt := tx.StmtContext(ctx, stmt)
 	
 	// Insert in batches
 	for i := 0; i < len(points); i++ {
 		point := points[i]
-		_, err := txStmt.Exec(point.ID, point.Location.Lon, point.Location.Lat)
+		_, err := txStmt.ExecContext(ctx, point.ID, point.Location.Lon, point.Location.Lat)
 		if err != nil {
 			tx.Rollback()
 			return fmt.Errorf("failed to insert point %s: %w", point.ID, err)
@@ -128,11 +370,11 @@ func (p *PostGISIndex) BulkInsertPoints(points []*models.Point, progressCallback
 			}
 			
 			// Start new transaction
-			tx, err = p.db.Begin()
+			tx, err = p.db.BeginTx(ctx, nil)
 			if err != nil {
 				return fmt.Errorf("failed to begin new transaction: %w", err)
 			}
-			txStmt = tx.Stmt(stmt)
+			txStmt = tx.StmtContext(ctx, stmt)
 		}
 	}
 	
@@ -149,17 +391,387 @@ func (p *PostGISIndex) BulkInsertPoints(points []*models.Point, progressCallback
 	return nil
 }
 
+// UpsertPoints inserts new points and moves existing ones (matched by ID) to
+// their new location, keeping the table in sync with incremental index updates
+func (p *PostGISIndex) UpsertPoints(ctx context.Context, points []*models.Point) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, fmt.Sprintf(`
+		INSERT INTO %s (id, location)
+		VALUES ($1, %s)
+		ON CONFLICT (id) DO UPDATE SET location = EXCLUDED.location
+	`, p.tableName(), p.makePoint("$2", "$3")))
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, point := range points {
+		if point.Location == nil {
+			continue
+		}
+		if _, err := stmt.ExecContext(ctx, point.ID, point.Location.Lon, point.Location.Lat); err != nil {
+			return fmt.Errorf("failed to upsert point %s: %w", point.ID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit upsert: %w", err)
+	}
+
+	p.log.Debug("points upserted", "table", p.tableName(), "points", len(points))
+	return nil
+}
+
+// DeletePoints removes the points with the given IDs and returns how many rows were deleted
+func (p *PostGISIndex) DeletePoints(ctx context.Context, ids []string) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	query := fmt.Sprintf(`DELETE FROM %s WHERE id = ANY($1)`, p.tableName())
+	res, err := p.db.ExecContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete points: %w", err)
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read deleted row count: %w", err)
+	}
+
+	p.log.Debug("points deleted", "table", p.tableName(), "points", deleted)
+	return deleted, nil
+}
+
+// boxQuery returns the SQL for a bounding box query taking lon/lat corner arguments
+func (p *PostGISIndex) boxQuery() string {
+	return fmt.Sprintf(`
+		SELECT %s
+		FROM %s
+		WHERE %s
+	`, p.selectColumns(), p.tableName(), p.boxFilter("$1", "$2", "$3", "$4"))
+}
+
 // QueryBox performs a bounding box query
-func (p *PostGISIndex) QueryBox(box models.BoundingBox) ([]*models.Point, error) {
-	query := `
-		SELECT id, ST_Y(location) as lat, ST_X(location) as lon
-		FROM geo_points
-		WHERE location && ST_MakeEnvelope($1, $2, $3, $4, 4326)
-	`
-	
-	rows, err := p.db.Query(query, 
+func (p *PostGISIndex) QueryBox(ctx context.Context, box models.BoundingBox) ([]*models.Point, error) {
+	return scanPoints(p.query(ctx, "box", p.boxQuery(),
 		box.BottomLeft.Lon, box.BottomLeft.Lat,
-		box.TopRight.Lon, box.TopRight.Lat)
+		box.TopRight.Lon, box.TopRight.Lat))
+}
+
+// QueryBoxes runs many bounding box queries in a single round trip by
+// joining the table against the unnested box corners. Results are returned
+// in the same order as boxes.
+func (p *PostGISIndex) QueryBoxes(ctx context.Context, boxes []models.BoundingBox) ([][]*models.Point, error) {
+	results := make([][]*models.Point, len(boxes))
+	if len(boxes) == 0 {
+		return results, nil
+	}
+	
+	minLon := make([]float64, len(boxes))
+	minLat := make([]float64, len(boxes))
+	maxLon := make([]float64, len(boxes))
+	maxLat := make([]float64, len(boxes))
+	for i, box := range boxes {
+		minLon[i], minLat[i] = box.BottomLeft.Lon, box.BottomLeft.Lat
+		maxLon[i], maxLat[i] = box.TopRight.Lon, box.TopRight.Lat
+	}
+	
+	query := fmt.Sprintf(`
+		SELECT q.idx, %s
+		FROM unnest($1::float8[], $2::float8[], $3::float8[], $4::float8[])
+			WITH ORDINALITY AS q(min_lon, min_lat, max_lon, max_lat, idx)
+		JOIN %s ON %s
+	`, p.selectColumns(), p.tableName(), p.boxFilter("q.min_lon", "q.min_lat", "q.max_lon", "q.max_lat"))
+	
+	rows, err := p.query(ctx, "boxes", query,
+		pq.Array(minLon), pq.Array(minLat), pq.Array(maxLon), pq.Array(maxLat))
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute query: %w", err)
+	}
+	defer rows.Close()
+	
+	for rows.Next() {
+		var idx int64
+		var id string
+		var lat, lon float64
+		
+		if err := rows.Scan(&idx, &id, &lat, &lon); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		
+		// ORDINALITY is 1-based
+		results[idx-1] = append(results[idx-1], &models.Point{
+			ID: id,
+			Location: &models.Location{
+				Lat: lat,
+				Lon: lon,
+			},
+		})
+	}
+	
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %w", err)
+	}
+	
+	return results, nil
+}
+
+// QueryPlan summarizes an EXPLAIN (ANALYZE, BUFFERS) run of a single query
+type QueryPlan struct {
+	NodeType         string        `json:"node_type"`
+	IndexName        string        `json:"index_name,omitempty"`
+	PlanningTime     time.Duration `json:"planning_time"`
+	ExecutionTime    time.Duration `json:"execution_time"`
+	ActualRows       int64         `json:"actual_rows"`
+	SharedHitBlocks  int64         `json:"shared_hit_blocks"`
+	SharedReadBlocks int64         `json:"shared_read_blocks"`
+}
+
+// planNode mirrors the subset of EXPLAIN (FORMAT JSON) node fields we report
+type planNode struct {
+	NodeType         string     `json:"Node Type"`
+	IndexName        string     `json:"Index Name"`
+	ActualRows       int64      `json:"Actual Rows"`
+	SharedHitBlocks  int64      `json:"Shared Hit Blocks"`
+	SharedReadBlocks int64      `json:"Shared Read Blocks"`
+	Plans            []planNode `json:"Plans"`
+}
+
+// indexName returns the first index used anywhere in the plan tree
+func (n planNode) indexName() string {
+	if n.IndexName != "" {
+		return n.IndexName
+	}
+	for _, child := range n.Plans {
+		if name := child.indexName(); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// ExplainBox runs a bounding box query under EXPLAIN (ANALYZE, BUFFERS) and
+// returns the planner's choice together with planning and execution timings
+func (p *PostGISIndex) ExplainBox(ctx context.Context, box models.BoundingBox) (*QueryPlan, error) {
+	var raw []byte
+	err := p.db.QueryRowContext(ctx, "EXPLAIN (ANALYZE, BUFFERS, FORMAT JSON) "+p.boxQuery(),
+		box.BottomLeft.Lon, box.BottomLeft.Lat,
+		box.TopRight.Lon, box.TopRight.Lat).Scan(&raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to explain query: %w", err)
+	}
+	
+	var explained []struct {
+		Plan          planNode `json:"Plan"`
+		PlanningTime  float64  `json:"Planning Time"`
+		ExecutionTime float64  `json:"Execution Time"`
+	}
+	if err := json.Unmarshal(raw, &explained); err != nil {
+		return nil, fmt.Errorf("failed to parse plan: %w", err)
+	}
+	if len(explained) == 0 {
+		return nil, fmt.Errorf("empty plan returned")
+	}
+	
+	e := explained[0]
+	return &QueryPlan{
+		NodeType:         e.Plan.NodeType,
+		IndexName:        e.Plan.indexName(),
+		PlanningTime:     time.Duration(e.PlanningTime * float64(time.Millisecond)),
+		ExecutionTime:    time.Duration(e.ExecutionTime * float64(time.Millisecond)),
+		ActualRows:       e.Plan.ActualRows,
+		SharedHitBlocks:  e.Plan.SharedHitBlocks,
+		SharedReadBlocks: e.Plan.SharedReadBlocks,
+	}, nil
+}
+
+// QueryRadius returns all points within radiusKm of center. With geography
+// storage the filter is index-assisted ST_DWithin in meters; with geometry
+// storage degree envelopes, split at ±180 and widened over the poles,
+// pre-filter via the index before the exact spheroid distance check.
+func (p *PostGISIndex) QueryRadius(ctx context.Context, center models.Location, radiusKm float64) ([]*models.Point, error) {
+	meters := radiusKm * 1000
+	if p.geography {
+		query := fmt.Sprintf(`
+			SELECT %s
+			FROM %s
+			WHERE ST_DWithin(location, %s, $3)
+		`, p.selectColumns(), p.tableName(), p.makePoint("$1", "$2"))
+		return scanPoints(p.query(ctx, "radius", query, center.Lon, center.Lat, meters))
+	}
+	
+	filter, args := p.radiusFilter(center, radiusKm, 4)
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM %s
+		WHERE (%s)
+		  AND ST_DWithin(%s::geography, ST_MakePoint($1, $2)::geography, $3)
+	`, p.selectColumns(), p.tableName(), filter, p.locationWGS84())
+	// The number of boxes changes the SQL, so each count gets its own statement
+	name := fmt.Sprintf("radius-%d", len(args)/4)
+	return scanPoints(p.query(ctx, name, query, append([]interface{}{center.Lon, center.Lat, meters}, args...)...))
+}
+
+// radiusFilter returns an SQL condition matching locations in the boxes
+// around the circle of radiusKm at center, with placeholders numbered from
+// first, and the box corners to bind to them. The boxes come from
+// models.NewBoundingBoxesFromCenter, so circles crossing ±180 search both
+// sides and circles covering a pole search every longitude.
+func (p *PostGISIndex) radiusFilter(center models.Location, radiusKm float64, first int) (string, []interface{}) {
+	boxes := models.NewBoundingBoxesFromCenter(center, radiusKm)
+	filters := make([]string, len(boxes))
+	args := make([]interface{}, 0, 4*len(boxes))
+	for i, box := range boxes {
+		n := first + 4*i
+		filters[i] = p.boxFilter(fmt.Sprintf("$%d", n), fmt.Sprintf("$%d", n+1), fmt.Sprintf("$%d", n+2), fmt.Sprintf("$%d", n+3))
+		// The part past ±180 is its own box, so keep the first one in range
+		args = append(args,
+			math.Max(box.BottomLeft.Lon, -180), box.BottomLeft.Lat,
+			math.Min(box.TopRight.Lon, 180), box.TopRight.Lat)
+	}
+	return strings.Join(filters, " OR "), args
+}
+
+// NearestNeighbors returns the n points closest to center using the
+// index-assisted <-> distance operator
+func (p *PostGISIndex) NearestNeighbors(ctx context.Context, center models.Location, n int) ([]*models.Point, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM %s
+		ORDER BY location <-> %s
+		LIMIT $3
+	`, p.selectColumns(), p.tableName(), p.makePoint("$1", "$2"))
+	
+	return scanPoints(p.query(ctx, "nearest", query, center.Lon, center.Lat, n))
+}
+
+// SourceTable names a table of points to load with LoadIntoIndex. Empty
+// fields default to the table this index was configured with and its "id"
+// and "location" columns.
+type SourceTable struct {
+	// Table may be schema qualified ("schema.table")
+	Table string
+	// IDColumn may be of any type that orders and converts to text, such as
+	// an integer serial or a text key
+	IDColumn string
+	// GeometryColumn is a geometry column in any SRID; its points are
+	// transformed to WGS 84 longitude and latitude
+	GeometryColumn string
+}
+
+// LoadIntoIndex streams every point of src into the R-tree index using keyset
+// pagination on its id column, batchSize rows at a time. It returns the number
+// of points loaded.
+func (p *PostGISIndex) LoadIntoIndex(ctx context.Context, index *rtree.GeoIndex, src SourceTable, batchSize int) (loaded int64, err error) {
+	if batchSize <= 0 {
+		batchSize = 10000
+	}
+
+	schema, table := p.schema, p.table
+	if src.Table != "" {
+		schema, table = "", src.Table
+		if i := strings.LastIndex(src.Table, "."); i >= 0 {
+			schema, table = src.Table[:i], src.Table[i+1:]
+		}
+	}
+	idColumn, geomColumn := src.IDColumn, src.GeometryColumn
+	if idColumn == "" {
+		idColumn = "id"
+	}
+	if geomColumn == "" {
+		geomColumn = "location"
+	}
+
+	qualified := pq.QuoteIdentifier(table)
+	if schema != "" {
+		qualified = pq.QuoteIdentifier(schema) + "." + qualified
+	}
+
+	start := time.Now()
+	defer func() {
+		if err != nil {
+			p.log.Error("table load failed", "table", qualified, "loaded", loaded, "error", err)
+			return
+		}
+		p.log.Info("table loaded into index", "table", qualified, "points", loaded, "duration", time.Since(start))
+	}()
+
+	// This index's own column may be geography; another table's geometry
+	// column need not share this index's SRID
+	location := p.locationWGS84()
+	if src.Table != "" || src.GeometryColumn != "" {
+		var srid int
+		err = p.db.QueryRowContext(ctx, "SELECT Find_SRID(COALESCE(NULLIF($1, ''), current_schema()), $2, $3)",
+			schema, table, geomColumn).Scan(&srid)
+		if err != nil {
+			return 0, fmt.Errorf("failed to find SRID of %s.%s: %w", qualified, geomColumn, err)
+		}
+		location = pq.QuoteIdentifier(geomColumn)
+		if srid != wgs84SRID {
+			location = fmt.Sprintf("ST_Transform(%s, %d)", location, wgs84SRID)
+		}
+	}
+
+	id := pq.QuoteIdentifier(idColumn)
+	columns := fmt.Sprintf("%s::text, ST_Y(%[2]s) as lat, ST_X(%[2]s) as lon", id, location)
+	// The first page has no cursor yet; later pages compare against the last
+	// id read, which the server casts back to the column's own type
+	pageQuery := func(after string) string {
+		return fmt.Sprintf(`
+			SELECT %s
+			FROM %s
+			WHERE %s%s IS NOT NULL
+			ORDER BY %s
+			LIMIT $1
+		`, columns, qualified, after, pq.QuoteIdentifier(geomColumn), id)
+	}
+
+	first, err := p.db.PrepareContext(ctx, pageQuery(""))
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer first.Close()
+	next, err := p.db.PrepareContext(ctx, pageQuery(id+" > $2 AND "))
+	if err != nil {
+		return 0, fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer next.Close()
+
+	var batch []*models.Point
+	for {
+		if batch == nil {
+			batch, err = scanPoints(first.QueryContext(ctx, batchSize))
+		} else {
+			batch, err = scanPoints(next.QueryContext(ctx, batchSize, batch[len(batch)-1].ID))
+		}
+		if err != nil {
+			return loaded, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		if err := index.IndexPoints(batch); err != nil {
+			return loaded, fmt.Errorf("failed to index batch: %w", err)
+		}
+		loaded += int64(len(batch))
+
+		if len(batch) < batchSize {
+			break
+		}
+	}
+
+	return loaded, nil
+}
+
+// scanPoints reads (id, lat, lon) rows into points and closes the result set
+func scanPoints(rows *sql.Rows, err error) ([]*models.Point, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute query: %w", err)
 	}
@@ -191,9 +803,9 @@ func (p *PostGISIndex) QueryBox(box models.BoundingBox) ([]*models.Point, error)
 }
 
 // Count returns the number of points in the database
-func (p *PostGISIndex) Count() (int64, error) {
+func (p *PostGISIndex) Count(ctx context.Context) (int64, error) {
 	var count int64
-	err := p.db.QueryRow("SELECT COUNT(*) FROM geo_points").Scan(&count)
+	err := p.db.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s", p.tableName())).Scan(&count)
 	if err != nil {
 		return 0, fmt.Errorf("failed to count points: %w", err)
 	}
@@ -201,12 +813,12 @@ func (p *PostGISIndex) Count() (int64, error) {
 }
 
 // GetDatabaseStats returns database size and table statistics
-func (p *PostGISIndex) GetDatabaseStats() (map[string]interface{}, error) {
+func (p *PostGISIndex) GetDatabaseStats(ctx context.Context) (map[string]interface{}, error) {
 	stats := make(map[string]interface{})
 	
 	// Get database size
 	var dbSize string
-	err := p.db.QueryRow(`
+	err := p.db.QueryRowContext(ctx, `
 		SELECT pg_size_pretty(pg_database_size('geodb'))
 	`).Scan(&dbSize)
 	if err != nil {
@@ -216,11 +828,11 @@ func (p *PostGISIndex) GetDatabaseStats() (map[string]interface{}, error) {
 	
 	// Get table size
 	var tableSize, indexSize string
-	err = p.db.QueryRow(`
+	err = p.db.QueryRowContext(ctx, `
 		SELECT 
-			pg_size_pretty(pg_total_relation_size('geo_points')) as total_size,
-			pg_size_pretty(pg_indexes_size('geo_points')) as index_size
-	`).Scan(&tableSize, &indexSize)
+			pg_size_pretty(pg_total_relation_size($1::regclass)) as total_size,
+			pg_size_pretty(pg_indexes_size($1::regclass)) as index_size
+	`, p.tableName()).Scan(&tableSize, &indexSize)
 	if err != nil {
 		// Table might not exist yet
 		stats["table_size"] = "0 bytes"
@@ -231,7 +843,7 @@ func (p *PostGISIndex) GetDatabaseStats() (map[string]interface{}, error) {
 	}
 	
 	// Get row count
-	count, _ := p.Count()
+	count, _ := p.Count(ctx)
 	stats["row_count"] = count
 	
 	return stats, nil
@@ -239,5 +851,6 @@ func (p *PostGISIndex) GetDatabaseStats() (map[string]interface{}, error) {
 
 // Close closes the database connection
 func (p *PostGISIndex) Close() error {
+	p.closeStatements()
 	return p.db.Close()
 }
\ No newline at end of file
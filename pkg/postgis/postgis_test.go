@@ -0,0 +1,63 @@
+package postgis
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// filterBoxes turns the corner arguments of radiusFilter back into boxes
+func filterBoxes(t *testing.T, args []interface{}) []models.BoundingBox {
+	require.Zero(t, len(args)%4)
+	var boxes []models.BoundingBox
+	for i := 0; i < len(args); i += 4 {
+		boxes = append(boxes, models.BoundingBox{
+			BottomLeft: models.Location{Lon: args[i].(float64), Lat: args[i+1].(float64)},
+			TopRight:   models.Location{Lon: args[i+2].(float64), Lat: args[i+3].(float64)},
+		})
+	}
+	return boxes
+}
+
+func anyContains(boxes []models.BoundingBox, loc models.Location) bool {
+	for _, box := range boxes {
+		if box.Contains(loc) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRadiusFilterCoversPole(t *testing.T) {
+	p := &PostGISIndex{srid: wgs84SRID}
+	center := models.Location{Lat: 80, Lon: 0}
+	target := models.Location{Lat: 88, Lon: 120}
+	require.Less(t, center.DistanceTo(target), 1500.0)
+
+	filter, args := p.radiusFilter(center, 1500, 4)
+	boxes := filterBoxes(t, args)
+	assert.True(t, anyContains(boxes, target), "boxes %v miss %v", boxes, target)
+	assert.Contains(t, filter, "$4")
+	assert.Equal(t, len(boxes), strings.Count(filter, "ST_MakeEnvelope"))
+}
+
+func TestRadiusFilterCrossesAntimeridian(t *testing.T) {
+	p := &PostGISIndex{srid: wgs84SRID}
+	center := models.Location{Lat: 10, Lon: 179.5}
+	target := models.Location{Lat: 10, Lon: -179.9}
+	require.Less(t, center.DistanceTo(target), 100.0)
+
+	filter, args := p.radiusFilter(center, 100, 4)
+	boxes := filterBoxes(t, args)
+	require.Len(t, boxes, 2)
+	assert.Contains(t, filter, " OR ")
+	assert.Contains(t, filter, "$11")
+	assert.True(t, anyContains(boxes, target), "boxes %v miss %v", boxes, target)
+	assert.True(t, anyContains(boxes, center))
+	for _, box := range boxes {
+		assert.NoError(t, box.Validate())
+	}
+}
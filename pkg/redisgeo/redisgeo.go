@@ -0,0 +1,414 @@
+// Package redisgeo keeps points in a Redis sorted set through the GEO
+// commands, so Redis can be benchmarked against the in-memory index from the
+// same harness. It needs Redis 6.2 or later for GEOSEARCH and speaks RESP
+// over a small pool of its own connections rather than depending on a client
+// library.
+//
+// Redis stores coordinates as 52-bit geohashes, about 0.6 m apart, and
+// cannot store latitudes beyond ±MaxLatitude. Queries return the stored
+// coordinates and apply this repository's semantics to them: boxes include
+// their edges and radii are haversine kilometers on a 6371 km sphere.
+package redisgeo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+)
+
+const (
+	// MaxLatitude is the largest latitude Redis stores, in either hemisphere
+	MaxLatitude = 85.05112878
+
+	defaultKey      = "geoindex:points"
+	defaultPoolSize = 16
+	defaultTimeout  = 5 * time.Second
+
+	// addBatch is the number of points sent per GEOADD
+	addBatch = 1000
+
+	// earthRadiusKm is the radius this repository measures distances with;
+	// redisEarthRadiusKm is the one Redis does
+	earthRadiusKm      = 6371.0
+	redisEarthRadiusKm = 6372.797560856
+	kmPerDegree        = math.Pi * earthRadiusKm / 180
+
+	// nearestStartKm is the radius NearestNeighbors searches first,
+	// quadrupling it until enough points are found
+	nearestStartKm = 10
+)
+
+// Index is a Redis GEO key holding points by ID
+type Index struct {
+	addr     string
+	password string
+	db       int
+	key      string
+	timeout  time.Duration
+	pool     chan *conn
+}
+
+// Option configures an Index
+type Option func(*Index)
+
+// WithKey sets the sorted set holding the points, "geoindex:points" by default
+func WithKey(key string) Option {
+	return func(x *Index) {
+		x.key = key
+	}
+}
+
+// WithPassword authenticates every connection with AUTH
+func WithPassword(password string) Option {
+	return func(x *Index) {
+		x.password = password
+	}
+}
+
+// WithDB selects the logical database of every connection
+func WithDB(db int) Option {
+	return func(x *Index) {
+		x.db = db
+	}
+}
+
+// WithPoolSize sets how many idle connections are kept, 16 by default.
+// Concurrent queries beyond it open short-lived connections.
+func WithPoolSize(n int) Option {
+	return func(x *Index) {
+		if n > 0 {
+			x.pool = make(chan *conn, n)
+		}
+	}
+}
+
+// WithTimeout bounds every command that has no earlier context deadline,
+// 5s by default
+func WithTimeout(d time.Duration) Option {
+	return func(x *Index) {
+		if d > 0 {
+			x.timeout = d
+		}
+	}
+}
+
+// New connects to the Redis server at addr and checks it answers
+func New(addr string, opts ...Option) (*Index, error) {
+	x := &Index{
+		addr:    addr,
+		key:     defaultKey,
+		timeout: defaultTimeout,
+		pool:    make(chan *conn, defaultPoolSize),
+	}
+	for _, opt := range opts {
+		opt(x)
+	}
+	if _, err := x.do(context.Background(), "PING"); err != nil {
+		return nil, fmt.Errorf("failed to connect to redis at %s: %w", addr, err)
+	}
+	return x, nil
+}
+
+// Key returns the sorted set holding the points
+func (x *Index) Key() string {
+	return x.key
+}
+
+// dial opens a connection, authenticated and on the configured database
+func (x *Index) dial(ctx context.Context) (*conn, error) {
+	d := net.Dialer{Timeout: x.timeout}
+	nc, err := d.DialContext(ctx, "tcp", x.addr)
+	if err != nil {
+		return nil, err
+	}
+	c := newConn(nc)
+	deadline := time.Now().Add(x.timeout)
+	if x.password != "" {
+		if _, err := c.do(deadline, "AUTH", x.password); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	if x.db != 0 {
+		if _, err := c.do(deadline, "SELECT", strconv.Itoa(x.db)); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// do runs one command on a pooled connection. Connections that fail with
+// anything but an error reply are closed, as their stream may be mid-reply.
+func (x *Index) do(ctx context.Context, args ...string) (any, error) {
+	var c *conn
+	select {
+	case c = <-x.pool:
+	default:
+		var err error
+		if c, err = x.dial(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	deadline := time.Now().Add(x.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	reply, err := c.do(deadline, args...)
+	var replyErr Error
+	if err != nil && !errors.As(err, &replyErr) {
+		c.nc.Close()
+		return nil, err
+	}
+
+	select {
+	case x.pool <- c:
+	default:
+		c.nc.Close()
+	}
+	return reply, err
+}
+
+// Add stores points by ID, moving those already stored, and returns how many
+// it sent. Points without a location or beyond ±MaxLatitude, which Redis
+// rejects, are skipped.
+func (x *Index) Add(ctx context.Context, points []*models.Point) (int, error) {
+	added := 0
+	args := []string{"GEOADD", x.key}
+	flush := func() error {
+		if len(args) == 2 {
+			return nil
+		}
+		_, err := x.do(ctx, args...)
+		args = args[:2]
+		return err
+	}
+	for _, p := range points {
+		if p.Location == nil || math.Abs(p.Location.Lat) > MaxLatitude {
+			continue
+		}
+		args = append(args, formatFloat(p.Location.Lon), formatFloat(p.Location.Lat), p.ID)
+		added++
+		if len(args) == 2+3*addBatch {
+			if err := flush(); err != nil {
+				return 0, fmt.Errorf("failed to add points: %w", err)
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return 0, fmt.Errorf("failed to add points: %w", err)
+	}
+	return added, nil
+}
+
+// Delete removes the points with the given IDs and returns how many were
+// stored
+func (x *Index) Delete(ctx context.Context, ids ...string) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	reply, err := x.do(ctx, append([]string{"ZREM", x.key}, ids...)...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete points: %w", err)
+	}
+	n, ok := reply.(int64)
+	if !ok {
+		return 0, errUnexpected
+	}
+	return n, nil
+}
+
+// Clear removes the key and every point in it
+func (x *Index) Clear(ctx context.Context) error {
+	if _, err := x.do(ctx, "DEL", x.key); err != nil {
+		return fmt.Errorf("failed to clear %s: %w", x.key, err)
+	}
+	return nil
+}
+
+// Count returns the number of stored points
+func (x *Index) Count(ctx context.Context) (int64, error) {
+	reply, err := x.do(ctx, "ZCARD", x.key)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count points: %w", err)
+	}
+	n, ok := reply.(int64)
+	if !ok {
+		return 0, errUnexpected
+	}
+	return n, nil
+}
+
+// QueryBox returns the points inside box with GEOSEARCH BYBOX. Redis boxes
+// are sized in kilometers around a center, so the search covers box at its
+// widest latitude and its results are trimmed to box.
+func (x *Index) QueryBox(ctx context.Context, box models.BoundingBox) ([]*models.Point, error) {
+	bl, tr := box.BottomLeft, box.TopRight
+	if tr.Lat < bl.Lat || tr.Lon < bl.Lon {
+		return nil, nil
+	}
+	centerLat := clampLat((bl.Lat + tr.Lat) / 2)
+	centerLon := (bl.Lon + tr.Lon) / 2
+
+	// Redis measures a point's east-west offset along its own latitude, so
+	// the latitude nearest the equator needs the widest box
+	widest := 0.0
+	if bl.Lat > 0 || tr.Lat < 0 {
+		widest = math.Min(math.Abs(bl.Lat), math.Abs(tr.Lat))
+	}
+	heightKm := 2 * math.Max(tr.Lat-centerLat, centerLat-bl.Lat) * kmPerDegree
+	widthKm := (tr.Lon - bl.Lon) * kmPerDegree * math.Cos(widest*math.Pi/180)
+
+	points, err := x.search(ctx, centerLat, centerLon,
+		"BYBOX", formatFloat(redisKm(widthKm)), formatFloat(redisKm(heightKm)), "km")
+	if err != nil {
+		return nil, err
+	}
+	results := points[:0]
+	for _, p := range points {
+		if box.Contains(*p.Location) {
+			results = append(results, p)
+		}
+	}
+	return results, nil
+}
+
+// QueryRadius returns the points within radiusKm of center with GEOSEARCH
+// BYRADIUS
+func (x *Index) QueryRadius(ctx context.Context, center models.Location, radiusKm float64) ([]*models.Point, error) {
+	return x.within(ctx, center, radiusKm)
+}
+
+// within returns the points within radiusKm of center, in no order
+func (x *Index) within(ctx context.Context, center models.Location, radiusKm float64, extra ...string) ([]*models.Point, error) {
+	// A center beyond Redis's latitudes is searched from the nearest one it
+	// accepts, with the radius grown to still reach around the original
+	lat := clampLat(center.Lat)
+	searchKm := radiusKm + math.Abs(center.Lat-lat)*kmPerDegree
+
+	args := append([]string{"BYRADIUS", formatFloat(redisKm(searchKm)), "km"}, extra...)
+	points, err := x.search(ctx, lat, center.Lon, args...)
+	if err != nil {
+		return nil, err
+	}
+	results := points[:0]
+	for _, p := range points {
+		if center.DistanceTo(*p.Location) <= radiusKm {
+			results = append(results, p)
+		}
+	}
+	return results, nil
+}
+
+// NearestNeighbors returns up to n points nearest to center, nearest first,
+// by widening a GEOSEARCH BYRADIUS ... ASC COUNT n until it finds n points or
+// covers the globe
+func (x *Index) NearestNeighbors(ctx context.Context, center models.Location, n int) ([]*models.Point, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	// Redis ranks by distance from where it searches, so a center beyond its
+	// latitudes gets every match and ranks them here
+	var limit []string
+	if clampLat(center.Lat) == center.Lat {
+		limit = []string{"ASC", "COUNT", strconv.Itoa(n)}
+	}
+	var results []*models.Point
+	for radiusKm := float64(nearestStartKm); ; radiusKm *= 4 {
+		var err error
+		results, err = x.within(ctx, center, radiusKm, limit...)
+		if err != nil {
+			return nil, err
+		}
+		if len(results) >= n || radiusKm >= math.Pi*earthRadiusKm {
+			break
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return center.DistanceTo(*results[i].Location) < center.DistanceTo(*results[j].Location)
+	})
+	if len(results) > n {
+		results = results[:n]
+	}
+	return results, nil
+}
+
+// search runs GEOSEARCH from lat, lon with the shape arguments and returns
+// the matches with their stored coordinates
+func (x *Index) search(ctx context.Context, lat, lon float64, shape ...string) ([]*models.Point, error) {
+	args := append([]string{"GEOSEARCH", x.key, "FROMLONLAT", formatFloat(lon), formatFloat(lat)}, shape...)
+	reply, err := x.do(ctx, append(args, "WITHCOORD")...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search: %w", err)
+	}
+	return parseMatches(reply)
+}
+
+// parseMatches reads GEOSEARCH WITHCOORD replies, [[member, [lon, lat]], ...]
+func parseMatches(reply any) ([]*models.Point, error) {
+	items, ok := reply.([]any)
+	if !ok && reply != nil {
+		return nil, errUnexpected
+	}
+	points := make([]*models.Point, 0, len(items))
+	for _, item := range items {
+		match, ok := item.([]any)
+		if !ok || len(match) != 2 {
+			return nil, errUnexpected
+		}
+		id, ok := match[0].(string)
+		coord, ok2 := match[1].([]any)
+		if !ok || !ok2 || len(coord) != 2 {
+			return nil, errUnexpected
+		}
+		lonStr, ok := coord[0].(string)
+		latStr, ok2 := coord[1].(string)
+		if !ok || !ok2 {
+			return nil, errUnexpected
+		}
+		lon, err := strconv.ParseFloat(lonStr, 64)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed longitude %q", lonStr)
+		}
+		lat, err := strconv.ParseFloat(latStr, 64)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed latitude %q", latStr)
+		}
+		points = append(points, &models.Point{ID: id, Location: &models.Location{Lat: lat, Lon: lon}})
+	}
+	return points, nil
+}
+
+// Close closes the idle connections
+func (x *Index) Close() error {
+	for {
+		select {
+		case c := <-x.pool:
+			c.nc.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// redisKm converts a distance to Redis's larger sphere, with a margin for
+// its geohash rounding, so a search reaches every point within it
+func redisKm(km float64) float64 {
+	return km*redisEarthRadiusKm/earthRadiusKm*1.001 + 0.001
+}
+
+func clampLat(lat float64) float64 {
+	return math.Max(-MaxLatitude, math.Min(MaxLatitude, lat))
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,333 @@
+package redisgeo
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRedis serves the commands Index sends, with the GEOSEARCH semantics of
+// Redis: distances on its sphere, and boxes measuring a point's east-west
+// offset along the point's own latitude
+type fakeRedis struct {
+	ln       net.Listener
+	password string
+
+	mu     sync.Mutex
+	points map[string]models.Location
+	calls  []string
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	f := &fakeRedis{ln: ln, password: password, points: make(map[string]models.Location)}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			nc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(nc)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(nc net.Conn) {
+	defer nc.Close()
+	r, w := bufio.NewReader(nc), bufio.NewWriter(nc)
+	authed := f.password == ""
+	for {
+		reply, err := readReply(r)
+		if err != nil {
+			return
+		}
+		items, _ := reply.([]any)
+		args := make([]string, len(items))
+		for i, item := range items {
+			args[i], _ = item.(string)
+		}
+		cmd := strings.ToUpper(args[0])
+		switch {
+		case cmd == "AUTH":
+			authed = args[1] == f.password
+			if !authed {
+				w.WriteString("-WRONGPASS invalid password\r\n")
+				break
+			}
+			w.WriteString("+OK\r\n")
+		case !authed:
+			w.WriteString("-NOAUTH Authentication required.\r\n")
+		default:
+			f.mu.Lock()
+			f.calls = append(f.calls, cmd)
+			writeFakeReply(w, f.exec(cmd, args[1:]))
+			f.mu.Unlock()
+		}
+		if w.Flush() != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeRedis) exec(cmd string, args []string) any {
+	switch cmd {
+	case "PING", "SELECT":
+		return "PONG"
+	case "ZCARD":
+		return int64(len(f.points))
+	case "DEL":
+		f.points = make(map[string]models.Location)
+		return int64(1)
+	case "ZREM":
+		n := int64(0)
+		for _, id := range args[1:] {
+			if _, ok := f.points[id]; ok {
+				delete(f.points, id)
+				n++
+			}
+		}
+		return n
+	case "GEOADD":
+		for i := 1; i+2 < len(args); i += 3 {
+			lon, _ := strconv.ParseFloat(args[i], 64)
+			lat, _ := strconv.ParseFloat(args[i+1], 64)
+			if math.Abs(lat) > MaxLatitude || math.Abs(lon) > 180 {
+				return Error("ERR invalid longitude,latitude pair")
+			}
+			f.points[args[i+2]] = models.Location{Lat: lat, Lon: lon}
+		}
+		return int64(len(args) / 3)
+	case "GEOSEARCH":
+		return f.search(args[1:])
+	}
+	return Error("ERR unknown command '" + cmd + "'")
+}
+
+// search is GEOSEARCH key FROMLONLAT lon lat BYRADIUS r km | BYBOX w h km
+// [ASC] [COUNT n] WITHCOORD, without the key
+func (f *fakeRedis) search(args []string) any {
+	num := func(s string) float64 { v, _ := strconv.ParseFloat(s, 64); return v }
+	center := models.Location{Lon: num(args[1]), Lat: num(args[2])}
+	if math.Abs(center.Lat) > MaxLatitude {
+		return Error("ERR invalid longitude,latitude pair")
+	}
+	redisDistance := func(a, b models.Location) float64 {
+		return a.DistanceTo(b) * redisEarthRadiusKm / earthRadiusKm
+	}
+	var inside func(p models.Location) bool
+	rest := args[3:]
+	switch rest[0] {
+	case "BYRADIUS":
+		radius := num(rest[1])
+		inside = func(p models.Location) bool { return redisDistance(center, p) <= radius }
+		rest = rest[3:]
+	case "BYBOX":
+		width, height := num(rest[1]), num(rest[2])
+		if width < 0 || height < 0 {
+			return Error("ERR height or width cannot be negative")
+		}
+		inside = func(p models.Location) bool {
+			latKm := math.Abs(p.Lat-center.Lat) * math.Pi / 180 * redisEarthRadiusKm
+			lonKm := redisDistance(models.Location{Lat: p.Lat, Lon: center.Lon}, p)
+			return latKm <= height/2 && lonKm <= width/2
+		}
+		rest = rest[4:]
+	}
+
+	var ids []string
+	for id, p := range f.points {
+		if inside(p) {
+			ids = append(ids, id)
+		}
+	}
+	count := -1
+	for i := 0; i < len(rest); i++ {
+		switch rest[i] {
+		case "ASC":
+			sort.Slice(ids, func(a, b int) bool {
+				return redisDistance(center, f.points[ids[a]]) < redisDistance(center, f.points[ids[b]])
+			})
+		case "COUNT":
+			count, _ = strconv.Atoi(rest[i+1])
+			i++
+		}
+	}
+	if count >= 0 && len(ids) > count {
+		ids = ids[:count]
+	}
+	matches := make([]any, len(ids))
+	for i, id := range ids {
+		p := f.points[id]
+		matches[i] = []any{id, []any{formatFloat(p.Lon), formatFloat(p.Lat)}}
+	}
+	return matches
+}
+
+func writeFakeReply(w *bufio.Writer, reply any) {
+	switch v := reply.(type) {
+	case Error:
+		w.WriteString("-" + string(v) + "\r\n")
+	case int64:
+		w.WriteString(":" + strconv.FormatInt(v, 10) + "\r\n")
+	case string:
+		w.WriteString("$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n")
+	case []any:
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, item := range v {
+			writeFakeReply(w, item)
+		}
+	}
+}
+
+func testPoints(n int) []*models.Point {
+	rng := rand.New(rand.NewSource(11))
+	points := make([]*models.Point, n)
+	for i := range points {
+		points[i] = &models.Point{
+			ID:       fmt.Sprintf("point_%d", i),
+			Location: &models.Location{Lat: rng.Float64()*170 - 85, Lon: rng.Float64()*360 - 180},
+		}
+	}
+	return points
+}
+
+func ids(points []*models.Point) []string {
+	out := make([]string, len(points))
+	for i, p := range points {
+		out[i] = p.ID
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestIndex(t *testing.T) {
+	fake := newFakeRedis(t, "s3cret")
+	ctx := context.Background()
+
+	_, err := New(fake.ln.Addr().String())
+	assert.ErrorContains(t, err, "NOAUTH")
+
+	x, err := New(fake.ln.Addr().String(), WithPassword("s3cret"), WithDB(2), WithPoolSize(2))
+	require.NoError(t, err)
+	defer x.Close()
+
+	points := testPoints(3000)
+	polar := &models.Point{ID: "polar", Location: &models.Location{Lat: 89, Lon: 0}}
+	added, err := x.Add(ctx, append(points, polar))
+	require.NoError(t, err)
+	assert.Equal(t, len(points), added)
+	count, err := x.Count(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(points)), count)
+
+	for _, box := range []models.BoundingBox{
+		{BottomLeft: models.Location{Lat: 10, Lon: -30}, TopRight: models.Location{Lat: 40, Lon: 20}},
+		{BottomLeft: models.Location{Lat: -70, Lon: 100}, TopRight: models.Location{Lat: -20, Lon: 180}},
+		{BottomLeft: models.Location{Lat: 60, Lon: -180}, TopRight: models.Location{Lat: 90, Lon: 180}},
+		{BottomLeft: models.Location{Lat: -90, Lon: -180}, TopRight: models.Location{Lat: 90, Lon: 180}},
+	} {
+		var want []*models.Point
+		for _, p := range points {
+			if box.Contains(*p.Location) {
+				want = append(want, p)
+			}
+		}
+		got, err := x.QueryBox(ctx, box)
+		require.NoError(t, err)
+		assert.Equal(t, ids(want), ids(got), "box %v", box)
+	}
+
+	for _, center := range []models.Location{{Lat: 48.85, Lon: 2.35}, {Lat: -33.9, Lon: 151.2}, {Lat: 88, Lon: 10}} {
+		var want []*models.Point
+		for _, p := range points {
+			if center.DistanceTo(*p.Location) <= 1500 {
+				want = append(want, p)
+			}
+		}
+		got, err := x.QueryRadius(ctx, center, 1500)
+		require.NoError(t, err)
+		assert.Equal(t, ids(want), ids(got), "radius around %v", center)
+
+		sorted := append([]*models.Point(nil), points...)
+		sort.Slice(sorted, func(i, j int) bool {
+			return center.DistanceTo(*sorted[i].Location) < center.DistanceTo(*sorted[j].Location)
+		})
+		nearest, err := x.NearestNeighbors(ctx, center, 10)
+		require.NoError(t, err)
+		require.Len(t, nearest, 10)
+		for i := range nearest {
+			assert.Equal(t, sorted[i].ID, nearest[i].ID)
+		}
+	}
+
+	// Adding a point again moves it
+	moved := &models.Point{ID: points[0].ID, Location: &models.Location{Lat: 1, Lon: 1}}
+	_, err = x.Add(ctx, []*models.Point{moved})
+	require.NoError(t, err)
+	got, err := x.QueryRadius(ctx, *moved.Location, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{moved.ID}, ids(got))
+
+	n, err := x.Delete(ctx, points[0].ID, "missing")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+	require.NoError(t, x.Clear(ctx))
+	count, err = x.Count(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, count)
+
+	fake.mu.Lock()
+	assert.Contains(t, fake.calls, "SELECT")
+	fake.mu.Unlock()
+}
+
+func TestReplies(t *testing.T) {
+	read := func(s string) (any, error) {
+		return readReply(bufio.NewReader(strings.NewReader(s)))
+	}
+	reply, err := read("*3\r\n$3\r\nabc\r\n:-7\r\n*2\r\n$-1\r\n-ERR nested\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, []any{"abc", int64(-7), []any{nil, Error("ERR nested")}}, reply)
+
+	reply, err = read("$0\r\n\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "", reply)
+
+	for _, bad := range []string{"", "?x\r\n", ":1\n", "$5\r\nab\r\n", "*2\r\n:1\r\n", ":x\r\n"} {
+		_, err := read(bad)
+		assert.Error(t, err, "%q", bad)
+	}
+
+	var buf strings.Builder
+	w := bufio.NewWriter(&buf)
+	require.NoError(t, writeCommand(w, []string{"GEOADD", "k", "1.5", "2", "a b"}))
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "*5\r\n$6\r\nGEOADD\r\n$1\r\nk\r\n$3\r\n1.5\r\n$1\r\n2\r\n$3\r\na b\r\n", buf.String())
+
+	fake := newFakeRedis(t, "")
+	x, err := New(fake.ln.Addr().String())
+	require.NoError(t, err)
+	defer x.Close()
+	_, err = x.do(context.Background(), "NOPE")
+	var replyErr Error
+	assert.True(t, errors.As(err, &replyErr))
+	// The connection survives an error reply
+	_, err = x.Count(context.Background())
+	assert.NoError(t, err)
+}
@@ -0,0 +1,172 @@
+package report
+
+import (
+	"html/template"
+	"io"
+	"math"
+)
+
+// Chart geometry in SVG user units
+const (
+	chartLabelWidth = 140
+	chartBarWidth   = 420
+	chartTextWidth  = 180
+	chartBarHeight  = 22
+	chartBarGap     = 8
+)
+
+// htmlPage is the data of htmlTemplate
+type htmlPage struct {
+	*Report
+	Generated string
+	Sections  []htmlSection
+}
+
+type htmlSection struct {
+	Title   string
+	Engines []string
+	Rows    []htmlRow
+	Chart   *htmlChart
+}
+
+type htmlRow struct {
+	Metric string
+	Cells  []htmlCell
+}
+
+type htmlCell struct {
+	Text string
+	Best bool
+}
+
+type htmlChart struct {
+	Metric, Baseline string
+	Width, Height    int
+	Bars             []htmlBar
+}
+
+type htmlBar struct {
+	Label, Text         string
+	X, Y, Height, TextY int
+	Width, TextX        float64
+	Best                bool
+}
+
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Roboto, Helvetica, Arial, sans-serif; color: #1f2328; margin: 2rem auto; max-width: 960px; padding: 0 1rem; }
+h1 { margin-bottom: 0.25rem; }
+.generated { color: #656d76; margin-top: 0; }
+dl { display: grid; grid-template-columns: max-content auto; gap: 0.25rem 1rem; }
+dt { font-weight: 600; }
+dd { margin: 0; }
+section { margin-top: 2.5rem; }
+table { border-collapse: collapse; width: 100%; margin: 1rem 0; }
+th, td { border-bottom: 1px solid #d0d7de; padding: 0.4rem 0.75rem; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+thead th { background: #f6f8fa; }
+td.best { font-weight: 700; color: #1a7f37; }
+figcaption { color: #656d76; font-size: 0.9rem; }
+svg text { font-size: 13px; fill: #1f2328; }
+svg rect { fill: #8c959f; }
+svg rect.best { fill: #2da44e; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{if .Generated}}<p class="generated">Generated {{.Generated}}</p>{{end}}
+{{if .Context}}<dl>
+{{range .Context}}<dt>{{.Name}}</dt><dd>{{.Value}}</dd>
+{{end}}</dl>{{end}}
+{{range .Notes}}<p>{{.}}</p>
+{{end}}
+{{range .Sections}}<section>
+<h2>{{.Title}}</h2>
+<table>
+<thead><tr><th>Metric</th>{{range .Engines}}<th>{{.}}</th>{{end}}</tr></thead>
+<tbody>
+{{range .Rows}}<tr><td>{{.Metric}}</td>{{range .Cells}}<td{{if .Best}} class="best"{{end}}>{{.Text}}</td>{{end}}</tr>
+{{end}}</tbody>
+</table>
+{{with .Chart}}<figure>
+<svg xmlns="http://www.w3.org/2000/svg" width="{{.Width}}" height="{{.Height}}" viewBox="0 0 {{.Width}} {{.Height}}" role="img" aria-label="{{.Metric}}">
+{{range .Bars}}<text x="0" y="{{.TextY}}">{{.Label}}</text>
+<rect x="{{.X}}" y="{{.Y}}" width="{{printf "%.1f" .Width}}" height="{{.Height}}" rx="3"{{if .Best}} class="best"{{end}}></rect>
+<text x="{{printf "%.1f" .TextX}}" y="{{.TextY}}">{{.Text}}</text>
+{{end}}</svg>
+<figcaption>{{.Metric}}, relative to {{.Baseline}}</figcaption>
+</figure>{{end}}
+</section>
+{{end}}</body>
+</html>
+`))
+
+// WriteHTML writes the report as a single HTML page with inline styles and
+// SVG bar charts, so it opens anywhere without network access
+func (r *Report) WriteHTML(w io.Writer) error {
+	page := htmlPage{Report: r}
+	if !r.Generated.IsZero() {
+		page.Generated = r.Generated.UTC().Format("2006-01-02 15:04 MST")
+	}
+	for _, s := range r.Sections {
+		hs := htmlSection{Title: s.Title, Engines: s.Engines}
+		for _, row := range s.Rows {
+			hr := htmlRow{Metric: row.Metric}
+			top := best(row)
+			for i := range s.Engines {
+				v := Missing
+				if i < len(row.Values) {
+					v = row.Values[i]
+				}
+				hr.Cells = append(hr.Cells, htmlCell{Text: Format(v, row.Unit), Best: i == top})
+			}
+			hs.Rows = append(hs.Rows, hr)
+		}
+		if row, ok := s.chartRow(); ok {
+			hs.Chart = newHTMLChart(s, row)
+		}
+		page.Sections = append(page.Sections, hs)
+	}
+	return htmlTemplate.Execute(w, page)
+}
+
+// newHTMLChart lays out row as one horizontal bar per engine, scaled to the
+// largest value
+func newHTMLChart(s Section, row Row) *htmlChart {
+	chart := &htmlChart{
+		Metric:   row.Metric,
+		Baseline: s.Engines[0],
+		Width:    chartLabelWidth + chartBarWidth + chartTextWidth,
+		Height:   len(s.Engines)*(chartBarHeight+chartBarGap) - chartBarGap,
+	}
+	top, winner := maxValue(row), best(row)
+	for i, engine := range s.Engines {
+		v := Missing
+		if i < len(row.Values) {
+			v = row.Values[i]
+		}
+		bar := htmlBar{
+			Label:  engine,
+			Text:   Format(v, row.Unit),
+			X:      chartLabelWidth,
+			Y:      i * (chartBarHeight + chartBarGap),
+			Height: chartBarHeight,
+			Best:   i == winner,
+		}
+		bar.TextY = bar.Y + chartBarHeight*3/4
+		if !math.IsNaN(v) && top > 0 {
+			bar.Width = v / top * chartBarWidth
+		}
+		bar.TextX = chartLabelWidth + bar.Width + 6
+		if rel := relative(row, v); rel != "" && i > 0 {
+			bar.Text += " (" + rel + ")"
+		}
+		chart.Bars = append(chart.Bars, bar)
+	}
+	return chart
+}
@@ -0,0 +1,94 @@
+package report
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// markdownBarWidth is the number of block characters of the longest bar
+const markdownBarWidth = 30
+
+// WriteMarkdown writes the report as Markdown: a table per section, the
+// best value of each row in bold, and the chart row as text bars
+func (r *Report) WriteMarkdown(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# %s\n\n", r.Title)
+	if !r.Generated.IsZero() {
+		fmt.Fprintf(bw, "Generated %s\n\n", r.Generated.UTC().Format("2006-01-02 15:04 MST"))
+	}
+	for _, f := range r.Context {
+		fmt.Fprintf(bw, "- **%s**: %s\n", f.Name, f.Value)
+	}
+	if len(r.Context) > 0 {
+		bw.WriteString("\n")
+	}
+	for _, note := range r.Notes {
+		fmt.Fprintf(bw, "%s\n\n", note)
+	}
+
+	for _, s := range r.Sections {
+		fmt.Fprintf(bw, "## %s\n\n", s.Title)
+		bw.WriteString("| Metric |")
+		for _, engine := range s.Engines {
+			fmt.Fprintf(bw, " %s |", markdownCell(engine))
+		}
+		bw.WriteString("\n| --- |" + strings.Repeat(" ---: |", len(s.Engines)) + "\n")
+		for _, row := range s.Rows {
+			fmt.Fprintf(bw, "| %s |", markdownCell(row.Metric))
+			top := best(row)
+			for i := range s.Engines {
+				v := Missing
+				if i < len(row.Values) {
+					v = row.Values[i]
+				}
+				cell := markdownCell(Format(v, row.Unit))
+				if i == top {
+					cell = "**" + cell + "**"
+				}
+				fmt.Fprintf(bw, " %s |", cell)
+			}
+			bw.WriteString("\n")
+		}
+		bw.WriteString("\n")
+
+		if row, ok := s.chartRow(); ok {
+			writeMarkdownChart(bw, s, row)
+		}
+	}
+	return bw.Flush()
+}
+
+// writeMarkdownChart draws row as bars of block characters in a code block
+func writeMarkdownChart(w *bufio.Writer, s Section, row Row) {
+	fmt.Fprintf(w, "%s, relative to %s:\n\n```\n", row.Metric, s.Engines[0])
+	labelWidth := 0
+	for _, engine := range s.Engines {
+		labelWidth = max(labelWidth, len(engine))
+	}
+	top := maxValue(row)
+	for i, engine := range s.Engines {
+		v := Missing
+		if i < len(row.Values) {
+			v = row.Values[i]
+		}
+		n := 0
+		if !math.IsNaN(v) && top > 0 {
+			n = int(math.Round(v / top * markdownBarWidth))
+		}
+		line := fmt.Sprintf("%-*s  %s%s  %s", labelWidth, engine,
+			strings.Repeat("█", n), strings.Repeat(" ", markdownBarWidth-n), Format(v, row.Unit))
+		if rel := relative(row, v); rel != "" && i > 0 {
+			line += " (" + rel + ")"
+		}
+		w.WriteString(strings.TrimRight(line, " ") + "\n")
+	}
+	w.WriteString("```\n\n")
+}
+
+// markdownCell escapes the characters that would end a table cell
+func markdownCell(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "|", `\|`), "\n", " ")
+}
@@ -0,0 +1,214 @@
+// Package report renders benchmark comparisons as a self-contained HTML page,
+// with inline SVG bar charts and no external assets, or as Markdown, so
+// results can be shared as a file instead of terminal screenshots.
+package report
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Unit says how the values of a row are formatted and which is best
+type Unit int
+
+const (
+	// Count values are plain numbers with no best value
+	Count Unit = iota
+	// PerSecond values are rates where higher is better
+	PerSecond
+	// Duration values are nanoseconds where lower is better
+	Duration
+	// Ratio values are multipliers, such as speedups, with no best value
+	Ratio
+	// Percent values are fractions shown as percentages, with no best value
+	Percent
+	// Bytes values are sizes shown in binary units, with no best value
+	Bytes
+)
+
+// Report compares engines, such as index backends, in one or more sections
+type Report struct {
+	Title     string
+	Generated time.Time
+	// Context describes the run: dataset, machine, seed and so on
+	Context []Field
+	// Notes are paragraphs shown after the context
+	Notes    []string
+	Sections []Section
+}
+
+// Field is a labelled value of the run context
+type Field struct {
+	Name, Value string
+}
+
+// Section is a table with one column per engine and one row per metric
+type Section struct {
+	Title   string
+	Engines []string
+	Rows    []Row
+	// Chart names the row drawn as a bar chart, empty for none
+	Chart string
+}
+
+// Row is one metric with a value per engine of its section. NaN marks an
+// engine without the metric.
+type Row struct {
+	Metric string
+	Unit   Unit
+	Values []float64
+}
+
+// Missing is the value of an engine without the metric
+var Missing = math.NaN()
+
+// Write writes the report to path as HTML for .html and .htm files and as
+// Markdown for .md files
+func (r *Report) Write(path string) error {
+	var write func(io.Writer) error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".html", ".htm":
+		write = r.WriteHTML
+	case ".md", ".markdown":
+		write = r.WriteMarkdown
+	default:
+		return fmt.Errorf("unknown report format %q (use .html or .md)", filepath.Ext(path))
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	defer file.Close()
+	if err := write(file); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return file.Close()
+}
+
+// Format formats v in unit u as reports show it
+func Format(v float64, u Unit) string {
+	switch {
+	case math.IsNaN(v):
+		return "N/A"
+	case u == PerSecond:
+		return groupThousands(v) + "/s"
+	case u == Ratio:
+		return fmt.Sprintf("%.2fx", v)
+	case u == Percent:
+		return fmt.Sprintf("%.0f%%", v*100)
+	case u == Bytes:
+		return formatBytes(v)
+	case u == Duration:
+		d := time.Duration(v)
+		switch {
+		case d >= time.Second:
+			return d.Round(time.Millisecond).String()
+		case d >= time.Millisecond:
+			return d.Round(10 * time.Microsecond).String()
+		case d >= time.Microsecond:
+			return d.Round(10 * time.Nanosecond).String()
+		}
+		return d.String()
+	case v == math.Trunc(v):
+		return groupThousands(v)
+	}
+	return fmt.Sprintf("%.2f", v)
+}
+
+// groupThousands formats v rounded to an integer with thousands separators
+func groupThousands(v float64) string {
+	s := fmt.Sprintf("%.0f", math.Abs(v))
+	var b strings.Builder
+	if v < 0 && s != "0" {
+		b.WriteByte('-')
+	}
+	for i, c := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// formatBytes formats a size in B, KiB, MiB or GiB
+func formatBytes(v float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB"}
+	i := 0
+	for ; i < len(units)-1 && math.Abs(v) >= 1024; i++ {
+		v /= 1024
+	}
+	if i == 0 {
+		return groupThousands(v) + " B"
+	}
+	return fmt.Sprintf("%.1f %s", v, units[i])
+}
+
+// best returns the index of the best value of row, or -1 when the unit has
+// no best or fewer than two engines have values
+func best(row Row) int {
+	if row.Unit != PerSecond && row.Unit != Duration {
+		return -1
+	}
+	index, present := -1, 0
+	for i, v := range row.Values {
+		if math.IsNaN(v) {
+			continue
+		}
+		present++
+		if index < 0 || (row.Unit == PerSecond && v > row.Values[index]) || (row.Unit == Duration && v < row.Values[index]) {
+			index = i
+		}
+	}
+	if present < 2 {
+		return -1
+	}
+	return index
+}
+
+// chartRow returns the row of s drawn as a chart. A single engine has
+// nothing to compare, so it gets no chart.
+func (s Section) chartRow() (Row, bool) {
+	if len(s.Engines) < 2 {
+		return Row{}, false
+	}
+	for _, row := range s.Rows {
+		if s.Chart != "" && row.Metric == s.Chart {
+			return row, true
+		}
+	}
+	return Row{}, false
+}
+
+// relative returns v relative to the first engine's value, as "2.5x", or ""
+// when either is missing
+func relative(row Row, v float64) string {
+	if len(row.Values) == 0 || math.IsNaN(v) || math.IsNaN(row.Values[0]) || row.Values[0] == 0 {
+		return ""
+	}
+	ratio := v / row.Values[0]
+	if row.Unit == Duration {
+		if v == 0 {
+			return ""
+		}
+		ratio = row.Values[0] / v
+	}
+	return fmt.Sprintf("%.2fx", ratio)
+}
+
+// maxValue returns the largest value of row, 0 when none is present
+func maxValue(row Row) float64 {
+	m := 0.0
+	for _, v := range row.Values {
+		if !math.IsNaN(v) && v > m {
+			m = v
+		}
+	}
+	return m
+}
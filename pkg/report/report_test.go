@@ -0,0 +1,118 @@
+package report
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testReport() *Report {
+	return &Report{
+		Title:     "Box queries",
+		Generated: time.Date(2026, 3, 1, 12, 30, 0, 0, time.UTC),
+		Context:   []Field{{"Points", "1,000,000"}, {"Seed", "42"}},
+		Notes:     []string{"Run on a laptop."},
+		Sections: []Section{{
+			Title:   "Box",
+			Engines: []string{"R-Tree", "Post<GIS>", "SQLite|R*Tree"},
+			Chart:   "Queries/sec",
+			Rows: []Row{
+				{Metric: "Queries/sec", Unit: PerSecond, Values: []float64{20000, 2500, Missing}},
+				{Metric: "Avg latency", Unit: Duration, Values: []float64{50e3, 400e3, 1.5e6}},
+				{Metric: "Avg results", Unit: Count, Values: []float64{12.5, 12.5}},
+			},
+		}},
+	}
+}
+
+func TestFormat(t *testing.T) {
+	assert.Equal(t, "1,234,568/s", Format(1234567.8, PerSecond))
+	assert.Equal(t, "999", Format(999, Count))
+	assert.Equal(t, "-1,000", Format(-1000, Count))
+	assert.Equal(t, "12.50", Format(12.5, Count))
+	assert.Equal(t, "1.52ms", Format(1523456, Duration))
+	assert.Equal(t, "52.35µs", Format(52345, Duration))
+	assert.Equal(t, "2.346s", Format(2345678901, Duration))
+	assert.Equal(t, "N/A", Format(Missing, Duration))
+	assert.Equal(t, "1.00x", Format(1, Ratio))
+	assert.Equal(t, "93%", Format(0.931, Percent))
+	assert.Equal(t, "512 B", Format(512, Bytes))
+	assert.Equal(t, "1.5 KiB", Format(1536, Bytes))
+	assert.Equal(t, "2.0 GiB", Format(2<<30, Bytes))
+
+	assert.Equal(t, 0, best(Row{Unit: PerSecond, Values: []float64{3, 2, Missing}}))
+	assert.Equal(t, 1, best(Row{Unit: Duration, Values: []float64{3, 2, Missing}}))
+	assert.Equal(t, -1, best(Row{Unit: Duration, Values: []float64{3, Missing}}))
+	assert.Equal(t, -1, best(Row{Unit: Count, Values: []float64{3, 2}}))
+	assert.Equal(t, -1, best(Row{Unit: Ratio, Values: []float64{1, 2}}))
+
+	assert.Equal(t, "0.12x", relative(Row{Unit: PerSecond, Values: []float64{20000}}, 2500))
+	assert.Equal(t, "0.12x", relative(Row{Unit: Duration, Values: []float64{50}}, 400))
+	assert.Equal(t, "", relative(Row{Unit: PerSecond, Values: []float64{Missing}}, 2500))
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, testReport().WriteMarkdown(&buf))
+	out := buf.String()
+
+	assert.True(t, strings.HasPrefix(out, "# Box queries\n\nGenerated 2026-03-01 12:30 UTC\n"))
+	assert.Contains(t, out, "- **Points**: 1,000,000\n")
+	assert.Contains(t, out, "| Metric | R-Tree | Post<GIS> | SQLite\\|R*Tree |\n| --- | ---: | ---: | ---: |\n")
+	assert.Contains(t, out, "| Queries/sec | **20,000/s** | 2,500/s | N/A |\n")
+	assert.Contains(t, out, "| Avg latency | **50µs** | 400µs | 1.5ms |\n")
+	assert.Contains(t, out, "| Avg results | 12.50 | 12.50 | N/A |\n")
+	assert.Contains(t, out, "R-Tree         "+strings.Repeat("█", markdownBarWidth)+"  20,000/s\n")
+	assert.Contains(t, out, "Post<GIS>      "+strings.Repeat("█", 4)+strings.Repeat(" ", markdownBarWidth-4)+"  2,500/s (0.12x)\n")
+	assert.Contains(t, out, "SQLite|R*Tree  "+strings.Repeat(" ", markdownBarWidth)+"  N/A\n")
+}
+
+func TestWriteHTML(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, testReport().WriteHTML(&buf))
+	out := buf.String()
+
+	assert.Contains(t, out, "<title>Box queries</title>")
+	assert.Contains(t, out, "Generated 2026-03-01 12:30 UTC")
+	assert.Contains(t, out, "<dt>Points</dt><dd>1,000,000</dd>")
+	assert.Contains(t, out, "<th>Post&lt;GIS&gt;</th>")
+	assert.NotContains(t, out, "Post<GIS>")
+	assert.Contains(t, out, `<td class="best">20,000/s</td><td>2,500/s</td><td>N/A</td>`)
+	assert.Contains(t, out, `<td class="best">50µs</td>`)
+	assert.Contains(t, out, "<svg ")
+	assert.Contains(t, out, `width="420.0" height="22" rx="3" class="best"`)
+	assert.Contains(t, out, `width="52.5" height="22" rx="3"></rect>`)
+	assert.Contains(t, out, "2,500/s (0.12x)")
+	// Self-contained: nothing is fetched
+	assert.NotContains(t, out, "<script src")
+	assert.NotContains(t, out, "<link")
+}
+
+func TestWrite(t *testing.T) {
+	dir := t.TempDir()
+	r := testReport()
+
+	for name, want := range map[string]string{"report.html": "<!DOCTYPE html>", "report.MD": "# Box queries"} {
+		path := filepath.Join(dir, name)
+		require.NoError(t, r.Write(path))
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(string(data), want), name)
+	}
+
+	// A single engine gets tables but no chart
+	r.Sections[0].Engines = r.Sections[0].Engines[:1]
+	var buf bytes.Buffer
+	require.NoError(t, r.WriteHTML(&buf))
+	assert.Contains(t, buf.String(), "<th>R-Tree</th>")
+	assert.NotContains(t, buf.String(), "<svg")
+
+	assert.ErrorContains(t, r.Write(filepath.Join(dir, "report.pdf")), "unknown report format")
+	assert.Error(t, r.Write(filepath.Join(dir, "missing", "report.html")))
+}
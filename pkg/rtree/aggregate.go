@@ -0,0 +1,184 @@
+package rtree
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+)
+
+// CountByDistanceBands counts the indexed points in concentric bands around
+// center in one pass over the tree. bands are the outer radii in the index's
+// distance unit, increasing; counts[0] is the number of points within
+// bands[0] and counts[i] the number farther than bands[i-1] but within
+// bands[i]. Summing counts[:i+1] gives the number within bands[i], so
+// "within 1, 5, 10 and 25 km" needs one call rather than four radius queries.
+func (g *GeoIndex) CountByDistanceBands(center models.Location, bands []float64) ([]int64, error) {
+	if len(bands) == 0 {
+		return nil, fmt.Errorf("%w: no distance bands", ErrInvalidQuery)
+	}
+	limitsKm := make([]float64, len(bands))
+	for i, band := range bands {
+		if !(band >= 0) || i > 0 && band <= bands[i-1] {
+			return nil, fmt.Errorf("%w: distance bands must be non-negative and increasing, got %v", ErrInvalidQuery, bands)
+		}
+		limitsKm[i] = g.unit.ToKm(band)
+	}
+	center, maxKm, err := g.checkRadius(center, bands[len(bands)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	state := g.state.Load()
+	counts := make([]int64, len(bands))
+	count := func(counts []int64, km float64) {
+		if i := sort.SearchFloat64s(limitsKm, km); i < len(counts) {
+			counts[i]++
+		}
+	}
+
+	if state.store != nil {
+		for _, r := range state.store.within(g, center, maxKm) {
+			count(counts, r.DistanceKm)
+		}
+		return counts, nil
+	}
+
+	resultsChan := make(chan []int64, g.numCPU)
+	centerTrig := newTrigLocation(center)
+	searches := 0
+	for _, queryBox := range g.radiusBoxes(center, maxKm) {
+		for _, partitionIdx := range g.getRelevantPartitions(state, queryBox) {
+			searches++
+			go func(idx int, queryBox models.BoundingBox) {
+				g.labeled(context.Background(), queryKindRadius, idx, func(context.Context) {
+					local := make([]int64, len(bands))
+					bounds, err := searchRect(queryBox)
+					if err == nil {
+						for _, result := range state.partitions[idx].searchIntersect(bounds) {
+							if item, ok := result.(*spatialPoint); ok && item.Point != nil {
+								count(local, centerTrig.distanceKm(item.trig))
+							}
+						}
+					}
+					resultsChan <- local
+				})
+			}(partitionIdx, queryBox)
+		}
+	}
+	for range searches {
+		for i, n := range <-resultsChan {
+			counts[i] += n
+		}
+	}
+	return counts, nil
+}
+
+// GridProjection selects how DensityGrid spaces its rows
+type GridProjection int
+
+const (
+	// Equirectangular spaces rows evenly in latitude
+	Equirectangular GridProjection = iota
+	// WebMercator spaces rows evenly in Web Mercator y, so each cell maps to
+	// one pixel of a web map tile. Latitudes are clamped to ±MaxMercatorLat.
+	WebMercator
+)
+
+// MaxMercatorLat is the latitude at which Web Mercator maps are cut off
+const MaxMercatorLat = 85.05112877980659
+
+// DensityGrid holds the number of indexed points in each cell of a grid over
+// Box. Counts[row][col] counts row from the north edge and col from the west
+// edge, the order of image pixels.
+type DensityGrid struct {
+	Box        models.BoundingBox
+	Rows, Cols int
+	Projection GridProjection
+	Counts     [][]int64
+	// Max is the largest cell count
+	Max int64
+}
+
+// DensityGrid counts the indexed points in each cell of a rows × cols grid
+// over box in one pass over the tree. Points on the box edges follow the
+// index's edge policy, as in QueryBox.
+func (g *GeoIndex) DensityGrid(box models.BoundingBox, rows, cols int, projection GridProjection) (*DensityGrid, error) {
+	if err := checkBox(box); err != nil {
+		return nil, err
+	}
+	if rows <= 0 || cols <= 0 {
+		return nil, fmt.Errorf("%w: grid must have at least one row and column, got %dx%d", ErrInvalidQuery, rows, cols)
+	}
+	if projection == WebMercator {
+		box.BottomLeft.Lat = math.Max(box.BottomLeft.Lat, -MaxMercatorLat)
+		box.TopRight.Lat = math.Min(box.TopRight.Lat, MaxMercatorLat)
+	}
+	if !(box.TopRight.Lat > box.BottomLeft.Lat && box.TopRight.Lon > box.BottomLeft.Lon) {
+		return nil, fmt.Errorf("%w: grid box has no area", ErrInvalidQuery)
+	}
+
+	project := func(lat float64) float64 { return lat }
+	if projection == WebMercator {
+		project = mercatorY
+	}
+	top, bottom := project(box.TopRight.Lat), project(box.BottomLeft.Lat)
+	rowScale := float64(rows) / (top - bottom)
+	colScale := float64(cols) / (box.TopRight.Lon - box.BottomLeft.Lon)
+	bin := func(counts []int64, loc models.Location) {
+		if !g.inBox(box, loc) {
+			return
+		}
+		row := min(int((top-project(loc.Lat))*rowScale), rows-1)
+		col := min(int((loc.Lon-box.BottomLeft.Lon)*colScale), cols-1)
+		counts[row*cols+col]++
+	}
+
+	state := g.state.Load()
+	counts := make([]int64, rows*cols)
+	if state.store != nil {
+		for _, p := range state.store.box(g, box) {
+			bin(counts, *p.Location)
+		}
+	} else {
+		relevantPartitions := g.getRelevantPartitions(state, box)
+		resultsChan := make(chan []int64, len(relevantPartitions))
+		for _, partitionIdx := range relevantPartitions {
+			go func(idx int) {
+				g.labeled(context.Background(), queryKindBox, idx, func(context.Context) {
+					local := make([]int64, rows*cols)
+					bounds, err := searchRect(box)
+					if err == nil {
+						for _, result := range state.partitions[idx].searchIntersect(bounds) {
+							if item, ok := result.(*spatialPoint); ok && item.Point != nil && item.Point.Location != nil {
+								bin(local, *item.Point.Location)
+							}
+						}
+					}
+					resultsChan <- local
+				})
+			}(partitionIdx)
+		}
+		for range relevantPartitions {
+			for i, n := range <-resultsChan {
+				counts[i] += n
+			}
+		}
+	}
+
+	grid := &DensityGrid{Box: box, Rows: rows, Cols: cols, Projection: projection, Counts: make([][]int64, rows)}
+	for row := range grid.Counts {
+		grid.Counts[row] = counts[row*cols : (row+1)*cols]
+		for _, n := range grid.Counts[row] {
+			grid.Max = max(grid.Max, n)
+		}
+	}
+	return grid, nil
+}
+
+// mercatorY returns the Web Mercator y of lat, in radians of arc
+func mercatorY(lat float64) float64 {
+	return math.Log(math.Tan(math.Pi/4 + lat*math.Pi/360))
+}
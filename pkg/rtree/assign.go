@@ -0,0 +1,89 @@
+package rtree
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+)
+
+// Assignment is the nearest site found for one query location
+type Assignment struct {
+	// Site is the nearest site and SiteIndex its position in the sites slice
+	Site      *models.Point
+	SiteIndex int
+	// DistanceKm is the haversine distance from the query location to Site
+	DistanceKm float64
+}
+
+// AssignNearest maps every query location to its nearest site, such as each
+// customer to the closest warehouse, partitioning the queries into the Voronoi
+// cells of the sites. The sites are packed into a static tree once and the
+// queries spread over all CPUs, each searching outward from its location until
+// the nearest site is certain, so this is much cheaper than a
+// NearestNeighbors call per query. Sites without a location are ignored; ties
+// go to the site that comes first. If any query location is invalid, nothing
+// is assigned and the error names the first bad one.
+func AssignNearest(sites []*models.Point, queries []models.Location) ([]Assignment, error) {
+	for i, q := range queries {
+		if math.IsNaN(q.Lat) || math.IsNaN(q.Lon) || q.Lat < -90 || q.Lat > 90 {
+			return nil, fmt.Errorf("query %d: %w: location (%v, %v)", i, ErrInvalidQuery, q.Lat, q.Lon)
+		}
+	}
+
+	// Sites are stored under their position so the codec hands it back
+	located := make([]*models.Point, 0, len(sites))
+	for i, site := range sites {
+		if site != nil && site.Location != nil {
+			located = append(located, &models.Point{ID: strconv.Itoa(i), Location: site.Location})
+		}
+	}
+	if len(located) == 0 {
+		return nil, fmt.Errorf("%w: no sites with a location", ErrEmptyIndex)
+	}
+	tree := &compactStore{}
+	tree.insert(located, true, &IDCodec{}, 0)
+
+	assignments := make([]Assignment, len(queries))
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < min(runtime.NumCPU(), len(queries)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := int(next.Add(1) - 1); i < len(queries); i = int(next.Add(1) - 1) {
+				site, km := tree.nearestSite(queries[i])
+				assignments[i] = Assignment{Site: sites[site], SiteIndex: site, DistanceKm: km}
+			}
+		}()
+	}
+	wg.Wait()
+	return assignments, nil
+}
+
+// nearestSite returns the site index stored as the ID of the point nearest to
+// center and its distance. The search radius grows fourfold from
+// compactStartKm until it reaches the nearest point found so far, at which
+// point nothing outside it can be closer.
+func (c *compactStore) nearestSite(center models.Location) (int, float64) {
+	centerTrig := newTrigLocation(center)
+	best, bestKm := -1, math.Inf(1)
+	visit := func(i int) {
+		km := centerTrig.distanceKm(newTrigLocation(c.location(i)))
+		if site := int(c.nums[i]); km < bestKm || km == bestKm && site < best {
+			best, bestKm = site, km
+		}
+	}
+	for radiusKm := float64(compactStartKm); ; radiusKm *= 4 {
+		for _, box := range splitAtAntimeridian(models.NewBoundingBoxFromCenter(center, radiusKm)) {
+			c.search(box, visit)
+		}
+		if bestKm <= radiusKm || radiusKm >= math.Pi*earthRadius {
+			return best, bestKm
+		}
+	}
+}
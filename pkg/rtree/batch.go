@@ -0,0 +1,130 @@
+package rtree
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+)
+
+// QueryBoxes runs QueryBox for every box and returns the results in the same
+// order. Instead of fanning each query out to its own goroutines, the batch is
+// spread over one worker per partition that answers whole queries, which saves
+// the per-query goroutine and channel overhead. All queries see the same
+// snapshot of the index. If any box is invalid, no query is run and the error
+// names the first bad box.
+func (g *GeoIndex) QueryBoxes(boxes []models.BoundingBox) ([][]*models.Point, error) {
+	for i, box := range boxes {
+		if err := checkBox(box); err != nil {
+			return nil, fmt.Errorf("box %d: %w", i, err)
+		}
+	}
+
+	state := g.state.Load()
+	results := make([][]*models.Point, len(boxes))
+	g.forEachQuery(queryKindBox, len(boxes), func(ctx context.Context, i int) {
+		results[i] = g.serialQueryBox(ctx, state, boxes[i])
+	})
+	return results, nil
+}
+
+// QueryRadii runs QueryRadius around every center with the same radius, in the
+// index's distance unit, and returns the results in the same order. It is
+// scheduled like QueryBoxes.
+func (g *GeoIndex) QueryRadii(centers []models.Location, radius float64) ([][]*models.Point, error) {
+	checked := make([]models.Location, len(centers))
+	var radiusKm float64
+	for i, center := range centers {
+		var err error
+		if checked[i], radiusKm, err = g.checkRadius(center, radius); err != nil {
+			return nil, fmt.Errorf("center %d: %w", i, err)
+		}
+	}
+
+	state := g.state.Load()
+	results := make([][]*models.Point, len(centers))
+	g.forEachQuery(queryKindRadius, len(centers), func(ctx context.Context, i int) {
+		found := g.serialQueryRadius(ctx, state, checked[i], radiusKm)
+		if g.altitude {
+			found = g.within3D(found, checked[i], radiusKm)
+		}
+		if g.stableOrder {
+			sortByDistance(found)
+		}
+		points := make([]*models.Point, len(found))
+		for j, r := range found {
+			points[j] = r.Point
+		}
+		results[i] = points
+	})
+	return results, nil
+}
+
+// forEachQuery calls fn for every query index in [0, n) from up to one
+// goroutine per partition, labeled with the query kind, and waits for them all
+func (g *GeoIndex) forEachQuery(kind string, n int, fn func(ctx context.Context, i int)) {
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < min(g.numCPU, n); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.labeled(context.Background(), kind, -1, func(ctx context.Context) {
+				for i := int(next.Add(1) - 1); i < n; i = int(next.Add(1) - 1) {
+					fn(ctx, i)
+				}
+			})
+		}()
+	}
+	wg.Wait()
+}
+
+// serialQueryBox is QueryBox on state searching the partitions in turn
+func (g *GeoIndex) serialQueryBox(ctx context.Context, state *indexState, box models.BoundingBox) []*models.Point {
+	parts := []models.BoundingBox{box}
+	if g.wrapLongitudes {
+		parts = splitAtAntimeridian(box)
+	}
+
+	var points []*models.Point
+	for _, part := range parts {
+		if g.cache != nil {
+			points = append(points, g.cachedQueryBox(ctx, state, part)...)
+			continue
+		}
+		if state.store != nil {
+			points = append(points, state.store.box(g, part)...)
+			continue
+		}
+		for _, idx := range g.getRelevantPartitions(state, part) {
+			points = append(points, g.partitionBox(state.partitions[idx], part)...)
+		}
+	}
+	if g.stableOrder {
+		sort.Slice(points, func(i, j int) bool {
+			return points[i].ID < points[j].ID
+		})
+	}
+	return points
+}
+
+// serialQueryRadius is queryRadius on state searching the partitions in turn,
+// for a center and radius already checked with checkRadius
+func (g *GeoIndex) serialQueryRadius(ctx context.Context, state *indexState, center models.Location, radiusKm float64) []models.PointDistance {
+	if g.cache != nil {
+		return g.cachedWithin(ctx, state, center, radiusKm)
+	}
+	if state.store != nil {
+		return state.store.within(g, center, radiusKm)
+	}
+	var results []models.PointDistance
+	for _, queryBox := range g.radiusBoxes(center, radiusKm) {
+		for _, idx := range g.getRelevantPartitions(state, queryBox) {
+			results = append(results, g.partitionRadius(state.partitions[idx], center, queryBox, radiusKm)...)
+		}
+	}
+	return results
+}
@@ -0,0 +1,649 @@
+package rtree
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+)
+
+// Benchmarks in this file use points spread over the whole globe from a fixed
+// seed, so runs are comparable across changes. Run with
+//
+//	go test ./pkg/rtree -run '^$' -bench . -benchmem
+//
+// Query benchmarks report results/op so timings can be read per result.
+
+const benchPoints = 100000
+
+var (
+	benchMu      sync.Mutex
+	benchIndexes = make(map[string]*GeoIndex)
+)
+
+// worldPoints returns n points uniformly spread in latitude and longitude
+func worldPoints(n int, seed int64) []*models.Point {
+	rng := rand.New(rand.NewSource(seed))
+	points := make([]*models.Point, n)
+	for i := range points {
+		points[i] = &models.Point{
+			ID:       fmt.Sprintf("point_%d", i),
+			Location: &models.Location{Lat: rng.Float64()*180 - 90, Lon: rng.Float64()*360 - 180},
+		}
+	}
+	return points
+}
+
+// queryCenters returns query locations between the 60th parallels, where most
+// real queries land
+func queryCenters(n int) []models.Location {
+	rng := rand.New(rand.NewSource(2))
+	centers := make([]models.Location, n)
+	for i := range centers {
+		centers[i] = models.Location{Lat: rng.Float64()*120 - 60, Lon: rng.Float64()*360 - 180}
+	}
+	return centers
+}
+
+// benchIndex returns a shared index of n world points split into the given
+// number of partitions, building it on first use outside the timer
+func benchIndex(b *testing.B, n, partitions int) *GeoIndex {
+	b.Helper()
+	benchMu.Lock()
+	defer benchMu.Unlock()
+
+	key := fmt.Sprintf("%d/%d", n, partitions)
+	if index, ok := benchIndexes[key]; ok {
+		return index
+	}
+	b.StopTimer()
+	defer b.StartTimer()
+	index := NewGeoIndexWithWorkers(partitions)
+	if err := index.IndexPoints(worldPoints(n, 1)); err != nil {
+		b.Fatal(err)
+	}
+	benchIndexes[key] = index
+	return index
+}
+
+func BenchmarkIndexPointsPartitions(b *testing.B) {
+	points := worldPoints(benchPoints, 1)
+	for _, partitions := range []int{1, 2, 4, 8, 16} {
+		b.Run(fmt.Sprintf("partitions_%d", partitions), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				index := NewGeoIndexWithWorkers(partitions)
+				if err := index.IndexPoints(points); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkIndexPointsBatches(b *testing.B) {
+	points := worldPoints(benchPoints, 1)
+	for _, batch := range []int{100, 1000, 10000} {
+		b.Run(fmt.Sprintf("batch_%d", batch), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				index := NewGeoIndexWithWorkers(4)
+				for start := 0; start < len(points); start += batch {
+					end := min(start+batch, len(points))
+					if err := index.IndexPoints(points[start:end]); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkQueryBoxSize(b *testing.B) {
+	index := benchIndex(b, benchPoints, 4)
+	centers := queryCenters(1024)
+	for _, side := range []float64{0.1, 1, 10, 45} {
+		b.Run(fmt.Sprintf("side_%gdeg", side), func(b *testing.B) {
+			b.ReportAllocs()
+			results := 0
+			for i := 0; i < b.N; i++ {
+				c := centers[i%len(centers)]
+				points, err := index.QueryBox(models.BoundingBox{
+					BottomLeft: models.Location{Lat: c.Lat - side/2, Lon: c.Lon - side/2},
+					TopRight:   models.Location{Lat: c.Lat + side/2, Lon: c.Lon + side/2},
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+				results += len(points)
+			}
+			b.ReportMetric(float64(results)/float64(b.N), "results/op")
+		})
+	}
+}
+
+func BenchmarkQueryRadiusSize(b *testing.B) {
+	index := benchIndex(b, benchPoints, 4)
+	centers := queryCenters(1024)
+	for _, radius := range []float64{1, 10, 100, 1000} {
+		b.Run(fmt.Sprintf("radius_%gkm", radius), func(b *testing.B) {
+			b.ReportAllocs()
+			results := 0
+			for i := 0; i < b.N; i++ {
+				points, err := index.QueryRadius(centers[i%len(centers)], radius)
+				if err != nil {
+					b.Fatal(err)
+				}
+				results += len(points)
+			}
+			b.ReportMetric(float64(results)/float64(b.N), "results/op")
+		})
+	}
+}
+
+func BenchmarkQueryRadiusWithDistance(b *testing.B) {
+	index := benchIndex(b, benchPoints, 4)
+	centers := queryCenters(1024)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := index.QueryRadiusWithDistance(centers[i%len(centers)], 100); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkNearestNeighborsK(b *testing.B) {
+	index := benchIndex(b, benchPoints, 4)
+	centers := queryCenters(1024)
+	for _, k := range []int{1, 10, 100} {
+		b.Run(fmt.Sprintf("k_%d", k), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_ = index.NearestNeighbors(centers[i%len(centers)], k)
+			}
+		})
+	}
+}
+
+func BenchmarkQueryPartitions(b *testing.B) {
+	centers := queryCenters(1024)
+	for _, partitions := range []int{1, 2, 4, 8, 16} {
+		index := benchIndex(b, benchPoints, partitions)
+		b.Run(fmt.Sprintf("box/partitions_%d", partitions), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				c := centers[i%len(centers)]
+				if _, err := index.QueryBox(models.BoundingBox{
+					BottomLeft: models.Location{Lat: c.Lat - 5, Lon: c.Lon - 5},
+					TopRight:   models.Location{Lat: c.Lat + 5, Lon: c.Lon + 5},
+				}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("nearest/partitions_%d", partitions), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_ = index.NearestNeighbors(centers[i%len(centers)], 10)
+			}
+		})
+	}
+}
+
+// BenchmarkQueryBoxes runs a batch of 1024 one-degree boxes per op; compare
+// with 1024 ops of BenchmarkQueryBoxSize/side_1deg
+func BenchmarkQueryBoxes(b *testing.B) {
+	index := benchIndex(b, benchPoints, 4)
+	centers := queryCenters(1024)
+	boxes := make([]models.BoundingBox, len(centers))
+	for i, c := range centers {
+		boxes[i] = models.BoundingBox{
+			BottomLeft: models.Location{Lat: c.Lat - 0.5, Lon: c.Lon - 0.5},
+			TopRight:   models.Location{Lat: c.Lat + 0.5, Lon: c.Lon + 0.5},
+		}
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := index.QueryBoxes(boxes); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDistanceMatrix(b *testing.B) {
+	origins := queryCenters(1000)
+	destinations := queryCenters(1000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = DistanceMatrix(origins, destinations)
+	}
+}
+
+func BenchmarkQueryBoxParallel(b *testing.B) {
+	index := benchIndex(b, benchPoints, 4)
+	centers := queryCenters(1024)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			c := centers[i%len(centers)]
+			i++
+			if _, err := index.QueryBox(models.BoundingBox{
+				BottomLeft: models.Location{Lat: c.Lat - 1, Lon: c.Lon - 1},
+				TopRight:   models.Location{Lat: c.Lat + 1, Lon: c.Lon + 1},
+			}); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkQueryRadiusParallel(b *testing.B) {
+	index := benchIndex(b, benchPoints, 4)
+	centers := queryCenters(1024)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if _, err := index.QueryRadius(centers[i%len(centers)], 100); err != nil {
+				b.Error(err)
+				return
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkNearestNeighborsParallel(b *testing.B) {
+	index := benchIndex(b, benchPoints, 4)
+	centers := queryCenters(1024)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			_ = index.NearestNeighbors(centers[i%len(centers)], 10)
+			i++
+		}
+	})
+}
+
+// BenchmarkMixedParallel interleaves inserts with queries to measure lock
+// contention between writers and readers
+func BenchmarkMixedParallel(b *testing.B) {
+	index := NewGeoIndexWithWorkers(4)
+	if err := index.IndexPoints(worldPoints(benchPoints, 1)); err != nil {
+		b.Fatal(err)
+	}
+	centers := queryCenters(1024)
+	inserts := worldPoints(1024, 3)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if i%10 == 0 {
+				if err := index.IndexPoints(inserts[i%len(inserts) : i%len(inserts)+1]); err != nil {
+					b.Error(err)
+					return
+				}
+			} else if _, err := index.QueryRadius(centers[i%len(centers)], 50); err != nil {
+				b.Error(err)
+				return
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkSaveLoad(b *testing.B) {
+	index := benchIndex(b, benchPoints, 4)
+	path := filepath.Join(b.TempDir(), "bench.gob")
+	b.Run("save", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := index.SaveToFile(path); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("load", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			loaded := NewGeoIndexWithWorkers(4)
+			if err := loaded.LoadFromFile(path); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkCompactStorage(b *testing.B) {
+	points := worldPoints(benchPoints, 1)
+	centers := queryCenters(1024)
+	b.Run("index", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := NewGeoIndex(WithCompactStorage()).IndexPoints(points); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	index := NewGeoIndex(WithCompactStorage())
+	if err := index.IndexPoints(points); err != nil {
+		b.Fatal(err)
+	}
+	b.Run("box", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			c := centers[i%len(centers)]
+			if _, err := index.QueryBox(models.BoundingBox{
+				BottomLeft: models.Location{Lat: c.Lat - 5, Lon: c.Lon - 5},
+				TopRight:   models.Location{Lat: c.Lat + 5, Lon: c.Lon + 5},
+			}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("radius", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := index.QueryRadius(centers[i%len(centers)], 100); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("nearest", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = index.NearestNeighbors(centers[i%len(centers)], 10)
+		}
+	})
+}
+
+func BenchmarkGeohashStorage(b *testing.B) {
+	points := worldPoints(benchPoints, 1)
+	centers := queryCenters(1024)
+	b.Run("index", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := NewGeoIndex(WithGeohashStorage(0)).IndexPoints(points); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	index := NewGeoIndex(WithGeohashStorage(0))
+	if err := index.IndexPoints(points); err != nil {
+		b.Fatal(err)
+	}
+	b.Run("box", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			c := centers[i%len(centers)]
+			if _, err := index.QueryBox(models.BoundingBox{
+				BottomLeft: models.Location{Lat: c.Lat - 5, Lon: c.Lon - 5},
+				TopRight:   models.Location{Lat: c.Lat + 5, Lon: c.Lon + 5},
+			}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("radius", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := index.QueryRadius(centers[i%len(centers)], 100); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("nearest", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = index.NearestNeighbors(centers[i%len(centers)], 10)
+		}
+	})
+}
+
+func BenchmarkS2Storage(b *testing.B) {
+	points := worldPoints(benchPoints, 1)
+	centers := queryCenters(1024)
+	b.Run("index", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := NewGeoIndex(WithS2Storage()).IndexPoints(points); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	index := NewGeoIndex(WithS2Storage())
+	if err := index.IndexPoints(points); err != nil {
+		b.Fatal(err)
+	}
+	b.Run("box", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			c := centers[i%len(centers)]
+			if _, err := index.QueryBox(models.BoundingBox{
+				BottomLeft: models.Location{Lat: c.Lat - 5, Lon: c.Lon - 5},
+				TopRight:   models.Location{Lat: c.Lat + 5, Lon: c.Lon + 5},
+			}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("radius", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := index.QueryRadius(centers[i%len(centers)], 100); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("nearest", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = index.NearestNeighbors(centers[i%len(centers)], 10)
+		}
+	})
+}
+
+func BenchmarkQuadtreeStorage(b *testing.B) {
+	points := worldPoints(benchPoints, 1)
+	centers := queryCenters(1024)
+	b.Run("index", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := NewGeoIndex(WithQuadtreeStorage()).IndexPoints(points); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	index := NewGeoIndex(WithQuadtreeStorage())
+	if err := index.IndexPoints(points); err != nil {
+		b.Fatal(err)
+	}
+	b.Run("box", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			c := centers[i%len(centers)]
+			if _, err := index.QueryBox(models.BoundingBox{
+				BottomLeft: models.Location{Lat: c.Lat - 5, Lon: c.Lon - 5},
+				TopRight:   models.Location{Lat: c.Lat + 5, Lon: c.Lon + 5},
+			}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("radius", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := index.QueryRadius(centers[i%len(centers)], 100); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("nearest", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = index.NearestNeighbors(centers[i%len(centers)], 10)
+		}
+	})
+}
+
+func BenchmarkKDTreeStorage(b *testing.B) {
+	points := worldPoints(benchPoints, 1)
+	centers := queryCenters(1024)
+	b.Run("index", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := NewGeoIndex(WithKDTreeStorage()).IndexPoints(points); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	index := NewGeoIndex(WithKDTreeStorage())
+	if err := index.IndexPoints(points); err != nil {
+		b.Fatal(err)
+	}
+	b.Run("box", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			c := centers[i%len(centers)]
+			if _, err := index.QueryBox(models.BoundingBox{
+				BottomLeft: models.Location{Lat: c.Lat - 5, Lon: c.Lon - 5},
+				TopRight:   models.Location{Lat: c.Lat + 5, Lon: c.Lon + 5},
+			}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("radius", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := index.QueryRadius(centers[i%len(centers)], 100); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("nearest", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = index.NearestNeighbors(centers[i%len(centers)], 10)
+		}
+	})
+}
+
+func BenchmarkGridStorage(b *testing.B) {
+	points := worldPoints(benchPoints, 1)
+	centers := queryCenters(1024)
+	b.Run("index", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := NewGeoIndex(WithGridStorage(0)).IndexPoints(points); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	index := NewGeoIndex(WithGridStorage(0))
+	if err := index.IndexPoints(points); err != nil {
+		b.Fatal(err)
+	}
+	b.Run("box", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			c := centers[i%len(centers)]
+			if _, err := index.QueryBox(models.BoundingBox{
+				BottomLeft: models.Location{Lat: c.Lat - 5, Lon: c.Lon - 5},
+				TopRight:   models.Location{Lat: c.Lat + 5, Lon: c.Lon + 5},
+			}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("radius", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := index.QueryRadius(centers[i%len(centers)], 100); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("nearest", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = index.NearestNeighbors(centers[i%len(centers)], 10)
+		}
+	})
+}
+
+func BenchmarkSpaceTimeStorage(b *testing.B) {
+	// A day of events, queried for the last 15 minutes of an area
+	points := worldPoints(benchPoints, 1)
+	end := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	for i, p := range points {
+		p.Properties = map[string]any{"timestamp": end.Add(-time.Duration(i) * 24 * time.Hour / time.Duration(len(points)))}
+	}
+	centers := queryCenters(1024)
+	b.Run("index", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := NewGeoIndex(WithSpaceTimeStorage("")).IndexPoints(points); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	index := NewGeoIndex(WithSpaceTimeStorage(""))
+	if err := index.IndexPoints(points); err != nil {
+		b.Fatal(err)
+	}
+	box := func(c models.Location) models.BoundingBox {
+		return models.BoundingBox{
+			BottomLeft: models.Location{Lat: c.Lat - 5, Lon: c.Lon - 5},
+			TopRight:   models.Location{Lat: c.Lat + 5, Lon: c.Lon + 5},
+		}
+	}
+	b.Run("box-15m", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := index.QueryBoxTime(box(centers[i%len(centers)]), end.Add(-15*time.Minute), end); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("box", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := index.QueryBox(box(centers[i%len(centers)])); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("radius", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := index.QueryRadius(centers[i%len(centers)], 100); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("nearest", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			_ = index.NearestNeighbors(centers[i%len(centers)], 10)
+		}
+	})
+}
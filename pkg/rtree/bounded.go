@@ -0,0 +1,423 @@
+package rtree
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+)
+
+// EvictionPolicy selects which points a BoundedIndex drops when it is full
+type EvictionPolicy string
+
+const (
+	// EvictOldest drops the point with the earliest timestamp property
+	EvictOldest EvictionPolicy = "oldest"
+	// EvictLRU drops the point least recently inserted or returned by a query
+	EvictLRU EvictionPolicy = "lru"
+	// EvictLowestPriority drops the point with the lowest priority property
+	EvictLowestPriority EvictionPolicy = "priority"
+)
+
+const (
+	// DefaultTimestampField is the property EvictOldest reads when no field
+	// is configured
+	DefaultTimestampField = "timestamp"
+	// DefaultPriorityField is the property EvictLowestPriority reads when no
+	// field is configured
+	DefaultPriorityField = "priority"
+	// boundedRebuildDivisor sets how many dropped copies the underlying index
+	// may hold before it is rebuilt: one per this many live points
+	boundedRebuildDivisor = 4
+)
+
+// BoundedConfig configures a BoundedIndex
+type BoundedConfig struct {
+	// MaxPoints is the most points the index holds
+	MaxPoints int
+	// Policy picks the points dropped once MaxPoints is reached; EvictOldest
+	// when empty
+	Policy EvictionPolicy
+	// Field is the property holding the timestamp or priority, defaulting to
+	// DefaultTimestampField or DefaultPriorityField. Numbers, RFC 3339
+	// strings and time.Time values are read; points without a readable value
+	// rank below every other point. EvictLRU ignores it.
+	Field string
+	// OnEvict, if set, is called with each dropped point, under the index's
+	// lock, so it must not call back into the index
+	OnEvict func(p *models.Point)
+	// Options configure the underlying GeoIndex
+	Options []Option
+}
+
+// BoundedIndex holds at most MaxPoints points and drops points by an eviction
+// policy to make room for new ones, for caches such as "the most recent 5M
+// sightings". Indexing an ID already held replaces its point.
+//
+// Dropped points leave the results immediately but stay in the underlying
+// GeoIndex until a quarter as many as are live have accumulated, when the
+// index is rebuilt from the live points. Queries filter the dropped copies
+// out, so memory holds up to 1.25 times MaxPoints points.
+type BoundedIndex struct {
+	maxPoints int
+	policy    EvictionPolicy
+	field     string
+	onEvict   func(p *models.Point)
+	opts      []Option
+
+	mu      sync.Mutex
+	index   *GeoIndex
+	entries map[string]*boundedEntry
+	order   boundedHeap
+	tick    int64 // orders inserts and, for EvictLRU, accesses
+	evicted int64
+}
+
+// BoundedStats reports the occupancy of a BoundedIndex
+type BoundedStats struct {
+	Count     int64 `json:"count"`
+	MaxPoints int   `json:"max_points"`
+	Evicted   int64 `json:"evicted"`
+	// Stale is the number of dropped copies waiting for the next rebuild
+	Stale int64 `json:"stale"`
+}
+
+// boundedEntry is a live point ranked by key, then by tick: the lowest is
+// evicted first
+type boundedEntry struct {
+	point *models.Point
+	key   float64
+	tick  int64
+	pos   int
+}
+
+// boundedHeap is a min-heap of entries by rank
+type boundedHeap []*boundedEntry
+
+func (h boundedHeap) Len() int { return len(h) }
+func (h boundedHeap) Less(i, j int) bool {
+	if h[i].key != h[j].key {
+		return h[i].key < h[j].key
+	}
+	return h[i].tick < h[j].tick
+}
+func (h boundedHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].pos, h[j].pos = i, j
+}
+func (h *boundedHeap) Push(x any) {
+	e := x.(*boundedEntry)
+	e.pos = len(*h)
+	*h = append(*h, e)
+}
+func (h *boundedHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
+
+// NewBoundedIndex returns an empty BoundedIndex
+func NewBoundedIndex(c BoundedConfig) (*BoundedIndex, error) {
+	if c.MaxPoints <= 0 {
+		return nil, fmt.Errorf("max points must be positive, got %d", c.MaxPoints)
+	}
+	switch c.Policy {
+	case "":
+		c.Policy = EvictOldest
+		fallthrough
+	case EvictOldest:
+		if c.Field == "" {
+			c.Field = DefaultTimestampField
+		}
+	case EvictLowestPriority:
+		if c.Field == "" {
+			c.Field = DefaultPriorityField
+		}
+	case EvictLRU:
+	default:
+		return nil, fmt.Errorf("unknown eviction policy %q", c.Policy)
+	}
+	return &BoundedIndex{
+		maxPoints: c.MaxPoints,
+		policy:    c.Policy,
+		field:     c.Field,
+		onEvict:   c.OnEvict,
+		opts:      c.Options,
+		index:     NewGeoIndex(c.Options...),
+		entries:   make(map[string]*boundedEntry),
+	}, nil
+}
+
+// rank returns the eviction key of p
+func (b *BoundedIndex) rank(p *models.Point) float64 {
+	if b.policy == EvictLRU {
+		return float64(b.tick)
+	}
+	if key, ok := propertyNumber(p.Properties[b.field]); ok {
+		return key
+	}
+	return math.Inf(-1)
+}
+
+// propertyNumber reads a property value as a number: numbers as they are,
+// times and RFC 3339 strings as Unix seconds
+func propertyNumber(v any) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case time.Time:
+		return unixSeconds(v), true
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return unixSeconds(t), true
+		}
+	}
+	return 0, false
+}
+
+// unixSeconds returns t in fractional Unix seconds
+func unixSeconds(t time.Time) float64 {
+	return float64(t.Unix()) + float64(t.Nanosecond())/1e9
+}
+
+// IndexPoints adds the points that have a location, replacing points with
+// the same ID, and drops the lowest ranked points beyond MaxPoints. Points of
+// the batch itself are dropped if they rank lowest.
+func (b *BoundedIndex) IndexPoints(points []*models.Point) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	batch := make([]*boundedEntry, 0, len(points))
+	for _, p := range points {
+		if p.Location == nil {
+			continue
+		}
+		if b.index.wrapLongitudes {
+			p = wrapPoint(p)
+		}
+		b.tick++
+		if e, ok := b.entries[p.ID]; ok {
+			e.point, e.key, e.tick = p, b.rank(p), b.tick
+			heap.Fix(&b.order, e.pos)
+			batch = append(batch, e)
+			continue
+		}
+		e := &boundedEntry{point: p, key: b.rank(p), tick: b.tick}
+		b.entries[p.ID] = e
+		heap.Push(&b.order, e)
+		batch = append(batch, e)
+	}
+	for b.order.Len() > b.maxPoints {
+		e := heap.Pop(&b.order).(*boundedEntry)
+		delete(b.entries, e.point.ID)
+		b.evicted++
+		if b.onEvict != nil {
+			b.onEvict(e.point)
+		}
+	}
+
+	// Only the batch entries still live reach the index, once each
+	live := make([]*models.Point, 0, len(batch))
+	added := make(map[*boundedEntry]bool, len(batch))
+	for _, e := range batch {
+		if b.entries[e.point.ID] == e && !added[e] {
+			added[e] = true
+			live = append(live, e.point)
+		}
+	}
+	if err := b.index.IndexPoints(live); err != nil {
+		return err
+	}
+	if b.stale() > int64(len(b.entries)/boundedRebuildDivisor) {
+		return b.rebuild()
+	}
+	return nil
+}
+
+// stale is the number of dropped copies in the underlying index
+func (b *BoundedIndex) stale() int64 {
+	return b.index.Count() - int64(len(b.entries))
+}
+
+// rebuild replaces the underlying index with one holding only live points
+func (b *BoundedIndex) rebuild() error {
+	points := make([]*models.Point, len(b.order))
+	for i, e := range b.order {
+		points[i] = e.point
+	}
+	stale := b.stale()
+	index := NewGeoIndex(b.opts...)
+	if err := index.IndexPoints(points); err != nil {
+		index.logger().Error("bounded index rebuild failed", "error", err)
+		return err
+	}
+	b.index = index
+	index.logger().Info("bounded index rebuilt", "points", len(points), "dropped", stale)
+	return nil
+}
+
+// current returns the underlying index for a query
+func (b *BoundedIndex) current() *GeoIndex {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.index
+}
+
+// live reports whether p is the point the index holds for its ID and returns
+// that point, marking it used for EvictLRU. Copies replaced at the same
+// location also match, so callers skip IDs they have already seen.
+func (b *BoundedIndex) live(p *models.Point) (*models.Point, bool) {
+	e, ok := b.entries[p.ID]
+	if !ok || *e.point.Location != *p.Location {
+		return nil, false
+	}
+	if b.policy == EvictLRU {
+		b.tick++
+		e.key, e.tick = float64(b.tick), b.tick
+		heap.Fix(&b.order, e.pos)
+	}
+	return e.point, true
+}
+
+// filter drops dropped copies from points
+func (b *BoundedIndex) filter(points []*models.Point) []*models.Point {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	seen := make(map[string]bool, len(points))
+	results := points[:0]
+	for _, p := range points {
+		if live, ok := b.live(p); ok && !seen[p.ID] {
+			seen[p.ID] = true
+			results = append(results, live)
+		}
+	}
+	return results
+}
+
+// filterDistances is filter for results with distances, keeping at most
+// limit of them
+func (b *BoundedIndex) filterDistances(points []models.PointDistance, limit int) []models.PointDistance {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	seen := make(map[string]bool, len(points))
+	results := points[:0]
+	for _, p := range points {
+		if len(results) == limit {
+			break
+		}
+		if seen[p.Point.ID] {
+			continue
+		}
+		if live, ok := b.live(p.Point); ok {
+			seen[p.Point.ID] = true
+			p.Point = live
+			results = append(results, p)
+		}
+	}
+	return results
+}
+
+// QueryBox returns the points inside box
+func (b *BoundedIndex) QueryBox(box models.BoundingBox) ([]*models.Point, error) {
+	points, err := b.current().QueryBox(box)
+	if err != nil {
+		return nil, err
+	}
+	return b.filter(points), nil
+}
+
+// QueryRadius returns the points within radius of center, in the unit of the
+// index options
+func (b *BoundedIndex) QueryRadius(center models.Location, radius float64) ([]*models.Point, error) {
+	points, err := b.current().QueryRadius(center, radius)
+	if err != nil {
+		return nil, err
+	}
+	return b.filter(points), nil
+}
+
+// QueryRadiusWithDistance is QueryRadius with each point's distance from
+// center, nearest first
+func (b *BoundedIndex) QueryRadiusWithDistance(center models.Location, radius float64) ([]models.PointDistance, error) {
+	points, err := b.current().QueryRadiusWithDistance(center, radius)
+	if err != nil {
+		return nil, err
+	}
+	return b.filterDistances(points, -1), nil
+}
+
+// NearestNeighbors returns up to n points nearest to center, nearest first
+func (b *BoundedIndex) NearestNeighbors(center models.Location, n int) []*models.Point {
+	results := b.NearestNeighborsWithDistance(center, n)
+	points := make([]*models.Point, len(results))
+	for i, r := range results {
+		points[i] = r.Point
+	}
+	return points
+}
+
+// NearestNeighborsWithDistance is NearestNeighbors with each point's distance
+// from center. Dropped copies are skipped by asking the underlying index for
+// twice as many neighbors until n live ones are found.
+func (b *BoundedIndex) NearestNeighborsWithDistance(center models.Location, n int) []models.PointDistance {
+	if n <= 0 {
+		return nil
+	}
+	index := b.current()
+	for k := n; ; k *= 2 {
+		candidates := index.NearestNeighborsWithDistance(center, k)
+		results := b.filterDistances(candidates, n)
+		if len(results) == n || len(candidates) < k {
+			return results
+		}
+	}
+}
+
+// Count returns the number of live points
+func (b *BoundedIndex) Count() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return int64(len(b.entries))
+}
+
+// Stats reports how many points are held and how many were dropped
+func (b *BoundedIndex) Stats() BoundedStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return BoundedStats{
+		Count:     int64(len(b.entries)),
+		MaxPoints: b.maxPoints,
+		Evicted:   b.evicted,
+		Stale:     b.stale(),
+	}
+}
+
+// SaveToFile writes the live points to an index file
+func (b *BoundedIndex) SaveToFile(path string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.stale() > 0 {
+		if err := b.rebuild(); err != nil {
+			return err
+		}
+	}
+	return b.index.SaveToFile(path)
+}
+
+// LoadFromFile adds the points of an index file, evicting as IndexPoints does
+func (b *BoundedIndex) LoadFromFile(path string) error {
+	points, err := ReadPoints(path)
+	if err != nil {
+		return err
+	}
+	return b.IndexPoints(points)
+}
@@ -0,0 +1,271 @@
+package rtree
+
+import (
+	"maps"
+	"math"
+	"slices"
+	"sort"
+
+	"github.com/1F47E/geo-index-rtree/pkg/geohash"
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+)
+
+const (
+	// DefaultGeohashPrecision is the cell size used by WithGeohashStorage when
+	// no precision is given, about 4.9km by 4.9km at the equator
+	DefaultGeohashPrecision = 5
+	// geohashBytesPerPoint approximates the fixed heap cost of one point in
+	// geohash storage: the Point and Location structs, its slot in a bucket
+	// and its entry in the ID set
+	geohashBytesPerPoint = 120
+	// geohashBytesPerCell approximates the map entry, key and slice header of
+	// one occupied cell
+	geohashBytesPerCell = 64
+)
+
+// WithGeohashStorage buckets points by the geohash cell of their location at
+// the given precision (1 to geohash.MaxPrecision characters, 0 for
+// DefaultGeohashPrecision) instead of building R-trees. Pure point data
+// then costs a slice slot per point rather than a tree entry, and inserts
+// only re-sort the cells they touch. Queries behave the same: box and radius
+// queries scan the cells overlapping the query, nearest-neighbor queries
+// widen a radius search until they hold enough points. Pick a precision whose
+// cells are about the size of typical queries; much smaller cells make large
+// queries visit many empty cells, much larger ones make them filter many
+// points.
+func WithGeohashStorage(precision int) Option {
+	return func(g *GeoIndex) {
+		if precision <= 0 {
+			precision = DefaultGeohashPrecision
+		}
+		g.storage = geohashStorage
+		g.geohashPrecision = min(precision, geohash.MaxPrecision)
+	}
+}
+
+// geohashStore keeps points in buckets keyed by the geohash of their cell,
+// each bucket sorted by latitude so a query covering part of a cell
+// binary-searches the rows it needs. Stores are immutable: add returns a new
+// store sharing the buckets it did not touch.
+type geohashStore struct {
+	precision int
+	// latStep and lonStep are the cell height and width in degrees
+	latStep, lonStep  float64
+	cells             map[string][]*models.Point
+	ids               idBatches
+	falsePositiveRate float64
+}
+
+func newGeohashStore(precision int, falsePositiveRate float64) *geohashStore {
+	bits := 5 * precision
+	return &geohashStore{
+		precision:         precision,
+		latStep:           180 / math.Exp2(float64(bits/2)),
+		lonStep:           360 / math.Exp2(float64(bits-bits/2)),
+		cells:             make(map[string][]*models.Point),
+		falsePositiveRate: falsePositiveRate,
+	}
+}
+
+// add returns a store holding s's points and those of points that have a
+// location, and the number added
+func (s *geohashStore) add(g *GeoIndex, points []*models.Point) (store, int) {
+	batches := make(map[string][]*models.Point)
+	ids := make([]string, 0, len(points))
+	for _, p := range points {
+		if p.Location == nil {
+			continue
+		}
+		if g.wrapLongitudes {
+			p = wrapPoint(p)
+		}
+		key := geohash.Encode(p.Location.Lat, p.Location.Lon, s.precision)
+		batches[key] = append(batches[key], p)
+		ids = append(ids, p.ID)
+	}
+	if len(ids) == 0 {
+		return s, 0
+	}
+
+	next := *s
+	next.cells = maps.Clone(s.cells)
+	for key, batch := range batches {
+		sortByLat(batch)
+		next.cells[key] = mergeByLat(s.cells[key], batch)
+	}
+	next.ids = s.ids.with(ids, s.falsePositiveRate)
+	return &next, len(ids)
+}
+
+// without returns a store holding s's points except those with an ID in
+// remove, and the number left out. Buckets holding none of them are shared.
+func (s *geohashStore) without(g *GeoIndex, remove map[string]bool) (store, int) {
+	next := *s
+	next.cells = maps.Clone(s.cells)
+	removed := 0
+	var ids []string
+	for key, bucket := range s.cells {
+		if slices.ContainsFunc(bucket, func(p *models.Point) bool { return remove[p.ID] }) {
+			kept := slices.DeleteFunc(slices.Clone(bucket), func(p *models.Point) bool { return remove[p.ID] })
+			removed += len(bucket) - len(kept)
+			if bucket = kept; len(kept) == 0 {
+				delete(next.cells, key)
+			} else {
+				next.cells[key] = kept
+			}
+		}
+		for _, p := range bucket {
+			ids = append(ids, p.ID)
+		}
+	}
+	if removed == 0 {
+		return s, 0
+	}
+	next.ids = idBatches(nil).with(ids, s.falsePositiveRate)
+	return &next, removed
+}
+
+func sortByLat(points []*models.Point) {
+	sort.Slice(points, func(i, j int) bool { return points[i].Location.Lat < points[j].Location.Lat })
+}
+
+// mergeByLat merges two latitude-sorted buckets into a new one
+func mergeByLat(a, b []*models.Point) []*models.Point {
+	merged := make([]*models.Point, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		if b[0].Location.Lat < a[0].Location.Lat {
+			merged, b = append(merged, b[0]), b[1:]
+		} else {
+			merged, a = append(merged, a[0]), a[1:]
+		}
+	}
+	return append(append(merged, a...), b...)
+}
+
+// search calls fn with every point inside box, edges included. It looks up
+// the cells overlapping box, or walks every bucket when that is fewer.
+func (s *geohashStore) search(box models.BoundingBox, fn func(p *models.Point)) {
+	rows, cols := int(180/s.latStep), int(360/s.lonStep)
+	cell := func(v, origin, step float64, n int) int {
+		return cellIndex((v-origin)/step, 0, n-1)
+	}
+	minRow, maxRow := cell(box.BottomLeft.Lat, -90, s.latStep, rows), cell(box.TopRight.Lat, -90, s.latStep, rows)
+	minCol, maxCol := cell(box.BottomLeft.Lon, -180, s.lonStep, cols), cell(box.TopRight.Lon, -180, s.lonStep, cols)
+
+	if float64(maxRow-minRow+1)*float64(maxCol-minCol+1) > float64(len(s.cells)) {
+		for _, bucket := range s.cells {
+			searchBucket(bucket, box, fn)
+		}
+		return
+	}
+	for row := minRow; row <= maxRow; row++ {
+		lat := -90 + (float64(row)+0.5)*s.latStep
+		for col := minCol; col <= maxCol; col++ {
+			lon := -180 + (float64(col)+0.5)*s.lonStep
+			if bucket, ok := s.cells[geohash.Encode(lat, lon, s.precision)]; ok {
+				searchBucket(bucket, box, fn)
+			}
+		}
+	}
+}
+
+// searchBucket calls fn with the points of a latitude-sorted bucket in box
+func searchBucket(bucket []*models.Point, box models.BoundingBox, fn func(p *models.Point)) {
+	i := sort.Search(len(bucket), func(i int) bool { return bucket[i].Location.Lat >= box.BottomLeft.Lat })
+	for ; i < len(bucket) && bucket[i].Location.Lat <= box.TopRight.Lat; i++ {
+		if lon := bucket[i].Location.Lon; lon >= box.BottomLeft.Lon && lon <= box.TopRight.Lon {
+			fn(bucket[i])
+		}
+	}
+}
+
+func (s *geohashStore) count() int {
+	n := 0
+	for _, bucket := range s.cells {
+		n += len(bucket)
+	}
+	return n
+}
+
+func (s *geohashStore) contains(id string) bool {
+	return s.ids.contains(id)
+}
+
+// box is queryBox for geohash storage
+func (s *geohashStore) box(g *GeoIndex, box models.BoundingBox) []*models.Point {
+	var points []*models.Point
+	s.search(box, func(p *models.Point) {
+		if g.inBox(box, *p.Location) {
+			points = append(points, p)
+		}
+	})
+	return points
+}
+
+// within returns the points of geohash storage within radiusKm of center
+func (s *geohashStore) within(g *GeoIndex, center models.Location, radiusKm float64) []models.PointDistance {
+	var results []models.PointDistance
+	for _, box := range g.radiusBoxes(center, radiusKm) {
+		s.search(box, func(p *models.Point) {
+			if !box.Contains(*p.Location) {
+				return
+			}
+			if dist := center.DistanceTo(*p.Location); dist <= radiusKm {
+				results = append(results, g.pointDistance(p, dist))
+			}
+		})
+	}
+	return results
+}
+
+// nearest returns the n points nearest to center in geohash storage by
+// widening a radius search from one cell height until it holds n points or
+// covers the globe
+func (s *geohashStore) nearest(g *GeoIndex, center models.Location, n int) []models.PointDistance {
+	if n <= 0 {
+		return nil
+	}
+	var results []models.PointDistance
+	for radiusKm := s.latStep * math.Pi * earthRadius / 180; ; radiusKm *= 4 {
+		results = s.within(g, center, radiusKm)
+		if len(results) >= n || radiusKm >= math.Pi*earthRadius {
+			break
+		}
+	}
+	sortByDistance(results)
+	if len(results) > n {
+		results = results[:n]
+	}
+	return results
+}
+
+// stats is Stats for geohash storage, reported as a single partition one
+// level deep
+func (s *geohashStore) stats() IndexStats {
+	ps := PartitionStats{
+		Region: models.BoundingBox{
+			BottomLeft: models.Location{Lat: -90, Lon: -180},
+			TopRight:   models.Location{Lat: 90, Lon: 180},
+		},
+		Count: s.count(),
+		Depth: 1,
+	}
+	var bytes int64
+	for key, bucket := range s.cells {
+		bytes += geohashBytesPerCell + int64(len(key))
+		for _, p := range bucket {
+			ps.Bounds = extend(ps.Bounds, *p.Location)
+			bytes += geohashBytesPerPoint + int64(len(p.ID))
+		}
+	}
+	stats := IndexStats{
+		Count:          int64(ps.Count),
+		Partitions:     []PartitionStats{ps},
+		EstimatedBytes: bytes,
+	}
+	if ps.Bounds != nil {
+		bounds := *ps.Bounds
+		stats.Bounds = &bounds
+	}
+	return stats
+}
@@ -0,0 +1,204 @@
+package rtree
+
+import (
+	"container/list"
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+)
+
+// DefaultCacheQuantum is the grid, in degrees, that cached query boxes are
+// widened to when QueryCacheConfig.Quantum is not set
+const DefaultCacheQuantum = 0.01
+
+// QueryCacheConfig configures the query result cache
+type QueryCacheConfig struct {
+	// Size is the maximum number of cached grid boxes
+	Size int
+	// TTL is how long an entry is served; zero keeps entries until they are
+	// evicted or invalidated by a write
+	TTL time.Duration
+	// Quantum is the grid in degrees that query boxes are widened to, so
+	// queries for nearby viewports share an entry
+	Quantum float64
+}
+
+// WithQueryCache caches the points of recently queried areas for read-heavy
+// workloads that repeat the same map viewports. Box and radius queries are
+// widened outward to the Quantum grid, the points of the grid box are cached
+// and then filtered exactly, so results are the same as without the cache.
+// Entries are dropped on every write and least recently used entries are
+// evicted beyond Size. A Size of zero or less disables the cache.
+func WithQueryCache(cfg QueryCacheConfig) Option {
+	return func(g *GeoIndex) {
+		if cfg.Size <= 0 {
+			g.cache = nil
+			return
+		}
+		if !(cfg.Quantum > 0) {
+			cfg.Quantum = DefaultCacheQuantum
+		}
+		g.cache = &queryCache{
+			cfg:     cfg,
+			entries: make(map[cacheKey]*list.Element),
+			lru:     list.New(),
+			now:     time.Now,
+		}
+	}
+}
+
+// cacheKey is the grid cell range of a widened query box: the cells of its
+// bottom-left and top-right corners
+type cacheKey [4]int64
+
+type cacheEntry struct {
+	key     cacheKey
+	state   *indexState // the snapshot the points were read from
+	points  []*models.Point
+	expires time.Time
+}
+
+// queryCache is an LRU of grid box query results
+type queryCache struct {
+	cfg QueryCacheConfig
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[cacheKey]*list.Element
+	lru     *list.List // front is most recently used
+}
+
+// widen returns the key of box and the grid box containing it. The grid box
+// extends strictly past the top and right edges of box, so edge policies
+// applied to it never drop a point of box.
+func (c *queryCache) widen(box models.BoundingBox) (cacheKey, models.BoundingBox) {
+	q := c.cfg.Quantum
+	lo := func(v float64) int64 {
+		k := math.Floor(v / q)
+		if k*q > v {
+			k--
+		}
+		return int64(k)
+	}
+	hi := func(v float64) int64 {
+		k := math.Floor(v / q)
+		if (k+1)*q <= v {
+			k++
+		}
+		return int64(k)
+	}
+	key := cacheKey{lo(box.BottomLeft.Lat), lo(box.BottomLeft.Lon), hi(box.TopRight.Lat), hi(box.TopRight.Lon)}
+	return key, models.BoundingBox{
+		BottomLeft: models.Location{Lat: float64(key[0]) * q, Lon: float64(key[1]) * q},
+		TopRight:   models.Location{Lat: float64(key[2]+1) * q, Lon: float64(key[3]+1) * q},
+	}
+}
+
+// get returns the cached points for key read from state
+func (c *queryCache) get(key cacheKey, state *indexState) ([]*models.Point, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if c.cfg.TTL > 0 && !c.now().Before(entry.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		return nil, false
+	}
+	if entry.state != state {
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return entry.points, true
+}
+
+// put stores the points for key read from state, evicting the least recently
+// used entries beyond the configured size
+func (c *queryCache) put(key cacheKey, state *indexState, points []*models.Point) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &cacheEntry{key: key, state: state, points: points, expires: c.now().Add(c.cfg.TTL)}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.cfg.Size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// purge drops every entry; it is a no-op on a nil cache
+func (c *queryCache) purge() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.entries)
+	c.lru.Init()
+}
+
+// count returns the number of cached entries
+func (c *queryCache) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// gridPoints returns the points of the grid box around box in state, from the
+// cache when possible
+func (g *GeoIndex) gridPoints(ctx context.Context, state *indexState, box models.BoundingBox) []*models.Point {
+	key, grid := g.cache.widen(box)
+	if points, ok := g.cache.get(key, state); ok {
+		return points
+	}
+	points, err := g.searchBox(ctx, state, grid)
+	if err != nil {
+		return nil
+	}
+	g.cache.put(key, state, points)
+	return points
+}
+
+// cachedQueryBox is searchBox served from the cache
+func (g *GeoIndex) cachedQueryBox(ctx context.Context, state *indexState, box models.BoundingBox) []*models.Point {
+	var points []*models.Point
+	for _, p := range g.gridPoints(ctx, state, box) {
+		if g.inBox(box, *p.Location) {
+			points = append(points, p)
+		}
+	}
+	return points
+}
+
+// cachedWithin returns the points within radiusKm of center, filtering the
+// cached points around the circle's bounding box. Distances are computed the
+// same way as the uncached search of the storage mode.
+func (g *GeoIndex) cachedWithin(ctx context.Context, state *indexState, center models.Location, radiusKm float64) []models.PointDistance {
+	centerTrig := newTrigLocation(center)
+	var results []models.PointDistance
+	for _, box := range g.radiusBoxes(center, radiusKm) {
+		for _, p := range g.gridPoints(ctx, state, box) {
+			var dist float64
+			if _, ok := state.store.(*spaceTimeStore); state.store != nil && !ok {
+				dist = center.DistanceTo(*p.Location)
+			} else {
+				dist = centerTrig.distanceKm(newTrigLocation(*p.Location))
+			}
+			if dist <= radiusKm {
+				results = append(results, g.pointDistance(p, dist))
+			}
+		}
+	}
+	return results
+}
@@ -0,0 +1,347 @@
+package rtree
+
+import (
+	"math"
+	"sort"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+)
+
+const (
+	// compactNodeSize is the fan-out of the packed tree used by compact storage
+	compactNodeSize = 16
+	// compactBytesPerPoint approximates the fixed heap cost of one point in
+	// compact storage, two coordinates, a slot in the properties slice and
+	// its position in the ID order, before its ID
+	compactBytesPerPoint = 28
+	// compactStartKm is the first radius tried by nearest-neighbor queries on
+	// compact storage; it grows fourfold until enough points are found
+	compactStartKm = 50
+)
+
+// WithCompactStorage stores points as flat coordinate, ID and property slices
+// under a static packed R-tree instead of one heap object per point, cutting
+// pointer and GC overhead for very large datasets. Queries behave the same but
+// search a single tree rather than partitions in parallel, and return fresh
+// Point values rather than the ones that were indexed. Every IndexPoints call
+// re-sorts and re-packs the whole store, so load data in large batches.
+func WithCompactStorage() Option {
+	return func(g *GeoIndex) {
+		g.storage = compactStorage
+	}
+}
+
+// compactStore keeps points as parallel slices in sort-tile-recursive order,
+// so nearby points sit next to each other, and references them by position.
+// levels[0] holds one box per leaf of compactNodeSize consecutive points and
+// each further level one box per compactNodeSize nodes of the level below;
+// boxes are stored flat as minLat, minLon, maxLat, maxLon. With an ID codec,
+// nums holds encoded IDs and ids is only allocated once an ID fails to encode.
+type compactStore struct {
+	lats   []float64
+	lons   []float64
+	ids    []string
+	nums   []uint64
+	codec  *IDCodec
+	props  []map[string]any // nil until a point with properties is added
+	alts   []float64        // nil until a point with an altitude is added
+	levels [][]float64
+	// idSet answers Contains, sortedIDs unless a false positive rate asks
+	// for a bloom filter
+	idSet             idSet
+	falsePositiveRate float64
+}
+
+// add returns a store holding c's points and those of points that have a
+// location, and the number added
+func (c *compactStore) add(g *GeoIndex, points []*models.Point) (store, int) {
+	next := *c
+	added := next.insert(points, g.wrapLongitudes, g.idCodec, g.idFalsePositives)
+	return &next, added
+}
+
+// without returns a store holding c's points except those with an ID in
+// remove, and the number left out. The kept points are re-packed.
+func (c *compactStore) without(g *GeoIndex, remove map[string]bool) (store, int) {
+	kept := make([]*models.Point, 0, len(c.lats))
+	for i := range c.lats {
+		if !remove[c.id(i)] {
+			kept = append(kept, c.point(i))
+		}
+	}
+	if len(kept) == len(c.lats) {
+		return c, 0
+	}
+	next := &compactStore{}
+	next.insert(kept, false, g.idCodec, g.idFalsePositives)
+	return next, len(c.lats) - len(kept)
+}
+
+// insert appends the points that have a location and re-packs the store. It
+// returns the number of points added.
+func (c *compactStore) insert(points []*models.Point, wrap bool, codec *IDCodec, falsePositiveRate float64) int {
+	c.codec = codec
+	c.falsePositiveRate = falsePositiveRate
+	added := 0
+	for _, p := range points {
+		if p.Location == nil {
+			continue
+		}
+		lon := p.Location.Lon
+		if wrap {
+			lon = models.NormalizeLongitude(lon)
+		}
+		if p.Properties != nil && c.props == nil {
+			c.props = make([]map[string]any, len(c.lats), cap(c.lats))
+		}
+		if p.Location.Alt != 0 && c.alts == nil {
+			c.alts = make([]float64, len(c.lats), cap(c.lats))
+		}
+		c.addID(p.ID)
+		c.lats = append(c.lats, p.Location.Lat)
+		c.lons = append(c.lons, lon)
+		if c.props != nil {
+			c.props = append(c.props, p.Properties)
+		}
+		if c.alts != nil {
+			c.alts = append(c.alts, p.Location.Alt)
+		}
+		added++
+	}
+	if added > 0 {
+		c.pack()
+	}
+	return added
+}
+
+// addID appends id for the point about to be added at position len(c.lats)
+func (c *compactStore) addID(id string) {
+	if c.codec == nil {
+		c.ids = append(c.ids, id)
+		return
+	}
+	n, ok := c.codec.Encode(id)
+	if !ok {
+		n = noID
+		if c.ids == nil {
+			c.ids = make([]string, len(c.lats), cap(c.lats))
+		}
+	}
+	c.nums = append(c.nums, n)
+	if c.ids != nil {
+		if ok {
+			id = ""
+		}
+		c.ids = append(c.ids, id)
+	}
+}
+
+// id returns the ID of the point at position i
+func (c *compactStore) id(i int) string {
+	if c.nums != nil && c.nums[i] != noID {
+		return c.codec.Decode(c.nums[i])
+	}
+	return c.ids[i]
+}
+
+// storedID returns the encoded ID at position i, or noID, and the string ID
+// kept for it, which is empty for encoded IDs
+func (c *compactStore) storedID(i int) (uint64, string) {
+	var num uint64
+	if c.nums != nil {
+		num = c.nums[i]
+	}
+	var id string
+	if c.ids != nil {
+		id = c.ids[i]
+	}
+	return num, id
+}
+
+// idBytes approximates the heap cost of the ID at position i
+func (c *compactStore) idBytes(i int) int64 {
+	var n int64
+	if c.nums != nil {
+		n += 8
+	}
+	if c.ids != nil {
+		n += 16 + int64(len(c.ids[i]))
+	}
+	return n
+}
+
+// pack sorts the points into sort-tile-recursive order, vertical slices by
+// longitude each sorted by latitude, and rebuilds the node boxes
+func (c *compactStore) pack() {
+	n := len(c.lats)
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return c.lons[order[a]] < c.lons[order[b]] })
+
+	leaves := (n + compactNodeSize - 1) / compactNodeSize
+	sliceSize := int(math.Ceil(math.Sqrt(float64(leaves)))) * compactNodeSize
+	for start := 0; start < n; start += sliceSize {
+		s := order[start:min(start+sliceSize, n)]
+		sort.Slice(s, func(a, b int) bool { return c.lats[s[a]] < c.lats[s[b]] })
+	}
+
+	c.lats, c.lons = permute(c.lats, order), permute(c.lons, order)
+	c.ids, c.nums, c.props = permute(c.ids, order), permute(c.nums, order), permute(c.props, order)
+	c.alts = permute(c.alts, order)
+	lats, lons := c.lats, c.lons
+
+	boxes := make([]float64, 0, 4*leaves)
+	for start := 0; start < n; start += compactNodeSize {
+		minLat, minLon := math.Inf(1), math.Inf(1)
+		maxLat, maxLon := math.Inf(-1), math.Inf(-1)
+		for i := start; i < min(start+compactNodeSize, n); i++ {
+			minLat, maxLat = math.Min(minLat, lats[i]), math.Max(maxLat, lats[i])
+			minLon, maxLon = math.Min(minLon, lons[i]), math.Max(maxLon, lons[i])
+		}
+		boxes = append(boxes, minLat, minLon, maxLat, maxLon)
+	}
+	c.levels = [][]float64{boxes}
+	for len(boxes) > 4 {
+		nodes := len(boxes) / 4
+		parents := make([]float64, 0, 4*((nodes+compactNodeSize-1)/compactNodeSize))
+		for start := 0; start < nodes; start += compactNodeSize {
+			b := boxes[4*start : 4*min(start+compactNodeSize, nodes)]
+			minLat, minLon, maxLat, maxLon := b[0], b[1], b[2], b[3]
+			for k := 4; k < len(b); k += 4 {
+				minLat, minLon = math.Min(minLat, b[k]), math.Min(minLon, b[k+1])
+				maxLat, maxLon = math.Max(maxLat, b[k+2]), math.Max(maxLon, b[k+3])
+			}
+			parents = append(parents, minLat, minLon, maxLat, maxLon)
+		}
+		c.levels = append(c.levels, parents)
+		boxes = parents
+	}
+
+	if c.falsePositiveRate > 0 {
+		ids := make([]string, n)
+		for i := range ids {
+			ids[i] = c.id(i)
+		}
+		c.idSet = newBloomFilter(ids, c.falsePositiveRate)
+	} else {
+		c.idSet = newSortedIDs(c)
+	}
+}
+
+// permute returns s reordered so that element i is s[order[i]], or nil for a
+// nil s
+func permute[T any](s []T, order []int) []T {
+	if s == nil {
+		return nil
+	}
+	out := make([]T, len(order))
+	for i, j := range order {
+		out[i] = s[j]
+	}
+	return out
+}
+
+// search calls fn with the position of every point inside box, edges included
+func (c *compactStore) search(box models.BoundingBox, fn func(i int)) {
+	if len(c.levels) == 0 {
+		return
+	}
+	c.searchLevel(len(c.levels)-1, 0, 1, box, fn)
+}
+
+// searchLevel visits nodes [from, to) of a level
+func (c *compactStore) searchLevel(level, from, to int, box models.BoundingBox, fn func(i int)) {
+	boxes := c.levels[level]
+	for j := from; j < to; j++ {
+		b := boxes[4*j : 4*j+4]
+		if b[0] > box.TopRight.Lat || b[2] < box.BottomLeft.Lat || b[1] > box.TopRight.Lon || b[3] < box.BottomLeft.Lon {
+			continue
+		}
+		lo, hi := j*compactNodeSize, (j+1)*compactNodeSize
+		if level > 0 {
+			c.searchLevel(level-1, lo, min(hi, len(c.levels[level-1])/4), box, fn)
+			continue
+		}
+		for i := lo; i < min(hi, len(c.lats)); i++ {
+			if box.Contains(c.location(i)) {
+				fn(i)
+			}
+		}
+	}
+}
+
+func (c *compactStore) location(i int) models.Location {
+	return models.Location{Lat: c.lats[i], Lon: c.lons[i]}
+}
+
+// point materializes the point at position i
+func (c *compactStore) point(i int) *models.Point {
+	loc := c.location(i)
+	if c.alts != nil {
+		loc.Alt = c.alts[i]
+	}
+	p := &models.Point{ID: c.id(i), Location: &loc}
+	if c.props != nil {
+		p.Properties = c.props[i]
+	}
+	return p
+}
+
+// depth returns the number of tree levels above the points
+func (c *compactStore) depth() int {
+	return len(c.levels)
+}
+
+func (c *compactStore) count() int {
+	return len(c.lats)
+}
+
+func (c *compactStore) contains(id string) bool {
+	return c.idSet != nil && c.idSet.contains(id)
+}
+
+// box is queryBox for compact storage
+func (c *compactStore) box(g *GeoIndex, box models.BoundingBox) []*models.Point {
+	var points []*models.Point
+	c.search(box, func(i int) {
+		if g.inBox(box, c.location(i)) {
+			points = append(points, c.point(i))
+		}
+	})
+	return points
+}
+
+// within returns the points within radiusKm of center in compact storage
+func (c *compactStore) within(g *GeoIndex, center models.Location, radiusKm float64) []models.PointDistance {
+	var results []models.PointDistance
+	for _, box := range g.radiusBoxes(center, radiusKm) {
+		c.search(box, func(i int) {
+			if dist := center.DistanceTo(c.location(i)); dist <= radiusKm {
+				results = append(results, g.pointDistance(c.point(i), dist))
+			}
+		})
+	}
+	return results
+}
+
+// nearest returns the n points nearest to center in compact storage by
+// widening a radius search until it holds n points or covers the globe
+func (c *compactStore) nearest(g *GeoIndex, center models.Location, n int) []models.PointDistance {
+	if n <= 0 {
+		return nil
+	}
+	var results []models.PointDistance
+	for radiusKm := float64(compactStartKm); ; radiusKm *= 4 {
+		results = c.within(g, center, radiusKm)
+		if len(results) >= n || radiusKm >= math.Pi*earthRadius {
+			break
+		}
+	}
+	sortByDistance(results)
+	if len(results) > n {
+		results = results[:n]
+	}
+	return results
+}
@@ -0,0 +1,228 @@
+package rtree
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+)
+
+// KeepPolicy selects which point survives when duplicates are collapsed
+type KeepPolicy string
+
+const (
+	// KeepFirst keeps the earliest point of each duplicate group
+	KeepFirst KeepPolicy = "first"
+	// KeepLast keeps the latest point of each duplicate group
+	KeepLast KeepPolicy = "last"
+	// KeepMerged replaces the group with one point at its centroid, using the
+	// ID of the earliest point and the union of the members' properties
+	KeepMerged KeepPolicy = "merged"
+)
+
+// kmPerDegree is the length of one degree of latitude
+const kmPerDegree = math.Pi * earthRadius / 180
+
+// DedupeOptions configures Dedupe
+type DedupeOptions struct {
+	// DistanceKm also treats points closer than this as duplicates; 0 only
+	// collapses identical IDs
+	DistanceKm float64
+	Keep       KeepPolicy
+}
+
+// DedupeResult describes the outcome of Dedupe
+type DedupeResult struct {
+	Points  []*models.Point
+	Groups  int // groups that had more than one member
+	Removed int
+}
+
+// Dedupe collapses points sharing an ID and, when opts.DistanceKm is set,
+// points within that distance of each other. Grouping is transitive, so a
+// chain of nearby points collapses into a single group. Survivors keep the
+// order of their group's first member.
+func Dedupe(points []*models.Point, opts DedupeOptions) (*DedupeResult, error) {
+	switch opts.Keep {
+	case "":
+		opts.Keep = KeepFirst
+	case KeepFirst, KeepLast, KeepMerged:
+	default:
+		return nil, fmt.Errorf("unknown keep policy %q", opts.Keep)
+	}
+	if opts.DistanceKm < 0 {
+		return nil, fmt.Errorf("distance must not be negative")
+	}
+
+	uf := newUnionFind(len(points))
+
+	byID := make(map[string]int, len(points))
+	for i, p := range points {
+		if j, ok := byID[p.ID]; ok {
+			uf.union(j, i)
+		} else {
+			byID[p.ID] = i
+		}
+	}
+
+	if opts.DistanceKm > 0 {
+		unionNearby(points, opts.DistanceKm, uf)
+	}
+
+	// Collect group members in input order
+	members := make(map[int][]int)
+	var roots []int
+	for i := range points {
+		root := uf.find(i)
+		if _, ok := members[root]; !ok {
+			roots = append(roots, root)
+		}
+		members[root] = append(members[root], i)
+	}
+
+	result := &DedupeResult{Points: make([]*models.Point, 0, len(roots))}
+	for _, root := range roots {
+		group := members[root]
+		if len(group) > 1 {
+			result.Groups++
+			result.Removed += len(group) - 1
+		}
+
+		switch {
+		case len(group) == 1 || opts.Keep == KeepFirst:
+			result.Points = append(result.Points, points[group[0]])
+		case opts.Keep == KeepLast:
+			result.Points = append(result.Points, points[group[len(group)-1]])
+		default:
+			result.Points = append(result.Points, mergeGroup(points, group))
+		}
+	}
+	return result, nil
+}
+
+// unionNearby joins points closer than distanceKm using a uniform degree grid
+// with cells one search distance high. Longitude cells wrap at the antimeridian
+// and the number of neighboring columns grows with latitude.
+func unionNearby(points []*models.Point, distanceKm float64, uf *unionFind) {
+	cellDeg := distanceKm / kmPerDegree
+	numCols := int(math.Ceil(360 / cellDeg))
+
+	type cell struct{ row, col int }
+	grid := make(map[cell][]int)
+
+	for i, p := range points {
+		if p.Location == nil {
+			continue
+		}
+		row := int(math.Floor(p.Location.Lat / cellDeg))
+		col := int(math.Floor((p.Location.Lon + 180) / cellDeg))
+
+		// A degree of longitude shrinks with cos(lat); use the widest row we touch
+		maxLat := math.Min(math.Abs(p.Location.Lat)+cellDeg, 90)
+		span := numCols
+		if cos := math.Cos(maxLat * math.Pi / 180); cos > 0 {
+			span = int(math.Ceil(1/cos)) + 1
+		}
+		if 2*span+1 > numCols {
+			span = numCols / 2
+		}
+
+		for dr := -1; dr <= 1; dr++ {
+			for dc := -span; dc <= span; dc++ {
+				c := cell{row + dr, ((col+dc)%numCols + numCols) % numCols}
+				for _, j := range grid[c] {
+					q := points[j].Location
+					if Distance(p.Location.Lat, p.Location.Lon, q.Lat, q.Lon) <= distanceKm {
+						uf.union(j, i)
+					}
+				}
+			}
+		}
+
+		home := cell{row, (col%numCols + numCols) % numCols}
+		grid[home] = append(grid[home], i)
+	}
+}
+
+// mergeGroup returns a point at the centroid of the group. Longitudes are
+// unwrapped around the first member so groups spanning the antimeridian
+// average correctly. Properties are combined, with earlier members winning
+// conflicting keys.
+func mergeGroup(points []*models.Point, group []int) *models.Point {
+	first := points[group[0]]
+	if first.Location == nil {
+		return first
+	}
+
+	var sumLat, sumLon, sumAlt float64
+	n := 0
+	for _, i := range group {
+		loc := points[i].Location
+		if loc == nil {
+			continue
+		}
+		lon := loc.Lon
+		for lon-first.Location.Lon > 180 {
+			lon -= 360
+		}
+		for lon-first.Location.Lon < -180 {
+			lon += 360
+		}
+		sumLat += loc.Lat
+		sumLon += lon
+		sumAlt += loc.Alt
+		n++
+	}
+
+	var props map[string]any
+	for _, i := range group {
+		for k, v := range points[i].Properties {
+			if props == nil {
+				props = make(map[string]any)
+			}
+			if _, ok := props[k]; !ok {
+				props[k] = v
+			}
+		}
+	}
+
+	lon := math.Mod(sumLon/float64(n)+540, 360) - 180
+	return &models.Point{
+		ID:         first.ID,
+		Location:   &models.Location{Lat: sumLat / float64(n), Lon: lon, Alt: sumAlt / float64(n)},
+		Properties: props,
+	}
+}
+
+// unionFind is a disjoint set over point indices
+type unionFind struct {
+	parent []int
+}
+
+func newUnionFind(n int) *unionFind {
+	parent := make([]int, n)
+	for i := range parent {
+		parent[i] = i
+	}
+	return &unionFind{parent: parent}
+}
+
+func (u *unionFind) find(i int) int {
+	for u.parent[i] != i {
+		u.parent[i] = u.parent[u.parent[i]]
+		i = u.parent[i]
+	}
+	return i
+}
+
+// union joins the sets of a and b, keeping the smaller index as root
+func (u *unionFind) union(a, b int) {
+	ra, rb := u.find(a), u.find(b)
+	if ra == rb {
+		return
+	}
+	if rb < ra {
+		ra, rb = rb, ra
+	}
+	u.parent[rb] = ra
+}
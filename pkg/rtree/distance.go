@@ -0,0 +1,69 @@
+package rtree
+
+import (
+	"errors"
+	"math"
+)
+
+// WGS-84 ellipsoid
+const (
+	wgs84A = 6378137.0         // semi-major axis, meters
+	wgs84F = 1 / 298.257223563 // flattening
+	wgs84B = (1 - wgs84F) * wgs84A
+
+	vincentyIterations = 200
+)
+
+// ErrNoConvergence is returned by VincentyDistance for nearly antipodal points,
+// where the iteration does not settle
+var ErrNoConvergence = errors.New("vincenty formula failed to converge")
+
+// VincentyDistance calculates the distance between two points in kilometers on
+// the WGS-84 ellipsoid using Vincenty's inverse formula. It is accurate to
+// millimeters but slower than the spherical Distance.
+func VincentyDistance(lat1, lon1, lat2, lon2 float64) (float64, error) {
+	toRad := math.Pi / 180
+	L := (lon2 - lon1) * toRad
+	U1 := math.Atan((1 - wgs84F) * math.Tan(lat1*toRad))
+	U2 := math.Atan((1 - wgs84F) * math.Tan(lat2*toRad))
+	sinU1, cosU1 := math.Sincos(U1)
+	sinU2, cosU2 := math.Sincos(U2)
+
+	lambda := L
+	var sinSigma, cosSigma, sigma, cosSqAlpha, cos2SigmaM float64
+	for i := 0; ; i++ {
+		if i == vincentyIterations {
+			return 0, ErrNoConvergence
+		}
+
+		sinLambda, cosLambda := math.Sincos(lambda)
+		sinSigma = math.Hypot(cosU2*sinLambda, cosU1*sinU2-sinU1*cosU2*cosLambda)
+		if sinSigma == 0 {
+			return 0, nil // coincident points
+		}
+		cosSigma = sinU1*sinU2 + cosU1*cosU2*cosLambda
+		sigma = math.Atan2(sinSigma, cosSigma)
+		sinAlpha := cosU1 * cosU2 * sinLambda / sinSigma
+		cosSqAlpha = 1 - sinAlpha*sinAlpha
+		cos2SigmaM = 0
+		if cosSqAlpha != 0 { // both points on the equator otherwise
+			cos2SigmaM = cosSigma - 2*sinU1*sinU2/cosSqAlpha
+		}
+
+		C := wgs84F / 16 * cosSqAlpha * (4 + wgs84F*(4-3*cosSqAlpha))
+		prev := lambda
+		lambda = L + (1-C)*wgs84F*sinAlpha*
+			(sigma+C*sinSigma*(cos2SigmaM+C*cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)))
+		if math.Abs(lambda-prev) < 1e-12 {
+			break
+		}
+	}
+
+	uSq := cosSqAlpha * (wgs84A*wgs84A - wgs84B*wgs84B) / (wgs84B * wgs84B)
+	A := 1 + uSq/16384*(4096+uSq*(-768+uSq*(320-175*uSq)))
+	B := uSq / 1024 * (256 + uSq*(-128+uSq*(74-47*uSq)))
+	deltaSigma := B * sinSigma * (cos2SigmaM + B/4*(cosSigma*(-1+2*cos2SigmaM*cos2SigmaM)-
+		B/6*cos2SigmaM*(-3+4*sinSigma*sinSigma)*(-3+4*cos2SigmaM*cos2SigmaM)))
+
+	return wgs84B * A * (sigma - deltaSigma) / 1000, nil
+}
@@ -0,0 +1,27 @@
+package rtree
+
+import "errors"
+
+// Errors returned by GeoIndex and the persistence functions, wrapped with
+// details. Compare with errors.Is.
+var (
+	// ErrNotFound is returned when an index file does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrEmptyIndex is returned by operations that need at least one point
+	ErrEmptyIndex = errors.New("index is empty")
+	// ErrInvalidQuery is returned for query parameters no point can satisfy,
+	// such as NaN coordinates, inverted boxes or negative radii
+	ErrInvalidQuery = errors.New("invalid query")
+	// ErrCorruptFile is returned when an index file cannot be decoded or its
+	// body does not match its header
+	ErrCorruptFile = errors.New("index file is corrupt")
+	// ErrSnapshotEnded is returned by Snapshot methods called after
+	// EndSnapshot
+	ErrSnapshotEnded = errors.New("snapshot ended")
+	// ErrDiscrepancy is matched by the *Discrepancy errors of queries on
+	// indexes built with WithVerification
+	ErrDiscrepancy = errors.New("query result differs from a linear scan")
+	// ErrUnsupported is returned by operations the index's storage layout
+	// does not provide
+	ErrUnsupported = errors.New("not supported")
+)
@@ -0,0 +1,153 @@
+package rtree
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+)
+
+// Fuzz targets in this file run their seed corpus with go test. Fuzz one with
+//
+//	go test ./pkg/rtree -run '^$' -fuzz FuzzQueryBox -fuzztime 1m
+//
+// FuzzLoadFromFile finds new inputs often and minimizing each takes long, so
+// add -fuzzminimizetime 1s to it.
+
+var (
+	fuzzOnce    sync.Once
+	fuzzIndexes map[string]*GeoIndex
+)
+
+// fuzzPoints returns points spread over the globe plus points on the poles,
+// the antimeridian and the prime meridian, where edge cases live
+func fuzzPoints() []*models.Point {
+	points := worldPoints(300, 81)
+	for i, loc := range []models.Location{
+		{Lat: 90, Lon: 0}, {Lat: -90, Lon: 0}, {Lat: 0, Lon: 180}, {Lat: 0, Lon: -180},
+		{Lat: 90, Lon: 180}, {Lat: -90, Lon: -180}, {Lat: 0, Lon: 0}, {Lat: 45, Lon: 179.999999},
+	} {
+		points = append(points, &models.Point{ID: "edge_" + string(rune('a'+i)), Location: &loc})
+	}
+	return points
+}
+
+// fuzzIndex returns an index of fuzzPoints per storage layout, verified
+// against a linear scan
+func fuzzIndex() map[string]*GeoIndex {
+	fuzzOnce.Do(func() {
+		fuzzIndexes = make(map[string]*GeoIndex)
+		for name, opts := range map[string][]Option{
+			"partition": nil,
+			"compact":   {WithCompactStorage()},
+			"geohash":   {WithGeohashStorage(2)},
+			"s2":        {WithS2Storage()},
+			"quadtree":  {WithQuadtreeStorage()},
+			"kdtree":    {WithKDTreeStorage()},
+			"grid":      {WithGridStorage(10)},
+			"halfopen":  {WithBoxEdges(EdgesHalfOpen)},
+		} {
+			index := NewGeoIndex(append(opts, WithVerification(nil))...)
+			if err := index.IndexPoints(fuzzPoints()); err != nil {
+				panic(err)
+			}
+			fuzzIndexes[name] = index
+		}
+	})
+	return fuzzIndexes
+}
+
+func FuzzQueryBox(f *testing.F) {
+	f.Add(-10.0, -10.0, 10.0, 10.0)
+	f.Add(-90.0, -180.0, 90.0, 180.0)
+	f.Add(0.0, 180.0, 0.0, 180.0)
+	f.Add(90.0, -180.0, 90.0, 180.0)
+	f.Add(-91.0, -181.0, 91.0, 181.0)
+	f.Add(10.0, 10.0, -10.0, -10.0)
+	f.Add(math.Inf(-1), math.Inf(-1), math.Inf(1), math.Inf(1))
+	f.Add(math.NaN(), 0.0, 1.0, 1.0)
+	f.Add(-math.MaxFloat64, -math.MaxFloat64, math.MaxFloat64, math.MaxFloat64)
+	f.Add(1e-300, -1e-300, 2e-300, 1e-300)
+
+	f.Fuzz(func(t *testing.T, minLat, minLon, maxLat, maxLon float64) {
+		box := models.BoundingBox{
+			BottomLeft: models.Location{Lat: minLat, Lon: minLon},
+			TopRight:   models.Location{Lat: maxLat, Lon: maxLon},
+		}
+		invalid := checkBox(box) != nil
+		for name, index := range fuzzIndex() {
+			results, err := index.QueryBox(box)
+			if invalid {
+				if !errors.Is(err, ErrInvalidQuery) {
+					t.Fatalf("%s: invalid box %v gave %v, want ErrInvalidQuery", name, box, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("%s: box %v: %v", name, box, err)
+			}
+			for _, p := range results {
+				if !box.Contains(*p.Location) {
+					t.Fatalf("%s: box %v returned %s at %v outside it", name, box, p.ID, *p.Location)
+				}
+			}
+		}
+	})
+}
+
+func FuzzLoadFromFile(f *testing.F) {
+	dir := f.TempDir()
+	for name, opts := range map[string][]Option{
+		"plain":   nil,
+		"compact": {WithCompactStorage(), WithIDCodec(IDCodec{Prefix: "point_"})},
+	} {
+		index := NewGeoIndex(opts...)
+		points := worldPoints(20, 82)
+		points[0].Properties = map[string]any{"name": "first", "tags": []any{"a", 1.5}}
+		if err := index.IndexPoints(points); err != nil {
+			f.Fatal(err)
+		}
+		path := filepath.Join(dir, name+".gob")
+		if err := index.SaveToFile(path); err != nil {
+			f.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+		f.Add(data[:len(data)/2])
+		f.Add(data[:len(fileMagic)+4])
+		flipped := bytes.Clone(data)
+		flipped[len(flipped)-10] ^= 0xff
+		f.Add(flipped)
+	}
+	f.Add([]byte{})
+	f.Add([]byte(fileMagic + "\xff\xff\xff\xff"))
+
+	// One file is rewritten for every input, as the inputs of a fuzzing
+	// process run one at a time
+	path := filepath.Join(dir, "input.gob")
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		index := NewGeoIndex()
+		if err := index.LoadFromFile(path); err != nil {
+			if index.Count() != 0 {
+				t.Fatalf("failed load left %d points", index.Count())
+			}
+			return
+		}
+		// Whatever loaded must be queryable
+		if _, err := index.QueryBox(quadWorld); err != nil {
+			t.Fatal(err)
+		}
+		index.NearestNeighbors(models.Location{}, 3)
+	})
+}
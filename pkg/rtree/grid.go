@@ -0,0 +1,340 @@
+package rtree
+
+import (
+	"math"
+	"slices"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+)
+
+const (
+	// DefaultGridCellDeg is the cell size used by WithGridStorage when the
+	// data gives nothing to suggest one from, about 1.1km of latitude
+	DefaultGridCellDeg = 0.01
+	// gridPointsPerCell is the average number of points per occupied cell
+	// SuggestGridCellSize aims for
+	gridPointsPerCell = 16
+	// gridSampleCells is the number of rows and columns of the coarse
+	// histogram SuggestGridCellSize measures the occupied area with
+	gridSampleCells = 64
+	// gridMaxCells bounds the cell array; extents that would need more cells
+	// at the chosen size get cells twice as large until they fit
+	gridMaxCells = 1 << 22
+	// gridBytesPerPoint approximates the fixed heap cost of one point in grid
+	// storage: the Point and Location structs, its slot in a cell and its
+	// entry in the ID set
+	gridBytesPerPoint = 104
+	// gridBytesPerCell is the slice header of one cell, empty or not
+	gridBytesPerCell = 24
+)
+
+// WithGridStorage keeps points in a fixed grid of square cells cellDeg
+// degrees on a side, spanning only the extent of the data, instead of
+// partitioned R-trees. Queries compute the cells they overlap and scan them
+// with no tree to descend, which beats the trees on dense datasets confined to
+// a city or region. Cells should hold a few dozen points and be no larger than
+// typical queries; 0 picks the size from the first batch with
+// SuggestGridCellSize. Points outside the grid rebuild it over the larger
+// extent, with coarser cells if the extent is too large for cellDeg, so load
+// widely spread data in one batch or choose partitions instead.
+func WithGridStorage(cellDeg float64) Option {
+	return func(g *GeoIndex) {
+		if !(cellDeg > 0) {
+			cellDeg = 0
+		}
+		g.storage = gridStorage
+		g.gridCellDeg = cellDeg
+	}
+}
+
+// SuggestGridCellSize returns a WithGridStorage cell size in degrees for
+// points. It measures the area the points occupy on a coarse histogram of
+// their bounding box, so clustered data is not spread over its empty
+// surroundings, and picks cells holding about 16 points each over that area.
+// Fewer than two located points give DefaultGridCellDeg.
+func SuggestGridCellSize(points []*models.Point) float64 {
+	var bounds *models.BoundingBox
+	n := 0
+	for _, p := range points {
+		if p.Location != nil {
+			bounds = extend(bounds, *p.Location)
+			n++
+		}
+	}
+	if n < 2 {
+		return DefaultGridCellDeg
+	}
+
+	// A line of points still occupies a strip one sample cell wide
+	latSpan := bounds.TopRight.Lat - bounds.BottomLeft.Lat
+	lonSpan := bounds.TopRight.Lon - bounds.BottomLeft.Lon
+	latSpan, lonSpan = max(latSpan, lonSpan/gridSampleCells), max(lonSpan, latSpan/gridSampleCells)
+	if latSpan == 0 {
+		return DefaultGridCellDeg
+	}
+
+	var occupied [gridSampleCells * gridSampleCells]bool
+	cells := 0
+	for _, p := range points {
+		if p.Location == nil {
+			continue
+		}
+		row := min(int((p.Location.Lat-bounds.BottomLeft.Lat)/latSpan*gridSampleCells), gridSampleCells-1)
+		col := min(int((p.Location.Lon-bounds.BottomLeft.Lon)/lonSpan*gridSampleCells), gridSampleCells-1)
+		if !occupied[row*gridSampleCells+col] {
+			occupied[row*gridSampleCells+col] = true
+			cells++
+		}
+	}
+	area := float64(cells) * latSpan * lonSpan / (gridSampleCells * gridSampleCells)
+	return math.Sqrt(area * gridPointsPerCell / float64(n))
+}
+
+// uniformGrid is an immutable grid of rows by cols cells of cellDeg degrees
+// whose bottom-left corner is at (lat0, lon0). cells[row*cols+col] holds the
+// points of a cell. add returns a new grid sharing the cells it did not touch.
+type uniformGrid struct {
+	cellDeg           float64
+	lat0, lon0        float64
+	rows, cols        int
+	cells             [][]*models.Point
+	ids               idBatches
+	falsePositiveRate float64
+}
+
+// add returns a grid holding q's points and those of points that have a
+// location, and the number added. The first batch sets the cell size if none
+// was given; a batch reaching outside the grid rebuilds it.
+func (q *uniformGrid) add(g *GeoIndex, points []*models.Point) (store, int) {
+	batch := make([]*models.Point, 0, len(points))
+	ids := make([]string, 0, len(points))
+	var bounds *models.BoundingBox
+	for _, p := range points {
+		if p.Location == nil {
+			continue
+		}
+		if g.wrapLongitudes {
+			p = wrapPoint(p)
+		}
+		batch = append(batch, p)
+		ids = append(ids, p.ID)
+		bounds = extend(bounds, *p.Location)
+	}
+	if len(batch) == 0 {
+		return q, 0
+	}
+
+	next := *q
+	next.ids = q.ids.with(ids, q.falsePositiveRate)
+	if next.cellDeg == 0 {
+		next.cellDeg = SuggestGridCellSize(batch)
+	}
+	if q.rows > 0 && q.covers(*bounds) {
+		next.cells = slices.Clone(q.cells)
+	} else {
+		if q.rows > 0 {
+			*bounds = bounds.Union(q.extent())
+		}
+		next.layout(*bounds)
+		next.cells = make([][]*models.Point, next.rows*next.cols)
+		for _, cell := range q.cells {
+			batch = append(batch, cell...)
+		}
+	}
+
+	touched := make(map[int][]*models.Point)
+	for _, p := range batch {
+		i := next.cell(*p.Location)
+		touched[i] = append(touched[i], p)
+	}
+	for i, cellPoints := range touched {
+		old := next.cells[i]
+		next.cells[i] = append(old[:len(old):len(old)], cellPoints...)
+	}
+	return &next, len(ids)
+}
+
+// without returns a grid holding q's points except those with an ID in
+// remove, and the number left out. The layout is kept and cells holding none
+// of them are shared.
+func (q *uniformGrid) without(g *GeoIndex, remove map[string]bool) (store, int) {
+	next := *q
+	next.cells = slices.Clone(q.cells)
+	removed := 0
+	var ids []string
+	for i, cell := range q.cells {
+		if slices.ContainsFunc(cell, func(p *models.Point) bool { return remove[p.ID] }) {
+			cell = slices.DeleteFunc(slices.Clone(cell), func(p *models.Point) bool { return remove[p.ID] })
+			removed += len(q.cells[i]) - len(cell)
+			next.cells[i] = cell
+		}
+		for _, p := range cell {
+			ids = append(ids, p.ID)
+		}
+	}
+	if removed == 0 {
+		return q, 0
+	}
+	next.ids = idBatches(nil).with(ids, q.falsePositiveRate)
+	return &next, removed
+}
+
+// layout sizes the grid to cover box with cells aligned to multiples of
+// cellDeg, doubling cellDeg while that takes more than gridMaxCells
+func (q *uniformGrid) layout(box models.BoundingBox) {
+	for {
+		q.lat0 = math.Floor(box.BottomLeft.Lat/q.cellDeg) * q.cellDeg
+		q.lon0 = math.Floor(box.BottomLeft.Lon/q.cellDeg) * q.cellDeg
+		rows := math.Floor((box.TopRight.Lat-q.lat0)/q.cellDeg) + 1
+		cols := math.Floor((box.TopRight.Lon-q.lon0)/q.cellDeg) + 1
+		if rows*cols <= gridMaxCells {
+			q.rows, q.cols = int(rows), int(cols)
+			return
+		}
+		q.cellDeg *= 2
+	}
+}
+
+// extent is the area covered by the grid's cells
+func (q *uniformGrid) extent() models.BoundingBox {
+	return models.BoundingBox{
+		BottomLeft: models.Location{Lat: q.lat0, Lon: q.lon0},
+		TopRight:   models.Location{Lat: q.lat0 + float64(q.rows)*q.cellDeg, Lon: q.lon0 + float64(q.cols)*q.cellDeg},
+	}
+}
+
+// row and col return the row and column holding a coordinate, -1 or rows or
+// cols for any coordinate beyond the grid, however far
+func (q *uniformGrid) row(lat float64) int { return cellIndex((lat-q.lat0)/q.cellDeg, -1, q.rows) }
+func (q *uniformGrid) col(lon float64) int { return cellIndex((lon-q.lon0)/q.cellDeg, -1, q.cols) }
+
+// cellIndex returns the floor of v clamped to [lo, hi]. It clamps before
+// converting, as converting infinite or huge floats to int is undefined.
+func cellIndex(v float64, lo, hi int) int {
+	return int(min(max(math.Floor(v), float64(lo)), float64(hi)))
+}
+
+// covers reports whether box lies within the grid's cells
+func (q *uniformGrid) covers(box models.BoundingBox) bool {
+	return q.row(box.BottomLeft.Lat) >= 0 && q.row(box.TopRight.Lat) < q.rows &&
+		q.col(box.BottomLeft.Lon) >= 0 && q.col(box.TopRight.Lon) < q.cols
+}
+
+// cell returns the index of the cell holding loc, clamped to the grid so
+// rounding at its far edges stays inside
+func (q *uniformGrid) cell(loc models.Location) int {
+	row := min(max(q.row(loc.Lat), 0), q.rows-1)
+	col := min(max(q.col(loc.Lon), 0), q.cols-1)
+	return row*q.cols + col
+}
+
+// search calls fn with every point in the cells overlapping box, a superset
+// of the points inside it
+func (q *uniformGrid) search(box models.BoundingBox, fn func(p *models.Point)) {
+	if q.rows == 0 {
+		return
+	}
+	minRow, maxRow := max(q.row(box.BottomLeft.Lat), 0), min(q.row(box.TopRight.Lat), q.rows-1)
+	minCol, maxCol := max(q.col(box.BottomLeft.Lon), 0), min(q.col(box.TopRight.Lon), q.cols-1)
+	if minCol > maxCol {
+		return
+	}
+	for row := minRow; row <= maxRow; row++ {
+		for _, cell := range q.cells[row*q.cols+minCol : row*q.cols+maxCol+1] {
+			for _, p := range cell {
+				fn(p)
+			}
+		}
+	}
+}
+
+func (q *uniformGrid) count() int {
+	n := 0
+	for _, cell := range q.cells {
+		n += len(cell)
+	}
+	return n
+}
+
+func (q *uniformGrid) contains(id string) bool {
+	return q.ids.contains(id)
+}
+
+// box is queryBox for grid storage
+func (q *uniformGrid) box(g *GeoIndex, box models.BoundingBox) []*models.Point {
+	var points []*models.Point
+	q.search(box, func(p *models.Point) {
+		if g.inBox(box, *p.Location) {
+			points = append(points, p)
+		}
+	})
+	return points
+}
+
+// within returns the points of grid storage within radiusKm of center
+func (q *uniformGrid) within(g *GeoIndex, center models.Location, radiusKm float64) []models.PointDistance {
+	var results []models.PointDistance
+	for _, box := range g.radiusBoxes(center, radiusKm) {
+		q.search(box, func(p *models.Point) {
+			if !box.Contains(*p.Location) {
+				return
+			}
+			if dist := center.DistanceTo(*p.Location); dist <= radiusKm {
+				results = append(results, g.pointDistance(p, dist))
+			}
+		})
+	}
+	return results
+}
+
+// nearest returns the n points nearest to center in grid storage by
+// doubling a radius search from one cell until it holds n points. Once the
+// search box covers the grid, the last search covers the globe.
+func (q *uniformGrid) nearest(g *GeoIndex, center models.Location, n int) []models.PointDistance {
+	if n <= 0 || q.rows == 0 {
+		return nil
+	}
+	extent := q.extent()
+	var results []models.PointDistance
+	for radiusKm := q.cellDeg * math.Pi / 180 * earthRadius; ; radiusKm *= 2 {
+		box := models.NewBoundingBoxFromCenter(center, radiusKm)
+		if box.Contains(extent.BottomLeft) && box.Contains(extent.TopRight) {
+			radiusKm = math.Pi * earthRadius
+		}
+		results = q.within(g, center, radiusKm)
+		if len(results) >= n || radiusKm >= math.Pi*earthRadius {
+			break
+		}
+	}
+	sortByDistance(results)
+	if len(results) > n {
+		results = results[:n]
+	}
+	return results
+}
+
+// stats is Stats for grid storage, reported as a single partition one level
+// deep covering the grid
+func (q *uniformGrid) stats() IndexStats {
+	ps := PartitionStats{Region: quadWorld, Count: q.count(), Depth: 1}
+	if q.rows > 0 {
+		ps.Region = q.extent()
+	}
+	bytes := int64(len(q.cells)) * gridBytesPerCell
+	for _, cell := range q.cells {
+		for _, p := range cell {
+			ps.Bounds = extend(ps.Bounds, *p.Location)
+			bytes += gridBytesPerPoint + int64(len(p.ID))
+		}
+	}
+	stats := IndexStats{
+		Count:          int64(ps.Count),
+		Partitions:     []PartitionStats{ps},
+		EstimatedBytes: bytes,
+	}
+	if ps.Bounds != nil {
+		bounds := *ps.Bounds
+		stats.Bounds = &bounds
+	}
+	return stats
+}
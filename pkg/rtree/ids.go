@@ -0,0 +1,46 @@
+package rtree
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// noID marks a numeric ID slot whose point kept its string ID
+const noID = math.MaxUint64
+
+// IDCodec stores point IDs made of Prefix followed by a decimal number as a
+// uint64 instead of a string, e.g. "point_123456" with Prefix "point_" or
+// "123456" with an empty Prefix. IDs of any other form, including numbers with
+// leading zeros, are kept as strings.
+type IDCodec struct {
+	Prefix string `json:"prefix"`
+}
+
+// WithIDCodec encodes IDs with codec in compact storage and in saved files,
+// saving the string header and bytes of every ID it matches. Points held by
+// the default storage keep their string IDs in memory.
+func WithIDCodec(codec IDCodec) Option {
+	return func(g *GeoIndex) {
+		g.idCodec = &codec
+	}
+}
+
+// Encode returns the number id stands for, or false if id does not have the
+// codec's form
+func (c IDCodec) Encode(id string) (uint64, bool) {
+	digits, ok := strings.CutPrefix(id, c.Prefix)
+	if !ok || digits == "" || len(digits) > 1 && digits[0] == '0' {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(digits, 10, 64)
+	if err != nil || n == noID {
+		return 0, false
+	}
+	return n, true
+}
+
+// Decode returns the ID for n
+func (c IDCodec) Decode(n uint64) string {
+	return c.Prefix + strconv.FormatUint(n, 10)
+}
@@ -0,0 +1,226 @@
+package rtree
+
+import (
+	"hash/fnv"
+	"maps"
+	"math"
+	"sort"
+
+	"github.com/dhconnelly/rtreego"
+)
+
+// idFilterTrees is the number of trees per partition the bloom filter rate is
+// split over, besides the partitions themselves
+const idFilterTrees = 4
+
+// WithIDFilter keeps the IDs behind Contains in bloom filters with the given
+// false positive rate instead of exact sets, trading a map entry per point for
+// a couple of bytes. Contains then never misses an indexed ID but reports an
+// absent one as present at about that rate. Every tree of every partition has
+// its own filter, so the rate is split over the partitions and up to four
+// trees in each; indexes loaded in many small batches hold more trees and see
+// proportionally more false positives. Rates outside (0, 1) keep exact sets.
+func WithIDFilter(falsePositiveRate float64) Option {
+	return func(g *GeoIndex) {
+		if !(falsePositiveRate > 0 && falsePositiveRate < 1) {
+			falsePositiveRate = 0
+		}
+		g.idFalsePositives = falsePositiveRate
+	}
+}
+
+// Contains reports whether a point with the given ID has been indexed, without
+// a spatial query. With WithIDFilter it may report false positives.
+func (g *GeoIndex) Contains(id string) bool {
+	state := g.state.Load()
+	if state.store != nil {
+		return state.store.contains(id)
+	}
+	for _, part := range state.partitions {
+		if part.contains(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// idSet is an immutable set of point IDs
+type idSet interface {
+	contains(id string) bool
+}
+
+// newIDSet returns an exact set of ids, or a bloom filter if
+// falsePositiveRate is positive
+func newIDSet(ids []string, falsePositiveRate float64) idSet {
+	if falsePositiveRate > 0 {
+		return newBloomFilter(ids, falsePositiveRate)
+	}
+	set := make(exactIDs, len(ids))
+	for _, id := range ids {
+		set[id] = struct{}{}
+	}
+	return set
+}
+
+// idBatches holds the ID sets of the points added by successive IndexPoints
+// calls in a store without partitions. Neighboring sets of similar size are
+// merged like partition trees, so there are O(log n) of them.
+type idBatches []idBatch
+
+// idBatch is the ID set of the points added by one or more IndexPoints
+// calls. Bloom filters cannot be combined, so they keep the IDs to rebuild
+// from when merged; exact sets are merged from their keys.
+type idBatch struct {
+	set  idSet
+	ids  []string
+	size int
+}
+
+// with returns the batches plus one for ids. The bloom filter rate is split
+// over idFilterTrees batches as for a partition.
+func (b idBatches) with(ids []string, falsePositiveRate float64) idBatches {
+	rate := falsePositiveRate / idFilterTrees
+	batch := idBatch{set: newIDSet(ids, rate), size: len(ids)}
+	if rate > 0 {
+		batch.ids = ids
+	}
+	next := append(b[:len(b):len(b)], batch)
+	for n := len(next); n > 1 && next[n-2].size <= 2*next[n-1].size; n = len(next) {
+		next = append(next[:n-2], next[n-2].merge(next[n-1], rate))
+	}
+	return next
+}
+
+// merge returns one batch holding the IDs of b and newer
+func (b idBatch) merge(newer idBatch, falsePositiveRate float64) idBatch {
+	if older, ok := b.set.(exactIDs); ok {
+		merged := make(exactIDs, b.size+newer.size)
+		maps.Copy(merged, older)
+		maps.Copy(merged, newer.set.(exactIDs))
+		return idBatch{set: merged, size: len(merged)}
+	}
+	ids := append(b.ids[:len(b.ids):len(b.ids)], newer.ids...)
+	return idBatch{set: newIDSet(ids, falsePositiveRate), ids: ids, size: len(ids)}
+}
+
+// contains reports whether id may be in any batch
+func (b idBatches) contains(id string) bool {
+	for _, batch := range b {
+		if batch.set.contains(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// spatialIDs returns the IDs of the points among items
+func spatialIDs(items []rtreego.Spatial) []string {
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		if sp, ok := item.(*spatialPoint); ok && sp.Point != nil {
+			ids = append(ids, sp.Point.ID)
+		}
+	}
+	return ids
+}
+
+type exactIDs map[string]struct{}
+
+func (s exactIDs) contains(id string) bool {
+	_, ok := s[id]
+	return ok
+}
+
+// bloomFilter is a bloom filter over IDs using double hashing of FNV-1a
+type bloomFilter struct {
+	bits   []uint64
+	hashes uint64
+}
+
+// newBloomFilter sizes a filter for ids at the given false positive rate
+func newBloomFilter(ids []string, falsePositiveRate float64) *bloomFilter {
+	n := float64(max(len(ids), 1))
+	m := math.Ceil(-n * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	f := &bloomFilter{
+		bits:   make([]uint64, (int(m)+63)/64),
+		hashes: uint64(max(1, math.Round(m/n*math.Ln2))),
+	}
+	for _, id := range ids {
+		f.add(id)
+	}
+	return f
+}
+
+// idHashes returns the two hashes that derive the filter's bit positions
+func idHashes(id string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	sum := h.Sum64()
+	return sum, sum>>32 | sum<<32 | 1
+}
+
+func (f *bloomFilter) add(id string) {
+	h1, h2 := idHashes(id)
+	m := uint64(len(f.bits)) * 64
+	for i := uint64(0); i < f.hashes; i++ {
+		bit := (h1 + i*h2) % m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (f *bloomFilter) contains(id string) bool {
+	h1, h2 := idHashes(id)
+	m := uint64(len(f.bits)) * 64
+	for i := uint64(0); i < f.hashes; i++ {
+		bit := (h1 + i*h2) % m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// sortedIDs finds IDs in a compact store through its positions sorted by
+// stored ID, costing four bytes per point instead of a map entry. Positions
+// are ordered by encoded number, then by string, so encoded IDs are compared
+// without decoding them.
+type sortedIDs struct {
+	store *compactStore
+	order []int32
+}
+
+func newSortedIDs(c *compactStore) *sortedIDs {
+	order := make([]int32, len(c.lats))
+	for i := range order {
+		order[i] = int32(i)
+	}
+	sort.Slice(order, func(a, b int) bool {
+		numA, idA := c.storedID(int(order[a]))
+		numB, idB := c.storedID(int(order[b]))
+		if numA != numB {
+			return numA < numB
+		}
+		return idA < idB
+	})
+	return &sortedIDs{store: c, order: order}
+}
+
+func (s *sortedIDs) contains(id string) bool {
+	num, str := uint64(0), id
+	if s.store.nums != nil {
+		if n, ok := s.store.codec.Encode(id); ok {
+			num, str = n, ""
+		} else {
+			num = noID
+		}
+	}
+	i := sort.Search(len(s.order), func(i int) bool {
+		n, v := s.store.storedID(int(s.order[i]))
+		return n > num || n == num && v >= str
+	})
+	if i == len(s.order) {
+		return false
+	}
+	n, v := s.store.storedID(int(s.order[i]))
+	return n == num && v == str
+}
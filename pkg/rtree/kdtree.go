@@ -0,0 +1,403 @@
+package rtree
+
+import (
+	"container/heap"
+	"math"
+	"math/bits"
+	"slices"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+)
+
+// kdBytesPerPoint approximates the fixed heap cost of one point in k-d tree
+// storage: its node, the Point and Location structs and its entry in the ID
+// set
+const kdBytesPerPoint = 136
+
+// WithKDTreeStorage keeps points in a static k-d tree over their unit vectors
+// in 3D instead of partitioned R-trees. The tree is an array in median order
+// with no child pointers or bounding boxes, and straight-line distance between
+// unit vectors orders points exactly as great-circle distance does, so
+// nearest-neighbor queries are exact branch-and-bound searches, including
+// across the antimeridian and around the poles, with less memory and lower
+// latency than R-trees for read-only snapshots. Every IndexPoints call
+// rebuilds the whole tree, so load data in one or a few large batches.
+func WithKDTreeStorage() Option {
+	return func(g *GeoIndex) {
+		g.storage = kdTreeStorage
+	}
+}
+
+// kdTree is an immutable k-d tree laid out implicitly: the node of the range
+// [lo, hi) is nodes[(lo+hi)/2], whose left subtree is [lo, mid) and right
+// subtree [mid+1, hi). Each node splits on the axis along which its range is
+// widest.
+type kdTree struct {
+	nodes             []kdNode
+	ids               idSet
+	falsePositiveRate float64
+}
+
+type kdNode struct {
+	xyz   [3]float64
+	point *models.Point
+	axis  uint8
+}
+
+// add returns a tree holding t's points and those of points that have a
+// location, and the number added
+func (t *kdTree) add(g *GeoIndex, points []*models.Point) (store, int) {
+	nodes := make([]kdNode, len(t.nodes), len(t.nodes)+len(points))
+	copy(nodes, t.nodes)
+	for _, p := range points {
+		if p.Location == nil {
+			continue
+		}
+		if g.wrapLongitudes {
+			p = wrapPoint(p)
+		}
+		nodes = append(nodes, kdNode{xyz: unitVector(*p.Location), point: p})
+	}
+	added := len(nodes) - len(t.nodes)
+	if added == 0 {
+		return t, 0
+	}
+	kdBuild(nodes)
+
+	ids := make([]string, len(nodes))
+	for i, n := range nodes {
+		ids[i] = n.point.ID
+	}
+	return &kdTree{
+		nodes:             nodes,
+		ids:               newIDSet(ids, t.falsePositiveRate),
+		falsePositiveRate: t.falsePositiveRate,
+	}, added
+}
+
+// without returns a tree holding t's points except those with an ID in
+// remove, and the number left out
+func (t *kdTree) without(g *GeoIndex, remove map[string]bool) (store, int) {
+	nodes := slices.DeleteFunc(slices.Clone(t.nodes), func(n kdNode) bool { return remove[n.point.ID] })
+	removed := len(t.nodes) - len(nodes)
+	if removed == 0 {
+		return t, 0
+	}
+	kdBuild(nodes)
+
+	ids := make([]string, len(nodes))
+	for i, n := range nodes {
+		ids[i] = n.point.ID
+	}
+	return &kdTree{
+		nodes:             nodes,
+		ids:               newIDSet(ids, t.falsePositiveRate),
+		falsePositiveRate: t.falsePositiveRate,
+	}, removed
+}
+
+// unitVector returns the point of the unit sphere at loc
+func unitVector(loc models.Location) [3]float64 {
+	lat, lon := loc.Lat*math.Pi/180, loc.Lon*math.Pi/180
+	return [3]float64{math.Cos(lat) * math.Cos(lon), math.Cos(lat) * math.Sin(lon), math.Sin(lat)}
+}
+
+// chord returns the straight-line distance through a unit sphere between two
+// points radiusKm apart along the surface
+func chord(radiusKm float64) float64 {
+	return 2 * math.Sin(math.Min(radiusKm/earthRadius, math.Pi)/2)
+}
+
+// kdBuild arranges nodes into an implicit k-d tree
+func kdBuild(nodes []kdNode) {
+	if len(nodes) <= 1 {
+		return
+	}
+	var lo, hi [3]float64
+	lo, hi = nodes[0].xyz, nodes[0].xyz
+	for _, n := range nodes[1:] {
+		for a := range 3 {
+			lo[a], hi[a] = math.Min(lo[a], n.xyz[a]), math.Max(hi[a], n.xyz[a])
+		}
+	}
+	axis := 0
+	for a := 1; a < 3; a++ {
+		if hi[a]-lo[a] > hi[axis]-lo[axis] {
+			axis = a
+		}
+	}
+
+	mid := len(nodes) / 2
+	kdSelect(nodes, mid, axis)
+	nodes[mid].axis = uint8(axis)
+	kdBuild(nodes[:mid])
+	kdBuild(nodes[mid+1:])
+}
+
+// kdSelect reorders nodes so nodes[k] holds the k-th smallest coordinate on
+// axis, with none larger before it and none smaller after it. Partitions are
+// three-way, so runs of coincident points do not degrade it.
+func kdSelect(nodes []kdNode, k, axis int) {
+	lo, hi := 0, len(nodes)
+	for hi-lo > 1 {
+		a, b, c := nodes[lo].xyz[axis], nodes[(lo+hi)/2].xyz[axis], nodes[hi-1].xyz[axis]
+		pivot := max(min(a, b), min(max(a, b), c))
+
+		// [lo, lt) < pivot, [lt, i) == pivot, [gt, hi) > pivot
+		lt, i, gt := lo, lo, hi
+		for i < gt {
+			switch v := nodes[i].xyz[axis]; {
+			case v < pivot:
+				nodes[lt], nodes[i] = nodes[i], nodes[lt]
+				lt++
+				i++
+			case v > pivot:
+				gt--
+				nodes[gt], nodes[i] = nodes[i], nodes[gt]
+			default:
+				i++
+			}
+		}
+		switch {
+		case k < lt:
+			hi = lt
+		case k >= gt:
+			lo = gt
+		default:
+			return
+		}
+	}
+}
+
+// searchBall calls fn with every node within straight-line distance r of q
+func (t *kdTree) searchBall(q [3]float64, r float64, fn func(n *kdNode)) {
+	var visit func(lo, hi int)
+	visit = func(lo, hi int) {
+		if lo >= hi {
+			return
+		}
+		mid := (lo + hi) / 2
+		n := &t.nodes[mid]
+		if dist2(q, n.xyz) <= r*r {
+			fn(n)
+		}
+		diff := q[n.axis] - n.xyz[n.axis]
+		if diff <= r {
+			visit(lo, mid)
+		}
+		if diff >= -r {
+			visit(mid+1, hi)
+		}
+	}
+	visit(0, len(t.nodes))
+}
+
+// searchBox calls fn with every node inside the axis-aligned box [lo, hi]
+func (t *kdTree) searchBox(lo, hi [3]float64, fn func(n *kdNode)) {
+	var visit func(start, end int)
+	visit = func(start, end int) {
+		if start >= end {
+			return
+		}
+		mid := (start + end) / 2
+		n := &t.nodes[mid]
+		if n.xyz[0] >= lo[0] && n.xyz[0] <= hi[0] && n.xyz[1] >= lo[1] && n.xyz[1] <= hi[1] &&
+			n.xyz[2] >= lo[2] && n.xyz[2] <= hi[2] {
+			fn(n)
+		}
+		if lo[n.axis] <= n.xyz[n.axis] {
+			visit(start, mid)
+		}
+		if hi[n.axis] >= n.xyz[n.axis] {
+			visit(mid+1, end)
+		}
+	}
+	visit(0, len(t.nodes))
+}
+
+func dist2(a, b [3]float64) float64 {
+	dx, dy, dz := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return dx*dx + dy*dy + dz*dz
+}
+
+// boxBounds returns an axis-aligned box in 3D holding the unit vectors of
+// every location in box. Longitudes may pass ±180; latitudes beyond ±90 and
+// boxes a full turn or more wide, infinite ones included, cover the sphere.
+func boxBounds(box models.BoundingBox) (lo, hi [3]float64) {
+	const pad = 1e-12
+	rad := math.Pi / 180
+	minLat := math.Max(box.BottomLeft.Lat, -90) * rad
+	maxLat := math.Min(box.TopRight.Lat, 90) * rad
+	minLon, maxLon := box.BottomLeft.Lon*rad, box.TopRight.Lon*rad
+	if maxLon-minLon >= 2*math.Pi {
+		minLon, maxLon = -math.Pi, math.Pi
+	}
+
+	// cos(lat) is non-negative and largest at the equator
+	cosLo, cosHi := math.Min(math.Cos(minLat), math.Cos(maxLat)), math.Max(math.Cos(minLat), math.Cos(maxLat))
+	if minLat <= 0 && maxLat >= 0 {
+		cosHi = 1
+	}
+	cosLonLo, cosLonHi := trigRange(math.Cos, minLon, maxLon, 0)
+	sinLonLo, sinLonHi := trigRange(math.Sin, minLon, maxLon, math.Pi/2)
+	xLo, xHi := productRange(cosLo, cosHi, cosLonLo, cosLonHi)
+	yLo, yHi := productRange(cosLo, cosHi, sinLonLo, sinLonHi)
+	return [3]float64{xLo - pad, yLo - pad, math.Sin(minLat) - pad},
+		[3]float64{xHi + pad, yHi + pad, math.Sin(maxLat) + pad}
+}
+
+// trigRange returns the range of f, cos or sin, over [lo, hi]; f peaks at
+// peak plus multiples of 2π and bottoms out half a turn later
+func trigRange(f func(float64) float64, lo, hi, peak float64) (float64, float64) {
+	minV, maxV := math.Min(f(lo), f(hi)), math.Max(f(lo), f(hi))
+	if math.Ceil((lo-peak)/(2*math.Pi)) <= math.Floor((hi-peak)/(2*math.Pi)) {
+		maxV = 1
+	}
+	if math.Ceil((lo-peak-math.Pi)/(2*math.Pi)) <= math.Floor((hi-peak-math.Pi)/(2*math.Pi)) {
+		minV = -1
+	}
+	return minV, maxV
+}
+
+// productRange returns the range of a*b for a in [aLo, aHi], b in [bLo, bHi]
+func productRange(aLo, aHi, bLo, bHi float64) (float64, float64) {
+	products := [4]float64{aLo * bLo, aLo * bHi, aHi * bLo, aHi * bHi}
+	lo, hi := products[0], products[0]
+	for _, p := range products[1:] {
+		lo, hi = math.Min(lo, p), math.Max(hi, p)
+	}
+	return lo, hi
+}
+
+func (t *kdTree) count() int {
+	return len(t.nodes)
+}
+
+func (t *kdTree) contains(id string) bool {
+	return t.ids != nil && t.ids.contains(id)
+}
+
+// box is queryBox for k-d tree storage
+func (t *kdTree) box(g *GeoIndex, box models.BoundingBox) []*models.Point {
+	var points []*models.Point
+	lo, hi := boxBounds(box)
+	t.searchBox(lo, hi, func(n *kdNode) {
+		if g.inBox(box, *n.point.Location) {
+			points = append(points, n.point)
+		}
+	})
+	return points
+}
+
+// within returns the points of k-d tree storage within radiusKm of center
+func (t *kdTree) within(g *GeoIndex, center models.Location, radiusKm float64) []models.PointDistance {
+	var results []models.PointDistance
+	// Pad the chord so rounding never drops a point on the circle
+	t.searchBall(unitVector(center), chord(radiusKm)+1e-12, func(n *kdNode) {
+		if dist := center.DistanceTo(*n.point.Location); dist <= radiusKm {
+			results = append(results, g.pointDistance(n.point, dist))
+		}
+	})
+	return results
+}
+
+// kdCandidate is a node found by a nearest-neighbor search and its squared
+// straight-line distance from the query
+type kdCandidate struct {
+	node *kdNode
+	d2   float64
+}
+
+// worse reports whether c ranks after other, farther or tied and later by ID
+func (c kdCandidate) worse(other kdCandidate) bool {
+	if c.d2 != other.d2 {
+		return c.d2 > other.d2
+	}
+	return c.node.point.ID > other.node.point.ID
+}
+
+// kdQueue is a max-heap of candidates holding the best ones found so far,
+// worst on top
+type kdQueue []kdCandidate
+
+func (q kdQueue) Len() int           { return len(q) }
+func (q kdQueue) Less(i, j int) bool { return q[i].worse(q[j]) }
+func (q kdQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *kdQueue) Push(x any)        { *q = append(*q, x.(kdCandidate)) }
+func (q *kdQueue) Pop() any {
+	old := *q
+	c := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return c
+}
+
+// nearest returns the n points nearest to center in k-d tree storage, ties
+// going to the lower ID. It descends toward center first and only enters the
+// far side of a split when the splitting plane is no farther than the worst
+// candidate kept so far.
+func (t *kdTree) nearest(g *GeoIndex, center models.Location, n int) []models.PointDistance {
+	if n <= 0 || len(t.nodes) == 0 {
+		return nil
+	}
+	q := unitVector(center)
+	best := make(kdQueue, 0, n+1)
+	var visit func(lo, hi int)
+	visit = func(lo, hi int) {
+		if lo >= hi {
+			return
+		}
+		mid := (lo + hi) / 2
+		node := &t.nodes[mid]
+		if c := (kdCandidate{node: node, d2: dist2(q, node.xyz)}); len(best) < n || best[0].worse(c) {
+			heap.Push(&best, c)
+			if len(best) > n {
+				heap.Pop(&best)
+			}
+		}
+		diff := q[node.axis] - node.xyz[node.axis]
+		near, far := [2]int{lo, mid}, [2]int{mid + 1, hi}
+		if diff > 0 {
+			near, far = far, near
+		}
+		visit(near[0], near[1])
+		if len(best) < n || diff*diff <= best[0].d2 {
+			visit(far[0], far[1])
+		}
+	}
+	visit(0, len(t.nodes))
+
+	results := make([]models.PointDistance, len(best))
+	for i, c := range best {
+		results[i] = g.pointDistance(c.node.point, center.DistanceTo(*c.node.point.Location))
+	}
+	sortByDistance(results)
+	return results
+}
+
+// stats is Stats for k-d tree storage, reported as a single partition as
+// deep as the tree
+func (t *kdTree) stats() IndexStats {
+	ps := PartitionStats{
+		Region: models.BoundingBox{
+			BottomLeft: models.Location{Lat: -90, Lon: -180},
+			TopRight:   models.Location{Lat: 90, Lon: 180},
+		},
+		Count: t.count(),
+		Depth: bits.Len(uint(len(t.nodes))),
+	}
+	var bytes int64
+	for _, n := range t.nodes {
+		ps.Bounds = extend(ps.Bounds, *n.point.Location)
+		bytes += kdBytesPerPoint + int64(len(n.point.ID))
+	}
+	stats := IndexStats{
+		Count:          int64(ps.Count),
+		Partitions:     []PartitionStats{ps},
+		EstimatedBytes: bytes,
+	}
+	if ps.Bounds != nil {
+		bounds := *ps.Bounds
+		stats.Bounds = &bounds
+	}
+	return stats
+}
@@ -0,0 +1,62 @@
+package rtree
+
+import (
+	"context"
+	"runtime/pprof"
+	"strconv"
+	"sync/atomic"
+)
+
+// Profiler label keys set on query goroutines with WithProfilerLabels
+const (
+	LabelQuery     = "geoindex_query"
+	LabelPartition = "geoindex_partition"
+	LabelOperation = "geoindex_operation"
+)
+
+// Query kinds reported under LabelQuery
+const (
+	queryKindBox     = "box"
+	queryKindRadius  = "radius"
+	queryKindNearest = "nearest"
+)
+
+// WithProfilerLabels attaches runtime/pprof labels to the goroutines searching
+// the index, so CPU profiles attribute time to the query kind (LabelQuery) and
+// partition (LabelPartition), plus any operation set with WithOperation.
+// Labels cost a few allocations per partition searched, so they are off by
+// default.
+func WithProfilerLabels() Option {
+	return func(g *GeoIndex) {
+		g.profilerLabels = true
+	}
+}
+
+// WithOperation returns a context that tags queries made through the Context
+// methods, such as QueryBoxContext, with an operation name under
+// LabelOperation. It only shows up in profiles of indexes built with
+// WithProfilerLabels.
+func WithOperation(ctx context.Context, operation string) context.Context {
+	return pprof.WithLabels(ctx, pprof.Labels(LabelOperation, operation))
+}
+
+// labeled runs fn with the query kind and, unless negative, the partition
+// added to the labels in ctx if profiler labels are enabled. Labels are set on
+// the calling goroutine and reset to those of ctx when fn returns. Each call
+// counts as a partition searched for the slow query hook.
+func (g *GeoIndex) labeled(ctx context.Context, kind string, partition int, fn func(ctx context.Context)) {
+	if g.slowLog != nil {
+		if searched, ok := ctx.Value(searchedKey{}).(*atomic.Int32); ok {
+			searched.Add(1)
+		}
+	}
+	if !g.profilerLabels {
+		fn(ctx)
+		return
+	}
+	labels := pprof.Labels(LabelQuery, kind)
+	if partition >= 0 {
+		labels = pprof.Labels(LabelQuery, kind, LabelPartition, strconv.Itoa(partition))
+	}
+	pprof.Do(ctx, labels, fn)
+}
@@ -0,0 +1,31 @@
+package rtree
+
+import (
+	"io"
+	"log/slog"
+)
+
+// discardLogger is the logger of indexes built without WithLogger. Its
+// handler is disabled at every level, so log calls cost no formatting.
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError + 1}))
+
+// WithLogger sends structured logs of the index to logger: loads and saves
+// at info level with their file, point count and duration, failures at error
+// level, index rebuilds by BoundedIndex and MovingIndex, region loads and
+// evictions by TieredIndex, verification discrepancies at warn level, and
+// indexed batches and snapshots at debug level. Indexes log nothing by
+// default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(g *GeoIndex) {
+		g.log = logger
+	}
+}
+
+// logger returns the index's logger, one discarding everything without
+// WithLogger
+func (g *GeoIndex) logger() *slog.Logger {
+	if g.log == nil {
+		return discardLogger
+	}
+	return g.log
+}
@@ -0,0 +1,71 @@
+package rtree
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+)
+
+// DistanceMatrix returns the haversine distances in kilometers between every
+// origin and every destination, with matrix[i][j] the distance from
+// origins[i] to destinations[j]. Rows are computed in parallel across CPUs.
+func DistanceMatrix(origins, destinations []models.Location) [][]float64 {
+	dests := make([]trigLocation, len(destinations))
+	for j, d := range destinations {
+		dests[j] = newTrigLocation(d)
+	}
+
+	// One backing array keeps the matrix to two allocations
+	cells := make([]float64, len(origins)*len(destinations))
+	matrix := make([][]float64, len(origins))
+	for i := range matrix {
+		matrix[i] = cells[i*len(destinations) : (i+1)*len(destinations) : (i+1)*len(destinations)]
+	}
+
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < min(runtime.NumCPU(), len(origins)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := int(next.Add(1) - 1); i < len(origins); i = int(next.Add(1) - 1) {
+				origin := newTrigLocation(origins[i])
+				row := matrix[i]
+				for j, d := range dests {
+					row[j] = origin.distanceKm(d)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return matrix
+}
+
+// NearbyDistanceMatrix restricts a distance matrix to indexed points: for each
+// origin it returns the points within radius, in the index's distance unit,
+// sorted nearest first with ties broken by ID. Candidates come from the tree,
+// so the cost follows the number of nearby points rather than the index size.
+// Origins are spread over the worker pool like QueryRadii.
+func (g *GeoIndex) NearbyDistanceMatrix(origins []models.Location, radius float64) ([][]models.PointDistance, error) {
+	checked := make([]models.Location, len(origins))
+	var radiusKm float64
+	for i, origin := range origins {
+		var err error
+		if checked[i], radiusKm, err = g.checkRadius(origin, radius); err != nil {
+			return nil, fmt.Errorf("origin %d: %w", i, err)
+		}
+	}
+
+	state := g.state.Load()
+	rows := make([][]models.PointDistance, len(origins))
+	g.forEachQuery(queryKindRadius, len(origins), func(ctx context.Context, i int) {
+		row := g.serialQueryRadius(ctx, state, checked[i], radiusKm)
+		sortByDistance(row)
+		rows[i] = row
+	})
+	return rows, nil
+}
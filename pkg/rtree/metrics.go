@@ -0,0 +1,161 @@
+package rtree
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics receives measurements of an index, so any monitoring stack can be
+// wired in without this package depending on its client. Implementations
+// must be safe for concurrent use, and quick, as they run on the querying and
+// writing goroutines.
+type Metrics interface {
+	// IncQueries counts a query of kind "box", "radius" or "nearest"
+	IncQueries(kind string)
+	// ObserveLatency records how long a query of kind took
+	ObserveLatency(kind string, d time.Duration)
+	// SetPointCount reports the number of indexed points after a write
+	SetPointCount(n int64)
+}
+
+// NopMetrics discards every measurement. It is the default of indexes built
+// without WithMetrics.
+type NopMetrics struct{}
+
+func (NopMetrics) IncQueries(string)                    {}
+func (NopMetrics) ObserveLatency(string, time.Duration) {}
+func (NopMetrics) SetPointCount(int64)                  {}
+
+// WithMetrics reports every QueryBox, QueryRadius and NearestNeighbors call,
+// including their Context and WithDistance forms, with its latency, and the
+// point count after every IndexPoints and Clear, to m. Queries failing
+// validation are not reported.
+func WithMetrics(m Metrics) Option {
+	return func(g *GeoIndex) {
+		g.metrics = m
+	}
+}
+
+// DefaultLatencyBuckets are the upper bounds, in seconds, of the latency
+// histogram buckets of PrometheusMetrics, from 10µs to 1s
+var DefaultLatencyBuckets = []float64{0.00001, 0.00005, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1}
+
+// PrometheusMetrics is a Metrics keeping query counters, latency histograms
+// and the point count, and writing them in the Prometheus text exposition
+// format. Serve it as the /metrics handler, or append WriteTo's output to an
+// existing one.
+type PrometheusMetrics struct {
+	namespace string
+	buckets   []float64
+	points    atomic.Int64
+
+	mu    sync.Mutex
+	kinds map[string]*latencyHistogram
+}
+
+// latencyHistogram holds the counts of one query kind
+type latencyHistogram struct {
+	queries int64
+	counts  []int64 // per bucket, not cumulative; the last one is +Inf
+	sum     float64
+	count   int64
+}
+
+// NewPrometheusMetrics creates metrics named namespace_queries_total,
+// namespace_query_duration_seconds and namespace_points, with
+// DefaultLatencyBuckets when buckets is empty
+func NewPrometheusMetrics(namespace string, buckets ...float64) *PrometheusMetrics {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+	return &PrometheusMetrics{
+		namespace: namespace,
+		buckets:   buckets,
+		kinds:     make(map[string]*latencyHistogram),
+	}
+}
+
+// histogram returns the counts of kind, creating them; m.mu must be held
+func (m *PrometheusMetrics) histogram(kind string) *latencyHistogram {
+	h, ok := m.kinds[kind]
+	if !ok {
+		h = &latencyHistogram{counts: make([]int64, len(m.buckets)+1)}
+		m.kinds[kind] = h
+	}
+	return h
+}
+
+func (m *PrometheusMetrics) IncQueries(kind string) {
+	m.mu.Lock()
+	m.histogram(kind).queries++
+	m.mu.Unlock()
+}
+
+func (m *PrometheusMetrics) ObserveLatency(kind string, d time.Duration) {
+	seconds := d.Seconds()
+	bucket, _ := slices.BinarySearch(m.buckets, seconds)
+	m.mu.Lock()
+	h := m.histogram(kind)
+	h.counts[bucket]++
+	h.sum += seconds
+	h.count++
+	m.mu.Unlock()
+}
+
+func (m *PrometheusMetrics) SetPointCount(n int64) {
+	m.points.Store(n)
+}
+
+// WriteTo writes the metrics to w in the Prometheus text exposition format
+func (m *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	m.mu.Lock()
+	kinds := make([]string, 0, len(m.kinds))
+	for kind := range m.kinds {
+		kinds = append(kinds, kind)
+	}
+	slices.Sort(kinds)
+
+	fmt.Fprintf(&buf, "# TYPE %s_queries_total counter\n", m.namespace)
+	for _, kind := range kinds {
+		fmt.Fprintf(&buf, "%s_queries_total{type=%q} %d\n", m.namespace, kind, m.kinds[kind].queries)
+	}
+	fmt.Fprintf(&buf, "# TYPE %s_query_duration_seconds histogram\n", m.namespace)
+	for _, kind := range kinds {
+		h := m.kinds[kind]
+		var cumulative int64
+		for i, le := range m.buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(&buf, "%s_query_duration_seconds_bucket{type=%q,le=\"%g\"} %d\n", m.namespace, kind, le, cumulative)
+		}
+		fmt.Fprintf(&buf, "%s_query_duration_seconds_bucket{type=%q,le=\"+Inf\"} %d\n", m.namespace, kind, h.count)
+		fmt.Fprintf(&buf, "%s_query_duration_seconds_sum{type=%q} %g\n", m.namespace, kind, h.sum)
+		fmt.Fprintf(&buf, "%s_query_duration_seconds_count{type=%q} %d\n", m.namespace, kind, h.count)
+	}
+	m.mu.Unlock()
+	fmt.Fprintf(&buf, "# TYPE %s_points gauge\n", m.namespace)
+	fmt.Fprintf(&buf, "%s_points %d\n", m.namespace, m.points.Load())
+	return buf.WriteTo(w)
+}
+
+// ServeHTTP writes the metrics as a Prometheus scrape endpoint
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = m.WriteTo(w)
+}
+
+// metricsSink returns the index's metrics, NopMetrics without WithMetrics
+func (g *GeoIndex) metricsSink() Metrics {
+	if g.metrics == nil {
+		return NopMetrics{}
+	}
+	return g.metrics
+}
@@ -0,0 +1,419 @@
+package rtree
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+)
+
+const (
+	// DefaultSpeedField is the property holding an object's speed in meters
+	// per second when no field is configured
+	DefaultSpeedField = "speed"
+	// DefaultHeadingField is the property holding an object's heading in
+	// degrees clockwise from north when no field is configured
+	DefaultHeadingField = "heading"
+	// DefaultMaxExtrapolation is how far past its last report an object is
+	// moved when no limit is configured
+	DefaultMaxExtrapolation = 5 * time.Minute
+)
+
+// MovingConfig configures a MovingIndex
+type MovingConfig struct {
+	// SpeedField is the property holding the speed in meters per second,
+	// DefaultSpeedField when empty
+	SpeedField string
+	// HeadingField is the property holding the heading in degrees clockwise
+	// from north, DefaultHeadingField when empty. Points without a readable
+	// positive speed and a heading stand still.
+	HeadingField string
+	// TimeField is the property holding the time of the report, read as by
+	// BoundedIndex, DefaultTimestampField when empty. Points without one are
+	// taken as reported when they are indexed.
+	TimeField string
+	// MaxExtrapolation bounds how far past its report an object moves:
+	// predictions for later times stop where the object would be then.
+	// DefaultMaxExtrapolation when zero.
+	MaxExtrapolation time.Duration
+	// Options configure the underlying GeoIndex
+	Options []Option
+}
+
+// MovingIndex tracks moving objects such as vehicles of a fleet. Each point
+// is an object's last report, with its speed and heading; queries ask where
+// objects will be at a time t by dead reckoning along a great circle from the
+// report, so objects need to report only when they turn or change speed
+// rather than every few seconds. Predictions never move an object further
+// than MaxExtrapolation past its report, and times before a report give the
+// reported position. Indexing an ID already held replaces its report.
+//
+// Reports are indexed where they were made. Queries search an area grown by
+// the distance the fastest object can cover in MaxExtrapolation and keep the
+// objects whose predicted position matches, returning copies of their points
+// moved there. Replaced reports stay in the underlying GeoIndex until a
+// quarter as many as there are objects have accumulated, when it is rebuilt.
+type MovingIndex struct {
+	speedField       string
+	headingField     string
+	timeField        string
+	maxExtrapolation float64 // seconds
+	opts             []Option
+	now              func() time.Time
+
+	mu       sync.Mutex
+	index    *GeoIndex
+	objects  map[string]*movingObject
+	maxSpeed float64 // meters per second, of any report in index
+}
+
+// movingObject is the last report of an object
+type movingObject struct {
+	point   *models.Point
+	at      float64 // Unix seconds
+	speed   float64 // meters per second
+	heading float64 // degrees
+}
+
+// NewMovingIndex returns an empty MovingIndex
+func NewMovingIndex(c MovingConfig) *MovingIndex {
+	if c.SpeedField == "" {
+		c.SpeedField = DefaultSpeedField
+	}
+	if c.HeadingField == "" {
+		c.HeadingField = DefaultHeadingField
+	}
+	if c.TimeField == "" {
+		c.TimeField = DefaultTimestampField
+	}
+	if c.MaxExtrapolation <= 0 {
+		c.MaxExtrapolation = DefaultMaxExtrapolation
+	}
+	return &MovingIndex{
+		speedField:       c.SpeedField,
+		headingField:     c.HeadingField,
+		timeField:        c.TimeField,
+		maxExtrapolation: c.MaxExtrapolation.Seconds(),
+		opts:             c.Options,
+		now:              time.Now,
+		index:            NewGeoIndex(c.Options...),
+		objects:          make(map[string]*movingObject),
+	}
+}
+
+// object reads the report p, made at now unless it has a time
+func (m *MovingIndex) object(p *models.Point, now float64) *movingObject {
+	o := &movingObject{point: p, at: now}
+	if at, ok := propertyNumber(p.Properties[m.timeField]); ok {
+		o.at = at
+	}
+	speed, ok := propertyNumber(p.Properties[m.speedField])
+	heading, hasHeading := propertyNumber(p.Properties[m.headingField])
+	if ok && hasHeading && speed > 0 && !math.IsInf(speed, 0) && !math.IsNaN(heading) && !math.IsInf(heading, 0) {
+		o.speed, o.heading = speed, heading
+	}
+	return o
+}
+
+// predict returns where o is at t in Unix seconds
+func (m *MovingIndex) predict(o *movingObject, t float64) models.Location {
+	dt := min(max(t-o.at, 0), m.maxExtrapolation)
+	if o.speed == 0 || !(dt > 0) {
+		return *o.point.Location
+	}
+	loc := o.point.Location.Destination(o.heading, o.speed*dt/1000)
+	loc.Alt = o.point.Location.Alt
+	return loc
+}
+
+// moved returns o's point at loc, a copy unless loc is where it was reported
+func moved(o *movingObject, loc models.Location) *models.Point {
+	if loc == *o.point.Location {
+		return o.point
+	}
+	p := *o.point
+	p.Location = &loc
+	return &p
+}
+
+// IndexPoints adds the reports that have a location, replacing the reports
+// of objects with the same ID
+func (m *MovingIndex) IndexPoints(points []*models.Point) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := unixSeconds(m.now())
+	batch := make([]*models.Point, 0, len(points))
+	for _, p := range points {
+		if p.Location == nil {
+			continue
+		}
+		if m.index.wrapLongitudes {
+			p = wrapPoint(p)
+		}
+		o := m.object(p, now)
+		m.objects[p.ID] = o
+		m.maxSpeed = max(m.maxSpeed, o.speed)
+		batch = append(batch, p)
+	}
+
+	// Only the last report of each object in the batch reaches the index
+	live := batch[:0]
+	for _, p := range batch {
+		if m.objects[p.ID].point == p {
+			live = append(live, p)
+		}
+	}
+	if err := m.index.IndexPoints(live); err != nil {
+		return err
+	}
+	if m.stale() > int64(len(m.objects)/boundedRebuildDivisor) {
+		return m.rebuild()
+	}
+	return nil
+}
+
+// stale is the number of replaced reports in the underlying index
+func (m *MovingIndex) stale() int64 {
+	return m.index.Count() - int64(len(m.objects))
+}
+
+// rebuild replaces the underlying index with one holding only the last
+// reports, and recomputes the top speed
+func (m *MovingIndex) rebuild() error {
+	points := make([]*models.Point, 0, len(m.objects))
+	m.maxSpeed = 0
+	for _, o := range m.objects {
+		points = append(points, o.point)
+		m.maxSpeed = max(m.maxSpeed, o.speed)
+	}
+	stale := m.stale()
+	index := NewGeoIndex(m.opts...)
+	if err := index.IndexPoints(points); err != nil {
+		index.logger().Error("moving index rebuild failed", "error", err)
+		return err
+	}
+	m.index = index
+	index.logger().Info("moving index rebuilt", "objects", len(points), "dropped", stale)
+	return nil
+}
+
+// current returns the underlying index for a query and the distance in
+// kilometers any object can move from its report
+func (m *MovingIndex) current() (*GeoIndex, float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.index, m.maxSpeed * m.maxExtrapolation / 1000
+}
+
+// live returns the last report of the object p is a report of, if p is that
+// report. Replaced reports at the same location also match, so callers skip
+// IDs they have already seen.
+func (m *MovingIndex) live(p *models.Point) (*movingObject, bool) {
+	o, ok := m.objects[p.ID]
+	if !ok || *o.point.Location != *p.Location {
+		return nil, false
+	}
+	return o, true
+}
+
+// PredictAt returns where the object id is at t, and false if the index has
+// no object id
+func (m *MovingIndex) PredictAt(id string, t time.Time) (models.Location, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, ok := m.objects[id]
+	if !ok {
+		return models.Location{}, false
+	}
+	return m.predict(o, unixSeconds(t)), true
+}
+
+// QueryBoxAt returns the objects inside box at t, moved to their predicted
+// positions
+func (m *MovingIndex) QueryBoxAt(box models.BoundingBox, t time.Time) ([]*models.Point, error) {
+	if err := checkBox(box); err != nil {
+		return nil, err
+	}
+	index, reachKm := m.current()
+	parts := []models.BoundingBox{box}
+	if index.wrapLongitudes {
+		parts = splitAtAntimeridian(box)
+	}
+	var candidates []*models.Point
+	for _, part := range splitAtAntimeridian(box.Expand(reachKm)) {
+		points, err := index.QueryBox(part)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, points...)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	at := unixSeconds(t)
+	seen := make(map[string]bool, len(candidates))
+	var results []*models.Point
+	for _, p := range candidates {
+		o, ok := m.live(p)
+		if !ok || seen[p.ID] {
+			continue
+		}
+		seen[p.ID] = true
+		loc := m.predict(o, at)
+		for _, part := range parts {
+			if index.inBox(part, loc) {
+				results = append(results, moved(o, loc))
+				break
+			}
+		}
+	}
+	if index.stableOrder {
+		sort.Slice(results, func(i, j int) bool { return results[i].ID < results[j].ID })
+	}
+	return results, nil
+}
+
+// QueryRadiusAt returns the objects within radius of center at t, in the
+// unit of the index options, moved to their predicted positions and nearest
+// first
+func (m *MovingIndex) QueryRadiusAt(center models.Location, radius float64, t time.Time) ([]models.PointDistance, error) {
+	index, reachKm := m.current()
+	center, radiusKm, err := index.checkRadius(center, radius)
+	if err != nil {
+		return nil, err
+	}
+	return m.within(index, center, radiusKm, reachKm, unixSeconds(t)), nil
+}
+
+// within returns the objects within radiusKm of a checked center at t in
+// Unix seconds, nearest first
+func (m *MovingIndex) within(index *GeoIndex, center models.Location, radiusKm, reachKm, t float64) []models.PointDistance {
+	candidates := index.surfaceWithin(context.Background(), index.state.Load(), center, radiusKm+reachKm)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	seen := make(map[string]bool, len(candidates))
+	var results []models.PointDistance
+	for _, c := range candidates {
+		o, ok := m.live(c.Point)
+		if !ok || seen[c.Point.ID] {
+			continue
+		}
+		seen[c.Point.ID] = true
+		loc := m.predict(o, t)
+		if dist := center.DistanceTo(loc); dist <= radiusKm {
+			results = append(results, index.pointDistance(moved(o, loc), dist))
+		}
+	}
+	sortByDistance(results)
+	return results
+}
+
+// NearestNeighborsAt returns up to n objects nearest to center at t, moved to
+// their predicted positions and nearest first. The n reports nearest to
+// center bound how far the n nearest objects can be, and a radius search
+// over that distance finds them.
+func (m *MovingIndex) NearestNeighborsAt(center models.Location, n int, t time.Time) []models.PointDistance {
+	if n <= 0 {
+		return nil
+	}
+	index, reachKm := m.current()
+	if index.wrapLongitudes {
+		center.Lon = models.NormalizeLongitude(center.Lon)
+	}
+	at := unixSeconds(t)
+
+	var candidates []models.PointDistance
+	var farthest float64
+	for k := n; ; k *= 2 {
+		candidates = index.NearestNeighborsWithDistance(center, k)
+		m.mu.Lock()
+		seen := make(map[string]bool, len(candidates))
+		var dists []float64
+		for _, c := range candidates {
+			if o, ok := m.live(c.Point); ok && !seen[c.Point.ID] {
+				seen[c.Point.ID] = true
+				dists = append(dists, center.DistanceTo(m.predict(o, at)))
+			}
+		}
+		m.mu.Unlock()
+		if len(dists) >= n {
+			sort.Float64s(dists)
+			farthest = dists[n-1]
+			break
+		}
+		if len(candidates) < k {
+			farthest = math.Pi * earthRadius
+			break
+		}
+	}
+
+	results := m.within(index, center, farthest*(1+1e-9)+1e-9, reachKm, at)
+	if len(results) > n {
+		results = results[:n]
+	}
+	return results
+}
+
+// QueryBox returns the objects inside box now
+func (m *MovingIndex) QueryBox(box models.BoundingBox) ([]*models.Point, error) {
+	return m.QueryBoxAt(box, m.now())
+}
+
+// QueryRadius returns the objects within radius of center now, in the unit
+// of the index options
+func (m *MovingIndex) QueryRadius(center models.Location, radius float64) ([]*models.Point, error) {
+	results, err := m.QueryRadiusAt(center, radius, m.now())
+	if err != nil {
+		return nil, err
+	}
+	points := make([]*models.Point, len(results))
+	for i, r := range results {
+		points[i] = r.Point
+	}
+	return points, nil
+}
+
+// NearestNeighbors returns up to n objects nearest to center now, nearest
+// first
+func (m *MovingIndex) NearestNeighbors(center models.Location, n int) []*models.Point {
+	results := m.NearestNeighborsAt(center, n, m.now())
+	points := make([]*models.Point, len(results))
+	for i, r := range results {
+		points[i] = r.Point
+	}
+	return points
+}
+
+// Count returns the number of objects
+func (m *MovingIndex) Count() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return int64(len(m.objects))
+}
+
+// SaveToFile writes the last report of every object to an index file
+func (m *MovingIndex) SaveToFile(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stale() > 0 {
+		if err := m.rebuild(); err != nil {
+			return err
+		}
+	}
+	return m.index.SaveToFile(path)
+}
+
+// LoadFromFile adds the reports of an index file, replacing reports as
+// IndexPoints does
+func (m *MovingIndex) LoadFromFile(path string) error {
+	points, err := ReadPoints(path)
+	if err != nil {
+		return err
+	}
+	return m.IndexPoints(points)
+}
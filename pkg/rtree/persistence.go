@@ -1,40 +1,131 @@
 package rtree
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
 	"encoding/gob"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
 	"os"
+	"time"
 
 	"github.com/1F47E/geo-index-rtree/pkg/models"
 )
 
+// Index files start with fileMagic, a little-endian uint32 header length and a
+// JSON FileHeader, followed by the gob-encoded IndexData body. Files written
+// before the header existed are a bare gob body and are still readable.
+// Version 3 added encoded IDs; version 2 files are read unchanged.
+const (
+	fileMagic         = "GEOINDEX"
+	FileFormatVersion = 3
+	maxHeaderSize     = 1024 * 1024
+)
+
+var (
+	// ErrNoHeader is returned by ReadHeader for files without a metadata
+	// block, such as snapshots written by older versions
+	ErrNoHeader = errors.New("file has no metadata header")
+	// ErrCorrupt is returned when a file's body does not match its header.
+	//
+	// Deprecated: use ErrCorruptFile, which it is equal to.
+	ErrCorrupt = ErrCorruptFile
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+func init() {
+	// Nested property values are stored in interfaces, which gob can only
+	// encode for registered types
+	gob.Register(map[string]any{})
+	gob.Register([]any{})
+}
+
+// FileHeader is the metadata block stored ahead of the points in an index file
+type FileHeader struct {
+	Version   int                 `json:"version"`
+	Count     int64               `json:"count"`
+	Bounds    *models.BoundingBox `json:"bounds,omitempty"`
+	CreatedAt time.Time           `json:"created_at"`
+	BodySize  int64               `json:"body_size"`
+	Checksum  uint32              `json:"checksum"` // CRC-32C of the body
+	// IDCodec is set when the body stores IDs as numbers in IndexData.IDs
+	IDCodec *IDCodec `json:"id_codec,omitempty"`
+}
+
 // IndexData represents the serializable form of the geo index
 type IndexData struct {
 	Points []*models.Point `json:"points"`
 	Count  int64          `json:"count"`
+	// IDs holds, for files with an ID codec, the encoded ID of each point
+	// whose ID field is left empty, or noID where the ID field is used
+	IDs []uint64 `json:"ids,omitempty"`
 }
 
 // SaveToFile saves the index to a binary file
 func (g *GeoIndex) SaveToFile(filename string) error {
-	g.mu.RLock()
-	
-	// Extract all points from all partitions
-	// We need to unlock before calling QueryBox to avoid deadlock
-	g.mu.RUnlock()
-	
+	// Extract all points from one snapshot so they match the count
+	return g.saveState(g.state.Load(), filename)
+}
+
+// saveState writes the points of state to a binary file and logs the outcome
+func (g *GeoIndex) saveState(state *indexState, filename string) error {
+	start := time.Now()
+	if err := g.writeState(state, filename); err != nil {
+		g.logger().Error("index save failed", "file", filename, "error", err)
+		return err
+	}
+	g.logger().Info("index saved", "file", filename, "points", state.count, "duration", time.Since(start))
+	return nil
+}
+
+// writeState writes the points of state to a binary file
+func (g *GeoIndex) writeState(state *indexState, filename string) error {
 	largeBounds := models.BoundingBox{
 		BottomLeft: models.Location{Lat: -90, Lon: -180},
 		TopRight:   models.Location{Lat: 90, Lon: 180},
 	}
 	
-	points, err := g.QueryBox(largeBounds)
+	points, err := g.searchBox(context.Background(), state, largeBounds)
 	if err != nil {
 		return fmt.Errorf("failed to extract points: %w", err)
 	}
 
 	data := IndexData{
 		Points: points,
-		Count:  g.itemCount.Load(),
+		Count:  state.count,
+	}
+	if g.idCodec != nil {
+		data.Points, data.IDs = encodeIDs(points, *g.idCodec)
+	}
+
+	var body bytes.Buffer
+	if err := gob.NewEncoder(&body).Encode(data); err != nil {
+		return fmt.Errorf("failed to encode data: %w", err)
+	}
+
+	header := FileHeader{
+		Version:   FileFormatVersion,
+		Count:     data.Count,
+		CreatedAt: time.Now().UTC(),
+		BodySize:  int64(body.Len()),
+		Checksum:  crc32.Checksum(body.Bytes(), crcTable),
+		IDCodec:   g.idCodec,
+	}
+	for _, p := range points {
+		if p.Location != nil {
+			header.Bounds = extend(header.Bounds, *p.Location)
+		}
+	}
+	headerData, err := json.Marshal(header)
+	if err != nil {
+		return fmt.Errorf("failed to encode header: %w", err)
 	}
 
 	file, err := os.Create(filename)
@@ -43,33 +134,247 @@ func (g *GeoIndex) SaveToFile(filename string) error {
 	}
 	defer file.Close()
 
-	encoder := gob.NewEncoder(file)
-	if err := encoder.Encode(data); err != nil {
-		return fmt.Errorf("failed to encode data: %w", err)
+	w := bufio.NewWriter(file)
+	w.WriteString(fileMagic)
+	binary.Write(w, binary.LittleEndian, uint32(len(headerData)))
+	w.Write(headerData)
+	body.WriteTo(w)
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
 	}
 
-	return nil
+	return file.Close()
 }
 
-// LoadFromFile loads the index from a binary file
+// LoadFromFile replaces the index contents with the points of a binary file
+// in one swap; on error the index is left as it was
 func (g *GeoIndex) LoadFromFile(filename string) error {
-	file, err := os.Open(filename)
+	start := time.Now()
+	points, err := ReadPoints(filename)
 	if err != nil {
-		return fmt.Errorf("failed to open file: %w", err)
+		g.logger().Error("index load failed", "file", filename, "error", err)
+		return err
+	}
+
+	// Build the loaded index beside the current one and swap it in whole, so
+	// queries never see it empty or half loaded
+	next := g.stateFrom(points, g.newProgressReporter(start))
+	g.writeMu.Lock()
+	unlock := g.lockPartitions(g.allPartitions())
+	g.state.Store(next)
+	g.cache.purge()
+	unlock()
+	g.writeMu.Unlock()
+	g.metricsSink().SetPointCount(g.Count())
+
+	g.logger().Info("index loaded", "file", filename, "points", g.Count(), "duration", time.Since(start))
+	return nil
+}
+
+// ReadPoints decodes the points stored in an index file without building an
+// index. The body checksum is verified for files that have a header. Files
+// written by the deprecated pkg/geo index are read too.
+func ReadPoints(filename string) ([]*models.Point, error) {
+	file, err := openIndexFile(filename)
+	if err != nil {
+		return nil, err
 	}
 	defer file.Close()
 
+	r := bufio.NewReader(file)
+	header, err := readHeader(r)
+	if err != nil && !errors.Is(err, ErrNoHeader) {
+		return nil, err
+	}
+
+	var body io.Reader = r
+	digest := newBodyDigest()
+	if header != nil {
+		body = io.TeeReader(io.LimitReader(r, header.BodySize), digest)
+	}
+
 	var data IndexData
-	decoder := gob.NewDecoder(file)
+	decoder := gob.NewDecoder(body)
 	if err := decoder.Decode(&data); err != nil {
-		return fmt.Errorf("failed to decode data: %w", err)
+		if header == nil {
+			if points, legacyErr := readGeoPoints(file); legacyErr == nil {
+				return points, nil
+			}
+		}
+		return nil, fmt.Errorf("%w: failed to decode data: %w", ErrCorruptFile, err)
+	}
+
+	if header != nil {
+		if err := checkBody(body, digest, header); err != nil {
+			return nil, err
+		}
+		if header.IDCodec != nil && data.IDs != nil {
+			if err := decodeIDs(data.Points, data.IDs, *header.IDCodec); err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	// Clear existing index and rebuild
-	g.Clear()
-	if err := g.IndexPoints(data.Points); err != nil {
-		return fmt.Errorf("failed to index points: %w", err)
+	return data.Points, nil
+}
+
+// encodeIDs returns copies of points with the IDs codec matches moved into
+// the returned numbers, leaving the originals untouched
+func encodeIDs(points []*models.Point, codec IDCodec) ([]*models.Point, []uint64) {
+	encoded := make([]*models.Point, len(points))
+	ids := make([]uint64, len(points))
+	for i, p := range points {
+		n, ok := codec.Encode(p.ID)
+		if !ok {
+			encoded[i], ids[i] = p, noID
+			continue
+		}
+		copied := *p
+		copied.ID = ""
+		encoded[i], ids[i] = &copied, n
 	}
+	return encoded, ids
+}
 
+// decodeIDs restores the IDs removed by encodeIDs in place
+func decodeIDs(points []*models.Point, ids []uint64, codec IDCodec) error {
+	if len(ids) != len(points) {
+		return fmt.Errorf("%w: %d encoded IDs for %d points", ErrCorruptFile, len(ids), len(points))
+	}
+	for i, n := range ids {
+		if n != noID {
+			points[i].ID = codec.Decode(n)
+		}
+	}
 	return nil
-}
\ No newline at end of file
+}
+
+// geoPoint is the point type written by the deprecated pkg/geo index, whose
+// files hold a bare gob-encoded []*geoPoint
+type geoPoint struct {
+	ID  string
+	Lat float64
+	Lon float64
+}
+
+// readGeoPoints decodes a pkg/geo index file from its start
+func readGeoPoints(file *os.File) ([]*models.Point, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var legacy []*geoPoint
+	if err := gob.NewDecoder(bufio.NewReader(file)).Decode(&legacy); err != nil {
+		return nil, err
+	}
+
+	points := make([]*models.Point, 0, len(legacy))
+	for _, p := range legacy {
+		if p != nil {
+			points = append(points, &models.Point{ID: p.ID, Location: &models.Location{Lat: p.Lat, Lon: p.Lon}})
+		}
+	}
+	return points, nil
+}
+
+// ReadHeader reads the metadata block of an index file without loading its
+// points. It returns ErrNoHeader for files written without one.
+func ReadHeader(filename string) (*FileHeader, error) {
+	file, err := openIndexFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return readHeader(bufio.NewReader(file))
+}
+
+// VerifyFile checks the size and checksum of an index file's body against its
+// header by streaming the raw bytes, without decoding any points
+func VerifyFile(filename string) error {
+	file, err := openIndexFile(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	header, err := readHeader(r)
+	if err != nil {
+		return err
+	}
+
+	digest := newBodyDigest()
+	return checkBody(io.TeeReader(io.LimitReader(r, header.BodySize), digest), digest, header)
+}
+
+// openIndexFile opens filename, reporting a missing file as ErrNotFound
+func openIndexFile(filename string) (*os.File, error) {
+	file, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: failed to open file: %w", ErrNotFound, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	return file, nil
+}
+
+// readHeader consumes the magic and header from r, or leaves r untouched and
+// returns ErrNoHeader if the file does not start with the magic
+func readHeader(r *bufio.Reader) (*FileHeader, error) {
+	magic, err := r.Peek(len(fileMagic))
+	if err != nil || string(magic) != fileMagic {
+		return nil, ErrNoHeader
+	}
+	r.Discard(len(fileMagic))
+
+	var size uint32
+	if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+		return nil, fmt.Errorf("%w: failed to read header: %w", ErrCorruptFile, err)
+	}
+	if size > maxHeaderSize {
+		return nil, fmt.Errorf("%w: header of %d bytes exceeds the %d byte limit", ErrCorruptFile, size, maxHeaderSize)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("%w: failed to read header: %w", ErrCorruptFile, err)
+	}
+
+	var header FileHeader
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, fmt.Errorf("%w: failed to decode header: %w", ErrCorruptFile, err)
+	}
+	if header.Version > FileFormatVersion {
+		return nil, fmt.Errorf("file format version %d is newer than supported version %d", header.Version, FileFormatVersion)
+	}
+	return &header, nil
+}
+
+// bodyDigest checksums and counts the body bytes passing through it
+type bodyDigest struct {
+	sum hash.Hash32
+	n   int64
+}
+
+func newBodyDigest() *bodyDigest {
+	return &bodyDigest{sum: crc32.New(crcTable)}
+}
+
+func (d *bodyDigest) Write(p []byte) (int, error) {
+	d.n += int64(len(p))
+	return d.sum.Write(p)
+}
+
+// checkBody drains what is left of the body and compares it with the header
+func checkBody(body io.Reader, digest *bodyDigest, header *FileHeader) error {
+	if _, err := io.Copy(io.Discard, body); err != nil {
+		return fmt.Errorf("failed to read body: %w", err)
+	}
+	if digest.n != header.BodySize {
+		return fmt.Errorf("%w: body is %d bytes, header says %d", ErrCorruptFile, digest.n, header.BodySize)
+	}
+	if sum := digest.sum.Sum32(); sum != header.Checksum {
+		return fmt.Errorf("%w: checksum %08x, header says %08x", ErrCorruptFile, sum, header.Checksum)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package rtree
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/dhconnelly/rtreego"
+)
+
+// PolygonIndex stores polygons such as geofences, zones and delivery areas and
+// answers which of them contain a location, the inverse of GeoIndex. Polygon
+// bounding boxes are kept in R-trees that give the candidates, which are then
+// checked exactly. Like GeoIndex, reads take no lock and see each
+// IndexPolygons call entirely or not at all.
+type PolygonIndex struct {
+	state   atomic.Pointer[polygonState]
+	writeMu sync.Mutex
+}
+
+// polygonState is an immutable snapshot of the polygons, merged the same way
+// as partition trees
+type polygonState struct {
+	trees []*rtreego.Rtree
+	count int
+}
+
+// spatialPolygon stores a polygon in the tree under its bounding box
+type spatialPolygon struct {
+	polygon *models.Polygon
+	rect    *rtreego.Rect
+}
+
+func (sp *spatialPolygon) Bounds() *rtreego.Rect {
+	return sp.rect
+}
+
+// NewPolygonIndex creates an empty polygon index
+func NewPolygonIndex() *PolygonIndex {
+	p := &PolygonIndex{}
+	p.state.Store(&polygonState{})
+	return p
+}
+
+// IndexPolygons adds polygons to the index. Nothing is added if any polygon
+// is invalid.
+func (p *PolygonIndex) IndexPolygons(polygons []*models.Polygon) error {
+	items := make([]rtreego.Spatial, 0, len(polygons))
+	for i, polygon := range polygons {
+		if err := polygon.Validate(); err != nil {
+			return fmt.Errorf("polygon %d (%q): %w", i, polygon.ID, err)
+		}
+		rect, err := searchRect(polygon.Bounds())
+		if err != nil {
+			return fmt.Errorf("failed to create rect for polygon %q: %w", polygon.ID, err)
+		}
+		items = append(items, &spatialPolygon{polygon: polygon, rect: rect})
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	old := p.state.Load()
+	trees := append(slices.Clone(old.trees), rtreego.NewTree(dimensions, minChildren, maxChildren, items...))
+	for n := len(trees); n > 1 && trees[n-2].Size() <= 2*trees[n-1].Size(); n = len(trees) {
+		merged := append(trees[n-2].SearchIntersect(everything), trees[n-1].SearchIntersect(everything)...)
+		trees = append(trees[:n-2], rtreego.NewTree(dimensions, minChildren, maxChildren, merged...))
+	}
+	p.state.Store(&polygonState{trees: trees, count: old.count + len(items)})
+	return nil
+}
+
+// ContainingPolygons returns the polygons that contain loc, on their boundary
+// included, sorted by ID
+func (p *PolygonIndex) ContainingPolygons(loc models.Location) []*models.Polygon {
+	state := p.state.Load()
+	bounds, err := searchRect(models.BoundingBox{BottomLeft: loc, TopRight: loc})
+	if err != nil {
+		return nil
+	}
+
+	var results []*models.Polygon
+	for _, tree := range state.trees {
+		for _, item := range tree.SearchIntersect(bounds) {
+			sp, ok := item.(*spatialPolygon)
+			if ok && sp.polygon.Contains(loc) {
+				results = append(results, sp.polygon)
+			}
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].ID < results[j].ID
+	})
+	return results
+}
+
+// Count returns the number of indexed polygons
+func (p *PolygonIndex) Count() int {
+	return p.state.Load().count
+}
+
+// Clear removes all polygons from the index
+func (p *PolygonIndex) Clear() {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	p.state.Store(&polygonState{})
+}
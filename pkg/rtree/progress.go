@@ -0,0 +1,94 @@
+package rtree
+
+import (
+	"sync"
+	"time"
+)
+
+// Stages of an IndexPoints call reported to the WithProgress callback
+const (
+	// StagePartitioning is the assignment of points to partitions
+	StagePartitioning = "partitioning"
+	// StageBuilding is the construction of the trees or other storage
+	StageBuilding = "building"
+)
+
+// progressEvery is the number of points partitioned between progress reports
+const progressEvery = 1 << 16
+
+// Progress reports how far an IndexPoints call has got
+type Progress struct {
+	// Stage is StagePartitioning or StageBuilding
+	Stage string
+	// Done is the number of points of the call handled in the stage so far,
+	// out of Total
+	Done, Total int
+	// Partitions and PartitionsDone count the partitions being built in the
+	// building stage of partitioned storage; other storage reports zero
+	Partitions, PartitionsDone int
+	// Elapsed is the time since the call started
+	Elapsed time.Duration
+}
+
+// WithProgress calls fn as IndexPoints, and LoadFromFile through it, works
+// through a batch: every 65,536 points while points are assigned to
+// partitions and at the end of that stage, then as each partition's tree is
+// built. Storage other than partitions reports only the end of the building
+// stage. Calls for one batch never overlap, but batches indexed
+// concurrently report concurrently, so fn must be safe for concurrent use
+// then. fn runs on the indexing goroutines and should be quick.
+func WithProgress(fn func(p Progress)) Option {
+	return func(g *GeoIndex) {
+		g.progress = fn
+	}
+}
+
+// progressReporter serializes the progress reports of one IndexPoints call
+type progressReporter struct {
+	fn    func(p Progress)
+	start time.Time
+
+	mu         sync.Mutex
+	builtParts int
+	builtItems int
+}
+
+// newProgressReporter returns a reporter for a call starting at start, nil
+// without WithProgress
+func (g *GeoIndex) newProgressReporter(start time.Time) *progressReporter {
+	if g.progress == nil {
+		return nil
+	}
+	return &progressReporter{fn: g.progress, start: start}
+}
+
+// report calls the callback with p, if there is one
+func (r *progressReporter) report(p Progress) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p.Elapsed = time.Since(r.start)
+	r.fn(p)
+}
+
+// built records a partition of n points as built and reports the points and
+// partitions built so far out of total and partitions
+func (r *progressReporter) built(n, total, partitions int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.builtParts++
+	r.builtItems += n
+	r.fn(Progress{
+		Stage:          StageBuilding,
+		Done:           r.builtItems,
+		Total:          total,
+		Partitions:     partitions,
+		PartitionsDone: r.builtParts,
+		Elapsed:        time.Since(r.start),
+	})
+}
@@ -0,0 +1,292 @@
+package rtree
+
+import (
+	"math"
+	"slices"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+)
+
+const (
+	// quadLeafSize is the number of points a quadtree leaf holds before it
+	// splits into four
+	quadLeafSize = 32
+	// quadMaxDepth stops splitting leaves of coincident points; at this depth
+	// a node is about 2cm across
+	quadMaxDepth = 30
+	// quadBytesPerPoint approximates the fixed heap cost of one point in a
+	// quadtree: the Point and Location structs, its leaf slot and its entry in
+	// the ID set
+	quadBytesPerPoint = 112
+	// quadBytesPerNode approximates the size of one node and its child array
+	quadBytesPerNode = 120
+)
+
+// WithQuadtreeStorage keeps points in a single point quadtree over the whole
+// globe instead of partitioned R-trees. Nodes split into equal quadrants, so
+// inserts only rebuild the path to the leaves they touch and nothing is
+// rebalanced, which makes it a lighter option for small and medium datasets
+// and a baseline for comparing structures. Queries behave the same but search
+// the one tree rather than partitions in parallel.
+func WithQuadtreeStorage() Option {
+	return func(g *GeoIndex) {
+		g.storage = quadtreeStorage
+	}
+}
+
+// quadtree is an immutable point quadtree: add returns a new tree sharing the
+// subtrees it did not touch
+type quadtree struct {
+	root              *quadNode
+	ids               idBatches
+	falsePositiveRate float64
+}
+
+// quadNode is a leaf holding points or an inner node with four children,
+// split at the middle of its region
+type quadNode struct {
+	region   models.BoundingBox
+	children *[4]*quadNode
+	points   []*models.Point
+}
+
+var quadWorld = models.BoundingBox{
+	BottomLeft: models.Location{Lat: -90, Lon: -180},
+	TopRight:   models.Location{Lat: 90, Lon: 180},
+}
+
+func newQuadtree(falsePositiveRate float64) *quadtree {
+	return &quadtree{root: &quadNode{region: quadWorld}, falsePositiveRate: falsePositiveRate}
+}
+
+// add returns a tree holding q's points and those of points that have a
+// location, and the number added
+func (q *quadtree) add(g *GeoIndex, points []*models.Point) (store, int) {
+	batch := make([]*models.Point, 0, len(points))
+	ids := make([]string, 0, len(points))
+	for _, p := range points {
+		if p.Location == nil {
+			continue
+		}
+		if g.wrapLongitudes {
+			p = wrapPoint(p)
+		}
+		batch = append(batch, p)
+		ids = append(ids, p.ID)
+	}
+	if len(batch) == 0 {
+		return q, 0
+	}
+	return &quadtree{
+		root:              q.root.with(batch, 0),
+		ids:               q.ids.with(ids, q.falsePositiveRate),
+		falsePositiveRate: q.falsePositiveRate,
+	}, len(batch)
+}
+
+// with returns a copy of n holding points as well, splitting leaves that
+// overflow
+func (n *quadNode) with(points []*models.Point, depth int) *quadNode {
+	if n.children == nil {
+		merged := append(n.points[:len(n.points):len(n.points)], points...)
+		if len(merged) <= quadLeafSize || depth >= quadMaxDepth {
+			return &quadNode{region: n.region, points: merged}
+		}
+		return (&quadNode{region: n.region, children: n.split()}).with(merged, depth)
+	}
+
+	var quadrants [4][]*models.Point
+	for _, p := range points {
+		i := n.quadrant(*p.Location)
+		quadrants[i] = append(quadrants[i], p)
+	}
+	children := *n.children
+	for i, qp := range quadrants {
+		if len(qp) > 0 {
+			children[i] = children[i].with(qp, depth+1)
+		}
+	}
+	return &quadNode{region: n.region, children: &children}
+}
+
+// without returns a tree holding q's points except those with an ID in
+// remove, and the number left out. Leaves emptied stay in place.
+func (q *quadtree) without(g *GeoIndex, remove map[string]bool) (store, int) {
+	root, removed := q.root.without(remove)
+	if removed == 0 {
+		return q, 0
+	}
+	var ids []string
+	root.search(quadWorld, func(p *models.Point) { ids = append(ids, p.ID) })
+	return &quadtree{
+		root:              root,
+		ids:               idBatches(nil).with(ids, q.falsePositiveRate),
+		falsePositiveRate: q.falsePositiveRate,
+	}, removed
+}
+
+// without returns n, or a copy of it without the points with an ID in remove,
+// and the number left out. Subtrees holding none of them are shared.
+func (n *quadNode) without(remove map[string]bool) (*quadNode, int) {
+	if n.children == nil {
+		kept := slices.DeleteFunc(slices.Clone(n.points), func(p *models.Point) bool { return remove[p.ID] })
+		if len(kept) == len(n.points) {
+			return n, 0
+		}
+		return &quadNode{region: n.region, points: kept}, len(n.points) - len(kept)
+	}
+
+	children := *n.children
+	removed := 0
+	for i, child := range children {
+		var r int
+		children[i], r = child.without(remove)
+		removed += r
+	}
+	if removed == 0 {
+		return n, 0
+	}
+	return &quadNode{region: n.region, children: &children}, removed
+}
+
+// split returns four empty leaves covering n's region
+func (n *quadNode) split() *[4]*quadNode {
+	midLat, midLon := n.mid()
+	bl, tr := n.region.BottomLeft, n.region.TopRight
+	return &[4]*quadNode{
+		{region: models.BoundingBox{BottomLeft: bl, TopRight: models.Location{Lat: midLat, Lon: midLon}}},
+		{region: models.BoundingBox{BottomLeft: models.Location{Lat: bl.Lat, Lon: midLon}, TopRight: models.Location{Lat: midLat, Lon: tr.Lon}}},
+		{region: models.BoundingBox{BottomLeft: models.Location{Lat: midLat, Lon: bl.Lon}, TopRight: models.Location{Lat: tr.Lat, Lon: midLon}}},
+		{region: models.BoundingBox{BottomLeft: models.Location{Lat: midLat, Lon: midLon}, TopRight: tr}},
+	}
+}
+
+func (n *quadNode) mid() (lat, lon float64) {
+	return (n.region.BottomLeft.Lat + n.region.TopRight.Lat) / 2, (n.region.BottomLeft.Lon + n.region.TopRight.Lon) / 2
+}
+
+// quadrant returns the index of the child whose region holds loc: bit 0 for
+// the eastern half, bit 1 for the northern one. Points on the middle lines go
+// east and north; points outside the region, such as unwrapped longitudes,
+// go to the nearest child.
+func (n *quadNode) quadrant(loc models.Location) int {
+	midLat, midLon := n.mid()
+	i := 0
+	if loc.Lon >= midLon {
+		i |= 1
+	}
+	if loc.Lat >= midLat {
+		i |= 2
+	}
+	return i
+}
+
+// search calls fn with every point in the leaves overlapping box, a superset
+// of the points inside it
+func (n *quadNode) search(box models.BoundingBox, fn func(p *models.Point)) {
+	if n.children == nil {
+		for _, p := range n.points {
+			fn(p)
+		}
+		return
+	}
+	midLat, midLon := n.mid()
+	for i, child := range n.children {
+		if i&1 == 0 && box.BottomLeft.Lon >= midLon || i&1 == 1 && box.TopRight.Lon < midLon ||
+			i&2 == 0 && box.BottomLeft.Lat >= midLat || i&2 == 2 && box.TopRight.Lat < midLat {
+			continue
+		}
+		child.search(box, fn)
+	}
+}
+
+func (q *quadtree) count() int {
+	n := 0
+	q.root.search(quadWorld, func(*models.Point) { n++ })
+	return n
+}
+
+func (q *quadtree) contains(id string) bool {
+	return q.ids.contains(id)
+}
+
+// box is queryBox for quadtree storage
+func (q *quadtree) box(g *GeoIndex, box models.BoundingBox) []*models.Point {
+	var points []*models.Point
+	q.root.search(box, func(p *models.Point) {
+		if g.inBox(box, *p.Location) {
+			points = append(points, p)
+		}
+	})
+	return points
+}
+
+// within returns the points of quadtree storage within radiusKm of center
+func (q *quadtree) within(g *GeoIndex, center models.Location, radiusKm float64) []models.PointDistance {
+	var results []models.PointDistance
+	for _, box := range g.radiusBoxes(center, radiusKm) {
+		q.root.search(box, func(p *models.Point) {
+			if !box.Contains(*p.Location) {
+				return
+			}
+			if dist := center.DistanceTo(*p.Location); dist <= radiusKm {
+				results = append(results, g.pointDistance(p, dist))
+			}
+		})
+	}
+	return results
+}
+
+// nearest returns the n points nearest to center in quadtree storage by
+// widening a radius search until it holds n points or covers the globe
+func (q *quadtree) nearest(g *GeoIndex, center models.Location, n int) []models.PointDistance {
+	if n <= 0 {
+		return nil
+	}
+	var results []models.PointDistance
+	for radiusKm := float64(compactStartKm); ; radiusKm *= 4 {
+		results = q.within(g, center, radiusKm)
+		if len(results) >= n || radiusKm >= math.Pi*earthRadius {
+			break
+		}
+	}
+	sortByDistance(results)
+	if len(results) > n {
+		results = results[:n]
+	}
+	return results
+}
+
+// stats is Stats for quadtree storage, reported as a single partition
+func (q *quadtree) stats() IndexStats {
+	ps := PartitionStats{Region: quadWorld}
+	var bytes int64
+	var walk func(n *quadNode, depth int)
+	walk = func(n *quadNode, depth int) {
+		bytes += quadBytesPerNode
+		ps.Depth = max(ps.Depth, depth)
+		if n.children != nil {
+			for _, child := range n.children {
+				walk(child, depth+1)
+			}
+			return
+		}
+		ps.Count += len(n.points)
+		for _, p := range n.points {
+			ps.Bounds = extend(ps.Bounds, *p.Location)
+			bytes += quadBytesPerPoint + int64(len(p.ID))
+		}
+	}
+	walk(q.root, 1)
+
+	stats := IndexStats{
+		Count:          int64(ps.Count),
+		Partitions:     []PartitionStats{ps},
+		EstimatedBytes: bytes,
+	}
+	if ps.Bounds != nil {
+		bounds := *ps.Bounds
+		stats.Bounds = &bounds
+	}
+	return stats
+}
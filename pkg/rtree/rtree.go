@@ -3,17 +3,28 @@
 package rtree
 
 import (
+	"context"
+	"fmt"
+	"log/slog"
 	"math"
 	"runtime"
+	"slices"
+	"sort"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/dhconnelly/rtreego"
 	"github.com/1F47E/geo-index-rtree/pkg/models"
 )
 
 const (
-	tolerance    = 0.01
+	// DefaultTolerance is the half-width in degrees of the rect each point is
+	// stored as, unless changed with WithTolerance
+	DefaultTolerance = 1e-9
+	// searchPadding widens query rects so zero-width boxes and radii still
+	// form a valid rect; the exact filters drop anything it lets in
+	searchPadding = 1e-9
 	minChildren  = 25
 	maxChildren  = 50
 	dimensions   = 2
@@ -24,35 +35,186 @@ const (
 type spatialPoint struct {
 	*models.Point
 	rect *rtreego.Rect
+	// trig caches the radian coordinates for distance filtering
+	trig trigLocation
+}
+
+// trigLocation is a location in radians with the cosine of its latitude, so
+// haversine distances to it need no conversions and one fewer cosine
+type trigLocation struct {
+	lat, lon, cosLat float64
+}
+
+func newTrigLocation(loc models.Location) trigLocation {
+	lat := loc.Lat * math.Pi / 180
+	return trigLocation{lat: lat, lon: loc.Lon * math.Pi / 180, cosLat: math.Cos(lat)}
+}
+
+// distanceKm returns the haversine distance to other, as Location.DistanceTo
+func (t trigLocation) distanceKm(other trigLocation) float64 {
+	sinLat := math.Sin((other.lat - t.lat) / 2)
+	sinLon := math.Sin((other.lon - t.lon) / 2)
+	a := sinLat*sinLat + t.cosLat*other.cosLat*sinLon*sinLon
+	return earthRadius * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
 }
 
 func (sp *spatialPoint) Bounds() *rtreego.Rect {
 	return sp.rect
 }
 
-// GeoIndex represents a thread-safe R-Tree based geographic index
+// GeoIndex represents a thread-safe R-Tree based geographic index.
+//
+// Queries never take a lock: they load the current state, an immutable view
+// of the whole index, and search it for as long as they need. Writers build
+// the parts they change beside it and publish a new state with a
+// compare-and-swap, so queries see each write entirely or not at all. With
+// partition storage, IndexPoints, Insert and Delete lock only the partitions
+// they change, so writes to different longitude bands run in parallel; the
+// other storage layouts are single structures whose writers take turns.
+// Clear waits for every writer. A replaced state is reclaimed by the garbage
+// collector once the last query or Snapshot holding it is done, which is
+// what epoch-based reclamation does by hand.
 type GeoIndex struct {
-	// Partitioned trees for parallel query execution
-	partitions []*rtreego.Rtree
-	numCPU     int
-	mu         sync.RWMutex
-	itemCount  atomic.Int64
+	// state holds the partitioned trees for parallel query execution. Readers
+	// load it without locking; writers lock partMu for the partitions they
+	// change, or writeMu for other storage, and swap it.
+	state   atomic.Pointer[indexState]
+	writeMu sync.Mutex
+	// partMu holds one lock per partition
+	partMu []sync.Mutex
+	numCPU  int
 	
 	// Partition bounds for efficient query routing
 	partitionBounds []models.BoundingBox
+
+	// wrapLongitudes normalizes longitudes into [-180, 180) on insert and query
+	wrapLongitudes bool
+	// boxEdges controls whether QueryBox includes points on the box edges
+	boxEdges BoxEdges
+	// stableOrder sorts query results so repeated queries return the same order
+	stableOrder bool
+	// tolerance is the half-width in degrees of each point's rect in the tree
+	tolerance float64
+	// unit is the unit of radii passed in and distances returned
+	unit models.Unit
+	// storage is the layout points are kept in, set by the storage options
+	storage storageMode
+	// geohashPrecision is the cell size in characters of geohash storage
+	geohashPrecision int
+	// gridCellDeg is the cell size in degrees of grid storage, zero to pick
+	// one from the first batch
+	gridCellDeg float64
+	// timeField is the property holding the time of space-time storage
+	timeField string
+	// idCodec stores matching IDs as numbers in compact storage and files
+	idCodec *IDCodec
+	// cache holds recent box and radius query results, nil unless enabled
+	cache *queryCache
+	// idFalsePositives is the bloom filter error rate behind Contains, zero
+	// for exact ID sets
+	idFalsePositives float64
+	// profilerLabels sets pprof labels on query goroutines
+	profilerLabels bool
+	// altitude makes radius and nearest-neighbor queries use 3D distance
+	altitude bool
+	// verifier checks queries against a linear scan, nil unless enabled
+	verifier *verifier
+	// slowLog reports slow queries, nil unless enabled
+	slowLog *slowLog
+	// log receives structured logs, nil unless set with WithLogger
+	log *slog.Logger
+	// metrics receives query and point count measurements, nil unless set
+	// with WithMetrics
+	metrics Metrics
+	// progress receives IndexPoints progress, nil unless set with
+	// WithProgress
+	progress func(p Progress)
+}
+
+// BoxEdges selects which box edges QueryBox treats as inside the box
+type BoxEdges int
+
+const (
+	// EdgesClosed includes points on every edge of the box. This is the default.
+	EdgesClosed BoxEdges = iota
+	// EdgesHalfOpen includes points on the bottom and left edges but not the
+	// top and right ones, so adjacent tiles never both return a point on their
+	// shared border. Top and right edges at latitude 90 and longitude 180 stay
+	// inclusive, as no tile lies beyond them.
+	EdgesHalfOpen
+)
+
+// Option configures a GeoIndex
+type Option func(*GeoIndex)
+
+// WithLongitudeWrap normalizes longitudes into [-180, 180) on insert and query,
+// for data sources that deliver 0-360 longitudes. Points needing it are copied
+// with the wrapped location, and query boxes and the boxes around radius
+// searches crossing the antimeridian are split.
+func WithLongitudeWrap() Option {
+	return func(g *GeoIndex) {
+		g.wrapLongitudes = true
+	}
+}
+
+// WithBoxEdges sets which box edges QueryBox includes; see BoxEdges
+func WithBoxEdges(edges BoxEdges) Option {
+	return func(g *GeoIndex) {
+		g.boxEdges = edges
+	}
+}
+
+// WithStableOrder makes query results deterministic: QueryBox sorts by ID and
+// radius and nearest-neighbor queries sort by distance, breaking ties by ID.
+// Without it, QueryBox and QueryRadius return points in the order partitions
+// finish searching.
+func WithStableOrder() Option {
+	return func(g *GeoIndex) {
+		g.stableOrder = true
+	}
+}
+
+// WithTolerance sets the half-width in degrees of the rect each point is
+// stored as in the tree. Box and radius results are exact at any tolerance,
+// since candidates are filtered by their actual location; larger values only
+// add candidates to filter and make nearest-neighbor candidate selection
+// coarser. Negative values are treated as zero.
+func WithTolerance(degrees float64) Option {
+	return func(g *GeoIndex) {
+		g.tolerance = math.Max(degrees, 0)
+	}
+}
+
+// WithDistanceUnit sets the unit of radii passed to radius queries and of
+// distances returned in PointDistance.Distance. The default is kilometers.
+// Unknown units make radius queries fail with ErrInvalidQuery.
+func WithDistanceUnit(unit models.Unit) Option {
+	return func(g *GeoIndex) {
+		g.unit = unit
+	}
+}
+
+// WithAltitude makes radius and nearest-neighbor queries measure the 3D
+// distance of Location.Distance3DTo, so points above or below the query
+// center's altitude are farther than their surface distance. Points are still
+// stored and searched in two dimensions: radius queries filter the surface
+// circle by 3D distance, and nearest-neighbor queries widen to the surface
+// radius that must hold the n nearest points in 3D. Box queries and the
+// aggregates ignore altitude.
+func WithAltitude() Option {
+	return func(g *GeoIndex) {
+		g.altitude = true
+	}
 }
 
 // NewGeoIndex creates a new geographic index with CPU-aware partitioning
-func NewGeoIndex() *GeoIndex {
+func NewGeoIndex(opts ...Option) *GeoIndex {
 	numCPU := runtime.NumCPU()
-	partitions := make([]*rtreego.Rtree, numCPU)
 	partitionBounds := make([]models.BoundingBox, numCPU)
 	
 	// Create partitions based on longitude bands
 	lonRange := 360.0 / float64(numCPU)
 	for i := 0; i < numCPU; i++ {
-		partitions[i] = rtreego.NewTree(dimensions, minChildren, maxChildren)
-		
 		// Calculate partition bounds
 		minLon := -180.0 + float64(i)*lonRange
 		maxLon := minLon + lonRange
@@ -66,27 +228,31 @@ func NewGeoIndex() *GeoIndex {
 		}
 	}
 	
-	return &GeoIndex{
-		partitions:      partitions,
+	g := &GeoIndex{
 		numCPU:          numCPU,
+		partMu:          make([]sync.Mutex, numCPU),
 		partitionBounds: partitionBounds,
+		tolerance:       DefaultTolerance,
+		unit:            models.Kilometers,
+	}
+	for _, opt := range opts {
+		opt(g)
 	}
+	g.state.Store(g.emptyState())
+	return g
 }
 
 // NewGeoIndexWithWorkers creates a new geographic index with specified partition count
-func NewGeoIndexWithWorkers(numPartitions int) *GeoIndex {
+func NewGeoIndexWithWorkers(numPartitions int, opts ...Option) *GeoIndex {
 	if numPartitions <= 0 {
 		numPartitions = runtime.NumCPU()
 	}
 	
-	partitions := make([]*rtreego.Rtree, numPartitions)
 	partitionBounds := make([]models.BoundingBox, numPartitions)
 	
 	// Create partitions based on longitude bands
 	lonRange := 360.0 / float64(numPartitions)
 	for i := 0; i < numPartitions; i++ {
-		partitions[i] = rtreego.NewTree(dimensions, minChildren, maxChildren)
-		
 		// Calculate partition bounds
 		minLon := -180.0 + float64(i)*lonRange
 		maxLon := minLon + lonRange
@@ -100,11 +266,18 @@ func NewGeoIndexWithWorkers(numPartitions int) *GeoIndex {
 		}
 	}
 	
-	return &GeoIndex{
-		partitions:      partitions,
+	g := &GeoIndex{
 		numCPU:          numPartitions,
+		partMu:          make([]sync.Mutex, numPartitions),
 		partitionBounds: partitionBounds,
+		tolerance:       DefaultTolerance,
+		unit:            models.Kilometers,
+	}
+	for _, opt := range opts {
+		opt(g)
 	}
+	g.state.Store(g.emptyState())
+	return g
 }
 
 // IndexPoints indexes multiple points using spatial partitioning
@@ -112,27 +285,76 @@ func (g *GeoIndex) IndexPoints(points []*models.Point) error {
 	if len(points) == 0 {
 		return nil
 	}
+	start := time.Now()
+	defer func() {
+		g.metricsSink().SetPointCount(g.Count())
+		g.logger().Debug("points indexed", "batch", len(points), "count", g.Count(), "duration", time.Since(start))
+	}()
+	progress := g.newProgressReporter(start)
+	if g.storage != partitionStorage {
+		defer progress.report(Progress{Stage: StageBuilding, Done: len(points), Total: len(points)})
+		g.writeMu.Lock()
+		defer g.writeMu.Unlock()
+		old := g.state.Load()
+		next, added := old.store.add(g, points)
+		g.state.Store(&indexState{store: next, count: old.count + int64(added), shadow: g.shadowed(old, points)})
+		g.cache.purge()
+		return nil
+	}
+
+	partitionedPoints := g.groupByPartition(points, progress)
+	
+	// Lock the partitions receiving points, build them in parallel and swap
+	// them in; writers to other partitions carry on meanwhile
+	var touched []int
+	added := 0
+	for i, items := range partitionedPoints {
+		if len(items) > 0 {
+			touched = append(touched, i)
+			added += len(items)
+		}
+	}
+	unlock := g.lockPartitions(touched)
+	defer unlock()
+	built := g.buildPartitions(g.state.Load(), partitionedPoints, touched, progress)
+	g.publish(func(cur *indexState) *indexState {
+		next := &indexState{partitions: slices.Clone(cur.partitions), count: cur.count + int64(added), shadow: g.shadowed(cur, points)}
+		for _, i := range touched {
+			next.partitions[i] = built[i]
+		}
+		return next
+	})
+	return nil
+}
 
-	// Group points by partition
-	partitionedPoints := make([][]*spatialPoint, g.numCPU)
+// groupByPartition returns the spatial items of the points that have a
+// location, grouped by the longitude band of their partition
+func (g *GeoIndex) groupByPartition(points []*models.Point, progress *progressReporter) [][]rtreego.Spatial {
+	partitionedPoints := make([][]rtreego.Spatial, g.numCPU)
 	for i := range partitionedPoints {
-		partitionedPoints[i] = make([]*spatialPoint, 0, len(points)/g.numCPU)
+		partitionedPoints[i] = make([]rtreego.Spatial, 0, len(points)/g.numCPU)
 	}
 	
 	// Distribute points to partitions based on longitude
 	lonRange := 360.0 / float64(g.numCPU)
-	for _, point := range points {
+	for i, point := range points {
+		if i > 0 && i%progressEvery == 0 {
+			progress.report(Progress{Stage: StagePartitioning, Done: i, Total: len(points)})
+		}
 		if point.Location == nil {
 			continue
 		}
+		if g.wrapLongitudes {
+			point = wrapPoint(point)
+		}
 		
 		// Create spatial point
 		p := rtreego.Point{
 			point.Location.Lat,
 			point.Location.Lon,
 		}
-		rect := p.ToRect(tolerance)
-		spatialPoint := &spatialPoint{point, rect}
+		rect := p.ToRect(g.tolerance)
+		spatialPoint := &spatialPoint{point, rect, newTrigLocation(*point.Location)}
 		
 		// Determine partition based on longitude
 		partitionIdx := int((point.Location.Lon + 180.0) / lonRange)
@@ -145,43 +367,131 @@ func (g *GeoIndex) IndexPoints(points []*models.Point) error {
 		
 		partitionedPoints[partitionIdx] = append(partitionedPoints[partitionIdx], spatialPoint)
 	}
-	
-	// Insert into partitions in parallel
-	g.mu.Lock()
-	defer g.mu.Unlock()
+	progress.report(Progress{Stage: StagePartitioning, Done: len(points), Total: len(points)})
+	return partitionedPoints
+}
+
+// buildPartitions returns the partitions of old at touched with the grouped
+// items added, built in parallel, and nil elsewhere
+func (g *GeoIndex) buildPartitions(old *indexState, partitionedPoints [][]rtreego.Spatial, touched []int, progress *progressReporter) []*partition {
+	added := 0
+	for _, i := range touched {
+		added += len(partitionedPoints[i])
+	}
+	built := make([]*partition, g.numCPU)
 	
 	var wg sync.WaitGroup
-	var totalInserted atomic.Int64
 	
-	for i := 0; i < g.numCPU; i++ {
-		if len(partitionedPoints[i]) == 0 {
-			continue
-		}
-		
+	for _, i := range touched {
 		wg.Add(1)
-		go func(partitionIdx int, items []*spatialPoint) {
+		go func(partitionIdx int, items []rtreego.Spatial) {
 			defer wg.Done()
 			
-			// Each partition can be updated independently
-			for _, item := range items {
-				g.partitions[partitionIdx].Insert(item)
-			}
-			totalInserted.Add(int64(len(items)))
+			// Each partition can be rebuilt independently
+			built[partitionIdx] = old.partitions[partitionIdx].with(items, g.idFalsePositives/float64(g.numCPU*idFilterTrees))
+			progress.built(len(items), added, len(touched))
 		}(i, partitionedPoints[i])
 	}
 	
 	wg.Wait()
-	g.itemCount.Store(totalInserted.Load())
-	return nil
+	return built
+}
+
+// stateFrom builds a state holding only points, without publishing it
+func (g *GeoIndex) stateFrom(points []*models.Point, progress *progressReporter) *indexState {
+	empty := g.emptyState()
+	if g.storage != partitionStorage {
+		defer progress.report(Progress{Stage: StageBuilding, Done: len(points), Total: len(points)})
+		next, added := empty.store.add(g, points)
+		return &indexState{store: next, count: int64(added), shadow: g.shadowed(empty, points)}
+	}
+
+	partitionedPoints := g.groupByPartition(points, progress)
+	next := &indexState{partitions: empty.partitions, shadow: g.shadowed(empty, points)}
+	var touched []int
+	for i, items := range partitionedPoints {
+		if len(items) > 0 {
+			touched = append(touched, i)
+			next.count += int64(len(items))
+		}
+	}
+	built := g.buildPartitions(empty, partitionedPoints, touched, progress)
+	for _, i := range touched {
+		next.partitions[i] = built[i]
+	}
+	return next
 }
 
-// QueryBox returns all points within the given bounding box using parallel search
+// QueryBox returns all points within the given bounding box using parallel search.
+// Points on the box edges are included unless the index was built with
+// WithBoxEdges(EdgesHalfOpen).
+// Boxes with NaN corners or BottomLeft above or right of TopRight give
+// ErrInvalidQuery.
 func (g *GeoIndex) QueryBox(box models.BoundingBox) ([]*models.Point, error) {
-	g.mu.RLock()
-	defer g.mu.RUnlock()
+	return g.QueryBoxContext(context.Background(), box)
+}
+
+// QueryBoxContext is QueryBox with profiler labels taken from ctx, see
+// WithOperation. The context does not cancel the query.
+func (g *GeoIndex) QueryBoxContext(ctx context.Context, box models.BoundingBox) ([]*models.Point, error) {
+	if err := checkBox(box); err != nil {
+		return nil, err
+	}
+	parts := []models.BoundingBox{box}
+	if g.wrapLongitudes {
+		parts = splitAtAntimeridian(box)
+	}
+
+	ctx, start := g.startQuery(ctx)
+	state := g.state.Load()
+	var allResults []*models.Point
+	for _, part := range parts {
+		results, err := g.queryBox(ctx, state, part)
+		if err != nil {
+			return nil, err
+		}
+		allResults = append(allResults, results...)
+	}
+	if g.stableOrder {
+		sort.Slice(allResults, func(i, j int) bool {
+			return allResults[i].ID < allResults[j].ID
+		})
+	}
+	if g.verifier != nil {
+		if err := g.verifyBox(state, box, allResults); err != nil {
+			return nil, err
+		}
+	}
+	g.endQuery(ctx, start, SlowQuery{Kind: queryKindBox, Box: &box, Results: len(allResults)})
+	return allResults, nil
+}
+
+func (g *GeoIndex) queryBox(ctx context.Context, state *indexState, box models.BoundingBox) ([]*models.Point, error) {
+	if g.cache != nil {
+		return g.cachedQueryBox(ctx, state, box), nil
+	}
+	return g.searchBox(ctx, state, box)
+}
+
+// searchBox is queryBox without the cache
+func (g *GeoIndex) searchBox(ctx context.Context, state *indexState, box models.BoundingBox) ([]*models.Point, error) {
+	if state.store != nil {
+		var points []*models.Point
+		g.labeled(ctx, queryKindBox, -1, func(context.Context) {
+			points = state.store.box(g, box)
+		})
+		return points, nil
+	}
 	
 	// Determine which partitions to search
-	relevantPartitions := g.getRelevantPartitions(box)
+	relevantPartitions := g.getRelevantPartitions(state, box)
+	if len(relevantPartitions) == 1 {
+		var points []*models.Point
+		g.labeled(ctx, queryKindBox, relevantPartitions[0], func(context.Context) {
+			points = g.partitionBox(state.partitions[relevantPartitions[0]], box)
+		})
+		return points, nil
+	}
 	
 	// Create channels for results
 	resultsChan := make(chan []*models.Point, len(relevantPartitions))
@@ -189,39 +499,9 @@ func (g *GeoIndex) QueryBox(box models.BoundingBox) ([]*models.Point, error) {
 	// Search partitions in parallel
 	for _, partitionIdx := range relevantPartitions {
 		go func(idx int) {
-			// Calculate bounding box dimensions
-			bottomLeft := rtreego.Point{box.BottomLeft.Lat, box.BottomLeft.Lon}
-			rectSize := []float64{
-				box.TopRight.Lat - box.BottomLeft.Lat,
-				box.TopRight.Lon - box.BottomLeft.Lon,
-			}
-			
-			bounds, err := rtreego.NewRect(bottomLeft, rectSize)
-			if err != nil {
-				resultsChan <- nil
-				return
-			}
-			
-			// Search this partition
-			results := g.partitions[idx].SearchIntersect(bounds)
-			
-			// Filter results to ensure they're strictly within bounds
-			points := make([]*models.Point, 0)
-			for _, result := range results {
-				item, ok := result.(*spatialPoint)
-				if !ok || item.Point == nil || item.Point.Location == nil {
-					continue
-				}
-				
-				// Strict boundary check
-				loc := item.Point.Location
-				if loc.Lat >= box.BottomLeft.Lat && loc.Lat <= box.TopRight.Lat &&
-				   loc.Lon >= box.BottomLeft.Lon && loc.Lon <= box.TopRight.Lon {
-					points = append(points, item.Point)
-				}
-			}
-			
-			resultsChan <- points
+			g.labeled(ctx, queryKindBox, idx, func(context.Context) {
+				resultsChan <- g.partitionBox(state.partitions[idx], box)
+			})
 		}(partitionIdx)
 	}
 	
@@ -237,178 +517,446 @@ func (g *GeoIndex) QueryBox(box models.BoundingBox) ([]*models.Point, error) {
 	return allResults, nil
 }
 
-// QueryRadius returns all points within the given radius (in km) from a center point using parallel search
-func (g *GeoIndex) QueryRadius(center models.Location, radiusKm float64) ([]*models.Point, error) {
-	g.mu.RLock()
-	defer g.mu.RUnlock()
-	
-	// Convert radius to degrees (approximate)
-	deg := (radiusKm / earthRadius) * (180 / math.Pi)
-	
-	// Create bounding box for initial filtering
-	queryBox := models.BoundingBox{
-		BottomLeft: models.Location{Lat: center.Lat - deg, Lon: center.Lon - deg},
-		TopRight:   models.Location{Lat: center.Lat + deg, Lon: center.Lon + deg},
+// partitionBox returns the points of part inside box
+func (g *GeoIndex) partitionBox(part *partition, box models.BoundingBox) []*models.Point {
+	bounds, err := searchRect(box)
+	if err != nil {
+		return nil
+	}
+
+	// Filter results to ensure they're strictly within bounds
+	var points []*models.Point
+	for _, result := range part.searchIntersect(bounds) {
+		item, ok := result.(*spatialPoint)
+		if !ok || item.Point == nil || item.Point.Location == nil {
+			continue
+		}
+		if g.inBox(box, *item.Point.Location) {
+			points = append(points, item.Point)
+		}
+	}
+	return points
+}
+
+// searchRect converts box to an rtreego rect padded by searchPadding
+func searchRect(box models.BoundingBox) (*rtreego.Rect, error) {
+	return rtreego.NewRect(
+		rtreego.Point{box.BottomLeft.Lat - searchPadding, box.BottomLeft.Lon - searchPadding},
+		[]float64{
+			box.TopRight.Lat - box.BottomLeft.Lat + 2*searchPadding,
+			box.TopRight.Lon - box.BottomLeft.Lon + 2*searchPadding,
+		},
+	)
+}
+
+// inBox reports whether loc is inside box under the index's edge policy
+func (g *GeoIndex) inBox(box models.BoundingBox, loc models.Location) bool {
+	if !box.Contains(loc) {
+		return false
+	}
+	if g.boxEdges == EdgesHalfOpen {
+		if loc.Lat == box.TopRight.Lat && box.TopRight.Lat < 90 {
+			return false
+		}
+		if loc.Lon == box.TopRight.Lon && box.TopRight.Lon < 180 {
+			return false
+		}
+	}
+	return true
+}
+
+// QueryRadius returns all points within the given radius from a center point using parallel search.
+// The radius is in the index's distance unit, kilometers unless set with
+// WithDistanceUnit. Invalid centers and negative radii give ErrInvalidQuery.
+func (g *GeoIndex) QueryRadius(center models.Location, radius float64) ([]*models.Point, error) {
+	return g.QueryRadiusContext(context.Background(), center, radius)
+}
+
+// QueryRadiusContext is QueryRadius with profiler labels taken from ctx, see
+// WithOperation. The context does not cancel the query.
+func (g *GeoIndex) QueryRadiusContext(ctx context.Context, center models.Location, radius float64) ([]*models.Point, error) {
+	results, err := g.queryRadius(ctx, center, radius)
+	if err != nil {
+		return nil, err
+	}
+	if g.stableOrder {
+		sortByDistance(results)
+	}
+	points := make([]*models.Point, len(results))
+	for i, r := range results {
+		points[i] = r.Point
+	}
+	return points, nil
+}
+
+// QueryRadiusWithDistance is QueryRadius with each point's haversine distance
+// from center, or 3D distance WithAltitude, sorted nearest first with ties
+// broken by ID
+func (g *GeoIndex) QueryRadiusWithDistance(center models.Location, radius float64) ([]models.PointDistance, error) {
+	results, err := g.queryRadius(context.Background(), center, radius)
+	if err != nil {
+		return nil, err
+	}
+	sortByDistance(results)
+	return results, nil
+}
+
+// pointDistance pairs p with its distance, also given in the index's unit
+func (g *GeoIndex) pointDistance(p *models.Point, km float64) models.PointDistance {
+	return models.PointDistance{Point: p, DistanceKm: km, Distance: g.unit.FromKm(km), Unit: g.unit}
+}
+
+// sortByDistance orders results nearest first, breaking ties by ID
+func sortByDistance(results []models.PointDistance) {
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].DistanceKm != results[j].DistanceKm {
+			return results[i].DistanceKm < results[j].DistanceKm
+		}
+		return results[i].Point.ID < results[j].Point.ID
+	})
+}
+
+// checkRadius validates a radius query and returns its center, with the
+// longitude wrapped if the index wraps, and its radius in kilometers
+func (g *GeoIndex) checkRadius(center models.Location, radius float64) (models.Location, float64, error) {
+	radiusKm := g.unit.ToKm(radius)
+	if math.IsNaN(center.Lat) || math.IsNaN(center.Lon) || center.Lat < -90 || center.Lat > 90 {
+		return center, 0, fmt.Errorf("%w: center (%v, %v)", ErrInvalidQuery, center.Lat, center.Lon)
+	}
+	if !(radiusKm >= 0) {
+		return center, 0, fmt.Errorf("%w: radius %v %s", ErrInvalidQuery, radius, g.unit)
+	}
+	if g.wrapLongitudes {
+		center.Lon = models.NormalizeLongitude(center.Lon)
+	}
+	return center, radiusKm, nil
+}
+
+func (g *GeoIndex) queryRadius(ctx context.Context, center models.Location, radius float64) ([]models.PointDistance, error) {
+	query := SlowQuery{Kind: queryKindRadius, Center: &center, Radius: radius}
+	center, radiusKm, err := g.checkRadius(center, radius)
+	if err != nil {
+		return nil, err
+	}
+	ctx, start := g.startQuery(ctx)
+	state := g.state.Load()
+	results := g.surfaceWithin(ctx, state, center, radiusKm)
+	if g.altitude {
+		results = g.within3D(results, center, radiusKm)
+	}
+	if g.verifier != nil {
+		if err := g.verifyRadius(state, center, radiusKm, results); err != nil {
+			return nil, err
+		}
+	}
+	query.Results = len(results)
+	g.endQuery(ctx, start, query)
+	return results, nil
+}
+
+// within3D keeps the results within radiusKm of center in 3D, with their 3D
+// distances. Surface distances never exceed 3D ones, so the surface circle
+// holds every result.
+func (g *GeoIndex) within3D(results []models.PointDistance, center models.Location, radiusKm float64) []models.PointDistance {
+	kept := results[:0]
+	for _, r := range results {
+		if dist := math.Hypot(r.DistanceKm, (r.Point.Location.Alt-center.Alt)/1000); dist <= radiusKm {
+			kept = append(kept, g.pointDistance(r.Point, dist))
+		}
+	}
+	return kept
+}
+
+// surfaceWithin returns the points of state within radiusKm of a checked
+// center by surface distance, unsorted
+func (g *GeoIndex) surfaceWithin(ctx context.Context, state *indexState, center models.Location, radiusKm float64) []models.PointDistance {
+	if g.cache != nil {
+		return g.cachedWithin(ctx, state, center, radiusKm)
+	}
+	if state.store != nil {
+		var points []models.PointDistance
+		g.labeled(ctx, queryKindRadius, -1, func(context.Context) {
+			points = state.store.within(g, center, radiusKm)
+		})
+		return points
+	}
+	return g.partitionsWithin(ctx, state, queryKindRadius, center, radiusKm)
+}
+
+// partitionsWithin is surfaceWithin for partition storage, labeling the
+// partition searches with kind
+func (g *GeoIndex) partitionsWithin(ctx context.Context, state *indexState, kind string, center models.Location, radiusKm float64) []models.PointDistance {
+	// Determine which partitions to search, with the part of the circle's
+	// box that each covers
+	type search struct {
+		idx int
+		box models.BoundingBox
+	}
+	var searches []search
+	for _, queryBox := range g.radiusBoxes(center, radiusKm) {
+		for _, idx := range g.getRelevantPartitions(state, queryBox) {
+			searches = append(searches, search{idx, queryBox})
+		}
+	}
+	if len(searches) == 1 {
+		var points []models.PointDistance
+		g.labeled(ctx, kind, searches[0].idx, func(context.Context) {
+			points = g.partitionRadius(state.partitions[searches[0].idx], center, searches[0].box, radiusKm)
+		})
+		return points
 	}
-	
-	// Determine which partitions to search
-	relevantPartitions := g.getRelevantPartitions(queryBox)
 	
 	// Create channels for results
-	resultsChan := make(chan []*models.Point, len(relevantPartitions))
+	resultsChan := make(chan []models.PointDistance, len(searches))
 	
 	// Search partitions in parallel
-	for _, partitionIdx := range relevantPartitions {
-		go func(idx int) {
-			bounds, err := rtreego.NewRect(
-				rtreego.Point{center.Lat - deg, center.Lon - deg},
-				[]float64{2 * deg, 2 * deg},
-			)
-			if err != nil {
-				resultsChan <- nil
-				return
-			}
-			
-			results := g.partitions[idx].SearchIntersect(bounds)
-			
-			// Filter by actual distance
-			points := make([]*models.Point, 0)
-			for _, result := range results {
-				item, ok := result.(*spatialPoint)
-				if !ok || item.Point == nil || item.Point.Location == nil {
-					continue
-				}
-				
-				dist := Distance(center.Lat, center.Lon, 
-					item.Point.Location.Lat, item.Point.Location.Lon)
-				if dist <= radiusKm {
-					points = append(points, item.Point)
-				}
-			}
-			
-			resultsChan <- points
-		}(partitionIdx)
+	for _, s := range searches {
+		go func(s search) {
+			g.labeled(ctx, kind, s.idx, func(context.Context) {
+				resultsChan <- g.partitionRadius(state.partitions[s.idx], center, s.box, radiusKm)
+			})
+		}(s)
 	}
 	
 	// Merge results from all partitions
-	var allResults []*models.Point
-	for i := 0; i < len(relevantPartitions); i++ {
+	var allResults []models.PointDistance
+	for i := 0; i < len(searches); i++ {
 		partitionResults := <-resultsChan
 		if partitionResults != nil {
 			allResults = append(allResults, partitionResults...)
 		}
 	}
 	
-	return allResults, nil
+	return allResults
+}
+
+// partitionRadius returns the points of part within radiusKm of center,
+// searching the tree with queryBox, the box around the circle
+func (g *GeoIndex) partitionRadius(part *partition, center models.Location, queryBox models.BoundingBox, radiusKm float64) []models.PointDistance {
+	bounds, err := searchRect(queryBox)
+	if err != nil {
+		return nil
+	}
+
+	// Filter by actual distance
+	centerTrig := newTrigLocation(center)
+	var points []models.PointDistance
+	for _, result := range part.searchIntersect(bounds) {
+		item, ok := result.(*spatialPoint)
+		if !ok || item.Point == nil || item.Point.Location == nil {
+			continue
+		}
+		if dist := centerTrig.distanceKm(item.trig); dist <= radiusKm {
+			points = append(points, g.pointDistance(item.Point, dist))
+		}
+	}
+	return points
 }
 
-// NearestNeighbors returns the N nearest points to the given location using parallel search
+// NearestNeighbors returns the N nearest points to the given location using
+// parallel search, or none if n is not positive
 func (g *GeoIndex) NearestNeighbors(center models.Location, n int) []*models.Point {
-	g.mu.RLock()
-	defer g.mu.RUnlock()
-	
-	type nearestResult struct {
-		point    *models.Point
-		distance float64
+	return g.NearestNeighborsContext(context.Background(), center, n)
+}
+
+// NearestNeighborsContext is NearestNeighbors with profiler labels taken from
+// ctx, see WithOperation. The context does not cancel the query.
+func (g *GeoIndex) NearestNeighborsContext(ctx context.Context, center models.Location, n int) []*models.Point {
+	results := g.nearest(ctx, center, n)
+	points := make([]*models.Point, len(results))
+	for i, r := range results {
+		points[i] = r.Point
 	}
-	
-	// Search all partitions in parallel
-	resultsChan := make(chan []nearestResult, g.numCPU)
-	
-	for i := 0; i < g.numCPU; i++ {
-		go func(idx int) {
-			queryPoint := rtreego.Point{center.Lat, center.Lon}
-			// Get more candidates than needed from each partition
-			results := g.partitions[idx].NearestNeighbors(n*2, queryPoint)
-			
-			nearestResults := make([]nearestResult, 0, len(results))
-			for _, result := range results {
-				sp := result.(*spatialPoint)
-				dist := Distance(center.Lat, center.Lon,
-					sp.Point.Location.Lat, sp.Point.Location.Lon)
-				nearestResults = append(nearestResults, nearestResult{
-					point:    sp.Point,
-					distance: dist,
-				})
-			}
-			
-			resultsChan <- nearestResults
-		}(i)
+	return points
+}
+
+// NearestNeighborsWithDistance is NearestNeighbors with each point's haversine
+// distance from center, or 3D distance WithAltitude
+func (g *GeoIndex) NearestNeighborsWithDistance(center models.Location, n int) []models.PointDistance {
+	return g.nearest(context.Background(), center, n)
+}
+
+// Nearest answers "what's here": the single point closest to loc, with its
+// distance and properties. It returns ErrEmptyIndex if there are no points.
+func (g *GeoIndex) Nearest(loc models.Location) (models.PointDistance, error) {
+	results := g.nearest(context.Background(), loc, 1)
+	if len(results) == 0 {
+		return models.PointDistance{}, ErrEmptyIndex
 	}
-	
-	// Collect all results
-	var allResults []nearestResult
-	for i := 0; i < g.numCPU; i++ {
-		partitionResults := <-resultsChan
-		allResults = append(allResults, partitionResults...)
+	return results[0], nil
+}
+
+func (g *GeoIndex) nearest(ctx context.Context, center models.Location, n int) []models.PointDistance {
+	if n <= 0 {
+		return nil
 	}
-	
-	// Sort by distance and take top n
-	for i := 0; i < len(allResults); i++ {
-		for j := i + 1; j < len(allResults); j++ {
-			if allResults[i].distance > allResults[j].distance {
-				allResults[i], allResults[j] = allResults[j], allResults[i]
-			}
-		}
+	query := SlowQuery{Kind: queryKindNearest, Center: &center, N: n}
+	// Searches size buffers by n, so more than every point is never asked for
+	if count := g.Count(); int64(n) > count {
+		n = int(count)
 	}
-	
-	// Return top n points
-	resultCount := n
-	if len(allResults) < n {
-		resultCount = len(allResults)
+	if g.wrapLongitudes {
+		center.Lon = models.NormalizeLongitude(center.Lon)
 	}
-	
-	points := make([]*models.Point, resultCount)
-	for i := 0; i < resultCount; i++ {
-		points[i] = allResults[i].point
+	ctx, start := g.startQuery(ctx)
+	state := g.state.Load()
+	results := g.searchNearest(ctx, state, center, n)
+	if g.verifier != nil {
+		g.verifyNearest(state, center, n, results)
 	}
-	
-	return points
+	query.Results = len(results)
+	g.endQuery(ctx, start, query)
+	return results
+}
+
+// searchNearest is nearest on state without verification, for a wrapped
+// center
+func (g *GeoIndex) searchNearest(ctx context.Context, state *indexState, center models.Location, n int) []models.PointDistance {
+	if !g.altitude {
+		return g.surfaceNearest(ctx, state, center, n)
+	}
+
+	// The n nearest points by surface distance are within the largest of
+	// their 3D distances, so the n nearest in 3D are within that surface
+	// radius too. The radius is padded against rounding.
+	candidates := g.surfaceNearest(ctx, state, center, n)
+	if len(candidates) == 0 {
+		return nil
+	}
+	radiusKm := 0.0
+	for _, c := range candidates {
+		radiusKm = max(radiusKm, math.Hypot(c.DistanceKm, (c.Point.Location.Alt-center.Alt)/1000))
+	}
+	radiusKm = radiusKm*(1+1e-9) + 1e-9
+	results := g.within3D(g.surfaceWithin(ctx, state, center, radiusKm), center, radiusKm)
+	sortByDistance(results)
+	if len(results) > n {
+		results = results[:n]
+	}
+	return results
+}
+
+// surfaceNearest returns the n points of state nearest to center by surface
+// distance
+func (g *GeoIndex) surfaceNearest(ctx context.Context, state *indexState, center models.Location, n int) []models.PointDistance {
+	if state.store != nil {
+		var points []models.PointDistance
+		g.labeled(ctx, queryKindNearest, -1, func(context.Context) {
+			points = state.store.nearest(g, center, n)
+		})
+		return points
+	}
+
+	// Widen a radius search fourfold from compactStartKm until it holds n
+	// points: nothing outside the radius can be nearer than those. A planar
+	// search of each partition's tree would miss neighbors across the
+	// antimeridian and misrank them near the poles.
+	var results []models.PointDistance
+	for radiusKm := float64(compactStartKm); ; radiusKm *= 4 {
+		results = g.partitionsWithin(ctx, state, queryKindNearest, center, radiusKm)
+		if len(results) >= n || radiusKm >= math.Pi*earthRadius {
+			break
+		}
+	}
+	sortByDistance(results)
+	if len(results) > n {
+		results = results[:n]
+	}
+	return results
+}
+
+// DistanceUnit returns the unit of radii and distances used by the index
+func (g *GeoIndex) DistanceUnit() models.Unit {
+	return g.unit
 }
 
 // Count returns the number of indexed points
 func (g *GeoIndex) Count() int64 {
-	return g.itemCount.Load()
+	return g.state.Load().count
 }
 
 // Clear removes all points from the index
 func (g *GeoIndex) Clear() {
-	g.mu.Lock()
-	defer g.mu.Unlock()
-	
-	for i := 0; i < g.numCPU; i++ {
-		g.partitions[i] = rtreego.NewTree(dimensions, minChildren, maxChildren)
+	g.writeMu.Lock()
+	defer g.writeMu.Unlock()
+	defer g.lockPartitions(g.allPartitions())()
+	g.state.Store(g.emptyState())
+	g.cache.purge()
+	g.metricsSink().SetPointCount(0)
+}
+
+// checkBox rejects boxes no point can lie in
+func checkBox(box models.BoundingBox) error {
+	for _, v := range []float64{box.BottomLeft.Lat, box.BottomLeft.Lon, box.TopRight.Lat, box.TopRight.Lon} {
+		if math.IsNaN(v) {
+			return fmt.Errorf("%w: box has NaN coordinates", ErrInvalidQuery)
+		}
+	}
+	if box.BottomLeft.Lat > box.TopRight.Lat || box.BottomLeft.Lon > box.TopRight.Lon {
+		return fmt.Errorf("%w: bottom-left corner is above or right of top-right corner", ErrInvalidQuery)
 	}
-	g.itemCount.Store(0)
+	return nil
 }
 
-// getRelevantPartitions returns the indices of partitions that intersect with the given bounding box
-func (g *GeoIndex) getRelevantPartitions(box models.BoundingBox) []int {
+// getRelevantPartitions returns the indices of partitions whose data intersects with the given bounding box
+func (g *GeoIndex) getRelevantPartitions(state *indexState, box models.BoundingBox) []int {
 	var relevant []int
-	for i, bounds := range g.partitionBounds {
-		// Check if partition bounds intersect with query box
-		if box.BottomLeft.Lon <= bounds.TopRight.Lon &&
-		   box.TopRight.Lon >= bounds.BottomLeft.Lon {
+	for i, part := range state.partitions {
+		// Check if the extent of the partition's points intersects with query box
+		if part.count > 0 && box.Intersects(part.bounds) {
 			relevant = append(relevant, i)
 		}
 	}
 	return relevant
 }
 
+// wrapPoint returns point, or a copy with its longitude wrapped into [-180, 180)
+func wrapPoint(point *models.Point) *models.Point {
+	lon := models.NormalizeLongitude(point.Location.Lon)
+	if lon == point.Location.Lon {
+		return point
+	}
+	wrapped := *point
+	wrapped.Location = &models.Location{Lat: point.Location.Lat, Lon: lon, Alt: point.Location.Alt}
+	return &wrapped
+}
+
+// radiusBoxes returns the boxes to search for the points within radiusKm of
+// center. Longitudes wrapped by WithLongitudeWrap lie in [-180, 180), so the
+// circle's box is split at the antimeridian; otherwise the box is searched as
+// it is, in case points were stored past ±180, and its overhang moved back
+// into range as well.
+func (g *GeoIndex) radiusBoxes(center models.Location, radiusKm float64) []models.BoundingBox {
+	if g.wrapLongitudes {
+		return splitAtAntimeridian(models.NewBoundingBoxFromCenter(center, radiusKm))
+	}
+	return models.NewBoundingBoxesFromCenter(center, radiusKm)
+}
+
+// splitAtAntimeridian wraps a box's longitudes into [-180, 180), splitting it
+// in two if it then crosses the antimeridian. Boxes 360 degrees or wider cover
+// every longitude.
+func splitAtAntimeridian(box models.BoundingBox) []models.BoundingBox {
+	width := box.TopRight.Lon - box.BottomLeft.Lon
+	if width >= 360 {
+		box.BottomLeft.Lon, box.TopRight.Lon = -180, 180
+		return []models.BoundingBox{box}
+	}
+
+	minLon := models.NormalizeLongitude(box.BottomLeft.Lon)
+	maxLon := minLon + width
+	if maxLon <= 180 {
+		box.BottomLeft.Lon, box.TopRight.Lon = minLon, maxLon
+		return []models.BoundingBox{box}
+	}
+
+	east, west := box, box
+	east.BottomLeft.Lon, east.TopRight.Lon = minLon, 180
+	west.BottomLeft.Lon, west.TopRight.Lon = -180, maxLon-360
+	return []models.BoundingBox{east, west}
+}
+
 // Distance calculates the Haversine distance between two points in kilometers
 func Distance(lat1, lon1, lat2, lon2 float64) float64 {
-	lat1Rad := lat1 * math.Pi / 180.0
-	lon1Rad := lon1 * math.Pi / 180.0
-	lat2Rad := lat2 * math.Pi / 180.0
-	lon2Rad := lon2 * math.Pi / 180.0
-	
-	dLat := lat2Rad - lat1Rad
-	dLon := lon2Rad - lon1Rad
-	
-	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
-		math.Cos(lat1Rad)*math.Cos(lat2Rad)*
-		math.Sin(dLon/2)*math.Sin(dLon/2)
-	
-	c := 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
-	return earthRadius * c
+	return models.Location{Lat: lat1, Lon: lon1}.DistanceTo(models.Location{Lat: lat2, Lon: lon2})
 }
\ No newline at end of file
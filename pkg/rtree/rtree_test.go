@@ -1,9 +1,23 @@
 package rtree
 
 import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
 	"fmt"
+	"log/slog"
+	"math"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"runtime"
+	"runtime/pprof"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -15,9 +29,9 @@ import (
 func TestNewGeoIndex(t *testing.T) {
 	index := NewGeoIndex()
 	assert.NotNil(t, index)
-	assert.NotNil(t, index.partitions)
+	assert.NotNil(t, index.state.Load().partitions)
 	assert.Equal(t, runtime.NumCPU(), index.numCPU)
-	assert.Equal(t, runtime.NumCPU(), len(index.partitions))
+	assert.Equal(t, runtime.NumCPU(), len(index.state.Load().partitions))
 	assert.Equal(t, int64(0), index.Count())
 }
 
@@ -36,6 +50,14 @@ func TestIndexPoints(t *testing.T) {
 	assert.Equal(t, int64(3), index.Count()) // Only 3 points have locations
 }
 
+func TestIndexPointsIncremental(t *testing.T) {
+	index := NewGeoIndex()
+	
+	require.NoError(t, index.IndexPoints(generateRandomPoints(100)))
+	require.NoError(t, index.IndexPoints(generateRandomPoints(50)))
+	assert.Equal(t, int64(150), index.Count())
+}
+
 func TestQueryBox(t *testing.T) {
 	index := NewGeoIndex()
 	
@@ -74,6 +96,77 @@ func TestQueryBox(t *testing.T) {
 	assert.False(t, resultIDs["CHI"])
 }
 
+func TestBoxEdges(t *testing.T) {
+	points := []*models.Point{
+		{ID: "corner", Location: &models.Location{Lat: 10, Lon: 10}},
+		{ID: "top", Location: &models.Location{Lat: 20, Lon: 15}},
+		{ID: "right", Location: &models.Location{Lat: 15, Lon: 20}},
+		{ID: "pole", Location: &models.Location{Lat: 90, Lon: 180}},
+	}
+	ids := func(index *GeoIndex, box models.BoundingBox) []string {
+		results, err := index.QueryBox(box)
+		require.NoError(t, err)
+		var ids []string
+		for _, p := range results {
+			ids = append(ids, p.ID)
+		}
+		return ids
+	}
+	box := models.BoundingBox{
+		BottomLeft: models.Location{Lat: 10, Lon: 10},
+		TopRight:   models.Location{Lat: 20, Lon: 20},
+	}
+	world := models.BoundingBox{
+		BottomLeft: models.Location{Lat: -90, Lon: -180},
+		TopRight:   models.Location{Lat: 90, Lon: 180},
+	}
+
+	closed := NewGeoIndex()
+	require.NoError(t, closed.IndexPoints(points))
+	assert.ElementsMatch(t, []string{"corner", "top", "right"}, ids(closed, box))
+
+	halfOpen := NewGeoIndex(WithBoxEdges(EdgesHalfOpen))
+	require.NoError(t, halfOpen.IndexPoints(points))
+	assert.ElementsMatch(t, []string{"corner"}, ids(halfOpen, box))
+	assert.Len(t, ids(halfOpen, world), len(points))
+}
+
+func TestStableOrder(t *testing.T) {
+	index := NewGeoIndexWithWorkers(4, WithStableOrder())
+	center := models.Location{Lat: 0, Lon: 0}
+	var points []*models.Point
+	for i := 0; i < 40; i++ {
+		// Pairs of points share a location so distances tie
+		loc := center.Destination(float64(i/2*18), float64(i/2%3+1)*10)
+		points = append(points, &models.Point{ID: fmt.Sprintf("p%02d", 39-i), Location: &loc})
+	}
+	require.NoError(t, index.IndexPoints(points))
+
+	world := models.BoundingBox{
+		BottomLeft: models.Location{Lat: -90, Lon: -180},
+		TopRight:   models.Location{Lat: 90, Lon: 180},
+	}
+	results, err := index.QueryBox(world)
+	require.NoError(t, err)
+	require.Len(t, results, 40)
+	for i, p := range results {
+		assert.Equal(t, fmt.Sprintf("p%02d", i), p.ID)
+	}
+
+	inOrder := func(results []*models.Point) {
+		for i := 1; i < len(results); i++ {
+			prev, cur := center.DistanceTo(*results[i-1].Location), center.DistanceTo(*results[i].Location)
+			assert.True(t, prev < cur || prev == cur && results[i-1].ID < results[i].ID,
+				"%s before %s", results[i-1].ID, results[i].ID)
+		}
+	}
+	results, err = index.QueryRadius(center, 100)
+	require.NoError(t, err)
+	require.Len(t, results, 40)
+	inOrder(results)
+	inOrder(index.NearestNeighbors(center, 40))
+}
+
 func TestQueryRadius(t *testing.T) {
 	index := NewGeoIndex()
 	
@@ -120,6 +213,504 @@ func TestQueryRadius(t *testing.T) {
 	}
 }
 
+func TestQueryRadiusWithDistance(t *testing.T) {
+	index := NewGeoIndex()
+	center := models.Location{Lat: 37.7749, Lon: -122.4194}
+	var points []*models.Point
+	for i, km := range []float64{40, 5, 120, 20} {
+		loc := center.Destination(float64(i*90), km)
+		points = append(points, &models.Point{ID: fmt.Sprintf("%.0fkm", km), Location: &loc})
+	}
+	require.NoError(t, index.IndexPoints(points))
+
+	results, err := index.QueryRadiusWithDistance(center, 100)
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	for i, want := range []float64{5, 20, 40} {
+		assert.Equal(t, fmt.Sprintf("%.0fkm", want), results[i].Point.ID)
+		assert.InDelta(t, want, results[i].DistanceKm, 1e-6)
+	}
+
+	_, err = index.QueryRadiusWithDistance(center, -1)
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+}
+
+func TestBatchQueries(t *testing.T) {
+	points := worldPoints(5000, 1)
+	centers := queryCenters(50)
+	boxes := make([]models.BoundingBox, len(centers))
+	for i, c := range centers {
+		boxes[i] = models.BoundingBox{
+			BottomLeft: models.Location{Lat: c.Lat - 5, Lon: c.Lon - 5},
+			TopRight:   models.Location{Lat: c.Lat + 5, Lon: c.Lon + 5},
+		}
+	}
+
+	for name, opts := range map[string][]Option{
+		"partitioned": {WithStableOrder()},
+		"compact":     {WithStableOrder(), WithCompactStorage()},
+		"geohash":     {WithStableOrder(), WithGeohashStorage(3)},
+		"s2":          {WithStableOrder(), WithS2Storage()},
+		"quadtree":    {WithStableOrder(), WithQuadtreeStorage()},
+		"kd-tree":     {WithStableOrder(), WithKDTreeStorage()},
+		"grid":        {WithStableOrder(), WithGridStorage(0)},
+		"space-time":  {WithStableOrder(), WithSpaceTimeStorage("")},
+		"wrapped":     {WithStableOrder(), WithLongitudeWrap()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			index := NewGeoIndexWithWorkers(4, opts...)
+			require.NoError(t, index.IndexPoints(points))
+
+			boxResults, err := index.QueryBoxes(boxes)
+			require.NoError(t, err)
+			require.Len(t, boxResults, len(boxes))
+			for i, box := range boxes {
+				want, err := index.QueryBox(box)
+				require.NoError(t, err)
+				assert.Equal(t, want, boxResults[i])
+			}
+
+			radiusResults, err := index.QueryRadii(centers, 500)
+			require.NoError(t, err)
+			require.Len(t, radiusResults, len(centers))
+			for i, center := range centers {
+				want, err := index.QueryRadius(center, 500)
+				require.NoError(t, err)
+				assert.Equal(t, want, radiusResults[i])
+			}
+		})
+	}
+
+	index := NewGeoIndex()
+	results, err := index.QueryBoxes(nil)
+	require.NoError(t, err)
+	assert.Empty(t, results)
+	_, err = index.QueryBoxes([]models.BoundingBox{boxes[0], {BottomLeft: models.Location{Lat: 10}}})
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+	_, err = index.QueryRadii(centers, -1)
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+}
+
+func TestQueryCache(t *testing.T) {
+	points := worldPoints(5000, 1)
+	centers := queryCenters(20)
+
+	for name, opts := range map[string][]Option{
+		"partitioned": {WithStableOrder()},
+		"compact":     {WithStableOrder(), WithCompactStorage()},
+		"geohash":     {WithStableOrder(), WithGeohashStorage(0)},
+		"s2":          {WithStableOrder(), WithS2Storage()},
+		"quadtree":    {WithStableOrder(), WithQuadtreeStorage()},
+		"kd-tree":     {WithStableOrder(), WithKDTreeStorage()},
+		"grid":        {WithStableOrder(), WithGridStorage(0)},
+		"space-time":  {WithStableOrder(), WithSpaceTimeStorage("")},
+		"half-open":   {WithStableOrder(), WithBoxEdges(EdgesHalfOpen)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			plain := NewGeoIndexWithWorkers(4, opts...)
+			cached := NewGeoIndexWithWorkers(4, append(opts, WithQueryCache(QueryCacheConfig{Size: 100, Quantum: 1}))...)
+			require.NoError(t, plain.IndexPoints(points))
+			require.NoError(t, cached.IndexPoints(points))
+
+			// Repeat each query so the second run is served from the cache
+			for round := 0; round < 2; round++ {
+				for _, c := range centers {
+					box := models.BoundingBox{
+						BottomLeft: models.Location{Lat: c.Lat - 3.3, Lon: c.Lon - 2.7},
+						TopRight:   models.Location{Lat: c.Lat + 3.3, Lon: c.Lon + 2.7},
+					}
+					want, err := plain.QueryBox(box)
+					require.NoError(t, err)
+					got, err := cached.QueryBox(box)
+					require.NoError(t, err)
+					assert.Equal(t, want, got)
+
+					wantRadius, err := plain.QueryRadiusWithDistance(c, 300)
+					require.NoError(t, err)
+					gotRadius, err := cached.QueryRadiusWithDistance(c, 300)
+					require.NoError(t, err)
+					assert.Equal(t, wantRadius, gotRadius)
+				}
+			}
+		})
+	}
+
+	t.Run("invalidation", func(t *testing.T) {
+		index := NewGeoIndex(WithQueryCache(QueryCacheConfig{Size: 2, TTL: time.Minute}))
+		now := time.Now()
+		index.cache.now = func() time.Time { return now }
+		box := models.BoundingBox{
+			BottomLeft: models.Location{Lat: 10, Lon: 10},
+			TopRight:   models.Location{Lat: 11, Lon: 11},
+		}
+		inside := &models.Point{ID: "a", Location: &models.Location{Lat: 10.5, Lon: 10.5}}
+		require.NoError(t, index.IndexPoints([]*models.Point{inside}))
+
+		results, err := index.QueryBox(box)
+		require.NoError(t, err)
+		assert.Len(t, results, 1)
+		assert.Equal(t, 1, index.cache.count())
+
+		// Writes drop cached results
+		another := &models.Point{ID: "b", Location: &models.Location{Lat: 10.6, Lon: 10.6}}
+		require.NoError(t, index.IndexPoints([]*models.Point{another}))
+		assert.Equal(t, 0, index.cache.count())
+		results, err = index.QueryBox(box)
+		require.NoError(t, err)
+		assert.Len(t, results, 2)
+
+		// Least recently used entries are evicted beyond Size
+		for i := 0; i < 3; i++ {
+			_, err := index.QueryBox(models.BoundingBox{
+				BottomLeft: models.Location{Lat: float64(20 + i), Lon: 0},
+				TopRight:   models.Location{Lat: float64(20 + i), Lon: 1},
+			})
+			require.NoError(t, err)
+		}
+		assert.Equal(t, 2, index.cache.count())
+
+		// Expired entries are not served
+		key, _ := index.cache.widen(box)
+		_, err = index.QueryBox(box)
+		require.NoError(t, err)
+		_, ok := index.cache.get(key, index.state.Load())
+		assert.True(t, ok)
+		now = now.Add(time.Minute)
+		_, ok = index.cache.get(key, index.state.Load())
+		assert.False(t, ok)
+
+		index.Clear()
+		assert.Equal(t, 0, index.cache.count())
+		assert.Nil(t, NewGeoIndex(WithQueryCache(QueryCacheConfig{})).cache)
+	})
+}
+
+func TestContains(t *testing.T) {
+	points := worldPoints(3000, 1)
+	for name, opts := range map[string][]Option{
+		"partitioned":   nil,
+		"compact":       {WithCompactStorage()},
+		"compact codec": {WithCompactStorage(), WithIDCodec(IDCodec{Prefix: "point_"})},
+		"bloom":         {WithIDFilter(0.01)},
+		"compact bloom": {WithCompactStorage(), WithIDFilter(0.01)},
+		"geohash":       {WithGeohashStorage(0)},
+		"geohash bloom": {WithGeohashStorage(0), WithIDFilter(0.01)},
+		"s2":            {WithS2Storage()},
+		"s2 bloom":      {WithS2Storage(), WithIDFilter(0.01)},
+		"quadtree":      {WithQuadtreeStorage()},
+		"kd-tree":       {WithKDTreeStorage()},
+		"kd-tree bloom": {WithKDTreeStorage(), WithIDFilter(0.01)},
+		"grid":          {WithGridStorage(0)},
+		"space-time":    {WithSpaceTimeStorage("")},
+		"grid bloom":    {WithGridStorage(0), WithIDFilter(0.01)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			index := NewGeoIndexWithWorkers(4, opts...)
+			assert.False(t, index.Contains("point_0"))
+
+			// Index in batches so partitions hold several trees
+			for start := 0; start < len(points); start += 100 {
+				require.NoError(t, index.IndexPoints(points[start:start+100]))
+			}
+			require.NoError(t, index.IndexPoints([]*models.Point{
+				{ID: "other", Location: &models.Location{Lat: 1, Lon: 1}},
+			}))
+			for _, p := range points {
+				require.True(t, index.Contains(p.ID), p.ID)
+			}
+			assert.True(t, index.Contains("other"))
+
+			falsePositives := 0
+			for i := 0; i < 1000; i++ {
+				if index.Contains(fmt.Sprintf("missing_%d", i)) {
+					falsePositives++
+				}
+			}
+			if index.idFalsePositives == 0 {
+				assert.Zero(t, falsePositives)
+				assert.False(t, index.Contains("point_99999"))
+			} else {
+				assert.Less(t, falsePositives, 50)
+			}
+
+			index.Clear()
+			assert.False(t, index.Contains("other"))
+		})
+	}
+}
+
+func TestDistanceMatrix(t *testing.T) {
+	origins := queryCenters(7)
+	destinations := []models.Location{{Lat: 0, Lon: 0}, {Lat: 51.5, Lon: -0.12}, {Lat: -33.9, Lon: 151.2}}
+	matrix := DistanceMatrix(origins, destinations)
+	require.Len(t, matrix, len(origins))
+	for i, o := range origins {
+		require.Len(t, matrix[i], len(destinations))
+		for j, d := range destinations {
+			assert.InDelta(t, o.DistanceTo(d), matrix[i][j], 1e-9)
+		}
+	}
+	assert.Empty(t, DistanceMatrix(nil, destinations))
+	assert.Empty(t, DistanceMatrix(origins, nil)[0])
+
+	index := NewGeoIndexWithWorkers(4, WithDistanceUnit(models.Miles))
+	points := worldPoints(5000, 1)
+	require.NoError(t, index.IndexPoints(points))
+	rows, err := index.NearbyDistanceMatrix(origins, 300)
+	require.NoError(t, err)
+	require.Len(t, rows, len(origins))
+	for i, o := range origins {
+		want, err := index.QueryRadiusWithDistance(o, 300)
+		require.NoError(t, err)
+		assert.Equal(t, want, rows[i])
+	}
+
+	_, err = index.NearbyDistanceMatrix([]models.Location{{Lat: 0, Lon: 0}, {Lat: 100, Lon: 0}}, 10)
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+	assert.ErrorContains(t, err, "origin 1")
+}
+
+func TestAssignNearest(t *testing.T) {
+	sites := worldPoints(500, 3)
+	sites = append(sites,
+		&models.Point{ID: "no-location"},
+		&models.Point{ID: "east", Location: &models.Location{Lat: 10, Lon: 179.9}},
+	)
+	queries := append(queryCenters(300),
+		models.Location{Lat: 10, Lon: -179.95},
+		models.Location{Lat: 89.9, Lon: 0},
+		models.Location{Lat: -89.9, Lon: 45},
+	)
+
+	assignments, err := AssignNearest(sites, queries)
+	require.NoError(t, err)
+	require.Len(t, assignments, len(queries))
+	for i, q := range queries {
+		// Brute force: the first site at the smallest distance
+		want, wantKm := -1, math.Inf(1)
+		for j, site := range sites {
+			if site.Location != nil {
+				if km := q.DistanceTo(*site.Location); km < wantKm {
+					want, wantKm = j, km
+				}
+			}
+		}
+		assert.Equal(t, want, assignments[i].SiteIndex, i)
+		assert.Same(t, sites[want], assignments[i].Site)
+		assert.InDelta(t, wantKm, assignments[i].DistanceKm, 1e-9)
+	}
+
+	// Nearest across the antimeridian
+	assert.Equal(t, "east", assignments[300].Site.ID)
+
+	assignments, err = AssignNearest(sites, nil)
+	require.NoError(t, err)
+	assert.Empty(t, assignments)
+
+	_, err = AssignNearest(sites, []models.Location{{Lat: 0, Lon: 0}, {Lat: math.NaN()}})
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+	assert.ErrorContains(t, err, "query 1")
+	_, err = AssignNearest([]*models.Point{{ID: "no-location"}}, queries)
+	assert.ErrorIs(t, err, ErrEmptyIndex)
+}
+
+func TestCountByDistanceBands(t *testing.T) {
+	points := worldPoints(20000, 1)
+	center := models.Location{Lat: 10, Lon: 20}
+	bands := []float64{100, 500, 1000, 2500}
+
+	for name, opts := range map[string][]Option{
+		"partitioned": nil,
+		"compact":     {WithCompactStorage()},
+		"geohash":     {WithGeohashStorage(4)},
+		"s2":          {WithS2Storage()},
+		"quadtree":    {WithQuadtreeStorage()},
+		"kd-tree":     {WithKDTreeStorage()},
+		"grid":        {WithGridStorage(0)},
+		"space-time":  {WithSpaceTimeStorage("")},
+		"miles":       {WithDistanceUnit(models.Miles)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			index := NewGeoIndexWithWorkers(4, opts...)
+			require.NoError(t, index.IndexPoints(points))
+			counts, err := index.CountByDistanceBands(center, bands)
+			require.NoError(t, err)
+			require.Len(t, counts, len(bands))
+
+			// Cumulative counts match separate radius queries
+			var within int64
+			for i, band := range bands {
+				within += counts[i]
+				results, err := index.QueryRadius(center, band)
+				require.NoError(t, err)
+				assert.Equal(t, int64(len(results)), within, band)
+			}
+			assert.NotZero(t, within)
+		})
+	}
+
+	index := NewGeoIndex()
+	for _, bad := range [][]float64{nil, {-1}, {5, 5}, {10, 5}, {math.NaN()}} {
+		_, err := index.CountByDistanceBands(center, bad)
+		assert.ErrorIs(t, err, ErrInvalidQuery, bad)
+	}
+	_, err := index.CountByDistanceBands(models.Location{Lat: 95}, bands)
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+}
+
+func TestDensityGrid(t *testing.T) {
+	points := worldPoints(20000, 1)
+	box := models.BoundingBox{
+		BottomLeft: models.Location{Lat: -60, Lon: -120},
+		TopRight:   models.Location{Lat: 60, Lon: 120},
+	}
+
+	for name, opts := range map[string][]Option{
+		"partitioned": nil,
+		"compact":     {WithCompactStorage()},
+		"geohash":     {WithGeohashStorage(2)},
+		"s2":          {WithS2Storage()},
+		"quadtree":    {WithQuadtreeStorage()},
+		"kd-tree":     {WithKDTreeStorage()},
+		"grid":        {WithGridStorage(0)},
+		"space-time":  {WithSpaceTimeStorage("")},
+	} {
+		t.Run(name, func(t *testing.T) {
+			index := NewGeoIndexWithWorkers(4, opts...)
+			require.NoError(t, index.IndexPoints(points))
+			inBox, err := index.QueryBox(box)
+			require.NoError(t, err)
+
+			for _, projection := range []GridProjection{Equirectangular, WebMercator} {
+				grid, err := index.DensityGrid(box, 6, 8, projection)
+				require.NoError(t, err)
+				require.Len(t, grid.Counts, 6)
+				var total, highest int64
+				for _, row := range grid.Counts {
+					require.Len(t, row, 8)
+					for _, n := range row {
+						total += n
+						highest = max(highest, n)
+					}
+				}
+				assert.Equal(t, int64(len(inBox)), total)
+				assert.Equal(t, highest, grid.Max)
+			}
+
+			// Row 0 is the northern edge: a top-left cell holds exactly
+			// the points of its box
+			grid, err := index.DensityGrid(box, 4, 4, Equirectangular)
+			require.NoError(t, err)
+			corner, err := index.QueryBox(models.BoundingBox{
+				BottomLeft: models.Location{Lat: 30, Lon: -120},
+				TopRight:   models.Location{Lat: 60, Lon: -60},
+			})
+			require.NoError(t, err)
+			assert.InDelta(t, len(corner), grid.Counts[0][0], 2)
+
+			// Mercator rows are evenly spaced in y, so the equator splits an
+			// even number of rows of a symmetric box in half
+			grid, err = index.DensityGrid(box, 2, 1, WebMercator)
+			require.NoError(t, err)
+			north, err := index.QueryBox(models.BoundingBox{
+				BottomLeft: models.Location{Lat: 0, Lon: -120},
+				TopRight:   models.Location{Lat: 60, Lon: 120},
+			})
+			require.NoError(t, err)
+			assert.InDelta(t, len(north), grid.Counts[0][0], 2)
+		})
+	}
+
+	index := NewGeoIndex()
+	_, err := index.DensityGrid(box, 0, 4, Equirectangular)
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+	_, err = index.DensityGrid(models.BoundingBox{TopRight: models.Location{Lat: 10}}, 4, 4, Equirectangular)
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+	_, err = index.DensityGrid(models.BoundingBox{
+		BottomLeft: models.Location{Lat: 86, Lon: 0},
+		TopRight:   models.Location{Lat: 89, Lon: 10},
+	}, 4, 4, WebMercator)
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+}
+
+func TestDistanceUnit(t *testing.T) {
+	index := NewGeoIndex(WithDistanceUnit(models.NauticalMiles))
+	assert.Equal(t, models.NauticalMiles, index.DistanceUnit())
+	center := models.Location{Lat: 50, Lon: 0}
+	near := center.Destination(0, 1.852*10)
+	far := center.Destination(0, 1.852*30)
+	require.NoError(t, index.IndexPoints([]*models.Point{
+		{ID: "near", Location: &near},
+		{ID: "far", Location: &far},
+	}))
+
+	results, err := index.QueryRadiusWithDistance(center, 20)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "near", results[0].Point.ID)
+	assert.InDelta(t, 10, results[0].Distance, 1e-6)
+	assert.InDelta(t, 18.52, results[0].DistanceKm, 1e-6)
+	assert.Equal(t, models.NauticalMiles, results[0].Unit)
+
+	nearest := index.NearestNeighborsWithDistance(center, 2)
+	require.Len(t, nearest, 2)
+	assert.InDelta(t, 30, nearest[1].Distance, 1e-6)
+
+	_, err = NewGeoIndex(WithDistanceUnit("furlong")).QueryRadius(center, 1)
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+}
+
+func TestQueryRadiusHighLatitude(t *testing.T) {
+	// At 70°N a degree of longitude is ~38km, so a point 100km east lies well
+	// outside a box of ±radius/111km degrees
+	index := NewGeoIndex()
+	center := models.Location{Lat: 70, Lon: 20}
+	east := center.Destination(90, 100)
+	require.NoError(t, index.IndexPoints([]*models.Point{{ID: "east", Location: &east}}))
+
+	results, err := index.QueryRadius(center, 101)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "east", results[0].ID)
+}
+
+func TestQueryRadiusAntimeridian(t *testing.T) {
+	// The box around a circle at 179.95°E passes 180; its overhang holds
+	// points stored at western longitudes
+	center := models.Location{Lon: 179.95}
+	points := []*models.Point{
+		{ID: "east", Location: &models.Location{Lon: 179.9}},
+		{ID: "west", Location: &models.Location{Lon: -179.9}},
+		{ID: "far", Location: &models.Location{Lon: -179}},
+	}
+	ids := func(results []*models.Point) []string {
+		var ids []string
+		for _, p := range results {
+			ids = append(ids, p.ID)
+		}
+		return ids
+	}
+	for _, index := range []*GeoIndex{
+		NewGeoIndexWithWorkers(4),
+		NewGeoIndexWithWorkers(4, WithQueryCache(QueryCacheConfig{Size: 10})),
+	} {
+		require.NoError(t, index.IndexPoints(points))
+
+		results, err := index.QueryRadius(center, 50)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"east", "west"}, ids(results))
+
+		batch, err := index.QueryRadii([]models.Location{center}, 50)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"east", "west"}, ids(batch[0]))
+
+		// east is 5.6km away, west 16.7km
+		counts, err := index.CountByDistanceBands(center, []float64{10, 50})
+		require.NoError(t, err)
+		assert.Equal(t, []int64{1, 1}, counts)
+	}
+}
+
 func TestNearestNeighbors(t *testing.T) {
 	index := NewGeoIndex()
 	
@@ -142,12 +733,81 @@ func TestNearestNeighbors(t *testing.T) {
 	assert.Len(t, results, 3)
 	// First result should be the center point itself
 	assert.Equal(t, "1", results[0].ID)
+	
+	// Non-positive and huge n neither panic nor over-allocate
+	assert.Empty(t, index.NearestNeighbors(center, 0))
+	assert.Empty(t, index.NearestNeighbors(center, -1))
+	assert.Len(t, index.NearestNeighbors(center, 1<<62), len(points))
+}
+
+func TestNearest(t *testing.T) {
+	index := NewGeoIndex()
+	_, err := index.Nearest(models.Location{Lat: 37.7749, Lon: -122.4194})
+	assert.ErrorIs(t, err, ErrEmptyIndex)
+	
+	props := map[string]any{"name": "Ferry Building"}
+	points := []*models.Point{
+		{ID: "ferry", Location: &models.Location{Lat: 37.7955, Lon: -122.3937}, Properties: props},
+		{ID: "oakland", Location: &models.Location{Lat: 37.8044, Lon: -122.2712}},
+	}
+	require.NoError(t, index.IndexPoints(points))
+	
+	here := models.Location{Lat: 37.7936, Lon: -122.3958}
+	result, err := index.Nearest(here)
+	require.NoError(t, err)
+	assert.Equal(t, "ferry", result.Point.ID)
+	assert.Equal(t, props, result.Point.Properties)
+	assert.InDelta(t, here.DistanceTo(*points[0].Location), result.DistanceKm, 1e-9)
+}
+
+func TestLoadFromFileSwapsOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.gob")
+	saved := NewGeoIndexWithWorkers(4)
+	require.NoError(t, saved.IndexPoints(worldPoints(2000, 98)))
+	require.NoError(t, saved.SaveToFile(path))
+
+	for _, opts := range [][]Option{nil, {WithCompactStorage()}} {
+		index := NewGeoIndexWithWorkers(4, opts...)
+		require.NoError(t, index.IndexPoints(worldPoints(500, 99)))
+
+		// Readers see the old contents or the loaded ones, never an empty index
+		stop := make(chan struct{})
+		done := make(chan int64)
+		least := index.Count()
+		go func() {
+			for {
+				select {
+				case <-stop:
+					done <- least
+					return
+				default:
+					least = min(least, index.Count())
+				}
+			}
+		}()
+		for i := 0; i < 20; i++ {
+			require.NoError(t, index.LoadFromFile(path))
+		}
+		close(stop)
+		assert.Equal(t, int64(500), <-done)
+		assert.Equal(t, int64(2000), index.Count())
+		all, err := index.QueryBox(quadWorld)
+		require.NoError(t, err)
+		assert.Len(t, all, 2000)
+
+		// A failed load leaves the index as it was
+		require.NoError(t, os.WriteFile(path+".bad", []byte("not an index"), 0o644))
+		assert.Error(t, index.LoadFromFile(path+".bad"))
+		assert.Equal(t, int64(2000), index.Count())
+	}
 }
 
 func TestPersistence(t *testing.T) {
 	// Create and populate index
 	index1 := NewGeoIndex()
 	points := generateRandomPoints(100)
+	props := map[string]any{"name": "SF", "pop": 815201.0, "tags": []any{"city"}, "meta": map[string]any{"ok": true}}
+	points[0].Properties = props
 	err := index1.IndexPoints(points)
 	require.NoError(t, err)
 	
@@ -164,6 +824,20 @@ func TestPersistence(t *testing.T) {
 	// Verify counts match
 	assert.Equal(t, index1.Count(), index2.Count())
 	
+	stored, err := ReadPoints(tempFile)
+	require.NoError(t, err)
+	assert.Len(t, stored, len(points))
+	for _, p := range stored {
+		if p.ID == points[0].ID {
+			assert.Equal(t, props, p.Properties)
+		}
+	}
+
+	// Queries return the properties of the loaded points
+	nearest := index2.NearestNeighbors(*points[0].Location, 1)
+	require.Len(t, nearest, 1)
+	assert.Equal(t, props, nearest[0].Properties)
+	
 	// Verify query results match
 	box := models.BoundingBox{
 		BottomLeft: models.Location{Lat: 30, Lon: -120},
@@ -179,6 +853,111 @@ func TestPersistence(t *testing.T) {
 	assert.Equal(t, len(results1), len(results2))
 }
 
+func TestFileHeader(t *testing.T) {
+	index := NewGeoIndex()
+	require.NoError(t, index.IndexPoints([]*models.Point{
+		{ID: "SF", Location: &models.Location{Lat: 37.7749, Lon: -122.4194}},
+		{ID: "NYC", Location: &models.Location{Lat: 40.7128, Lon: -74.0060}},
+	}))
+
+	dir := t.TempDir()
+	path := dir + "/index.gob"
+	require.NoError(t, index.SaveToFile(path))
+
+	header, err := ReadHeader(path)
+	require.NoError(t, err)
+	assert.Equal(t, FileFormatVersion, header.Version)
+	assert.Equal(t, int64(2), header.Count)
+	assert.Equal(t, 37.7749, header.Bounds.BottomLeft.Lat)
+	assert.Equal(t, -74.0060, header.Bounds.TopRight.Lon)
+	assert.WithinDuration(t, time.Now(), header.CreatedAt, time.Minute)
+	require.NoError(t, VerifyFile(path))
+
+	// Flip a byte in the body
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	data[len(data)-1] ^= 0xff
+	corrupt := dir + "/corrupt.gob"
+	require.NoError(t, os.WriteFile(corrupt, data, 0644))
+	assert.ErrorIs(t, VerifyFile(corrupt), ErrCorrupt)
+	_, err = ReadPoints(corrupt)
+	assert.ErrorIs(t, err, ErrCorruptFile)
+
+	// Files written before the header existed still load
+	legacy := dir + "/legacy.gob"
+	file, err := os.Create(legacy)
+	require.NoError(t, err)
+	require.NoError(t, gob.NewEncoder(file).Encode(IndexData{
+		Points: []*models.Point{{ID: "SF", Location: &models.Location{Lat: 37.7749, Lon: -122.4194}}},
+		Count:  1,
+	}))
+	require.NoError(t, file.Close())
+
+	_, err = ReadHeader(legacy)
+	assert.ErrorIs(t, err, ErrNoHeader)
+	points, err := ReadPoints(legacy)
+	require.NoError(t, err)
+	assert.Len(t, points, 1)
+}
+
+func TestErrors(t *testing.T) {
+	index := NewGeoIndex()
+
+	_, err := index.Bounds()
+	assert.ErrorIs(t, err, ErrEmptyIndex)
+	require.NoError(t, index.IndexPoints([]*models.Point{{ID: "SF", Location: &models.Location{Lat: 37.7749, Lon: -122.4194}}}))
+	bounds, err := index.Bounds()
+	require.NoError(t, err)
+	assert.Equal(t, 37.7749, bounds.TopRight.Lat)
+
+	_, err = index.QueryBox(models.BoundingBox{
+		BottomLeft: models.Location{Lat: 38, Lon: -123},
+		TopRight:   models.Location{Lat: 37, Lon: -122},
+	})
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+	_, err = index.QueryBox(models.BoundingBox{BottomLeft: models.Location{Lat: math.NaN()}})
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+	_, err = index.QueryRadius(models.Location{Lat: 37, Lon: -122}, -1)
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+	_, err = index.QueryRadius(models.Location{Lat: 91}, 10)
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+
+	dir := t.TempDir()
+	err = index.LoadFromFile(dir + "/missing.gob")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	garbage := dir + "/garbage.gob"
+	require.NoError(t, os.WriteFile(garbage, []byte("not an index"), 0644))
+	assert.ErrorIs(t, index.LoadFromFile(garbage), ErrCorruptFile)
+}
+
+func TestStats(t *testing.T) {
+	index := NewGeoIndexWithWorkers(4)
+	
+	points := []*models.Point{
+		{ID: "SF", Location: &models.Location{Lat: 37.7749, Lon: -122.4194}},
+		{ID: "LA", Location: &models.Location{Lat: 34.0522, Lon: -118.2437}},
+		{ID: "Tokyo", Location: &models.Location{Lat: 35.6762, Lon: 139.6503}},
+	}
+	require.NoError(t, index.IndexPoints(points))
+	
+	stats := index.Stats()
+	assert.Equal(t, int64(3), stats.Count)
+	require.Len(t, stats.Partitions, 4)
+	assert.Equal(t, 2, stats.Partitions[0].Count)
+	assert.Equal(t, 0, stats.Partitions[1].Count)
+	assert.Nil(t, stats.Partitions[1].Bounds)
+	assert.Equal(t, 1, stats.Partitions[3].Count)
+	
+	require.NotNil(t, stats.Bounds)
+	assert.Equal(t, 34.0522, stats.Bounds.BottomLeft.Lat)
+	assert.Equal(t, -122.4194, stats.Bounds.BottomLeft.Lon)
+	assert.Equal(t, 37.7749, stats.Bounds.TopRight.Lat)
+	assert.Equal(t, 139.6503, stats.Bounds.TopRight.Lon)
+	assert.Greater(t, stats.EstimatedBytes, int64(0))
+}
+
 func TestConcurrentQueries(t *testing.T) {
 	index := NewGeoIndex()
 	points := generateRandomPoints(10000)
@@ -220,58 +999,388 @@ func TestConcurrentQueries(t *testing.T) {
 	}
 }
 
-func TestDistance(t *testing.T) {
-	testCases := []struct {
-		name     string
-		lat1     float64
-		lon1     float64
-		lat2     float64
-		lon2     float64
-		expected float64
-		delta    float64
-	}{
-		{
-			name:     "Same point",
-			lat1:     37.7749, lon1: -122.4194,
-			lat2:     37.7749, lon2: -122.4194,
-			expected: 0,
-			delta:    0.01,
-		},
-		{
-			name:     "SF to Oakland",
-			lat1:     37.7749, lon1: -122.4194,
-			lat2:     37.8044, lon2: -122.2712,
-			expected: 13.0, // Approximately 13km
-			delta:    1.0,
-		},
-		{
-			name:     "SF to LA",
-			lat1:     37.7749, lon1: -122.4194,
-			lat2:     34.0522, lon2: -118.2437,
-			expected: 559.0, // Approximately 559km
-			delta:    5.0,
-		},
-	}
-	
-	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
-			dist := Distance(tc.lat1, tc.lon1, tc.lat2, tc.lon2)
-			assert.InDelta(t, tc.expected, dist, tc.delta)
+func TestInsertDelete(t *testing.T) {
+	index := NewGeoIndexWithWorkers(4, WithVerification(nil))
+	require.NoError(t, index.IndexPoints(worldPoints(200, 91)))
+	require.NoError(t, index.Insert(&models.Point{ID: "extra", Location: &models.Location{Lat: 1, Lon: 1}}))
+	require.NoError(t, index.Insert(&models.Point{ID: "extra", Location: &models.Location{Lat: 2, Lon: -100}}))
+	assert.Equal(t, int64(202), index.Count())
+	assert.True(t, index.Contains("extra"))
+
+	removed, err := index.Delete("extra", "point_7", "missing")
+	require.NoError(t, err)
+	assert.Equal(t, 3, removed)
+	assert.Equal(t, int64(199), index.Count())
+	assert.False(t, index.Contains("extra"))
+	assert.False(t, index.Contains("point_7"))
+	all, err := index.QueryBox(quadWorld)
+	require.NoError(t, err)
+	assert.Len(t, all, 199)
+	assert.Zero(t, index.Discrepancies())
+
+	removed, err = index.Delete("extra")
+	require.NoError(t, err)
+	assert.Zero(t, removed)
+}
+
+func TestDeleteStorages(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"compact":    {WithCompactStorage()},
+		"geohash":    {WithGeohashStorage(3)},
+		"s2":         {WithS2Storage()},
+		"quadtree":   {WithQuadtreeStorage()},
+		"kd-tree":    {WithKDTreeStorage()},
+		"grid":       {WithGridStorage(0)},
+		"space-time": {WithSpaceTimeStorage("")},
+		"bloom":      {WithGeohashStorage(3), WithIDFilter(0.01)},
+		"codec":      {WithCompactStorage(), WithIDCodec(IDCodec{Prefix: "point_"})},
+	} {
+		t.Run(name, func(t *testing.T) {
+			index := NewGeoIndex(append(opts, WithVerification(nil))...)
+			points := worldPoints(500, 93)
+			require.NoError(t, index.IndexPoints(points[:300]))
+			require.NoError(t, index.IndexPoints(points[300:]))
+			require.NoError(t, index.Insert(&models.Point{ID: "extra", Location: &models.Location{Lat: 1, Lon: 1}}))
+			require.NoError(t, index.Insert(&models.Point{ID: "extra", Location: &models.Location{Lat: 2, Lon: -100}}))
+
+			removed, err := index.Delete("extra", "point_7", "point_450", "missing")
+			require.NoError(t, err)
+			assert.Equal(t, 4, removed)
+			assert.Equal(t, int64(498), index.Count())
+			assert.False(t, index.Contains("extra"))
+			assert.False(t, index.Contains("point_7"))
+			assert.True(t, index.Contains("point_8"))
+
+			all, err := index.QueryBox(quadWorld)
+			require.NoError(t, err)
+			assert.Len(t, all, 498)
+			for _, p := range all {
+				assert.NotContains(t, []string{"extra", "point_7", "point_450"}, p.ID)
+			}
+			within, err := index.QueryRadius(models.Location{Lat: 1, Lon: 1}, 50)
+			require.NoError(t, err)
+			for _, p := range within {
+				assert.NotEqual(t, "extra", p.ID)
+			}
+			assert.Zero(t, index.Discrepancies())
+
+			removed, err = index.Delete("extra")
+			require.NoError(t, err)
+			assert.Zero(t, removed)
 		})
 	}
 }
 
-// Helper function to generate random points
-func generateRandomPoints(n int) []*models.Point {
-	points := make([]*models.Point, n)
-	for i := 0; i < n; i++ {
-		points[i] = &models.Point{
-			ID: fmt.Sprintf("point_%d", i),
-			Location: &models.Location{
-				Lat: rand.Float64()*20 + 30,    // 30-50
-				Lon: rand.Float64()*40 - 120,   // -120 to -80
-			},
-		}
+func TestPartitionWriteIsolation(t *testing.T) {
+	index := NewGeoIndexWithWorkers(4)
+	require.NoError(t, index.IndexPoints(worldPoints(100, 92)))
+
+	// A writer stuck in the first partition, [-180, -90), holds its lock
+	index.partMu[0].Lock()
+	done := make(chan error)
+	go func() {
+		done <- index.Insert(&models.Point{ID: "east", Location: &models.Location{Lat: 0, Lon: 100}})
+	}()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("insert into another partition blocked")
+	}
+	results, err := index.QueryBox(quadWorld)
+	require.NoError(t, err)
+	assert.Len(t, results, 101)
+
+	go func() {
+		done <- index.Insert(&models.Point{ID: "west", Location: &models.Location{Lat: 0, Lon: -170}})
+	}()
+	select {
+	case <-done:
+		t.Fatal("insert into a locked partition did not wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+	index.partMu[0].Unlock()
+	require.NoError(t, <-done)
+	assert.Equal(t, int64(102), index.Count())
+}
+
+// TestConcurrentWrites runs writers of every partition against readers; run
+// it with -race
+func TestConcurrentWrites(t *testing.T) {
+	const writers, batches, batch = 8, 20, 10
+	index := NewGeoIndexWithWorkers(4, WithVerification(nil))
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for b := 0; b < batches; b++ {
+				points := worldPoints(batch, int64(w*batches+b))
+				for i, p := range points {
+					p.ID = fmt.Sprintf("w%d_b%d_%d", w, b, i)
+				}
+				assert.NoError(t, index.IndexPoints(points))
+				if b%4 == 3 {
+					removed, err := index.Delete(points[0].ID)
+					assert.NoError(t, err)
+					assert.Equal(t, 1, removed)
+				}
+			}
+		}(w)
+	}
+	var readers sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				_, err := index.QueryBox(models.BoundingBox{
+					BottomLeft: models.Location{Lat: -45, Lon: -90},
+					TopRight:   models.Location{Lat: 45, Lon: 90},
+				})
+				assert.NoError(t, err)
+				// Checked against the state each query searched, even mid-write
+				_, err = index.QueryRadius(models.Location{}, 5000)
+				assert.NoError(t, err)
+				index.NearestNeighbors(models.Location{}, 5)
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	readers.Wait()
+
+	want := writers * batches * batch
+	want -= writers * batches / 4
+	assert.Equal(t, int64(want), index.Count())
+	all, err := index.QueryBox(quadWorld)
+	require.NoError(t, err)
+	assert.Len(t, all, want)
+	assert.Zero(t, index.Discrepancies())
+}
+
+func TestSkipEmptyPartitions(t *testing.T) {
+	index := NewGeoIndexWithWorkers(8)
+	world := models.BoundingBox{
+		BottomLeft: models.Location{Lat: -90, Lon: -180},
+		TopRight:   models.Location{Lat: 90, Lon: 180},
+	}
+	assert.Empty(t, index.getRelevantPartitions(index.state.Load(), world))
+
+	// All points fall in the partition covering [0, 45)
+	require.NoError(t, index.IndexPoints([]*models.Point{
+		{ID: "a", Location: &models.Location{Lat: 10, Lon: 10}},
+		{ID: "b", Location: &models.Location{Lat: 20, Lon: 20}},
+	}))
+	assert.Equal(t, []int{4}, index.getRelevantPartitions(index.state.Load(), world))
+
+	results, err := index.QueryBox(world)
+	require.NoError(t, err)
+	assert.Len(t, results, 2)
+	nearest := index.NearestNeighbors(models.Location{Lat: 0, Lon: -10}, 5)
+	require.Len(t, nearest, 2)
+	assert.Equal(t, "a", nearest[0].ID)
+}
+
+func TestPartitionBounds(t *testing.T) {
+	index := NewGeoIndexWithWorkers(8)
+	require.NoError(t, index.IndexPoints([]*models.Point{
+		{ID: "a", Location: &models.Location{Lat: 10, Lon: 10}},
+	}))
+	require.NoError(t, index.IndexPoints([]*models.Point{
+		{ID: "b", Location: &models.Location{Lat: 20, Lon: 20}},
+		{ID: "c", Location: &models.Location{Lat: -30, Lon: 100}},
+	}))
+
+	state := index.state.Load()
+	assert.Equal(t, models.BoundingBox{
+		BottomLeft: models.Location{Lat: 10, Lon: 10},
+		TopRight:   models.Location{Lat: 20, Lon: 20},
+	}, state.partitions[4].bounds)
+
+	// The box overlaps the band of partition 4 but not its data
+	ocean := models.BoundingBox{
+		BottomLeft: models.Location{Lat: -40, Lon: 1},
+		TopRight:   models.Location{Lat: 5, Lon: 40},
+	}
+	assert.Empty(t, index.getRelevantPartitions(state, ocean))
+	results, err := index.QueryBox(ocean)
+	require.NoError(t, err)
+	assert.Empty(t, results)
+
+	// Edges of the data extent still count as overlapping
+	edge := models.BoundingBox{
+		BottomLeft: models.Location{Lat: 20, Lon: 20},
+		TopRight:   models.Location{Lat: 25, Lon: 25},
+	}
+	assert.Equal(t, []int{4}, index.getRelevantPartitions(state, edge))
+	results, err = index.QueryBox(edge)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "b", results[0].ID)
+}
+
+func TestProfilerLabels(t *testing.T) {
+	ctx := WithOperation(context.Background(), "viewport")
+
+	index := NewGeoIndex(WithProfilerLabels())
+	index.labeled(ctx, queryKindBox, 3, func(ctx context.Context) {
+		for key, want := range map[string]string{
+			LabelOperation: "viewport",
+			LabelQuery:     "box",
+			LabelPartition: "3",
+		} {
+			got, ok := pprof.Label(ctx, key)
+			assert.True(t, ok, key)
+			assert.Equal(t, want, got)
+		}
+	})
+	index.labeled(ctx, queryKindNearest, -1, func(ctx context.Context) {
+		_, ok := pprof.Label(ctx, LabelPartition)
+		assert.False(t, ok)
+	})
+
+	// Without the option fn gets ctx unchanged
+	NewGeoIndex().labeled(ctx, queryKindBox, 3, func(ctx context.Context) {
+		_, ok := pprof.Label(ctx, LabelQuery)
+		assert.False(t, ok)
+	})
+
+	// Labeled queries return the same results
+	points := generateRandomPoints(2000)
+	plain := NewGeoIndexWithWorkers(4)
+	require.NoError(t, plain.IndexPoints(points))
+	index = NewGeoIndexWithWorkers(4, WithProfilerLabels())
+	require.NoError(t, index.IndexPoints(points))
+	box := models.BoundingBox{
+		BottomLeft: models.Location{Lat: 30, Lon: -120},
+		TopRight:   models.Location{Lat: 40, Lon: -110},
+	}
+	want, err := plain.QueryBox(box)
+	require.NoError(t, err)
+	got, err := index.QueryBoxContext(ctx, box)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, want, got)
+
+	center := models.Location{Lat: 37.7749, Lon: -122.4194}
+	want, err = plain.QueryRadius(center, 200)
+	require.NoError(t, err)
+	got, err = index.QueryRadiusContext(ctx, center, 200)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, want, got)
+	assert.Equal(t, plain.NearestNeighbors(center, 10), index.NearestNeighborsContext(ctx, center, 10))
+}
+
+func TestReadsDuringWrites(t *testing.T) {
+	index := NewGeoIndexWithWorkers(4)
+	const batches, batchSize = 200, 5
+	world := models.BoundingBox{
+		BottomLeft: models.Location{Lat: -90, Lon: -180},
+		TopRight:   models.Location{Lat: 90, Lon: 180},
+	}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				// Readers only ever see whole batches
+				points, err := index.QueryBox(world)
+				assert.NoError(t, err)
+				assert.Zero(t, len(points)%batchSize)
+			}
+		}()
+	}
+
+	for b := 0; b < batches; b++ {
+		points := make([]*models.Point, batchSize)
+		for i := range points {
+			points[i] = &models.Point{
+				ID:       fmt.Sprintf("p%d_%d", b, i),
+				Location: &models.Location{Lat: rand.Float64()*180 - 90, Lon: rand.Float64()*360 - 180},
+			}
+		}
+		require.NoError(t, index.IndexPoints(points))
+	}
+	close(stop)
+	wg.Wait()
+
+	assert.Equal(t, int64(batches*batchSize), index.Count())
+	points, err := index.QueryBox(world)
+	require.NoError(t, err)
+	assert.Len(t, points, batches*batchSize)
+
+	// Small batches are merged so a partition holds O(log n) trees
+	for _, part := range index.state.Load().partitions {
+		assert.LessOrEqual(t, len(part.trees), 12)
+	}
+}
+
+func TestDistance(t *testing.T) {
+	testCases := []struct {
+		name     string
+		lat1     float64
+		lon1     float64
+		lat2     float64
+		lon2     float64
+		expected float64
+		delta    float64
+	}{
+		{
+			name:     "Same point",
+			lat1:     37.7749, lon1: -122.4194,
+			lat2:     37.7749, lon2: -122.4194,
+			expected: 0,
+			delta:    0.01,
+		},
+		{
+			name:     "SF to Oakland",
+			lat1:     37.7749, lon1: -122.4194,
+			lat2:     37.8044, lon2: -122.2712,
+			expected: 13.0, // Approximately 13km
+			delta:    1.0,
+		},
+		{
+			name:     "SF to LA",
+			lat1:     37.7749, lon1: -122.4194,
+			lat2:     34.0522, lon2: -118.2437,
+			expected: 559.0, // Approximately 559km
+			delta:    5.0,
+		},
+	}
+	
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dist := Distance(tc.lat1, tc.lon1, tc.lat2, tc.lon2)
+			assert.InDelta(t, tc.expected, dist, tc.delta)
+		})
+	}
+}
+
+// Helper function to generate random points
+func generateRandomPoints(n int) []*models.Point {
+	points := make([]*models.Point, n)
+	for i := 0; i < n; i++ {
+		points[i] = &models.Point{
+			ID: fmt.Sprintf("point_%d", i),
+			Location: &models.Location{
+				Lat: rand.Float64()*20 + 30,    // 30-50
+				Lon: rand.Float64()*40 - 120,   // -120 to -80
+			},
+		}
 	}
 	return points
 }
@@ -330,4 +1439,1912 @@ func BenchmarkNearestNeighbors(b *testing.B) {
 		center := models.Location{Lat: 37.5, Lon: -112.5}
 		_ = index.NearestNeighbors(center, 10)
 	}
-}
\ No newline at end of file
+}
+func TestDedupe(t *testing.T) {
+	points := []*models.Point{
+		{ID: "a", Location: &models.Location{Lat: 10, Lon: 10}},
+		{ID: "b", Location: &models.Location{Lat: 20, Lon: 20}, Properties: map[string]any{"name": "b"}},
+		{ID: "a", Location: &models.Location{Lat: 11, Lon: 11}},
+		{ID: "c", Location: &models.Location{Lat: 20.0001, Lon: 20.0001}, Properties: map[string]any{"name": "c", "kind": "cafe"}},
+		{ID: "d", Location: &models.Location{Lat: 0, Lon: 179.9999}},
+		{ID: "e", Location: &models.Location{Lat: 0, Lon: -179.9999}},
+	}
+
+	result, err := Dedupe(points, DedupeOptions{})
+	require.NoError(t, err)
+	require.Len(t, result.Points, 5)
+	assert.Equal(t, 1, result.Removed)
+	assert.Equal(t, 10.0, result.Points[0].Location.Lat)
+
+	result, err = Dedupe(points, DedupeOptions{Keep: KeepLast})
+	require.NoError(t, err)
+	assert.Equal(t, 11.0, result.Points[0].Location.Lat)
+
+	// Within 100m: b/c and d/e (across the antimeridian) collapse too
+	result, err = Dedupe(points, DedupeOptions{DistanceKm: 0.1, Keep: KeepMerged})
+	require.NoError(t, err)
+	require.Len(t, result.Points, 3)
+	assert.Equal(t, 3, result.Groups)
+	assert.Equal(t, 3, result.Removed)
+	assert.Equal(t, "a", result.Points[0].ID)
+	assert.InDelta(t, 10.5, result.Points[0].Location.Lat, 1e-9)
+	assert.Equal(t, "b", result.Points[1].ID)
+	assert.InDelta(t, 20.00005, result.Points[1].Location.Lat, 1e-9)
+	assert.Equal(t, map[string]any{"name": "b", "kind": "cafe"}, result.Points[1].Properties)
+	assert.Equal(t, "d", result.Points[2].ID)
+	assert.InDelta(t, 180, math.Abs(result.Points[2].Location.Lon), 1e-9)
+
+	_, err = Dedupe(points, DedupeOptions{Keep: "random"})
+	assert.Error(t, err)
+}
+
+func TestTrigDistance(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		a := models.Location{Lat: rng.Float64()*180 - 90, Lon: rng.Float64()*360 - 180}
+		b := models.Location{Lat: rng.Float64()*180 - 90, Lon: rng.Float64()*360 - 180}
+		assert.InDelta(t, a.DistanceTo(b), newTrigLocation(a).distanceKm(newTrigLocation(b)), 1e-9)
+	}
+}
+
+func TestVincentyDistance(t *testing.T) {
+	// Flinders Peak to Buninyong, the reference case from Vincenty's paper
+	d, err := VincentyDistance(-37.95103341666667, 144.42486788888889, -37.65282113888889, 143.92649552777778)
+	require.NoError(t, err)
+	assert.InDelta(t, 54.972271, d, 1e-6)
+
+	// Close to the spherical result for ordinary distances
+	sf, la := [2]float64{37.7749, -122.4194}, [2]float64{34.0522, -118.2437}
+	d, err = VincentyDistance(sf[0], sf[1], la[0], la[1])
+	require.NoError(t, err)
+	assert.InEpsilon(t, Distance(sf[0], sf[1], la[0], la[1]), d, 0.005)
+
+	d, err = VincentyDistance(10, 20, 10, 20)
+	require.NoError(t, err)
+	assert.Equal(t, 0.0, d)
+
+	_, err = VincentyDistance(0, 0, 0.5, 179.7)
+	assert.ErrorIs(t, err, ErrNoConvergence)
+}
+
+func TestTolerance(t *testing.T) {
+	// Points on the edges of the box [10, 20] x [10, 20] and just outside it
+	const eps = 1e-7
+	points := []*models.Point{
+		{ID: "corner", Location: &models.Location{Lat: 10, Lon: 10}},
+		{ID: "bottom", Location: &models.Location{Lat: 10, Lon: 15}},
+		{ID: "top", Location: &models.Location{Lat: 20, Lon: 15}},
+		{ID: "left", Location: &models.Location{Lat: 15, Lon: 10}},
+		{ID: "right", Location: &models.Location{Lat: 15, Lon: 20}},
+		{ID: "below", Location: &models.Location{Lat: 10 - eps, Lon: 15}},
+		{ID: "above", Location: &models.Location{Lat: 20 + eps, Lon: 15}},
+		{ID: "west", Location: &models.Location{Lat: 15, Lon: 10 - eps}},
+		{ID: "east", Location: &models.Location{Lat: 15, Lon: 20 + eps}},
+	}
+	box := models.BoundingBox{
+		BottomLeft: models.Location{Lat: 10, Lon: 10},
+		TopRight:   models.Location{Lat: 20, Lon: 20},
+	}
+	ids := func(points []*models.Point) []string {
+		var ids []string
+		for _, p := range points {
+			ids = append(ids, p.ID)
+		}
+		return ids
+	}
+
+	for _, tol := range []float64{0, DefaultTolerance, 0.01, 1, -1} {
+		t.Run(fmt.Sprint(tol), func(t *testing.T) {
+			index := NewGeoIndexWithWorkers(2, WithTolerance(tol))
+			require.NoError(t, index.IndexPoints(points))
+
+			results, err := index.QueryBox(box)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []string{"corner", "bottom", "top", "left", "right"}, ids(results))
+
+			// A zero-size box and a zero radius still find a point at their location
+			corner := *points[0].Location
+			results, err = index.QueryBox(models.BoundingBox{BottomLeft: corner, TopRight: corner})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"corner"}, ids(results))
+			results, err = index.QueryRadius(corner, 0)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"corner"}, ids(results))
+
+			stats := index.Stats()
+			assert.Equal(t, int64(len(points)), stats.Count)
+			require.NotNil(t, stats.Bounds)
+			assert.Equal(t, 20+eps, stats.Bounds.TopRight.Lat)
+		})
+	}
+}
+
+func TestCompactStorage(t *testing.T) {
+	points := worldPoints(5000, 7)
+	points[0].Properties = map[string]any{"name": "first"}
+	points = append(points, &models.Point{ID: "no-location"})
+
+	regular := NewGeoIndexWithWorkers(4, WithStableOrder())
+	compact := NewGeoIndex(WithCompactStorage(), WithStableOrder())
+	require.NoError(t, regular.IndexPoints(points[:2000]))
+	require.NoError(t, regular.IndexPoints(points[2000:]))
+	require.NoError(t, compact.IndexPoints(points[:2000]))
+	require.NoError(t, compact.IndexPoints(points[2000:]))
+	assert.Equal(t, regular.Count(), compact.Count())
+
+	ids := func(points []*models.Point) []string {
+		var ids []string
+		for _, p := range points {
+			ids = append(ids, p.ID)
+		}
+		return ids
+	}
+	for _, c := range queryCenters(50) {
+		box := models.BoundingBox{
+			BottomLeft: models.Location{Lat: c.Lat - 10, Lon: c.Lon - 10},
+			TopRight:   models.Location{Lat: c.Lat + 10, Lon: c.Lon + 10},
+		}
+		want, err := regular.QueryBox(box)
+		require.NoError(t, err)
+		got, err := compact.QueryBox(box)
+		require.NoError(t, err)
+		assert.Equal(t, ids(want), ids(got))
+
+		want, err = regular.QueryRadius(c, 800)
+		require.NoError(t, err)
+		got, err = compact.QueryRadius(c, 800)
+		require.NoError(t, err)
+		assert.Equal(t, ids(want), ids(got))
+
+		nearest := compact.NearestNeighborsWithDistance(c, 5)
+		require.Len(t, nearest, 5)
+		within, err := compact.QueryRadius(c, nearest[4].DistanceKm)
+		require.NoError(t, err)
+		assert.Len(t, within, 5)
+	}
+
+	results, err := compact.QueryRadius(*points[0].Location, 0)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "first", results[0].Properties["name"])
+
+	stats := compact.Stats()
+	assert.Equal(t, int64(5000), stats.Count)
+	require.Len(t, stats.Partitions, 1)
+	assert.Equal(t, 5000, stats.Partitions[0].Count)
+	assert.Equal(t, regular.Stats().Bounds, stats.Bounds)
+
+	// Files saved from compact storage load into a regular index
+	path := t.TempDir() + "/compact.gob"
+	require.NoError(t, compact.SaveToFile(path))
+	loaded := NewGeoIndex()
+	require.NoError(t, loaded.LoadFromFile(path))
+	assert.Equal(t, int64(5000), loaded.Count())
+
+	checkAntimeridian(t, WithCompactStorage())
+
+	compact.Clear()
+	assert.Zero(t, compact.Count())
+	assert.Empty(t, compact.NearestNeighbors(models.Location{}, 3))
+}
+
+func TestGeohashStorage(t *testing.T) {
+	points := worldPoints(5000, 7)
+	points = append(points,
+		&models.Point{ID: "no-location"},
+		&models.Point{ID: "north-pole", Location: &models.Location{Lat: 90, Lon: 180}},
+		&models.Point{ID: "antimeridian", Location: &models.Location{Lat: 10, Lon: -180}},
+	)
+
+	regular := NewGeoIndexWithWorkers(4, WithStableOrder())
+	hashed := NewGeoIndex(WithGeohashStorage(4), WithStableOrder())
+	require.NoError(t, regular.IndexPoints(points[:2000]))
+	require.NoError(t, regular.IndexPoints(points[2000:]))
+	require.NoError(t, hashed.IndexPoints(points[:2000]))
+	require.NoError(t, hashed.IndexPoints(points[2000:]))
+	assert.Equal(t, regular.Count(), hashed.Count())
+
+	centers := queryCenters(50)
+	for _, c := range append(centers, models.Location{Lat: 89.5, Lon: 179.5}, models.Location{Lat: 10, Lon: -179.9}) {
+		box := models.BoundingBox{
+			BottomLeft: models.Location{Lat: math.Max(c.Lat-10, -90), Lon: math.Max(c.Lon-10, -180)},
+			TopRight:   models.Location{Lat: math.Min(c.Lat+10, 90), Lon: math.Min(c.Lon+10, 180)},
+		}
+		want, err := regular.QueryBox(box)
+		require.NoError(t, err)
+		got, err := hashed.QueryBox(box)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+
+		wantNear, err := regular.QueryRadiusWithDistance(c, 800)
+		require.NoError(t, err)
+		gotNear, err := hashed.QueryRadiusWithDistance(c, 800)
+		require.NoError(t, err)
+		require.Len(t, gotNear, len(wantNear))
+		for i := range wantNear {
+			assert.Equal(t, wantNear[i].Point.ID, gotNear[i].Point.ID)
+		}
+
+		nearest := hashed.NearestNeighborsWithDistance(c, 5)
+		require.Len(t, nearest, 5)
+		within, err := hashed.QueryRadius(c, nearest[4].DistanceKm)
+		require.NoError(t, err)
+		assert.Len(t, within, 5)
+	}
+
+	// A box covering more cells than are occupied walks the buckets instead
+	world, err := hashed.QueryBox(models.BoundingBox{
+		BottomLeft: models.Location{Lat: -90, Lon: -180},
+		TopRight:   models.Location{Lat: 90, Lon: 180},
+	})
+	require.NoError(t, err)
+	assert.Len(t, world, 5002)
+
+	// Indexed points are returned as is
+	results, err := hashed.QueryRadius(*points[0].Location, 0)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Same(t, points[0], results[0])
+
+	assert.True(t, hashed.Contains("north-pole"))
+	assert.False(t, hashed.Contains("no-location"))
+	stats := hashed.Stats()
+	assert.Equal(t, int64(5002), stats.Count)
+	require.Len(t, stats.Partitions, 1)
+	assert.Equal(t, regular.Stats().Bounds, stats.Bounds)
+	assert.Less(t, stats.EstimatedBytes, regular.Stats().EstimatedBytes)
+
+	// The last storage option wins
+	assert.IsType(t, &compactStore{}, NewGeoIndex(WithGeohashStorage(0), WithCompactStorage()).state.Load().store)
+	assert.IsType(t, &geohashStore{}, NewGeoIndex(WithCompactStorage(), WithGeohashStorage(0)).state.Load().store)
+
+	checkAntimeridian(t, WithGeohashStorage(4))
+
+	hashed.Clear()
+	assert.Zero(t, hashed.Count())
+	assert.Empty(t, hashed.NearestNeighbors(models.Location{}, 3))
+}
+
+// checkAntimeridian checks that radius and nearest searches of an index built
+// with opts find a point across the antimeridian from a center at 179.95°E
+func checkAntimeridian(t *testing.T, opts ...Option) {
+	t.Helper()
+	index := NewGeoIndex(opts...)
+	// Enough points on each side that a search of each longitude band alone
+	// returns only its own, planar nearest ones
+	points := []*models.Point{{ID: "west", Location: &models.Location{Lon: -179.9}}}
+	for i := 0; i < 200; i++ {
+		offset := 1 + float64(i)/20
+		points = append(points,
+			&models.Point{ID: fmt.Sprintf("east_%d", i), Location: &models.Location{Lon: 180 - offset}},
+			&models.Point{ID: fmt.Sprintf("west_%d", i), Location: &models.Location{Lon: -180.2 + offset}},
+		)
+	}
+	require.NoError(t, index.IndexPoints(points))
+	center := models.Location{Lon: 179.95}
+
+	results, err := index.QueryRadius(center, 50)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "west", results[0].ID)
+
+	nearest := index.NearestNeighbors(center, 3)
+	require.Len(t, nearest, 3)
+	assert.Equal(t, []string{"west", "west_0", "west_1"}, []string{nearest[0].ID, nearest[1].ID, nearest[2].ID})
+}
+
+func TestNearestMatchesBruteForce(t *testing.T) {
+	// Points crowd the antimeridian and the polar caps, where planar lat/lon
+	// distance misranks neighbors
+	rng := rand.New(rand.NewSource(97))
+	var points []*models.Point
+	for i := 0; i < 3000; i++ {
+		loc := models.Location{Lat: rng.Float64()*180 - 90, Lon: 178 + rng.Float64()*4}
+		if i%2 == 1 {
+			loc = models.Location{Lat: 80 + rng.Float64()*10, Lon: rng.Float64()*360 - 180}
+			if i%4 == 3 {
+				loc.Lat = -loc.Lat
+			}
+		}
+		loc.Lon = models.NormalizeLongitude(loc.Lon)
+		points = append(points, &models.Point{ID: fmt.Sprintf("p%d", i), Location: &loc})
+	}
+	centers := []models.Location{{Lat: 0, Lon: 179.9}, {Lat: 0, Lon: -179.9}, {Lat: 85, Lon: 0}, {Lat: -85, Lon: 90}}
+	for i := 0; i < 100; i++ {
+		centers = append(centers,
+			models.Location{Lat: rng.Float64()*170 - 85, Lon: models.NormalizeLongitude(179 + rng.Float64()*2)},
+			models.Location{Lat: 85 - rng.Float64()*2, Lon: rng.Float64()*360 - 180},
+			models.Location{Lat: -85 + rng.Float64()*2, Lon: rng.Float64()*360 - 180},
+		)
+	}
+
+	for name, opts := range map[string][]Option{
+		"partitioned": nil,
+		"wrapped":     {WithLongitudeWrap()},
+		"compact":     {WithCompactStorage()},
+		"kd-tree":     {WithKDTreeStorage()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			index := NewGeoIndexWithWorkers(8, opts...)
+			require.NoError(t, index.IndexPoints(points))
+			for _, center := range centers {
+				want := make([]float64, len(points))
+				for i, p := range points {
+					want[i] = center.DistanceTo(*p.Location)
+				}
+				slices.Sort(want)
+
+				for _, k := range []int{1, 10} {
+					got := index.NearestNeighborsWithDistance(center, k)
+					require.Len(t, got, k)
+					for i, pd := range got {
+						assert.InDelta(t, want[i], pd.DistanceKm, 1e-9, "neighbor %d of %v, k=%d", i+1, center, k)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestS2Storage(t *testing.T) {
+	points := worldPoints(5000, 9)
+	points = append(points,
+		&models.Point{ID: "no-location"},
+		&models.Point{ID: "north-pole", Location: &models.Location{Lat: 90, Lon: 0}},
+		&models.Point{ID: "antimeridian", Location: &models.Location{Lat: 10, Lon: -180}},
+	)
+
+	regular := NewGeoIndexWithWorkers(4, WithStableOrder())
+	sphere := NewGeoIndex(WithS2Storage(), WithStableOrder())
+	require.NoError(t, regular.IndexPoints(points))
+	require.NoError(t, sphere.IndexPoints(points[:3000]))
+	require.NoError(t, sphere.IndexPoints(points[3000:]))
+	assert.Equal(t, regular.Count(), sphere.Count())
+
+	// bruteForce returns the IDs of the points within radiusKm of center,
+	// nearest first
+	bruteForce := func(center models.Location, radiusKm float64) []string {
+		var within []models.PointDistance
+		for _, p := range points {
+			if p.Location != nil {
+				if d := center.DistanceTo(*p.Location); d <= radiusKm {
+					within = append(within, models.PointDistance{Point: p, DistanceKm: d})
+				}
+			}
+		}
+		sortByDistance(within)
+		ids := make([]string, len(within))
+		for i, pd := range within {
+			ids[i] = pd.Point.ID
+		}
+		return ids
+	}
+	ids := func(results []models.PointDistance) []string {
+		out := make([]string, len(results))
+		for i, pd := range results {
+			out[i] = pd.Point.ID
+		}
+		return out
+	}
+
+	// Circles across the antimeridian and over the poles are exact
+	centers := append(queryCenters(30),
+		models.Location{Lat: 89.5, Lon: 179.5},
+		models.Location{Lat: 10, Lon: -179.9},
+		models.Location{Lat: -88, Lon: 45},
+	)
+	for _, c := range centers {
+		box := models.BoundingBox{
+			BottomLeft: models.Location{Lat: math.Max(c.Lat-10, -90), Lon: math.Max(c.Lon-10, -180)},
+			TopRight:   models.Location{Lat: math.Min(c.Lat+10, 90), Lon: math.Min(c.Lon+10, 180)},
+		}
+		want, err := regular.QueryBox(box)
+		require.NoError(t, err)
+		got, err := sphere.QueryBox(box)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, want, got)
+
+		near, err := sphere.QueryRadiusWithDistance(c, 800)
+		require.NoError(t, err)
+		assert.Equal(t, bruteForce(c, 800), ids(near))
+
+		nearest := sphere.NearestNeighborsWithDistance(c, 5)
+		require.Len(t, nearest, 5)
+		assert.Equal(t, bruteForce(c, nearest[4].DistanceKm), ids(nearest))
+	}
+
+	// Indexed points are returned as is
+	results, err := sphere.QueryRadius(*points[0].Location, 0)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Same(t, points[0], results[0])
+
+	assert.True(t, sphere.Contains("north-pole"))
+	assert.False(t, sphere.Contains("no-location"))
+	stats := sphere.Stats()
+	assert.Equal(t, int64(5002), stats.Count)
+	require.Len(t, stats.Partitions, 1)
+	assert.Equal(t, regular.Stats().Bounds, stats.Bounds)
+
+	// The last storage option wins
+	assert.IsType(t, &geohashStore{}, NewGeoIndex(WithS2Storage(), WithGeohashStorage(0)).state.Load().store)
+	assert.IsType(t, &compactStore{}, NewGeoIndex(WithS2Storage(), WithCompactStorage()).state.Load().store)
+	assert.IsType(t, &s2Store{}, NewGeoIndex(WithCompactStorage(), WithS2Storage()).state.Load().store)
+
+	sphere.Clear()
+	assert.Zero(t, sphere.Count())
+	assert.Empty(t, sphere.NearestNeighbors(models.Location{}, 3))
+}
+
+func TestQuadtreeStorage(t *testing.T) {
+	points := worldPoints(5000, 11)
+	points = append(points,
+		&models.Point{ID: "no-location"},
+		&models.Point{ID: "corner", Location: &models.Location{Lat: 90, Lon: 180}},
+		&models.Point{ID: "center", Location: &models.Location{Lat: 0, Lon: 0}},
+	)
+	// Coincident points beyond a leaf's capacity stop splitting at the
+	// maximum depth
+	for i := 0; i < 2*quadLeafSize; i++ {
+		points = append(points, &models.Point{ID: fmt.Sprintf("dup_%d", i), Location: &models.Location{Lat: 12.5, Lon: -45.25}})
+	}
+
+	regular := NewGeoIndexWithWorkers(4, WithStableOrder())
+	quad := NewGeoIndex(WithQuadtreeStorage(), WithStableOrder())
+	require.NoError(t, regular.IndexPoints(points))
+	for i := 0; i < len(points); i += 1000 {
+		require.NoError(t, quad.IndexPoints(points[i:min(i+1000, len(points))]))
+	}
+	assert.Equal(t, regular.Count(), quad.Count())
+
+	for _, c := range queryCenters(50) {
+		box := models.BoundingBox{
+			BottomLeft: models.Location{Lat: math.Max(c.Lat-10, -90), Lon: math.Max(c.Lon-10, -180)},
+			TopRight:   models.Location{Lat: math.Min(c.Lat+10, 90), Lon: math.Min(c.Lon+10, 180)},
+		}
+		want, err := regular.QueryBox(box)
+		require.NoError(t, err)
+		got, err := quad.QueryBox(box)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, want, got)
+
+		wantNear, err := regular.QueryRadiusWithDistance(c, 800)
+		require.NoError(t, err)
+		gotNear, err := quad.QueryRadiusWithDistance(c, 800)
+		require.NoError(t, err)
+		require.Len(t, gotNear, len(wantNear))
+		for i := range wantNear {
+			assert.Equal(t, wantNear[i].Point.ID, gotNear[i].Point.ID)
+		}
+
+		nearest := quad.NearestNeighborsWithDistance(c, 5)
+		require.Len(t, nearest, 5)
+		within, err := quad.QueryRadius(c, nearest[4].DistanceKm)
+		require.NoError(t, err)
+		assert.Len(t, within, 5)
+	}
+
+	dups, err := quad.QueryRadius(models.Location{Lat: 12.5, Lon: -45.25}, 0)
+	require.NoError(t, err)
+	assert.Len(t, dups, 2*quadLeafSize)
+	edges, err := quad.QueryBox(models.BoundingBox{TopRight: models.Location{Lat: 90, Lon: 180}})
+	require.NoError(t, err)
+	assert.Contains(t, edges, points[5001])
+	assert.Contains(t, edges, points[5002])
+
+	assert.True(t, quad.Contains("corner"))
+	assert.False(t, quad.Contains("no-location"))
+	stats := quad.Stats()
+	assert.Equal(t, regular.Count(), stats.Count)
+	require.Len(t, stats.Partitions, 1)
+	assert.Equal(t, quadMaxDepth+1, stats.Partitions[0].Depth)
+	assert.Equal(t, regular.Stats().Bounds, stats.Bounds)
+
+	// Earlier snapshots are not changed by later inserts
+	before := quad.state.Load()
+	require.NoError(t, quad.IndexPoints(worldPoints(100, 12)))
+	assert.Equal(t, int64(5002+2*quadLeafSize), before.count)
+	all, err := quad.QueryBox(quadWorld)
+	require.NoError(t, err)
+	assert.Len(t, all, 5102+2*quadLeafSize)
+
+	assert.IsType(t, &s2Store{}, NewGeoIndex(WithQuadtreeStorage(), WithS2Storage()).state.Load().store)
+
+	// One leaf holds points on both sides of the antimeridian
+	checkAntimeridian(t, WithQuadtreeStorage())
+
+	quad.Clear()
+	assert.Zero(t, quad.Count())
+	assert.Empty(t, quad.NearestNeighbors(models.Location{}, 3))
+}
+
+func TestKDTreeStorage(t *testing.T) {
+	points := worldPoints(5000, 13)
+	points = append(points,
+		&models.Point{ID: "no-location"},
+		&models.Point{ID: "south-pole", Location: &models.Location{Lat: -90, Lon: 0}},
+		&models.Point{ID: "antimeridian", Location: &models.Location{Lat: -20, Lon: 180}},
+	)
+	for i := 0; i < 100; i++ {
+		points = append(points, &models.Point{ID: fmt.Sprintf("dup_%d", i), Location: &models.Location{Lat: 51.5, Lon: -0.1}})
+	}
+
+	regular := NewGeoIndexWithWorkers(4, WithStableOrder())
+	kd := NewGeoIndex(WithKDTreeStorage(), WithStableOrder())
+	require.NoError(t, regular.IndexPoints(points))
+	require.NoError(t, kd.IndexPoints(points[:2500]))
+	require.NoError(t, kd.IndexPoints(points[2500:]))
+	assert.Equal(t, regular.Count(), kd.Count())
+
+	bruteForce := func(center models.Location, radiusKm float64) []string {
+		var within []models.PointDistance
+		for _, p := range points {
+			if p.Location != nil {
+				if d := center.DistanceTo(*p.Location); d <= radiusKm {
+					within = append(within, models.PointDistance{Point: p, DistanceKm: d})
+				}
+			}
+		}
+		sortByDistance(within)
+		ids := make([]string, len(within))
+		for i, pd := range within {
+			ids[i] = pd.Point.ID
+		}
+		return ids
+	}
+	ids := func(results []models.PointDistance) []string {
+		out := make([]string, len(results))
+		for i, pd := range results {
+			out[i] = pd.Point.ID
+		}
+		return out
+	}
+
+	centers := append(queryCenters(30),
+		models.Location{Lat: -89.5, Lon: 100},
+		models.Location{Lat: -20, Lon: -179.9},
+	)
+	for _, c := range centers {
+		box := models.BoundingBox{
+			BottomLeft: models.Location{Lat: math.Max(c.Lat-10, -90), Lon: math.Max(c.Lon-10, -180)},
+			TopRight:   models.Location{Lat: math.Min(c.Lat+10, 90), Lon: math.Min(c.Lon+10, 180)},
+		}
+		want, err := regular.QueryBox(box)
+		require.NoError(t, err)
+		got, err := kd.QueryBox(box)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, want, got)
+
+		near, err := kd.QueryRadiusWithDistance(c, 800)
+		require.NoError(t, err)
+		assert.Equal(t, bruteForce(c, 800), ids(near))
+
+		nearest := kd.NearestNeighborsWithDistance(c, 5)
+		require.Len(t, nearest, 5)
+		assert.Equal(t, bruteForce(c, nearest[4].DistanceKm), ids(nearest))
+	}
+
+	// Coincident points are all found, in ID order
+	dups := kd.NearestNeighborsWithDistance(models.Location{Lat: 51.5, Lon: -0.1}, 5)
+	assert.Equal(t, []string{"dup_0", "dup_1", "dup_10", "dup_11", "dup_12"}, ids(dups))
+	for _, d := range dups {
+		assert.Zero(t, d.DistanceKm)
+	}
+
+	// Boxes spanning every longitude and reaching the poles
+	world, err := kd.QueryBox(models.BoundingBox{
+		BottomLeft: models.Location{Lat: -90, Lon: -180},
+		TopRight:   models.Location{Lat: 90, Lon: 180},
+	})
+	require.NoError(t, err)
+	assert.Len(t, world, int(regular.Count()))
+
+	assert.True(t, kd.Contains("south-pole"))
+	assert.False(t, kd.Contains("no-location"))
+	stats := kd.Stats()
+	assert.Equal(t, regular.Count(), stats.Count)
+	require.Len(t, stats.Partitions, 1)
+	assert.Equal(t, 13, stats.Partitions[0].Depth)
+	assert.Less(t, stats.EstimatedBytes, regular.Stats().EstimatedBytes)
+
+	kd.Clear()
+	assert.Zero(t, kd.Count())
+	assert.Empty(t, kd.NearestNeighbors(models.Location{}, 3))
+}
+
+func TestGridStorage(t *testing.T) {
+	// A dense city dataset, then a second city outside the grid
+	rng := rand.New(rand.NewSource(17))
+	city := func(prefix string, n int, lat, lon float64) []*models.Point {
+		points := make([]*models.Point, n)
+		for i := range points {
+			points[i] = &models.Point{
+				ID:       fmt.Sprintf("%s_%d", prefix, i),
+				Location: &models.Location{Lat: lat + rng.Float64()*0.3, Lon: lon + rng.Float64()*0.4},
+			}
+		}
+		return points
+	}
+	paris := city("paris", 5000, 48.7, 2.2)
+	london := city("london", 2000, 51.35, -0.3)
+	paris = append(paris, &models.Point{ID: "no-location"})
+	for i := 0; i < 50; i++ {
+		paris = append(paris, &models.Point{ID: fmt.Sprintf("dup_%d", i), Location: &models.Location{Lat: 48.85, Lon: 2.35}})
+	}
+
+	regular := NewGeoIndexWithWorkers(4, WithStableOrder())
+	grid := NewGeoIndex(WithGridStorage(0), WithStableOrder())
+	require.NoError(t, regular.IndexPoints(append(slices.Clone(paris), london...)))
+	for i := 0; i < len(paris); i += 1000 {
+		require.NoError(t, grid.IndexPoints(paris[i:min(i+1000, len(paris))]))
+	}
+	before := grid.state.Load()
+	require.NoError(t, grid.IndexPoints(london))
+	assert.Equal(t, regular.Count(), grid.Count())
+
+	// The cell size comes from the first batch and survives the rebuild
+	cellDeg := SuggestGridCellSize(paris[:1000])
+	assert.Equal(t, cellDeg, before.store.(*uniformGrid).cellDeg)
+	assert.Equal(t, cellDeg, grid.state.Load().store.(*uniformGrid).cellDeg)
+	assert.True(t, grid.state.Load().store.(*uniformGrid).covers(models.BoundingBox{
+		BottomLeft: models.Location{Lat: 48.7, Lon: -0.3},
+		TopRight:   models.Location{Lat: 51.65, Lon: 2.6},
+	}))
+	// Earlier snapshots are not changed by later inserts
+	assert.Equal(t, int64(5050), before.count)
+	assert.False(t, before.store.(*uniformGrid).covers(models.BoundingBox{BottomLeft: *london[0].Location, TopRight: *london[0].Location}))
+
+	for _, c := range []models.Location{{Lat: 48.8, Lon: 2.3}, {Lat: 48.72, Lon: 2.58}, {Lat: 51.5, Lon: -0.12}, {Lat: 50, Lon: 1}, {Lat: 40, Lon: -3}} {
+		box := models.BoundingBox{
+			BottomLeft: models.Location{Lat: c.Lat - 0.05, Lon: c.Lon - 0.05},
+			TopRight:   models.Location{Lat: c.Lat + 0.05, Lon: c.Lon + 0.05},
+		}
+		want, err := regular.QueryBox(box)
+		require.NoError(t, err)
+		got, err := grid.QueryBox(box)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, want, got)
+
+		wantNear, err := regular.QueryRadiusWithDistance(c, 5)
+		require.NoError(t, err)
+		gotNear, err := grid.QueryRadiusWithDistance(c, 5)
+		require.NoError(t, err)
+		require.Len(t, gotNear, len(wantNear))
+		for i := range wantNear {
+			assert.Equal(t, wantNear[i].Point.ID, gotNear[i].Point.ID)
+		}
+
+		nearest := grid.NearestNeighborsWithDistance(c, 5)
+		require.Len(t, nearest, 5)
+		within, err := grid.QueryRadius(c, nearest[4].DistanceKm)
+		require.NoError(t, err)
+		assert.Len(t, within, 5)
+	}
+	// Far from the data the search widens until it covers the grid
+	assert.Len(t, grid.NearestNeighbors(models.Location{Lat: -45, Lon: 170}, 3), 3)
+
+	dups, err := grid.QueryRadius(models.Location{Lat: 48.85, Lon: 2.35}, 0)
+	require.NoError(t, err)
+	assert.Len(t, dups, 50)
+	assert.True(t, grid.Contains("london_0"))
+	assert.False(t, grid.Contains("no-location"))
+	stats := grid.Stats()
+	assert.Equal(t, regular.Count(), stats.Count)
+	require.Len(t, stats.Partitions, 1)
+	assert.Equal(t, regular.Stats().Bounds, stats.Bounds)
+
+	// Cells too small for the extent are coarsened to fit
+	coarse := NewGeoIndex(WithGridStorage(1e-6))
+	require.NoError(t, coarse.IndexPoints(worldPoints(1000, 18)))
+	g := coarse.state.Load().store.(*uniformGrid)
+	assert.LessOrEqual(t, g.rows*g.cols, gridMaxCells)
+	assert.Greater(t, g.cellDeg, 1e-6)
+	all, err := coarse.QueryBox(quadWorld)
+	require.NoError(t, err)
+	assert.Len(t, all, 1000)
+
+	assert.IsType(t, &s2Store{}, NewGeoIndex(WithGridStorage(0), WithS2Storage()).state.Load().store)
+
+	// The columns at both ends of the grid meet at the antimeridian, also
+	// when a single column holds both sides
+	checkAntimeridian(t, WithGridStorage(0.5))
+	checkAntimeridian(t, WithGridStorage(360))
+
+	grid.Clear()
+	assert.Zero(t, grid.Count())
+	assert.Empty(t, grid.NearestNeighbors(models.Location{}, 3))
+}
+
+func TestSuggestGridCellSize(t *testing.T) {
+	assert.Equal(t, DefaultGridCellDeg, SuggestGridCellSize(nil))
+	assert.Equal(t, DefaultGridCellDeg, SuggestGridCellSize([]*models.Point{
+		{ID: "a", Location: &models.Location{Lat: 1, Lon: 1}},
+		{ID: "b", Location: &models.Location{Lat: 1, Lon: 1}},
+		{ID: "c"},
+	}))
+
+	// 10,000 points spread evenly over a 1x1 degree square get cells holding
+	// about 16 points each
+	var uniform []*models.Point
+	for i := 0; i < 100; i++ {
+		for j := 0; j < 100; j++ {
+			uniform = append(uniform, &models.Point{ID: fmt.Sprintf("%d_%d", i, j), Location: &models.Location{Lat: float64(i) / 99, Lon: float64(j) / 99}})
+		}
+	}
+	assert.InDelta(t, 0.04, SuggestGridCellSize(uniform), 0.001)
+
+	// The same points in two distant clusters get cells sized for the
+	// clusters, not for the empty space between them: the bounding box alone
+	// would give cells of about 1.2 degrees
+	var clustered []*models.Point
+	for i, p := range uniform {
+		loc := models.Location{Lat: p.Location.Lat / 2, Lon: p.Location.Lon / 2}
+		if i%2 == 1 {
+			loc.Lat += 30
+			loc.Lon += 30
+		}
+		clustered = append(clustered, &models.Point{ID: p.ID, Location: &loc})
+	}
+	assert.Less(t, SuggestGridCellSize(clustered), 0.1)
+
+	// Points along a line still get cells a fraction of its length
+	var line []*models.Point
+	for i := 0; i < 1000; i++ {
+		line = append(line, &models.Point{ID: fmt.Sprint(i), Location: &models.Location{Lat: 10, Lon: float64(i) / 100}})
+	}
+	cell := SuggestGridCellSize(line)
+	assert.Greater(t, cell, 0.0)
+	assert.Less(t, cell, 1.0)
+}
+
+func TestSpaceTimeStorage(t *testing.T) {
+	// An hour of events over a city, one every second, timed every way a
+	// property can hold a time
+	end := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	rng := rand.New(rand.NewSource(31))
+	var points []*models.Point
+	for i := 0; i < 3600; i++ {
+		at := end.Add(-time.Duration(i) * time.Second)
+		var ts any
+		switch i % 3 {
+		case 0:
+			ts = at
+		case 1:
+			ts = at.Format(time.RFC3339)
+		case 2:
+			ts = float64(at.Unix())
+		}
+		points = append(points, &models.Point{
+			ID:         fmt.Sprintf("event_%d", i),
+			Location:   &models.Location{Lat: 40.6 + rng.Float64()*0.3, Lon: -74.1 + rng.Float64()*0.3},
+			Properties: map[string]any{"seen": ts},
+		})
+	}
+	points = append(points, &models.Point{ID: "untimed", Location: &models.Location{Lat: 40.7, Lon: -74}})
+
+	regular := NewGeoIndexWithWorkers(4, WithStableOrder())
+	require.NoError(t, regular.IndexPoints(points))
+	index := NewGeoIndex(WithSpaceTimeStorage("seen"), WithStableOrder())
+	for i := 0; i < len(points); i += 500 {
+		require.NoError(t, index.IndexPoints(points[i:min(i+500, len(points))]))
+	}
+	assert.Equal(t, regular.Count(), index.Count())
+	assert.True(t, index.Contains("untimed"))
+	assert.False(t, index.Contains("event_9999"))
+
+	box := models.BoundingBox{
+		BottomLeft: models.Location{Lat: 40.65, Lon: -74.05},
+		TopRight:   models.Location{Lat: 40.8, Lon: -73.9},
+	}
+	all, err := regular.QueryBox(box)
+	require.NoError(t, err)
+	got, err := index.QueryBox(box)
+	require.NoError(t, err)
+	assert.Equal(t, all, got)
+
+	// The last 15 minutes of the area, bounds included
+	recent, err := index.QueryBoxTime(box, end.Add(-15*time.Minute), end)
+	require.NoError(t, err)
+	var want []*models.Point
+	for _, p := range all {
+		var i int
+		if _, err := fmt.Sscanf(p.ID, "event_%d", &i); err == nil && i <= 900 {
+			want = append(want, p)
+		}
+	}
+	assert.Equal(t, want, recent)
+	assert.NotEmpty(t, recent)
+	assert.Less(t, len(recent), len(all))
+	empty, err := index.QueryBoxTime(box, end.Add(time.Second), end.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, empty)
+
+	_, err = index.QueryBoxTime(box, end, end.Add(-time.Minute))
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+	_, err = regular.QueryBoxTime(box, end.Add(-time.Minute), end)
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+
+	c := models.Location{Lat: 40.7, Lon: -74}
+	wantNear, err := regular.QueryRadiusWithDistance(c, 3)
+	require.NoError(t, err)
+	gotNear, err := index.QueryRadiusWithDistance(c, 3)
+	require.NoError(t, err)
+	assert.Equal(t, wantNear, gotNear)
+	nearest := index.NearestNeighborsWithDistance(c, 5)
+	require.Len(t, nearest, 5)
+	assert.Equal(t, "untimed", nearest[0].Point.ID)
+	within, err := index.QueryRadius(c, nearest[4].DistanceKm)
+	require.NoError(t, err)
+	assert.Len(t, within, 5)
+	assert.Len(t, index.NearestNeighbors(models.Location{Lat: -40, Lon: 100}, 3), 3)
+
+	stats := index.Stats()
+	assert.Equal(t, regular.Count(), stats.Count)
+	assert.Equal(t, regular.Stats().Bounds, stats.Bounds)
+
+	checkAntimeridian(t, WithSpaceTimeStorage("seen"))
+
+	index.Clear()
+	assert.Zero(t, index.Count())
+	assert.Empty(t, index.NearestNeighbors(c, 3))
+}
+
+func TestSnapshot(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"partitions": {WithStableOrder()},
+		"compact":    {WithStableOrder(), WithCompactStorage()},
+		"cached":     {WithStableOrder(), WithQueryCache(QueryCacheConfig{Size: 16})},
+	} {
+		t.Run(name, func(t *testing.T) {
+			index := NewGeoIndexWithWorkers(4, opts...)
+			require.NoError(t, index.IndexPoints(worldPoints(2000, 41)))
+			box := models.BoundingBox{
+				BottomLeft: models.Location{Lat: -30, Lon: -60},
+				TopRight:   models.Location{Lat: 30, Lon: 60},
+			}
+			want, err := index.QueryBox(box)
+			require.NoError(t, err)
+
+			snap := index.BeginSnapshot()
+			defer index.EndSnapshot(snap)
+
+			// Writers carry on while the snapshot is read
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 10; i++ {
+					assert.NoError(t, index.IndexPoints(worldPoints(100, int64(i))))
+				}
+			}()
+			got, err := snap.QueryBox(box)
+			require.NoError(t, err)
+			assert.Equal(t, want, got)
+			wg.Wait()
+			index.Clear()
+			assert.Zero(t, index.Count())
+
+			assert.Equal(t, int64(2000), snap.Count())
+			got, err = snap.QueryBox(box)
+			require.NoError(t, err)
+			assert.Equal(t, want, got)
+			n := 0
+			require.NoError(t, snap.ForEach(func(p *models.Point) bool {
+				n++
+				return true
+			}))
+			assert.Equal(t, 2000, n)
+			n = 0
+			require.NoError(t, snap.ForEach(func(p *models.Point) bool {
+				n++
+				return n < 10
+			}))
+			assert.Equal(t, 10, n)
+
+			path := filepath.Join(t.TempDir(), "snapshot.gob")
+			require.NoError(t, snap.SaveToFile(path))
+			loaded := NewGeoIndex(WithStableOrder())
+			require.NoError(t, loaded.LoadFromFile(path))
+			got, err = loaded.QueryBox(box)
+			require.NoError(t, err)
+			assert.Equal(t, want, got)
+
+			_, err = snap.QueryBox(models.BoundingBox{BottomLeft: box.TopRight, TopRight: box.BottomLeft})
+			assert.ErrorIs(t, err, ErrInvalidQuery)
+			index.EndSnapshot(snap)
+			assert.Zero(t, snap.Count())
+			_, err = snap.QueryBox(box)
+			assert.ErrorIs(t, err, ErrSnapshotEnded)
+			assert.ErrorIs(t, snap.ForEach(func(*models.Point) bool { return true }), ErrSnapshotEnded)
+			assert.ErrorIs(t, snap.SaveToFile(path), ErrSnapshotEnded)
+		})
+	}
+}
+
+func TestVerification(t *testing.T) {
+	rng := rand.New(rand.NewSource(43))
+	var points []*models.Point
+	for i := 0; i < 3000; i++ {
+		points = append(points, &models.Point{
+			ID:       fmt.Sprintf("p_%d", i),
+			Location: &models.Location{Lat: -5 + rng.Float64()*10, Lon: 5 + rng.Float64()*10, Alt: rng.Float64() * 3000},
+		})
+	}
+	box := models.BoundingBox{
+		BottomLeft: models.Location{Lat: -2, Lon: 8},
+		TopRight:   models.Location{Lat: 2, Lon: 12},
+	}
+	center := models.Location{Lat: 0, Lon: 10}
+
+	for name, opts := range map[string][]Option{
+		"partitioned": {},
+		"compact":     {WithCompactStorage()},
+		"geohash":     {WithGeohashStorage(0)},
+		"s2":          {WithS2Storage()},
+		"quadtree":    {WithQuadtreeStorage()},
+		"kd-tree":     {WithKDTreeStorage()},
+		"grid":        {WithGridStorage(0)},
+		"space-time":  {WithSpaceTimeStorage("")},
+		"cached":      {WithQueryCache(QueryCacheConfig{Size: 10, Quantum: 1})},
+		"altitude":    {WithAltitude()},
+		"wrapped":     {WithLongitudeWrap()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			index := NewGeoIndexWithWorkers(4, append(opts, WithVerification(nil))...)
+			for i := 0; i < len(points); i += 1000 {
+				require.NoError(t, index.IndexPoints(points[i:i+1000]))
+			}
+			for round := 0; round < 2; round++ {
+				_, err := index.QueryBox(box)
+				require.NoError(t, err)
+				_, err = index.QueryRadiusWithDistance(center, 150)
+				require.NoError(t, err)
+				index.NearestNeighbors(center, 10)
+			}
+			assert.Zero(t, index.Discrepancies())
+		})
+	}
+
+	// A point missing from the storage shows up in every kind of query
+	var found []Discrepancy
+	index := NewGeoIndex(WithVerification(func(d Discrepancy) { found = append(found, d) }))
+	require.NoError(t, index.IndexPoints(points))
+	lost := &models.Point{ID: "lost", Location: &models.Location{Lat: 0, Lon: 10}}
+	state := *index.state.Load()
+	state.shadow = append(slices.Clip(state.shadow), lost)
+	index.state.Store(&state)
+
+	results, err := index.QueryBox(box)
+	require.NoError(t, err)
+	assert.NotEmpty(t, results)
+	_, err = index.QueryRadius(center, 10)
+	require.NoError(t, err)
+	assert.NotEqual(t, "lost", index.NearestNeighbors(center, 1)[0].ID)
+	require.Len(t, found, 3)
+	for i, kind := range []string{"box", "radius"} {
+		assert.Equal(t, Discrepancy{Query: kind, Missing: []string{"lost"}}, found[i])
+	}
+	assert.Equal(t, []string{"lost"}, found[2].Missing)
+	assert.Len(t, found[2].Extra, 1)
+	assert.Equal(t, int64(3), index.Discrepancies())
+
+	// Without a report function, box and radius queries fail
+	strict := NewGeoIndex(WithVerification(nil))
+	require.NoError(t, strict.IndexPoints(points[:10]))
+	state = *strict.state.Load()
+	state.shadow = state.shadow[:9]
+	strict.state.Store(&state)
+	_, err = strict.QueryBox(quadWorld)
+	assert.ErrorIs(t, err, ErrDiscrepancy)
+	var d *Discrepancy
+	require.ErrorAs(t, err, &d)
+	assert.Equal(t, []string{"p_9"}, d.Extra)
+	_, err = strict.QueryRadius(*points[9].Location, 1)
+	assert.ErrorIs(t, err, ErrDiscrepancy)
+	assert.Zero(t, NewGeoIndex().Discrepancies())
+}
+
+func TestSlowQueryHook(t *testing.T) {
+	var mu sync.Mutex
+	var slow []SlowQuery
+	hook := func(q SlowQuery) {
+		mu.Lock()
+		defer mu.Unlock()
+		slow = append(slow, q)
+	}
+	points := worldPoints(2000, 47)
+	world := models.BoundingBox{BottomLeft: models.Location{Lat: -90, Lon: -180}, TopRight: models.Location{Lat: 90, Lon: 180}}
+	center := models.Location{Lat: 10, Lon: 20}
+
+	index := NewGeoIndexWithWorkers(4, WithSlowQueryHook(0, hook))
+	require.NoError(t, index.IndexPoints(points))
+	all, err := index.QueryBox(world)
+	require.NoError(t, err)
+	near, err := index.QueryRadiusWithDistance(center, 1000)
+	require.NoError(t, err)
+	nearest := index.NearestNeighbors(center, 5)
+	_, err = index.QueryRadius(center, -1)
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+
+	require.Len(t, slow, 3)
+	assert.Equal(t, queryKindBox, slow[0].Kind)
+	assert.Equal(t, &world, slow[0].Box)
+	assert.Equal(t, len(all), slow[0].Results)
+	assert.Equal(t, 4, slow[0].Partitions)
+	assert.Equal(t, queryKindRadius, slow[1].Kind)
+	assert.Equal(t, &center, slow[1].Center)
+	assert.Equal(t, 1000.0, slow[1].Radius)
+	assert.Equal(t, len(near), slow[1].Results)
+	assert.Positive(t, slow[1].Partitions)
+	assert.Equal(t, queryKindNearest, slow[2].Kind)
+	assert.Equal(t, 5, slow[2].N)
+	assert.Equal(t, len(nearest), slow[2].Results)
+	assert.Positive(t, slow[2].Partitions)
+	for _, q := range slow {
+		assert.Positive(t, q.Duration)
+	}
+
+	// Other storage counts as one search, and cache hits as none
+	slow = nil
+	cached := NewGeoIndex(WithCompactStorage(), WithQueryCache(QueryCacheConfig{Size: 10}), WithSlowQueryHook(0, hook))
+	require.NoError(t, cached.IndexPoints(points))
+	for i := 0; i < 2; i++ {
+		_, err = cached.QueryBox(world)
+		require.NoError(t, err)
+	}
+	require.Len(t, slow, 2)
+	assert.Equal(t, 1, slow[0].Partitions)
+	assert.Zero(t, slow[1].Partitions)
+
+	// Fast queries are not reported
+	slow = nil
+	fast := NewGeoIndex(WithSlowQueryHook(time.Hour, hook))
+	require.NoError(t, fast.IndexPoints(points))
+	_, err = fast.QueryBox(world)
+	require.NoError(t, err)
+	fast.NearestNeighbors(center, 5)
+	assert.Empty(t, slow)
+	assert.Nil(t, NewGeoIndex(WithSlowQueryHook(0, nil)).slowLog)
+}
+
+func TestLogger(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	messages := func() []string {
+		var msgs []string
+		for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+			var entry struct{ Msg string }
+			require.NoError(t, json.Unmarshal([]byte(line), &entry))
+			msgs = append(msgs, entry.Msg)
+		}
+		logs.Reset()
+		return msgs
+	}
+
+	index := NewGeoIndex(WithLogger(logger))
+	require.NoError(t, index.IndexPoints(worldPoints(100, 53)))
+	assert.Equal(t, []string{"points indexed"}, messages())
+
+	path := filepath.Join(t.TempDir(), "index.gob")
+	require.NoError(t, index.SaveToFile(path))
+	assert.Contains(t, logs.String(), `"points":100`)
+	assert.Equal(t, []string{"index saved"}, messages())
+	require.NoError(t, index.LoadFromFile(path))
+	assert.Equal(t, []string{"index loaded"}, messages())
+	assert.Error(t, index.LoadFromFile(filepath.Join(t.TempDir(), "missing.gob")))
+	assert.Equal(t, []string{"index load failed"}, messages())
+	assert.Error(t, index.SaveToFile(filepath.Join(path, "index.gob")))
+	assert.Equal(t, []string{"index save failed"}, messages())
+
+	snap := index.BeginSnapshot()
+	index.EndSnapshot(snap)
+	index.EndSnapshot(snap)
+	assert.Equal(t, []string{"snapshot begun", "snapshot ended"}, messages())
+
+	bounded, err := NewBoundedIndex(BoundedConfig{MaxPoints: 8, Policy: EvictLRU, Options: []Option{WithLogger(logger)}})
+	require.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		require.NoError(t, bounded.IndexPoints(worldPoints(8, int64(i))))
+	}
+	assert.Contains(t, messages(), "bounded index rebuilt")
+
+	// Without a logger nothing is written anywhere
+	require.NoError(t, NewGeoIndex().SaveToFile(filepath.Join(t.TempDir(), "quiet.gob")))
+	assert.Empty(t, logs.String())
+}
+
+func TestProgress(t *testing.T) {
+	var reports []Progress
+	record := func(p Progress) { reports = append(reports, p) }
+
+	n := 2*progressEvery + 100
+	points := append(worldPoints(n, 61), &models.Point{ID: "nowhere"})
+	index := NewGeoIndexWithWorkers(4, WithProgress(record))
+	require.NoError(t, index.IndexPoints(points))
+
+	var partitioning, building []Progress
+	for _, p := range reports {
+		switch p.Stage {
+		case StagePartitioning:
+			assert.Empty(t, building, "partitioning after building")
+			partitioning = append(partitioning, p)
+		case StageBuilding:
+			building = append(building, p)
+		}
+	}
+	require.Len(t, partitioning, 3)
+	assert.Equal(t, progressEvery, partitioning[0].Done)
+	assert.Equal(t, 2*progressEvery, partitioning[1].Done)
+	assert.Equal(t, n+1, partitioning[2].Done)
+	assert.Equal(t, n+1, partitioning[2].Total)
+	require.Len(t, building, 4)
+	for i, p := range building {
+		assert.Equal(t, i+1, p.PartitionsDone)
+		assert.Equal(t, 4, p.Partitions)
+		assert.Equal(t, n, p.Total)
+		if i > 0 {
+			assert.Greater(t, p.Done, building[i-1].Done)
+			assert.GreaterOrEqual(t, p.Elapsed, building[i-1].Elapsed)
+		}
+	}
+	assert.Equal(t, n, building[3].Done)
+
+	// Other storage reports the end of the batch
+	reports = nil
+	kd := NewGeoIndex(WithKDTreeStorage(), WithProgress(record))
+	require.NoError(t, kd.IndexPoints(points[:100]))
+	require.Len(t, reports, 1)
+	assert.Equal(t, Progress{Stage: StageBuilding, Done: 100, Total: 100, Elapsed: reports[0].Elapsed}, reports[0])
+
+	// Loading reports through IndexPoints
+	path := filepath.Join(t.TempDir(), "index.gob")
+	require.NoError(t, kd.SaveToFile(path))
+	reports = nil
+	require.NoError(t, NewGeoIndexWithWorkers(2, WithProgress(record)).LoadFromFile(path))
+	require.NotEmpty(t, reports)
+	assert.Equal(t, 100, reports[len(reports)-1].Done)
+}
+
+// recordingMetrics is a Metrics keeping what it was told
+type recordingMetrics struct {
+	mu        sync.Mutex
+	queries   map[string]int
+	latencies map[string]int
+	points    []int64
+}
+
+func (m *recordingMetrics) IncQueries(kind string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queries[kind]++
+}
+
+func (m *recordingMetrics) ObserveLatency(kind string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.latencies[kind]++
+}
+
+func (m *recordingMetrics) SetPointCount(n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.points = append(m.points, n)
+}
+
+func TestMetrics(t *testing.T) {
+	metrics := &recordingMetrics{queries: map[string]int{}, latencies: map[string]int{}}
+	index := NewGeoIndex(WithMetrics(metrics))
+	require.NoError(t, index.IndexPoints(worldPoints(50, 61)))
+	require.NoError(t, index.IndexPoints(worldPoints(30, 62)))
+
+	_, err := index.QueryBox(models.BoundingBox{TopRight: models.Location{Lat: 10, Lon: 10}})
+	require.NoError(t, err)
+	_, err = index.QueryRadius(models.Location{Lat: 0, Lon: 0}, 500)
+	require.NoError(t, err)
+	_, err = index.QueryRadiusContext(context.Background(), models.Location{Lat: 0, Lon: 0}, 500)
+	require.NoError(t, err)
+	index.NearestNeighbors(models.Location{Lat: 0, Lon: 0}, 3)
+	// Invalid queries are not counted
+	_, err = index.QueryRadius(models.Location{Lat: 100, Lon: 0}, 500)
+	require.Error(t, err)
+
+	assert.Equal(t, map[string]int{"box": 1, "radius": 2, "nearest": 1}, metrics.queries)
+	assert.Equal(t, metrics.queries, metrics.latencies)
+	index.Clear()
+	assert.Equal(t, []int64{50, 80, 0}, metrics.points)
+
+	t.Run("prometheus", func(t *testing.T) {
+		prom := NewPrometheusMetrics("geo", 0.001, 0.01)
+		prom.IncQueries("box")
+		prom.ObserveLatency("box", 500*time.Microsecond)
+		prom.ObserveLatency("box", 10*time.Millisecond)
+		prom.ObserveLatency("box", time.Second)
+		prom.SetPointCount(42)
+
+		rec := httptest.NewRecorder()
+		prom.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		assert.Equal(t, `# TYPE geo_queries_total counter
+geo_queries_total{type="box"} 1
+# TYPE geo_query_duration_seconds histogram
+geo_query_duration_seconds_bucket{type="box",le="0.001"} 1
+geo_query_duration_seconds_bucket{type="box",le="0.01"} 2
+geo_query_duration_seconds_bucket{type="box",le="+Inf"} 3
+geo_query_duration_seconds_sum{type="box"} 1.0105
+geo_query_duration_seconds_count{type="box"} 3
+# TYPE geo_points gauge
+geo_points 42
+`, rec.Body.String())
+	})
+
+	var _ Metrics = NopMetrics{}
+}
+
+func TestDumpStructure(t *testing.T) {
+	points := worldPoints(500, 71)
+	modes := map[string]struct {
+		opts []Option
+		// kind is the feature kind whose points add up to the index's
+		kind string
+	}{
+		"partition": {nil, "tree"},
+		"compact":   {[]Option{WithCompactStorage()}, ""},
+		"geohash":   {[]Option{WithGeohashStorage(2)}, "cell"},
+		"s2":        {[]Option{WithS2Storage()}, "extent"},
+		"quadtree":  {[]Option{WithQuadtreeStorage()}, "node"},
+		"kdtree":    {[]Option{WithKDTreeStorage()}, ""},
+		"grid":      {[]Option{WithGridStorage(10)}, "cell"},
+	}
+	for name, mode := range modes {
+		t.Run(name, func(t *testing.T) {
+			index := NewGeoIndex(mode.opts...)
+			require.NoError(t, index.IndexPoints(points))
+
+			var buf bytes.Buffer
+			require.NoError(t, index.DumpStructure(&buf))
+			var collection struct {
+				Type     string
+				Features []struct {
+					Geometry struct {
+						Type        string
+						Coordinates [][][2]float64
+					}
+					Properties struct {
+						Kind   string
+						Level  int
+						Points *int
+					}
+				}
+			}
+			require.NoError(t, json.Unmarshal(buf.Bytes(), &collection))
+			assert.Equal(t, "FeatureCollection", collection.Type)
+			require.NotEmpty(t, collection.Features)
+
+			levels := make(map[int]bool)
+			total := 0
+			for _, f := range collection.Features {
+				assert.Equal(t, "Polygon", f.Geometry.Type)
+				require.Len(t, f.Geometry.Coordinates, 1)
+				ring := f.Geometry.Coordinates[0]
+				require.Len(t, ring, 5)
+				assert.Equal(t, ring[0], ring[4])
+				levels[f.Properties.Level] = true
+				if f.Properties.Kind == mode.kind && f.Properties.Points != nil {
+					total += *f.Properties.Points
+				}
+			}
+			assert.True(t, levels[0])
+			if mode.kind != "" {
+				assert.Equal(t, len(points), total)
+			} else {
+				// Compact and k-d trees have several levels of nodes
+				assert.True(t, levels[1])
+			}
+		})
+	}
+
+	t.Run("empty", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, NewGeoIndex(WithQuadtreeStorage()).DumpStructure(&buf))
+		assert.JSONEq(t, `{"type":"FeatureCollection","features":[{"type":"Feature","geometry":{"type":"Polygon","coordinates":[[[-180,-90],[180,-90],[180,90],[-180,90],[-180,-90]]]},"properties":{"kind":"node","level":0,"points":0}}]}`, buf.String())
+	})
+}
+
+func TestTieredIndex(t *testing.T) {
+	// Points over Europe in 5 degree regions, with room for a few regions
+	rng := rand.New(rand.NewSource(23))
+	points := make([]*models.Point, 3000)
+	for i := range points {
+		points[i] = &models.Point{
+			ID:       fmt.Sprintf("p_%d", i),
+			Location: &models.Location{Lat: 35 + rng.Float64()*25, Lon: -10 + rng.Float64()*40},
+		}
+	}
+	dir := t.TempDir()
+	cfg := TieredConfig{Dir: dir, MaxResidentPoints: 400, Options: []Option{WithStableOrder()}}
+	tiered, err := NewTieredIndex(cfg)
+	require.NoError(t, err)
+	regular := NewGeoIndexWithWorkers(4, WithStableOrder())
+	require.NoError(t, regular.IndexPoints(points))
+	for i := 0; i < len(points); i += 500 {
+		require.NoError(t, tiered.IndexPoints(append(points[i:i+500:i+500], &models.Point{ID: "no-location"})))
+	}
+	assert.Equal(t, regular.Count(), tiered.Count())
+
+	stats := tiered.Stats()
+	assert.Equal(t, 40, stats.Regions)
+	assert.LessOrEqual(t, stats.ResidentPoints, int64(400))
+	assert.Positive(t, stats.Evictions)
+	assert.Positive(t, stats.WriteBacks)
+	assert.NoError(t, tiered.Err())
+
+	check := func(tiered *TieredIndex) {
+		t.Helper()
+		for _, c := range []models.Location{{Lat: 48.8, Lon: 2.3}, {Lat: 40, Lon: -5}, {Lat: 55, Lon: 25}, {Lat: 45, Lon: 10}, {Lat: 70, Lon: 60}} {
+			box := models.BoundingBox{
+				BottomLeft: models.Location{Lat: c.Lat - 3, Lon: c.Lon - 3},
+				TopRight:   models.Location{Lat: c.Lat + 3, Lon: c.Lon + 3},
+			}
+			want, err := regular.QueryBox(box)
+			require.NoError(t, err)
+			got, err := tiered.QueryBox(box)
+			require.NoError(t, err)
+			assert.Equal(t, want, got)
+
+			wantNear, err := regular.QueryRadiusWithDistance(c, 300)
+			require.NoError(t, err)
+			gotNear, err := tiered.QueryRadiusWithDistance(c, 300)
+			require.NoError(t, err)
+			assert.Equal(t, wantNear, gotNear)
+
+			nearest := tiered.NearestNeighborsWithDistance(c, 10)
+			require.Len(t, nearest, 10)
+			within, err := regular.QueryRadius(c, nearest[9].DistanceKm)
+			require.NoError(t, err)
+			assert.Len(t, within, 10)
+		}
+		assert.LessOrEqual(t, tiered.Stats().ResidentPoints, int64(400))
+	}
+	check(tiered)
+	assert.Positive(t, tiered.Stats().Loads)
+
+	// A region queried again is still resident
+	_, err = tiered.QueryRadius(models.Location{Lat: 46, Lon: 11}, 10)
+	require.NoError(t, err)
+	before := tiered.Stats()
+	_, err = tiered.QueryRadius(models.Location{Lat: 46, Lon: 11}, 10)
+	require.NoError(t, err)
+	assert.Equal(t, before.Loads, tiered.Stats().Loads)
+	assert.Equal(t, before.Hits+1, tiered.Stats().Hits)
+
+	// The directory reopens with the region size it was written with
+	require.NoError(t, tiered.Close())
+	reopened, err := NewTieredIndex(TieredConfig{Dir: dir, MaxResidentPoints: 400, Options: cfg.Options})
+	require.NoError(t, err)
+	assert.Equal(t, regular.Count(), reopened.Count())
+	assert.Zero(t, reopened.Stats().ResidentRegions)
+	check(reopened)
+	_, err = NewTieredIndex(TieredConfig{Dir: dir, RegionDeg: 1})
+	assert.Error(t, err)
+
+	// Saving gathers every region into one index file
+	path := filepath.Join(t.TempDir(), "all.gob")
+	require.NoError(t, reopened.SaveToFile(path))
+	loaded := NewGeoIndex()
+	require.NoError(t, loaded.LoadFromFile(path))
+	assert.Equal(t, regular.Count(), loaded.Count())
+
+	// Regions on both sides of the antimeridian are searched
+	edge, err := NewTieredIndex(TieredConfig{Dir: t.TempDir()})
+	require.NoError(t, err)
+	require.NoError(t, edge.IndexPoints([]*models.Point{
+		{ID: "west", Location: &models.Location{Lon: -179.9}},
+		{ID: "east", Location: &models.Location{Lon: 179}},
+	}))
+	results, err := edge.QueryRadius(models.Location{Lon: 179.95}, 50)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "west", results[0].ID)
+	nearest := edge.NearestNeighbors(models.Location{Lon: 179.95}, 1)
+	require.Len(t, nearest, 1)
+	assert.Equal(t, "west", nearest[0].ID)
+
+	// Points added while regions are written back are kept
+	busyDir := t.TempDir()
+	busy, err := NewTieredIndex(TieredConfig{Dir: busyDir, MaxResidentPoints: 400})
+	require.NoError(t, err)
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w * 500; i < len(points); i += 2000 {
+				assert.NoError(t, busy.IndexPoints(points[i:i+500]))
+				assert.NoError(t, busy.Flush())
+				_, err := busy.QueryRadius(*points[i].Location, 300)
+				assert.NoError(t, err)
+			}
+		}(w)
+	}
+	wg.Wait()
+	require.NoError(t, busy.Close())
+	assert.NoError(t, busy.Err())
+	reopened, err = NewTieredIndex(TieredConfig{Dir: busyDir})
+	require.NoError(t, err)
+	assert.Equal(t, regular.Count(), reopened.Count())
+	all, err := reopened.QueryBox(quadWorld)
+	require.NoError(t, err)
+	assert.Len(t, all, len(points))
+}
+
+func TestBoundedIndex(t *testing.T) {
+	sighting := func(i int, ts float64) *models.Point {
+		return &models.Point{
+			ID:         fmt.Sprintf("s_%d", i),
+			Location:   &models.Location{Lat: 40 + float64(i%100)*0.01, Lon: -74 + float64(i/100)*0.01},
+			Properties: map[string]any{"timestamp": ts, "priority": float64(i % 7)},
+		}
+	}
+
+	// The most recent sightings survive, however they arrive
+	var evicted []string
+	recent, err := NewBoundedIndex(BoundedConfig{
+		MaxPoints: 1000,
+		OnEvict:   func(p *models.Point) { evicted = append(evicted, p.ID) },
+		Options:   []Option{WithStableOrder()},
+	})
+	require.NoError(t, err)
+	rng := rand.New(rand.NewSource(29))
+	order := rng.Perm(3000)
+	for i := 0; i < len(order); i += 250 {
+		batch := make([]*models.Point, 0, 251)
+		for _, j := range order[i : i+250] {
+			batch = append(batch, sighting(j, float64(j)))
+		}
+		require.NoError(t, recent.IndexPoints(append(batch, &models.Point{ID: "no-location"})))
+	}
+	assert.Equal(t, int64(1000), recent.Count())
+	assert.Len(t, evicted, 2000)
+	stats := recent.Stats()
+	assert.Equal(t, int64(2000), stats.Evicted)
+	assert.LessOrEqual(t, stats.Stale, int64(250))
+
+	world := models.BoundingBox{BottomLeft: models.Location{Lat: -90, Lon: -180}, TopRight: models.Location{Lat: 90, Lon: 180}}
+	all, err := recent.QueryBox(world)
+	require.NoError(t, err)
+	require.Len(t, all, 1000)
+	for _, p := range all {
+		assert.GreaterOrEqual(t, p.Properties["timestamp"], float64(2000))
+	}
+	near, err := recent.QueryRadiusWithDistance(models.Location{Lat: 40.5, Lon: -73.75}, 2)
+	require.NoError(t, err)
+	for _, p := range near {
+		assert.GreaterOrEqual(t, p.Point.Properties["timestamp"], float64(2000))
+	}
+	nearest := recent.NearestNeighborsWithDistance(models.Location{Lat: 40, Lon: -74}, 5)
+	require.Len(t, nearest, 5)
+	within, err := recent.QueryRadius(models.Location{Lat: 40, Lon: -74}, nearest[4].DistanceKm)
+	require.NoError(t, err)
+	assert.Len(t, within, 5)
+
+	// Re-indexing an ID replaces its point; older timestamps are dropped at once
+	moved := sighting(2500, 5000)
+	moved.Location = &models.Location{Lat: 10, Lon: 10}
+	require.NoError(t, recent.IndexPoints([]*models.Point{moved, sighting(9999, 1)}))
+	assert.Equal(t, int64(1000), recent.Count())
+	got, err := recent.QueryRadius(models.Location{Lat: 10, Lon: 10}, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []*models.Point{moved}, got)
+	got, err = recent.QueryRadius(*sighting(2500, 0).Location, 0)
+	require.NoError(t, err)
+	assert.Empty(t, got)
+	assert.Equal(t, "s_9999", evicted[len(evicted)-1])
+
+	// Saving writes only the live points
+	path := filepath.Join(t.TempDir(), "recent.gob")
+	require.NoError(t, recent.SaveToFile(path))
+	assert.Zero(t, recent.Stats().Stale)
+	header, err := ReadHeader(path)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1000), header.Count)
+
+	// LRU keeps the points queries return
+	lru, err := NewBoundedIndex(BoundedConfig{MaxPoints: 100, Policy: EvictLRU})
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, lru.IndexPoints([]*models.Point{sighting(i, 0)}))
+	}
+	used, err := lru.QueryRadius(*sighting(0, 0).Location, 0)
+	require.NoError(t, err)
+	require.Len(t, used, 1)
+	require.NoError(t, lru.IndexPoints([]*models.Point{sighting(100, 0), sighting(101, 0)}))
+	for id, want := range map[int]int{0: 1, 1: 0, 2: 0, 3: 1, 101: 1} {
+		got, err := lru.QueryRadius(*sighting(id, 0).Location, 0)
+		require.NoError(t, err)
+		assert.Len(t, got, want, "s_%d", id)
+	}
+
+	// Lowest priority goes first, ties by insertion order
+	prio, err := NewBoundedIndex(BoundedConfig{MaxPoints: 600, Policy: EvictLowestPriority})
+	require.NoError(t, err)
+	batch := make([]*models.Point, 700)
+	for i := range batch {
+		batch[i] = sighting(i, 0)
+	}
+	require.NoError(t, prio.IndexPoints(batch))
+	all, err = prio.QueryBox(world)
+	require.NoError(t, err)
+	require.Len(t, all, 600)
+	for _, p := range all {
+		assert.NotEqual(t, float64(0), p.Properties["priority"], p.ID)
+	}
+
+	_, err = NewBoundedIndex(BoundedConfig{MaxPoints: 10, Policy: "newest"})
+	assert.Error(t, err)
+	_, err = NewBoundedIndex(BoundedConfig{})
+	assert.Error(t, err)
+}
+
+func TestMovingIndex(t *testing.T) {
+	// A fleet reporting over the last minute, a third of it parked
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	rng := rand.New(rand.NewSource(37))
+	report := func(i int) *models.Point {
+		props := map[string]any{"timestamp": float64(now.Unix() - rng.Int63n(60))}
+		if i%3 != 0 {
+			props["speed"] = rng.Float64() * 30
+			props["heading"] = rng.Float64() * 360
+		}
+		return &models.Point{
+			ID:         fmt.Sprintf("truck_%d", i),
+			Location:   &models.Location{Lat: 51.3 + rng.Float64()*0.4, Lon: -0.4 + rng.Float64()*0.6},
+			Properties: props,
+		}
+	}
+	fleet := NewMovingIndex(MovingConfig{Options: []Option{WithStableOrder()}})
+	var reports []*models.Point
+	for i := 0; i < 600; i++ {
+		reports = append(reports, report(i))
+	}
+	for round := 0; round < 3; round++ {
+		// Each round re-reports every truck, replacing its last report
+		for i := range reports {
+			if round > 0 {
+				reports[i] = report(i)
+			}
+		}
+		for i := 0; i < len(reports); i += 200 {
+			require.NoError(t, fleet.IndexPoints(append(reports[i:i+200:i+200], &models.Point{ID: "no-location"})))
+		}
+	}
+	assert.Equal(t, int64(600), fleet.Count())
+
+	// Dead reckoning by hand, bounded by the default five minutes
+	predict := func(p *models.Point, at time.Time) models.Location {
+		ts := time.Unix(int64(p.Properties["timestamp"].(float64)), 0)
+		dt := min(max(at.Sub(ts), 0), DefaultMaxExtrapolation).Seconds()
+		if speed, ok := p.Properties["speed"].(float64); ok && dt > 0 {
+			return p.Location.Destination(p.Properties["heading"].(float64), speed*dt/1000)
+		}
+		return *p.Location
+	}
+	for _, p := range reports[:10] {
+		loc, ok := fleet.PredictAt(p.ID, now.Add(2*time.Minute))
+		require.True(t, ok)
+		assert.InDelta(t, 0, loc.DistanceTo(predict(p, now.Add(2*time.Minute))), 1e-9)
+		loc, _ = fleet.PredictAt(p.ID, now.Add(-2*time.Minute))
+		assert.Equal(t, *p.Location, loc)
+	}
+	_, ok := fleet.PredictAt("truck_600", now)
+	assert.False(t, ok)
+	late, _ := fleet.PredictAt("truck_1", now.Add(time.Hour))
+	capped, _ := fleet.PredictAt("truck_1", time.Unix(int64(reports[1].Properties["timestamp"].(float64)), 0).Add(DefaultMaxExtrapolation))
+	assert.Equal(t, capped, late)
+
+	box := models.BoundingBox{
+		BottomLeft: models.Location{Lat: 51.4, Lon: -0.3},
+		TopRight:   models.Location{Lat: 51.6, Lon: 0},
+	}
+	center := models.Location{Lat: 51.5, Lon: -0.1}
+	for _, at := range []time.Time{now.Add(-time.Minute), now, now.Add(3 * time.Minute), now.Add(time.Hour)} {
+		var wantBox []string
+		var wantNear []models.PointDistance
+		for _, p := range reports {
+			loc := predict(p, at)
+			if box.Contains(loc) {
+				wantBox = append(wantBox, p.ID)
+			}
+			if dist := center.DistanceTo(loc); dist <= 5 {
+				wantNear = append(wantNear, models.PointDistance{Point: p, DistanceKm: dist})
+			}
+		}
+		slices.Sort(wantBox)
+		sortByDistance(wantNear)
+
+		inBox, err := fleet.QueryBoxAt(box, at)
+		require.NoError(t, err)
+		var gotBox []string
+		for _, p := range inBox {
+			gotBox = append(gotBox, p.ID)
+			assert.True(t, box.Contains(*p.Location))
+		}
+		assert.Equal(t, wantBox, gotBox)
+
+		near, err := fleet.QueryRadiusAt(center, 5, at)
+		require.NoError(t, err)
+		require.Len(t, near, len(wantNear))
+		for i, r := range near {
+			assert.Equal(t, wantNear[i].Point.ID, r.Point.ID)
+			assert.InDelta(t, wantNear[i].DistanceKm, r.DistanceKm, 1e-9)
+		}
+
+		nearest := fleet.NearestNeighborsAt(center, 10, at)
+		require.Len(t, nearest, 10)
+		for i, r := range nearest {
+			assert.Equal(t, wantNear[i].Point.ID, r.Point.ID)
+		}
+	}
+
+	// Results are moved copies; the reports themselves stay put
+	p := reports[1]
+	reported := *p.Location
+	got, err := fleet.QueryRadiusAt(predict(p, now.Add(time.Minute)), 0.001, now.Add(time.Minute))
+	require.NoError(t, err)
+	require.NotEmpty(t, got)
+	assert.Equal(t, p.ID, got[0].Point.ID)
+	assert.Equal(t, reported, *p.Location)
+
+	_, err = fleet.QueryRadiusAt(center, -1, now)
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+	_, err = fleet.QueryBoxAt(models.BoundingBox{BottomLeft: box.TopRight, TopRight: box.BottomLeft}, now)
+	assert.ErrorIs(t, err, ErrInvalidQuery)
+	assert.Empty(t, fleet.NearestNeighborsAt(center, 0, now))
+	assert.Len(t, fleet.NearestNeighborsAt(center, 1000, now), 600)
+
+	path := filepath.Join(t.TempDir(), "fleet.gob")
+	require.NoError(t, fleet.SaveToFile(path))
+	loaded := NewMovingIndex(MovingConfig{})
+	require.NoError(t, loaded.LoadFromFile(path))
+	assert.Equal(t, int64(600), loaded.Count())
+}
+
+func TestAltitude(t *testing.T) {
+	// A column of drones over one spot and ground points spreading east
+	base := models.Location{Lat: 47.6, Lon: -122.3}
+	var points []*models.Point
+	for i := 0; i < 10; i++ {
+		points = append(points, &models.Point{
+			ID:       fmt.Sprintf("drone_%d", i),
+			Location: &models.Location{Lat: base.Lat, Lon: base.Lon, Alt: float64(i) * 300},
+		})
+		points = append(points, &models.Point{
+			ID:       fmt.Sprintf("ground_%d", i),
+			Location: &models.Location{Lat: base.Lat, Lon: base.Lon + float64(i+1)*0.004},
+		})
+	}
+
+	for name, opts := range map[string][]Option{
+		"partitioned": nil,
+		"compact":     {WithCompactStorage()},
+		"geohash":     {WithGeohashStorage(0)},
+		"s2":          {WithS2Storage()},
+		"quadtree":    {WithQuadtreeStorage()},
+		"kd-tree":     {WithKDTreeStorage()},
+		"grid":        {WithGridStorage(0)},
+		"space-time":  {WithSpaceTimeStorage("")},
+		"wrapped":     {WithLongitudeWrap()},
+		"cached":      {WithQueryCache(QueryCacheConfig{Size: 16})},
+	} {
+		t.Run(name, func(t *testing.T) {
+			index := NewGeoIndexWithWorkers(4, append(opts, WithAltitude())...)
+			require.NoError(t, index.IndexPoints(points))
+
+			// Vertical separation counts: from the ground only the lowest
+			// drones are within 1km
+			results, err := index.QueryRadiusWithDistance(base, 1)
+			require.NoError(t, err)
+			var ids []string
+			for _, r := range results {
+				ids = append(ids, r.Point.ID)
+				assert.InDelta(t, base.Distance3DTo(*r.Point.Location), r.DistanceKm, 1e-9)
+			}
+			assert.Equal(t, []string{"drone_0", "ground_0", "drone_1", "ground_1", "drone_2", "ground_2", "drone_3"}, ids)
+			batch, err := index.QueryRadii([]models.Location{base}, 1)
+			require.NoError(t, err)
+			assert.Len(t, batch[0], len(ids))
+
+			// At 2700m the nearest points are the drones around it
+			high := models.Location{Lat: base.Lat, Lon: base.Lon, Alt: 2700}
+			nearest := index.NearestNeighborsWithDistance(high, 3)
+			require.Len(t, nearest, 3)
+			assert.Equal(t, "drone_9", nearest[0].Point.ID)
+			assert.Zero(t, nearest[0].DistanceKm)
+			assert.ElementsMatch(t, []string{"drone_8", "drone_7"}, []string{nearest[1].Point.ID, nearest[2].Point.ID})
+			assert.Equal(t, 2700.0, nearest[0].Point.Location.Alt)
+			assert.Len(t, index.NearestNeighbors(high, 50), 20)
+		})
+	}
+
+	// Without the option altitude is ignored
+	flat := NewGeoIndexWithWorkers(4)
+	require.NoError(t, flat.IndexPoints(points))
+	results, err := flat.QueryRadius(base, 1)
+	require.NoError(t, err)
+	assert.Len(t, results, 13)
+
+	// Altitudes survive a save and load
+	path := filepath.Join(t.TempDir(), "alt.gob")
+	require.NoError(t, flat.SaveToFile(path))
+	loaded := NewGeoIndex()
+	require.NoError(t, loaded.LoadFromFile(path))
+	top := loaded.NearestNeighbors(base, 20)
+	alts := 0.0
+	for _, p := range top {
+		alts += p.Location.Alt
+	}
+	assert.Equal(t, 45*300.0, alts)
+}
+
+func TestIDCodec(t *testing.T) {
+	codec := IDCodec{Prefix: "point_"}
+	for id, want := range map[string]bool{
+		"point_0": true, "point_123456": true, "point_18446744073709551614": true,
+		"point_": false, "point_01": false, "point_-1": false, "point_+1": false,
+		"point_1a": false, "node/1": false, "point_18446744073709551615": false,
+	} {
+		n, ok := codec.Encode(id)
+		assert.Equal(t, want, ok, id)
+		if ok {
+			assert.Equal(t, id, codec.Decode(n))
+		}
+	}
+
+	points := worldPoints(2000, 5)
+	points[10].ID = "custom"
+	points[11].ID = ""
+
+	// Compact storage keeps numeric IDs and falls back to strings
+	compact := NewGeoIndex(WithCompactStorage(), WithIDCodec(codec), WithStableOrder())
+	require.NoError(t, compact.IndexPoints(points))
+	all, err := compact.QueryBox(models.BoundingBox{
+		BottomLeft: models.Location{Lat: -90, Lon: -180},
+		TopRight:   models.Location{Lat: 90, Lon: 180},
+	})
+	require.NoError(t, err)
+	want := make([]string, 0, len(points))
+	for _, p := range points {
+		want = append(want, p.ID)
+	}
+	got := make([]string, 0, len(all))
+	for _, p := range all {
+		got = append(got, p.ID)
+	}
+	assert.ElementsMatch(t, want, got)
+
+	// Saved files store the IDs as numbers and restore them on load
+	dir := t.TempDir()
+	plain := NewGeoIndex()
+	require.NoError(t, plain.IndexPoints(points))
+	require.NoError(t, plain.SaveToFile(dir+"/plain.gob"))
+	encoded := NewGeoIndex(WithIDCodec(codec))
+	require.NoError(t, encoded.IndexPoints(points))
+	require.NoError(t, encoded.SaveToFile(dir+"/encoded.gob"))
+	assert.Equal(t, "point_0", points[0].ID, "saving must not modify indexed points")
+
+	header, err := ReadHeader(dir + "/encoded.gob")
+	require.NoError(t, err)
+	assert.Equal(t, &codec, header.IDCodec)
+	plainInfo, err := os.Stat(dir + "/plain.gob")
+	require.NoError(t, err)
+	encodedInfo, err := os.Stat(dir + "/encoded.gob")
+	require.NoError(t, err)
+	assert.Less(t, encodedInfo.Size(), plainInfo.Size())
+
+	read, err := ReadPoints(dir + "/encoded.gob")
+	require.NoError(t, err)
+	got = got[:0]
+	for _, p := range read {
+		got = append(got, p.ID)
+	}
+	assert.ElementsMatch(t, want, got)
+}
+
+func TestLongitudeWrap(t *testing.T) {
+	points := []*models.Point{
+		{ID: "fiji", Location: &models.Location{Lat: -17.7, Lon: 178.0}},
+		{ID: "samoa", Location: &models.Location{Lat: -13.8, Lon: 188.2}}, // -171.8
+		{ID: "london", Location: &models.Location{Lat: 51.5, Lon: 359.9}}, // -0.1
+	}
+
+	index := NewGeoIndexWithWorkers(4, WithLongitudeWrap())
+	require.NoError(t, index.IndexPoints(points))
+	// The caller's points are left untouched
+	assert.Equal(t, 188.2, points[1].Location.Lon)
+
+	results, err := index.QueryBox(models.BoundingBox{
+		BottomLeft: models.Location{Lat: 51, Lon: -1},
+		TopRight:   models.Location{Lat: 52, Lon: 1},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "london", results[0].ID)
+	assert.InDelta(t, -0.1, results[0].Location.Lon, 1e-9)
+
+	// A 0-360 box across the antimeridian is split in two
+	results, err = index.QueryBox(models.BoundingBox{
+		BottomLeft: models.Location{Lat: -20, Lon: 175},
+		TopRight:   models.Location{Lat: -10, Lon: 190},
+	})
+	require.NoError(t, err)
+	ids := []string{}
+	for _, p := range results {
+		ids = append(ids, p.ID)
+	}
+	assert.ElementsMatch(t, []string{"fiji", "samoa"}, ids)
+
+	results, err = index.QueryRadius(models.Location{Lat: -13.8, Lon: 188.2}, 10)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "samoa", results[0].ID)
+
+	// Radius boxes are split at the antimeridian in every storage layout
+	for _, storage := range [][]Option{
+		{}, {WithCompactStorage()}, {WithGeohashStorage(4)}, {WithS2Storage()}, {WithQuadtreeStorage()},
+		{WithKDTreeStorage()}, {WithGridStorage(0.5)}, {WithSpaceTimeStorage("seen")},
+	} {
+		checkAntimeridian(t, append(storage, WithLongitudeWrap())...)
+	}
+
+	nearest := index.NearestNeighbors(models.Location{Lat: 51.5, Lon: 359.8}, 1)
+	require.Len(t, nearest, 1)
+	assert.Equal(t, "london", nearest[0].ID)
+
+	// Without the option 0-360 longitudes are stored as given
+	plain := NewGeoIndexWithWorkers(4)
+	require.NoError(t, plain.IndexPoints(points))
+	results, err = plain.QueryBox(models.BoundingBox{
+		BottomLeft: models.Location{Lat: 51, Lon: -1},
+		TopRight:   models.Location{Lat: 52, Lon: 1},
+	})
+	require.NoError(t, err)
+	assert.Empty(t, results)
+}
+
+func TestPolygonIndex(t *testing.T) {
+	square := func(id string, lat, lon, side float64) *models.Polygon {
+		return &models.Polygon{ID: id, Outer: []models.Location{
+			{Lat: lat, Lon: lon}, {Lat: lat, Lon: lon + side}, {Lat: lat + side, Lon: lon + side}, {Lat: lat + side, Lon: lon},
+		}}
+	}
+	index := NewPolygonIndex()
+	assert.Empty(t, index.ContainingPolygons(models.Location{Lat: 1, Lon: 1}))
+
+	// A triangle whose bounding box covers (1, 9) without the triangle doing so
+	triangle := &models.Polygon{ID: "triangle", Outer: []models.Location{{Lat: 0, Lon: 0}, {Lat: 0, Lon: 10}, {Lat: 10, Lon: 10}}}
+	require.NoError(t, index.IndexPolygons([]*models.Polygon{square("big", 0, 0, 10), square("small", 0, 0, 2), triangle}))
+	for i := 0; i < 50; i++ {
+		require.NoError(t, index.IndexPolygons([]*models.Polygon{square(fmt.Sprintf("far%d", i), 50, float64(i), 0.5)}))
+	}
+	assert.Equal(t, 53, index.Count())
+
+	ids := func(polygons []*models.Polygon) []string {
+		var out []string
+		for _, p := range polygons {
+			out = append(out, p.ID)
+		}
+		return out
+	}
+	assert.Equal(t, []string{"big", "small", "triangle"}, ids(index.ContainingPolygons(models.Location{Lat: 1, Lon: 1.5})))
+	assert.Equal(t, []string{"big"}, ids(index.ContainingPolygons(models.Location{Lat: 9, Lon: 1})))
+	assert.Equal(t, []string{"big", "small", "triangle"}, ids(index.ContainingPolygons(models.Location{Lat: 0, Lon: 0})))
+	assert.Equal(t, []string{"far7"}, ids(index.ContainingPolygons(models.Location{Lat: 50.25, Lon: 7.25})))
+	assert.Empty(t, index.ContainingPolygons(models.Location{Lat: -5, Lon: 5}))
+
+	// An invalid polygon rejects the whole batch
+	err := index.IndexPolygons([]*models.Polygon{square("ok", 20, 20, 1), {ID: "line", Outer: []models.Location{{Lat: 0, Lon: 0}, {Lat: 1, Lon: 1}}}})
+	assert.ErrorContains(t, err, "line")
+	assert.Equal(t, 53, index.Count())
+
+	index.Clear()
+	assert.Zero(t, index.Count())
+	assert.Empty(t, index.ContainingPolygons(models.Location{Lat: 1, Lon: 1}))
+}
@@ -0,0 +1,244 @@
+package rtree
+
+import (
+	"container/heap"
+	"sort"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/1F47E/geo-index-rtree/pkg/s2cell"
+)
+
+const (
+	// s2CoveringCells is the number of cells a query region is approximated
+	// by; more cells fit the region tighter but cost a binary search each
+	s2CoveringCells = 16
+	// s2LeafPoints is the number of points below which a nearest-neighbor
+	// search measures a cell's points instead of splitting it further
+	s2LeafPoints = 16
+	// s2BytesPerPoint approximates the fixed heap cost of one point in S2
+	// storage: the Point and Location structs, its cell ID and slot and its
+	// entry in the ID set
+	s2BytesPerPoint = 128
+)
+
+// WithS2Storage keeps points sorted by the S2 cell of their location (see
+// package s2cell) instead of building R-trees. S2 cells tile the sphere
+// without seams, so radius and nearest-neighbor queries are exact across the
+// antimeridian and around the poles, where partitions and the other storage
+// modes approximate circles by latitude/longitude boxes. Box queries behave
+// the same as with partitions. Every IndexPoints call merges the new points
+// into one sorted slice, so load data in large batches.
+func WithS2Storage() Option {
+	return func(g *GeoIndex) {
+		g.storage = s2Storage
+	}
+}
+
+// s2Store keeps points ordered by leaf cell ID, so the points of any cell are
+// the contiguous run between its RangeMin and RangeMax. Stores are immutable:
+// add returns a new store.
+type s2Store struct {
+	cells             []s2cell.CellID
+	points            []*models.Point
+	ids               idBatches
+	falsePositiveRate float64
+}
+
+// add returns a store holding s's points and those of points that have a
+// location, and the number added
+func (s *s2Store) add(g *GeoIndex, points []*models.Point) (store, int) {
+	type entry struct {
+		cell  s2cell.CellID
+		point *models.Point
+	}
+	var batch []entry
+	ids := make([]string, 0, len(points))
+	for _, p := range points {
+		if p.Location == nil {
+			continue
+		}
+		if g.wrapLongitudes {
+			p = wrapPoint(p)
+		}
+		batch = append(batch, entry{cell: s2cell.FromLocation(*p.Location), point: p})
+		ids = append(ids, p.ID)
+	}
+	if len(batch) == 0 {
+		return s, 0
+	}
+	sort.Slice(batch, func(i, j int) bool { return batch[i].cell < batch[j].cell })
+
+	next := &s2Store{
+		cells:             make([]s2cell.CellID, 0, len(s.cells)+len(batch)),
+		points:            make([]*models.Point, 0, len(s.cells)+len(batch)),
+		ids:               s.ids.with(ids, s.falsePositiveRate),
+		falsePositiveRate: s.falsePositiveRate,
+	}
+	i := 0
+	for _, e := range batch {
+		for ; i < len(s.cells) && s.cells[i] <= e.cell; i++ {
+			next.cells, next.points = append(next.cells, s.cells[i]), append(next.points, s.points[i])
+		}
+		next.cells, next.points = append(next.cells, e.cell), append(next.points, e.point)
+	}
+	next.cells, next.points = append(next.cells, s.cells[i:]...), append(next.points, s.points[i:]...)
+	return next, len(batch)
+}
+
+// without returns a store holding s's points except those with an ID in
+// remove, and the number left out
+func (s *s2Store) without(g *GeoIndex, remove map[string]bool) (store, int) {
+	next := &s2Store{
+		cells:             make([]s2cell.CellID, 0, len(s.cells)),
+		points:            make([]*models.Point, 0, len(s.points)),
+		falsePositiveRate: s.falsePositiveRate,
+	}
+	ids := make([]string, 0, len(s.points))
+	for i, p := range s.points {
+		if !remove[p.ID] {
+			next.cells, next.points = append(next.cells, s.cells[i]), append(next.points, p)
+			ids = append(ids, p.ID)
+		}
+	}
+	removed := len(s.points) - len(next.points)
+	if removed == 0 {
+		return s, 0
+	}
+	next.ids = idBatches(nil).with(ids, s.falsePositiveRate)
+	return next, removed
+}
+
+// cellRange returns the indexes of the first point in cell and one past the
+// last
+func (s *s2Store) cellRange(cell s2cell.CellID) (int, int) {
+	lo, hi := cell.RangeMin(), cell.RangeMax()
+	start := sort.Search(len(s.cells), func(i int) bool { return s.cells[i] >= lo })
+	end := start + sort.Search(len(s.cells)-start, func(i int) bool { return s.cells[start+i] > hi })
+	return start, end
+}
+
+// search calls fn with every point in the cells covering region, a superset
+// of the points inside it
+func (s *s2Store) search(region s2cell.Region, fn func(p *models.Point)) {
+	for _, cell := range s2cell.Covering(region, s2CoveringCells) {
+		start, end := s.cellRange(cell)
+		for _, p := range s.points[start:end] {
+			fn(p)
+		}
+	}
+}
+
+func (s *s2Store) count() int {
+	return len(s.points)
+}
+
+func (s *s2Store) contains(id string) bool {
+	return s.ids.contains(id)
+}
+
+// box is queryBox for S2 storage
+func (s *s2Store) box(g *GeoIndex, box models.BoundingBox) []*models.Point {
+	var points []*models.Point
+	s.search(s2cell.Rect(box), func(p *models.Point) {
+		if g.inBox(box, *p.Location) {
+			points = append(points, p)
+		}
+	})
+	return points
+}
+
+// within returns the points of S2 storage within radiusKm of center
+func (s *s2Store) within(g *GeoIndex, center models.Location, radiusKm float64) []models.PointDistance {
+	var results []models.PointDistance
+	s.search(s2cell.Cap{Center: center, RadiusKm: radiusKm}, func(p *models.Point) {
+		if dist := center.DistanceTo(*p.Location); dist <= radiusKm {
+			results = append(results, g.pointDistance(p, dist))
+		}
+	})
+	return results
+}
+
+// s2Candidate is a cell or point queued by s2Store.nearest, ordered by its
+// distance or the lower bound on the distance of the points in the cell
+type s2Candidate struct {
+	km    float64
+	cell  s2cell.CellID
+	point *models.Point
+}
+
+type s2Queue []s2Candidate
+
+func (q s2Queue) Len() int           { return len(q) }
+func (q s2Queue) Less(i, j int) bool { return q[i].km < q[j].km }
+func (q s2Queue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *s2Queue) Push(x any)        { *q = append(*q, x.(s2Candidate)) }
+func (q *s2Queue) Pop() any {
+	old := *q
+	c := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return c
+}
+
+// nearest returns the n points nearest to center in S2 storage. It visits
+// cells best-first from the six faces down, queueing the points of cells
+// holding few of them, so a point leaves the queue only once nothing closer
+// is left.
+func (s *s2Store) nearest(g *GeoIndex, center models.Location, n int) []models.PointDistance {
+	if n <= 0 {
+		return nil
+	}
+	var queue s2Queue
+	for face := 0; face < 6; face++ {
+		cell := s2cell.FromFace(face)
+		queue = append(queue, s2Candidate{km: cell.MinDistanceKm(center), cell: cell})
+	}
+	heap.Init(&queue)
+
+	var results []models.PointDistance
+	for queue.Len() > 0 && len(results) < n {
+		c := heap.Pop(&queue).(s2Candidate)
+		if c.point != nil {
+			results = append(results, g.pointDistance(c.point, c.km))
+			continue
+		}
+		start, end := s.cellRange(c.cell)
+		if end-start <= s2LeafPoints || c.cell.IsLeaf() {
+			for _, p := range s.points[start:end] {
+				heap.Push(&queue, s2Candidate{km: center.DistanceTo(*p.Location), point: p})
+			}
+			continue
+		}
+		for _, child := range c.cell.Children() {
+			heap.Push(&queue, s2Candidate{km: child.MinDistanceKm(center), cell: child})
+		}
+	}
+	return results
+}
+
+// stats is Stats for S2 storage, reported as a single partition one level
+// deep
+func (s *s2Store) stats() IndexStats {
+	ps := PartitionStats{
+		Region: models.BoundingBox{
+			BottomLeft: models.Location{Lat: -90, Lon: -180},
+			TopRight:   models.Location{Lat: 90, Lon: 180},
+		},
+		Count: s.count(),
+		Depth: 1,
+	}
+	var bytes int64
+	for _, p := range s.points {
+		ps.Bounds = extend(ps.Bounds, *p.Location)
+		bytes += s2BytesPerPoint + int64(len(p.ID))
+	}
+	stats := IndexStats{
+		Count:          int64(ps.Count),
+		Partitions:     []PartitionStats{ps},
+		EstimatedBytes: bytes,
+	}
+	if ps.Bounds != nil {
+		bounds := *ps.Bounds
+		stats.Bounds = &bounds
+	}
+	return stats
+}
@@ -0,0 +1,92 @@
+package rtree
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+)
+
+// SlowQuery describes a query that took at least the threshold given to
+// WithSlowQueryHook
+type SlowQuery struct {
+	// Kind is the kind of query: "box", "radius" or "nearest"
+	Kind string
+	// Box is the box of a box query
+	Box *models.BoundingBox
+	// Center is the center of a radius or nearest-neighbor query
+	Center *models.Location
+	// Radius is the radius of a radius query, in the index's unit
+	Radius float64
+	// N is the number of neighbors a nearest-neighbor query asked for
+	N int
+	// Results is the number of points returned
+	Results int
+	// Partitions is the number of partitions searched, counting storage
+	// other than partitions as one; zero when the query cache answered. A
+	// nearest-neighbor query counts the partitions of every radius it tried.
+	Partitions int
+	// Duration is how long the query took
+	Duration time.Duration
+}
+
+// slowLog holds the WithSlowQueryHook settings
+type slowLog struct {
+	threshold time.Duration
+	hook      func(q SlowQuery)
+}
+
+// searchedKey is the context key of the counter of partitions a query
+// searched, set while the slow query hook is enabled
+type searchedKey struct{}
+
+// WithSlowQueryHook calls hook with the parameters, result count, partitions
+// searched and duration of every QueryBox, QueryRadius and NearestNeighbors
+// call, including their Context and WithDistance forms, that takes threshold
+// or longer, so production incidents can be diagnosed without wrapping every
+// call. Queries failing validation are not reported. hook runs on the
+// querying goroutine after the query, so it should be quick; it must be safe
+// for concurrent use.
+func WithSlowQueryHook(threshold time.Duration, hook func(q SlowQuery)) Option {
+	return func(g *GeoIndex) {
+		if hook == nil {
+			g.slowLog = nil
+			return
+		}
+		g.slowLog = &slowLog{threshold: threshold, hook: hook}
+	}
+}
+
+// startQuery returns ctx with a counter of the partitions searched while the
+// slow query hook is enabled, and the start time of a query, the zero time
+// without a slow query hook or metrics
+func (g *GeoIndex) startQuery(ctx context.Context) (context.Context, time.Time) {
+	if g.slowLog == nil {
+		if g.metrics == nil {
+			return ctx, time.Time{}
+		}
+		return ctx, time.Now()
+	}
+	return context.WithValue(ctx, searchedKey{}, new(atomic.Int32)), time.Now()
+}
+
+// endQuery reports the query started at start to the metrics, and q to the
+// slow query hook if it took the threshold or longer
+func (g *GeoIndex) endQuery(ctx context.Context, start time.Time, q SlowQuery) {
+	if g.slowLog == nil && g.metrics == nil {
+		return
+	}
+	q.Duration = time.Since(start)
+	if g.metrics != nil {
+		g.metrics.IncQueries(q.Kind)
+		g.metrics.ObserveLatency(q.Kind, q.Duration)
+	}
+	if g.slowLog == nil || q.Duration < g.slowLog.threshold {
+		return
+	}
+	if searched, ok := ctx.Value(searchedKey{}).(*atomic.Int32); ok {
+		q.Partitions = int(searched.Load())
+	}
+	g.slowLog.hook(q)
+}
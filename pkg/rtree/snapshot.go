@@ -0,0 +1,99 @@
+package rtree
+
+import (
+	"context"
+	"sort"
+	"sync/atomic"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+)
+
+// Snapshot is a stable view of a GeoIndex as it was when BeginSnapshot was
+// called. Writes made to the index afterwards, including Clear, do not show
+// through it, and it does not hold any lock, so an export or replication
+// stream can read it for as long as it takes while writers carry on. Its
+// methods are safe for concurrent use.
+type Snapshot struct {
+	g     *GeoIndex
+	state atomic.Pointer[indexState]
+}
+
+// BeginSnapshot returns a view of the index as it is now. Writers swap the
+// index's state rather than change it, so this costs no copy; the snapshot
+// keeps the old state reachable until EndSnapshot.
+func (g *GeoIndex) BeginSnapshot() *Snapshot {
+	s := &Snapshot{g: g}
+	s.state.Store(g.state.Load())
+	g.logger().Debug("snapshot begun", "points", s.Count())
+	return s
+}
+
+// EndSnapshot releases s so the memory of points written over since it began
+// can be reclaimed. Later calls to its methods return ErrSnapshotEnded or
+// nothing; ending it again does nothing.
+func (g *GeoIndex) EndSnapshot(s *Snapshot) {
+	if s.state.Swap(nil) != nil {
+		g.logger().Debug("snapshot ended")
+	}
+}
+
+// Count returns the number of points in the snapshot, zero once it ended
+func (s *Snapshot) Count() int64 {
+	state := s.state.Load()
+	if state == nil {
+		return 0
+	}
+	return state.count
+}
+
+// QueryBox returns the points of the snapshot inside box, as GeoIndex.QueryBox
+// does. The query cache is bypassed, as it holds results of the live index.
+func (s *Snapshot) QueryBox(box models.BoundingBox) ([]*models.Point, error) {
+	state := s.state.Load()
+	if state == nil {
+		return nil, ErrSnapshotEnded
+	}
+	if err := checkBox(box); err != nil {
+		return nil, err
+	}
+	parts := []models.BoundingBox{box}
+	if s.g.wrapLongitudes {
+		parts = splitAtAntimeridian(box)
+	}
+	var results []*models.Point
+	for _, part := range parts {
+		points, err := s.g.searchBox(context.Background(), state, part)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, points...)
+	}
+	if s.g.stableOrder {
+		sort.Slice(results, func(i, j int) bool { return results[i].ID < results[j].ID })
+	}
+	return results, nil
+}
+
+// ForEach calls fn with every point of the snapshot until fn returns false
+func (s *Snapshot) ForEach(fn func(p *models.Point) bool) error {
+	points, err := s.QueryBox(quadWorld)
+	if err != nil {
+		return err
+	}
+	for _, p := range points {
+		if !fn(p) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// SaveToFile writes the points of the snapshot to an index file, as
+// GeoIndex.SaveToFile does
+func (s *Snapshot) SaveToFile(filename string) error {
+	state := s.state.Load()
+	if state == nil {
+		return ErrSnapshotEnded
+	}
+	return s.g.saveState(state, filename)
+}
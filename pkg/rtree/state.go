@@ -0,0 +1,157 @@
+package rtree
+
+import (
+	"math"
+	"slices"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/dhconnelly/rtreego"
+)
+
+// storageMode is the layout an index keeps its points in. The storage options
+// (WithCompactStorage, WithGeohashStorage, WithS2Storage, WithQuadtreeStorage,
+// WithKDTreeStorage, WithGridStorage and WithSpaceTimeStorage) replace each
+// other, so the last one given wins.
+type storageMode int
+
+const (
+	partitionStorage storageMode = iota
+	compactStorage
+	geohashStorage
+	s2Storage
+	quadtreeStorage
+	kdTreeStorage
+	gridStorage
+	spaceTimeStorage
+)
+
+// store is a storage layout replacing the partitions, one per storage option.
+// Stores are immutable: add returns a new store sharing what it did not touch.
+type store interface {
+	// add returns a store holding the store's points and those of points that
+	// have a location, and the number added
+	add(g *GeoIndex, points []*models.Point) (store, int)
+	// without returns a store holding the store's points except those with
+	// an ID in remove, and the number left out
+	without(g *GeoIndex, remove map[string]bool) (store, int)
+	// box returns the points inside box, following the index's edge policy
+	box(g *GeoIndex, box models.BoundingBox) []*models.Point
+	// within returns the points within radiusKm of center, unsorted
+	within(g *GeoIndex, center models.Location, radiusKm float64) []models.PointDistance
+	// nearest returns the n points nearest to center, nearest first
+	nearest(g *GeoIndex, center models.Location, n int) []models.PointDistance
+	// count returns the number of points held
+	count() int
+	// contains reports whether a point with the given ID may be held
+	contains(id string) bool
+	// stats reports the store as IndexStats with a single partition
+	stats() IndexStats
+}
+
+// indexState is an immutable snapshot of the index contents. Writers build a
+// new state from the current one and swap it in, so readers load it without
+// taking a lock and see each IndexPoints call entirely or not at all.
+type indexState struct {
+	partitions []*partition
+	store      store // replaces partitions with a storage option
+	count      int64
+	// shadow holds the indexed points in a plain slice for WithVerification
+	shadow []*models.Point
+}
+
+// everything is a search rect covering any point, including longitudes that
+// were not wrapped into [-180, 180)
+var everything, _ = rtreego.NewRect(
+	rtreego.Point{-math.MaxFloat64 / 2, -math.MaxFloat64 / 2},
+	[]float64{math.MaxFloat64, math.MaxFloat64},
+)
+
+// partition holds the points of one longitude band in immutable trees. New
+// points go into a new tree, which is merged with the trees before it while
+// they are at most twice its size, so a point is rebuilt O(log n) times and a
+// band never holds more than O(log n) trees. ids[i] holds the IDs in trees[i].
+type partition struct {
+	trees []*rtreego.Rtree
+	ids   []idSet
+	count int // points in all trees, so empty partitions are skipped cheaply
+	// bounds is the extent of the points actually stored, valid if count > 0;
+	// it is usually much smaller than the partition's longitude band
+	bounds models.BoundingBox
+}
+
+// with returns a new partition holding p's points and items. A positive
+// falsePositiveRate keeps the IDs of each tree in a bloom filter.
+func (p *partition) with(items []rtreego.Spatial, falsePositiveRate float64) *partition {
+	trees := append(slices.Clone(p.trees), rtreego.NewTree(dimensions, minChildren, maxChildren, items...))
+	ids := append(slices.Clone(p.ids), newIDSet(spatialIDs(items), falsePositiveRate))
+	for n := len(trees); n > 1 && trees[n-2].Size() <= 2*trees[n-1].Size(); n = len(trees) {
+		merged := append(trees[n-2].SearchIntersect(everything), trees[n-1].SearchIntersect(everything)...)
+		trees = append(trees[:n-2], rtreego.NewTree(dimensions, minChildren, maxChildren, merged...))
+		ids = append(ids[:n-2], newIDSet(spatialIDs(merged), falsePositiveRate))
+	}
+	next := &partition{trees: trees, ids: ids, count: p.count, bounds: p.bounds}
+	for _, item := range items {
+		loc := *item.(*spatialPoint).Point.Location
+		if next.count == 0 {
+			next.bounds = models.BoundingBox{BottomLeft: loc, TopRight: loc}
+		} else {
+			next.bounds = next.bounds.Union(models.BoundingBox{BottomLeft: loc, TopRight: loc})
+		}
+		next.count++
+	}
+	return next
+}
+
+// contains reports whether id may be in the partition
+func (p *partition) contains(id string) bool {
+	for _, set := range p.ids {
+		if set.contains(id) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *partition) searchIntersect(bb *rtreego.Rect) []rtreego.Spatial {
+	if len(p.trees) == 1 {
+		return p.trees[0].SearchIntersect(bb)
+	}
+	var results []rtreego.Spatial
+	for _, tree := range p.trees {
+		results = append(results, tree.SearchIntersect(bb)...)
+	}
+	return results
+}
+
+func (p *partition) depth() int {
+	depth := 0
+	for _, tree := range p.trees {
+		depth = max(depth, tree.Depth())
+	}
+	return depth
+}
+
+// emptyState returns a state with no points in the index's storage layout
+func (g *GeoIndex) emptyState() *indexState {
+	switch g.storage {
+	case compactStorage:
+		return &indexState{store: &compactStore{}}
+	case geohashStorage:
+		return &indexState{store: newGeohashStore(g.geohashPrecision, g.idFalsePositives)}
+	case s2Storage:
+		return &indexState{store: &s2Store{falsePositiveRate: g.idFalsePositives}}
+	case quadtreeStorage:
+		return &indexState{store: newQuadtree(g.idFalsePositives)}
+	case kdTreeStorage:
+		return &indexState{store: &kdTree{falsePositiveRate: g.idFalsePositives}}
+	case gridStorage:
+		return &indexState{store: &uniformGrid{cellDeg: g.gridCellDeg, falsePositiveRate: g.idFalsePositives}}
+	case spaceTimeStorage:
+		return &indexState{store: &spaceTimeStore{falsePositiveRate: g.idFalsePositives}}
+	}
+	partitions := make([]*partition, g.numCPU)
+	for i := range partitions {
+		partitions[i] = &partition{}
+	}
+	return &indexState{partitions: partitions}
+}
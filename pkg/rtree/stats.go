@@ -0,0 +1,112 @@
+package rtree
+
+import (
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+)
+
+// estimatedBytesPerPoint approximates the fixed heap cost of one indexed point:
+// the Point and Location structs, the spatialPoint wrapper with its cached
+// trig, its rtreego.Rect, the leaf entry plus a share of the internal nodes
+// above it and its entry in the ID set
+const estimatedBytesPerPoint = 256
+
+// PartitionStats describes one longitude partition of the index
+type PartitionStats struct {
+	Index  int                 `json:"index"`
+	Region models.BoundingBox  `json:"region"`
+	Count  int                 `json:"count"`
+	Depth  int                 `json:"depth"`
+	Bounds *models.BoundingBox `json:"bounds,omitempty"`
+}
+
+// IndexStats summarizes the contents and shape of an index
+type IndexStats struct {
+	Count          int64               `json:"count"`
+	Partitions     []PartitionStats    `json:"partitions"`
+	Bounds         *models.BoundingBox `json:"bounds,omitempty"`
+	EstimatedBytes int64               `json:"estimated_bytes"`
+}
+
+// Stats walks every partition and reports point counts, tree depth, the
+// actual data extent and an estimate of the memory held by the index
+func (g *GeoIndex) Stats() IndexStats {
+	state := g.state.Load()
+	if state.store != nil {
+		return state.store.stats()
+	}
+
+	stats := IndexStats{
+		Count:      state.count,
+		Partitions: make([]PartitionStats, g.numCPU),
+	}
+
+	var overall *models.BoundingBox
+	for i, part := range state.partitions {
+		ps := PartitionStats{
+			Index:  i,
+			Region: g.partitionBounds[i],
+			Count:  part.count,
+			Depth:  part.depth(),
+		}
+
+		for _, result := range part.searchIntersect(everything) {
+			item, ok := result.(*spatialPoint)
+			if !ok || item.Point == nil || item.Point.Location == nil {
+				continue
+			}
+			ps.Bounds = extend(ps.Bounds, *item.Point.Location)
+			overall = extend(overall, *item.Point.Location)
+			stats.EstimatedBytes += estimatedBytesPerPoint + int64(len(item.Point.ID))
+		}
+		stats.Partitions[i] = ps
+	}
+	stats.Bounds = overall
+
+	return stats
+}
+
+// stats is Stats for compact storage, which has a single tree
+func (c *compactStore) stats() IndexStats {
+	ps := PartitionStats{
+		Region: models.BoundingBox{
+			BottomLeft: models.Location{Lat: -90, Lon: -180},
+			TopRight:   models.Location{Lat: 90, Lon: 180},
+		},
+		Count: c.count(),
+		Depth: c.depth(),
+	}
+	var bytes int64
+	for i := range c.lats {
+		ps.Bounds = extend(ps.Bounds, c.location(i))
+		bytes += compactBytesPerPoint + c.idBytes(i)
+	}
+	stats := IndexStats{
+		Count:          int64(ps.Count),
+		Partitions:     []PartitionStats{ps},
+		EstimatedBytes: bytes,
+	}
+	if ps.Bounds != nil {
+		bounds := *ps.Bounds
+		stats.Bounds = &bounds
+	}
+	return stats
+}
+
+// Bounds returns the smallest box containing every indexed point, or
+// ErrEmptyIndex if there are none
+func (g *GeoIndex) Bounds() (models.BoundingBox, error) {
+	bounds := g.Stats().Bounds
+	if bounds == nil {
+		return models.BoundingBox{}, ErrEmptyIndex
+	}
+	return *bounds, nil
+}
+
+// extend grows box to include loc, allocating it on first use
+func extend(box *models.BoundingBox, loc models.Location) *models.BoundingBox {
+	if box == nil {
+		return &models.BoundingBox{BottomLeft: loc, TopRight: loc}
+	}
+	*box = box.Union(models.BoundingBox{BottomLeft: loc, TopRight: loc})
+	return box
+}
@@ -0,0 +1,246 @@
+package rtree
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"maps"
+	"slices"
+
+	"github.com/1F47E/geo-index-rtree/pkg/geohash"
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/dhconnelly/rtreego"
+)
+
+// structureFeature is a GeoJSON polygon feature of DumpStructure
+type structureFeature struct {
+	Type       string            `json:"type"`
+	Geometry   structureGeometry `json:"geometry"`
+	Properties map[string]any    `json:"properties"`
+}
+
+type structureGeometry struct {
+	Type        string         `json:"type"`
+	Coordinates [][][2]float64 `json:"coordinates"`
+}
+
+// structureWriter streams the features of a FeatureCollection
+type structureWriter struct {
+	w     *bufio.Writer
+	enc   *json.Encoder
+	first bool
+}
+
+// feature writes box as a polygon with the kind and level properties and
+// the key-value pairs in props
+func (s *structureWriter) feature(kind string, level int, box models.BoundingBox, props ...any) error {
+	properties := map[string]any{"kind": kind, "level": level}
+	for i := 0; i+1 < len(props); i += 2 {
+		properties[props[i].(string)] = props[i+1]
+	}
+	bl, tr := box.BottomLeft, box.TopRight
+	f := structureFeature{
+		Type: "Feature",
+		Geometry: structureGeometry{
+			Type: "Polygon",
+			Coordinates: [][][2]float64{{
+				{bl.Lon, bl.Lat}, {tr.Lon, bl.Lat}, {tr.Lon, tr.Lat}, {bl.Lon, tr.Lat}, {bl.Lon, bl.Lat},
+			}},
+		},
+		Properties: properties,
+	}
+	if !s.first {
+		if err := s.w.WriteByte(','); err != nil {
+			return err
+		}
+	}
+	s.first = false
+	return s.enc.Encode(f)
+}
+
+// DumpStructure writes the shape of the index to w as a GeoJSON
+// FeatureCollection of polygons, so it can be viewed in QGIS when queries are
+// slower than expected. Every feature has a "kind" and a "level", 0 at the
+// root, and most a "points" count:
+//
+//   - partition storage writes each partition's longitude band ("partition"),
+//     the extent of its points ("extent") and the bounding box of each of its
+//     trees ("tree", level 1); the inner nodes of these trees are not exposed
+//   - compact storage writes the box of every node ("node")
+//   - quadtree storage writes the region of every node ("node")
+//   - k-d tree storage writes the bounding box of every subtree down to
+//     subtrees of 16 points ("node")
+//   - grid and geohash storage write their non-empty cells ("cell")
+//   - space-time storage writes the bounding box of each tree ("tree")
+//   - S2 storage, a sorted list of cells, writes the extent of its points
+//     ("extent")
+func (g *GeoIndex) DumpStructure(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	s := &structureWriter{w: bw, enc: json.NewEncoder(bw), first: true}
+	if _, err := bw.WriteString(`{"type":"FeatureCollection","features":[` + "\n"); err != nil {
+		return err
+	}
+	if err := g.dumpState(s, g.state.Load()); err != nil {
+		return err
+	}
+	if _, err := bw.WriteString("]}\n"); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func (g *GeoIndex) dumpState(s *structureWriter, state *indexState) error {
+	switch store := state.store.(type) {
+	case *compactStore:
+		return dumpCompact(s, store)
+	case *geohashStore:
+		return dumpGeohash(s, store)
+	case *s2Store:
+		if len(store.points) == 0 {
+			return nil
+		}
+		return s.feature("extent", 0, pointsExtent(store.points), "points", len(store.points))
+	case *quadtree:
+		return dumpQuadNode(s, store.root, 0)
+	case *kdTree:
+		return dumpKDTree(s, store)
+	case *uniformGrid:
+		return dumpGrid(s, store)
+	case *spaceTimeStore:
+		for i, tree := range store.trees {
+			if err := dumpTree(s, 0, tree, "tree", i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for i, part := range state.partitions {
+		if err := s.feature("partition", 0, g.partitionBounds[i], "partition", i, "points", part.count); err != nil {
+			return err
+		}
+		if part.count == 0 {
+			continue
+		}
+		if err := s.feature("extent", 0, part.bounds, "partition", i, "points", part.count); err != nil {
+			return err
+		}
+		for j, tree := range part.trees {
+			if err := dumpTree(s, 1, tree, "partition", i, "tree", j, "depth", tree.Depth()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// dumpTree writes the bounding box of the points of an rtreego tree
+func dumpTree(s *structureWriter, level int, tree *rtreego.Rtree, props ...any) error {
+	items := tree.SearchIntersect(everything)
+	if len(items) == 0 {
+		return nil
+	}
+	points := make([]*models.Point, len(items))
+	for i, item := range items {
+		switch item := item.(type) {
+		case *spatialPoint:
+			points[i] = item.Point
+		case *spaceTimePoint:
+			points[i] = item.Point
+		}
+	}
+	return s.feature("tree", level, pointsExtent(points), append(props, "points", len(points))...)
+}
+
+// pointsExtent returns the bounding box of points, which must not be empty
+func pointsExtent(points []*models.Point) models.BoundingBox {
+	box := models.BoundingBox{BottomLeft: *points[0].Location, TopRight: *points[0].Location}
+	for _, p := range points[1:] {
+		box = box.Union(models.BoundingBox{BottomLeft: *p.Location, TopRight: *p.Location})
+	}
+	return box
+}
+
+func dumpCompact(s *structureWriter, c *compactStore) error {
+	for l := len(c.levels) - 1; l >= 0; l-- {
+		boxes := c.levels[l]
+		for j := 0; j < len(boxes); j += 4 {
+			box := models.BoundingBox{
+				BottomLeft: models.Location{Lat: boxes[j], Lon: boxes[j+1]},
+				TopRight:   models.Location{Lat: boxes[j+2], Lon: boxes[j+3]},
+			}
+			if err := s.feature("node", len(c.levels)-1-l, box, "node", j/4); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func dumpQuadNode(s *structureWriter, n *quadNode, depth int) error {
+	if n.children == nil {
+		return s.feature("node", depth, n.region, "points", len(n.points))
+	}
+	if err := s.feature("node", depth, n.region); err != nil {
+		return err
+	}
+	for _, child := range n.children {
+		if err := dumpQuadNode(s, child, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dumpKDTree(s *structureWriter, t *kdTree) error {
+	var visit func(lo, hi, depth int) error
+	visit = func(lo, hi, depth int) error {
+		if lo >= hi {
+			return nil
+		}
+		points := make([]*models.Point, hi-lo)
+		for i := range points {
+			points[i] = t.nodes[lo+i].point
+		}
+		if err := s.feature("node", depth, pointsExtent(points), "points", len(points)); err != nil {
+			return err
+		}
+		if hi-lo <= compactNodeSize {
+			return nil
+		}
+		mid := (lo + hi) / 2
+		if err := visit(lo, mid, depth+1); err != nil {
+			return err
+		}
+		return visit(mid+1, hi, depth+1)
+	}
+	return visit(0, len(t.nodes), 0)
+}
+
+func dumpGrid(s *structureWriter, q *uniformGrid) error {
+	for i, cell := range q.cells {
+		if len(cell) == 0 {
+			continue
+		}
+		row, col := i/q.cols, i%q.cols
+		bl := models.Location{Lat: q.lat0 + float64(row)*q.cellDeg, Lon: q.lon0 + float64(col)*q.cellDeg}
+		box := models.BoundingBox{BottomLeft: bl, TopRight: models.Location{Lat: bl.Lat + q.cellDeg, Lon: bl.Lon + q.cellDeg}}
+		if err := s.feature("cell", 0, box, "row", row, "col", col, "points", len(cell)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func dumpGeohash(s *structureWriter, store *geohashStore) error {
+	for _, hash := range slices.Sorted(maps.Keys(store.cells)) {
+		box, err := geohash.DecodeBounds(hash)
+		if err != nil {
+			return err
+		}
+		if err := s.feature("cell", 0, box, "geohash", hash, "points", len(store.cells[hash])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
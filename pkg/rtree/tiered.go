@@ -0,0 +1,598 @@
+package rtree
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+)
+
+const (
+	// DefaultTieredRegionDeg is the region size of a TieredIndex when none is
+	// configured
+	DefaultTieredRegionDeg = 5.0
+	// tieredManifest records the region size of a tiered directory, so it is
+	// reopened with the layout its files were written with
+	tieredManifest = "tiered.json"
+)
+
+// TieredConfig configures a TieredIndex
+type TieredConfig struct {
+	// Dir holds one index file per region. It is created if missing; region
+	// files already in it are opened.
+	Dir string
+	// MaxResidentPoints is the number of points kept in memory before the
+	// least recently used regions are dropped; 0 keeps every loaded region
+	MaxResidentPoints int64
+	// RegionDeg is the side of the square regions in degrees: 0 uses the
+	// size Dir was written with, or DefaultTieredRegionDeg for a new Dir
+	RegionDeg float64
+	// Options configure the GeoIndex of every region. Regions report no
+	// metrics, and their logger only receives region loads and evictions.
+	Options []Option
+}
+
+// TieredIndex splits a dataset into square latitude/longitude regions, each a
+// GeoIndex saved as an index file, and keeps only the most recently used
+// regions in memory. Regions are loaded on demand when a query or insert
+// touches them, and the least recently used ones are dropped once the
+// resident regions hold more than MaxResidentPoints, so datasets larger than
+// RAM stay queryable: queries on hot regions run at in-memory speed, the rest
+// pay for reading region files. Changed regions are written back when they
+// are dropped and by Flush and Close.
+//
+// Methods are safe for concurrent use. A region is never dropped while a
+// query or insert is using it, so the budget is exceeded while more regions
+// than fit are in use at once.
+type TieredIndex struct {
+	dir         string
+	regionDeg   float64
+	maxResident int64
+	opts        []Option
+	// template holds the options shared by every region, such as the unit
+	template *GeoIndex
+
+	mu       sync.Mutex
+	regions  map[tierKey]*tierRegion // every region with points
+	lru      *list.List              // resident regions, most recently used first
+	resident int64                   // points in resident regions
+	count    int64
+	stats    TieredStats
+	err      error
+}
+
+// TieredStats reports the residency of a TieredIndex's regions
+type TieredStats struct {
+	Count           int64 `json:"count"`
+	Regions         int   `json:"regions"`
+	ResidentRegions int   `json:"resident_regions"`
+	ResidentPoints  int64 `json:"resident_points"`
+	Hits            int64 `json:"hits"`      // region uses served from memory
+	Loads           int64 `json:"loads"`     // regions read from disk
+	Evictions       int64 `json:"evictions"` // regions dropped from memory
+	WriteBacks      int64 `json:"write_backs"`
+}
+
+type tierKey struct{ row, col int }
+
+// tierRegion is one region of a TieredIndex. index is nil unless the region
+// is resident; loadMu serializes loading and writing it. pins counts the
+// callers using the region, which keep it from being dropped. version counts
+// the changes, so a write-back sees changes made while it ran.
+type tierRegion struct {
+	key     tierKey
+	count   int64
+	onDisk  bool
+	dirty   bool
+	version int64
+	pins    int
+	index   *GeoIndex
+	elem    *list.Element
+	loadMu  sync.Mutex
+}
+
+// NewTieredIndex opens the tiered index in c.Dir, reading the point counts of
+// its region files but none of their points
+func NewTieredIndex(c TieredConfig) (*TieredIndex, error) {
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", c.Dir, err)
+	}
+
+	var manifest struct {
+		RegionDeg float64 `json:"region_deg"`
+	}
+	manifestPath := filepath.Join(c.Dir, tieredManifest)
+	data, err := os.ReadFile(manifestPath)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrCorruptFile, manifestPath, err)
+		}
+		if c.RegionDeg != 0 && c.RegionDeg != manifest.RegionDeg {
+			return nil, fmt.Errorf("%s holds %v degree regions, not %v", c.Dir, manifest.RegionDeg, c.RegionDeg)
+		}
+	case errors.Is(err, os.ErrNotExist):
+		manifest.RegionDeg = c.RegionDeg
+		if !(manifest.RegionDeg > 0) {
+			manifest.RegionDeg = DefaultTieredRegionDeg
+		}
+		data, _ := json.Marshal(manifest)
+		if err := os.WriteFile(manifestPath, data, 0o644); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", manifestPath, err)
+		}
+	default:
+		return nil, fmt.Errorf("failed to read %s: %w", manifestPath, err)
+	}
+
+	t := &TieredIndex{
+		dir:         c.Dir,
+		regionDeg:   manifest.RegionDeg,
+		maxResident: c.MaxResidentPoints,
+		opts:        append(slices.Clip(c.Options), WithLogger(nil), WithMetrics(nil)),
+		template:    NewGeoIndexWithWorkers(1, c.Options...),
+		regions:     make(map[tierKey]*tierRegion),
+		lru:         list.New(),
+	}
+
+	files, err := filepath.Glob(filepath.Join(c.Dir, "region_*.gob"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		var key tierKey
+		if _, err := fmt.Sscanf(filepath.Base(file), "region_%d_%d.gob", &key.row, &key.col); err != nil {
+			continue
+		}
+		header, err := ReadHeader(file)
+		if err != nil {
+			return nil, err
+		}
+		t.regions[key] = &tierRegion{key: key, count: header.Count, onDisk: true}
+		t.count += header.Count
+	}
+	return t, nil
+}
+
+// regionPath is the index file of a region
+func (t *TieredIndex) regionPath(key tierKey) string {
+	return filepath.Join(t.dir, fmt.Sprintf("region_%d_%d.gob", key.row, key.col))
+}
+
+// rows and cols are the number of regions along each axis
+func (t *TieredIndex) rows() int { return int(math.Ceil(180 / t.regionDeg)) }
+func (t *TieredIndex) cols() int { return int(math.Ceil(360 / t.regionDeg)) }
+
+// regionRow and regionCol return the region coordinates of a latitude and a
+// longitude, clamped to the globe
+func (t *TieredIndex) regionRow(lat float64) int {
+	return cellIndex((lat+90)/t.regionDeg, 0, t.rows()-1)
+}
+
+func (t *TieredIndex) regionCol(lon float64) int {
+	return cellIndex((lon+180)/t.regionDeg, 0, t.cols()-1)
+}
+
+// keysIn returns the regions with points overlapping box
+func (t *TieredIndex) keysIn(box models.BoundingBox) []tierKey {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var keys []tierKey
+	for row := t.regionRow(box.BottomLeft.Lat); row <= t.regionRow(box.TopRight.Lat); row++ {
+		for col := t.regionCol(box.BottomLeft.Lon); col <= t.regionCol(box.TopRight.Lon); col++ {
+			if r, ok := t.regions[tierKey{row, col}]; ok && r.count > 0 {
+				keys = append(keys, r.key)
+			}
+		}
+	}
+	return keys
+}
+
+// acquire pins a region and makes it resident, creating it if create is set.
+// It returns nil for a missing region that is not created. Release the region
+// with release.
+func (t *TieredIndex) acquire(key tierKey, create bool) (*tierRegion, error) {
+	t.mu.Lock()
+	r, ok := t.regions[key]
+	if !ok {
+		if !create {
+			t.mu.Unlock()
+			return nil, nil
+		}
+		r = &tierRegion{key: key}
+		t.regions[key] = r
+	}
+	r.pins++
+	t.mu.Unlock()
+
+	r.loadMu.Lock()
+	defer r.loadMu.Unlock()
+	if r.index != nil {
+		t.mu.Lock()
+		t.lru.MoveToFront(r.elem)
+		t.stats.Hits++
+		t.mu.Unlock()
+		return r, nil
+	}
+
+	index := NewGeoIndexWithWorkers(1, t.opts...)
+	if r.onDisk {
+		if err := index.LoadFromFile(t.regionPath(key)); err != nil {
+			t.template.logger().Error("region load failed", "file", t.regionPath(key), "error", err)
+			t.release(r)
+			return nil, err
+		}
+		t.template.logger().Debug("region loaded", "file", t.regionPath(key), "points", index.Count())
+	}
+	t.mu.Lock()
+	r.index = index
+	r.elem = t.lru.PushFront(r)
+	t.resident += r.count
+	if r.onDisk {
+		t.stats.Loads++
+	}
+	t.mu.Unlock()
+	return r, nil
+}
+
+// release unpins a region and drops regions over the budget
+func (t *TieredIndex) release(r *tierRegion) {
+	t.mu.Lock()
+	r.pins--
+	t.mu.Unlock()
+	t.evict()
+}
+
+// evict drops unpinned regions, least recently used first, while the
+// resident points exceed the budget, writing changed ones back first. The
+// files are written without holding t.mu; regions used or changed meanwhile
+// stay resident. Regions that fail to write stay resident and the error is
+// kept for Err.
+func (t *TieredIndex) evict() {
+	if t.maxResident <= 0 {
+		return
+	}
+	for _, r := range t.victims() {
+		err := t.writeBack(r)
+		t.mu.Lock()
+		r.pins--
+		switch {
+		case err != nil:
+			t.err = err
+			t.template.logger().Error("region write-back failed", "file", t.regionPath(r.key), "error", err)
+		case r.pins == 0 && !r.dirty:
+			t.lru.Remove(r.elem)
+			r.index, r.elem = nil, nil
+			t.resident -= r.count
+			t.stats.Evictions++
+			t.template.logger().Debug("region evicted", "file", t.regionPath(r.key), "points", r.count)
+		}
+		t.mu.Unlock()
+	}
+}
+
+// victims pins and returns the unpinned resident regions to drop, least
+// recently used first, for the resident points to fit the budget
+func (t *TieredIndex) victims() []*tierRegion {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var victims []*tierRegion
+	over := t.resident - t.maxResident
+	for e := t.lru.Back(); e != nil && over > 0; e = e.Prev() {
+		if r := e.Value.(*tierRegion); r.pins == 0 {
+			r.pins++
+			over -= r.count
+			victims = append(victims, r)
+		}
+	}
+	return victims
+}
+
+// writeBack saves a changed resident region, replacing its file atomically.
+// The caller pins the region and does not hold t.mu. A region changed while
+// it is written stays dirty.
+func (t *TieredIndex) writeBack(r *tierRegion) error {
+	r.loadMu.Lock()
+	defer r.loadMu.Unlock()
+	t.mu.Lock()
+	dirty, version := r.dirty, r.version
+	t.mu.Unlock()
+	if !dirty {
+		return nil
+	}
+
+	path := t.regionPath(r.key)
+	if err := r.index.SaveToFile(path + ".tmp"); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r.dirty = r.version != version
+	r.onDisk = true
+	t.stats.WriteBacks++
+	return nil
+}
+
+// IndexPoints adds points to the regions holding their locations, loading
+// or creating those regions as needed
+func (t *TieredIndex) IndexPoints(points []*models.Point) error {
+	batches := make(map[tierKey][]*models.Point)
+	for _, p := range points {
+		if p.Location == nil {
+			continue
+		}
+		lon := p.Location.Lon
+		if t.template.wrapLongitudes {
+			lon = models.NormalizeLongitude(lon)
+		}
+		key := tierKey{t.regionRow(p.Location.Lat), t.regionCol(lon)}
+		batches[key] = append(batches[key], p)
+	}
+
+	for key, batch := range batches {
+		r, err := t.acquire(key, true)
+		if err != nil {
+			return err
+		}
+		err = r.index.IndexPoints(batch)
+		t.mu.Lock()
+		added := r.index.Count() - r.count
+		r.count += added
+		t.resident += added
+		t.count += added
+		r.dirty = true
+		r.version++
+		t.mu.Unlock()
+		t.release(r)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QueryBox returns the points inside box, loading the regions it overlaps
+func (t *TieredIndex) QueryBox(box models.BoundingBox) ([]*models.Point, error) {
+	if err := checkBox(box); err != nil {
+		return nil, err
+	}
+	parts := []models.BoundingBox{box}
+	if t.template.wrapLongitudes {
+		parts = splitAtAntimeridian(box)
+	}
+
+	var results []*models.Point
+	for _, part := range parts {
+		for _, key := range t.keysIn(part) {
+			r, err := t.acquire(key, false)
+			if err != nil {
+				return nil, err
+			}
+			if r == nil {
+				continue
+			}
+			points, err := r.index.QueryBox(part)
+			t.release(r)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, points...)
+		}
+	}
+	if t.template.stableOrder {
+		sort.Slice(results, func(i, j int) bool { return results[i].ID < results[j].ID })
+	}
+	return results, nil
+}
+
+// QueryRadius returns the points within radius of center, in the unit of the
+// region options, loading the regions the circle overlaps
+func (t *TieredIndex) QueryRadius(center models.Location, radius float64) ([]*models.Point, error) {
+	results, err := t.QueryRadiusWithDistance(center, radius)
+	if err != nil {
+		return nil, err
+	}
+	points := make([]*models.Point, len(results))
+	for i, r := range results {
+		points[i] = r.Point
+	}
+	return points, nil
+}
+
+// QueryRadiusWithDistance is QueryRadius with each point's distance from
+// center, sorted nearest first with ties broken by ID
+func (t *TieredIndex) QueryRadiusWithDistance(center models.Location, radius float64) ([]models.PointDistance, error) {
+	center, radiusKm, err := t.template.checkRadius(center, radius)
+	if err != nil {
+		return nil, err
+	}
+	results, err := t.within(center, radiusKm)
+	if err != nil {
+		return nil, err
+	}
+	sortByDistance(results)
+	return results, nil
+}
+
+// within returns the points within radiusKm of a checked center, unsorted.
+// Each region searches the whole circle, so the regions overlapping the
+// parts of its box are searched once.
+func (t *TieredIndex) within(center models.Location, radiusKm float64) ([]models.PointDistance, error) {
+	var keys []tierKey
+	for _, box := range t.template.radiusBoxes(center, radiusKm) {
+		for _, key := range t.keysIn(box) {
+			if !slices.Contains(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	var results []models.PointDistance
+	for _, key := range keys {
+		r, err := t.acquire(key, false)
+		if err != nil {
+			return nil, err
+		}
+		if r == nil {
+			continue
+		}
+		points, err := r.index.queryRadius(context.Background(), center, t.template.unit.FromKm(radiusKm))
+		t.release(r)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, points...)
+	}
+	return results, nil
+}
+
+// NearestNeighbors returns up to n points nearest to center, nearest first.
+// It widens a radius search from one region until it holds n points, so it
+// loads the regions around center. If a region cannot be loaded the result
+// is nil and Err reports why.
+func (t *TieredIndex) NearestNeighbors(center models.Location, n int) []*models.Point {
+	results := t.NearestNeighborsWithDistance(center, n)
+	points := make([]*models.Point, len(results))
+	for i, r := range results {
+		points[i] = r.Point
+	}
+	return points
+}
+
+// NearestNeighborsWithDistance is NearestNeighbors with each point's distance
+// from center
+func (t *TieredIndex) NearestNeighborsWithDistance(center models.Location, n int) []models.PointDistance {
+	if n <= 0 || t.Count() == 0 {
+		return nil
+	}
+	if t.template.wrapLongitudes {
+		center.Lon = models.NormalizeLongitude(center.Lon)
+	}
+	var results []models.PointDistance
+	for radiusKm := t.regionDeg * math.Pi / 180 * earthRadius; ; radiusKm *= 2 {
+		var err error
+		if results, err = t.within(center, radiusKm); err != nil {
+			t.mu.Lock()
+			t.err = err
+			t.mu.Unlock()
+			return nil
+		}
+		if len(results) >= n || radiusKm >= math.Pi*earthRadius {
+			break
+		}
+	}
+	sortByDistance(results)
+	if len(results) > n {
+		results = results[:n]
+	}
+	return results
+}
+
+// Count returns the number of points in every region, resident or not
+func (t *TieredIndex) Count() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.count
+}
+
+// Stats reports how many regions and points are resident and how often
+// regions were loaded and dropped
+func (t *TieredIndex) Stats() TieredStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stats := t.stats
+	stats.Count = t.count
+	stats.Regions = len(t.regions)
+	stats.ResidentRegions = t.lru.Len()
+	stats.ResidentPoints = t.resident
+	return stats
+}
+
+// Err returns the last error of a region that could not be written back when
+// dropped or loaded by NearestNeighbors
+func (t *TieredIndex) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+// Flush writes every changed resident region to its file. The regions are
+// pinned while their files are written, without holding t.mu.
+func (t *TieredIndex) Flush() error {
+	t.mu.Lock()
+	regions := make([]*tierRegion, 0, t.lru.Len())
+	for e := t.lru.Front(); e != nil; e = e.Next() {
+		r := e.Value.(*tierRegion)
+		r.pins++
+		regions = append(regions, r)
+	}
+	t.mu.Unlock()
+
+	var err error
+	for _, r := range regions {
+		if err == nil {
+			err = t.writeBack(r)
+		}
+		t.mu.Lock()
+		r.pins--
+		t.mu.Unlock()
+	}
+	return err
+}
+
+// Close flushes the index. It stays usable.
+func (t *TieredIndex) Close() error {
+	return t.Flush()
+}
+
+// SaveToFile writes every point of every region to a single index file,
+// loading the regions one at a time. The points are collected in memory, so
+// the file is limited by RAM like any GeoIndex file.
+func (t *TieredIndex) SaveToFile(path string) error {
+	t.mu.Lock()
+	keys := make([]tierKey, 0, len(t.regions))
+	for key := range t.regions {
+		keys = append(keys, key)
+	}
+	t.mu.Unlock()
+
+	all := NewGeoIndexWithWorkers(1, t.opts...)
+	for _, key := range keys {
+		r, err := t.acquire(key, false)
+		if err != nil {
+			return err
+		}
+		if r == nil {
+			continue
+		}
+		points, err := r.index.QueryBox(quadWorld)
+		t.release(r)
+		if err != nil {
+			return err
+		}
+		if err := all.IndexPoints(points); err != nil {
+			return err
+		}
+	}
+	return all.SaveToFile(path)
+}
+
+// LoadFromFile adds the points of an index file to their regions
+func (t *TieredIndex) LoadFromFile(path string) error {
+	points, err := ReadPoints(path)
+	if err != nil {
+		return err
+	}
+	return t.IndexPoints(points)
+}
@@ -0,0 +1,222 @@
+package rtree
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+)
+
+// verifyToleranceKm is how close to a radius query's edge, or to the distance
+// of the last nearest neighbor, a point may be and still be left out or let in
+// without counting as a discrepancy, as distances round differently
+const verifyToleranceKm = 1e-9
+
+// Discrepancy describes a query whose results differ from a linear scan of
+// the same points
+type Discrepancy struct {
+	// Query is the kind of query: "box", "radius" or "nearest"
+	Query string
+	// Missing holds the IDs the scan found that the query did not return
+	Missing []string
+	// Extra holds the IDs the query returned that the scan did not find
+	Extra []string
+}
+
+func (d *Discrepancy) Error() string {
+	return fmt.Sprintf("%s query differs from a linear scan: missing [%s], extra [%s]",
+		d.Query, strings.Join(d.Missing, ", "), strings.Join(d.Extra, ", "))
+}
+
+// Unwrap returns ErrDiscrepancy
+func (d *Discrepancy) Unwrap() error { return ErrDiscrepancy }
+
+// verifier checks query results against a linear scan of indexState.shadow
+type verifier struct {
+	report func(d Discrepancy)
+	found  atomic.Int64
+}
+
+// WithVerification shadows every QueryBox, QueryRadius and NearestNeighbors
+// call, including their Context and WithDistance forms, with a linear scan of
+// a plain copy of the indexed points and compares the results, so integration
+// tests and canary deployments catch bugs in the storage layouts and the
+// query cache. Each discrepancy is passed to report; when report is nil, box
+// and radius queries fail with it as their error instead, matching
+// ErrDiscrepancy, and Discrepancies counts them all. Nearest-neighbor results
+// only differ where distances do, not on ties. Each query is checked against
+// the points it searched, so queries racing a write are checked too.
+//
+// The scan makes every query O(n) and the copy holds a pointer per point, so
+// this is meant for tests and canaries, not production traffic.
+func WithVerification(report func(d Discrepancy)) Option {
+	return func(g *GeoIndex) {
+		g.verifier = &verifier{report: report}
+	}
+}
+
+// Discrepancies returns the number of queries whose results differed from the
+// linear scan since the index was created, zero without WithVerification
+func (g *GeoIndex) Discrepancies() int64 {
+	if g.verifier == nil {
+		return 0
+	}
+	return g.verifier.found.Load()
+}
+
+// shadowed returns the shadow of a state replacing old after points were
+// indexed: old's shadow and the points with a location, wrapped as they are
+// stored. It is nil without WithVerification.
+func (g *GeoIndex) shadowed(old *indexState, points []*models.Point) []*models.Point {
+	if g.verifier == nil {
+		return nil
+	}
+	shadow := slices.Clip(old.shadow)
+	for _, p := range points {
+		if p.Location == nil {
+			continue
+		}
+		if g.wrapLongitudes {
+			p = wrapPoint(p)
+		}
+		shadow = append(shadow, p)
+	}
+	return shadow
+}
+
+// shadowedWithout returns the shadow of a state replacing old after the points
+// with an ID in remove were deleted. It is nil without WithVerification.
+func (g *GeoIndex) shadowedWithout(old *indexState, remove map[string]bool) []*models.Point {
+	if g.verifier == nil {
+		return nil
+	}
+	return slices.DeleteFunc(slices.Clone(old.shadow), func(p *models.Point) bool { return remove[p.ID] })
+}
+
+// check records a discrepancy between the IDs a query returned and the IDs a
+// scan required and allowed, and returns it as an error when there is no
+// report function. The scan's required IDs must be returned, and nothing
+// outside allowed may be.
+func (g *GeoIndex) check(kind string, got []string, required, allowed map[string]bool) error {
+	returned := make(map[string]bool, len(got))
+	d := Discrepancy{Query: kind}
+	for _, id := range got {
+		returned[id] = true
+		if !allowed[id] {
+			d.Extra = append(d.Extra, id)
+		}
+	}
+	for id := range required {
+		if !returned[id] {
+			d.Missing = append(d.Missing, id)
+		}
+	}
+	if len(d.Missing) == 0 && len(d.Extra) == 0 {
+		return nil
+	}
+	slices.Sort(d.Missing)
+	slices.Sort(d.Extra)
+	g.verifier.found.Add(1)
+	g.logger().Warn("query differs from a linear scan", "query", kind, "missing", len(d.Missing), "extra", len(d.Extra))
+	if g.verifier.report != nil {
+		g.verifier.report(d)
+		return nil
+	}
+	return &d
+}
+
+// verifyBox checks the points a box query returned from state against a scan
+func (g *GeoIndex) verifyBox(state *indexState, box models.BoundingBox, results []*models.Point) error {
+	parts := []models.BoundingBox{box}
+	if g.wrapLongitudes {
+		parts = splitAtAntimeridian(box)
+	}
+	inside := make(map[string]bool)
+	for _, p := range state.shadow {
+		for _, part := range parts {
+			if g.inBox(part, *p.Location) {
+				inside[p.ID] = true
+				break
+			}
+		}
+	}
+	got := make([]string, len(results))
+	for i, p := range results {
+		got[i] = p.ID
+	}
+	return g.check(queryKindBox, got, inside, inside)
+}
+
+// scanDistance is the distance from center to loc in kilometers, in 3D with
+// WithAltitude
+func (g *GeoIndex) scanDistance(center, loc models.Location) float64 {
+	if g.altitude {
+		return center.Distance3DTo(loc)
+	}
+	return center.DistanceTo(loc)
+}
+
+// verifyRadius checks the results a radius query on a checked center
+// returned from state against a scan of it
+func (g *GeoIndex) verifyRadius(state *indexState, center models.Location, radiusKm float64, results []models.PointDistance) error {
+	required, allowed := make(map[string]bool), make(map[string]bool)
+	for _, p := range state.shadow {
+		dist := g.scanDistance(center, *p.Location)
+		if dist <= radiusKm-verifyToleranceKm {
+			required[p.ID] = true
+		}
+		if dist <= radiusKm+verifyToleranceKm {
+			allowed[p.ID] = true
+		}
+	}
+	got := make([]string, len(results))
+	for i, r := range results {
+		got[i] = r.Point.ID
+	}
+	return g.check(queryKindRadius, got, required, allowed)
+}
+
+// verifyNearest checks the results a nearest-neighbor query on a wrapped
+// center returned from state against a scan of it.
+// Points further than the scan's nth nearest must not be returned, and points
+// nearer than it, or within it and nearer than a returned point, must be.
+func (g *GeoIndex) verifyNearest(state *indexState, center models.Location, n int, results []models.PointDistance) {
+	if n <= 0 {
+		return
+	}
+	dists := make([]float64, len(state.shadow))
+	for i, p := range state.shadow {
+		dists[i] = g.scanDistance(center, *p.Location)
+	}
+	sorted := slices.Clone(dists)
+	slices.Sort(sorted)
+	nth := math.Inf(1)
+	if len(sorted) >= n {
+		nth = sorted[n-1]
+	}
+
+	got := make([]string, len(results))
+	farthest := 0.0
+	for i, r := range results {
+		got[i] = r.Point.ID
+		farthest = max(farthest, g.scanDistance(center, *r.Point.Location))
+	}
+	required, allowed := make(map[string]bool), make(map[string]bool)
+	for i, p := range state.shadow {
+		if dists[i] <= nth+verifyToleranceKm {
+			allowed[p.ID] = true
+			if dists[i] < max(nth, farthest)-verifyToleranceKm {
+				required[p.ID] = true
+			}
+		}
+	}
+	if len(results) < min(n, len(sorted)) {
+		// Ties cannot explain returning too few points
+		required = allowed
+	}
+	// Nearest-neighbor queries have no error to return
+	_ = g.check(queryKindNearest, got, required, allowed)
+}
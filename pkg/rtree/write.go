@@ -0,0 +1,171 @@
+package rtree
+
+import (
+	"slices"
+	"time"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/dhconnelly/rtreego"
+)
+
+// lockPartitions locks the partition locks of indices, which must be
+// ascending so writers never wait for each other in a cycle, and returns the
+// function unlocking them
+func (g *GeoIndex) lockPartitions(indices []int) func() {
+	for _, i := range indices {
+		g.partMu[i].Lock()
+	}
+	return func() {
+		for _, i := range indices {
+			g.partMu[i].Unlock()
+		}
+	}
+}
+
+// allPartitions returns the indices of every partition
+func (g *GeoIndex) allPartitions() []int {
+	indices := make([]int, g.numCPU)
+	for i := range indices {
+		indices[i] = i
+	}
+	return indices
+}
+
+// publish swaps in the state next builds from the current one, retrying when
+// a writer of other partitions swapped first. next must only replace the
+// partitions whose locks the caller holds.
+func (g *GeoIndex) publish(next func(cur *indexState) *indexState) {
+	for {
+		cur := g.state.Load()
+		if g.state.CompareAndSwap(cur, next(cur)) {
+			break
+		}
+	}
+	g.cache.purge()
+}
+
+// Insert adds one point to the index, as IndexPoints does. With partition
+// storage it only locks the partition the point falls in.
+func (g *GeoIndex) Insert(point *models.Point) error {
+	return g.IndexPoints([]*models.Point{point})
+}
+
+// Delete removes every point with one of ids and returns how many it
+// removed. With partition storage it only locks the partitions whose ID sets
+// may hold one of ids, and rebuilds only the trees that do; queries and
+// writes elsewhere carry on. A partition's extent is not shrunk. Other
+// storage layouts take turns with IndexPoints and rebuild the parts holding
+// the points.
+func (g *GeoIndex) Delete(ids ...string) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	start := time.Now()
+	remove := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		remove[id] = true
+	}
+
+	var removed int
+	if g.storage != partitionStorage {
+		removed = g.deleteFromStore(ids, remove)
+	} else {
+		removed = g.deleteFromPartitions(ids, remove)
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	g.metricsSink().SetPointCount(g.Count())
+	g.logger().Debug("points deleted", "ids", len(ids), "removed", removed, "duration", time.Since(start))
+	return removed, nil
+}
+
+// deleteFromStore removes the points with an ID in remove from a store
+// without partitions under writeMu and returns how many it removed
+func (g *GeoIndex) deleteFromStore(ids []string, remove map[string]bool) int {
+	g.writeMu.Lock()
+	defer g.writeMu.Unlock()
+	old := g.state.Load()
+	if !slices.ContainsFunc(ids, old.store.contains) {
+		return 0
+	}
+	next, removed := old.store.without(g, remove)
+	if removed == 0 {
+		return 0
+	}
+	g.state.Store(&indexState{store: next, count: old.count - int64(removed), shadow: g.shadowedWithout(old, remove)})
+	g.cache.purge()
+	return removed
+}
+
+// deleteFromPartitions removes the points with an ID in remove from the
+// partitions that may hold them and returns how many it removed
+func (g *GeoIndex) deleteFromPartitions(ids []string, remove map[string]bool) int {
+	var touched []int
+	for i, part := range g.state.Load().partitions {
+		if part.count > 0 && slices.ContainsFunc(ids, part.contains) {
+			touched = append(touched, i)
+		}
+	}
+	unlock := g.lockPartitions(touched)
+	defer unlock()
+	old := g.state.Load()
+	built := make(map[int]*partition, len(touched))
+	removed := 0
+	for _, i := range touched {
+		part, n := old.partitions[i].without(remove, g.idFalsePositives/float64(g.numCPU*idFilterTrees))
+		if n > 0 {
+			built[i] = part
+			removed += n
+		}
+	}
+	if removed == 0 {
+		return 0
+	}
+	g.publish(func(cur *indexState) *indexState {
+		next := &indexState{partitions: slices.Clone(cur.partitions), count: cur.count - int64(removed), shadow: g.shadowedWithout(cur, remove)}
+		for i, part := range built {
+			next.partitions[i] = part
+		}
+		return next
+	})
+	return removed
+}
+
+// without returns a partition holding p's points except those with an ID in
+// remove, and the number left out. Trees whose ID sets hold none of them are
+// shared.
+func (p *partition) without(remove map[string]bool, falsePositiveRate float64) (*partition, int) {
+	next := &partition{count: p.count, bounds: p.bounds}
+	removed := 0
+	for j, tree := range p.trees {
+		hit := false
+		for id := range remove {
+			if p.ids[j].contains(id) {
+				hit = true
+				break
+			}
+		}
+		if !hit {
+			next.trees = append(next.trees, tree)
+			next.ids = append(next.ids, p.ids[j])
+			continue
+		}
+		items := tree.SearchIntersect(everything)
+		kept := slices.DeleteFunc(items, func(item rtreego.Spatial) bool {
+			return remove[item.(*spatialPoint).Point.ID]
+		})
+		if len(kept) == tree.Size() {
+			next.trees = append(next.trees, tree)
+			next.ids = append(next.ids, p.ids[j])
+			continue
+		}
+		removed += tree.Size() - len(kept)
+		if len(kept) > 0 {
+			next.trees = append(next.trees, rtreego.NewTree(dimensions, minChildren, maxChildren, kept...))
+			next.ids = append(next.ids, newIDSet(spatialIDs(kept), falsePositiveRate))
+		}
+	}
+	next.count -= removed
+	return next, removed
+}
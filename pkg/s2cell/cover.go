@@ -0,0 +1,127 @@
+package s2cell
+
+import (
+	"math"
+	"sort"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+)
+
+// DefaultMaxCells is the covering size used when Covering is given no limit
+const DefaultMaxCells = 16
+
+// Region is an area of the sphere that can be approximated by cells
+type Region interface {
+	// MayIntersect reports whether the region may overlap the cell; false
+	// means it certainly does not
+	MayIntersect(c CellID) bool
+	// ContainsCell reports whether the region certainly holds the whole cell
+	ContainsCell(c CellID) bool
+}
+
+// Cap is the set of points within RadiusKm of Center along the surface
+type Cap struct {
+	Center   models.Location
+	RadiusKm float64
+}
+
+func (c Cap) angles(cell CellID) (distance, capRadius, cellRadius float64) {
+	center, cellRadius := cell.bound()
+	return angle(latLonToXYZ(c.Center.Lat, c.Center.Lon), center), c.RadiusKm / earthRadiusKm, cellRadius
+}
+
+// MayIntersect reports whether the cap may overlap the cell
+func (c Cap) MayIntersect(cell CellID) bool {
+	distance, capRadius, cellRadius := c.angles(cell)
+	return distance <= capRadius+cellRadius
+}
+
+// ContainsCell reports whether the cap holds the whole cell
+func (c Cap) ContainsCell(cell CellID) bool {
+	distance, capRadius, cellRadius := c.angles(cell)
+	return distance+cellRadius <= capRadius
+}
+
+// Rect is a latitude/longitude box. Longitudes are taken as given, so a box
+// crossing the antimeridian must be split into two.
+type Rect models.BoundingBox
+
+// latLonBound returns a box around the cell. Its longitudes may pass ±180
+// and span every longitude when the cell is near a pole.
+func latLonBound(cell CellID) models.BoundingBox {
+	center, radius := cell.bound()
+	loc := xyzToLocation(center)
+	return models.NewBoundingBoxFromCenter(loc, radius*earthRadiusKm)
+}
+
+// MayIntersect reports whether the box may overlap the cell
+func (r Rect) MayIntersect(cell CellID) bool {
+	bound := latLonBound(cell)
+	if bound.TopRight.Lat < r.BottomLeft.Lat || bound.BottomLeft.Lat > r.TopRight.Lat {
+		return false
+	}
+	for _, shift := range [3]float64{-360, 0, 360} {
+		if bound.BottomLeft.Lon+shift <= r.TopRight.Lon && bound.TopRight.Lon+shift >= r.BottomLeft.Lon {
+			return true
+		}
+	}
+	return false
+}
+
+// ContainsCell reports whether the box holds the whole cell
+func (r Rect) ContainsCell(cell CellID) bool {
+	bound := latLonBound(cell)
+	return bound.BottomLeft.Lat >= r.BottomLeft.Lat && bound.TopRight.Lat <= r.TopRight.Lat &&
+		bound.BottomLeft.Lon >= r.BottomLeft.Lon && bound.TopRight.Lon <= r.TopRight.Lon
+}
+
+// Covering returns at most maxCells cells (DefaultMaxCells when not positive)
+// whose union holds the region, sorted by ID. It starts from the faces and
+// repeatedly splits the largest cell the region does not fully contain,
+// keeping the children the region may intersect, until another split would
+// exceed maxCells. The cells do not overlap.
+func Covering(r Region, maxCells int) []CellID {
+	if maxCells <= 0 {
+		maxCells = DefaultMaxCells
+	}
+	type candidate struct {
+		cell CellID
+		// full is set when the region holds the cell or it is a leaf
+		full bool
+	}
+	var cells []candidate
+	for face := 0; face < 6; face++ {
+		if c := FromFace(face); r.MayIntersect(c) {
+			cells = append(cells, candidate{cell: c, full: r.ContainsCell(c)})
+		}
+	}
+
+	for {
+		split, level := -1, math.MaxInt
+		for i, c := range cells {
+			if l := c.cell.Level(); !c.full && l < level {
+				split, level = i, l
+			}
+		}
+		if split < 0 {
+			break
+		}
+		var children []candidate
+		for _, child := range cells[split].cell.Children() {
+			if r.MayIntersect(child) {
+				children = append(children, candidate{cell: child, full: child.IsLeaf() || r.ContainsCell(child)})
+			}
+		}
+		if len(cells)-1+len(children) > maxCells {
+			break
+		}
+		cells = append(append(cells[:split:split], cells[split+1:]...), children...)
+	}
+
+	covering := make([]CellID, len(cells))
+	for i, c := range cells {
+		covering[i] = c.cell
+	}
+	sort.Slice(covering, func(i, j int) bool { return covering[i] < covering[j] })
+	return covering
+}
@@ -0,0 +1,348 @@
+// Package s2cell implements the cell hierarchy of the S2 geometry library: the
+// sphere is projected onto the six faces of a cube and each face is split into
+// a quadtree 30 levels deep, numbered along a Hilbert curve. A CellID is the
+// same 64-bit value and token as in S2, so cells can be exchanged with other
+// S2 implementations. Unlike latitude/longitude boxes, cells have no seam at
+// the antimeridian and no singularity at the poles.
+package s2cell
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+)
+
+const (
+	// MaxLevel is the level of leaf cells, about 1cm across
+	MaxLevel = 30
+
+	earthRadiusKm = 6371.0
+
+	faceBits = 3
+	posBits  = 2*MaxLevel + 1
+	maxSize  = 1 << MaxLevel
+
+	swapMask   = 1
+	invertMask = 2
+)
+
+// Hilbert curve tables: ijToPos[orientation][i<<1|j] is the position of a
+// child along the curve, posToIJ the inverse, and posToOrientation the change
+// of orientation applied to the children of the child at that position
+var (
+	ijToPos = [4][4]int{
+		{0, 1, 3, 2},
+		{0, 3, 1, 2},
+		{2, 3, 1, 0},
+		{2, 1, 3, 0},
+	}
+	posToIJ = [4][4]int{
+		{0, 1, 3, 2},
+		{0, 2, 3, 1},
+		{3, 2, 0, 1},
+		{3, 1, 0, 2},
+	}
+	posToOrientation = [4]int{swapMask, 0, 0, invertMask | swapMask}
+)
+
+// CellID identifies a cell: 3 bits of face, then 2 bits per level of position
+// along the Hilbert curve, then a single set bit marking the level
+type CellID uint64
+
+// FromLatLon returns the leaf cell containing the coordinates
+func FromLatLon(lat, lon float64) CellID {
+	face, u, v := xyzToFaceUV(latLonToXYZ(lat, lon))
+	return fromFaceIJ(face, stToIJ(uvToST(u)), stToIJ(uvToST(v)))
+}
+
+// FromLocation returns the leaf cell containing loc
+func FromLocation(loc models.Location) CellID {
+	return FromLatLon(loc.Lat, loc.Lon)
+}
+
+// FromFace returns the level 0 cell covering a whole cube face
+func FromFace(face int) CellID {
+	return CellID(uint64(face)<<posBits + lsbForLevel(0))
+}
+
+// FromToken parses a token as produced by Token
+func FromToken(token string) (CellID, error) {
+	if token == "X" {
+		return 0, fmt.Errorf("invalid cell token %q", token)
+	}
+	if len(token) == 0 || len(token) > 16 {
+		return 0, fmt.Errorf("invalid cell token %q: want 1 to 16 hex digits", token)
+	}
+	v, err := strconv.ParseUint(token, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cell token %q: %w", token, err)
+	}
+	c := CellID(v << (4 * (16 - len(token))))
+	if !c.IsValid() {
+		return 0, fmt.Errorf("invalid cell token %q", token)
+	}
+	return c, nil
+}
+
+func lsbForLevel(level int) uint64 {
+	return 1 << (2 * (MaxLevel - level))
+}
+
+func (c CellID) lsb() uint64 {
+	return uint64(c) & -uint64(c)
+}
+
+// IsValid reports whether c names a cell: a face below 6 and a level marker
+// at an even bit position
+func (c CellID) IsValid() bool {
+	return c.Face() < 6 && c.lsb()&0x1555555555555555 != 0
+}
+
+// Face returns the cube face of the cell, 0 to 5
+func (c CellID) Face() int {
+	return int(uint64(c) >> posBits)
+}
+
+// Level returns the level of the cell, 0 for a face and MaxLevel for a leaf
+func (c CellID) Level() int {
+	lsb := c.lsb()
+	level := MaxLevel
+	for lsb > 1 {
+		lsb >>= 2
+		level--
+	}
+	return level
+}
+
+// IsLeaf reports whether c is a cell at MaxLevel
+func (c CellID) IsLeaf() bool {
+	return uint64(c)&1 != 0
+}
+
+// Parent returns the ancestor of c at level, which must not be below c's
+func (c CellID) Parent(level int) CellID {
+	lsb := lsbForLevel(level)
+	return CellID(uint64(c)&-lsb | lsb)
+}
+
+// Children returns the four cells one level below c in Hilbert curve order.
+// c must not be a leaf.
+func (c CellID) Children() [4]CellID {
+	lsb := c.lsb() >> 2
+	child := uint64(c) - c.lsb() + lsb
+	var children [4]CellID
+	for i := range children {
+		children[i] = CellID(child)
+		child += 2 * lsb
+	}
+	return children
+}
+
+// RangeMin and RangeMax return the first and last leaf cells inside c. Leaf
+// IDs inside c, and only those, fall in this range, so sorted leaf IDs can be
+// searched for a cell's contents.
+func (c CellID) RangeMin() CellID {
+	return CellID(uint64(c) - (c.lsb() - 1))
+}
+
+// RangeMax returns the last leaf cell inside c, see RangeMin
+func (c CellID) RangeMax() CellID {
+	return CellID(uint64(c) + (c.lsb() - 1))
+}
+
+// Contains reports whether other is c or one of its descendants
+func (c CellID) Contains(other CellID) bool {
+	return other >= c.RangeMin() && other <= c.RangeMax()
+}
+
+// Token returns the compact hex form of c used by S2, with trailing zeros
+// dropped
+func (c CellID) Token() string {
+	if c == 0 {
+		return "X"
+	}
+	return strings.TrimRight(fmt.Sprintf("%016x", uint64(c)), "0")
+}
+
+// String returns the face and Hilbert positions of c, e.g. "2/0312"
+func (c CellID) String() string {
+	var b strings.Builder
+	b.WriteString(strconv.Itoa(c.Face()))
+	b.WriteByte('/')
+	for level := 1; level <= c.Level(); level++ {
+		b.WriteByte(byte('0' + uint64(c)>>(posBits-2*level)&3))
+	}
+	return b.String()
+}
+
+// Center returns the center of the cell
+func (c CellID) Center() models.Location {
+	return xyzToLocation(c.centerXYZ())
+}
+
+// Vertices returns the corners of the cell counterclockwise from the one with
+// the lowest u and v face coordinates
+func (c CellID) Vertices() [4]models.Location {
+	face, i, j, size := c.faceIJ()
+	u0, u1 := stToUV(ijToST(i)), stToUV(ijToST(i+size))
+	v0, v1 := stToUV(ijToST(j)), stToUV(ijToST(j+size))
+	return [4]models.Location{
+		xyzToLocation(faceUVToXYZ(face, u0, v0)),
+		xyzToLocation(faceUVToXYZ(face, u1, v0)),
+		xyzToLocation(faceUVToXYZ(face, u1, v1)),
+		xyzToLocation(faceUVToXYZ(face, u0, v1)),
+	}
+}
+
+// MinDistanceKm returns a lower bound on the distance from loc to any point of
+// the cell, zero when loc is near or inside it
+func (c CellID) MinDistanceKm(loc models.Location) float64 {
+	center, radius := c.bound()
+	return math.Max(angle(latLonToXYZ(loc.Lat, loc.Lon), center)-radius, 0) * earthRadiusKm
+}
+
+// faceIJ returns the face of c and the i, j coordinates and size of the cell
+// in leaf cells
+func (c CellID) faceIJ() (face, i, j, size int) {
+	face = c.Face()
+	orientation := face & swapMask
+	level := c.Level()
+	for k := 1; k <= level; k++ {
+		pos := int(uint64(c)>>(posBits-2*k)) & 3
+		ij := posToIJ[orientation][pos]
+		i = i<<1 | ij>>1
+		j = j<<1 | ij&1
+		orientation ^= posToOrientation[pos]
+	}
+	shift := MaxLevel - level
+	return face, i << shift, j << shift, 1 << shift
+}
+
+func fromFaceIJ(face, i, j int) CellID {
+	id := uint64(face)
+	orientation := face & swapMask
+	for k := MaxLevel - 1; k >= 0; k-- {
+		ij := (i>>k&1)<<1 | j>>k&1
+		pos := ijToPos[orientation][ij]
+		id = id<<2 | uint64(pos)
+		orientation ^= posToOrientation[pos]
+	}
+	return CellID(id<<1 | 1)
+}
+
+func (c CellID) centerXYZ() [3]float64 {
+	face, i, j, size := c.faceIJ()
+	return normalize(faceUVToXYZ(face, stToUV(ijToST(2*i+size)/2), stToUV(ijToST(2*j+size)/2)))
+}
+
+// bound returns the center of the cell and the angle in radians to its
+// farthest vertex. Cell edges are great circle arcs, so the cap of that
+// radius holds the whole cell.
+func (c CellID) bound() ([3]float64, float64) {
+	center := c.centerXYZ()
+	face, i, j, size := c.faceIJ()
+	var radius float64
+	for _, s := range [2]int{i, i + size} {
+		for _, t := range [2]int{j, j + size} {
+			radius = math.Max(radius, angle(center, normalize(faceUVToXYZ(face, stToUV(ijToST(s)), stToUV(ijToST(t))))))
+		}
+	}
+	// Pad for rounding so the bound stays conservative
+	return center, radius + 1e-12
+}
+
+func latLonToXYZ(lat, lon float64) [3]float64 {
+	phi, lambda := lat*math.Pi/180, lon*math.Pi/180
+	return [3]float64{math.Cos(phi) * math.Cos(lambda), math.Cos(phi) * math.Sin(lambda), math.Sin(phi)}
+}
+
+func xyzToLocation(p [3]float64) models.Location {
+	return models.Location{
+		Lat: math.Atan2(p[2], math.Hypot(p[0], p[1])) * 180 / math.Pi,
+		Lon: math.Atan2(p[1], p[0]) * 180 / math.Pi,
+	}
+}
+
+func normalize(p [3]float64) [3]float64 {
+	n := math.Sqrt(p[0]*p[0] + p[1]*p[1] + p[2]*p[2])
+	return [3]float64{p[0] / n, p[1] / n, p[2] / n}
+}
+
+// angle returns the angle in radians between two unit vectors
+func angle(a, b [3]float64) float64 {
+	cross := [3]float64{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+	return math.Atan2(math.Sqrt(cross[0]*cross[0]+cross[1]*cross[1]+cross[2]*cross[2]), a[0]*b[0]+a[1]*b[1]+a[2]*b[2])
+}
+
+// xyzToFaceUV picks the face whose axis is closest to p and projects p onto it
+func xyzToFaceUV(p [3]float64) (face int, u, v float64) {
+	face = 0
+	if math.Abs(p[1]) > math.Abs(p[face]) {
+		face = 1
+	}
+	if math.Abs(p[2]) > math.Abs(p[face]) {
+		face = 2
+	}
+	if p[face] < 0 {
+		face += 3
+	}
+	x, y, z := p[0], p[1], p[2]
+	switch face {
+	case 0:
+		return face, y / x, z / x
+	case 1:
+		return face, -x / y, z / y
+	case 2:
+		return face, -x / z, -y / z
+	case 3:
+		return face, z / x, y / x
+	case 4:
+		return face, z / y, -x / y
+	default:
+		return face, -y / z, -x / z
+	}
+}
+
+func faceUVToXYZ(face int, u, v float64) [3]float64 {
+	switch face {
+	case 0:
+		return [3]float64{1, u, v}
+	case 1:
+		return [3]float64{-u, 1, v}
+	case 2:
+		return [3]float64{-u, -v, 1}
+	case 3:
+		return [3]float64{-1, -v, -u}
+	case 4:
+		return [3]float64{v, -1, -u}
+	default:
+		return [3]float64{v, u, -1}
+	}
+}
+
+// uvToST applies S2's quadratic transform, which evens out cell areas across
+// a face
+func uvToST(u float64) float64 {
+	if u >= 0 {
+		return 0.5 * math.Sqrt(1+3*u)
+	}
+	return 1 - 0.5*math.Sqrt(1-3*u)
+}
+
+func stToUV(s float64) float64 {
+	if s >= 0.5 {
+		return (4*s*s - 1) / 3
+	}
+	return (1 - 4*(1-s)*(1-s)) / 3
+}
+
+func stToIJ(s float64) int {
+	return min(max(int(math.Floor(maxSize*s)), 0), maxSize-1)
+}
+
+func ijToST(i int) float64 {
+	return float64(i) / maxSize
+}
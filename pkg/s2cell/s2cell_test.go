@@ -0,0 +1,155 @@
+package s2cell
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaces(t *testing.T) {
+	for face, loc := range []models.Location{
+		{Lat: 0, Lon: 0}, {Lat: 0, Lon: 90}, {Lat: 90, Lon: 0},
+		{Lat: 0, Lon: 180}, {Lat: 0, Lon: -90}, {Lat: -90, Lon: 0},
+	} {
+		c := FromLocation(loc)
+		assert.Equal(t, face, c.Face(), loc)
+		assert.True(t, c.IsLeaf())
+		assert.True(t, FromFace(face).Contains(c))
+	}
+	assert.Equal(t, "1", FromFace(0).Token())
+	assert.Equal(t, "b", FromFace(5).Token())
+	assert.Equal(t, [4]string{"04", "0c", "14", "1c"}, tokens(FromFace(0).Children()))
+}
+
+func tokens(cells [4]CellID) [4]string {
+	var out [4]string
+	for i, c := range cells {
+		out[i] = c.Token()
+	}
+	return out
+}
+
+func TestHierarchy(t *testing.T) {
+	leaf := FromLatLon(37.7749, -122.4194)
+	require.True(t, leaf.IsValid())
+	assert.Equal(t, MaxLevel, leaf.Level())
+	for level := 0; level < MaxLevel; level++ {
+		parent := leaf.Parent(level)
+		assert.Equal(t, level, parent.Level())
+		assert.True(t, parent.Contains(leaf))
+		assert.Contains(t, parent.Children(), leaf.Parent(level+1))
+		assert.LessOrEqual(t, parent.RangeMin(), leaf)
+		assert.GreaterOrEqual(t, parent.RangeMax(), leaf)
+	}
+
+	// A cell's center lies inside it and its corners around it
+	cell := leaf.Parent(10)
+	assert.Equal(t, cell, FromLocation(cell.Center()).Parent(10))
+	for _, v := range cell.Vertices() {
+		assert.Less(t, v.DistanceTo(cell.Center()), 15.0)
+	}
+	assert.Zero(t, cell.MinDistanceKm(models.Location{Lat: 37.7749, Lon: -122.4194}))
+	sanDiego := models.Location{Lat: 32.7157, Lon: -117.1611}
+	assert.LessOrEqual(t, cell.MinDistanceKm(sanDiego), cell.Center().DistanceTo(sanDiego))
+	assert.Greater(t, cell.MinDistanceKm(sanDiego), cell.Center().DistanceTo(sanDiego)-15)
+}
+
+func TestHilbertCurve(t *testing.T) {
+	// Consecutive cells along the curve share an edge
+	cells := []CellID{FromFace(1)}
+	for level := 0; level < 4; level++ {
+		var next []CellID
+		for _, c := range cells {
+			children := c.Children()
+			next = append(next, children[:]...)
+		}
+		cells = next
+	}
+	for k := 1; k < len(cells); k++ {
+		_, i0, j0, size := cells[k-1].faceIJ()
+		_, i1, j1, _ := cells[k].faceIJ()
+		assert.Equal(t, size, abs(i1-i0)+abs(j1-j0), cells[k].String())
+	}
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func TestToken(t *testing.T) {
+	c := FromLatLon(-33.8688, 151.2093).Parent(12)
+	parsed, err := FromToken(c.Token())
+	require.NoError(t, err)
+	assert.Equal(t, c, parsed)
+	assert.Equal(t, "X", CellID(0).Token())
+
+	for _, token := range []string{"", "X", "zz", "0", "1234567890abcdef0", "c"} {
+		_, err := FromToken(token)
+		assert.Error(t, err, token)
+	}
+}
+
+func TestCovering(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, region := range []struct {
+		name   string
+		region Region
+	}{
+		{"cap", Cap{Center: models.Location{Lat: 48.85, Lon: 2.35}, RadiusKm: 300}},
+		{"antimeridian cap", Cap{Center: models.Location{Lat: 10, Lon: 179.9}, RadiusKm: 500}},
+		{"polar cap", Cap{Center: models.Location{Lat: 89.5, Lon: 0}, RadiusKm: 200}},
+		{"rect", Rect{BottomLeft: models.Location{Lat: -10, Lon: 20}, TopRight: models.Location{Lat: 5, Lon: 40}}},
+	} {
+		t.Run(region.name, func(t *testing.T) {
+			covering := Covering(region.region, 8)
+			require.NotEmpty(t, covering)
+			assert.LessOrEqual(t, len(covering), 8)
+			for k := 1; k < len(covering); k++ {
+				assert.Greater(t, covering[k].RangeMin(), covering[k-1].RangeMax())
+			}
+
+			// Every point of the region falls in a covering cell
+			inside := func(loc models.Location) bool {
+				switch r := region.region.(type) {
+				case Cap:
+					return r.Center.DistanceTo(loc) <= r.RadiusKm
+				case Rect:
+					return loc.Lat >= r.BottomLeft.Lat && loc.Lat <= r.TopRight.Lat &&
+						loc.Lon >= r.BottomLeft.Lon && loc.Lon <= r.TopRight.Lon
+				}
+				return false
+			}
+			found := 0
+			for i := 0; i < 5000; i++ {
+				// Sample around the region so enough points land inside
+				var loc models.Location
+				switch r := region.region.(type) {
+				case Cap:
+					loc = r.Center.Destination(rng.Float64()*360, rng.Float64()*r.RadiusKm*1.5)
+				case Rect:
+					loc = models.Location{Lat: rng.Float64()*20 - 12, Lon: rng.Float64()*30 + 15}
+				}
+				if !inside(loc) {
+					continue
+				}
+				found++
+				leaf := FromLocation(loc)
+				covered := false
+				for _, c := range covering {
+					covered = covered || c.Contains(leaf)
+				}
+				assert.True(t, covered, "%v", loc)
+			}
+			assert.Greater(t, found, 100)
+		})
+	}
+
+	// The whole sphere is the six faces
+	assert.Len(t, Covering(Cap{RadiusKm: 30000}, 0), 6)
+}
@@ -0,0 +1,556 @@
+// Package server exposes a GeoIndex over HTTP with JSON query endpoints,
+// optional bearer-token auth, Prometheus-style metrics and periodic snapshots
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/1F47E/geo-index-rtree/pkg/heatmap"
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/1F47E/geo-index-rtree/pkg/rtree"
+)
+
+// Config controls the HTTP server behaviour
+type Config struct {
+	// Addr is the listen address, e.g. ":8080"
+	Addr string
+	// AuthToken, when set, is required as "Authorization: Bearer <token>" on every endpoint except /health
+	AuthToken string
+	// EnableMetrics exposes request counters and latencies at /metrics
+	EnableMetrics bool
+	// EnableUI serves a map page at /ui for drawing box and radius queries
+	EnableUI bool
+	// IndexMetrics, when set, are appended to /metrics; pass them to the
+	// index with rtree.WithMetrics to add query counts and latencies
+	IndexMetrics *rtree.PrometheusMetrics
+	// SnapshotFile is where the index is saved on snapshot
+	SnapshotFile string
+	// SnapshotInterval enables periodic snapshots of a modified index (0 disables)
+	SnapshotInterval time.Duration
+	// Logger receives structured logs of startup, shutdown, snapshots and
+	// failed requests; nothing is logged when nil
+	Logger *slog.Logger
+}
+
+// queryMetrics accumulates per-endpoint counters
+type queryMetrics struct {
+	requests  atomic.Int64
+	errors    atomic.Int64
+	latencyNs atomic.Int64
+}
+
+// Server serves queries against a GeoIndex
+type Server struct {
+	index  *rtree.GeoIndex
+	config Config
+	mux    *http.ServeMux
+
+	log       *slog.Logger
+	metrics   map[string]*queryMetrics
+	dirty     atomic.Bool
+	snapshots atomic.Int64
+	snapMu    sync.Mutex
+}
+
+// New creates a server for the given index
+func New(index *rtree.GeoIndex, config Config) *Server {
+	s := &Server{
+		index:   index,
+		config:  config,
+		mux:     http.NewServeMux(),
+		log:     config.Logger,
+		metrics: make(map[string]*queryMetrics),
+	}
+	if s.log == nil {
+		s.log = slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError + 1}))
+	}
+
+	s.handle("/query/box", "box", s.handleBox)
+	s.handle("/query/radius", "radius", s.handleRadius)
+	s.handle("/query/nearest", "nearest", s.handleNearest)
+	s.handle("/points", "insert", s.handlePoints)
+	s.handle("/stats", "stats", s.handleStats)
+	s.handle("/heatmap", "heatmap", s.handleHeatmap)
+	s.mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	if config.EnableMetrics {
+		s.mux.Handle("/metrics", s.authorize(http.HandlerFunc(s.handleMetrics)))
+	}
+	if config.EnableUI {
+		s.mux.HandleFunc("/ui", s.handleUI)
+	}
+
+	return s
+}
+
+// Handler returns the HTTP handler serving all endpoints
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// ListenAndServe serves until ctx is cancelled, then shuts down gracefully
+// and writes a final snapshot if the index changed since the last one
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	httpServer := &http.Server{
+		Addr:              s.config.Addr,
+		Handler:           s.mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	if s.config.SnapshotInterval > 0 && s.config.SnapshotFile != "" {
+		go s.snapshotLoop(ctx)
+	}
+
+	s.log.Info("server listening", "addr", s.config.Addr, "points", s.index.Count())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to shut down server: %w", err)
+	}
+	s.log.Info("server stopped")
+
+	if s.config.SnapshotFile != "" && s.dirty.Load() {
+		return s.Snapshot()
+	}
+	return nil
+}
+
+// Snapshot saves the index to the snapshot file atomically (write + rename)
+func (s *Server) Snapshot() error {
+	s.snapMu.Lock()
+	defer s.snapMu.Unlock()
+
+	s.dirty.Store(false)
+	tmp := s.config.SnapshotFile + ".tmp"
+	start := time.Now()
+	if err := s.index.SaveToFile(tmp); err != nil {
+		s.dirty.Store(true)
+		s.log.Error("snapshot failed", "file", s.config.SnapshotFile, "error", err)
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, s.config.SnapshotFile); err != nil {
+		s.dirty.Store(true)
+		s.log.Error("snapshot failed", "file", s.config.SnapshotFile, "error", err)
+		return fmt.Errorf("failed to replace snapshot: %w", err)
+	}
+	s.snapshots.Add(1)
+	s.log.Info("snapshot saved", "file", s.config.SnapshotFile, "points", s.index.Count(), "duration", time.Since(start))
+	return nil
+}
+
+func (s *Server) snapshotLoop(ctx context.Context) {
+	ticker := time.NewTicker(s.config.SnapshotInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if s.dirty.Load() {
+				_ = s.Snapshot()
+			}
+		}
+	}
+}
+
+// handle registers an authorized, instrumented endpoint
+func (s *Server) handle(pattern, name string, h func(w http.ResponseWriter, r *http.Request) error) {
+	m := &queryMetrics{}
+	s.metrics[name] = m
+
+	s.mux.Handle(pattern, s.authorize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		err := h(w, r)
+		m.requests.Add(1)
+		m.latencyNs.Add(int64(time.Since(start)))
+		if err != nil {
+			m.errors.Add(1)
+			s.log.Debug("request failed", "endpoint", name, "error", err)
+			writeError(w, err)
+		}
+	})))
+}
+
+func (s *Server) authorize(next http.Handler) http.Handler {
+	if s.config.AuthToken == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		want := "Bearer " + s.config.AuthToken
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(want)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// queryResponse is the JSON body returned by query endpoints
+type queryResponse struct {
+	Count  int             `json:"count"`
+	TookUs int64           `json:"took_us"`
+	Points []*models.Point `json:"points"`
+}
+
+func (s *Server) handleBox(w http.ResponseWriter, r *http.Request) error {
+	v, err := floatParams(r, "min_lat", "min_lon", "max_lat", "max_lon")
+	if err != nil {
+		return err
+	}
+	box := models.BoundingBox{
+		BottomLeft: models.Location{Lat: v[0], Lon: v[1]},
+		TopRight:   models.Location{Lat: v[2], Lon: v[3]},
+	}.Normalize()
+	if err := box.Validate(); err != nil {
+		return badRequest("invalid box: %v", err)
+	}
+
+	start := time.Now()
+	points, err := s.index.QueryBox(box)
+	if err != nil {
+		return err
+	}
+	writeQueryResponse(w, r, points, time.Since(start))
+	return nil
+}
+
+// handleRadius takes the radius as radius_km, or as radius in the given unit
+func (s *Server) handleRadius(w http.ResponseWriter, r *http.Request) error {
+	center, err := centerParam(r)
+	if err != nil {
+		return err
+	}
+	radiusKm, err := radiusParam(r)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	points, err := s.index.QueryRadius(center, s.index.DistanceUnit().FromKm(radiusKm))
+	if err != nil {
+		return err
+	}
+	writeQueryResponse(w, r, points, time.Since(start))
+	return nil
+}
+
+// maxNearest caps k of nearest neighbor queries
+const maxNearest = 10000
+
+func (s *Server) handleNearest(w http.ResponseWriter, r *http.Request) error {
+	center, err := centerParam(r)
+	if err != nil {
+		return err
+	}
+	k := 10
+	if raw := r.URL.Query().Get("k"); raw != "" {
+		if k, err = strconv.Atoi(raw); err != nil || k <= 0 {
+			return badRequest("invalid k %q", raw)
+		}
+		if k > maxNearest {
+			return badRequest("k must be at most %d, got %d", maxNearest, k)
+		}
+	}
+
+	start := time.Now()
+	points := s.index.NearestNeighbors(center, k)
+	writeQueryResponse(w, r, points, time.Since(start))
+	return nil
+}
+
+// maxPointsBody caps the size of a POST /points body
+const maxPointsBody = 64 << 20
+
+// handlePoints indexes a JSON array of points posted to the server
+func (s *Server) handlePoints(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return &httpError{status: http.StatusMethodNotAllowed, msg: "use POST"}
+	}
+
+	var points []*models.Point
+	body := http.MaxBytesReader(w, r.Body, maxPointsBody)
+	if err := json.NewDecoder(body).Decode(&points); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return &httpError{status: http.StatusRequestEntityTooLarge, msg: fmt.Sprintf("points payload exceeds %d bytes", tooLarge.Limit)}
+		}
+		return badRequest("invalid points payload: %v", err)
+	}
+	// Points without a location are skipped by IndexPoints, so they are not
+	// reported as indexed
+	indexed := 0
+	for i, point := range points {
+		if point == nil {
+			return badRequest("invalid points payload: element %d is null", i)
+		}
+		if point.Location != nil {
+			indexed++
+		}
+	}
+	if err := s.index.IndexPoints(points); err != nil {
+		return err
+	}
+	s.dirty.Store(true)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"indexed": indexed,
+		"total":   s.index.Count(),
+	})
+	return nil
+}
+
+// maxHeatmapSize caps the width and height of rendered heatmaps
+const maxHeatmapSize = 4096
+
+// handleHeatmap renders a PNG density heatmap, either of web map tile z/x/y
+// or of a box at width x height pixels, both in Web Mercator. Optional
+// parameters are ramp (a ramp name or hex colors), scale=linear|log and max,
+// the count given the last ramp color.
+func (s *Server) handleHeatmap(w http.ResponseWriter, r *http.Request) error {
+	q := r.URL.Query()
+	width, height := heatmap.TileSize, heatmap.TileSize
+	var box models.BoundingBox
+	if q.Get("z") != "" {
+		v, err := intParams(r, "z", "x", "y")
+		if err != nil {
+			return err
+		}
+		if box, err = heatmap.TileBox(v[0], v[1], v[2]); err != nil {
+			return badRequest("%v", err)
+		}
+	} else {
+		v, err := floatParams(r, "min_lat", "min_lon", "max_lat", "max_lon")
+		if err != nil {
+			return err
+		}
+		box = models.BoundingBox{
+			BottomLeft: models.Location{Lat: v[0], Lon: v[1]},
+			TopRight:   models.Location{Lat: v[2], Lon: v[3]},
+		}.Normalize()
+		if err := box.Validate(); err != nil {
+			return badRequest("invalid box: %v", err)
+		}
+		if q.Get("width") != "" || q.Get("height") != "" {
+			size, err := intParams(r, "width", "height")
+			if err != nil {
+				return err
+			}
+			width, height = size[0], size[1]
+		}
+	}
+	if width <= 0 || height <= 0 || width > maxHeatmapSize || height > maxHeatmapSize {
+		return badRequest("image size must be between 1 and %d pixels, got %dx%d", maxHeatmapSize, width, height)
+	}
+
+	var opts heatmap.Options
+	if raw := q.Get("ramp"); raw != "" {
+		ramp, err := heatmap.ParseRamp(raw)
+		if err != nil {
+			return badRequest("%v", err)
+		}
+		opts.Ramp = ramp
+	}
+	switch q.Get("scale") {
+	case "", "log":
+	case "linear":
+		opts.Linear = true
+	default:
+		return badRequest("invalid scale %q: use linear or log", q.Get("scale"))
+	}
+	if raw := q.Get("max"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n < 0 {
+			return badRequest("invalid max %q", raw)
+		}
+		opts.Max = n
+	}
+
+	grid, err := s.index.DensityGrid(box, height, width, rtree.WebMercator)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "image/png")
+	return heatmap.Encode(w, grid, opts)
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) error {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"points":    s.index.Count(),
+		"snapshots": s.snapshots.Load(),
+	})
+	return nil
+}
+
+// handleMetrics writes counters in the Prometheus text exposition format
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# TYPE geoindex_points gauge")
+	fmt.Fprintf(w, "geoindex_points %d\n", s.index.Count())
+	fmt.Fprintln(w, "# TYPE geoindex_requests_total counter")
+	for name, m := range s.metrics {
+		fmt.Fprintf(w, "geoindex_requests_total{endpoint=%q} %d\n", name, m.requests.Load())
+	}
+	fmt.Fprintln(w, "# TYPE geoindex_request_errors_total counter")
+	for name, m := range s.metrics {
+		fmt.Fprintf(w, "geoindex_request_errors_total{endpoint=%q} %d\n", name, m.errors.Load())
+	}
+	fmt.Fprintln(w, "# TYPE geoindex_request_seconds_total counter")
+	for name, m := range s.metrics {
+		fmt.Fprintf(w, "geoindex_request_seconds_total{endpoint=%q} %g\n", name, time.Duration(m.latencyNs.Load()).Seconds())
+	}
+	fmt.Fprintln(w, "# TYPE geoindex_snapshots_total counter")
+	fmt.Fprintf(w, "geoindex_snapshots_total %d\n", s.snapshots.Load())
+	if s.config.IndexMetrics != nil {
+		_, _ = s.config.IndexMetrics.WriteTo(w)
+	}
+}
+
+// httpError carries an HTTP status alongside the message
+type httpError struct {
+	status int
+	msg    string
+}
+
+func (e *httpError) Error() string {
+	return e.msg
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &httpError{status: http.StatusBadRequest, msg: fmt.Sprintf(format, args...)}
+}
+
+// floatParams parses the named required float query parameters
+func floatParams(r *http.Request, names ...string) ([]float64, error) {
+	values := make([]float64, len(names))
+	for i, name := range names {
+		raw := r.URL.Query().Get(name)
+		if raw == "" {
+			return nil, badRequest("missing parameter %q", name)
+		}
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, badRequest("invalid parameter %q: %v", name, raw)
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// centerParam parses the lat and lon query parameters and the optional alt
+// in meters, which counts in indexes built WithAltitude
+func centerParam(r *http.Request) (models.Location, error) {
+	v, err := floatParams(r, "lat", "lon")
+	if err != nil {
+		return models.Location{}, err
+	}
+	center := models.Location{Lat: v[0], Lon: v[1]}
+	if raw := r.URL.Query().Get("alt"); raw != "" {
+		if center.Alt, err = strconv.ParseFloat(raw, 64); err != nil {
+			return center, badRequest("invalid parameter %q: %v", "alt", raw)
+		}
+	}
+	return center, nil
+}
+
+// intParams parses the named required integer query parameters
+func intParams(r *http.Request, names ...string) ([]int, error) {
+	values := make([]int, len(names))
+	for i, name := range names {
+		raw := r.URL.Query().Get(name)
+		if raw == "" {
+			return nil, badRequest("missing parameter %q", name)
+		}
+		v, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, badRequest("invalid parameter %q: %v", name, raw)
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// radiusParam returns the radius_km parameter, or the radius parameter
+// converted from unit when unit is given
+func radiusParam(r *http.Request) (float64, error) {
+	raw := r.URL.Query().Get("unit")
+	if raw == "" {
+		v, err := floatParams(r, "radius_km")
+		if err != nil {
+			return 0, err
+		}
+		return v[0], nil
+	}
+
+	unit, err := models.ParseUnit(raw)
+	if err != nil {
+		return 0, badRequest("%v", err)
+	}
+	v, err := floatParams(r, "radius")
+	if err != nil {
+		return 0, err
+	}
+	return unit.ToKm(v[0]), nil
+}
+
+// writeQueryResponse writes the query results, as a GeoJSON FeatureCollection
+// when the request has format=geojson
+func writeQueryResponse(w http.ResponseWriter, r *http.Request, points []*models.Point, took time.Duration) {
+	if points == nil {
+		points = []*models.Point{}
+	}
+	if r.URL.Query().Get("format") == "geojson" {
+		w.Header().Set("Content-Type", "application/geo+json")
+		w.Header().Set("X-Took-Us", strconv.FormatInt(took.Microseconds(), 10))
+		_ = json.NewEncoder(w).Encode(models.NewGeoJSONFeatureCollection(points))
+		return
+	}
+	writeJSON(w, http.StatusOK, queryResponse{
+		Count:  len(points),
+		TookUs: took.Microseconds(),
+		Points: points,
+	})
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var httpErr *httpError
+	switch {
+	case errors.As(err, &httpErr):
+		status = httpErr.status
+	case errors.Is(err, rtree.ErrInvalidQuery):
+		status = http.StatusBadRequest
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/1F47E/geo-index-rtree/pkg/models"
+	"github.com/1F47E/geo-index-rtree/pkg/rtree"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestServer(t *testing.T, config Config) *Server {
+	index := rtree.NewGeoIndex()
+	points := []*models.Point{
+		{ID: "SF", Location: &models.Location{Lat: 37.7749, Lon: -122.4194}},
+		{ID: "Oakland", Location: &models.Location{Lat: 37.8044, Lon: -122.2712}},
+		{ID: "LA", Location: &models.Location{Lat: 34.0522, Lon: -118.2437}},
+	}
+	require.NoError(t, index.IndexPoints(points))
+	return New(index, config)
+}
+
+func get(t *testing.T, s *Server, url string, header map[string]string) (*httptest.ResponseRecorder, queryResponse) {
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+
+	var resp queryResponse
+	if rec.Code == http.StatusOK {
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	}
+	return rec, resp
+}
+
+func TestQueryEndpoints(t *testing.T) {
+	s := newTestServer(t, Config{})
+
+	rec, resp := get(t, s, "/query/box?min_lat=37&min_lon=-123&max_lat=38&max_lon=-122", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 2, resp.Count)
+
+	rec, resp = get(t, s, "/query/radius?lat=37.7749&lon=-122.4194&radius_km=20", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 2, resp.Count)
+
+	rec, resp = get(t, s, "/query/nearest?lat=34&lon=-118&k=1", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	require.Len(t, resp.Points, 1)
+	assert.Equal(t, "LA", resp.Points[0].ID)
+
+	// Swapped corners are accepted, out of range ones are not
+	rec, resp = get(t, s, "/query/box?min_lat=38&min_lon=-122&max_lat=37&max_lon=-123", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 2, resp.Count)
+
+	rec, _ = get(t, s, "/query/box?min_lat=abc", nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec, _ = get(t, s, "/query/box?min_lat=37&min_lon=-123&max_lat=91&max_lon=-122", nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec, _ = get(t, s, "/query/radius?lat=37.7749&lon=-122.4194&radius_km=-5", nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Oakland is ~13km (~8mi) from SF
+	rec, resp = get(t, s, "/query/radius?lat=37.7749&lon=-122.4194&radius=10&unit=mi", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 2, resp.Count)
+	rec, resp = get(t, s, "/query/radius?lat=37.7749&lon=-122.4194&radius=5000&unit=m", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 1, resp.Count)
+	rec, _ = get(t, s, "/query/radius?lat=37.7749&lon=-122.4194&radius=10&unit=furlong", nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec, _ = get(t, s, "/query/nearest?lat=34&lon=-118&alt=high", nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	// Huge k is refused rather than sized into the search
+	rec, _ = get(t, s, "/query/nearest?lat=0&lon=0&k=4611686018427387904", nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	// The altitude is ignored by an index not built for it
+	rec, resp = get(t, s, "/query/radius?lat=37.7749&lon=-122.4194&alt=9000&radius_km=1", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 1, resp.Count)
+}
+
+func TestGeoJSONResponse(t *testing.T) {
+	s := newTestServer(t, Config{})
+
+	rec, _ := get(t, s, "/query/nearest?lat=34&lon=-118&k=1&format=geojson", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/geo+json", rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"type":"FeatureCollection","features":[
+		{"type":"Feature","id":"LA","geometry":{"type":"Point","coordinates":[-118.2437,34.0522]},"properties":{}}
+	]}`, rec.Body.String())
+}
+
+func TestAuth(t *testing.T) {
+	s := newTestServer(t, Config{AuthToken: "secret", EnableMetrics: true})
+
+	rec, _ := get(t, s, "/query/nearest?lat=34&lon=-118", nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec, _ = get(t, s, "/query/nearest?lat=34&lon=-118", map[string]string{"Authorization": "Bearer secret"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec, _ = get(t, s, "/query/nearest?lat=34&lon=-118", map[string]string{"Authorization": "Bearer secre"})
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec, _ = get(t, s, "/health", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestUI(t *testing.T) {
+	rec, _ := get(t, newTestServer(t, Config{}), "/ui", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	s := newTestServer(t, Config{AuthToken: "secret", EnableUI: true})
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ui", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), "query/box?")
+	assert.Contains(t, rec.Body.String(), "query/radius?")
+}
+
+func TestInsertAndMetrics(t *testing.T) {
+	s := newTestServer(t, Config{EnableMetrics: true})
+
+	body := `[{"id":"NYC","location":{"lat":40.7128,"lon":-74.006}}]`
+	req := httptest.NewRequest(http.MethodPost, "/points", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, int64(4), s.index.Count())
+	assert.True(t, s.dirty.Load())
+
+	big := "[" + strings.Repeat(" ", maxPointsBody) + "]"
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/points", strings.NewReader(big)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	assert.Contains(t, rec.Body.String(), `geoindex_requests_total{endpoint="insert"} 2`)
+	assert.Contains(t, rec.Body.String(), "geoindex_points 4")
+}
+
+func TestInsertRejectsNullPoints(t *testing.T) {
+	s := newTestServer(t, Config{})
+
+	for _, body := range []string{`[null]`, `[{"id":"a"},null]`} {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/points", strings.NewReader(body)))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+		assert.Contains(t, rec.Body.String(), "null", body)
+	}
+	assert.Equal(t, int64(3), s.index.Count())
+	assert.False(t, s.dirty.Load())
+}
+
+func TestInsertCountsOnlyLocatedPoints(t *testing.T) {
+	s := newTestServer(t, Config{})
+
+	body := `[{"id":"NYC","location":{"lat":40.7128,"lon":-74.006}},{"id":"nowhere"}]`
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/points", strings.NewReader(body)))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resp struct {
+		Indexed int   `json:"indexed"`
+		Total   int64 `json:"total"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, 1, resp.Indexed)
+	assert.Equal(t, int64(4), resp.Total)
+	assert.Equal(t, int64(4), s.index.Count())
+}
+
+func TestIndexMetrics(t *testing.T) {
+	metrics := rtree.NewPrometheusMetrics("geoindex_index")
+	index := rtree.NewGeoIndex(rtree.WithMetrics(metrics))
+	require.NoError(t, index.IndexPoints([]*models.Point{
+		{ID: "SF", Location: &models.Location{Lat: 37.7749, Lon: -122.4194}},
+	}))
+	s := New(index, Config{EnableMetrics: true, IndexMetrics: metrics})
+
+	rec, resp := get(t, s, "/query/radius?lat=37.77&lon=-122.42&radius_km=5", nil)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 1, resp.Count)
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, rec.Body.String(), `geoindex_requests_total{endpoint="radius"} 1`)
+	assert.Contains(t, rec.Body.String(), `geoindex_index_queries_total{type="radius"} 1`)
+	assert.Contains(t, rec.Body.String(), `geoindex_index_query_duration_seconds_count{type="radius"} 1`)
+	assert.Contains(t, rec.Body.String(), "geoindex_index_points 1")
+}
+
+func TestHeatmap(t *testing.T) {
+	s := newTestServer(t, Config{})
+
+	render := func(url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		return rec
+	}
+
+	rec := render("/heatmap?z=0&x=0&y=0&ramp=viridis")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "image/png", rec.Header().Get("Content-Type"))
+	img, err := png.Decode(rec.Body)
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 256, 256), img.Bounds())
+
+	rec = render("/heatmap?min_lat=30&min_lon=-125&max_lat=40&max_lon=-115&width=64&height=32&scale=linear")
+	require.Equal(t, http.StatusOK, rec.Code)
+	img, err = png.Decode(rec.Body)
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 64, 32), img.Bounds())
+
+	for _, url := range []string{
+		"/heatmap?z=1&x=2&y=0",
+		"/heatmap?z=1&x=0",
+		"/heatmap?min_lat=30&min_lon=-125&max_lat=40&max_lon=-115&width=0&height=32",
+		"/heatmap?min_lat=30&min_lon=-125&max_lat=40&max_lon=-115&width=5000&height=32",
+		"/heatmap?z=0&x=0&y=0&ramp=rainbow",
+		"/heatmap?z=0&x=0&y=0&scale=cubic",
+	} {
+		assert.Equal(t, http.StatusBadRequest, render(url).Code, url)
+	}
+}
+
+func TestSnapshotLogging(t *testing.T) {
+	var logs bytes.Buffer
+	path := filepath.Join(t.TempDir(), "snapshot.gob")
+	s := newTestServer(t, Config{
+		SnapshotFile: path,
+		Logger:       slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	})
+
+	require.NoError(t, s.Snapshot())
+	assert.Contains(t, logs.String(), `"msg":"snapshot saved"`)
+	assert.Contains(t, logs.String(), `"points":3`)
+
+	rec, _ := get(t, s, "/query/radius?lat=100&lon=0&radius_km=1", nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, logs.String(), `"msg":"request failed","endpoint":"radius"`)
+
+	s.config.SnapshotFile = filepath.Join(path, "missing", "snapshot.gob")
+	assert.Error(t, s.Snapshot())
+	assert.Contains(t, logs.String(), `"msg":"snapshot failed"`)
+}
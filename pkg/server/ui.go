@@ -0,0 +1,20 @@
+package server
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// uiPage is a Leaflet map that queries the server's own endpoints. Leaflet,
+// Leaflet.draw and the OpenStreetMap tiles load from their CDNs, so the
+// browser needs internet access but the server does not.
+//
+//go:embed ui.html
+var uiPage []byte
+
+// handleUI serves the map page. The page itself holds no data, so it is
+// served without auth; the queries it makes send the token typed into it.
+func (s *Server) handleUI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(uiPage)
+}